@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/vaurdan/sprite-bootstrap/internal/fakeapi"
+	"github.com/vaurdan/sprite-bootstrap/internal/sshserver"
+	"github.com/vaurdan/sprite-bootstrap/internal/tools"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	fakeListen string
+	fakeRoot   string
+	fakeToken  string
+)
+
+var devCmd = &cobra.Command{
+	Use:    "dev",
+	Short:  "Tools for developing sprite-bootstrap",
+	Hidden: true,
+}
+
+var fakeAPICmd = &cobra.Command{
+	Use:   "fake-api",
+	Short: "Run a fake sprites API on this machine",
+	Long: `Run a fake sprites API that treats each sprite as a sandbox directory
+under --root, so zed, vscode and serve can be tried without a sprites
+account.
+
+Commands run locally with the sandbox as their home, and /home/sprite is
+rewritten to point into it. Port forwards reach ports on this machine. This
+is not isolation: commands run as you.
+
+Point the CLI at it through the environment it prints, after stopping any
+running server so that a new one picks it up:
+
+  sprite-bootstrap stop
+  export SPRITES_API=http://127.0.0.1:8765 SPRITES_TOKEN=<token>
+  sprite-bootstrap zed -s demo`,
+	RunE: runFakeAPI,
+}
+
+func init() {
+	fakeAPICmd.Flags().StringVar(&fakeListen, "listen", "127.0.0.1:8765", "Address to listen on")
+	fakeAPICmd.Flags().StringVar(&fakeRoot, "root", "", "Directory for sprite sandboxes (default: a temporary directory)")
+	fakeAPICmd.Flags().StringVar(&fakeToken, "token", "", "Token clients must send (default: random)")
+	devCmd.AddCommand(fakeAPICmd)
+	rootCmd.AddCommand(devCmd)
+}
+
+func runFakeAPI(cmd *cobra.Command, args []string) error {
+	root := fakeRoot
+	if root == "" {
+		dir, err := os.MkdirTemp("", "sprite-fake-api-")
+		if err != nil {
+			return fmt.Errorf("create sandbox root: %w", err)
+		}
+		root = dir
+	}
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return err
+	}
+
+	token := fakeToken
+	if token == "" {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			return err
+		}
+		token = hex.EncodeToString(b)
+	}
+
+	ln, err := net.Listen("tcp", fakeListen)
+	if err != nil {
+		return fmt.Errorf("listen on %s: %w", fakeListen, err)
+	}
+
+	fmt.Printf("%s✓%s Fake sprites API on http://%s, sandboxes in %s\n\n",
+		tools.ColorGreen, tools.ColorReset, ln.Addr(), root)
+	fmt.Printf("  export %s=http://%s %s=%s\n\n", sshserver.EnvAPI, ln.Addr(), sshserver.EnvToken, token)
+	fmt.Printf("%s⚠%s Commands run on this machine as you; stop any running server first.\n",
+		tools.ColorYellow, tools.ColorReset)
+
+	srv := &http.Server{Handler: &fakeapi.Server{Root: root, Token: token}}
+	if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
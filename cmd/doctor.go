@@ -0,0 +1,318 @@
+package cmd
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/vaurdan/sprite-bootstrap/internal/config"
+	"github.com/vaurdan/sprite-bootstrap/internal/sshconfig"
+	"github.com/vaurdan/sprite-bootstrap/internal/sshdir"
+	"github.com/vaurdan/sprite-bootstrap/internal/sshserver"
+	"github.com/vaurdan/sprite-bootstrap/internal/tools"
+
+	"github.com/spf13/cobra"
+	"github.com/superfly/sprites-go"
+)
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Check the local environment for common problems",
+	Long: `Check the local environment for problems that stop SSH-based remote
+development from working, such as a missing ~/.ssh directory or permissions
+OpenSSH refuses to accept.
+
+With --network and a sprite (-s), also echo WebSocket messages of increasing
+size through the sprite's proxy endpoint to find the largest that survives
+the path. Port forwards that stall on large transfers over a VPN usually
+point to a path MTU problem, worked around with serve --max-frame-size.
+
+The sprites set up from this machine are looked up by ID, to spot ones
+renamed, replaced or deleted since.`,
+	RunE: runDoctor,
+}
+
+var doctorNetwork bool
+
+func init() {
+	doctorCmd.Flags().BoolVar(&doctorNetwork, "network", false, "Probe the sprite proxy for message size problems (needs -s)")
+	rootCmd.AddCommand(doctorCmd)
+}
+
+func runDoctor(cmd *cobra.Command, args []string) error {
+	fmt.Println("Environment Check")
+	fmt.Println("─────────────────────────────────────")
+
+	problems := 0
+
+	report, err := sshdir.Check(false)
+	if err != nil {
+		return err
+	}
+	if report.OK() {
+		fmt.Printf("SSH dir:     ✓ %s\n", report.Dir)
+	} else {
+		fmt.Printf("SSH dir:     ✗ %s\n", report.Dir)
+		for _, p := range report.Problems {
+			fmt.Printf("  - %s\n", p)
+		}
+		problems += len(report.Problems)
+	}
+	for _, w := range report.Warnings {
+		fmt.Printf("  ⚠ %s\n", w)
+	}
+
+	checkSSHClient()
+	checkStateLayout()
+	problems += checkServeEnv()
+	problems += checkPolicy()
+	problems += checkInventory()
+
+	if doctorNetwork {
+		n, err := checkNetwork()
+		if err != nil {
+			return err
+		}
+		problems += n
+	}
+
+	if problems > 0 {
+		return fmt.Errorf("found %d problem(s)", problems)
+	}
+	return nil
+}
+
+// checkSSHClient shows the ssh client version the SSH config is written for
+func checkSSHClient() {
+	v := sshconfig.ClientVersion()
+	switch {
+	case v.Unknown():
+		fmt.Println("SSH client:  - version unknown (assuming current OpenSSH)")
+	case !v.AtLeast(7, 8):
+		fmt.Printf("SSH client:  ⚠ OpenSSH %s (SetEnv unsupported; SSH config entries leave it out)\n", v)
+	default:
+		fmt.Printf("SSH client:  ✓ OpenSSH %s\n", v)
+	}
+}
+
+// checkStateLayout reports where state and runtime files live, which
+// differs when the state directory is on a network filesystem
+func checkStateLayout() {
+	l := config.CurrentLayout()
+	switch {
+	case l.Err != nil && !l.Filesystem.Network:
+		fmt.Printf("State dir:   - %s (filesystem unknown: %v)\n", l.StateDir, l.Err)
+	case l.Filesystem.Network:
+		fmt.Printf("State dir:   ⚠ %s is on a network filesystem (%s)\n", l.StateDir, l.Filesystem.Type)
+	default:
+		fmt.Printf("State dir:   ✓ %s\n", l.StateDir)
+	}
+
+	switch {
+	case l.Relocated:
+		fmt.Printf("Runtime dir: ✓ %s (machine-local)\n", l.RuntimeDir)
+	case l.Filesystem.Network:
+		fmt.Printf("Runtime dir: ⚠ %s (namespaced by machine; no local directory: %v)\n", l.RuntimeDir, l.Err)
+	}
+}
+
+// checkPolicy shows the organization policy in force, if any
+func checkPolicy() int {
+	// The policy is resolved with the credentials, but is shown even when
+	// they can't be
+	tokenOpts := &sshserver.TokenOptions{Organization: orgName}
+	_ = tokenOpts.Resolve()
+	policy, err := config.LoadPolicy(tokenOpts.Organization)
+	if err != nil {
+		fmt.Printf("Policy:      ✗ %v\n", err)
+		return 1
+	}
+	if policy == nil {
+		fmt.Println("Policy:      - none")
+		return 0
+	}
+
+	fmt.Printf("Policy:      ✓ %s (%s)\n", policy.Name, policy.Source)
+	for _, rule := range policy.Rules() {
+		fmt.Printf("  - %s\n", rule)
+	}
+	return 0
+}
+
+// checkInventory compares the sprites set up from this machine with the
+// API by ID, to find renamed, replaced and deleted sprites. Renames are
+// fixed by the next setup under the new name; the rest are problems.
+func checkInventory() int {
+	inv, err := config.LoadInventory()
+	if err != nil {
+		fmt.Printf("Sprites:     ✗ %v\n", err)
+		return 1
+	}
+	if len(inv.Sprites) == 0 {
+		fmt.Println("Sprites:     - none set up yet")
+		return 0
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// Sprite names by ID and IDs by name, per organization
+	type orgSprites struct {
+		names map[string]string
+		ids   map[string]string
+		err   error
+	}
+	listed := make(map[string]*orgSprites)
+	list := func(org string) *orgSprites {
+		if l, ok := listed[org]; ok {
+			return l
+		}
+		l := &orgSprites{names: make(map[string]string), ids: make(map[string]string)}
+		listed[org] = l
+		tokenOpts := &sshserver.TokenOptions{Organization: org}
+		if l.err = tokenOpts.Resolve(); l.err != nil {
+			return l
+		}
+		client := sprites.New(tokenOpts.AuthToken, sprites.WithBaseURL(tokenOpts.API))
+		all, err := client.ListAllSprites(ctx, "")
+		if l.err = err; err != nil {
+			return l
+		}
+		for _, s := range all {
+			l.names[s.ID] = s.Name()
+			l.ids[s.Name()] = s.ID
+		}
+		return l
+	}
+
+	var notes []string
+	problems := 0
+	for _, e := range inv.Sprites {
+		user := sshserver.SpriteUser(e.Name, e.Org)
+		if e.ID == "" {
+			continue
+		}
+		if others := inv.FindID(e.ID); len(others) > 1 && others[0] == e {
+			var names []string
+			for _, o := range others {
+				names = append(names, o.Name)
+			}
+			notes = append(notes, fmt.Sprintf("✗ %s are the same sprite; remove the stale ones with 'stop -s <name> --local-only'", strings.Join(names, ", ")))
+			problems++
+		}
+
+		l := list(e.Org)
+		if l.err != nil {
+			continue
+		}
+		current, found := l.names[e.ID]
+		switch {
+		case found && current == e.Name:
+		case found:
+			notes = append(notes, fmt.Sprintf("⚠ %s was renamed to %s; set it up with -s %s to move its local state", user, current, current))
+		case l.ids[e.Name] != "":
+			notes = append(notes, fmt.Sprintf("✗ %s is now a different sprite (ID %s, was %s); check its setup with 'repair'", user, l.ids[e.Name], e.ID))
+			problems++
+		default:
+			notes = append(notes, fmt.Sprintf("✗ %s no longer exists; remove its local state with 'stop -s %s --local-only'", user, e.Name))
+			problems++
+		}
+	}
+
+	for org, l := range listed {
+		if l.err != nil {
+			notes = append(notes, fmt.Sprintf("- couldn't list sprites in %s: %v", cmp.Or(org, "the current organization"), l.err))
+		}
+	}
+
+	if len(notes) == 0 {
+		fmt.Printf("Sprites:     ✓ %d set up, names match their IDs\n", len(inv.Sprites))
+		return problems
+	}
+	fmt.Printf("Sprites:     %d set up\n", len(inv.Sprites))
+	for _, n := range notes {
+		fmt.Printf("  %s\n", n)
+	}
+	return problems
+}
+
+// checkServeEnv compares the running server's environment with ours, since
+// a server that can't reach the API the way the CLI does fails at auth time
+func checkServeEnv() int {
+	pid := tools.GetServePid()
+	meta, err := tools.LoadServeMetadata()
+	if pid == 0 || err != nil || meta.PID != pid {
+		fmt.Println("Serve env:   - server not running (or started by an older version)")
+		return 0
+	}
+
+	diffs := tools.DiffEnvSnapshots(tools.EnvSnapshot(os.Environ()), meta.Env)
+	if len(diffs) == 0 {
+		fmt.Println("Serve env:   ✓ matches this shell")
+		return 0
+	}
+
+	fmt.Println("Serve env:   ✗ differs from this shell (restart with 'sprite-bootstrap stop')")
+	for _, d := range diffs {
+		fmt.Printf("  - %s\n", d)
+	}
+	fmt.Printf("  Serve log: %s\n", meta.LogFile)
+	if meta.RotatingLog != "" {
+		fmt.Printf("  Earlier runs: %s\n", meta.RotatingLog)
+	}
+	return len(diffs)
+}
+
+// checkNetwork finds the largest proxy message that makes it to the sprite
+// and back
+func checkNetwork() (int, error) {
+	if spriteName == "" {
+		return 0, fmt.Errorf("--network needs a sprite (-s)")
+	}
+
+	tokenOpts := &sshserver.TokenOptions{Organization: orgName}
+	if err := tokenOpts.Resolve(); err != nil {
+		return 0, fmt.Errorf("failed to resolve sprites credentials: %w\nRun 'sprite login' first", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Minute)
+	defer cancel()
+
+	client := sprites.New(tokenOpts.AuthToken, sprites.WithBaseURL(tokenOpts.API))
+	sprite, err := client.GetSprite(ctx, spriteName)
+	if err != nil {
+		return 0, fmt.Errorf("sprite not found: %s", spriteName)
+	}
+
+	fmt.Printf("%s⏳%s Probing proxy message sizes on %s...\n", tools.ColorYellow, tools.ColorReset, spriteName)
+	results, err := sshserver.ProbeFrameSizes(ctx, tokenOpts, sprite, sshserver.DefaultProbeSizes, 10*time.Second)
+	if err != nil {
+		fmt.Printf("Network:     ✗ %v\n", err)
+		return 1, nil
+	}
+
+	largest := 0
+	for _, r := range results {
+		if r.OK {
+			fmt.Printf("  %8d bytes  ✓ %s\n", r.Size, r.Elapsed.Round(time.Millisecond))
+			largest = r.Size
+		} else {
+			fmt.Printf("  %8d bytes  ✗ %v\n", r.Size, r.Err)
+		}
+	}
+
+	last := results[len(results)-1]
+	if last.OK {
+		fmt.Printf("Network:     ✓ messages up to %d bytes echo intact\n", largest)
+		return 0, nil
+	}
+
+	fmt.Printf("Network:     ✗ messages of %d bytes don't make it through\n", last.Size)
+	if largest > 0 {
+		fmt.Printf("  Restart serve with --max-frame-size %d (or set max-frame-size in serve.yaml)\n", largest)
+	}
+	return 1, nil
+}
@@ -0,0 +1,106 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/vaurdan/sprite-bootstrap/internal/tools"
+
+	"github.com/spf13/cobra"
+)
+
+// Exit statuses of ensure besides 0 for converged. Any other failure, such
+// as a usage error, also exits with 1.
+const (
+	ensureExitFailed  = 1
+	ensureExitChanged = 2
+)
+
+var (
+	ensureTool    string
+	ensureTimeout time.Duration
+	ensureJSON    bool
+)
+
+var ensureCmd = &cobra.Command{
+	Use:   "ensure",
+	Short: "Converge a sprite's tool setup without prompting, for scripts",
+	Long: `Check that a tool's setup of a sprite is in place and run only the steps
+that are missing: the SSH server is running and reachable, the SSH config
+entry (and host key pin, when the policy requires one) is current, and the
+state the tool's setup leaves on the sprite is present. It never prompts and
+gives up after --timeout. The editor isn't launched.
+
+Each step run is printed as "changed STEP DETAIL", each failure as
+"failed STEP ERROR", followed by the outcome. --json prints the same as one
+JSON object.
+
+Exit status:
+  0  converged: everything was already in place
+  2  converged after running missing steps
+  1  failed
+
+Example:
+  sprite-bootstrap ensure -s mysprite --tool vscode`,
+	Args: cobra.NoArgs,
+	RunE: runEnsure,
+}
+
+func init() {
+	ensureCmd.Flags().StringVar(&ensureTool, "tool", "", "Tool whose setup to converge (required)")
+	ensureCmd.Flags().DurationVar(&ensureTimeout, "timeout", 2*time.Minute, "Give up after this long")
+	ensureCmd.Flags().BoolVar(&ensureJSON, "json", false, "Print the result as JSON")
+	ensureCmd.MarkFlagRequired("tool")
+	rootCmd.AddCommand(ensureCmd)
+}
+
+func runEnsure(cmd *cobra.Command, args []string) error {
+	if spriteName == "" {
+		return fmt.Errorf("sprite name required (-s)")
+	}
+	tool, ok := tools.Get(ensureTool)
+	if !ok {
+		return fmt.Errorf("unknown tool: %s", ensureTool)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), ensureTimeout)
+	defer cancel()
+
+	opts := tools.NewSetupOptions(spriteName, orgName, localPort, resolveRemotePath(remotePath))
+	if err := applyServeHost(ctx, &opts); err != nil {
+		return err
+	}
+
+	// Whatever the steps print goes to stderr, leaving stdout for the result
+	stdout := os.Stdout
+	os.Stdout = os.Stderr
+	result := tools.Ensure(ctx, tool, opts)
+	os.Stdout = stdout
+
+	if ensureJSON {
+		if err := writeJSON(stdout, result); err != nil {
+			return err
+		}
+	} else {
+		for _, a := range result.Actions {
+			if a.Error != "" {
+				fmt.Fprintf(stdout, "failed %s %s\n", a.Step, a.Error)
+			} else {
+				fmt.Fprintf(stdout, "changed %s %s\n", a.Step, a.Detail)
+			}
+		}
+		fmt.Fprintln(stdout, result.Status)
+	}
+
+	// The outcome is already printed
+	cmd.SilenceErrors, cmd.SilenceUsage = true, true
+	switch result.Status {
+	case tools.EnsureChanged:
+		return &ExitError{Code: ensureExitChanged}
+	case tools.EnsureFailed:
+		return &ExitError{Code: ensureExitFailed}
+	}
+	return nil
+}
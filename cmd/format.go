@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"text/template"
+)
+
+// formatFuncs are available in --format templates
+var formatFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+}
+
+// writeFormatted executes a --format Go template against v and writes the
+// result followed by a newline. Errors about unknown fields list the fields
+// that exist.
+func writeFormatted(w io.Writer, format string, v any) error {
+	tmpl, err := template.New("format").Funcs(formatFuncs).Option("missingkey=error").Parse(format)
+	if err != nil {
+		return fmt.Errorf("invalid --format template: %w", err)
+	}
+
+	var out strings.Builder
+	if err := tmpl.Execute(&out, v); err != nil {
+		if strings.Contains(err.Error(), "can't evaluate field") {
+			return fmt.Errorf("invalid --format template: %w (available fields: %s)", err, strings.Join(fieldNames(v), ", "))
+		}
+		return fmt.Errorf("invalid --format template: %w", err)
+	}
+	_, err = fmt.Fprintln(w, out.String())
+	return err
+}
+
+// writeJSON writes v as indented JSON
+func writeJSON(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// fieldNames lists the exported fields of a struct, for template errors
+func fieldNames(v any) []string {
+	t := reflect.TypeOf(v)
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+	var names []string
+	for i := 0; i < t.NumField(); i++ {
+		if f := t.Field(i); f.IsExported() {
+			names = append(names, "."+f.Name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteFormatted(t *testing.T) {
+	st := serveStatus{
+		Running:     true,
+		PID:         4242,
+		Port:        2222,
+		StartedAt:   time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+		Connections: 3,
+		LogFile:     "/tmp/serve.log",
+		RuntimeDir:  "/tmp/sprite-bootstrap",
+	}
+	tests := []struct {
+		name    string
+		format  string
+		want    string
+		wantErr []string // Substrings of the error
+	}{
+		{name: "fields", format: "{{.PID}} {{.Port}}", want: "4242 2222\n"},
+		{name: "conditional", format: "{{if .Running}}up{{else}}down{{end}} on {{.Port}}", want: "up on 2222\n"},
+		{name: "time method", format: `{{.StartedAt.Format "2006-01-02"}}`, want: "2026-01-02\n"},
+		{name: "printf", format: `{{printf "%05d" .Connections}}`, want: "00003\n"},
+		{name: "json of a field", format: "{{json .LogFile}}", want: `"/tmp/serve.log"` + "\n"},
+		{name: "json of everything", format: "{{json .}}",
+			want: `{"running":true,"pid":4242,"port":2222,"started_at":"2026-01-02T03:04:05Z","connections":3,"max_connections":0,"log_file":"/tmp/serve.log","runtime_dir":"/tmp/sprite-bootstrap"}` + "\n"},
+		{name: "literal text", format: "status", want: "status\n"},
+		{name: "unknown field", format: "{{.State}}",
+			wantErr: []string{"invalid --format template", "State", "available fields: .Running, .PID, .Port, .StartedAt, .Connections, .MaxConnections, .LogFile, .RuntimeDir"}},
+		{name: "field in lower case", format: "{{.port}}", wantErr: []string{"port", "available fields: .Running"}},
+		{name: "unclosed action", format: "{{.Port", wantErr: []string{"invalid --format template", "unclosed action"}},
+		{name: "unknown function", format: "{{yaml .}}", wantErr: []string{"invalid --format template", `function "yaml" not defined`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out strings.Builder
+			err := writeFormatted(&out, tt.format, st)
+			if len(tt.wantErr) > 0 {
+				if err == nil {
+					t.Fatalf("writeFormatted(%q) printed %q, want an error", tt.format, out.String())
+				}
+				for _, s := range tt.wantErr {
+					if !strings.Contains(err.Error(), s) {
+						t.Errorf("writeFormatted(%q) error = %v, want it to contain %q", tt.format, err, s)
+					}
+				}
+				if out.Len() != 0 {
+					t.Errorf("writeFormatted(%q) printed %q before failing", tt.format, out.String())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("writeFormatted(%q): %v", tt.format, err)
+			}
+			if out.String() != tt.want {
+				t.Errorf("writeFormatted(%q) = %q, want %q", tt.format, out.String(), tt.want)
+			}
+		})
+	}
+}
+
+// TestWriteFormattedMap checks a missing map key is an error rather than
+// "<no value>"
+func TestWriteFormattedMap(t *testing.T) {
+	var out strings.Builder
+	if err := writeFormatted(&out, "{{.port}}", map[string]int{"port": 2222}); err != nil || out.String() != "2222\n" {
+		t.Errorf("writeFormatted = %q, %v; want %q", out.String(), err, "2222\n")
+	}
+	out.Reset()
+	if err := writeFormatted(&out, "{{.pid}}", map[string]int{"port": 2222}); err == nil {
+		t.Errorf("writeFormatted of a missing key printed %q, want an error", out.String())
+	}
+}
+
+func TestFieldNames(t *testing.T) {
+	type inner struct {
+		Name   string
+		hidden int
+		Count  int
+	}
+	tests := []struct {
+		name string
+		v    any
+		want []string
+	}{
+		{"struct", inner{}, []string{".Name", ".Count"}},
+		{"pointer", &inner{}, []string{".Name", ".Count"}},
+		{"slice", []inner{}, nil},
+		{"scalar", 3, nil},
+	}
+	for _, tt := range tests {
+		if got := fieldNames(tt.v); !slices.Equal(got, tt.want) {
+			t.Errorf("fieldNames(%s) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestStatusFieldsDocumented checks status --help lists every field a
+// template can use, in order
+func TestStatusFieldsDocumented(t *testing.T) {
+	var documented []string
+	for _, m := range regexp.MustCompile(`(?m)^  (\.\w+)`).FindAllStringSubmatch(statusCmd.Long, -1) {
+		documented = append(documented, m[1])
+	}
+	if fields := fieldNames(serveStatus{}); !slices.Equal(documented, fields) {
+		t.Errorf("status --help documents %q, want the fields %q", documented, fields)
+	}
+}
@@ -0,0 +1,218 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/vaurdan/sprite-bootstrap/internal/ratelimit"
+	"github.com/vaurdan/sprite-bootstrap/internal/tools"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	forwardsJSON      bool
+	forwardsLimitRate string
+	forwardsLimitUp   string
+	forwardsLimitDown string
+)
+
+var forwardsCmd = &cobra.Command{
+	Use:   "forwards",
+	Short: "List, limit and close active port forwards",
+	Long: `List and close the active forwards: channels forwarded through the SSH
+server (by IDEs, ssh -L and the like) and forwarding commands such as
+'open --alias'.
+
+Closing a forward through the SSH server drops just that channel; the SSH
+connection it belongs to stays up. Closing a command's forward stops the
+command. Forwards can be closed by ID or by the label given when they were
+created (e.g. open --alias --label web).
+
+Rate limits (serve --limit-rate, open --limit-rate) are shared by all the
+forwards of a process and shown in the LIMIT column as up/down. 'forwards
+limit' changes them while the forwards run; given a forward through the SSH
+server it changes the server's limits, which apply to all of its forwards.`,
+}
+
+var forwardsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List active forwards",
+	Args:  cobra.NoArgs,
+	RunE:  runForwardsList,
+}
+
+var forwardsLimitCmd = &cobra.Command{
+	Use:   "limit [id|label]",
+	Short: "Change the rate limits of running forwards",
+	Long: `Change the rate limits of running forwards. Without an argument the SSH
+server's limits are changed. Rates are given like 5MB/s, 500KB/s or
+1.5MiB/s; 0 lifts a limit.
+
+Example:
+  sprite-bootstrap forwards limit --limit-rate 5MB/s
+  sprite-bootstrap forwards limit web --limit-up 1MB/s --limit-down 0`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runForwardsLimit,
+}
+
+var forwardsCloseCmd = &cobra.Command{
+	Use:   "close <id|label>",
+	Short: "Close a forward",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runForwardsClose,
+}
+
+func init() {
+	forwardsListCmd.Flags().BoolVar(&forwardsJSON, "json", false, "Print the forwards as JSON")
+	forwardsLimitCmd.Flags().StringVar(&forwardsLimitRate, "limit-rate", "", "Bytes per second each way, e.g. 5MB/s (0 for none)")
+	forwardsLimitCmd.Flags().StringVar(&forwardsLimitUp, "limit-up", "", "Bytes per second sent to sprites, overriding --limit-rate")
+	forwardsLimitCmd.Flags().StringVar(&forwardsLimitDown, "limit-down", "", "Bytes per second received from sprites, overriding --limit-rate")
+	forwardsCmd.AddCommand(forwardsListCmd, forwardsLimitCmd, forwardsCloseCmd)
+	rootCmd.AddCommand(forwardsCmd)
+}
+
+func runForwardsList(cmd *cobra.Command, args []string) error {
+	forwards := tools.ListForwards()
+	if forwardsJSON {
+		if forwards == nil {
+			forwards = []tools.ForwardInfo{}
+		}
+		return writeJSON(os.Stdout, forwards)
+	}
+
+	if len(forwards) == 0 {
+		fmt.Println("No active forwards")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSOURCE\tLABEL\tSPRITE\tLOCAL\tREMOTE\tIN/OUT\tLIMIT\tAGE")
+	for _, f := range forwards {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s/%s\t%s\t%s\n",
+			f.ID, f.Source, orDash(f.Label), orDash(f.Sprite), orDash(f.Local), orDash(f.Remote),
+			formatBytes(f.BytesIn), formatBytes(f.BytesOut), formatLimits(f.LimitUp, f.LimitDown),
+			time.Since(f.Started).Round(time.Second))
+	}
+	return w.Flush()
+}
+
+func runForwardsClose(cmd *cobra.Command, args []string) error {
+	f, err := tools.FindForward(tools.ListForwards(), args[0])
+	if err != nil {
+		return err
+	}
+	if err := tools.CloseForward(f); err != nil {
+		return err
+	}
+	fmt.Printf("%s✓%s Closed forward %s (%s → %s)\n", tools.ColorGreen, tools.ColorReset, f.ID, f.Sprite, f.Remote)
+	return nil
+}
+
+func runForwardsLimit(cmd *cobra.Command, args []string) error {
+	var limits tools.RateLimits
+	if forwardsLimitRate != "" {
+		rate, err := ratelimit.Parse(forwardsLimitRate)
+		if err != nil {
+			return fmt.Errorf("--limit-rate: %w", err)
+		}
+		limits.Up, limits.Down = &rate, &rate
+	}
+	if forwardsLimitUp != "" {
+		rate, err := ratelimit.Parse(forwardsLimitUp)
+		if err != nil {
+			return fmt.Errorf("--limit-up: %w", err)
+		}
+		limits.Up = &rate
+	}
+	if forwardsLimitDown != "" {
+		rate, err := ratelimit.Parse(forwardsLimitDown)
+		if err != nil {
+			return fmt.Errorf("--limit-down: %w", err)
+		}
+		limits.Down = &rate
+	}
+	if limits.Up == nil && limits.Down == nil {
+		return fmt.Errorf("nothing to change; pass --limit-rate, --limit-up or --limit-down")
+	}
+
+	var f tools.ForwardInfo
+	if len(args) == 1 {
+		var err error
+		if f, err = tools.FindForward(tools.ListForwards(), args[0]); err != nil {
+			return err
+		}
+	} else if f.PID = tools.GetServePid(); f.PID == 0 {
+		return fmt.Errorf("the SSH server is not running")
+	}
+	if err := tools.SetRateLimits(f, limits); err != nil {
+		return err
+	}
+
+	var changes []string
+	if limits.Up != nil {
+		changes = append(changes, "up "+ratelimit.Format(*limits.Up))
+	}
+	if limits.Down != nil {
+		changes = append(changes, "down "+ratelimit.Format(*limits.Down))
+	}
+	target := "the SSH server's forwards"
+	if f.Source == tools.ForwardSourceCLI {
+		target = "forward " + f.ID
+	}
+	fmt.Printf("%s✓%s Limited %s: %s\n", tools.ColorGreen, tools.ColorReset, target, strings.Join(changes, ", "))
+	return nil
+}
+
+// rateLimitFlags parses a command's --limit-rate, --limit-up and
+// --limit-down flags into the up and down limits, in bytes per second
+func rateLimitFlags(rate, up, down string) (int64, int64, error) {
+	both, err := ratelimit.Parse(rate)
+	if err != nil {
+		return 0, 0, fmt.Errorf("--limit-rate: %w", err)
+	}
+	limitUp, limitDown := both, both
+	if up != "" {
+		if limitUp, err = ratelimit.Parse(up); err != nil {
+			return 0, 0, fmt.Errorf("--limit-up: %w", err)
+		}
+	}
+	if down != "" {
+		if limitDown, err = ratelimit.Parse(down); err != nil {
+			return 0, 0, fmt.Errorf("--limit-down: %w", err)
+		}
+	}
+	return limitUp, limitDown, nil
+}
+
+// formatLimits prints up and down rate limits, or "-" when there are none
+func formatLimits(up, down int64) string {
+	if up == 0 && down == 0 {
+		return "-"
+	}
+	return ratelimit.Format(up) + "/" + ratelimit.Format(down)
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// formatBytes prints a byte count with a binary unit
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,150 @@
+package cmd
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/vaurdan/sprite-bootstrap/internal/sshserver"
+	"github.com/vaurdan/sprite-bootstrap/internal/tools"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh"
+)
+
+var rotateGrace time.Duration
+
+var hostKeyCmd = &cobra.Command{
+	Use:   "host-key",
+	Short: "Show or rotate the SSH server's host key",
+	Long: `Show or rotate the default host key of the SSH server, the one used when
+serve isn't given --host-key.`,
+}
+
+var hostKeyShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Print the host key's fingerprint",
+	Args:  cobra.NoArgs,
+	RunE:  runHostKeyShow,
+}
+
+var hostKeyRotateCmd = &cobra.Command{
+	Use:   "rotate",
+	Short: "Replace the host key without breaking clients",
+	Long: `Generate a new host key, keeping the old one for a grace period.
+
+Until the grace period ends the server keeps handshaking with the old key
+and announces the new one, so OpenSSH clients with UpdateHostKeys learn it.
+The new key is also added next to the old one in ~/.ssh/known_hosts and in
+the pinned known_hosts file, for the same hosts. Once the grace period is
+over, the next server start switches to the new key and removes the old
+key and its known_hosts entries.
+
+A running server picks up the rotation when it restarts. With --grace 0
+the switch happens on that restart.`,
+	Args: cobra.NoArgs,
+	RunE: runHostKeyRotate,
+}
+
+func init() {
+	hostKeyRotateCmd.Flags().DurationVar(&rotateGrace, "grace", 7*24*time.Hour, "How long the old key stays in use")
+	hostKeyCmd.AddCommand(hostKeyShowCmd, hostKeyRotateCmd)
+	rootCmd.AddCommand(hostKeyCmd)
+}
+
+func runHostKeyShow(cmd *cobra.Command, args []string) error {
+	path, err := sshserver.DefaultHostKeyPath()
+	if err != nil {
+		return err
+	}
+	key, err := sshserver.LoadHostKey(path)
+	if err != nil {
+		return fmt.Errorf("failed to load host key: %w", err)
+	}
+
+	fmt.Printf("Host key: %s (%s)\n", path, key.PublicKey().Type())
+	fmt.Printf("          %s\n", ssh.FingerprintSHA256(key.PublicKey()))
+
+	rot, err := sshserver.LoadHostKeyRotation(path)
+	if err != nil || rot == nil {
+		return err
+	}
+	retired, err := sshserver.LoadHostKey(rot.Retired)
+	if err != nil {
+		return fmt.Errorf("failed to load retired host key: %w", err)
+	}
+	if rot.Active() {
+		fmt.Printf("\nRotating: the server handshakes with the previous key until %s\n", rot.Until.Local().Format(time.RFC1123))
+	} else {
+		fmt.Printf("\nRotated: the previous key is dropped when the server next starts\n")
+	}
+	fmt.Printf("          %s\n", ssh.FingerprintSHA256(retired.PublicKey()))
+	return nil
+}
+
+func runHostKeyRotate(cmd *cobra.Command, args []string) error {
+	path, err := sshserver.DefaultHostKeyPath()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s⏳%s Generating a new host key...\n", tools.ColorYellow, tools.ColorReset)
+	retired, current, err := sshserver.RotateHostKey(path, rotateGrace)
+	if err != nil {
+		return fmt.Errorf("failed to rotate host key: %w", err)
+	}
+	fmt.Printf("%s✓%s New host key %s\n", tools.ColorGreen, tools.ColorReset, ssh.FingerprintSHA256(current.PublicKey()))
+
+	n, err := tools.TrustRotatedHostKey(retired.PublicKey(), current.PublicKey())
+	if err != nil {
+		fmt.Printf("%s⚠%s Failed to update known_hosts: %v\n", tools.ColorYellow, tools.ColorReset, err)
+	} else {
+		fmt.Printf("%s✓%s Added the new key to %d known_hosts entries\n", tools.ColorGreen, tools.ColorReset, n)
+	}
+
+	if rotateGrace > 0 {
+		fmt.Printf("  The previous key stays in use until %s\n", time.Now().Add(rotateGrace).Format(time.RFC1123))
+	}
+	if tools.IsServeRunning() {
+		fmt.Printf("%s⚠%s Restart the SSH server to pick up the rotation: sprite-bootstrap stop\n", tools.ColorYellow, tools.ColorReset)
+		fmt.Println("  It starts again with the next zed or vscode command.")
+	}
+	return nil
+}
+
+// applyHostKeyRotation returns the host keys to serve with while the
+// default key is being rotated: the retired key for the handshake, with the
+// new one announced. A rotation past its grace period is finished instead.
+func applyHostKeyRotation(current ssh.Signer, extra []ssh.Signer) (ssh.Signer, []ssh.Signer) {
+	path, err := sshserver.DefaultHostKeyPath()
+	if err != nil {
+		return current, extra
+	}
+	rot, err := sshserver.LoadHostKeyRotation(path)
+	if err != nil {
+		slog.Warn("Failed to read host key rotation", "exception", err)
+		return current, extra
+	}
+	if rot == nil {
+		return current, extra
+	}
+	retired, err := sshserver.LoadHostKey(rot.Retired)
+	if err != nil {
+		slog.Warn("Failed to load retired host key, using the new one", "exception", err)
+		return current, extra
+	}
+
+	if !rot.Active() {
+		if _, err := tools.ForgetRetiredHostKey(retired.PublicKey()); err != nil {
+			slog.Warn("Failed to remove the retired host key from known_hosts", "exception", err)
+		}
+		sshserver.FinishHostKeyRotation(path, rot)
+		slog.Info("Finished host key rotation", "key.fingerprint", ssh.FingerprintSHA256(current.PublicKey()))
+		return current, extra
+	}
+
+	slog.Info("Host key rotation in progress, announcing the new key",
+		"key.fingerprint", ssh.FingerprintSHA256(current.PublicKey()),
+		"until", rot.Until)
+	return retired, append(extra, current)
+}
@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/vaurdan/sprite-bootstrap/internal/config"
+	"github.com/vaurdan/sprite-bootstrap/internal/ui"
+
+	"github.com/spf13/cobra"
+)
+
+// notifyEnabled reports whether completion notifications are wanted for
+// cmd. An explicit --notify flag wins over the saved preference, but JSON
+// output is never followed by a notification: the bell would end up in
+// whatever reads the JSON.
+func notifyEnabled(cmd *cobra.Command) bool {
+	if jsonOutput(cmd) || !ui.IsInteractive() {
+		return false
+	}
+	if f := rootCmd.PersistentFlags().Lookup("notify"); f != nil && f.Changed {
+		return notify
+	}
+	prefs, err := config.LoadPreferences()
+	return err == nil && prefs.Notify
+}
+
+// jsonOutput reports whether cmd was asked for JSON output
+func jsonOutput(cmd *cobra.Command) bool {
+	asJSON, err := cmd.Flags().GetBool("json")
+	return err == nil && asJSON
+}
+
+// notifyDone notifies the user that a long operation on a sprite, run by
+// cmd, finished
+func notifyDone(cmd *cobra.Command, operation, sprite string, start time.Time, err error) {
+	if !notifyEnabled(cmd) {
+		return
+	}
+
+	elapsed := time.Since(start).Round(time.Second)
+	if err != nil {
+		ui.Notify("sprite-bootstrap", fmt.Sprintf("%s for %s failed after %s", operation, sprite, elapsed))
+		return
+	}
+	ui.Notify("sprite-bootstrap", fmt.Sprintf("%s for %s finished in %s", operation, sprite, elapsed))
+}
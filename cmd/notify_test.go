@@ -0,0 +1,36 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+func TestNotifyEnabledJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want bool
+	}{
+		{"no json flag", nil, false},
+		{"json flag unset", []string{}, false},
+		{"json output", []string{"--json"}, true},
+		{"json output off", []string{"--json=false"}, false},
+	}
+	for _, tt := range tests {
+		cmd := &cobra.Command{}
+		if tt.args != nil {
+			var output summaryFlags
+			output.register(cmd)
+			if err := cmd.ParseFlags(tt.args); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if got := jsonOutput(cmd); got != tt.want {
+			t.Errorf("%s: jsonOutput() = %v, want %v", tt.name, got, tt.want)
+		}
+		if tt.want && notifyEnabled(cmd) {
+			t.Errorf("%s: notifyEnabled() = true with JSON output", tt.name)
+		}
+	}
+}
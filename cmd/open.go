@@ -0,0 +1,319 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/vaurdan/sprite-bootstrap/internal/sshserver"
+	"github.com/vaurdan/sprite-bootstrap/internal/tools"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	openAlias      bool
+	openAliasPort  int
+	openTargetPort int
+	openRoutes     []string
+	openLabel      string
+	openLimitRate  string
+	openLimitUp    string
+	openLimitDown  string
+)
+
+var openCmd = &cobra.Command{
+	Use:   "open [sprite...]",
+	Short: "Reach web services on sprites by name from the browser",
+	Long: `Reach web services on sprites from a local browser by sprite name.
+
+With --alias a local HTTP proxy listens on one port (default 8080) and routes
+requests by host name: http://foo.sprite.localhost:8080/ goes to sprite foo.
+*.localhost resolves to this machine on modern systems, so no hosts file
+editing is needed. WebSocket connections are passed through.
+
+Only routed sprites are reachable; requests for any other host get a 404,
+so web pages can't use the proxy to reach every sprite you have access to.
+Routes come from:
+
+  - port forwards through serve to localhost on a sprite, e.g. ssh -L
+    3000:localhost:3000 sends mysprite's requests to its port 3000
+  - --route SPRITE=PORT
+  - the sprites given as arguments (or -s), with --target-port
+
+Forwards are picked up while the proxy runs. URLs are printed for every
+route.
+
+The proxy is listed by 'sprite-bootstrap forwards list' and can be stopped
+with 'sprite-bootstrap forwards close'; --label names it there. --limit-rate
+caps the bandwidth all requests share, and 'sprite-bootstrap forwards limit'
+changes it while the proxy runs.
+
+Example:
+  sprite-bootstrap open --alias --target-port 8080 mysprite
+  sprite-bootstrap open --alias --route api=3000 --route web=5173`,
+	RunE: runOpen,
+}
+
+func init() {
+	openCmd.Flags().BoolVar(&openAlias, "alias", false, "Serve sprites at http://<sprite>.sprite.localhost through a local proxy")
+	openCmd.Flags().IntVar(&openAliasPort, "alias-port", 8080, "Local port the alias proxy listens on")
+	openCmd.Flags().IntVar(&openTargetPort, "target-port", 0, "Route the sprites given as arguments to this port on them (default: only route --route and forwarded sprites)")
+	openCmd.Flags().StringArrayVar(&openRoutes, "route", nil, "Send a sprite's requests to another port on it, as SPRITE=PORT (repeatable)")
+	openCmd.Flags().StringVar(&openLabel, "label", "", "Label for the proxy in 'forwards list' and 'forwards close'")
+	openCmd.Flags().StringVar(&openLimitRate, "limit-rate", "", "Cap the bytes per second the proxy sends and receives, each way, e.g. 5MB/s (default none)")
+	openCmd.Flags().StringVar(&openLimitUp, "limit-up", "", "Cap the bytes per second sent to sprites, overriding --limit-rate")
+	openCmd.Flags().StringVar(&openLimitDown, "limit-down", "", "Cap the bytes per second received from sprites, overriding --limit-rate")
+	openCmd.Flags().StringVar(&apiCACert, "ca-cert", "", "PEM CA bundle to trust for a self-hosted sprites API, besides the system roots (or "+sshserver.EnvCACert+")")
+	openCmd.Flags().StringVar(&apiClientCert, "client-cert", "", "PEM client certificate for a sprites API that requires mutual TLS (or "+sshserver.EnvClientCert+")")
+	openCmd.Flags().StringVar(&apiClientKey, "client-key", "", "Key of --client-cert (or "+sshserver.EnvClientKey+")")
+	openCmd.Flags().BoolVar(&apiInsecure, "insecure-skip-verify", false, "Don't verify the sprites API's certificate; for testing only (or "+sshserver.EnvInsecureSkipVerify+")")
+	openCmd.Flags().BoolVar(&ignoreProxyEnv, "ignore-proxy-env", false, "Connect to the sprites API directly, ignoring HTTPS_PROXY, HTTP_PROXY and NO_PROXY")
+	openCmd.MarkFlagRequired("alias")
+	rootCmd.AddCommand(openCmd)
+}
+
+func runOpen(cmd *cobra.Command, args []string) error {
+	tokenOpts := &sshserver.TokenOptions{Organization: orgName}
+	if err := tokenOpts.Resolve(); err != nil {
+		return fmt.Errorf("failed to resolve sprites credentials: %w\nRun 'sprite login' first", err)
+	}
+
+	tlsOpts, err := apiTLSOptions(cmd)
+	if err != nil {
+		return err
+	}
+	proxy, err := sshserver.NewAliasProxy(tokenOpts, tlsOpts, ignoreProxyEnv)
+	if err != nil {
+		return err
+	}
+	for _, spec := range openRoutes {
+		name, portStr, ok := strings.Cut(spec, "=")
+		port, err := strconv.Atoi(portStr)
+		if !ok || name == "" || err != nil || port < 1 || port > 65535 {
+			return fmt.Errorf("invalid --route %q (expected SPRITE=PORT)", spec)
+		}
+		if err := tokenOpts.Policy.CheckForwardPort(port); err != nil {
+			return fmt.Errorf("--route %s: %w", spec, err)
+		}
+		proxy.SetRoute(name, port)
+	}
+
+	names := append([]string{}, args...)
+	if spriteName != "" {
+		names = append(names, spriteName)
+	}
+	if openTargetPort != 0 {
+		if openTargetPort < 0 || openTargetPort > 65535 {
+			return fmt.Errorf("invalid --target-port %d", openTargetPort)
+		}
+		if err := tokenOpts.Policy.CheckForwardPort(openTargetPort); err != nil {
+			return fmt.Errorf("--target-port: %w", err)
+		}
+		if len(names) == 0 {
+			return errors.New("--target-port needs the sprites to route, as arguments or with -s")
+		}
+		for _, name := range names {
+			if !slices.Contains(proxy.RoutedSprites(), strings.ToLower(name)) {
+				proxy.SetRoute(name, openTargetPort)
+			}
+		}
+	}
+	proxy.SetForwardRoutes(forwardRoutes(tools.ListForwards()))
+	limitUp, limitDown, err := rateLimitFlags(openLimitRate, openLimitUp, openLimitDown)
+	if err != nil {
+		return err
+	}
+	proxy.SetRateLimits(limitUp, limitDown)
+
+	// Listen on both loopback families, since browsers may resolve
+	// *.localhost to either
+	port := strconv.Itoa(openAliasPort)
+	listener, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", port))
+	if err != nil {
+		return fmt.Errorf("failed to listen on port %d: %w\nPick another port with --alias-port", openAliasPort, err)
+	}
+	listeners := []net.Listener{listener}
+	if l6, err := net.Listen("tcp", net.JoinHostPort("::1", port)); err == nil {
+		listeners = append(listeners, l6)
+	}
+	var counter byteCounter
+	for i, l := range listeners {
+		listeners[i] = &countingListener{Listener: l, counter: &counter}
+	}
+
+	printAliasURLs(proxy.RoutedSprites())
+	for _, name := range names {
+		if !slices.Contains(proxy.RoutedSprites(), strings.ToLower(name)) {
+			fmt.Printf("%s⚠%s %s has no route yet; forward a port to it, or use --target-port or --route %s=PORT\n",
+				tools.ColorYellow, tools.ColorReset, name, name)
+		}
+	}
+
+	server := &http.Server{Handler: proxy, ReadHeaderTimeout: 30 * time.Second}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	serverErr := make(chan error, len(listeners))
+	for _, l := range listeners {
+		go func() { serverErr <- server.Serve(l) }()
+	}
+
+	// Publish the proxy for the forwards command
+	fwd := &tools.ForwardInfo{
+		ID:      tools.CLIForwardID(os.Getpid()),
+		Source:  tools.ForwardSourceCLI,
+		Label:   openLabel,
+		Kind:    "alias",
+		Local:   listener.Addr().String(),
+		Started: time.Now(),
+		PID:     os.Getpid(),
+	}
+	defer tools.RemoveCLIForward(fwd.PID)
+	go publishCLIForward(ctx, fwd, &counter, proxy)
+
+	// Let 'forwards limit' change the proxy's rate limits
+	if err := serveControl(ctx, sshserver.RateLimitHandler(proxy)); err != nil {
+		slog.Warn("Failed to open the control socket; 'forwards limit' won't reach this proxy", "exception", err)
+	}
+
+	select {
+	case <-ctx.Done():
+		fmt.Println("\nShutting down...")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		return server.Shutdown(shutdownCtx)
+	case err := <-serverErr:
+		if errors.Is(err, http.ErrServerClosed) {
+			return nil
+		}
+		return fmt.Errorf("alias proxy: %w", err)
+	}
+}
+
+// aliasTargets describes the sprites the alias proxy routes and the ports
+// their requests go to
+func aliasTargets(proxy *sshserver.AliasProxy) (sprites, targets string) {
+	routes := proxy.Routes()
+	names := proxy.RoutedSprites()
+	list := make([]string, len(names))
+	for i, name := range names {
+		list[i] = name + ":" + strconv.Itoa(routes[name])
+	}
+	return strings.Join(names, ","), strings.Join(list, ",")
+}
+
+// forwardRoutes returns the alias routes given by serve's port forwards to
+// localhost on a sprite. A sprite with forwards to several ports is routed
+// to the one forwarded first.
+func forwardRoutes(forwards []tools.ForwardInfo) map[string]int {
+	routes := make(map[string]int)
+	for _, f := range forwards {
+		if f.Source != tools.ForwardSourceSSH || f.Kind != "direct-tcpip" {
+			continue
+		}
+		i := strings.LastIndexByte(f.Remote, ':')
+		if i < 0 {
+			continue
+		}
+		host := strings.Trim(f.Remote[:i], "[]")
+		port, err := strconv.Atoi(f.Remote[i+1:])
+		if err != nil || port < 1 || port > 65535 {
+			continue
+		}
+		if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+			continue
+		}
+		if _, ok := routes[f.Sprite]; !ok {
+			routes[f.Sprite] = port
+		}
+	}
+	return routes
+}
+
+// publishCLIForward keeps the proxy's metadata and forward routes current
+// until ctx ends
+func publishCLIForward(ctx context.Context, fwd *tools.ForwardInfo, counter *byteCounter, proxy *sshserver.AliasProxy) {
+	ticker := time.NewTicker(tools.ServeStatsInterval)
+	defer ticker.Stop()
+
+	for {
+		proxy.SetForwardRoutes(forwardRoutes(tools.ListForwards()))
+		fwd.Sprite, fwd.Remote = aliasTargets(proxy)
+		fwd.BytesIn, fwd.BytesOut = counter.in.Load(), counter.out.Load()
+		fwd.LimitUp, fwd.LimitDown = proxy.RateLimits()
+		if err := tools.SaveCLIForward(fwd); err != nil {
+			slog.Debug("Failed to write forward metadata", "exception", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// byteCounter totals the bytes through a listener's connections
+type byteCounter struct {
+	in, out atomic.Int64
+}
+
+// countingListener counts the bytes its connections read and write
+type countingListener struct {
+	net.Listener
+	counter *byteCounter
+}
+
+func (l *countingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &countingConn{Conn: conn, counter: l.counter}, nil
+}
+
+type countingConn struct {
+	net.Conn
+	counter *byteCounter
+}
+
+func (c *countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.counter.in.Add(int64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.counter.out.Add(int64(n))
+	return n, err
+}
+
+// printAliasURLs prints the alias URL of each sprite once
+func printAliasURLs(names []string) {
+	fmt.Printf("%s✓%s Alias proxy listening on port %d\n", tools.ColorGreen, tools.ColorReset, openAliasPort)
+	seen := make(map[string]bool)
+	for _, name := range names {
+		name = strings.ToLower(name)
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		fmt.Printf("  %s%s%s\n", tools.ColorCyan, sshserver.AliasURL(name, openAliasPort), tools.ColorReset)
+	}
+	if len(seen) == 0 {
+		fmt.Printf("  Open http://<sprite>.%s:%d/\n", sshserver.AliasDomain, openAliasPort)
+	}
+}
@@ -0,0 +1,29 @@
+package cmd
+
+import (
+	"maps"
+	"testing"
+
+	"github.com/vaurdan/sprite-bootstrap/internal/tools"
+)
+
+func TestForwardRoutes(t *testing.T) {
+	ssh := func(sprite, remote string) tools.ForwardInfo {
+		return tools.ForwardInfo{Source: tools.ForwardSourceSSH, Kind: "direct-tcpip", Sprite: sprite, Remote: remote}
+	}
+	forwards := []tools.ForwardInfo{
+		ssh("web", "localhost:5173"),
+		ssh("web", "localhost:3000"), // Later forwards don't replace the first
+		ssh("api", "127.0.0.1:8080"),
+		ssh("v6", "::1:9000"),
+		ssh("db", "10.0.0.5:5432"), // Not on the sprite itself
+		ssh("bad", "localhost:http"),
+		{Source: tools.ForwardSourceSSH, Kind: "direct-streamlocal", Sprite: "sock", Remote: "/var/run/docker.sock"},
+		{Source: tools.ForwardSourceCLI, Kind: "alias", Sprite: "web", Remote: "web:5173"},
+	}
+
+	want := map[string]int{"web": 5173, "api": 8080, "v6": 9000}
+	if got := forwardRoutes(forwards); !maps.Equal(got, want) {
+		t.Errorf("forwardRoutes() = %v, want %v", got, want)
+	}
+}
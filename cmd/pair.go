@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/vaurdan/sprite-bootstrap/internal/tools"
+
+	"github.com/spf13/cobra"
+)
+
+var pairCmd = &cobra.Command{
+	Use:   "pair [sprite]",
+	Short: "Print a one-time code for logging in without an SSH key",
+	Long: `Ask the background server for a one-time pairing code, for SSH clients
+that have no key pair. They log in with keyboard-interactive authentication
+and are asked for the code when the server runs with --require-pairing-code
+or --authorized-keys; otherwise they are let in without a question.
+
+A code can be used once, within 10 minutes.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runPair,
+}
+
+func init() {
+	rootCmd.AddCommand(pairCmd)
+}
+
+func runPair(cmd *cobra.Command, args []string) error {
+	code, err := tools.NewPairingCode()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s✓%s Pairing code: %s (valid until %s, once)\n", tools.ColorGreen, tools.ColorReset,
+		code.Code, code.Expires.Local().Format(time.Kitchen))
+	if len(args) == 1 {
+		port := 2222
+		if meta, err := tools.LoadServeMetadata(); err == nil && meta.Port != 0 {
+			port = meta.Port
+		}
+		fmt.Printf("  Connect with: ssh -o PreferredAuthentications=keyboard-interactive %s@localhost -p %d\n", args[0], port)
+	}
+	return nil
+}
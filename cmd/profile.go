@@ -0,0 +1,213 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/vaurdan/sprite-bootstrap/internal/config"
+	"github.com/vaurdan/sprite-bootstrap/internal/tools"
+
+	"github.com/charmbracelet/huh"
+	"github.com/spf13/cobra"
+)
+
+// profileNamePattern restricts profile names to something easy to type
+var profileNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]*$`)
+
+var profileCmd = &cobra.Command{
+	Use:   "profile",
+	Short: "Manage setup profiles",
+	Long: `Manage named setup profiles. A profile bundles a tool, remote extensions,
+an env file, a dotfiles repository, post-hooks and a remote path template.
+
+Apply one with: sprite-bootstrap up -s <sprite> --profile <name>`,
+}
+
+var profileListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List profiles",
+	Args:  cobra.NoArgs,
+	RunE:  runProfileList,
+}
+
+var profileShowCmd = &cobra.Command{
+	Use:   "show <name>",
+	Short: "Show a profile",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runProfileShow,
+}
+
+var profileCreateCmd = &cobra.Command{
+	Use:   "create [name]",
+	Short: "Create or replace a profile interactively",
+	Args:  cobra.MaximumNArgs(1),
+	RunE:  runProfileCreate,
+}
+
+func init() {
+	profileCmd.AddCommand(profileListCmd, profileShowCmd, profileCreateCmd)
+	rootCmd.AddCommand(profileCmd)
+}
+
+func runProfileList(cmd *cobra.Command, args []string) error {
+	prefs, err := config.LoadPreferences()
+	if err != nil {
+		return fmt.Errorf("failed to load preferences: %w", err)
+	}
+
+	if len(prefs.Profiles) == 0 {
+		fmt.Println("No profiles. Create one with: sprite-bootstrap profile create")
+		return nil
+	}
+
+	names := make([]string, 0, len(prefs.Profiles))
+	for name := range prefs.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Printf("%-20s %s\n", name, prefs.Profiles[name].Tool)
+	}
+	return nil
+}
+
+func runProfileShow(cmd *cobra.Command, args []string) error {
+	prefs, err := config.LoadPreferences()
+	if err != nil {
+		return fmt.Errorf("failed to load preferences: %w", err)
+	}
+
+	profile, ok := prefs.Profiles[args[0]]
+	if !ok {
+		return fmt.Errorf("profile %q not found", args[0])
+	}
+
+	data, err := json.MarshalIndent(profile, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+
+	if err := tools.ValidateProfile(profile); err != nil {
+		fmt.Printf("\n%s⚠%s Profile has problems:\n%v\n", tools.ColorYellow, tools.ColorReset, err)
+	}
+	return nil
+}
+
+func runProfileCreate(cmd *cobra.Command, args []string) error {
+	prefs, err := config.LoadPreferences()
+	if err != nil {
+		return fmt.Errorf("failed to load preferences: %w", err)
+	}
+
+	var name string
+	if len(args) > 0 {
+		name = args[0]
+	}
+
+	toolNames := tools.Names()
+	sort.Strings(toolNames)
+	toolOptions := make([]huh.Option[string], 0, len(toolNames))
+	for _, t := range toolNames {
+		toolOptions = append(toolOptions, huh.NewOption(t, t))
+	}
+
+	var (
+		tool       string
+		extensions string
+		envFile    string
+		dotfiles   string
+		hooks      string
+		remotePath string
+	)
+	if existing, ok := prefs.Profiles[name]; ok {
+		tool = existing.Tool
+		extensions = strings.Join(existing.Extensions, ", ")
+		envFile = existing.EnvFile
+		dotfiles = existing.Dotfiles
+		hooks = strings.Join(existing.PostHooks, "\n")
+		remotePath = existing.RemotePath
+	}
+
+	form := huh.NewForm(
+		huh.NewGroup(
+			huh.NewInput().
+				Title("Profile name").
+				Value(&name).
+				Validate(func(s string) error {
+					if !profileNamePattern.MatchString(s) {
+						return fmt.Errorf("use letters, digits, '-' and '_'")
+					}
+					return nil
+				}),
+			huh.NewSelect[string]().
+				Title("Tool").
+				Options(toolOptions...).
+				Value(&tool),
+		),
+		huh.NewGroup(
+			huh.NewInput().
+				Title("Remote extensions").
+				Description("Comma-separated publisher.name IDs (VS Code only)").
+				Value(&extensions),
+			huh.NewInput().
+				Title("Env file").
+				Description("Local KEY=VALUE file copied to the sprite").
+				Value(&envFile),
+			huh.NewInput().
+				Title("Dotfiles").
+				Description("Git URL, optionally suffixed with #branch").
+				Value(&dotfiles),
+			huh.NewText().
+				Title("Post-hooks").
+				Description("One command per line, run on the sprite after setup").
+				Value(&hooks),
+			huh.NewInput().
+				Title("Remote path").
+				Description("Relative to /home/sprite; may use {sprite}, {org} and {profile}").
+				Value(&remotePath),
+		),
+	)
+	if err := form.Run(); err != nil {
+		return err
+	}
+
+	profile := &config.Profile{
+		Tool:       tool,
+		Extensions: splitList(extensions, ","),
+		EnvFile:    strings.TrimSpace(envFile),
+		Dotfiles:   strings.TrimSpace(dotfiles),
+		PostHooks:  splitList(hooks, "\n"),
+		RemotePath: strings.TrimSpace(remotePath),
+	}
+	if err := tools.ValidateProfile(profile); err != nil {
+		return fmt.Errorf("invalid profile: %w", err)
+	}
+
+	if prefs.Profiles == nil {
+		prefs.Profiles = make(map[string]*config.Profile)
+	}
+	prefs.Profiles[name] = profile
+	if err := config.SavePreferences(prefs); err != nil {
+		return fmt.Errorf("failed to save profile: %w", err)
+	}
+
+	fmt.Printf("%s✓%s Saved profile %s\n", tools.ColorGreen, tools.ColorReset, name)
+	fmt.Printf("  Apply it with: sprite-bootstrap up -s <sprite> --profile %s\n", name)
+	return nil
+}
+
+// splitList splits s on sep, dropping empty entries
+func splitList(s, sep string) []string {
+	var out []string
+	for _, item := range strings.Split(s, sep) {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
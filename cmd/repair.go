@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/vaurdan/sprite-bootstrap/internal/tools"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	repairTool string
+	repairFix  bool
+)
+
+var repairCmd = &cobra.Command{
+	Use:   "repair",
+	Short: "Check a sprite for incomplete tool setup and repair it",
+	Long: `Check the state each tool's setup leaves on a sprite (files, settings,
+ownership) and report anything missing or wrong. With --fix, only the setup
+steps for broken pieces are re-run.
+
+Without --tool, every tool that has been set up on the sprite is checked.
+
+Example:
+  sprite-bootstrap repair -s mysprite
+  sprite-bootstrap repair -s mysprite --tool vscode --fix`,
+	RunE: runRepair,
+}
+
+func init() {
+	repairCmd.Flags().StringVar(&repairTool, "tool", "", "Only check this tool")
+	repairCmd.Flags().BoolVar(&repairFix, "fix", false, "Repair the problems found")
+	rootCmd.AddCommand(repairCmd)
+}
+
+func runRepair(cmd *cobra.Command, args []string) error {
+	if spriteName == "" {
+		return fmt.Errorf("sprite name required (-s)")
+	}
+
+	ctx := context.Background()
+	opts := tools.NewSetupOptions(spriteName, orgName, localPort, resolveRemotePath(remotePath))
+	if err := applyServeHost(ctx, &opts); err != nil {
+		return err
+	}
+	start := time.Now()
+	err := tools.Repair(ctx, opts, repairTool, repairFix)
+	notifyDone(cmd, "Repair", spriteName, start, err)
+	return err
+}
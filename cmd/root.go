@@ -3,20 +3,34 @@ package cmd
 import (
 	"context"
 	"fmt"
+	"os"
 	"path"
 	"strings"
+	"time"
 
-	"sprite-bootstrap/internal/tools"
+	"github.com/vaurdan/sprite-bootstrap/internal/config"
+	"github.com/vaurdan/sprite-bootstrap/internal/sshserver"
+	"github.com/vaurdan/sprite-bootstrap/internal/telemetry"
+	"github.com/vaurdan/sprite-bootstrap/internal/tools"
+	"github.com/vaurdan/sprite-bootstrap/internal/ui"
 
 	"github.com/spf13/cobra"
 )
 
 var (
-	spriteName string
-	orgName    string
-	localPort  int
-	remotePath string
-	version    = "dev"
+	spriteName    string
+	orgName       string
+	localPort     int
+	remotePath    string
+	otelURL       string
+	notify        bool
+	pinSpecs      []string
+	allowUnpinned bool
+	serveHost     string
+	tailscale     bool
+	useStdio      bool
+	noColor       bool
+	version       = "dev"
 )
 
 // SetVersion sets the version string for the CLI
@@ -33,13 +47,34 @@ environments for IDE remote development via SSH.
 
 It runs a local SSH server that proxies connections to sprites.
 Connect using: ssh <sprite-name>@localhost -p <port>`,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		telemetry.Init(telemetry.Endpoint(otelURL), "sprite-bootstrap")
+		if noColor || os.Getenv("NO_COLOR") != "" || !ui.IsInteractive() {
+			tools.DisableColors()
+		}
+		for _, spec := range pinSpecs {
+			if err := tools.SetPin(spec); err != nil {
+				return err
+			}
+		}
+		tools.SetAllowUnpinned(allowUnpinned)
+		return nil
+	},
 }
 
 func init() {
 	rootCmd.PersistentFlags().StringVarP(&spriteName, "sprite", "s", "", "Sprite name")
 	rootCmd.PersistentFlags().StringVarP(&orgName, "org", "o", "", "Organization")
 	rootCmd.PersistentFlags().IntVarP(&localPort, "port", "p", 2222, "Local SSH port")
-	rootCmd.PersistentFlags().StringVar(&remotePath, "path", "", "Remote path (relative to /home/sprite or absolute)")
+	rootCmd.PersistentFlags().StringVar(&remotePath, "path", "", "Remote path (relative to /home/sprite or absolute); a file opens in its directory")
+	rootCmd.PersistentFlags().StringVar(&serveHost, "host", "", "Host the IDE connects to for the SSH server (default localhost)")
+	rootCmd.PersistentFlags().BoolVar(&tailscale, "tailscale", false, "Serve over Tailscale and connect through the tailnet name")
+	rootCmd.PersistentFlags().BoolVar(&useStdio, "stdio", false, "Connect through 'sprite-bootstrap stdio' as the SSH config entry's ProxyCommand, without a background server")
+	rootCmd.PersistentFlags().StringVar(&otelURL, "otel-endpoint", "", "OTLP/HTTP endpoint for tracing (or "+telemetry.EndpointEnv+")")
+	rootCmd.PersistentFlags().BoolVar(&notify, "notify", false, "Ring the bell and show a desktop notification when long operations finish")
+	rootCmd.PersistentFlags().BoolVar(&noColor, "no-color", false, "Don't color output (also set by NO_COLOR, or when output isn't a terminal)")
+	rootCmd.PersistentFlags().StringArrayVar(&pinSpecs, "pin", nil, "Pin a downloaded extension to a version and checksum (id@version=sha256, repeatable)")
+	rootCmd.PersistentFlags().BoolVar(&allowUnpinned, "allow-unpinned", false, "Install extensions without a --pin at their latest version, unverified")
 
 	// Register commands for all tools
 	for _, tool := range tools.All() {
@@ -58,8 +93,32 @@ func resolveRemotePath(p string) string {
 	return path.Join("/home/sprite", p)
 }
 
+// applyServeHost fills in the host the IDE connects to from --host and
+// --tailscale, or --stdio
+func applyServeHost(ctx context.Context, opts *tools.SetupOptions) error {
+	if useStdio && (serveHost != "" || tailscale) {
+		return fmt.Errorf("--stdio can't be combined with --host or --tailscale")
+	}
+	opts.Host, opts.Stdio = serveHost, useStdio
+	if !tailscale {
+		return nil
+	}
+
+	opts.Tailscale = true
+	if opts.Host == "" {
+		host, err := sshserver.TailscaleHost(ctx)
+		if err != nil {
+			return fmt.Errorf("--tailscale: %w", err)
+		}
+		opts.Host = host
+	}
+	return nil
+}
+
 func makeToolCommand(tool tools.Tool) *cobra.Command {
-	return &cobra.Command{
+	var editorArgs string
+	var output summaryFlags
+	cmd := &cobra.Command{
 		Use:   tool.Name(),
 		Short: tool.Description(),
 		RunE: func(cmd *cobra.Command, args []string) error {
@@ -69,11 +128,60 @@ func makeToolCommand(tool tools.Tool) *cobra.Command {
 
 			ctx := context.Background()
 			opts := tools.NewSetupOptions(spriteName, orgName, localPort, resolveRemotePath(remotePath))
-			return tools.Bootstrap(ctx, tool, opts)
+			if err := applyServeHost(ctx, &opts); err != nil {
+				return err
+			}
+			var err error
+			if opts.EditorArgs, err = resolveEditorArgs(cmd, tool.Name(), editorArgs); err != nil {
+				return err
+			}
+
+			start := time.Now()
+			err = output.bootstrap(ctx, tool, opts)
+			notifyDone(cmd, tool.Name()+" setup", spriteName, start, err)
+			return err
 		},
 	}
+	output.register(cmd)
+	if _, ok := tool.(tools.EditorLauncher); ok {
+		cmd.Flags().StringVar(&editorArgs, "editor-args", "", "Extra arguments for the editor launch, split like a shell would (overrides the preference)")
+	}
+	return cmd
+}
+
+// resolveEditorArgs splits --editor-args, or the tool's editor_args
+// preference when the flag isn't given
+func resolveEditorArgs(cmd *cobra.Command, toolName, flag string) ([]string, error) {
+	raw := flag
+	if !cmd.Flags().Changed("editor-args") {
+		if prefs, err := config.LoadPreferences(); err == nil {
+			raw = prefs.EditorArgs[toolName]
+		}
+	}
+	args, err := tools.SplitEditorArgs(raw)
+	if err != nil {
+		return nil, fmt.Errorf("--editor-args: %w", err)
+	}
+	return args, nil
+}
+
+// ExitError makes the process exit with Code. Commands that return it have
+// already reported the outcome.
+type ExitError struct {
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.Code)
 }
 
 func Execute() error {
-	return rootCmd.Execute()
+	err := rootCmd.Execute()
+
+	// Flush any buffered spans before exiting
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	telemetry.Shutdown(ctx)
+
+	return err
 }
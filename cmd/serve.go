@@ -3,19 +3,84 @@ package cmd
 import (
 	"context"
 	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
-	"sprite-bootstrap/internal/sshserver"
+	"github.com/vaurdan/sprite-bootstrap/internal/config"
+	"github.com/vaurdan/sprite-bootstrap/internal/logfile"
+	"github.com/vaurdan/sprite-bootstrap/internal/sshserver"
+	"github.com/vaurdan/sprite-bootstrap/internal/tools"
 
 	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh"
 )
 
 var (
-	listenAddr string
-	hostKeyPath string
+	listenAddr      string
+	hostKeyPaths    []string
+	serveShell      string
+	installTerminfo bool
+	serveConfig     string
+	printConfig     bool
+	listenTailscale bool
+	authorizedKeys  string
+	trustedUserCAs  string
+	principals      []string
+	revokedKeys     string
+	maxFrameSize    int
+	replayBufferKB  int
+	persist         string
+	wsBufferSize    int
+	proxyCompress   bool
+	serveLogFile    string
+	serveLogMaxSize int
+	auditLogPath    string
+	auditLogMaxSize int
+	auditRedact     []string
+	noKbdInteract   bool
+	requirePairing  bool
+	maxConnForwards int
+	maxForwards     int
+	maxAuthTries    int
+	maxRemoteFwds   int
+	allowedShells   []string
+	wrapCommands    []string
+	allowedSockets  []string
+	extraHostKeys   []string
+	defaultEnv      []string
+	noDefaultEnv    bool
+	rawExec         bool
+	keepInterval    time.Duration
+	keepTimeout     time.Duration
+	idleTimeout     time.Duration
+	shutdownGrace   time.Duration
+	fwdIdleTimeout  time.Duration
+	maxLifetime     time.Duration
+	lifetimeWarns   []time.Duration
+	janitorEvery    time.Duration
+	maxConnections  int
+	maxAuthFailures int
+	healthListen    string
+	healthSecret    string
+	healthFailures  int
+	apiCACert       string
+	apiClientCert   string
+	apiClientKey    string
+	apiInsecure     bool
+	ignoreProxyEnv  bool
+	warmProxies     int
+	warmProxyIdle   time.Duration
+	serveLimitRate  string
+	serveLimitUp    string
+	serveLimitDown  string
 )
 
 var serveCmd = &cobra.Command{
@@ -27,22 +92,135 @@ var serveCmd = &cobra.Command{
 Connect using: ssh <sprite-name>@localhost -p <port>
 
 The sprite name is taken from the SSH username. Any SSH key will be accepted
-for authentication - the sprite is looked up by name using your sprites CLI
-credentials.
+for authentication unless --authorized-keys is set - the sprite is looked up
+by name using your sprites CLI credentials, in the current organization and
+then in the others you're logged in to; use sprite@org as the username when
+a name exists in several. When the lookup fails, the client is shown why
+in a banner (except with --authorized-keys). --org serves only that
+organization. The authorized keys file is reloaded when it changes, or on
+SIGHUP, without dropping connections. SIGHUP also reloads the sprites
+credentials, e.g. after 'sprite login' (a rejected token does so too), and
+re-reads --config (see below).
+
+With --health-listen, /healthz answers 503 once --health-failures sprites
+API calls in a row fail or the API rejects the token, and /info describes
+the server as JSON. Set --health-secret-file to require the secret as a
+bearer token, since /info names the organizations served.
+
+With --listen-tailscale the server binds only to this machine's tailnet
+address, keeping the port of --listen, and only accepts keys from
+--authorized-keys (default ~/.ssh/authorized_keys).
+
+Clients can adjust a session by sending these environment variables (e.g.
+ssh -o SetEnv=SPRITE_RETRIES=0); they are not passed on to the sprite:
+
+  SPRITE_RETRIES=<n>     Reconnection attempts, capped at the server's limit
+  SPRITE_SHELL=<path>    Shell to use; must be --shell or listed in --allow-shell
+  SPRITE_KEEPWARM=<bool> Whether the connection keeps the sprite awake (default true)
+  SPRITE_CWD=<path>      Absolute working directory on the sprite
+  SPRITE_WRAPPER=<cmd>   Command wrapper for this session; "none" bypasses --wrap
+  SPRITE_RAW_EXEC=<bool> Run exec commands as argv without the shell (see --raw-exec)
+
+Options can also be read from a YAML or JSON file with --config; flags given
+on the command line take precedence over the file. On SIGHUP the file is
+read again: changes to --limit-rate, --limit-up and --limit-down apply right
+away, and changes to other keys are logged as needing a restart.
 
 Example:
   sprite-bootstrap serve -l :2222
+  sprite-bootstrap serve --config ~/.sprite-bootstrap/serve.yaml
+  sprite-bootstrap serve -l :2222 --listen-tailscale
+  sprite-bootstrap serve --wrap 'mysprite=nix develop --command'
   ssh mysprite@localhost -p 2222`,
 	RunE: runServe,
 }
 
 func init() {
 	serveCmd.Flags().StringVarP(&listenAddr, "listen", "l", ":2222", "Address to listen on")
-	serveCmd.Flags().StringVar(&hostKeyPath, "host-key", "", "Path to host key (auto-generated if not specified)")
+	serveCmd.Flags().StringSliceVar(&hostKeyPaths, "host-key", nil, "Path to a host key: Ed25519, ECDSA or RSA (repeatable, one per key type; auto-generated if not specified)")
+	serveCmd.Flags().StringSliceVar(&extraHostKeys, "extra-host-key", nil, "Additional host key announced to clients for rotation (repeatable)")
+	serveCmd.Flags().StringVar(&serveShell, "shell", "", "Shell to run on the sprite, instead of the sprite user's login shell (falls back to /bin/sh if missing)")
+	serveCmd.Flags().StringArrayVar(&wrapCommands, "wrap", nil, "Run shell and exec requests for a sprite through a command, as SPRITE=COMMAND (* for all sprites; repeatable)")
+	serveCmd.Flags().StringArrayVar(&allowedSockets, "allow-socket", nil, "Unix socket on the sprite clients may forward to, as a path or glob (repeatable; default any)")
+	serveCmd.Flags().StringArrayVar(&defaultEnv, "default-env", nil, "Variable set for every session unless the client sends it, as KEY=VALUE; replaces a built-in default of the same name (repeatable)")
+	serveCmd.Flags().BoolVar(&noDefaultEnv, "no-default-env", false, "Don't set the built-in LANG and LC_ALL defaults in sessions")
+	serveCmd.Flags().BoolVar(&rawExec, "raw-exec", false, "Run exec commands as argv without the shell's -c (no expansion, globs or pipes)")
+	serveCmd.Flags().DurationVar(&keepInterval, "keepalive-interval", 30*time.Second, "How often to probe idle connections and port forwards (0 disables keepalives)")
+	serveCmd.Flags().DurationVar(&keepTimeout, "keepalive-timeout", 0, "How long a keepalive may go unanswered before the connection is closed; must be below the interval (default 20s, or half a shorter interval; 0 disables keepalives)")
+	serveCmd.Flags().DurationVar(&shutdownGrace, "shutdown-grace", 10*time.Second, "How long sessions get to finish after a shutdown warning before their connections are closed")
+	serveCmd.Flags().DurationVar(&idleTimeout, "idle-timeout", 0, "Close sessions with no input or output for this long, e.g. 2h (0 disables)")
+	serveCmd.Flags().DurationVar(&fwdIdleTimeout, "forward-idle-timeout", 0, "Close port forwards with no traffic either way for this long, e.g. 30m (0 disables)")
+	serveCmd.Flags().DurationVar(&maxLifetime, "max-session-lifetime", 0, "End sessions this long after they start, e.g. 8h (0 is unlimited)")
+	serveCmd.Flags().DurationSliceVar(&lifetimeWarns, "session-lifetime-warnings", nil, "When to warn terminals before --max-session-lifetime ends them (default 10m,1m)")
+	serveCmd.Flags().DurationVar(&janitorEvery, "janitor-interval", time.Minute, "How often expired entries are evicted from the server's caches")
+	serveCmd.Flags().IntVar(&maxConnections, "max-connections", 64, "Maximum concurrent SSH connections; more are refused with \"too many connections\" (0 for no limit)")
+	serveCmd.Flags().IntVar(&maxAuthFailures, "max-auth-failures", 10, "Failed sprite lookups per minute from one address before its attempts are refused for a minute (0 for no limit)")
+	serveCmd.Flags().StringSliceVar(&allowedShells, "allow-shell", nil, "Shell clients may request with SPRITE_SHELL (repeatable)")
+	serveCmd.Flags().BoolVar(&installTerminfo, "install-terminfo", false, "Install the client's terminfo entry on sprites that lack it (instead of using xterm-256color)")
+	serveCmd.Flags().StringVar(&serveConfig, "config", "", "Path to a YAML or JSON serve config file")
+	serveCmd.Flags().BoolVar(&listenTailscale, "listen-tailscale", false, "Bind only to the Tailscale address and require --authorized-keys")
+	serveCmd.Flags().StringVar(&authorizedKeys, "authorized-keys", "", "Only accept client keys listed in this authorized_keys file")
+	serveCmd.Flags().StringVar(&trustedUserCAs, "trusted-user-ca-keys", "", "Require user certificates signed by a CA key in this file; plain keys are only accepted from --authorized-keys")
+	serveCmd.Flags().StringSliceVar(&principals, "authorized-principals", nil, "Certificate principals accepted for any sprite, besides the sprite's name (repeatable)")
+	serveCmd.Flags().StringVar(&revokedKeys, "revoked-keys", "", "Reject certificates whose key or CA is listed in this file of public keys")
+	serveCmd.Flags().IntVar(&maxFrameSize, "max-frame-size", 0, "Cap WebSocket message payloads for port forwards, in bytes (0 for no cap; see doctor --network)")
+	serveCmd.Flags().IntVar(&replayBufferKB, "replay-buffer", 64, "KB of recent output kept for each interactive shell and shown again after it reconnects (0 disables)")
+	serveCmd.Flags().StringVar(&persist, "persist", "", "Run interactive shells under tmux or screen on the sprite, so they survive reconnects")
+	serveCmd.Flags().IntVar(&wsBufferSize, "ws-buffer-size", 64*1024, "Read and write buffer size of each port forward's WebSocket, and of the buffers forwarded data is copied through, in bytes")
+	serveCmd.Flags().BoolVar(&proxyCompress, "proxy-compression", false, "Compress port forward traffic to sprites with permessage-deflate; helps text over slow uplinks, adds latency to interactive traffic")
+	serveCmd.Flags().IntVar(&warmProxies, "warm-proxies", 2, "Proxy connections kept open ahead of time for each sprite with recent port forwards (0 disables)")
+	serveCmd.Flags().DurationVar(&warmProxyIdle, "warm-proxy-idle", 30*time.Second, "How long a warm proxy connection is kept unused before it is closed")
+	serveCmd.Flags().StringVar(&serveLimitRate, "limit-rate", "", "Cap the bytes per second all port forwards together send and receive, each way, e.g. 5MB/s (default none)")
+	serveCmd.Flags().StringVar(&serveLimitUp, "limit-up", "", "Cap the bytes per second port forwards send to sprites, overriding --limit-rate")
+	serveCmd.Flags().StringVar(&serveLimitDown, "limit-down", "", "Cap the bytes per second port forwards receive from sprites, overriding --limit-rate")
+	serveCmd.Flags().IntVar(&maxConnForwards, "max-forwards-per-conn", 0, "Maximum concurrent port forwards per SSH connection (0 for no cap)")
+	serveCmd.Flags().IntVar(&maxForwards, "max-forwards", 0, "Maximum concurrent port forwards across the server (0 for no cap)")
+	serveCmd.Flags().IntVar(&maxRemoteFwds, "max-remote-forwards", 10, "Maximum remote (ssh -R) forwards per SSH connection")
+	serveCmd.Flags().BoolVar(&noKbdInteract, "no-keyboard-interactive", false, "Don't accept keyboard-interactive logins from clients without a usable key")
+	serveCmd.Flags().BoolVar(&requirePairing, "require-pairing-code", false, "Ask keyboard-interactive logins for a one-time code from 'sprite-bootstrap pair' (always asked with --authorized-keys or --trusted-user-ca-keys)")
+	serveCmd.Flags().IntVar(&maxAuthTries, "max-auth-tries", 6, "Authentication attempts allowed per connection before it is closed")
+	serveCmd.Flags().StringVar(&apiCACert, "ca-cert", "", "PEM CA bundle to trust for a self-hosted sprites API, besides the system roots (or "+sshserver.EnvCACert+")")
+	serveCmd.Flags().StringVar(&apiClientCert, "client-cert", "", "PEM client certificate for a sprites API that requires mutual TLS (or "+sshserver.EnvClientCert+")")
+	serveCmd.Flags().StringVar(&apiClientKey, "client-key", "", "Key of --client-cert (or "+sshserver.EnvClientKey+")")
+	serveCmd.Flags().BoolVar(&apiInsecure, "insecure-skip-verify", false, "Don't verify the sprites API's certificate; for testing only (or "+sshserver.EnvInsecureSkipVerify+")")
+	serveCmd.Flags().BoolVar(&ignoreProxyEnv, "ignore-proxy-env", false, "Connect to the sprites API directly, ignoring HTTPS_PROXY, HTTP_PROXY and NO_PROXY")
+	serveCmd.Flags().StringVar(&healthListen, "health-listen", "", "Address to serve /healthz and /info on for uptime monitors, e.g. 127.0.0.1:9222 (disabled by default)")
+	serveCmd.Flags().StringVar(&healthSecret, "health-secret-file", "", "File holding a secret health requests must send as \"Authorization: Bearer <secret>\"")
+	serveCmd.Flags().IntVar(&healthFailures, "health-failures", 3, "Failed sprites API calls in a row before /healthz reports 503")
+	serveCmd.Flags().StringVar(&serveLogFile, "log-file", "", "Also write logs to this file, rotated by size with the last 3 kept as .1 to .3 (background servers use <state dir>/logs/serve-<port>.log)")
+	serveCmd.Flags().IntVar(&serveLogMaxSize, "log-max-size", 10, "Size in MB at which --log-file is rotated (0 never rotates)")
+	serveCmd.Flags().StringVar(&auditLogPath, "audit-log", "", "Append every exec and shell request to this file as JSON lines, rotated like --log-file")
+	serveCmd.Flags().IntVar(&auditLogMaxSize, "audit-log-max-size", 10, "Size in MB at which --audit-log is rotated (0 never rotates)")
+	serveCmd.Flags().StringArrayVar(&auditRedact, "audit-redact", nil, "Regular expression whose matches are masked in --audit-log commands; with groups, only the groups are masked (repeatable)")
+	serveCmd.Flags().BoolVar(&printConfig, "print-config", false, "Print the effective configuration and exit")
 	rootCmd.AddCommand(serveCmd)
 }
 
 func runServe(cmd *cobra.Command, args []string) error {
+	var fileConfig *config.ServeConfig
+	explicit := explicitFlags(cmd)
+	if serveConfig != "" {
+		var err error
+		if fileConfig, err = applyServeConfig(cmd, serveConfig); err != nil {
+			return err
+		}
+	}
+	if printConfig {
+		return printServeConfig(cmd)
+	}
+
+	if serveLogFile != "" {
+		w, err := logfile.Open(serveLogFile, int64(serveLogMaxSize)<<20, logfile.DefaultBackups)
+		if err != nil {
+			return fmt.Errorf("failed to open log file: %w", err)
+		}
+		defer w.Close()
+		// slog's default handler writes through the log package. Stderr
+		// keeps everything too: in the background it is the run's own log,
+		// which start failures are excerpted from.
+		log.SetOutput(io.MultiWriter(os.Stderr, w))
+	}
+
 	// Resolve token from sprites config
 	tokenOpts := &sshserver.TokenOptions{
 		Organization: orgName,
@@ -51,19 +229,161 @@ func runServe(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("failed to resolve sprites credentials: %w\nRun 'sprite login' first", err)
 	}
 
-	// Load or generate host key
-	hostKey, err := sshserver.LoadOrGenerateHostKey(hostKeyPath)
+	// Without --org, sprites from any configured organization are served
+	var searchOrgs []string
+	if orgName == "" {
+		var err error
+		if searchOrgs, err = sshserver.ConfiguredOrgs(tokenOpts.API); err != nil {
+			slog.Warn("Failed to list organizations, serving only the current one", "exception", err)
+		}
+	}
+
+	// Load or generate the first host key; further ones must exist
+	var firstHostKey string
+	if len(hostKeyPaths) > 0 {
+		firstHostKey = hostKeyPaths[0]
+	}
+	hostKey, err := sshserver.LoadOrGenerateHostKey(firstHostKey)
 	if err != nil {
 		return fmt.Errorf("failed to load host key: %w", err)
 	}
 
+	var moreKeys []ssh.Signer
+	for _, path := range hostKeyPaths[min(1, len(hostKeyPaths)):] {
+		key, err := sshserver.LoadHostKey(path)
+		if err != nil {
+			return fmt.Errorf("failed to load host key %s: %w", path, err)
+		}
+		moreKeys = append(moreKeys, key)
+	}
+
+	var extraKeys []ssh.Signer
+	for _, path := range extraHostKeys {
+		key, err := sshserver.LoadHostKey(path)
+		if err != nil {
+			return fmt.Errorf("failed to load extra host key %s: %w", path, err)
+		}
+		extraKeys = append(extraKeys, key)
+	}
+	if len(hostKeyPaths) == 0 {
+		hostKey, extraKeys = applyHostKeyRotation(hostKey, extraKeys)
+	}
+
+	wrappers := make(map[string]string)
+	for _, spec := range wrapCommands {
+		sprite, command, ok := strings.Cut(spec, "=")
+		if !ok || sprite == "" {
+			return fmt.Errorf("invalid --wrap %q (expected SPRITE=COMMAND)", spec)
+		}
+		wrappers[sprite] = command
+	}
+
+	if listenTailscale {
+		addr, err := tailscaleListenAddr(listenAddr)
+		if err != nil {
+			return err
+		}
+		listenAddr = addr
+		if authorizedKeys == "" && trustedUserCAs == "" {
+			if authorizedKeys, err = sshserver.DefaultAuthorizedKeysPath(); err != nil {
+				return err
+			}
+		}
+	}
+
+	var authKeys *sshserver.AuthorizedKeys
+	if authorizedKeys != "" {
+		authKeys, err = sshserver.LoadAuthorizedKeys(authorizedKeys)
+		if err != nil {
+			return fmt.Errorf("failed to load authorized keys: %w", err)
+		}
+	}
+
+	var userCAs, revoked *sshserver.AuthorizedKeys
+	if trustedUserCAs != "" {
+		if userCAs, err = sshserver.LoadTrustedUserCAKeys(trustedUserCAs); err != nil {
+			return fmt.Errorf("failed to load trusted user CA keys: %w", err)
+		}
+	}
+	if revokedKeys != "" {
+		if userCAs == nil {
+			return fmt.Errorf("--revoked-keys needs --trusted-user-ca-keys")
+		}
+		if revoked, err = sshserver.LoadRevokedKeys(revokedKeys); err != nil {
+			return fmt.Errorf("failed to load revoked keys: %w", err)
+		}
+	}
+
+	tlsOpts, err := apiTLSOptions(cmd)
+	if err != nil {
+		return err
+	}
+
+	limitUp, limitDown, err := rateLimitFlags(serveLimitRate, serveLimitUp, serveLimitDown)
+	if err != nil {
+		return err
+	}
+
+	redact, err := sshserver.RedactPatterns(auditRedact)
+	if err != nil {
+		return fmt.Errorf("--audit-redact: %w", err)
+	}
+
 	// Create server
 	srv, err := sshserver.NewServer(&sshserver.ServerConfig{
-		ListenAddr:    listenAddr,
-		HostKey:       hostKey,
-		TokenOptions:  tokenOpts,
-		MaxRetries:    5,
-		SocketTimeout: 10 * time.Second,
+		ListenAddr:      listenAddr,
+		HostKey:         hostKey,
+		MoreHostKeys:    moreKeys,
+		ExtraHostKeys:   extraKeys,
+		TokenOptions:    tokenOpts,
+		MaxRetries:      5,
+		SocketTimeout:   10 * time.Second,
+		Shell:           serveShell,
+		InstallTerminfo: installTerminfo,
+		AuthorizedKeys:  authKeys,
+		SearchOrgs:      searchOrgs,
+		MaxFrameSize:    maxFrameSize,
+		ReplayBuffer:    replayBufferFlag(replayBufferKB),
+		Persist:         persist,
+		AllowedShells:   allowedShells,
+
+		TrustedUserCAKeys:    userCAs,
+		AuthorizedPrincipals: principals,
+		RevokedKeys:          revoked,
+
+		WebSocketBufferSize: wsBufferSize,
+		EnableCompression:   proxyCompress,
+		WarmProxies:         warmProxiesFlag(warmProxies),
+		WarmProxyIdle:       warmProxyIdle,
+		LimitUp:             limitUp,
+		LimitDown:           limitDown,
+		MaxForwardsPerConn:  maxConnForwards,
+		MaxForwards:         maxForwards,
+		MaxAuthTries:        maxAuthTries,
+		RequirePairingCode:  requirePairing,
+		MaxRemoteForwards:   maxRemoteFwds,
+		CommandWrappers:     wrappers,
+		AllowedSockets:      allowedSockets,
+		DefaultEnv:          defaultEnv,
+		NoBuiltinEnv:        noDefaultEnv,
+		RawExec:             rawExec,
+		KeepaliveInterval:   keepaliveFlag(keepInterval),
+		KeepaliveTimeout:    keepaliveTimeoutFlag(cmd),
+		IdleTimeout:         idleTimeout,
+		ShutdownGrace:       shutdownGrace,
+		AuditLogPath:        auditLogPath,
+		AuditLogMaxSize:     int64(auditLogMaxSize) << 20,
+		AuditRedact:         redact,
+		ForwardIdleTimeout:  fwdIdleTimeout,
+		MaxSessionLifetime:  maxLifetime,
+		LifetimeWarnings:    lifetimeWarns,
+		JanitorInterval:     janitorEvery,
+		MaxConnections:      maxConnections,
+		MaxAuthFailures:     authFailuresFlag(maxAuthFailures),
+		TLSOptions:          tlsOpts,
+		IgnoreProxyEnv:      ignoreProxyEnv,
+
+		DisableKeyboardInteractive: noKbdInteract,
 	})
 	if err != nil {
 		return fmt.Errorf("failed to create server: %w", err)
@@ -78,11 +398,89 @@ func runServe(cmd *cobra.Command, args []string) error {
 
 	listener, err := sshserver.Bind(bindCtx, listenAddr)
 	if err != nil {
-		return fmt.Errorf("failed to bind to %s: %w\n\nIs another service using this port? Try a different port with -l flag", listenAddr, err)
+		return err
 	}
 
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	if !listenTailscale {
+		host = "localhost"
+	}
 	fmt.Printf("SSH server listening on %s\n", listener.Addr().String())
-	fmt.Printf("Connect with: ssh <sprite-name>@localhost -p %s\n", listenAddr[1:])
+	fmt.Printf("Connect with: ssh <sprite-name>@%s -p %s\n", host, port)
+	if authKeys != nil {
+		fmt.Printf("Accepting %d key(s) from %s\n", authKeys.Len(), authorizedKeys)
+	}
+	if userCAs != nil {
+		fmt.Printf("Accepting certificates from %d CA(s) in %s\n", userCAs.Len(), trustedUserCAs)
+	}
+
+	// Write a debug dump of the server state on SIGUSR1
+	dumpCh := make(chan os.Signal, 1)
+	notifyDump(dumpCh)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-dumpCh:
+				_ = config.EnsureRuntimeDir()
+				path, err := srv.WriteDump(config.RuntimeDir())
+				if err != nil {
+					slog.Error("Failed to write debug dump", "exception", err)
+					continue
+				}
+				slog.Info("Wrote debug dump", "path", path)
+			}
+		}
+	}()
+
+	// Reload sprites credentials on SIGHUP, e.g. right after "sprite login";
+	// a rejected token also makes the server reload them on its own
+	credsCh := make(chan os.Signal, 1)
+	notifyReload(credsCh)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-credsCh:
+				srv.ReloadCredentials(ctx, "SIGHUP")
+			}
+		}
+	}()
+
+	// Re-read the config file on SIGHUP
+	if fileConfig != nil {
+		reloadCh := make(chan os.Signal, 1)
+		notifyReload(reloadCh)
+		go newServeConfigReloader(cmd, srv, fileConfig, explicit).Watch(ctx, serveConfig, reloadCh)
+	}
+
+	// Reload key files when they change or on SIGHUP
+	for _, keys := range []*sshserver.AuthorizedKeys{authKeys, userCAs, revoked} {
+		if keys != nil {
+			reloadCh := make(chan os.Signal, 1)
+			notifyReload(reloadCh)
+			go keys.Watch(ctx, reloadCh)
+		}
+	}
+
+	if healthListen != "" {
+		addr, err := serveHealth(ctx, srv, listener.Addr().String())
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Health endpoints on http://%s/healthz and /info\n", addr)
+	}
+
+	// Let the sessions and forwards commands list and end connections,
+	// close forwards, change rate limits and ask for debug dumps
+	if err := serveControl(ctx, srv.ControlHandler(config.RuntimeDir())); err != nil {
+		slog.Warn("Failed to open the control socket; 'sessions' and 'forwards' won't reach this server", "exception", err)
+	}
+
+	// Publish the connection count and forwards for status and forwards
+	go publishServeStats(ctx, srv)
 
 	// Handle shutdown signals
 	go func() {
@@ -93,7 +491,8 @@ func runServe(cmd *cobra.Command, args []string) error {
 		cancel()
 	}()
 
-	// Serve
+	// Serve until a shutdown signal cancels ctx. Connections outlive it so
+	// that Shutdown can give them the grace period.
 	serverErr := make(chan error, 1)
 	go func() {
 		serverErr <- srv.Serve(ctx, listener)
@@ -101,14 +500,216 @@ func runServe(cmd *cobra.Command, args []string) error {
 
 	select {
 	case <-ctx.Done():
-		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
-		defer shutdownCancel()
-		srv.Shutdown(shutdownCtx)
-		return nil
 	case err := <-serverErr:
-		if err != nil && ctx.Err() == nil {
+		if ctx.Err() == nil {
 			return fmt.Errorf("server error: %w", err)
 		}
-		return nil
 	}
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), shutdownGrace+5*time.Second)
+	defer shutdownCancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		slog.Warn("Connections were still open at exit", "exception", err)
+	}
+	return nil
+}
+
+// tailscaleListenAddr swaps the host of addr for this machine's tailnet
+// address, refusing to fall back to a wider bind
+func tailscaleListenAddr(addr string) (string, error) {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", fmt.Errorf("invalid listen address %q: %w", addr, err)
+	}
+
+	ip, err := sshserver.TailscaleAddr()
+	if err != nil {
+		return "", fmt.Errorf("--listen-tailscale: %w", err)
+	}
+	return net.JoinHostPort(ip.String(), port), nil
+}
+
+// keepaliveFlag maps a keepalive flag to ServerConfig, where 0 means the
+// default and a negative value disables keepalives
+func keepaliveFlag(d time.Duration) time.Duration {
+	if d == 0 {
+		return -1
+	}
+	return d
+}
+
+// keepaliveTimeoutFlag is keepaliveFlag for --keepalive-timeout. Left
+// unset, the server picks a timeout that fits the interval.
+func keepaliveTimeoutFlag(cmd *cobra.Command) time.Duration {
+	if !cmd.Flags().Changed("keepalive-timeout") {
+		return 0
+	}
+	return keepaliveFlag(keepTimeout)
+}
+
+// publishServeStats writes the server's live stats for status until ctx ends
+func publishServeStats(ctx context.Context, srv *sshserver.Server) {
+	if err := config.EnsureRuntimeDir(); err != nil {
+		return
+	}
+	ticker := time.NewTicker(tools.ServeStatsInterval)
+	defer ticker.Stop()
+
+	for {
+		stats := &tools.ServeStats{
+			PID:            os.Getpid(),
+			Connections:    srv.Connections(),
+			MaxConnections: srv.MaxConnections(),
+			UpdatedAt:      time.Now(),
+			Forwards:       serveForwards(srv),
+		}
+		if err := tools.SaveServeStats(stats); err != nil {
+			slog.Debug("Failed to write serve stats", "exception", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// serveForwards describes the server's forwards for the forwards command
+func serveForwards(srv *sshserver.Server) []tools.ForwardInfo {
+	var forwards []tools.ForwardInfo
+	for _, f := range srv.Forwards() {
+		forwards = append(forwards, tools.ForwardInfo{
+			ID:        f.ID,
+			Source:    tools.ForwardSourceSSH,
+			Sprite:    f.Sprite,
+			Kind:      f.Kind,
+			Local:     f.Origin,
+			Remote:    f.Dest,
+			Started:   f.Started,
+			BytesIn:   f.BytesIn,
+			BytesOut:  f.BytesOut,
+			PID:       os.Getpid(),
+			LimitUp:   f.LimitUp,
+			LimitDown: f.LimitDown,
+		})
+	}
+	return forwards
+}
+
+// serveHealth starts the --health-listen listener and returns its address.
+// It runs until ctx ends.
+func serveHealth(ctx context.Context, srv *sshserver.Server, sshAddr string) (string, error) {
+	var secret string
+	if healthSecret != "" {
+		data, err := os.ReadFile(healthSecret)
+		if err != nil {
+			return "", fmt.Errorf("failed to read health secret: %w", err)
+		}
+		if secret = strings.TrimSpace(string(data)); secret == "" {
+			return "", fmt.Errorf("health secret file %s is empty", healthSecret)
+		}
+	}
+
+	l, err := net.Listen("tcp", healthListen)
+	if err != nil {
+		return "", fmt.Errorf("failed to listen for health checks: %w", err)
+	}
+
+	handler := srv.HealthHandler(sshserver.HealthOptions{
+		Version:     version,
+		Started:     time.Now(),
+		Listen:      []string{sshAddr, l.Addr().String()},
+		MaxFailures: healthFailures,
+		Secret:      secret,
+	})
+	server := &http.Server{Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := server.Serve(l); err != nil && err != http.ErrServerClosed {
+			slog.Error("Health listener failed", "exception", err)
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		_ = server.Close()
+	}()
+	go srv.ProbeAPI(ctx)
+	return l.Addr().String(), nil
+}
+
+// serveControl serves a control API on this process's socket in the
+// runtime directory until ctx ends
+func serveControl(ctx context.Context, handler http.Handler) error {
+	if err := config.EnsureRuntimeDir(); err != nil {
+		return err
+	}
+	l, err := sshserver.ListenControl(tools.ControlSocket(os.Getpid()))
+	if err != nil {
+		return err
+	}
+
+	// Closing the listener removes the socket
+	server := &http.Server{Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := server.Serve(l); err != nil && err != http.ErrServerClosed {
+			slog.Error("Control socket failed", "exception", err)
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		_ = server.Close()
+	}()
+	return nil
+}
+
+// apiTLSOptions reads the TLS options for the sprites API from the
+// environment, with flags taking precedence
+func apiTLSOptions(cmd *cobra.Command) (sshserver.TLSOptions, error) {
+	opts, err := sshserver.TLSOptionsFromEnv()
+	if err != nil {
+		return opts, err
+	}
+	flags := cmd.Flags()
+	if flags.Changed("ca-cert") {
+		opts.CACertFile = apiCACert
+	}
+	if flags.Changed("client-cert") {
+		opts.ClientCertFile = apiClientCert
+	}
+	if flags.Changed("client-key") {
+		opts.ClientKeyFile = apiClientKey
+	}
+	if flags.Changed("insecure-skip-verify") {
+		opts.InsecureSkipVerify = apiInsecure
+	}
+	if opts.InsecureSkipVerify {
+		slog.Warn("Not verifying the sprites API's TLS certificate")
+	}
+	return opts, nil
+}
+
+// authFailuresFlag maps --max-auth-failures to ServerConfig, where 0 means
+// the default and a negative value disables the limit
+func authFailuresFlag(n int) int {
+	if n == 0 {
+		return -1
+	}
+	return n
+}
+
+// replayBufferFlag maps --replay-buffer, in KB, to ServerConfig, where 0
+// means the default and a negative value disables replay
+func replayBufferFlag(kb int) int {
+	if kb <= 0 {
+		return -1
+	}
+	return kb * 1024
+}
+
+// warmProxiesFlag maps --warm-proxies to ServerConfig, where 0 means the
+// default and a negative value disables warm proxy connections
+func warmProxiesFlag(n int) int {
+	if n == 0 {
+		return -1
+	}
+	return n
 }
@@ -0,0 +1,228 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strconv"
+
+	"github.com/vaurdan/sprite-bootstrap/internal/config"
+	"github.com/vaurdan/sprite-bootstrap/internal/sshserver"
+	"github.com/vaurdan/sprite-bootstrap/internal/tools"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// serveConfigSkip lists flags that can't be set from the config file
+var serveConfigSkip = map[string]bool{
+	"config":       true,
+	"print-config": true,
+	"help":         true,
+}
+
+// serveConfigReloadable lists the keys a running server applies when the
+// config file is re-read on SIGHUP; changing any other key needs a restart
+var serveConfigReloadable = map[string]bool{
+	"limit-rate": true,
+	"limit-up":   true,
+	"limit-down": true,
+}
+
+// applyServeConfig loads the serve config file and sets every flag the user
+// didn't pass explicitly, so command-line flags always take precedence
+func applyServeConfig(cmd *cobra.Command, path string) (*config.ServeConfig, error) {
+	cfg, err := config.LoadServeConfig(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load serve config: %w", err)
+	}
+
+	flags := cmd.Flags()
+	for _, key := range cfg.Keys() {
+		values := cfg.Values[key]
+
+		f := flags.Lookup(key)
+		if f == nil || serveConfigSkip[key] {
+			fmt.Fprintf(os.Stderr, "%s⚠ Unknown key %q in %s (ignored)%s\n", tools.ColorYellow, key, cfg.Path, tools.ColorReset)
+			continue
+		}
+		if f.Changed {
+			continue
+		}
+
+		if slice, ok := f.Value.(pflag.SliceValue); ok {
+			if err := slice.Replace(values); err != nil {
+				return nil, fmt.Errorf("%s: invalid value for %s: %w", cfg.Path, key, err)
+			}
+			f.Changed = true
+			continue
+		}
+		if len(values) != 1 {
+			return nil, fmt.Errorf("%s: %s takes a single value, got %d", cfg.Path, key, len(values))
+		}
+		if err := flags.Set(key, values[0]); err != nil {
+			return nil, fmt.Errorf("%s: invalid value for %s: %w", cfg.Path, key, err)
+		}
+	}
+	return cfg, nil
+}
+
+// serveConfigReloader re-reads the serve config file of a running server
+type serveConfigReloader struct {
+	cmd      *cobra.Command
+	srv      *sshserver.Server
+	explicit map[string]bool     // Flags from the command line, which win over the file
+	running  map[string][]string // File values the server runs with
+}
+
+// newServeConfigReloader starts from cfg, the file as loaded at startup.
+// explicit names the flags given on the command line.
+func newServeConfigReloader(cmd *cobra.Command, srv *sshserver.Server, cfg *config.ServeConfig, explicit map[string]bool) *serveConfigReloader {
+	return &serveConfigReloader{cmd: cmd, srv: srv, explicit: explicit, running: cfg.Values}
+}
+
+// explicitFlags returns the names of the flags given on the command line.
+// It must be called before the config file is applied.
+func explicitFlags(cmd *cobra.Command) map[string]bool {
+	explicit := make(map[string]bool)
+	cmd.Flags().Visit(func(f *pflag.Flag) {
+		explicit[f.Name] = true
+	})
+	return explicit
+}
+
+// Watch reloads the config file each time reload receives, until ctx ends
+func (r *serveConfigReloader) Watch(ctx context.Context, path string, reload <-chan os.Signal) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-reload:
+			applied, restart, err := r.Reload(path)
+			if err != nil {
+				slog.Error("Failed to reload serve config, keeping the current settings", "path", path, "exception", err)
+				continue
+			}
+			slog.Info("Reloaded serve config", "path", path, "changed", applied)
+			if len(restart) > 0 {
+				slog.Warn("Serve config changes need a restart to take effect", "path", path, "keys", restart)
+			}
+		}
+	}
+}
+
+// Reload re-reads the config file and applies the reloadable keys that
+// changed, which it returns as applied. Keys also given on the command line
+// are left alone. Changed keys that can't be applied are returned as
+// restart, on every reload until the server is restarted.
+func (r *serveConfigReloader) Reload(path string) (applied, restart []string, err error) {
+	cfg, err := config.LoadServeConfig(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keys := make(map[string]bool)
+	for k := range r.running {
+		keys[k] = true
+	}
+	for k := range cfg.Values {
+		keys[k] = true
+	}
+	sorted := make([]string, 0, len(keys))
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	slices.Sort(sorted)
+
+	next := make(map[string][]string, len(r.running))
+	for k, v := range r.running {
+		next[k] = v
+	}
+	for _, key := range sorted {
+		if serveConfigSkip[key] || r.cmd.Flags().Lookup(key) == nil {
+			if _, ok := cfg.Values[key]; ok {
+				slog.Warn("Unknown key in serve config (ignored)", "path", cfg.Path, "key", key)
+			}
+			continue
+		}
+		if r.explicit[key] {
+			continue
+		}
+		if slices.Equal(r.running[key], cfg.Values[key]) {
+			continue
+		}
+		if !serveConfigReloadable[key] {
+			restart = append(restart, key)
+			continue
+		}
+		applied = append(applied, key)
+		if v, ok := cfg.Values[key]; ok {
+			next[key] = v
+		} else {
+			delete(next, key)
+		}
+	}
+
+	if len(applied) > 0 {
+		up, down, err := rateLimitFlags(r.value(next, "limit-rate"), r.value(next, "limit-up"), r.value(next, "limit-down"))
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", cfg.Path, err)
+		}
+		r.srv.SetRateLimits(up, down)
+		r.running = next
+	}
+	return applied, restart, nil
+}
+
+// value returns the effective value of a single-valued flag given the
+// file values: the command line's, else the file's, else the default
+func (r *serveConfigReloader) value(file map[string][]string, key string) string {
+	f := r.cmd.Flags().Lookup(key)
+	if r.explicit[key] {
+		return f.Value.String()
+	}
+	if v := file[key]; len(v) == 1 {
+		return v[0]
+	}
+	return f.DefValue
+}
+
+// printServeConfig writes the effective serve configuration as JSON, in a
+// form that can be saved and passed back with --config
+func printServeConfig(cmd *cobra.Command) error {
+	effective := make(map[string]any)
+	cmd.Flags().VisitAll(func(f *pflag.Flag) {
+		if serveConfigSkip[f.Name] {
+			return
+		}
+		if slice, ok := f.Value.(pflag.SliceValue); ok {
+			effective[f.Name] = slice.GetSlice()
+			return
+		}
+
+		value := f.Value.String()
+		switch f.Value.Type() {
+		case "bool":
+			if b, err := strconv.ParseBool(value); err == nil {
+				effective[f.Name] = b
+				return
+			}
+		case "int", "int64", "uint32":
+			if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+				effective[f.Name] = n
+				return
+			}
+		}
+		effective[f.Name] = value
+	})
+
+	data, err := json.MarshalIndent(effective, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(cmd.OutOrStdout(), string(data))
+	return nil
+}
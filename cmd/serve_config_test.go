@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/vaurdan/sprite-bootstrap/internal/ratelimit"
+	"github.com/vaurdan/sprite-bootstrap/internal/sshserver"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestServeConfigReload(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostKey, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv, err := sshserver.NewServer(&sshserver.ServerConfig{
+		HostKey:      hostKey,
+		TokenOptions: &sshserver.TokenOptions{API: "http://127.0.0.1:1", AuthToken: "test"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := &cobra.Command{}
+	for _, name := range []string{"limit-rate", "limit-up", "limit-down", "shell", "listen"} {
+		cmd.Flags().String(name, "", "")
+	}
+	if err := cmd.Flags().Set("limit-down", "2MB/s"); err != nil {
+		t.Fatal(err)
+	}
+	explicit := explicitFlags(cmd)
+
+	path := filepath.Join(t.TempDir(), "serve.yaml")
+	write := func(content string) {
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("limit-rate: 1MB/s\nshell: /bin/sh\n")
+	cfg, err := applyServeConfig(cmd, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := newServeConfigReloader(cmd, srv, cfg, explicit)
+
+	mb := func(s string) int64 {
+		n, err := ratelimit.Parse(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	tests := []struct {
+		file           string
+		applied        []string
+		restart        []string
+		wantErr        bool
+		wantUp, wantDn int64
+	}{
+		// limit-down was given on the command line, so the file can't change it
+		{"limit-rate: 3MB/s\nlimit-down: 9MB/s\nshell: /bin/sh\n", []string{"limit-rate"}, nil, false, mb("3MB/s"), mb("2MB/s")},
+		{"limit-rate: 3MB/s\nshell: /bin/zsh\nlisten: :2223\n", nil, []string{"listen", "shell"}, false, mb("3MB/s"), mb("2MB/s")},
+		// The restart is still needed; removing limit-rate lifts the limit
+		{"limit-up: 4MB/s\nshell: /bin/zsh\nlisten: :2223\n", []string{"limit-rate", "limit-up"}, []string{"listen", "shell"}, false, mb("4MB/s"), mb("2MB/s")},
+		{"limit-up: fast\n", nil, nil, true, mb("4MB/s"), mb("2MB/s")},
+		{"limit-up: [", nil, nil, true, mb("4MB/s"), mb("2MB/s")},
+	}
+	for i, tt := range tests {
+		write(tt.file)
+		applied, restart, err := r.Reload(path)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("#%d: Reload() error = %v, want error %v", i, err, tt.wantErr)
+		}
+		if !slices.Equal(applied, tt.applied) || !slices.Equal(restart, tt.restart) {
+			t.Errorf("#%d: Reload() = %v, %v; want %v, %v", i, applied, restart, tt.applied, tt.restart)
+		}
+		if up, down := srv.RateLimits(); up != tt.wantUp || down != tt.wantDn {
+			t.Errorf("#%d: RateLimits() = %d, %d; want %d, %d", i, up, down, tt.wantUp, tt.wantDn)
+		}
+	}
+}
@@ -0,0 +1,20 @@
+//go:build !windows
+
+package cmd
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// notifyDump relays SIGUSR1, which asks serve for a debug dump
+func notifyDump(ch chan<- os.Signal) {
+	signal.Notify(ch, syscall.SIGUSR1)
+}
+
+// notifyReload relays SIGHUP, which asks serve to reload its key files and
+// sprites credentials
+func notifyReload(ch chan<- os.Signal) {
+	signal.Notify(ch, syscall.SIGHUP)
+}
@@ -0,0 +1,12 @@
+//go:build windows
+
+package cmd
+
+import "os"
+
+// notifyDump is a no-op: Windows has no SIGUSR1
+func notifyDump(ch chan<- os.Signal) {}
+
+// notifyReload is a no-op: Windows has no SIGHUP. Key files are still
+// reloaded when they change.
+func notifyReload(ch chan<- os.Signal) {}
@@ -0,0 +1,111 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/vaurdan/sprite-bootstrap/internal/sshserver"
+	"github.com/vaurdan/sprite-bootstrap/internal/tools"
+
+	"github.com/spf13/cobra"
+)
+
+// sessionIDWidth is how much of a connection ID the table shows
+const sessionIDWidth = 12
+
+var sessionsJSON bool
+
+var sessionsCmd = &cobra.Command{
+	Use:   "sessions",
+	Short: "List and end SSH connections to the server",
+	Long: `List the SSH connections to the background server: who is connected to
+which sprite, since when, how many sessions and forwards each has open and
+how much traffic it has moved.
+
+'sessions kill' ends a connection with everything running over it, for a
+client that is stuck without restarting the server. IDs can be shortened
+to any unique prefix.`,
+	Args: cobra.NoArgs,
+	RunE: runSessions,
+}
+
+var sessionsKillCmd = &cobra.Command{
+	Use:   "kill <id>",
+	Short: "End an SSH connection",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runSessionsKill,
+}
+
+var sessionsDumpCmd = &cobra.Command{
+	Use:   "dump",
+	Short: "Write a debug dump of the server's state",
+	Long: `Ask the background server to write a JSON snapshot of its state, the same
+as SIGUSR1 does, to the runtime directory, and print its path.`,
+	Args: cobra.NoArgs,
+	RunE: runSessionsDump,
+}
+
+func init() {
+	sessionsCmd.Flags().BoolVar(&sessionsJSON, "json", false, "Print the sessions as JSON")
+	sessionsCmd.AddCommand(sessionsKillCmd, sessionsDumpCmd)
+	rootCmd.AddCommand(sessionsCmd)
+}
+
+func runSessions(cmd *cobra.Command, args []string) error {
+	sessions, err := tools.ListSessions()
+	if err != nil {
+		return err
+	}
+	if sessionsJSON {
+		if sessions == nil {
+			sessions = []sshserver.ConnSnapshot{}
+		}
+		return writeJSON(os.Stdout, sessions)
+	}
+
+	if len(sessions) == 0 {
+		fmt.Println("No SSH connections")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSPRITE\tREMOTE\tSESSIONS\tFORWARDS\tIN/OUT\tAGE")
+	for _, s := range sessions {
+		id := s.ID
+		if len(id) > sessionIDWidth {
+			id = id[:sessionIDWidth]
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%s/%s\t%s\n",
+			id, s.Sprite, s.Remote, s.Sessions, s.Forwards+s.RemoteForwards,
+			formatBytes(s.BytesIn), formatBytes(s.BytesOut),
+			time.Since(s.Started).Round(time.Second))
+	}
+	return w.Flush()
+}
+
+func runSessionsKill(cmd *cobra.Command, args []string) error {
+	sessions, err := tools.ListSessions()
+	if err != nil {
+		return err
+	}
+	s, err := tools.FindSession(sessions, args[0])
+	if err != nil {
+		return err
+	}
+	if err := tools.KillSession(s.ID); err != nil {
+		return err
+	}
+	fmt.Printf("%s✓%s Ended connection %s (%s from %s)\n", tools.ColorGreen, tools.ColorReset, s.ID[:min(len(s.ID), sessionIDWidth)], s.Sprite, s.Remote)
+	return nil
+}
+
+func runSessionsDump(cmd *cobra.Command, args []string) error {
+	path, err := tools.WriteDump()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s✓%s Wrote debug dump to %s\n", tools.ColorGreen, tools.ColorReset, path)
+	return nil
+}
@@ -2,8 +2,11 @@ package cmd
 
 import (
 	"fmt"
+	"os"
+	"time"
 
-	"sprite-bootstrap/internal/tools"
+	"github.com/vaurdan/sprite-bootstrap/internal/config"
+	"github.com/vaurdan/sprite-bootstrap/internal/tools"
 
 	"github.com/spf13/cobra"
 )
@@ -11,24 +14,95 @@ import (
 var statusCmd = &cobra.Command{
 	Use:   "status",
 	Short: "Show SSH server status",
-	Long:  `Display the current status of the SSH server.`,
-	RunE:  runStatus,
+	Long: `Display the current status of the SSH server.
+
+With --json the status is printed as JSON. --format takes a Go template over
+the same fields, e.g. --format '{{.Running}} {{.Port}}'; {{json .}} prints a
+value as JSON. Fields:
+
+  .Running         Whether the server is running
+  .PID             Process ID (0 when not running)
+  .Port            Port the server listens on
+  .StartedAt       When the server was started (zero if unknown)
+  .Connections     SSH connections being served (-1 if unknown)
+  .MaxConnections  The server's connection limit (0 for none)
+  .LogFile         The server's log file
+  .RuntimeDir      Where the PID file, log and metadata are kept`,
+	RunE: runStatus,
 }
 
+var (
+	statusJSON   bool
+	statusFormat string
+)
+
 func init() {
+	statusCmd.Flags().BoolVar(&statusJSON, "json", false, "Print the status as JSON")
+	statusCmd.Flags().StringVar(&statusFormat, "format", "", "Print the status with a Go template")
+	statusCmd.MarkFlagsMutuallyExclusive("json", "format")
 	rootCmd.AddCommand(statusCmd)
 }
 
+// serveStatus is the structured status used by --json and --format
+type serveStatus struct {
+	Running        bool      `json:"running"`
+	PID            int       `json:"pid"`
+	Port           int       `json:"port"`
+	StartedAt      time.Time `json:"started_at,omitzero"`
+	Connections    int       `json:"connections"`
+	MaxConnections int       `json:"max_connections"`
+	LogFile        string    `json:"log_file"`
+	RuntimeDir     string    `json:"runtime_dir"`
+}
+
+// currentServeStatus collects the status of the background server
+func currentServeStatus() serveStatus {
+	st := serveStatus{
+		Port:       localPort,
+		LogFile:    tools.ServeLogFile(),
+		RuntimeDir: config.RuntimeDir(),
+	}
+	st.PID = tools.GetServePid()
+	st.Running = st.PID != 0
+	if meta, err := tools.LoadServeMetadata(); err == nil && st.Running && meta.PID == st.PID {
+		st.Port = meta.Port
+		st.StartedAt = meta.StartedAt
+	}
+
+	// Stats are only trusted while the server keeps them fresh
+	st.Connections = -1
+	stats, err := tools.LoadServeStats()
+	if err == nil && st.Running && stats.PID == st.PID && time.Since(stats.UpdatedAt) < 3*tools.ServeStatsInterval {
+		st.Connections = stats.Connections
+		st.MaxConnections = stats.MaxConnections
+	}
+	return st
+}
+
 func runStatus(cmd *cobra.Command, args []string) error {
+	st := currentServeStatus()
+	switch {
+	case statusJSON:
+		return writeJSON(os.Stdout, st)
+	case statusFormat != "":
+		return writeFormatted(os.Stdout, statusFormat, st)
+	}
+
 	fmt.Println("SSH Server Status")
 	fmt.Println("─────────────────────────────────────")
 
-	if tools.IsServeRunning() {
-		pid := tools.GetServePid()
-		fmt.Printf("Server:      ✓ running (PID %d) on port %d\n", pid, localPort)
+	if st.Running {
+		fmt.Printf("Server:      ✓ running (PID %d) on port %d\n", st.PID, st.Port)
+		switch {
+		case st.Connections < 0:
+		case st.MaxConnections > 0:
+			fmt.Printf("Connections: %d of %d\n", st.Connections, st.MaxConnections)
+		default:
+			fmt.Printf("Connections: %d\n", st.Connections)
+		}
 		fmt.Println()
 		fmt.Println("Connect with:")
-		fmt.Printf("  ssh <sprite-name>@localhost -p %d\n", localPort)
+		fmt.Printf("  ssh <sprite-name>@localhost -p %d\n", st.Port)
 	} else {
 		fmt.Println("Server:      ✗ not running")
 		fmt.Println()
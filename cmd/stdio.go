@@ -0,0 +1,138 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/vaurdan/sprite-bootstrap/internal/logfile"
+	"github.com/vaurdan/sprite-bootstrap/internal/sshserver"
+	"github.com/vaurdan/sprite-bootstrap/internal/tools"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh"
+)
+
+var (
+	stdioHostKey string
+	stdioShell   string
+	stdioLogFile string
+)
+
+var stdioCmd = &cobra.Command{
+	Use:   "stdio [user]",
+	Short: "Serve one SSH connection over stdin and stdout, as an SSH ProxyCommand",
+	Long: `Serve a single SSH connection over stdin and stdout, then exit. It is meant
+to be ssh's ProxyCommand, so connecting needs no background server or local
+port:
+
+  Host sprite-mysprite
+      User mysprite
+      ProxyCommand sprite-bootstrap stdio %r
+
+The sprite is taken from the SSH username like with serve. Passing it as
+user (ssh's %r) narrows the credentials to its organization when it names
+one, as sprite@org. The host key is serve's, so known_hosts entries work
+for both.
+
+Logs go to --log-file (default <state dir>/logs/stdio.log) rather than the
+terminal. The IDE commands write entries like the one above with --stdio.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runStdio,
+}
+
+func init() {
+	stdioCmd.Flags().StringVar(&stdioHostKey, "host-key", "", "Path to the host key (default: serve's auto-generated key)")
+	stdioCmd.Flags().StringVar(&stdioShell, "shell", "", "Shell to run on the sprite, instead of the sprite user's login shell")
+	stdioCmd.Flags().StringVar(&stdioLogFile, "log-file", "", "Write logs to this file, rotated by size (default <state dir>/logs/stdio.log)")
+	rootCmd.AddCommand(stdioCmd)
+}
+
+func runStdio(cmd *cobra.Command, args []string) error {
+	// Errors show up in the ssh client, where the usage would bury them
+	cmd.SilenceUsage = true
+
+	// stdout carries the SSH connection: anything else printed there, by us
+	// or the sprites SDK, goes to stderr instead
+	conn := sshserver.StdioConn(os.Stdin, os.Stdout)
+	os.Stdout = os.Stderr
+
+	logPath := stdioLogFile
+	if logPath == "" {
+		logPath = tools.StdioLog()
+	}
+	if w, err := logfile.Open(logPath, 10<<20, logfile.DefaultBackups); err != nil {
+		slog.Warn("Failed to open log file, logging to stderr", "exception", err)
+	} else {
+		defer w.Close()
+		log.SetOutput(w)
+	}
+
+	org := orgName
+	if len(args) > 0 && org == "" {
+		_, org = sshserver.SplitSpriteUser(args[0])
+	}
+
+	tokenOpts := &sshserver.TokenOptions{
+		Organization: org,
+	}
+	if err := tokenOpts.Resolve(); err != nil {
+		return fmt.Errorf("failed to resolve sprites credentials: %w\nRun 'sprite login' first", err)
+	}
+
+	var searchOrgs []string
+	if org == "" {
+		var err error
+		if searchOrgs, err = sshserver.ConfiguredOrgs(tokenOpts.API); err != nil {
+			slog.Warn("Failed to list organizations, serving only the current one", "exception", err)
+		}
+	}
+
+	hostKey, err := sshserver.LoadOrGenerateHostKey(stdioHostKey)
+	if err != nil {
+		return fmt.Errorf("failed to load host key: %w", err)
+	}
+	var extraKeys []ssh.Signer
+	if stdioHostKey == "" {
+		hostKey, extraKeys = applyHostKeyRotation(hostKey, nil)
+	}
+
+	srv, err := sshserver.NewServer(&sshserver.ServerConfig{
+		HostKey:       hostKey,
+		ExtraHostKeys: extraKeys,
+		TokenOptions:  tokenOpts,
+		MaxRetries:    5,
+		SocketTimeout: 10 * time.Second,
+		Shell:         stdioShell,
+		SearchOrgs:    searchOrgs,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create server: %w", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// The connection outlives ctx so that Shutdown can warn its sessions
+	done := make(chan error, 1)
+	go func() {
+		done <- srv.ServeConn(context.WithoutCancel(ctx), conn)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			slog.Warn("Connection was still open at exit", "exception", err)
+		}
+		return nil
+	}
+}
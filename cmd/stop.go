@@ -5,32 +5,53 @@ import (
 	"fmt"
 	"time"
 
-	"sprite-bootstrap/internal/tools"
+	"github.com/vaurdan/sprite-bootstrap/internal/tools"
 
 	"github.com/spf13/cobra"
 )
 
+var (
+	stopTool         string
+	stopKeepForwards bool
+	stopLocalOnly    bool
+)
+
 var stopCmd = &cobra.Command{
 	Use:   "stop",
 	Short: "Stop the SSH server",
 	Long: `Stop the running SSH server.
 
-If a sprite is specified with -s, also cleans up any lingering
-IDE processes on that sprite.`,
+If a sprite is specified with -s, also removes its SSH config entry and
+cleans up any lingering IDE processes on that sprite. Use --tool to clean
+up only one tool, and --local-only to leave the sprite untouched.
+
+Port forwards and remote sessions run through the SSH server, so
+--keep-forwards leaves it running.
+
+Example:
+  sprite-bootstrap stop -s mysprite --tool vscode --keep-forwards`,
 	RunE: runStop,
 }
 
 func init() {
+	stopCmd.Flags().StringVar(&stopTool, "tool", "", "Only clean up this tool's artifacts (e.g. vscode)")
+	stopCmd.Flags().BoolVar(&stopKeepForwards, "keep-forwards", false, "Leave the SSH server, and the forwards and sessions through it, running")
+	stopCmd.Flags().BoolVar(&stopLocalOnly, "local-only", false, "Only remove local artifacts such as the SSH config entry, without touching the sprite")
 	rootCmd.AddCommand(stopCmd)
 }
 
 func runStop(cmd *cobra.Command, args []string) error {
+	if spriteName == "" && (stopTool != "" || stopLocalOnly) {
+		return fmt.Errorf("--tool and --local-only need a sprite (-s)")
+	}
+
 	// Clean up sprite if specified
 	if spriteName != "" {
 		ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
 		defer cancel()
 
-		if err := tools.CleanupSprite(ctx, spriteName, orgName); err != nil {
+		opts := tools.CleanupOptions{Tool: stopTool, LocalOnly: stopLocalOnly}
+		if err := tools.CleanupSpriteWith(ctx, spriteName, orgName, opts); err != nil {
 			fmt.Printf("%s⚠%s Cleanup warning: %v\n",
 				tools.ColorYellow, tools.ColorReset, err)
 		}
@@ -42,6 +63,10 @@ func runStop(cmd *cobra.Command, args []string) error {
 	}
 
 	pid := tools.GetServePid()
+	if stopKeepForwards {
+		fmt.Printf("  Kept SSH server running (PID %d, --keep-forwards)\n", pid)
+		return nil
+	}
 	fmt.Printf("%s⏳%s Stopping SSH server (PID %d)...\n", tools.ColorYellow, tools.ColorReset, pid)
 
 	if err := tools.StopServe(); err != nil {
@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"context"
+	"os"
+
+	"github.com/vaurdan/sprite-bootstrap/internal/tools"
+
+	"github.com/spf13/cobra"
+)
+
+// summaryFlags are the output flags of commands that bootstrap a sprite
+type summaryFlags struct {
+	quiet  bool
+	asJSON bool
+}
+
+func (f *summaryFlags) register(cmd *cobra.Command) {
+	cmd.Flags().BoolVarP(&f.quiet, "quiet", "q", false, "Don't print the summary after the setup")
+	cmd.Flags().BoolVar(&f.asJSON, "json", false, "Print only the summary, as JSON, with progress on stderr")
+}
+
+// bootstrap runs the setup, ending with the summary as text or JSON
+func (f *summaryFlags) bootstrap(ctx context.Context, tool tools.Tool, opts tools.SetupOptions) error {
+	if !f.asJSON {
+		opts.Quiet = f.quiet
+		return tools.Bootstrap(ctx, tool, opts)
+	}
+
+	// Progress and the tool's instructions go to stderr, leaving stdout
+	// for the JSON
+	stdout := os.Stdout
+	os.Stdout = os.Stderr
+	summary, err := tools.BootstrapSummary(ctx, tool, opts)
+	os.Stdout = stdout
+	if err != nil {
+		return err
+	}
+	return writeJSON(stdout, struct {
+		Summary *tools.Summary `json:"summary"`
+	}{summary})
+}
@@ -0,0 +1,143 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/vaurdan/sprite-bootstrap/internal/config"
+	"github.com/vaurdan/sprite-bootstrap/internal/tools"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+var (
+	upProfile    string
+	upTool       string
+	upExtensions []string
+	upEnvFile    string
+	upDotfiles   string
+	upHooks      []string
+	upOutput     summaryFlags
+)
+
+var upCmd = &cobra.Command{
+	Use:   "up",
+	Short: "Bootstrap a sprite using a profile",
+	Long: `Bootstrap a sprite end to end: wake it, start the SSH server, apply the
+profile's env file, dotfiles and extensions, run the tool setup, then run the
+profile's post-hooks.
+
+Flags given on the command line take precedence over profile values. List
+flags (--extension, --hook) replace the profile's list rather than adding to it.
+
+Example:
+  sprite-bootstrap up -s mysprite --profile go-services
+  sprite-bootstrap up -s mysprite --profile go-services --tool zed`,
+	RunE: runUp,
+}
+
+func init() {
+	upCmd.Flags().StringVar(&upProfile, "profile", "", "Profile to apply (see 'profile list')")
+	upCmd.Flags().StringVar(&upTool, "tool", "", "Tool to set up (overrides the profile)")
+	upCmd.Flags().StringArrayVar(&upExtensions, "extension", nil, "Remote extension to install (repeatable)")
+	upCmd.Flags().StringVar(&upEnvFile, "env-file", "", "Local KEY=VALUE file to copy to the sprite")
+	upCmd.Flags().StringVar(&upDotfiles, "dotfiles", "", "Dotfiles git URL, optionally suffixed with #branch")
+	upCmd.Flags().StringArrayVar(&upHooks, "hook", nil, "Command to run on the sprite after setup (repeatable)")
+	upOutput.register(upCmd)
+	rootCmd.AddCommand(upCmd)
+}
+
+func runUp(cmd *cobra.Command, args []string) error {
+	if spriteName == "" {
+		return fmt.Errorf("sprite name required (-s)")
+	}
+
+	profile := &config.Profile{}
+	if upProfile != "" {
+		prefs, err := config.LoadPreferences()
+		if err != nil {
+			return fmt.Errorf("failed to load preferences: %w", err)
+		}
+		p, ok := prefs.Profiles[upProfile]
+		if !ok {
+			return fmt.Errorf("profile %q not found (see 'sprite-bootstrap profile list')", upProfile)
+		}
+		copied := *p
+		profile = &copied
+	}
+
+	// Explicit flags win over profile values
+	flags := cmd.Flags()
+	if flags.Changed("tool") {
+		profile.Tool = upTool
+	}
+	if flags.Changed("extension") {
+		profile.Extensions = upExtensions
+	}
+	if flags.Changed("env-file") {
+		profile.EnvFile = upEnvFile
+	}
+	if flags.Changed("dotfiles") {
+		profile.Dotfiles = upDotfiles
+	}
+	if flags.Changed("hook") {
+		profile.PostHooks = upHooks
+	}
+	if flags.Changed("path") || profile.RemotePath == "" {
+		profile.RemotePath = remotePath
+	}
+
+	if profile.Tool == "" {
+		return fmt.Errorf("no tool selected: pass --tool or a --profile that sets one")
+	}
+	if err := tools.ValidateProfile(profile); err != nil {
+		return fmt.Errorf("invalid profile: %w", err)
+	}
+
+	tool, _ := tools.Get(profile.Tool)
+	path := tools.ExpandRemotePath(profile.RemotePath, spriteName, orgName, upProfile)
+
+	opts := tools.NewSetupOptions(spriteName, orgName, localPort, resolveRemotePath(path))
+	opts.Extensions = profile.Extensions
+	opts.EnvFile = profile.EnvFile
+	opts.Dotfiles = profile.Dotfiles
+	opts.PostHooks = profile.PostHooks
+	opts.Command = upCommand(cmd)
+
+	ctx := context.Background()
+	if err := applyServeHost(ctx, &opts); err != nil {
+		return err
+	}
+
+	start := time.Now()
+	err := upOutput.bootstrap(ctx, tool, opts)
+	notifyDone(cmd, tool.Name()+" setup", spriteName, start, err)
+	return err
+}
+
+// upCommand returns the up command line the summary suggests for
+// reconnecting: the sprite and profile, plus the flags overriding it
+func upCommand(cmd *cobra.Command) []string {
+	args := []string{"up", "-s", spriteName}
+	if orgName != "" {
+		args = append(args, "-o", orgName)
+	}
+	if upProfile != "" {
+		args = append(args, "--profile", upProfile)
+	}
+	cmd.Flags().Visit(func(f *pflag.Flag) {
+		switch f.Name {
+		case "extension", "hook":
+			for _, v := range f.Value.(pflag.SliceValue).GetSlice() {
+				args = append(args, "--"+f.Name, v)
+			}
+		case "tailscale":
+			args = append(args, "--tailscale="+f.Value.String())
+		case "port", "path", "host", "tool", "env-file", "dotfiles":
+			args = append(args, "--"+f.Name, f.Value.String())
+		}
+	})
+	return args
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"net"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/vaurdan/sprite-bootstrap/internal/fakeapi"
+	"github.com/vaurdan/sprite-bootstrap/pkg/tools"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// recordingTool is shellTool remembering what it was set up with
+type recordingTool struct {
+	shellTool
+	setups []tools.SetupOptions
+}
+
+func (t *recordingTool) Setup(ctx context.Context, opts tools.SetupOptions) error {
+	t.setups = append(t.setups, opts)
+	return t.shellTool.Setup(ctx, opts)
+}
+
+// sshWithKey puts an ssh on PATH that logs in with a new key and keeps
+// known hosts in dir, as ssh otherwise uses the real user's files
+func sshWithKey(t *testing.T, dir string) {
+	t.Helper()
+	sshPath, err := exec.LookPath("ssh")
+	if err != nil {
+		t.Skip("ssh not installed")
+	}
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	identity := filepath.Join(dir, "id_ed25519")
+	if err := os.WriteFile(identity, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	config := "UserKnownHostsFile " + filepath.Join(dir, "known_hosts") + "\n" +
+		"IdentityFile " + identity + "\n" +
+		"IdentitiesOnly yes\nBatchMode yes\n"
+	if err := os.WriteFile(filepath.Join(dir, "ssh_config"), []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	bin := filepath.Join(dir, "bin")
+	if err := os.Mkdir(bin, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	wrapper := "#!/bin/sh\nexec '" + sshPath + "' -F '" + filepath.Join(dir, "ssh_config") + "' \"$@\"\n"
+	if err := os.WriteFile(filepath.Join(bin, "ssh"), []byte(wrapper), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+// freePort returns a loopback port nothing listens on
+func freePort(t *testing.T) int {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+// TestBootstrap registers a custom tool and bootstraps it against the fake
+// sprites API, with the real SSH server and ssh client
+func TestBootstrap(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds sprite-bootstrap")
+	}
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a POSIX shell for the ssh wrapper")
+	}
+	dir := t.TempDir()
+	sshWithKey(t, dir)
+
+	// The SSH server runs as "sprite-bootstrap serve"
+	binary := filepath.Join(dir, "sprite-bootstrap")
+	build := exec.Command("go", "build", "-o", binary, "github.com/vaurdan/sprite-bootstrap")
+	if out, err := build.CombinedOutput(); err != nil {
+		t.Fatalf("build sprite-bootstrap: %v\n%s", err, out)
+	}
+	tools.SetServeBinary(binary)
+
+	// State, credentials and the server all stay in the test's home
+	home := filepath.Join(dir, "home")
+	if err := os.Mkdir(home, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"HOME", "XDG_STATE_HOME", "XDG_CONFIG_HOME", "XDG_RUNTIME_DIR"} {
+		t.Setenv(name, home)
+	}
+	root := t.TempDir()
+	api := httptest.NewServer(&fakeapi.Server{Root: root, Token: "test"})
+	defer api.Close()
+	t.Setenv("SPRITES_API", api.URL)
+	t.Setenv("SPRITES_TOKEN", "test")
+
+	tool := &recordingTool{}
+	tools.Register(tool)
+	registered, ok := tools.Get("shell")
+	if !ok {
+		t.Fatal("shell tool not registered")
+	}
+
+	opts := tools.NewSetupOptions("demo", "", freePort(t), "/home/sprite")
+	t.Cleanup(func() { tools.StopServe() })
+	summary, err := tools.BootstrapSummary(context.Background(), registered, opts)
+	if err != nil {
+		t.Fatalf("Bootstrap: %v", err)
+	}
+
+	if !tools.IsServeRunning() {
+		t.Error("SSH server isn't running after Bootstrap")
+	}
+	if len(tool.setups) != 1 {
+		t.Fatalf("Setup ran %d times, want once", len(tool.setups))
+	}
+	if got := tool.setups[0]; got.Sprite == nil || got.SpriteName != "demo" || got.LocalPort != opts.LocalPort {
+		t.Errorf("Setup got sprite %v, name %q, port %d", got.Sprite, got.SpriteName, got.LocalPort)
+	}
+	if summary.Tool != "shell" || summary.Sprite != "demo" || summary.Port != opts.LocalPort {
+		t.Errorf("summary = %+v", summary)
+	}
+	// The sprite was woken through the fake API
+	if _, err := os.Stat(filepath.Join(root, "demo")); err != nil {
+		t.Errorf("sprite sandbox: %v", err)
+	}
+}
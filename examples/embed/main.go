@@ -0,0 +1,60 @@
+// Command embed shows how another program can reuse sprite-bootstrap's tool
+// registry and bootstrap orchestration.
+//
+// It registers a tool that only prints connection details, then bootstraps
+// the sprite named on the command line using the sprites CLI credentials.
+// The sprite-bootstrap binary must be on PATH to run the SSH server:
+//
+//	go run ./examples/embed mysprite
+//
+// Its test bootstraps the same tool against the fake sprites API.
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+
+	"github.com/vaurdan/sprite-bootstrap/pkg/tools"
+)
+
+// shellTool is a minimal Tool that needs no local IDE
+type shellTool struct{}
+
+func (t *shellTool) Name() string        { return "shell" }
+func (t *shellTool) Description() string { return "Plain SSH access" }
+
+func (t *shellTool) Validate(ctx context.Context) error { return nil }
+
+func (t *shellTool) Setup(ctx context.Context, opts tools.SetupOptions) error {
+	return nil
+}
+
+func (t *shellTool) Instructions(opts tools.SetupOptions) string {
+	return fmt.Sprintf("\nConnect with: ssh %s@localhost -p %d\n", opts.SpriteName, opts.LocalPort)
+}
+
+func main() {
+	if len(os.Args) != 2 {
+		fmt.Fprintln(os.Stderr, "usage: embed <sprite-name>")
+		os.Exit(2)
+	}
+
+	// The background SSH server is started as "sprite-bootstrap serve"
+	serveBinary, err := exec.LookPath("sprite-bootstrap")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "sprite-bootstrap must be installed to run the SSH server")
+		os.Exit(1)
+	}
+	tools.SetServeBinary(serveBinary)
+
+	tools.Register(&shellTool{})
+	tool, _ := tools.Get("shell")
+
+	opts := tools.NewSetupOptions(os.Args[1], "", 2222, "/home/sprite")
+	if err := tools.Bootstrap(context.Background(), tool, opts); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
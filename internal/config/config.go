@@ -2,9 +2,13 @@ package config
 
 import (
 	"encoding/json"
+	"fmt"
 	"os"
 	"path/filepath"
 	"runtime"
+
+	"github.com/vaurdan/sprite-bootstrap/internal/jsonc"
+	"github.com/vaurdan/sprite-bootstrap/internal/textfile"
 )
 
 // StateDir returns the platform-appropriate state directory for sprite-bootstrap
@@ -33,6 +37,26 @@ func EnsureStateDir() error {
 // Preferences stores user preferences
 type Preferences struct {
 	NeverAskClaudeCodeExtension bool `json:"never_ask_claude_code_extension,omitempty"`
+
+	// Notify sends a desktop notification when long operations finish
+	Notify bool `json:"notify,omitempty"`
+
+	// EditorArgs are extra editor launch arguments by tool name, used when
+	// --editor-args isn't given
+	EditorArgs map[string]string `json:"editor_args,omitempty"`
+
+	// Profiles are named setup bundles applied with --profile
+	Profiles map[string]*Profile `json:"profiles,omitempty"`
+}
+
+// Profile bundles the settings used to bootstrap a sprite for a project
+type Profile struct {
+	Tool       string   `json:"tool"`
+	Extensions []string `json:"extensions,omitempty"`  // Remote extension IDs (publisher.name)
+	EnvFile    string   `json:"env_file,omitempty"`    // Local KEY=VALUE file copied to the sprite
+	Dotfiles   string   `json:"dotfiles,omitempty"`    // Git URL, optionally suffixed with #branch
+	PostHooks  []string `json:"post_hooks,omitempty"`  // Commands run on the sprite after setup
+	RemotePath string   `json:"remote_path,omitempty"` // May reference {sprite}, {org} and {profile}
 }
 
 // prefsFile returns the path to the preferences file
@@ -43,15 +67,15 @@ func prefsFile() string {
 // LoadPreferences loads user preferences from disk
 func LoadPreferences() (*Preferences, error) {
 	prefs := &Preferences{}
-	data, err := os.ReadFile(prefsFile())
+	data, _, err := textfile.ReadFile(prefsFile())
 	if err != nil {
 		if os.IsNotExist(err) {
 			return prefs, nil // Return empty prefs if file doesn't exist
 		}
 		return prefs, err
 	}
-	if err := json.Unmarshal(data, prefs); err != nil {
-		return prefs, err
+	if err := jsonc.Unmarshal(data, prefs); err != nil {
+		return prefs, fmt.Errorf("%s: %w", prefsFile(), err)
 	}
 	return prefs, nil
 }
@@ -0,0 +1,24 @@
+//go:build darwin
+
+package config
+
+import "syscall"
+
+// mntLocal is MNT_LOCAL from <sys/mount.h>
+const mntLocal = 0x00001000
+
+// detectFilesystem reads the volume flags with statfs
+func detectFilesystem(path string) (Filesystem, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return Filesystem{}, err
+	}
+	name := make([]byte, 0, len(st.Fstypename))
+	for _, c := range st.Fstypename {
+		if c == 0 {
+			break
+		}
+		name = append(name, byte(c))
+	}
+	return Filesystem{Type: string(name), Network: st.Flags&mntLocal == 0}, nil
+}
@@ -0,0 +1,31 @@
+//go:build linux
+
+package config
+
+import "syscall"
+
+// networkMagic maps statfs magic numbers of network filesystems to names
+var networkMagic = map[int64]string{
+	0x6969:     "nfs",
+	0x517b:     "smb",
+	0xff534d42: "cifs",
+	0xfe534d42: "smb2",
+	0x5346414f: "afs",
+	0x73757245: "coda",
+	0x00c36400: "ceph",
+	0x0bd00bd0: "lustre",
+	0x01021997: "9p",
+	0x47504653: "gpfs",
+}
+
+// detectFilesystem reads the filesystem type with statfs
+func detectFilesystem(path string) (Filesystem, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return Filesystem{}, err
+	}
+	if name, ok := networkMagic[int64(st.Type)]; ok {
+		return Filesystem{Type: name, Network: true}, nil
+	}
+	return Filesystem{}, nil
+}
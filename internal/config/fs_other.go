@@ -0,0 +1,8 @@
+//go:build !linux && !darwin && !windows
+
+package config
+
+// detectFilesystem can't tell on this platform, so state is assumed local
+func detectFilesystem(path string) (Filesystem, error) {
+	return Filesystem{}, nil
+}
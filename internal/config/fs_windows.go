@@ -0,0 +1,40 @@
+//go:build windows
+
+package config
+
+import (
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// driveRemote is DRIVE_REMOTE from GetDriveTypeW
+const driveRemote = 4
+
+var procGetDriveType = syscall.NewLazyDLL("kernel32.dll").NewProc("GetDriveTypeW")
+
+// detectFilesystem treats UNC paths and mapped network drives as network
+// filesystems
+func detectFilesystem(path string) (Filesystem, error) {
+	volume := filepath.VolumeName(path)
+	if strings.HasPrefix(volume, `\\`) {
+		return Filesystem{Type: "unc", Network: true}, nil
+	}
+	if volume == "" {
+		return Filesystem{}, nil
+	}
+
+	root, err := syscall.UTF16PtrFromString(volume + `\`)
+	if err != nil {
+		return Filesystem{}, err
+	}
+	if err := procGetDriveType.Find(); err != nil {
+		return Filesystem{}, err
+	}
+	kind, _, _ := procGetDriveType.Call(uintptr(unsafe.Pointer(root)))
+	if kind == driveRemote {
+		return Filesystem{Type: "remote", Network: true}, nil
+	}
+	return Filesystem{}, nil
+}
@@ -0,0 +1,141 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/vaurdan/sprite-bootstrap/internal/jsonc"
+	"github.com/vaurdan/sprite-bootstrap/internal/textfile"
+)
+
+// Inventory lists the sprites bootstrapped from this machine, with the ID
+// the API gave each one, so local state can follow a sprite that is renamed
+type Inventory struct {
+	Sprites []*InventoryEntry `json:"sprites"`
+}
+
+// InventoryEntry is a sprite bootstrapped from this machine
+type InventoryEntry struct {
+	Name      string    `json:"name"`
+	Org       string    `json:"org,omitempty"` // Organization given with --org, if any
+	ID        string    `json:"id,omitempty"`
+	Created   time.Time `json:"created,omitzero"` // Creation time the API reported, which a rebuild changes
+	Tools     []string  `json:"tools,omitempty"`
+	LastSetup time.Time `json:"last_setup"`
+}
+
+// inventoryFile returns the path to the inventory
+func inventoryFile() string {
+	return filepath.Join(StateDir(), "sprites.json")
+}
+
+// LoadInventory loads the inventory, which is empty before the first setup
+func LoadInventory() (*Inventory, error) {
+	inv := &Inventory{}
+	data, _, err := textfile.ReadFile(inventoryFile())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return inv, nil
+		}
+		return inv, err
+	}
+	if err := jsonc.Unmarshal(data, inv); err != nil {
+		return inv, fmt.Errorf("%s: %w", inventoryFile(), err)
+	}
+	return inv, nil
+}
+
+// SaveInventory writes the inventory to disk
+func SaveInventory(inv *Inventory) error {
+	if err := EnsureStateDir(); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(inv, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(inventoryFile(), data, 0600)
+}
+
+// Find returns the entry for a sprite name in an organization, or nil
+func (inv *Inventory) Find(name, org string) *InventoryEntry {
+	for _, e := range inv.Sprites {
+		if e.Name == name && e.Org == org {
+			return e
+		}
+	}
+	return nil
+}
+
+// FindID returns the entries recorded for a sprite ID, under any name
+func (inv *Inventory) FindID(id string) []*InventoryEntry {
+	var found []*InventoryEntry
+	for _, e := range inv.Sprites {
+		if id != "" && e.ID == id {
+			found = append(found, e)
+		}
+	}
+	return found
+}
+
+// Record notes a setup of a sprite with a tool and returns its entry
+func (inv *Inventory) Record(name, org, id, tool string, at time.Time) *InventoryEntry {
+	e := inv.Find(name, org)
+	if e == nil {
+		e = &InventoryEntry{Name: name, Org: org}
+		inv.Sprites = append(inv.Sprites, e)
+	}
+	e.ID = id
+	e.LastSetup = at
+	if !slices.Contains(e.Tools, tool) {
+		e.Tools = append(e.Tools, tool)
+		slices.Sort(e.Tools)
+	}
+	return e
+}
+
+// Rebuilt reports whether a sprite with this ID and creation time replaced
+// the one recorded under the entry's name. Restarts change neither, and
+// either is only compared once it was recorded.
+func (e *InventoryEntry) Rebuilt(id string, created time.Time) bool {
+	if e.ID != "" && id != "" && e.ID != id {
+		return true
+	}
+	return !e.Created.IsZero() && !created.IsZero() && !e.Created.Equal(created)
+}
+
+// Rename moves an entry to a new name, merging it into any entry already
+// recorded under that name
+func (inv *Inventory) Rename(e *InventoryEntry, name string) {
+	if existing := inv.Find(name, e.Org); existing != nil && existing != e {
+		for _, tool := range e.Tools {
+			if !slices.Contains(existing.Tools, tool) {
+				existing.Tools = append(existing.Tools, tool)
+			}
+		}
+		slices.Sort(existing.Tools)
+		inv.Remove(e.Name, e.Org, "")
+		return
+	}
+	e.Name = name
+}
+
+// Remove forgets a tool's setup of a sprite, or the whole entry when tool
+// is empty or was the last one
+func (inv *Inventory) Remove(name, org, tool string) {
+	e := inv.Find(name, org)
+	if e == nil {
+		return
+	}
+	if tool != "" {
+		e.Tools = slices.DeleteFunc(e.Tools, func(t string) bool { return t == tool })
+		if len(e.Tools) > 0 {
+			return
+		}
+	}
+	inv.Sprites = slices.DeleteFunc(inv.Sprites, func(s *InventoryEntry) bool { return s == e })
+}
@@ -0,0 +1,184 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/vaurdan/sprite-bootstrap/internal/jsonc"
+	"github.com/vaurdan/sprite-bootstrap/internal/textfile"
+)
+
+// Policy is a set of rules an organization enforces for every developer.
+// Features consult it and refuse what it denies with a PolicyError.
+//
+// The sprites API has no endpoint for organization settings yet, so
+// policies come from a local override file (see PolicyFile). A policy
+// fetched from the API would be resolved in the same place and take the
+// same shape.
+type Policy struct {
+	// Name identifies the policy in messages; defaults to the file name
+	Name string `json:"name,omitempty"`
+	// Org is the organization the policy applies to; empty for all
+	Org string `json:"org,omitempty"`
+
+	// DenyClaudeBypassPermissions stops setup from turning on Claude
+	// Code's bypassPermissions mode
+	DenyClaudeBypassPermissions bool `json:"deny_claude_bypass_permissions,omitempty"`
+	// AllowedForwardPorts limits port forwards to these ports or ranges,
+	// e.g. "3000-9000". Empty allows any port.
+	AllowedForwardPorts []string `json:"allowed_forward_ports,omitempty"`
+	// RequireHostKeyPinning makes generated SSH config entries verify the
+	// server's host key instead of accepting any
+	RequireHostKeyPinning bool `json:"require_host_key_pinning,omitempty"`
+
+	// Source is where the policy was read from
+	Source string `json:"-"`
+
+	ports []portRange
+}
+
+// portRange is an inclusive range of ports
+type portRange struct{ lo, hi int }
+
+// PolicyError is returned for options a policy denies
+type PolicyError struct {
+	Policy string // Policy name
+	Rule   string // JSON name of the rule
+	Detail string
+}
+
+func (e *PolicyError) Error() string {
+	return fmt.Sprintf("denied by policy %q (%s): %s", e.Policy, e.Rule, e.Detail)
+}
+
+// PolicyFile returns the path of the local policy override file
+func PolicyFile() string {
+	return filepath.Join(StateDir(), "policy.json")
+}
+
+var (
+	policyMu    sync.Mutex
+	policyCache = make(map[string]*Policy)
+)
+
+// LoadPolicy returns the policy for an organization, or nil if none
+// applies. The result is cached for the life of the process.
+func LoadPolicy(org string) (*Policy, error) {
+	policyMu.Lock()
+	defer policyMu.Unlock()
+
+	if p, ok := policyCache[org]; ok {
+		return p, nil
+	}
+	p, err := readPolicy(PolicyFile(), org)
+	if err != nil {
+		return nil, err
+	}
+	policyCache[org] = p
+	return p, nil
+}
+
+// readPolicy reads a policy file, returning nil if it doesn't exist or is
+// for another organization
+func readPolicy(path, org string) (*Policy, error) {
+	data, _, err := textfile.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var p Policy
+	if err := jsonc.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if p.Org != "" && org != "" && !strings.EqualFold(p.Org, org) {
+		return nil, nil
+	}
+	if p.Name == "" {
+		p.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	p.Source = path
+
+	for _, spec := range p.AllowedForwardPorts {
+		r, err := parsePortRange(spec)
+		if err != nil {
+			return nil, fmt.Errorf("%s: allowed_forward_ports: %w", path, err)
+		}
+		p.ports = append(p.ports, r)
+	}
+	return &p, nil
+}
+
+// parsePortRange parses "PORT" or "LO-HI"
+func parsePortRange(spec string) (portRange, error) {
+	loStr, hiStr, isRange := strings.Cut(strings.TrimSpace(spec), "-")
+	if !isRange {
+		hiStr = loStr
+	}
+	lo, err1 := strconv.Atoi(loStr)
+	hi, err2 := strconv.Atoi(hiStr)
+	if err1 != nil || err2 != nil || lo < 1 || hi > 65535 || lo > hi {
+		return portRange{}, fmt.Errorf("invalid port range %q", spec)
+	}
+	return portRange{lo, hi}, nil
+}
+
+// CheckForwardPort returns a PolicyError if the policy doesn't allow
+// forwarding to port. A nil policy allows everything.
+func (p *Policy) CheckForwardPort(port int) error {
+	if p == nil || len(p.ports) == 0 {
+		return nil
+	}
+	for _, r := range p.ports {
+		if port >= r.lo && port <= r.hi {
+			return nil
+		}
+	}
+	return &PolicyError{
+		Policy: p.Name,
+		Rule:   "allowed_forward_ports",
+		Detail: fmt.Sprintf("port %d is not in %s", port, strings.Join(p.AllowedForwardPorts, ", ")),
+	}
+}
+
+// CheckClaudeBypassPermissions returns a PolicyError if the policy denies
+// Claude Code's bypassPermissions mode
+func (p *Policy) CheckClaudeBypassPermissions() error {
+	if p == nil || !p.DenyClaudeBypassPermissions {
+		return nil
+	}
+	return &PolicyError{
+		Policy: p.Name,
+		Rule:   "deny_claude_bypass_permissions",
+		Detail: "Claude Code may not run with bypassPermissions",
+	}
+}
+
+// PinsHostKeys reports whether SSH config entries must pin the host key
+func (p *Policy) PinsHostKeys() bool {
+	return p != nil && p.RequireHostKeyPinning
+}
+
+// Rules describes the policy's rules, one per line, for doctor
+func (p *Policy) Rules() []string {
+	if p == nil {
+		return nil
+	}
+	var rules []string
+	if p.DenyClaudeBypassPermissions {
+		rules = append(rules, "Claude Code bypassPermissions denied")
+	}
+	if len(p.AllowedForwardPorts) > 0 {
+		rules = append(rules, "Port forwards only to "+strings.Join(p.AllowedForwardPorts, ", "))
+	}
+	if p.RequireHostKeyPinning {
+		rules = append(rules, "SSH host keys pinned")
+	}
+	return rules
+}
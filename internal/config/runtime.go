@@ -0,0 +1,151 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// Filesystem describes the filesystem a directory lives on
+type Filesystem struct {
+	Type    string // e.g. "nfs", "smb2", "ext4"; empty when unknown
+	Network bool
+}
+
+// DetectFilesystem reports the filesystem of a path. It can be replaced to
+// simulate a network home directory.
+var DetectFilesystem = detectFilesystem
+
+// Layout describes where sprite-bootstrap keeps its files on this machine
+type Layout struct {
+	StateDir   string     // Preferences, profiles and serve config
+	RuntimeDir string     // PID file, serve log and metadata, debug dumps
+	Filesystem Filesystem // Filesystem of StateDir
+	MachineID  string     // Namespaces runtime state kept on a shared filesystem
+	Relocated  bool       // RuntimeDir was moved off a network StateDir
+	Err        error      // Why detection or relocation failed, if it did
+}
+
+var (
+	layoutOnce sync.Once
+	layout     Layout
+)
+
+// CurrentLayout detects the layout once per process
+func CurrentLayout() Layout {
+	layoutOnce.Do(func() {
+		layout = DetectLayout()
+	})
+	return layout
+}
+
+// DetectLayout works out where runtime state should go. State on a network
+// filesystem is shared between machines and may not support locks or atomic
+// renames, so runtime state moves to a machine-local directory; if that
+// can't be created it stays in the state directory, namespaced by machine.
+func DetectLayout() Layout {
+	l := Layout{StateDir: StateDir(), RuntimeDir: StateDir(), MachineID: MachineID()}
+
+	fs, err := DetectFilesystem(existingParent(l.StateDir))
+	if err != nil {
+		l.Err = fmt.Errorf("detect filesystem: %w", err)
+		return l
+	}
+	l.Filesystem = fs
+	if !fs.Network {
+		return l
+	}
+
+	local := localRuntimeDir()
+	if err := ensurePrivateDir(local); err != nil {
+		l.Err = fmt.Errorf("%s: %w", local, err)
+		l.RuntimeDir = filepath.Join(l.StateDir, "run-"+l.MachineID)
+		return l
+	}
+	l.RuntimeDir = local
+	l.Relocated = true
+	return l
+}
+
+// RuntimeDir returns the directory for machine-specific runtime state
+func RuntimeDir() string {
+	return CurrentLayout().RuntimeDir
+}
+
+// EnsureRuntimeDir creates the runtime directory if it doesn't exist
+func EnsureRuntimeDir() error {
+	return os.MkdirAll(RuntimeDir(), 0700)
+}
+
+// localRuntimeDir returns a machine-local directory for runtime state
+func localRuntimeDir() string {
+	if runtime.GOOS == "linux" {
+		if xdg := os.Getenv("XDG_RUNTIME_DIR"); xdg != "" {
+			return filepath.Join(xdg, "sprite-bootstrap")
+		}
+	}
+	if runtime.GOOS == "windows" {
+		return filepath.Join(os.TempDir(), "sprite-bootstrap")
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("sprite-bootstrap-%d", os.Getuid()))
+}
+
+// ensurePrivateDir creates dir with mode 0700 and checks that it is a real
+// directory, so a shared /tmp can't be used to redirect our files
+func ensurePrivateDir(dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("not a directory")
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0077 != 0 {
+		return fmt.Errorf("has mode %04o, expected 0700", info.Mode().Perm())
+	}
+	return nil
+}
+
+// existingParent returns path or its closest existing parent, so detection
+// works before the state directory has been created
+func existingParent(path string) string {
+	for {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return path
+		}
+		path = parent
+	}
+}
+
+// MachineID returns a short stable identifier for this machine
+func MachineID() string {
+	for _, path := range []string{"/etc/machine-id", "/var/lib/dbus/machine-id"} {
+		if data, err := os.ReadFile(path); err == nil {
+			if id := strings.TrimSpace(string(data)); id != "" {
+				return shortID(id)
+			}
+		}
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		return "unknown"
+	}
+	return shortID(host)
+}
+
+func shortID(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:6])
+}
@@ -0,0 +1,172 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// useDetector replaces DetectFilesystem for the test, recording the paths
+// it is asked about
+func useDetector(t *testing.T, fs Filesystem, err error) *[]string {
+	t.Helper()
+	var paths []string
+	old := DetectFilesystem
+	DetectFilesystem = func(path string) (Filesystem, error) {
+		paths = append(paths, path)
+		return fs, err
+	}
+	t.Cleanup(func() { DetectFilesystem = old })
+	return &paths
+}
+
+// tempHome points the state directory and the machine-local runtime
+// directory at fresh temporary directories, returning the state directory
+func tempHome(t *testing.T, local string) string {
+	t.Helper()
+	home := t.TempDir()
+	for _, name := range []string{"HOME", "USERPROFILE", "LOCALAPPDATA", "XDG_STATE_HOME"} {
+		t.Setenv(name, home)
+	}
+	for _, name := range []string{"XDG_RUNTIME_DIR", "TMPDIR", "TMP", "TEMP"} {
+		t.Setenv(name, local)
+	}
+	return StateDir()
+}
+
+func TestDetectLayout(t *testing.T) {
+	nfs := Filesystem{Type: "nfs", Network: true}
+	tests := []struct {
+		name string
+		fs   Filesystem
+		err  error
+		// setup changes what is in the machine-local directory's parent
+		setup         func(t *testing.T, local string)
+		wantRelocated bool
+		wantShared    bool // Namespaced by machine in the state directory
+		wantErr       bool
+	}{
+		{name: "local filesystem", fs: Filesystem{Type: "ext4"}},
+		{name: "detection fails", err: errors.New("statfs: permission denied"), wantErr: true},
+		{name: "network filesystem", fs: nfs, wantRelocated: true},
+		{name: "network, local dir already there", fs: nfs, wantRelocated: true,
+			setup: func(t *testing.T, local string) { mkdir(t, localRuntimeDir(), 0o700) }},
+		{name: "network, local dir can't be created", fs: nfs, wantShared: true, wantErr: true,
+			setup: func(t *testing.T, local string) {
+				// A file where the directory's parent should be
+				if err := os.Remove(local); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(local, nil, 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}},
+		{name: "network, local dir open to others", fs: nfs, wantShared: true, wantErr: true,
+			setup: func(t *testing.T, local string) {
+				if runtime.GOOS == "windows" {
+					t.Skip("no Unix permissions")
+				}
+				mkdir(t, localRuntimeDir(), 0o777)
+			}},
+		{name: "network, local dir is a symlink", fs: nfs, wantShared: true, wantErr: true,
+			setup: func(t *testing.T, local string) {
+				target := t.TempDir()
+				if err := os.Symlink(target, localRuntimeDir()); err != nil {
+					t.Skip("can't create symlinks:", err)
+				}
+			}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			local := filepath.Join(t.TempDir(), "run")
+			stateDir := tempHome(t, local)
+			if err := os.MkdirAll(local, 0o700); err != nil {
+				t.Fatal(err)
+			}
+			if tt.setup != nil {
+				tt.setup(t, local)
+			}
+			paths := useDetector(t, tt.fs, tt.err)
+
+			l := DetectLayout()
+			if l.StateDir != stateDir {
+				t.Errorf("StateDir = %s, want %s", l.StateDir, stateDir)
+			}
+			if (l.Err != nil) != tt.wantErr {
+				t.Errorf("Err = %v, want error %v", l.Err, tt.wantErr)
+			}
+			if l.Relocated != tt.wantRelocated {
+				t.Errorf("Relocated = %v, want %v", l.Relocated, tt.wantRelocated)
+			}
+			if l.Filesystem != tt.fs {
+				t.Errorf("Filesystem = %+v, want %+v", l.Filesystem, tt.fs)
+			}
+
+			var want string
+			switch {
+			case tt.wantRelocated:
+				want = localRuntimeDir()
+			case tt.wantShared:
+				want = filepath.Join(stateDir, "run-"+MachineID())
+			default:
+				want = stateDir
+			}
+			if l.RuntimeDir != want {
+				t.Errorf("RuntimeDir = %s, want %s", l.RuntimeDir, want)
+			}
+			if tt.wantRelocated {
+				info, err := os.Stat(l.RuntimeDir)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if runtime.GOOS != "windows" && info.Mode().Perm() != 0o700 {
+					t.Errorf("runtime dir has mode %04o, want 0700", info.Mode().Perm())
+				}
+			}
+
+			// The state directory doesn't exist yet, so its parent is checked
+			if len(*paths) != 1 || (*paths)[0] != existingParent(stateDir) {
+				t.Errorf("detector asked about %q, want %q", *paths, existingParent(stateDir))
+			}
+		})
+	}
+}
+
+// mkdir creates dir with exactly mode, whatever the umask
+func mkdir(t *testing.T, dir string, mode os.FileMode) {
+	t.Helper()
+	if err := os.Mkdir(dir, mode); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(dir, mode); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExistingParent(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		path, want string
+	}{
+		{dir, dir},
+		{filepath.Join(dir, "missing"), dir},
+		{filepath.Join(dir, "missing", "deeper"), dir},
+	}
+	for _, tt := range tests {
+		if got := existingParent(tt.path); got != tt.want {
+			t.Errorf("existingParent(%s) = %s, want %s", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestMachineID(t *testing.T) {
+	id := MachineID()
+	if len(id) != 12 {
+		t.Errorf("MachineID() = %q, want 12 hex digits", id)
+	}
+	if again := MachineID(); again != id {
+		t.Errorf("MachineID() = %q, then %q", id, again)
+	}
+}
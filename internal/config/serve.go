@@ -0,0 +1,222 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/vaurdan/sprite-bootstrap/internal/jsonc"
+	"github.com/vaurdan/sprite-bootstrap/internal/textfile"
+)
+
+// serveConfigNames are the default serve config files, in lookup order
+var serveConfigNames = []string{"serve.yaml", "serve.yml", "serve.json"}
+
+// ServeConfig holds serve options read from a config file. Keys are flag
+// names; each value is one or more flag values in file order.
+type ServeConfig struct {
+	Path   string
+	Values map[string][]string
+}
+
+// Keys returns the config keys in sorted order
+func (c *ServeConfig) Keys() []string {
+	keys := make([]string, 0, len(c.Values))
+	for k := range c.Values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// DefaultServeConfigFile returns the first default serve config file that
+// exists in the state directory, or "" if there is none
+func DefaultServeConfigFile() string {
+	for _, name := range serveConfigNames {
+		path := filepath.Join(StateDir(), name)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// LoadServeConfig reads a serve config file. Files ending in .json are parsed
+// as JSON; anything else is parsed as a flat YAML mapping.
+func LoadServeConfig(path string) (*ServeConfig, error) {
+	data, _, err := textfile.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var values map[string][]string
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		values, err = parseServeJSON(data)
+	} else {
+		values, err = parseServeYAML(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	// Accept snake_case keys as well as flag names
+	normalized := make(map[string][]string, len(values))
+	for k, v := range values {
+		normalized[strings.ReplaceAll(k, "_", "-")] = v
+	}
+	return &ServeConfig{Path: path, Values: normalized}, nil
+}
+
+// parseServeJSON parses a JSON object of scalars and arrays of scalars
+func parseServeJSON(data []byte) (map[string][]string, error) {
+	var raw map[string]json.RawMessage
+	if err := jsonc.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+
+	values := make(map[string][]string, len(raw))
+	for key, msg := range raw {
+		msg = bytes.TrimSpace(msg)
+		if len(msg) > 0 && msg[0] == '[' {
+			var items []json.RawMessage
+			if err := json.Unmarshal(msg, &items); err != nil {
+				return nil, fmt.Errorf("%s: %w", key, err)
+			}
+			list := []string{}
+			for _, item := range items {
+				s, err := jsonScalar(item)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %w", key, err)
+				}
+				list = append(list, s)
+			}
+			values[key] = list
+			continue
+		}
+
+		s, err := jsonScalar(msg)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+		values[key] = []string{s}
+	}
+	return values, nil
+}
+
+// jsonScalar converts a JSON string, number or bool to its flag value
+func jsonScalar(msg json.RawMessage) (string, error) {
+	var v any
+	if err := json.Unmarshal(msg, &v); err != nil {
+		return "", err
+	}
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case float64:
+		return string(bytes.TrimSpace(msg)), nil
+	default:
+		return "", fmt.Errorf("unsupported value %s", msg)
+	}
+}
+
+// parseServeYAML parses the subset of YAML needed for serve options: a flat
+// mapping of scalars, inline lists ([a, b]) and block lists ("- item").
+func parseServeYAML(data []byte) (map[string][]string, error) {
+	values := make(map[string][]string)
+	var listKey string
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := stripYAMLComment(scanner.Text())
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || trimmed == "---" {
+			continue
+		}
+
+		if strings.HasPrefix(trimmed, "- ") || trimmed == "-" {
+			if listKey == "" {
+				return nil, fmt.Errorf("line %d: list item without a key", lineNo)
+			}
+			values[listKey] = append(values[listKey], yamlScalar(strings.TrimSpace(strings.TrimPrefix(trimmed, "-"))))
+			continue
+		}
+
+		if line[0] == ' ' || line[0] == '\t' {
+			return nil, fmt.Errorf("line %d: nested mappings are not supported", lineNo)
+		}
+
+		key, value, ok := strings.Cut(trimmed, ":")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected \"key: value\"", lineNo)
+		}
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+
+		switch {
+		case value == "":
+			// Block list follows
+			listKey = key
+			values[key] = []string{}
+		case strings.HasPrefix(value, "[") && strings.HasSuffix(value, "]"):
+			listKey = ""
+			list := []string{}
+			for _, item := range strings.Split(value[1:len(value)-1], ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					list = append(list, yamlScalar(item))
+				}
+			}
+			values[key] = list
+		default:
+			listKey = ""
+			values[key] = []string{yamlScalar(value)}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// stripYAMLComment removes a trailing # comment that isn't inside quotes
+func stripYAMLComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return strings.TrimRight(line[:i], " \t")
+		}
+	}
+	return strings.TrimRight(line, " \t")
+}
+
+// yamlScalar unquotes a YAML scalar
+func yamlScalar(s string) string {
+	if len(s) >= 2 {
+		switch {
+		case s[0] == '"' && s[len(s)-1] == '"':
+			if unquoted, err := strconv.Unquote(s); err == nil {
+				return unquoted
+			}
+			return s[1 : len(s)-1]
+		case s[0] == '\'' && s[len(s)-1] == '\'':
+			return strings.ReplaceAll(s[1:len(s)-1], "''", "'")
+		}
+	}
+	return s
+}
@@ -0,0 +1,259 @@
+package fakeapi
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// Stream IDs of the non-TTY exec framing
+const (
+	streamStdin    byte = 0
+	streamStdout   byte = 1
+	streamStderr   byte = 2
+	streamExit     byte = 3
+	streamStdinEOF byte = 4
+)
+
+// controlMessage is a text frame sent by the client during an exec
+type controlMessage struct {
+	Type   string `json:"type"`
+	Signal string `json:"signal,omitempty"`
+	Rows   uint16 `json:"rows,omitempty"`
+	Cols   uint16 `json:"cols,omitempty"`
+}
+
+// execSession is one command running for an exec websocket
+type execSession struct {
+	conn *websocket.Conn
+	cmd  *exec.Cmd
+	tty  bool
+
+	writeMu sync.Mutex
+	stdin   io.WriteCloser
+	resize  func(rows, cols uint16)
+}
+
+// exec runs a command in the sprite's sandbox. A plain HEAD or GET answers
+// the version probe the client makes before attaching.
+func (s *Server) exec(w http.ResponseWriter, r *http.Request, home string) {
+	if !websocket.IsWebSocketUpgrade(r) {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	q := r.URL.Query()
+	argv := q["cmd"]
+	if len(argv) == 0 {
+		http.Error(w, "missing cmd", http.StatusBadRequest)
+		return
+	}
+	for i := range argv {
+		argv[i] = sandboxPath(argv[i], home)
+	}
+
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Dir = home
+	if dir := q.Get("dir"); dir != "" {
+		cmd.Dir = sandboxPath(dir, home)
+	}
+	cmd.Env = append(os.Environ(), "HOME="+home, "SPRITE_FAKE=1")
+	for _, kv := range q["env"] {
+		cmd.Env = append(cmd.Env, sandboxPath(kv, home))
+	}
+
+	conn, err := upgrader.Upgrade(w, r, http.Header{"X-Sprite-Capabilities": {"signal"}})
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	e := &execSession{conn: conn, cmd: cmd, tty: q.Get("tty") == "true"}
+	if e.tty {
+		rows, _ := strconv.ParseUint(q.Get("rows"), 10, 16)
+		cols, _ := strconv.ParseUint(q.Get("cols"), 10, 16)
+		e.runTTY(uint16(rows), uint16(cols))
+	} else {
+		e.runPipes()
+	}
+}
+
+// runTTY runs the command on a pseudo-terminal, or on pipes where this
+// platform has none
+func (e *execSession) runTTY(rows, cols uint16) {
+	pty, err := startPTY(e.cmd, rows, cols)
+	if errors.Is(err, errNoPTY) {
+		e.runPipes()
+		return
+	}
+	if err != nil {
+		e.fail(err)
+		return
+	}
+	defer pty.Close()
+
+	e.stdin = pty
+	e.resize = func(rows, cols uint16) { _ = setPTYSize(pty, rows, cols) }
+	go e.readInput()
+
+	e.pump(pty, 0)
+	e.exit(exitCode(e.cmd.Wait()))
+}
+
+// runPipes runs the command with its standard streams on pipes
+func (e *execSession) runPipes() {
+	ownProcessGroup(e.cmd)
+	stdin, err := e.cmd.StdinPipe()
+	if err != nil {
+		e.fail(err)
+		return
+	}
+	stdout, err := e.cmd.StdoutPipe()
+	if err != nil {
+		e.fail(err)
+		return
+	}
+	stderr, err := e.cmd.StderrPipe()
+	if err != nil {
+		e.fail(err)
+		return
+	}
+	if err := e.cmd.Start(); err != nil {
+		e.fail(err)
+		return
+	}
+
+	e.stdin = stdin
+	go e.readInput()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() { defer wg.Done(); e.pump(stdout, streamStdout) }()
+	go func() { defer wg.Done(); e.pump(stderr, streamStderr) }()
+	wg.Wait()
+
+	e.exit(exitCode(e.cmd.Wait()))
+}
+
+// pump copies command output to the websocket until it ends
+func (e *execSession) pump(r io.Reader, stream byte) {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 && e.write(stream, buf[:n]) != nil {
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// readInput applies client frames until the websocket closes, then kills
+// the command and what it started if it is still running
+func (e *execSession) readInput() {
+	defer func() {
+		if e.cmd.ProcessState == nil && e.cmd.Process != nil {
+			_ = signalAll(e.cmd.Process, signals["KILL"])
+		}
+	}()
+
+	for {
+		kind, data, err := e.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		if kind == websocket.TextMessage {
+			var msg controlMessage
+			if json.Unmarshal(data, &msg) != nil {
+				continue
+			}
+			switch msg.Type {
+			case "resize":
+				if e.resize != nil {
+					e.resize(msg.Rows, msg.Cols)
+				}
+			case "signal":
+				if sig, ok := signals[strings.TrimPrefix(msg.Signal, "SIG")]; ok {
+					_ = signalAll(e.cmd.Process, sig)
+				}
+			}
+			continue
+		}
+
+		if e.tty {
+			_, _ = e.stdin.Write(data)
+			continue
+		}
+		if len(data) == 0 {
+			continue
+		}
+		switch data[0] {
+		case streamStdin:
+			_, _ = e.stdin.Write(data[1:])
+		case streamStdinEOF:
+			_ = e.stdin.Close()
+		}
+	}
+}
+
+// write sends output, framed with its stream ID unless this is a TTY
+func (e *execSession) write(stream byte, data []byte) error {
+	if !e.tty {
+		data = append([]byte{stream}, data...)
+	}
+	e.writeMu.Lock()
+	defer e.writeMu.Unlock()
+	return e.conn.WriteMessage(websocket.BinaryMessage, data)
+}
+
+// exit reports the exit code: as an exit frame without a TTY, and as the
+// close status with one
+func (e *execSession) exit(code int) {
+	status := websocket.CloseNormalClosure
+	if e.tty {
+		if code != 0 {
+			status = 4000 + code&0xff
+		}
+	} else {
+		_ = e.write(streamExit, []byte{byte(code)})
+	}
+
+	e.writeMu.Lock()
+	defer e.writeMu.Unlock()
+	_ = e.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(status, ""))
+}
+
+// fail reports a command that could not start, the way a shell would
+func (e *execSession) fail(err error) {
+	msg := []byte(err.Error() + "\n")
+	if e.tty {
+		_ = e.write(0, msg)
+	} else {
+		_ = e.write(streamStderr, msg)
+	}
+	e.exit(127)
+}
+
+func exitCode(err error) int {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		if code := exitErr.ExitCode(); code >= 0 {
+			return code
+		}
+		return 1
+	}
+	if err != nil {
+		return 1
+	}
+	return 0
+}
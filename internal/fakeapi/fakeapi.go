@@ -0,0 +1,122 @@
+// Package fakeapi is a stand-in for the sprites API that runs each sprite as
+// a local sandbox directory, so the CLI can be developed and tried without a
+// sprites account.
+//
+// Commands run as local processes with the sandbox as their home, and
+// references to /home/sprite are rewritten to point into it. Proxy
+// connections reach ports on this machine. None of this is isolation:
+// commands run with the caller's own privileges.
+package fakeapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Version is reported in the Sprite-Version header. A dev version tells the
+// client it may attach with a working directory.
+const Version = "dev-fake"
+
+// spriteHome is the home directory sprites report
+const spriteHome = "/home/sprite"
+
+var validName = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  64 * 1024,
+	WriteBufferSize: 64 * 1024,
+}
+
+// Server serves the subset of the sprites API the CLI uses
+type Server struct {
+	Root  string // Directory holding one sandbox per sprite
+	Token string // Bearer token clients must send; empty accepts any
+
+	mu      sync.Mutex
+	created map[string]time.Time
+}
+
+// ServeHTTP routes /v1/sprites/{name}[/exec|/proxy]
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Sprite-Version", Version)
+	if s.Token != "" && r.Header.Get("Authorization") != "Bearer "+s.Token {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		return
+	}
+
+	rest, ok := strings.CutPrefix(r.URL.Path, "/v1/sprites/")
+	name, action, _ := strings.Cut(rest, "/")
+	if !ok || !validName.MatchString(name) {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "not found"})
+		return
+	}
+
+	home, created, err := s.sandbox(name)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+
+	switch action {
+	case "":
+		s.getSprite(w, name, created)
+	case "exec":
+		s.exec(w, r, home)
+	case "proxy":
+		s.proxy(w, r)
+	default:
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "not found"})
+	}
+}
+
+// sandbox returns the sprite's home directory, creating it on first use.
+// Every valid name exists, the way a sprite would after "sprite create".
+func (s *Server) sandbox(name string) (string, time.Time, error) {
+	home := filepath.Join(s.Root, name)
+	if err := os.MkdirAll(home, 0755); err != nil {
+		return "", time.Time{}, fmt.Errorf("create sandbox: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.created == nil {
+		s.created = make(map[string]time.Time)
+	}
+	created, ok := s.created[name]
+	if !ok {
+		created = time.Now().UTC()
+		s.created[name] = created
+	}
+	return home, created, nil
+}
+
+func (s *Server) getSprite(w http.ResponseWriter, name string, created time.Time) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"id":           "fake-" + name,
+		"name":         name,
+		"organization": "fake",
+		"status":       "running",
+		"created_at":   created,
+		"updated_at":   created,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// sandboxPath rewrites references to the sprite's home into the sandbox
+func sandboxPath(s, home string) string {
+	return strings.ReplaceAll(s, spriteHome, home)
+}
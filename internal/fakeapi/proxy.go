@@ -0,0 +1,93 @@
+package fakeapi
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// proxy connects a websocket to a TCP port on this machine, after the same
+// {host, port} handshake the sprites proxy uses. A close frame from the
+// client half-closes the TCP connection, whose remaining output is still
+// sent before the close is answered.
+func (s *Server) proxy(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	var init struct {
+		Host string `json:"host"`
+		Port int    `json:"port"`
+	}
+	if err := conn.ReadJSON(&init); err != nil {
+		return
+	}
+	if init.Host == "" {
+		init.Host = "localhost"
+	}
+
+	target := net.JoinHostPort(init.Host, strconv.Itoa(init.Port))
+	tcp, err := net.DialTimeout("tcp", target, 10*time.Second)
+	if err != nil {
+		_ = conn.WriteJSON(map[string]string{"status": "error: " + err.Error(), "target": target})
+		return
+	}
+	defer tcp.Close()
+
+	if err := conn.WriteJSON(map[string]string{"status": "connected", "target": target}); err != nil {
+		return
+	}
+
+	// Answered once the target is done writing, instead of right away
+	conn.SetCloseHandler(func(int, string) error {
+		if tc, ok := tcp.(*net.TCPConn); ok {
+			return tc.CloseWrite()
+		}
+		return nil
+	})
+
+	readDone, writeDone := make(chan error, 1), make(chan struct{})
+	go func() {
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				readDone <- err
+				return
+			}
+			if _, err := tcp.Write(data); err != nil {
+				readDone <- err
+				return
+			}
+		}
+	}()
+	go func() {
+		defer close(writeDone)
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := tcp.Read(buf)
+			if n > 0 {
+				if werr := conn.WriteMessage(websocket.BinaryMessage, buf[:n]); werr != nil {
+					return
+				}
+			}
+			if err != nil {
+				msg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+				_ = conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+				return
+			}
+		}
+	}()
+
+	select {
+	case err := <-readDone:
+		if websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+			<-writeDone
+		}
+	case <-writeDone:
+	}
+}
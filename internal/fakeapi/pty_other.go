@@ -0,0 +1,20 @@
+//go:build !linux
+
+package fakeapi
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+)
+
+var errNoPTY = errors.New("pseudo-terminals are not supported on this platform")
+
+// startPTY is only implemented on Linux; elsewhere TTY sessions use pipes
+func startPTY(cmd *exec.Cmd, rows, cols uint16) (*os.File, error) {
+	return nil, errNoPTY
+}
+
+func setPTYSize(f *os.File, rows, cols uint16) error {
+	return errNoPTY
+}
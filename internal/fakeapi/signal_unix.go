@@ -0,0 +1,32 @@
+//go:build !windows
+
+package fakeapi
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// signals maps the names clients send to local signals
+var signals = map[string]os.Signal{
+	"HUP":  syscall.SIGHUP,
+	"INT":  syscall.SIGINT,
+	"QUIT": syscall.SIGQUIT,
+	"KILL": syscall.SIGKILL,
+	"TERM": syscall.SIGTERM,
+	"USR1": syscall.SIGUSR1,
+	"USR2": syscall.SIGUSR2,
+}
+
+// ownProcessGroup starts cmd in a process group of its own, so whatever it
+// starts can be killed along with it
+func ownProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// signalAll sends sig to the command and everything it started, as a
+// sprite does
+func signalAll(p *os.Process, sig os.Signal) error {
+	return syscall.Kill(-p.Pid, sig.(syscall.Signal))
+}
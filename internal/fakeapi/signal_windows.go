@@ -0,0 +1,21 @@
+package fakeapi
+
+import (
+	"os"
+	"os/exec"
+)
+
+// signals maps the names clients send to local signals. Windows can only
+// kill a process.
+var signals = map[string]os.Signal{
+	"KILL": os.Kill,
+	"TERM": os.Kill,
+}
+
+// ownProcessGroup does nothing; Windows has no process groups to kill
+func ownProcessGroup(cmd *exec.Cmd) {}
+
+// signalAll sends sig to the command only
+func signalAll(p *os.Process, sig os.Signal) error {
+	return p.Signal(sig)
+}
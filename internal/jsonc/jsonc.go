@@ -0,0 +1,148 @@
+// Package jsonc parses JSON with comments and trailing commas, the dialect
+// of VS Code and Zed settings files, and locates parse errors in the
+// original text.
+package jsonc
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// snippetContext is how many lines before the error a snippet shows
+const snippetContext = 2
+
+// SyntaxError is a parse error located in the original text
+type SyntaxError struct {
+	Line    int    // 1-based
+	Column  int    // 1-based, in bytes
+	Msg     string // The underlying parse error
+	Snippet string // The offending lines, with a caret under the column
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("line %d, column %d: %s", e.Line, e.Column, e.Msg)
+}
+
+// Unmarshal parses JSONC data into v. Errors with a position in the text
+// are returned as *SyntaxError.
+func Unmarshal(data []byte, v any) error {
+	err := json.Unmarshal(Standardize(data), v)
+
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		return locate(data, syntaxErr.Offset, err)
+	case errors.As(err, &typeErr):
+		return locate(data, typeErr.Offset, err)
+	}
+	return err
+}
+
+// Standardize returns data as plain JSON: comments and trailing commas are
+// replaced with spaces, so offsets, lines and columns are unchanged.
+func Standardize(data []byte) []byte {
+	out := bytes.Clone(data)
+	blankComments(out)
+	blankTrailingCommas(out)
+	return out
+}
+
+// blankComments replaces // and /* */ comments outside strings with
+// spaces, keeping newlines
+func blankComments(b []byte) {
+	inString := false
+	for i := 0; i < len(b); i++ {
+		switch {
+		case inString:
+			if b[i] == '\\' {
+				i++
+			} else if b[i] == '"' {
+				inString = false
+			}
+		case b[i] == '"':
+			inString = true
+		case b[i] == '/' && i+1 < len(b) && b[i+1] == '/':
+			for ; i < len(b) && b[i] != '\n'; i++ {
+				b[i] = ' '
+			}
+		case b[i] == '/' && i+1 < len(b) && b[i+1] == '*':
+			b[i], b[i+1] = ' ', ' '
+			for i += 2; i < len(b); i++ {
+				if b[i] == '*' && i+1 < len(b) && b[i+1] == '/' {
+					b[i], b[i+1] = ' ', ' '
+					i++
+					break
+				}
+				if b[i] != '\n' {
+					b[i] = ' '
+				}
+			}
+		}
+	}
+}
+
+// blankTrailingCommas replaces commas outside strings that are followed,
+// past whitespace, by a closing bracket
+func blankTrailingCommas(b []byte) {
+	inString := false
+	for i := 0; i < len(b); i++ {
+		switch {
+		case inString:
+			if b[i] == '\\' {
+				i++
+			} else if b[i] == '"' {
+				inString = false
+			}
+		case b[i] == '"':
+			inString = true
+		case b[i] == ',':
+			j := i + 1
+			for j < len(b) && isSpace(b[j]) {
+				j++
+			}
+			if j < len(b) && (b[j] == '}' || b[j] == ']') {
+				b[i] = ' '
+			}
+		}
+	}
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+// locate builds a SyntaxError for an error reported at a byte offset
+func locate(data []byte, offset int64, err error) *SyntaxError {
+	// The decoder reports the offset after the byte it choked on
+	pos := int(min(max(offset-1, 0), int64(len(data))))
+
+	line := 1 + bytes.Count(data[:pos], []byte("\n"))
+	lineStart := bytes.LastIndexByte(data[:pos], '\n') + 1
+	return &SyntaxError{
+		Line:    line,
+		Column:  pos - lineStart + 1,
+		Msg:     err.Error(),
+		Snippet: snippet(data, line, pos-lineStart),
+	}
+}
+
+// snippet returns the lines up to line (1-based), numbered, followed by a
+// caret under col (0-based)
+func snippet(data []byte, line, col int) string {
+	lines := strings.Split(string(data), "\n")
+	if line > len(lines) {
+		return ""
+	}
+
+	var b strings.Builder
+	width := len(fmt.Sprint(line))
+	for n := max(1, line-snippetContext); n <= line; n++ {
+		fmt.Fprintf(&b, "%*d | %s\n", width, n, strings.TrimRight(lines[n-1], "\r"))
+	}
+	fmt.Fprintf(&b, "%*s | %s^", width, "", strings.Repeat(" ", col))
+	return b.String()
+}
@@ -0,0 +1,137 @@
+package jsonc
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestUnmarshal(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want any
+	}{
+		{"plain JSON", `{"a": 1, "b": [true, null]}`, map[string]any{"a": 1.0, "b": []any{true, nil}}},
+		{"line comment", "{\n  // a comment\n  \"a\": 1 // trailing\n}", map[string]any{"a": 1.0}},
+		{"block comment", `{/* one */ "a": /* two */ 1}`, map[string]any{"a": 1.0}},
+		{"multiline block comment", "{\n/*\n  \"a\": 2,\n*/\n\"a\": 1}", map[string]any{"a": 1.0}},
+		{"comment at end of input", `{"a": 1} // done`, map[string]any{"a": 1.0}},
+		{"line comment in string", `{"url": "https://example.com"}`, map[string]any{"url": "https://example.com"}},
+		{"block comment in string", `{"glob": "src/*.go /* not a comment */"}`, map[string]any{"glob": "src/*.go /* not a comment */"}},
+		{"escaped quote before comment marker", `{"a": "say \"//\" here"}`, map[string]any{"a": `say "//" here`}},
+		{"escaped backslash ends string", `{"a": "C:\\"} // comment`, map[string]any{"a": `C:\`}},
+		{"trailing comma in object", `{"a": 1,}`, map[string]any{"a": 1.0}},
+		{"trailing comma in array", `{"a": [1, 2,]}`, map[string]any{"a": []any{1.0, 2.0}}},
+		{"trailing comma before newline", "{\n  \"a\": [\n    1,\n  ],\n}", map[string]any{"a": []any{1.0}}},
+		{"trailing comma before comment", "{\"a\": 1, // last\n}", map[string]any{"a": 1.0}},
+		{"comma and bracket in string", `{"a": ",}", "b": ",]"}`, map[string]any{"a": ",}", "b": ",]"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got any
+			if err := Unmarshal([]byte(tt.in), &got); err != nil {
+				t.Fatalf("Unmarshal(%q): %v", tt.in, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Unmarshal(%q) = %#v, want %#v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestStandardize checks that comments and trailing commas become spaces,
+// leaving every other byte where it was
+func TestStandardize(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"{\"a\": 1, // x\n}", "{\"a\": 1      \n}"},
+		{"[1 /* x\ny */, 2]", "[1     \n    , 2]"},
+		{`["//", "/*"]`, `["//", "/*"]`},
+		{`[1,]`, `[1 ]`},
+		{"/* unterminated", "               "},
+	}
+	for _, tt := range tests {
+		got := string(Standardize([]byte(tt.in)))
+		if got != tt.want {
+			t.Errorf("Standardize(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+		if len(got) != len(tt.in) {
+			t.Errorf("Standardize(%q) is %d bytes, want %d", tt.in, len(got), len(tt.in))
+		}
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		in         string
+		typed      bool // Decoded into a struct rather than an any
+		line, col  int
+		wantInSnip string
+	}{
+		{"missing comma", "{\n  \"a\": 1\n  \"b\": 2\n}", false, 3, 3, `3 |   "b": 2`},
+		{"after comments", "// header\n/* block\n   comment */\n{\"a\": tru}", false, 4, 10, `4 | {"a": tru}`},
+		{"unterminated block comment", "{\"a\": 1 /* never closed\n", false, 1, 24, `1 | {"a": 1 /* never closed`},
+		{"unterminated line comment", `{"a": 1 // no newline`, false, 1, 21, `1 | {"a": 1 // no newline`},
+		{"unterminated string", `{"a": "open}`, false, 1, 12, `1 | {"a": "open}`},
+		{"empty element", `[1,,]`, false, 1, 5, `1 | [1,,]`},
+		{"CRLF", "{\r\n\"a\": 1\r\n\"b\": 2}", false, 3, 1, "3 | \"b\": 2}\n"},
+		{"wrong type", "{\n\"name\": 3}", true, 2, 9, `2 | "name": 3}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var target any = new(any)
+			if tt.typed {
+				target = &struct {
+					Name string `json:"name"`
+				}{}
+			}
+			err := Unmarshal([]byte(tt.in), target)
+			var syntaxErr *SyntaxError
+			if !errors.As(err, &syntaxErr) {
+				t.Fatalf("Unmarshal(%q) error = %v, want a *SyntaxError", tt.in, err)
+			}
+			if syntaxErr.Line != tt.line || syntaxErr.Column != tt.col {
+				t.Errorf("error at line %d, column %d, want line %d, column %d (%v)", syntaxErr.Line, syntaxErr.Column, tt.line, tt.col, err)
+			}
+			if !strings.HasPrefix(err.Error(), "line ") {
+				t.Errorf("Error() = %q, want it to start with the position", err)
+			}
+			if !strings.Contains(syntaxErr.Snippet, tt.wantInSnip) {
+				t.Errorf("snippet doesn't show %q:\n%s", tt.wantInSnip, syntaxErr.Snippet)
+			}
+			if strings.Contains(syntaxErr.Snippet, "\r") {
+				t.Errorf("snippet has carriage returns:\n%q", syntaxErr.Snippet)
+			}
+		})
+	}
+}
+
+// TestSnippet checks the context lines and the caret's column
+func TestSnippet(t *testing.T) {
+	data := "one\ntwo\nthree\nfour\n"
+	tests := []struct {
+		line, col int
+		want      string
+	}{
+		{1, 0, "1 | one\n  | ^"},
+		{3, 2, "1 | one\n2 | two\n3 | three\n  |   ^"},
+		{4, 3, "2 | two\n3 | three\n4 | four\n  |    ^"},
+		{9, 0, ""},
+	}
+	for _, tt := range tests {
+		if got := snippet([]byte(data), tt.line, tt.col); got != tt.want {
+			t.Errorf("snippet(line %d, col %d) =\n%s\nwant\n%s", tt.line, tt.col, got, tt.want)
+		}
+	}
+
+	// The line number column widens to fit the last line
+	long := strings.Repeat("x\n", 9) + "[1,,]"
+	want := " 8 | x\n 9 | x\n10 | [1,,]\n   |    ^"
+	if got := snippet([]byte(long), 10, 3); got != want {
+		t.Errorf("snippet at line 10 =\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,111 @@
+// Package logfile writes logs to a file that is rotated by size, keeping a
+// few of the previous files as path.1 (newest) to path.N.
+package logfile
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// DefaultBackups is how many rotated files are kept besides the current one
+const DefaultBackups = 3
+
+// Writer appends to a log file, rotating it once a write would take it
+// past the size limit. It is safe for concurrent use.
+type Writer struct {
+	path    string
+	maxSize int64 // 0 never rotates
+	backups int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// Open opens path for appending, creating it and its directory. The file
+// is rotated before it grows past maxSize bytes, keeping backups old files.
+func Open(path string, maxSize int64, backups int) (*Writer, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	w := &Writer{path: path, maxSize: maxSize, backups: max(backups, 0)}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Path returns the path of the current log file
+func (w *Writer) Path() string {
+	return w.path
+}
+
+func (w *Writer) open() error {
+	f, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	w.file, w.size = f, info.Size()
+	return nil
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return 0, os.ErrClosed
+	}
+	// A write bigger than the limit still goes to a file of its own
+	if w.maxSize > 0 && w.size > 0 && w.size+int64(len(p)) > w.maxSize {
+		if err := w.rotate(); err != nil {
+			return 0, fmt.Errorf("rotate %s: %w", w.path, err)
+		}
+	}
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// rotate shifts path.1..path.N-1 up by one, moves the current file to
+// path.1 and starts a new one. The oldest backup is dropped. Renames are
+// best effort: if they fail, logging carries on in the same file.
+func (w *Writer) rotate() error {
+	w.file.Close()
+	w.file = nil
+
+	if w.backups == 0 {
+		os.Remove(w.path)
+	} else {
+		os.Remove(w.backup(w.backups))
+		for i := w.backups - 1; i >= 1; i-- {
+			os.Rename(w.backup(i), w.backup(i+1))
+		}
+		os.Rename(w.path, w.backup(1))
+	}
+	return w.open()
+}
+
+func (w *Writer) backup(i int) string {
+	return fmt.Sprintf("%s.%d", w.path, i)
+}
+
+// Close closes the current file. Later writes fail.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
@@ -0,0 +1,185 @@
+// Package ratelimit throttles forwarded traffic with token buckets shared
+// by every connection they are given to.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// burst is how much traffic a limiter lets through at once after an idle
+// spell, as a share of a second's worth
+const burst = 0.1
+
+// Limiter is a token bucket limiting a byte rate. The zero rate is
+// unlimited and costs one atomic load per call. The rate can be changed
+// while the limiter is in use.
+type Limiter struct {
+	rate atomic.Int64 // Bytes per second; 0 for unlimited
+
+	mu     sync.Mutex
+	tokens float64 // Negative while callers wait for earlier reservations
+	last   time.Time
+}
+
+// New returns a limiter of rate bytes per second, or unlimited for 0
+func New(rate int64) *Limiter {
+	l := &Limiter{}
+	l.SetRate(rate)
+	return l
+}
+
+// Rate returns the limit in bytes per second, or 0 when unlimited
+func (l *Limiter) Rate() int64 {
+	if l == nil {
+		return 0
+	}
+	return l.rate.Load()
+}
+
+// SetRate changes the limit. Traffic already waiting keeps its delay.
+func (l *Limiter) SetRate(rate int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// The time since the last call is credited at the old rate first;
+	// nothing is owed after an unlimited spell
+	now := time.Now()
+	if old := float64(l.rate.Load()); old > 0 {
+		l.tokens = min(l.tokens+now.Sub(l.last).Seconds()*old, old*burst)
+	} else {
+		l.tokens = 0
+	}
+	l.rate.Store(max(rate, 0))
+	l.tokens = min(l.tokens, float64(rate)*burst)
+	l.last = now
+}
+
+// WaitN blocks until n bytes may pass, or ctx ends. A nil limiter doesn't
+// limit.
+func (l *Limiter) WaitN(ctx context.Context, n int) error {
+	if l == nil || l.rate.Load() == 0 || n <= 0 {
+		return nil
+	}
+
+	l.mu.Lock()
+	rate := float64(l.rate.Load())
+	if rate == 0 {
+		l.mu.Unlock()
+		return nil
+	}
+	now := time.Now()
+	l.tokens = min(l.tokens+now.Sub(l.last).Seconds()*rate, rate*burst)
+	l.last = now
+	l.tokens -= float64(n)
+	wait := time.Duration(-l.tokens / rate * float64(time.Second))
+	l.mu.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+	t := time.NewTimer(wait)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Conn limits a connection's reads with down and its writes with up.
+// Either may be nil.
+func Conn(conn net.Conn, up, down *Limiter) net.Conn {
+	if up == nil && down == nil {
+		return conn
+	}
+	return &limitedConn{Conn: conn, up: up, down: down}
+}
+
+type limitedConn struct {
+	net.Conn
+	up, down *Limiter
+}
+
+func (c *limitedConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if werr := c.down.WaitN(context.Background(), n); werr != nil && err == nil {
+		err = werr
+	}
+	return n, err
+}
+
+func (c *limitedConn) Write(p []byte) (int, error) {
+	if err := c.up.WaitN(context.Background(), len(p)); err != nil {
+		return 0, err
+	}
+	return c.Conn.Write(p)
+}
+
+// units are the rate suffixes Parse accepts, in bytes
+var units = map[string]float64{
+	"":    1,
+	"b":   1,
+	"k":   1e3,
+	"kb":  1e3,
+	"kib": 1 << 10,
+	"m":   1e6,
+	"mb":  1e6,
+	"mib": 1 << 20,
+	"g":   1e9,
+	"gb":  1e9,
+	"gib": 1 << 30,
+}
+
+// Parse reads a rate such as "5MB/s", "500k" or "1.5MiB/s", in bytes per
+// second. "0", "" and "none" are unlimited.
+func Parse(s string) (int64, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "" || s == "none" {
+		return 0, nil
+	}
+	num := strings.TrimSuffix(s, "/s")
+	i := strings.IndexFunc(num, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+	unit := ""
+	if i >= 0 {
+		num, unit = num[:i], strings.TrimSpace(num[i:])
+	}
+	mult, ok := units[unit]
+	v, err := strconv.ParseFloat(num, 64)
+	if !ok || err != nil || v < 0 || v*mult >= math.MaxInt64 {
+		return 0, fmt.Errorf("invalid rate %q (e.g. 5MB/s, 500KB/s or 0 for none)", s)
+	}
+	// A rate under a byte per second would otherwise become 0, unlimited
+	rate := int64(v * mult)
+	if rate == 0 && v != 0 {
+		return 0, fmt.Errorf("rate %q is below 1B/s (use 0 or none for no limit)", s)
+	}
+	return rate, nil
+}
+
+// Format renders a rate for display, or "none" when unlimited
+func Format(rate int64) string {
+	switch {
+	case rate <= 0:
+		return "none"
+	case rate >= 1e9:
+		return formatUnit(float64(rate)/1e9) + "GB/s"
+	case rate >= 1e6:
+		return formatUnit(float64(rate)/1e6) + "MB/s"
+	case rate >= 1e3:
+		return formatUnit(float64(rate)/1e3) + "KB/s"
+	}
+	return strconv.FormatInt(rate, 10) + "B/s"
+}
+
+// formatUnit renders a rate in some unit with at most two decimals
+func formatUnit(v float64) string {
+	return strconv.FormatFloat(math.Round(v*100)/100, 'f', -1, 64)
+}
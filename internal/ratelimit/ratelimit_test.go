@@ -0,0 +1,200 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"none", 0, false},
+		{"0", 0, false},
+		{"0MB/s", 0, false},
+		{"500", 500, false},
+		{"500b", 500, false},
+		{"500k", 500_000, false},
+		{"5MB/s", 5_000_000, false},
+		{" 5 mb/s ", 5_000_000, false},
+		{"1.5MiB/s", 1_572_864, false},
+		{"1GiB", 1 << 30, false},
+		{"2.5KB", 2500, false},
+		{"1.9", 1, false},
+		{"0.5", 0, true},
+		{"0.0001KB/s", 0, true},
+		{"-1", 0, true},
+		{"5XB/s", 0, true},
+		{"fast", 0, true},
+		{"1e30GB", 0, true},
+		{"10000000000GB", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Parse(%q) error = %v, want error %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Parse(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		rate int64
+		want string
+	}{
+		{0, "none"},
+		{-1, "none"},
+		{999, "999B/s"},
+		{1500, "1.5KB/s"},
+		{5_000_000, "5MB/s"},
+		{1_234_567, "1.23MB/s"},
+		{2_000_000_000, "2GB/s"},
+	}
+	for _, tt := range tests {
+		if got := Format(tt.rate); got != tt.want {
+			t.Errorf("Format(%d) = %q, want %q", tt.rate, got, tt.want)
+		}
+		if tt.rate > 0 && tt.rate%10 == 0 {
+			if back, err := Parse(tt.want); err != nil || back != tt.rate {
+				t.Errorf("Parse(Format(%d)) = %d, %v", tt.rate, back, err)
+			}
+		}
+	}
+}
+
+// within fails unless got is within 10% of want
+func within(t *testing.T, what string, got, want time.Duration) {
+	t.Helper()
+	if got < want*9/10 || got > want*11/10 {
+		t.Errorf("%s took %v, want %v ±10%%", what, got, want)
+	}
+}
+
+// TestLimiterThroughput sends through a limiter shared by several writers
+// and checks the total rate
+func TestLimiterThroughput(t *testing.T) {
+	const (
+		rate    = 1 << 20
+		writers = 4
+		chunk   = 16 << 10
+		total   = rate / 2
+	)
+	l := New(rate)
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for sent := 0; sent < total/writers; sent += chunk {
+				if err := l.WaitN(context.Background(), chunk); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	within(t, "sending half a second's worth", time.Since(start), 500*time.Millisecond)
+}
+
+// TestConnThroughput limits each direction of a connection separately
+func TestConnThroughput(t *testing.T) {
+	const total = 256 << 10
+	tests := []struct {
+		name     string
+		up, down int64
+		want     time.Duration
+	}{
+		{"up limited", 512 << 10, 0, 500 * time.Millisecond},
+		{"down limited", 0, 512 << 10, 500 * time.Millisecond},
+		{"both, down slower", 1 << 20, 256 << 10, time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer client.Close()
+			defer server.Close()
+			// Whatever is written comes back, so both directions carry it
+			go io.Copy(server, server)
+			conn := Conn(client, New(tt.up), New(tt.down))
+
+			start := time.Now()
+			go func() {
+				buf := make([]byte, 8<<10)
+				for sent := 0; sent < total; sent += len(buf) {
+					if _, err := conn.Write(buf); err != nil {
+						return
+					}
+				}
+			}()
+			if _, err := io.ReadFull(conn, make([]byte, total)); err != nil {
+				t.Fatal(err)
+			}
+			within(t, "the echo", time.Since(start), tt.want)
+		})
+	}
+}
+
+// TestSetRate changes the rate while a writer is limited
+func TestSetRate(t *testing.T) {
+	l := New(1 << 20)
+	if err := l.WaitN(context.Background(), 1<<20); err != nil {
+		t.Fatal(err)
+	}
+	l.SetRate(0)
+	start := time.Now()
+	if err := l.WaitN(context.Background(), 100<<20); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > 10*time.Millisecond {
+		t.Errorf("unlimited wait took %v", d)
+	}
+
+	l.SetRate(256 << 10)
+	start = time.Now()
+	if err := l.WaitN(context.Background(), 128<<10); err != nil {
+		t.Fatal(err)
+	}
+	within(t, "half a second at the new rate", time.Since(start), 500*time.Millisecond)
+}
+
+func TestWaitNCanceled(t *testing.T) {
+	l := New(1000)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := l.WaitN(ctx, 1_000_000); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("WaitN = %v, want the context's deadline", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("canceled wait took %v", d)
+	}
+}
+
+func TestNilLimiter(t *testing.T) {
+	var l *Limiter
+	if l.Rate() != 0 {
+		t.Errorf("nil limiter rate = %d, want 0", l.Rate())
+	}
+	if err := l.WaitN(context.Background(), 1<<30); err != nil {
+		t.Errorf("nil limiter WaitN = %v", err)
+	}
+	c, _ := net.Pipe()
+	defer c.Close()
+	if Conn(c, nil, nil) != c {
+		t.Error("Conn wrapped a connection without limiters")
+	}
+}
@@ -0,0 +1,187 @@
+package sshconfig
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Conflict is an option from elsewhere in the SSH config, typically a
+// global Host * block, that breaks connections to a sprite's entry
+type Conflict struct {
+	Option  string // As ssh -G names it, e.g. "proxycommand"
+	Value   string // Its effective value
+	Problem string // What goes wrong, in plain language
+
+	// Fix holds directives that override the option inside the entry's
+	// managed block. Empty when the other block has to be changed instead.
+	Fix []string
+}
+
+// effective holds the options ssh -G reports for a host, by lowercase name
+type effective map[string][]string
+
+// get returns the first value of an option, or ""
+func (o effective) get(option string) string {
+	if v := o[option]; len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+// conflictCheck detects one kind of conflict in the effective options for
+// an entry, returning the offending value
+type conflictCheck struct {
+	option  string
+	problem string
+	detect  func(o effective, e Entry) (string, bool)
+	fix     func(o effective, e Entry) []string // nil when no override helps
+}
+
+// overridden reports ssh ignoring one of the entry's own directives: ssh
+// keeps the first value it finds, so a block earlier in the file wins and
+// nothing in the entry can override it. want returns "" for directives the
+// entry doesn't write.
+func overridden(option, problem string, want func(e Entry) string) conflictCheck {
+	return conflictCheck{
+		option:  option,
+		problem: problem + ": an earlier Host or Match block sets it, and ssh uses the first value it finds",
+		detect: func(o effective, e Entry) (string, bool) {
+			got, w := o.get(option), want(e)
+			return got, got != "" && w != "" && !strings.EqualFold(got, w)
+		},
+	}
+}
+
+// conflictChecks are the known options that break sprite entries
+var conflictChecks = []conflictCheck{
+	{
+		option:  "proxycommand",
+		problem: "ssh runs this command to reach every host, so it doesn't connect to the local sprite-bootstrap server directly",
+		detect: func(o effective, e Entry) (string, bool) {
+			v := o.get("proxycommand")
+			if e.ProxyCommand != "" {
+				return v, v != e.ProxyCommand
+			}
+			return v, v != "" && v != "none"
+		},
+		fix: func(_ effective, e Entry) []string {
+			if e.ProxyCommand != "" {
+				return nil // The entry's own ProxyCommand lost to the other block
+			}
+			return []string{"ProxyCommand none"}
+		},
+	},
+	{
+		option:  "proxyjump",
+		problem: "ssh tunnels through this jump host, which can't reach the sprite-bootstrap server on your machine",
+		detect: func(o effective, _ Entry) (string, bool) {
+			v := o.get("proxyjump")
+			return v, v != "" && v != "none"
+		},
+		fix: func(effective, Entry) []string { return []string{"ProxyJump none"} },
+	},
+	{
+		option:  "controlpath",
+		problem: "ControlMaster is on, but the ControlPath directory doesn't exist, so ssh can't create the control socket and fails",
+		detect: func(o effective, _ Entry) (string, bool) {
+			path := o.get("controlpath")
+			if path == "" || path == "none" || o.get("controlmaster") == "false" || o.get("controlmaster") == "no" {
+				return path, false
+			}
+			dir := filepath.Dir(expandHome(path))
+			if strings.Contains(dir, "%") {
+				return path, false // Depends on the connection; can't be checked here
+			}
+			_, err := os.Stat(dir)
+			return path, os.IsNotExist(err)
+		},
+		fix: func(effective, Entry) []string { return []string{"ControlPath ~/.ssh/sprite-bootstrap-%C"} },
+	},
+	{
+		option:  "identitiesonly",
+		problem: "ssh only offers keys from IdentityFile, none of which exist, so the server gets no key to accept",
+		detect: func(o effective, _ Entry) (string, bool) {
+			if o.get("identitiesonly") != "yes" {
+				return "", false
+			}
+			for _, f := range o["identityfile"] {
+				if _, err := os.Stat(expandHome(f)); err == nil {
+					return "yes", false
+				}
+			}
+			return "yes", true
+		},
+		fix: func(effective, Entry) []string {
+			for _, name := range []string{"id_ed25519", "id_ecdsa", "id_rsa"} {
+				path := filepath.Join("~", ".ssh", name)
+				if _, err := os.Stat(expandHome(path)); err == nil {
+					return []string{"IdentityFile " + filepath.ToSlash(path)}
+				}
+			}
+			// No key on disk: let ssh offer the agent's keys
+			return []string{"IdentitiesOnly no"}
+		},
+	},
+	overridden("hostname", "ssh connects to another address than the sprite-bootstrap server",
+		func(e Entry) string { return e.addr(e.Host) }),
+	overridden("port", "ssh connects to another port than the sprite-bootstrap server's",
+		func(e Entry) string { return e.addr(strconv.Itoa(e.Port)) }),
+	overridden("user", "ssh logs in as another user, which the server takes as the sprite name",
+		func(e Entry) string { return e.sshUser() }),
+}
+
+// CheckConflicts asks the local ssh client for the effective options of the
+// entry's host alias and returns the known ones that break it
+func CheckConflicts(e Entry) ([]Conflict, error) {
+	out, err := exec.Command("ssh", "-G", HostName(e.Sprite)).Output()
+	if err != nil {
+		return nil, fmt.Errorf("ssh -G: %w", err)
+	}
+	return findConflicts(parseEffective(string(out)), e), nil
+}
+
+// findConflicts runs every check against the effective options
+func findConflicts(o effective, e Entry) []Conflict {
+	var conflicts []Conflict
+	for _, check := range conflictChecks {
+		value, bad := check.detect(o, e)
+		if !bad {
+			continue
+		}
+		c := Conflict{Option: check.option, Value: value, Problem: check.problem}
+		if check.fix != nil {
+			c.Fix = check.fix(o, e)
+		}
+		conflicts = append(conflicts, c)
+	}
+	return conflicts
+}
+
+// parseEffective parses ssh -G output, one "option value" per line
+func parseEffective(out string) effective {
+	o := make(effective)
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		option, value, _ := strings.Cut(strings.TrimSpace(scanner.Text()), " ")
+		if option != "" {
+			option = strings.ToLower(option)
+			o[option] = append(o[option], value)
+		}
+	}
+	return o
+}
+
+// expandHome replaces a leading ~/ with the home directory
+func expandHome(path string) string {
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, rest)
+		}
+	}
+	return path
+}
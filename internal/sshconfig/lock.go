@@ -0,0 +1,118 @@
+package sshconfig
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// lockTimeout is how long withLock waits for another process's lock
+	lockTimeout = 5 * time.Second
+	// lockPoll is how often a held lock is checked
+	lockPoll = 100 * time.Millisecond
+	// lockWriteGrace is how long a lock file may go without a PID, while
+	// its owner is still writing it, before it is considered abandoned
+	lockWriteGrace = 2 * time.Second
+)
+
+// withLock executes a function while holding a lock on the SSH config. The
+// lock is a file created exclusively and holding the owner's PID; one left
+// by a process that has exited is taken over. It fails when another
+// process keeps the lock for lockTimeout.
+func withLock(configPath string, fn func() error) error {
+	lockPath := configPath + ".sprite-bootstrap.lock"
+	deadline := time.Now().Add(lockTimeout)
+	for {
+		token, err := createLock(lockPath)
+		if err == nil {
+			defer releaseLock(lockPath, token)
+			return fn()
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return fmt.Errorf("failed to create lock file: %w", err)
+		}
+
+		pid, content, stale := lockOwner(lockPath)
+		if stale {
+			breakLock(lockPath, content)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%s is locked by another sprite-bootstrap (pid %d); remove %s if that process is gone",
+				configPath, pid, lockPath)
+		}
+		time.Sleep(lockPoll)
+	}
+}
+
+// lockSeq tells apart the locks this process takes
+var lockSeq atomic.Int64
+
+// createLock creates the lock file, failing if it exists, and writes this
+// process's PID to it followed by a token unique to this lock, which it
+// returns
+func createLock(lockPath string) (string, error) {
+	f, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return "", err
+	}
+	token := fmt.Sprintf("%d %d-%d\n", os.Getpid(), time.Now().UnixNano(), lockSeq.Add(1))
+	_, err = f.WriteString(token)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(lockPath)
+		return "", err
+	}
+	return token, nil
+}
+
+// releaseLock removes the lock file, unless another process has broken it
+// and taken the lock since
+func releaseLock(lockPath, token string) {
+	if data, err := os.ReadFile(lockPath); err == nil && string(data) == token {
+		os.Remove(lockPath)
+	}
+}
+
+// lockOwner returns the PID in the lock file, its content and whether the
+// lock is stale: its owner has exited, or it never got a PID written to it
+func lockOwner(lockPath string) (int, string, bool) {
+	info, err := os.Stat(lockPath)
+	if err != nil {
+		// Released meanwhile: trying again takes it
+		return 0, "", false
+	}
+	data, err := os.ReadFile(lockPath)
+	if err != nil {
+		return 0, "", false
+	}
+	field, _, _ := strings.Cut(strings.TrimSpace(string(data)), " ")
+	pid, err := strconv.Atoi(field)
+	if err != nil || pid <= 0 {
+		return 0, string(data), time.Since(info.ModTime()) > lockWriteGrace
+	}
+	return pid, string(data), !processRunning(pid)
+}
+
+// breakLock removes a stale lock whose content was seen as stale. Another
+// process may have broken it first and taken the lock since, so the file
+// is moved aside under a name of its own and only deleted if it still has
+// that content; a live lock moved by mistake is linked back.
+func breakLock(lockPath, stale string) {
+	aside := fmt.Sprintf("%s.stale-%d-%d", lockPath, os.Getpid(), lockSeq.Add(1))
+	if err := os.Rename(lockPath, aside); err != nil {
+		return
+	}
+	defer os.Remove(aside)
+	if data, err := os.ReadFile(aside); err == nil && string(data) != stale {
+		// Fails harmlessly if yet another process has locked meanwhile
+		_ = os.Link(aside, lockPath)
+	}
+}
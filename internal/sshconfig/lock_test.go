@@ -0,0 +1,180 @@
+package sshconfig
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// exitedPID returns the PID of a process that has exited
+func exitedPID(t *testing.T) int {
+	t.Helper()
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Skip("can't run true:", err)
+	}
+	return cmd.Process.Pid
+}
+
+// shortLockTimes makes withLock give up and poll quickly for the test
+func shortLockTimes(t *testing.T) {
+	timeout, poll := lockTimeout, lockPoll
+	lockTimeout, lockPoll = 300*time.Millisecond, time.Millisecond
+	t.Cleanup(func() { lockTimeout, lockPoll = timeout, poll })
+}
+
+func TestWithLock(t *testing.T) {
+	shortLockTimes(t)
+
+	tests := []struct {
+		name    string
+		lock    string        // Lock file content; "-" for no lock file
+		age     time.Duration // How old the lock file is
+		wantRun bool
+	}{
+		{"free", "-", 0, true},
+		{"owner exited", "exited", 0, true},
+		{"abandoned without a PID", "", time.Minute, true},
+		{"garbage", "not a pid", time.Minute, true},
+		{"owner running", strconv.Itoa(os.Getpid()), 0, false},
+		{"owner still writing", "", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := filepath.Join(t.TempDir(), "config")
+			lockPath := config + ".sprite-bootstrap.lock"
+			content := tt.lock
+			if content == "exited" {
+				content = strconv.Itoa(exitedPID(t))
+			}
+			if content != "-" {
+				if err := os.WriteFile(lockPath, []byte(content), 0600); err != nil {
+					t.Fatal(err)
+				}
+				mtime := time.Now().Add(-tt.age)
+				if err := os.Chtimes(lockPath, mtime, mtime); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			ran := false
+			err := withLock(config, func() error {
+				ran = true
+				data, err := os.ReadFile(lockPath)
+				if err != nil {
+					t.Errorf("lock file missing while held: %v", err)
+				} else if pid, _, stale := lockOwner(lockPath); pid != os.Getpid() || stale {
+					t.Errorf("lock file holds %q, want this process's PID", data)
+				}
+				return nil
+			})
+			if ran != tt.wantRun {
+				t.Fatalf("fn ran = %v, want %v (err %v)", ran, tt.wantRun, err)
+			}
+			if tt.wantRun {
+				if err != nil {
+					t.Fatalf("withLock() = %v", err)
+				}
+				if _, err := os.Stat(lockPath); !os.IsNotExist(err) {
+					t.Errorf("lock file left behind: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("withLock() = nil, want a timeout error")
+			}
+			data, err := os.ReadFile(lockPath)
+			if err != nil || string(data) != content {
+				t.Errorf("other process's lock = %q, %v; want %q kept", data, err, content)
+			}
+		})
+	}
+}
+
+// TestWithLockExclusive runs several lockers at once, as separate
+// sprite-bootstrap processes would
+func TestWithLockExclusive(t *testing.T) {
+	shortLockTimes(t)
+	lockTimeout = 10 * time.Second
+	config := filepath.Join(t.TempDir(), "config")
+
+	var inside, most atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := withLock(config, func() error {
+				n := inside.Add(1)
+				if n > most.Load() {
+					most.Store(n)
+				}
+				time.Sleep(5 * time.Millisecond)
+				inside.Add(-1)
+				return nil
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if got := most.Load(); got != 1 {
+		t.Errorf("%d lockers held the lock at once, want 1", got)
+	}
+}
+
+// TestWithLockKeepsOthersLock checks that a lock broken and taken by
+// another process while fn runs isn't removed on release
+func TestWithLockKeepsOthersLock(t *testing.T) {
+	config := filepath.Join(t.TempDir(), "config")
+	lockPath := config + ".sprite-bootstrap.lock"
+
+	err := withLock(config, func() error {
+		if err := os.Remove(lockPath); err != nil {
+			return err
+		}
+		return os.WriteFile(lockPath, []byte("1\n"), 0600)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(lockPath); err != nil || string(data) != "1\n" {
+		t.Errorf("other process's lock = %q, %v; want it kept", data, err)
+	}
+}
+
+// TestBreakLock checks that breaking a stale lock that another process
+// has already replaced with its own leaves the new lock in place
+func TestBreakLock(t *testing.T) {
+	lockPath := filepath.Join(t.TempDir(), "config.sprite-bootstrap.lock")
+	stale := strconv.Itoa(exitedPID(t)) + "\n"
+	if err := os.WriteFile(lockPath, []byte(stale), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, content, isStale := lockOwner(lockPath); !isStale || content != stale {
+		t.Fatalf("lockOwner() = %q, stale %v; want %q, stale", content, isStale, stale)
+	}
+	breakLock(lockPath, stale)
+	if _, err := os.Stat(lockPath); !os.IsNotExist(err) {
+		t.Fatalf("stale lock not removed: %v", err)
+	}
+
+	token, err := createLock(lockPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A second process that also saw the stale lock breaks it too late
+	breakLock(lockPath, stale)
+	if data, err := os.ReadFile(lockPath); err != nil || string(data) != token {
+		t.Errorf("live lock = %q, %v; want %q kept", data, err, token)
+	}
+	if matches, _ := filepath.Glob(lockPath + ".stale-*"); len(matches) != 0 {
+		t.Errorf("moved-aside locks left behind: %v", matches)
+	}
+}
@@ -0,0 +1,19 @@
+//go:build !windows
+
+package sshconfig
+
+import (
+	"os"
+	"syscall"
+)
+
+// processRunning checks if a process is still running
+func processRunning(pid int) bool {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	err = process.Signal(syscall.Signal(0))
+	// EPERM means it runs as another user
+	return err == nil || err == syscall.EPERM
+}
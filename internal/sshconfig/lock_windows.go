@@ -0,0 +1,16 @@
+//go:build windows
+
+package sshconfig
+
+import "os"
+
+// processRunning checks if a process is still running on Windows, where
+// FindProcess opens the process and fails once it has exited
+func processRunning(pid int) bool {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	process.Release()
+	return true
+}
@@ -0,0 +1,448 @@
+// Package sshconfig manages sprite-bootstrap's entries in ~/.ssh/config.
+//
+// Changes are collected in a Transaction and written with a single locked,
+// atomic rewrite on Commit, so bootstrapping several tools or sprites
+// touches the file once.
+package sshconfig
+
+import (
+	"cmp"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/vaurdan/sprite-bootstrap/internal/sshdir"
+	"github.com/vaurdan/sprite-bootstrap/internal/textfile"
+)
+
+// Markers for our managed SSH config entries
+const (
+	startMarker = "# >>> sprite-bootstrap %s >>>"
+	endMarker   = "# <<< sprite-bootstrap %s <<<"
+)
+
+// Entry is the SSH config block for one sprite
+type Entry struct {
+	Sprite string // Sprite name
+	Host   string // Address of the SSH server
+	Port   int
+	User   string // SSH user, e.g. sprite@org; defaults to Sprite
+
+	// ProxyCommand, when set, reaches the server through this command
+	// instead of Host and Port
+	ProxyCommand string
+
+	// ForwardAgent forwards the local SSH agent, e.g. for commit signing
+	ForwardAgent bool
+
+	// Tool is the tool whose setup wrote the entry. It is sent to the
+	// server, which exposes it to the sprite as SPRITE_BOOTSTRAP_TOOL.
+	Tool string
+
+	// Cwd is the directory on the sprite sessions start in, sent to the
+	// server as SPRITE_CWD
+	Cwd string
+
+	// KnownHostsFile pins the server's host key: when set, the key is
+	// checked against this file under PinnedHostAlias instead of accepted
+	// unverified
+	KnownHostsFile string
+
+	// Overrides are directives that counter conflicting options from
+	// elsewhere in the SSH config (see CheckConflicts). Overrides already in
+	// the file are kept when the entry is rewritten.
+	Overrides []string
+}
+
+// overridesComment introduces the overrides in a managed block
+const overridesComment = "    # Overrides for conflicting options elsewhere in this file"
+
+// PinnedHostAlias is the known_hosts name pinned entries check the server's
+// host key under, whatever host and port it is reached on
+const PinnedHostAlias = "sprite-bootstrap"
+
+// HostName returns the SSH config host alias for a sprite
+func HostName(spriteName string) string {
+	return fmt.Sprintf("sprite-%s", spriteName)
+}
+
+// StartMarker returns the line that opens a sprite's managed block
+func StartMarker(spriteName string) string {
+	return fmt.Sprintf(startMarker, spriteName)
+}
+
+// Path returns the path to the user's SSH config
+func Path() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(homeDir, ".ssh", "config"), nil
+}
+
+// block renders the managed block for the entry, leaving out directives
+// the ssh client version doesn't understand
+func (e Entry) block(v Version) string {
+	var extra string
+	if e.ForwardAgent {
+		extra = "    ForwardAgent yes\n"
+	}
+	if env := e.setEnv(); len(env) > 0 && v.supports("SetEnv") {
+		extra += "    SetEnv " + strings.Join(env, " ") + "\n"
+	}
+	hostKeys := "    StrictHostKeyChecking no\n    UserKnownHostsFile /dev/null\n"
+	if e.KnownHostsFile != "" {
+		hostKeys = fmt.Sprintf("    StrictHostKeyChecking yes\n    UserKnownHostsFile \"%s\"\n    HostKeyAlias %s\n",
+			e.KnownHostsFile, PinnedHostAlias)
+	}
+	if len(e.Overrides) > 0 {
+		extra += overridesComment + "\n"
+		for _, o := range e.Overrides {
+			extra += "    " + o + "\n"
+		}
+	}
+	addr := fmt.Sprintf("    HostName %s\n    Port %d\n", e.Host, e.Port)
+	if e.ProxyCommand != "" {
+		addr = "    ProxyCommand " + e.ProxyCommand + "\n"
+	}
+	return fmt.Sprintf(`%s
+Host %s
+%s    User %s
+%s%s%s
+`, StartMarker(e.Sprite), HostName(e.Sprite), addr, e.sshUser(), hostKeys, extra, fmt.Sprintf(endMarker, e.Sprite))
+}
+
+// sshUser returns the user the entry logs in as
+func (e Entry) sshUser() string {
+	return cmp.Or(e.User, e.Sprite)
+}
+
+// addr returns v, one of the entry's HostName and Port values, or "" when
+// a ProxyCommand reaches the server instead
+func (e Entry) addr(v string) string {
+	if e.ProxyCommand != "" {
+		return ""
+	}
+	return v
+}
+
+// withOverrides returns the entry with the overrides of an existing block
+// added to its own
+func (e Entry) withOverrides(block string) Entry {
+	in := false
+	for _, line := range strings.Split(block, "\n") {
+		switch {
+		case line == overridesComment:
+			in = true
+		case in && strings.HasPrefix(line, "    ") && !slices.Contains(e.Overrides, strings.TrimSpace(line)):
+			e.Overrides = append(slices.Clip(e.Overrides), strings.TrimSpace(line))
+		}
+	}
+	return e
+}
+
+// setEnv returns the variables the entry sends with SetEnv, quoted where
+// ssh would split them
+func (e Entry) setEnv() []string {
+	var env []string
+	if e.Tool != "" {
+		env = append(env, "SPRITE_BOOTSTRAP_TOOL="+e.Tool)
+	}
+	if e.Cwd != "" {
+		env = append(env, "SPRITE_CWD="+e.Cwd)
+	}
+	for i, v := range env {
+		if strings.ContainsAny(v, " \t\"") {
+			env[i] = `"` + strings.ReplaceAll(v, `"`, `\"`) + `"`
+		}
+	}
+	return env
+}
+
+// Unsupported describes the entry's features the ssh client version can't
+// express, which block leaves out
+func (e Entry) Unsupported(v Version) []string {
+	var missing []string
+	if len(e.setEnv()) > 0 && !v.supports("SetEnv") {
+		missing = append(missing, fmt.Sprintf("SPRITE_BOOTSTRAP_TOOL and SPRITE_CWD are not sent to the sprite (SetEnv needs OpenSSH %s, found %s)",
+			directiveSince["SetEnv"], v))
+	}
+	return missing
+}
+
+// Change describes what a transaction does to one sprite's entry
+type Change struct {
+	Sprite string
+	Action string // "add", "update", "remove" or "unchanged"
+}
+
+// Summary lists the changes a transaction makes, sorted by sprite
+type Summary []Change
+
+// Changed reports whether the summary modifies the file
+func (s Summary) Changed() bool {
+	for _, c := range s {
+		if c.Action != "unchanged" {
+			return true
+		}
+	}
+	return false
+}
+
+// String renders the summary as one "+ host" / "~ host" / "- host" line per
+// change
+func (s Summary) String() string {
+	var b strings.Builder
+	for _, c := range s {
+		sign := map[string]string{"add": "+", "update": "~", "remove": "-"}[c.Action]
+		if sign == "" {
+			continue
+		}
+		fmt.Fprintf(&b, "%s %s\n", sign, HostName(c.Sprite))
+	}
+	return b.String()
+}
+
+// Transaction accumulates SSH config changes. Later operations on the same
+// sprite replace earlier ones.
+type Transaction struct {
+	ops   map[string]*Entry // nil entry means remove
+	order []string
+
+	// version is the ssh client entries are rendered for
+	version Version
+}
+
+// Begin starts an empty transaction, rendering entries for the local ssh
+// client's version
+func Begin() *Transaction {
+	return &Transaction{ops: make(map[string]*Entry), version: ClientVersion()}
+}
+
+// Warnings describes features of the transaction's entries that the ssh
+// client can't express
+func (t *Transaction) Warnings() []string {
+	var warnings []string
+	for _, name := range t.order {
+		if e := t.ops[name]; e != nil {
+			warnings = append(warnings, e.Unsupported(t.version)...)
+		}
+	}
+	return warnings
+}
+
+// Add adds or replaces the entry for e.Sprite
+func (t *Transaction) Add(e Entry) {
+	t.set(e.Sprite, &e)
+}
+
+// Remove removes the entry for a sprite
+func (t *Transaction) Remove(spriteName string) {
+	t.set(spriteName, nil)
+}
+
+func (t *Transaction) set(spriteName string, e *Entry) {
+	if _, ok := t.ops[spriteName]; !ok {
+		t.order = append(t.order, spriteName)
+	}
+	t.ops[spriteName] = e
+}
+
+// Empty reports whether the transaction has no operations
+func (t *Transaction) Empty() bool {
+	return len(t.ops) == 0
+}
+
+// apply returns config with the transaction's operations applied, and what
+// changed for each sprite
+func (t *Transaction) apply(config string) (string, Summary) {
+	var summary Summary
+	for _, name := range t.order {
+		e := t.ops[name]
+		existing, found := extractBlock(config, name)
+		if e != nil && found {
+			merged := e.withOverrides(existing)
+			e = &merged
+		}
+
+		change := Change{Sprite: name}
+		switch {
+		case e == nil && !found:
+			change.Action = "unchanged"
+		case e == nil:
+			change.Action = "remove"
+			config = removeBlock(config, name)
+		case found && existing == e.block(t.version):
+			change.Action = "unchanged"
+		default:
+			change.Action = "add"
+			if found {
+				change.Action = "update"
+				config = removeBlock(config, name)
+			}
+			if len(config) > 0 && !strings.HasSuffix(config, "\n") {
+				config += "\n"
+			}
+			config += e.block(t.version)
+		}
+		summary = append(summary, change)
+	}
+
+	sort.Slice(summary, func(i, j int) bool { return summary[i].Sprite < summary[j].Sprite })
+	return config, summary
+}
+
+// Diff reports what Commit would change without writing anything
+func (t *Transaction) Diff() (Summary, error) {
+	path, err := Path()
+	if err != nil {
+		return nil, err
+	}
+	existing, _, err := readConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	_, summary := t.apply(existing)
+	return summary, nil
+}
+
+// Commit applies every operation with one locked, atomic rewrite of the SSH
+// config. The file is left untouched when nothing changes. The transaction
+// is empty afterwards.
+func (t *Transaction) Commit() (Summary, error) {
+	if t.Empty() {
+		return nil, nil
+	}
+
+	path, err := Path()
+	if err != nil {
+		return nil, err
+	}
+
+	// Ensure .ssh directory exists and OpenSSH will accept it
+	if err := sshdir.Ensure(); err != nil {
+		return nil, err
+	}
+
+	var summary Summary
+	err = withLock(path, func() error {
+		existing, style, err := readConfig(path)
+		if err != nil {
+			return err
+		}
+
+		var updated string
+		updated, summary = t.apply(existing)
+		if !summary.Changed() {
+			return nil
+		}
+		return writeAtomic(path, style.Encode([]byte(updated)))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	t.ops = make(map[string]*Entry)
+	t.order = nil
+	return summary, nil
+}
+
+// readConfig returns the SSH config contents with LF line endings and no
+// BOM, or "" if it doesn't exist, along with the style to write it back in
+func readConfig(path string) (string, textfile.Style, error) {
+	data, style, err := textfile.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "", style, nil
+	}
+	return string(data), style, err
+}
+
+// writeAtomic replaces the file through a temporary file and rename. A
+// symlinked config (e.g. managed by a dotfiles repo) is written through to
+// its target rather than replaced.
+func writeAtomic(path string, data []byte) error {
+	if target, err := filepath.EvalSymlinks(path); err == nil {
+		path = target
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".config.sprite-bootstrap-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// extractBlock returns a sprite's managed block, including its markers
+func extractBlock(config, spriteName string) (string, bool) {
+	start := StartMarker(spriteName)
+	end := fmt.Sprintf(endMarker, spriteName)
+
+	var block []string
+	inBlock, found := false, false
+	for _, line := range strings.Split(config, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == start {
+			inBlock, found = true, true
+		}
+		if inBlock {
+			block = append(block, line)
+		}
+		if trimmed == end {
+			inBlock = false
+		}
+	}
+	if !found {
+		return "", false
+	}
+	return strings.Join(block, "\n") + "\n", true
+}
+
+// removeBlock removes a sprite's managed block from the config string
+func removeBlock(config, spriteName string) string {
+	start := StartMarker(spriteName)
+	end := fmt.Sprintf(endMarker, spriteName)
+
+	lines := strings.Split(config, "\n")
+	var result []string
+	inBlock := false
+
+	for _, line := range lines {
+		if strings.TrimSpace(line) == start {
+			inBlock = true
+			continue
+		}
+		if strings.TrimSpace(line) == end {
+			inBlock = false
+			continue
+		}
+		if !inBlock {
+			result = append(result, line)
+		}
+	}
+
+	// Clean up extra blank lines at the end
+	for len(result) > 0 && strings.TrimSpace(result[len(result)-1]) == "" {
+		result = result[:len(result)-1]
+	}
+
+	if len(result) > 0 {
+		return strings.Join(result, "\n") + "\n"
+	}
+	return ""
+}
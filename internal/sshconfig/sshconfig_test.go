@@ -0,0 +1,469 @@
+package sshconfig
+
+import (
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// newTransaction begins a transaction rendering for a current OpenSSH
+// client, whatever ssh is installed
+func newTransaction() *Transaction {
+	return &Transaction{ops: make(map[string]*Entry), version: Version{9, 6}}
+}
+
+func TestSummaryString(t *testing.T) {
+	tests := []struct {
+		name        string
+		summary     Summary
+		want        string
+		wantChanged bool
+	}{
+		{"empty", nil, "", false},
+		{"unchanged only", Summary{{"a", "unchanged"}}, "", false},
+		{"every action", Summary{{"a", "add"}, {"b", "update"}, {"c", "remove"}, {"d", "unchanged"}},
+			"+ sprite-a\n~ sprite-b\n- sprite-c\n", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.summary.String(); got != tt.want {
+				t.Errorf("String() = %q, want %q", got, tt.want)
+			}
+			if got := tt.summary.Changed(); got != tt.wantChanged {
+				t.Errorf("Changed() = %v, want %v", got, tt.wantChanged)
+			}
+		})
+	}
+}
+
+// TestTransactionSummary runs transactions one after another against the
+// same config, checking what Diff predicts, what Commit reports and writes
+func TestTransactionSummary(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	entry := func(sprite string, port int) Entry {
+		return Entry{Sprite: sprite, Host: "127.0.0.1", Port: port}
+	}
+
+	steps := []struct {
+		name     string
+		ops      func(tx *Transaction)
+		want     string   // Summary.String()
+		wantHost []string // In file order
+	}{
+		{"add two", func(tx *Transaction) { tx.Add(entry("b", 2222)); tx.Add(entry("a", 2222)) },
+			"+ sprite-a\n+ sprite-b\n", []string{"sprite-b", "sprite-a"}},
+		{"same again", func(tx *Transaction) { tx.Add(entry("a", 2222)) },
+			"", []string{"sprite-b", "sprite-a"}},
+		{"update one", func(tx *Transaction) { tx.Add(entry("b", 2223)); tx.Add(entry("a", 2222)) },
+			"~ sprite-b\n", []string{"sprite-a", "sprite-b"}},
+		{"later operation wins", func(tx *Transaction) { tx.Add(entry("c", 2222)); tx.Remove("c") },
+			"", []string{"sprite-a", "sprite-b"}},
+		{"remove one", func(tx *Transaction) { tx.Remove("b"); tx.Remove("missing") },
+			"- sprite-b\n", []string{"sprite-a"}},
+	}
+	path := filepath.Join(os.Getenv("HOME"), ".ssh", "config")
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			before, _ := os.ReadFile(path)
+
+			tx := newTransaction()
+			step.ops(tx)
+			diff, err := tx.Diff()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := diff.String(); got != step.want {
+				t.Errorf("Diff() = %q, want %q", got, step.want)
+			}
+			if after, _ := os.ReadFile(path); string(after) != string(before) {
+				t.Error("Diff changed the config")
+			}
+
+			summary, err := tx.Commit()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := summary.String(); got != step.want {
+				t.Errorf("Commit() = %q, want %q", got, step.want)
+			}
+			if !tx.Empty() {
+				t.Error("transaction not empty after Commit")
+			}
+
+			config, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if step.want == "" && string(config) != string(before) {
+				t.Error("config rewritten without changes")
+			}
+			var hosts []string
+			for _, line := range strings.Split(string(config), "\n") {
+				if host, ok := strings.CutPrefix(line, "Host "); ok {
+					hosts = append(hosts, host)
+				}
+			}
+			if strings.Join(hosts, " ") != strings.Join(step.wantHost, " ") {
+				t.Errorf("config has hosts %v, want %v", hosts, step.wantHost)
+			}
+		})
+	}
+}
+
+// TestWindowsConfigRoundTrip adds and removes a sprite in a config saved
+// by a Windows editor, with a BOM and CRLF line endings, and checks it
+// keeps both and ends up as it started
+func TestWindowsConfigRoundTrip(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	path := filepath.Join(os.Getenv("HOME"), ".ssh", "config")
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	original := "\ufeffHost work\r\n  HostName work.example.com\r\n  User me\r\n"
+	if err := os.WriteFile(path, []byte(original), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tx := newTransaction()
+	tx.Add(Entry{Sprite: "a", Host: "127.0.0.1", Port: 2222})
+	if _, err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	added, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := string(added)
+	if !strings.HasPrefix(text, original) {
+		t.Errorf("config after adding doesn't start with the original:\n%q", text)
+	}
+	if !strings.Contains(text, "Host sprite-a\r\n") {
+		t.Errorf("added block isn't in CRLF:\n%q", text)
+	}
+	if n := strings.Count(text, "\ufeff"); n != 1 {
+		t.Errorf("config has %d BOMs, want 1", n)
+	}
+	if lf, crlf := strings.Count(text, "\n"), strings.Count(text, "\r\n"); lf != crlf {
+		t.Errorf("config has %d bare LF line endings:\n%q", lf-crlf, text)
+	}
+
+	tx = newTransaction()
+	tx.Remove("a")
+	if _, err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	removed, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(removed) != original {
+		t.Errorf("config after removing = %q, want the original %q", removed, original)
+	}
+}
+
+func TestParseVersion(t *testing.T) {
+	tests := []struct {
+		output string
+		want   Version
+		wantOK bool
+	}{
+		{"OpenSSH_7.4p1, OpenSSL 1.0.2k-fips  26 Jan 2017", Version{7, 4}, true},
+		{"OpenSSH_8.2p1 Ubuntu-4ubuntu0.11, OpenSSL 1.1.1f  31 Mar 2020", Version{8, 2}, true},
+		{"OpenSSH_9.6p1, LibreSSL 3.3.6", Version{9, 6}, true},
+		{"OpenSSH_for_Windows_8.1p1, LibreSSL 3.0.2", Version{8, 1}, true},
+		{"OpenSSH_10.0p2 Debian-5, OpenSSL 3.5.1 1 Jul 2025", Version{10, 0}, true},
+		{"Sun_SSH_1.1.5, SSH protocols 1.5/2.0, OpenSSL 0x0090704f", Version{}, false},
+		{"ssh: command not found", Version{}, false},
+		{"", Version{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := ParseVersion(tt.output)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("ParseVersion(%q) = %v, %v; want %v, %v", tt.output, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestVersionAtLeast(t *testing.T) {
+	tests := []struct {
+		v            Version
+		major, minor int
+		want         bool
+	}{
+		{Version{7, 4}, 7, 8, false},
+		{Version{7, 8}, 7, 8, true},
+		{Version{7, 9}, 7, 8, true},
+		{Version{8, 0}, 7, 8, true},
+		{Version{6, 9}, 7, 0, false},
+		{Version{}, 99, 0, true}, // Unknown is taken as current
+	}
+	for _, tt := range tests {
+		if got := tt.v.AtLeast(tt.major, tt.minor); got != tt.want {
+			t.Errorf("%v.AtLeast(%d, %d) = %v, want %v", tt.v, tt.major, tt.minor, got, tt.want)
+		}
+	}
+}
+
+// TestBlockVersions renders entries for clients from before SetEnv, the
+// release that added it, a current one and an undetected one
+func TestBlockVersions(t *testing.T) {
+	withEnv := Entry{Sprite: "a", Host: "127.0.0.1", Port: 2222, Tool: "zed", Cwd: "/home/sprite/my app"}
+	plain := Entry{Sprite: "a", Host: "127.0.0.1", Port: 2222}
+	const setEnv = `    SetEnv SPRITE_BOOTSTRAP_TOOL=zed "SPRITE_CWD=/home/sprite/my app"` + "\n"
+
+	tests := []struct {
+		name        string
+		version     Version
+		entry       Entry
+		wantSetEnv  bool
+		wantWarning bool
+	}{
+		{"7.4 with env", Version{7, 4}, withEnv, false, true},
+		{"7.4 without env", Version{7, 4}, plain, false, false},
+		{"7.8 with env", Version{7, 8}, withEnv, true, false},
+		{"9.6 with env", Version{9, 6}, withEnv, true, false},
+		{"unknown with env", Version{}, withEnv, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			block := tt.entry.block(tt.version)
+			if got := strings.Contains(block, setEnv); got != tt.wantSetEnv {
+				t.Errorf("block has SetEnv = %v, want %v:\n%s", got, tt.wantSetEnv, block)
+			}
+			if !tt.wantSetEnv && strings.Contains(block, "SetEnv") {
+				t.Errorf("block has a partial SetEnv line:\n%s", block)
+			}
+			// Everything else is rendered alike for every version
+			if rest := strings.Replace(block, setEnv, "", 1); rest != plain.block(Version{9, 6}) {
+				t.Errorf("block differs from the current one beyond SetEnv:\n%s", block)
+			}
+
+			tx := &Transaction{ops: make(map[string]*Entry), version: tt.version}
+			tx.Add(tt.entry)
+			warnings := tx.Warnings()
+			if got := len(warnings) > 0; got != tt.wantWarning {
+				t.Fatalf("Warnings() = %q, want a warning %v", warnings, tt.wantWarning)
+			}
+			if tt.wantWarning && !strings.Contains(warnings[0], "needs OpenSSH 7.8, found 7.4") {
+				t.Errorf("warning %q doesn't name the versions", warnings[0])
+			}
+		})
+	}
+}
+
+// sshG renders ssh -G output for a plain sprite entry, with options
+// replaced or added
+func sshG(options map[string]string) string {
+	o := map[string]string{
+		"host":           "sprite-a",
+		"hostname":       "127.0.0.1",
+		"port":           "2222",
+		"user":           "a",
+		"controlmaster":  "false",
+		"controlpath":    "none",
+		"identitiesonly": "no",
+	}
+	for k, v := range options {
+		o[k] = v
+	}
+	var b strings.Builder
+	for _, k := range slices.Sorted(maps.Keys(o)) {
+		b.WriteString(k + " " + o[k] + "\n")
+	}
+	for _, f := range []string{"~/.ssh/id_rsa", "~/.ssh/id_ecdsa", "~/.ssh/id_ed25519"} {
+		b.WriteString("identityfile " + f + "\n")
+	}
+	return b.String()
+}
+
+// TestFindConflicts runs the conflict checks against ssh -G output, in a
+// home directory holding only the files a case creates
+func TestFindConflicts(t *testing.T) {
+	plain := Entry{Sprite: "a", Host: "127.0.0.1", Port: 2222}
+	proxied := Entry{Sprite: "a", ProxyCommand: "sprite-bootstrap proxy a"}
+
+	tests := []struct {
+		name      string
+		entry     Entry
+		options   map[string]string
+		files     []string // Created under the home directory; directories end in /
+		want      []string // Options reported, in check order
+		wantFix   []string // Every conflict's fixes, in order
+		wantNoFix []string // Options reported without a fix
+	}{
+		{name: "clean", entry: plain},
+		{name: "global ProxyCommand", entry: plain, options: map[string]string{"proxycommand": "nc %h %p"},
+			want: []string{"proxycommand"}, wantFix: []string{"ProxyCommand none"}},
+		{name: "ProxyCommand none", entry: plain, options: map[string]string{"proxycommand": "none"}},
+		{name: "entry's ProxyCommand in effect", entry: proxied, options: map[string]string{"proxycommand": "sprite-bootstrap proxy a"}},
+		{name: "entry's ProxyCommand overridden", entry: proxied, options: map[string]string{"proxycommand": "nc %h %p"},
+			want: []string{"proxycommand"}, wantNoFix: []string{"proxycommand"}},
+		{name: "global ProxyJump", entry: plain, options: map[string]string{"proxyjump": "bastion"},
+			want: []string{"proxyjump"}, wantFix: []string{"ProxyJump none"}},
+		{name: "ControlPath in missing directory", entry: plain,
+			options: map[string]string{"controlmaster": "auto", "controlpath": "~/.ssh/sockets/%C"},
+			want:    []string{"controlpath"}, wantFix: []string{"ControlPath ~/.ssh/sprite-bootstrap-%C"}},
+		{name: "ControlPath in existing directory", entry: plain, files: []string{".ssh/sockets/"},
+			options: map[string]string{"controlmaster": "auto", "controlpath": "~/.ssh/sockets/%C"}},
+		{name: "ControlPath with ControlMaster off", entry: plain,
+			options: map[string]string{"controlmaster": "false", "controlpath": "~/.ssh/sockets/%C"}},
+		{name: "ControlPath directory depends on the host", entry: plain,
+			options: map[string]string{"controlmaster": "auto", "controlpath": "~/.ssh/%h/%C"}},
+		{name: "IdentitiesOnly without keys", entry: plain, options: map[string]string{"identitiesonly": "yes"},
+			want: []string{"identitiesonly"}, wantFix: []string{"IdentitiesOnly no"}},
+		{name: "IdentitiesOnly with a listed key", entry: plain, files: []string{".ssh/id_ecdsa"},
+			options: map[string]string{"identitiesonly": "yes"}},
+		{name: "IdentitiesOnly with an unlisted key", entry: plain, files: []string{".ssh/id_work"},
+			options: map[string]string{"identitiesonly": "yes", "identityfile": "~/.ssh/id_missing"},
+			want:    []string{"identitiesonly"}, wantFix: []string{"IdentitiesOnly no"}},
+		{name: "IdentitiesOnly with a default key unlisted", entry: plain, files: []string{".ssh/id_rsa"},
+			options: map[string]string{"identitiesonly": "yes"}},
+		{name: "HostName overridden", entry: plain, options: map[string]string{"hostname": "10.0.0.1"},
+			want: []string{"hostname"}, wantNoFix: []string{"hostname"}},
+		{name: "HostName ignored behind ProxyCommand", entry: proxied,
+			options: map[string]string{"hostname": "sprite-a", "proxycommand": "sprite-bootstrap proxy a"}},
+		{name: "Port overridden", entry: plain, options: map[string]string{"port": "22"},
+			want: []string{"port"}, wantNoFix: []string{"port"}},
+		{name: "User overridden", entry: plain, options: map[string]string{"user": "root"},
+			want: []string{"user"}, wantNoFix: []string{"user"}},
+		{name: "User differs in case", entry: plain, options: map[string]string{"user": "A"}},
+		{name: "several", entry: plain,
+			options: map[string]string{"proxycommand": "nc %h %p", "proxyjump": "bastion", "user": "root"},
+			want:    []string{"proxycommand", "proxyjump", "user"}, wantFix: []string{"ProxyCommand none", "ProxyJump none"},
+			wantNoFix: []string{"user"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			home := t.TempDir()
+			t.Setenv("HOME", home)
+			for _, f := range tt.files {
+				path := filepath.Join(home, f)
+				if strings.HasSuffix(f, "/") {
+					if err := os.MkdirAll(path, 0o700); err != nil {
+						t.Fatal(err)
+					}
+					continue
+				}
+				if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, nil, 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			out := sshG(tt.options)
+			if v, ok := tt.options["identityfile"]; ok {
+				// Replaces the defaults rather than adding to them
+				out = strings.Join(slices.DeleteFunc(strings.Split(out, "\n"), func(l string) bool {
+					return strings.HasPrefix(l, "identityfile ") && l != "identityfile "+v
+				}), "\n")
+			}
+
+			var got, fixes, noFix []string
+			for _, c := range findConflicts(parseEffective(out), tt.entry) {
+				got = append(got, c.Option)
+				fixes = append(fixes, c.Fix...)
+				if len(c.Fix) == 0 {
+					noFix = append(noFix, c.Option)
+				}
+				if c.Problem == "" {
+					t.Errorf("%s conflict has no explanation", c.Option)
+				}
+				if c.Value == "" {
+					t.Errorf("%s conflict has no value", c.Option)
+				}
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("conflicts = %q, want %q", got, tt.want)
+			}
+			if !slices.Equal(fixes, tt.wantFix) {
+				t.Errorf("fixes = %q, want %q", fixes, tt.wantFix)
+			}
+			if !slices.Equal(noFix, tt.wantNoFix) {
+				t.Errorf("conflicts without a fix = %q, want %q", noFix, tt.wantNoFix)
+			}
+		})
+	}
+}
+
+// TestFixedConflicts has the local ssh client read a config where a global
+// block follows the entry, and checks that the fixes added as overrides
+// clear the conflicts they are offered for
+func TestFixedConflicts(t *testing.T) {
+	if _, err := exec.LookPath("ssh"); err != nil {
+		t.Skip("ssh not installed")
+	}
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	// ssh ignores ProxyJump when ProxyCommand is set, so only one is tried
+	const global = "Host *\n    ProxyCommand nc %h %p\n    ControlMaster auto\n    ControlPath /nonexistent/%C\n"
+
+	conflicts := func(e Entry) []Conflict {
+		t.Helper()
+		config := filepath.Join(home, "config")
+		if err := os.WriteFile(config, []byte(e.block(Version{9, 6})+global), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		out, err := exec.Command("ssh", "-G", "-F", config, HostName(e.Sprite)).Output()
+		if err != nil {
+			t.Fatalf("ssh -G: %v", err)
+		}
+		return findConflicts(parseEffective(string(out)), e)
+	}
+
+	e := Entry{Sprite: "a", Host: "127.0.0.1", Port: 2222}
+	found := conflicts(e)
+	var options []string
+	for _, c := range found {
+		options = append(options, c.Option)
+		e.Overrides = append(e.Overrides, c.Fix...)
+	}
+	if want := []string{"proxycommand", "controlpath"}; !slices.Equal(options, want) {
+		t.Fatalf("conflicts = %q, want %q", options, want)
+	}
+	if left := conflicts(e); len(left) != 0 {
+		t.Errorf("conflicts left after adding %q: %+v", e.Overrides, left)
+	}
+}
+
+// TestOverridesKept rewrites an entry that has overrides and checks they
+// stay in its block
+func TestOverridesKept(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	e := Entry{Sprite: "a", Host: "127.0.0.1", Port: 2222}
+
+	fixed := e
+	fixed.Overrides = []string{"ProxyCommand none"}
+	tx := newTransaction()
+	tx.Add(fixed)
+	if _, err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Setup runs again, with the entry as it builds it
+	e.Port = 2223
+	tx = newTransaction()
+	tx.Add(e)
+	summary, err := tx.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := summary.String(); got != "~ sprite-a\n" {
+		t.Errorf("Commit() = %q, want an update", got)
+	}
+	path, _ := Path()
+	config, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(config), overridesComment+"\n    ProxyCommand none\n") {
+		t.Errorf("override lost:\n%s", config)
+	}
+	if !strings.Contains(string(config), "Port 2223") {
+		t.Errorf("entry not updated:\n%s", config)
+	}
+}
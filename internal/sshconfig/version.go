@@ -0,0 +1,82 @@
+package sshconfig
+
+import (
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"sync"
+)
+
+// Version is an OpenSSH client version. The zero Version means unknown and
+// is treated as current.
+type Version struct {
+	Major, Minor int
+}
+
+func (v Version) String() string {
+	if v.Unknown() {
+		return "unknown"
+	}
+	return fmt.Sprintf("%d.%d", v.Major, v.Minor)
+}
+
+// Unknown reports whether the version couldn't be detected
+func (v Version) Unknown() bool {
+	return v == Version{}
+}
+
+// AtLeast reports whether v is major.minor or newer. An unknown version is
+// assumed to be newer than anything.
+func (v Version) AtLeast(major, minor int) bool {
+	if v.Unknown() {
+		return true
+	}
+	return v.Major > major || v.Major == major && v.Minor >= minor
+}
+
+// directiveSince is the OpenSSH version that introduced each directive we
+// emit that older clients reject. A config line ssh doesn't understand makes
+// it refuse to run for every host, so these are left out for older clients.
+var directiveSince = map[string]Version{
+	"SetEnv": {7, 8},
+}
+
+// supports reports whether the client understands a directive
+func (v Version) supports(directive string) bool {
+	since, ok := directiveSince[directive]
+	return !ok || v.AtLeast(since.Major, since.Minor)
+}
+
+var versionRE = regexp.MustCompile(`OpenSSH[A-Za-z_]*_(\d+)\.(\d+)`)
+
+// ParseVersion parses the output of ssh -V, e.g. "OpenSSH_7.4p1, OpenSSL
+// 1.0.2k-fips  26 Jan 2017" or "OpenSSH_for_Windows_8.1p1, LibreSSL 3.0.2"
+func ParseVersion(output string) (Version, bool) {
+	m := versionRE.FindStringSubmatch(output)
+	if m == nil {
+		return Version{}, false
+	}
+	major, _ := strconv.Atoi(m[1])
+	minor, _ := strconv.Atoi(m[2])
+	return Version{Major: major, Minor: minor}, true
+}
+
+var (
+	clientVersionOnce sync.Once
+	clientVersion     Version
+)
+
+// ClientVersion returns the version of the local ssh client, detected once
+// per process. It is unknown when ssh is missing or isn't OpenSSH.
+func ClientVersion() Version {
+	clientVersionOnce.Do(func() {
+		// ssh -V prints to stderr
+		out, err := exec.Command("ssh", "-V").CombinedOutput()
+		if err != nil {
+			return
+		}
+		clientVersion, _ = ParseVersion(string(out))
+	})
+	return clientVersion
+}
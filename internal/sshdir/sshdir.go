@@ -0,0 +1,184 @@
+// Package sshdir checks that ~/.ssh is usable before anything is written to it.
+//
+// OpenSSH silently ignores (or refuses) config and key files when the
+// directory is writable by other users, so problems are detected upfront and
+// reported with the exact path and fix. Writable parents only matter to
+// sshd's StrictModes, so they are warnings.
+package sshdir
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Problem is a single issue found with the SSH directory
+type Problem struct {
+	Path  string // File or directory with the issue
+	Issue string // What's wrong
+	Fix   string // How to fix it, if known
+}
+
+func (p Problem) String() string {
+	s := fmt.Sprintf("%s: %s", p.Path, p.Issue)
+	if p.Fix != "" {
+		s += fmt.Sprintf(" (fix: %s)", p.Fix)
+	}
+	return s
+}
+
+// Report is the result of checking the SSH directory
+type Report struct {
+	Dir      string    // The ~/.ssh directory (symlinks resolved)
+	Created  []string  // Directories created by the check
+	Fixed    []string  // Changes made to fix problems
+	Problems []Problem // Remaining problems
+	Warnings []Problem // Issues that don't stop the ssh client, such as writable parents
+}
+
+// OK reports whether no problems remain
+func (r *Report) OK() bool {
+	return len(r.Problems) == 0
+}
+
+// Err returns an error describing all remaining problems, or nil
+func (r *Report) Err() error {
+	if r.OK() {
+		return nil
+	}
+	lines := make([]string, 0, len(r.Problems))
+	for _, p := range r.Problems {
+		lines = append(lines, "  - "+p.String())
+	}
+	return fmt.Errorf("SSH directory %s is not usable:\n%s", r.Dir, strings.Join(lines, "\n"))
+}
+
+// Dir returns the path to the user's ~/.ssh directory
+func Dir() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(homeDir, ".ssh"), nil
+}
+
+// Check verifies that ~/.ssh exists, is owned by the current user, and isn't
+// writable by others, along with the files OpenSSH reads. Parents that
+// aren't are reported as warnings. When create is true a missing directory
+// is created with mode 0700.
+func Check(create bool) (*Report, error) {
+	dir, err := Dir()
+	if err != nil {
+		return nil, fmt.Errorf("unable to find home directory: %w", err)
+	}
+	homeDir := filepath.Dir(dir)
+	report := &Report{Dir: dir}
+
+	// Follow symlinks so managed locations are checked where they really live
+	if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+		report.Dir = resolved
+	}
+
+	info, err := os.Stat(report.Dir)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		if !create {
+			report.Problems = append(report.Problems, Problem{
+				Path:  dir,
+				Issue: "does not exist",
+				Fix:   "mkdir -m 700 " + dir,
+			})
+			return report, nil
+		}
+		if err := createDir(report, dir); err != nil {
+			return report, nil
+		}
+		if info, err = os.Stat(report.Dir); err != nil {
+			report.Problems = append(report.Problems, Problem{Path: dir, Issue: err.Error()})
+			return report, nil
+		}
+	case err != nil:
+		report.Problems = append(report.Problems, Problem{Path: dir, Issue: err.Error()})
+		return report, nil
+	}
+
+	if !info.IsDir() {
+		report.Problems = append(report.Problems, Problem{
+			Path:  report.Dir,
+			Issue: "is not a directory",
+		})
+		return report, nil
+	}
+
+	report.Problems = append(report.Problems, checkPath(report.Dir, true)...)
+
+	// sshd's StrictModes also rejects writable parent directories, but the
+	// ssh client reading our config and keys doesn't
+	for parent := filepath.Dir(report.Dir); ; parent = filepath.Dir(parent) {
+		report.Warnings = append(report.Warnings, checkParent(parent)...)
+		if parent == homeDir || parent == filepath.Dir(parent) {
+			break
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(report.Dir, "config")); err == nil {
+		report.Problems = append(report.Problems, checkPath(filepath.Join(report.Dir, "config"), false)...)
+	}
+
+	if p := checkWritable(report.Dir); p != nil {
+		report.Problems = append(report.Problems, *p)
+	}
+
+	return report, nil
+}
+
+// Ensure creates ~/.ssh if needed and returns an error describing anything
+// that would stop OpenSSH from using it. Warnings are left to doctor.
+func Ensure() error {
+	report, err := Check(true)
+	if err != nil {
+		return err
+	}
+	return report.Err()
+}
+
+// createDir creates the SSH directory, recording it in the report. Only
+// directories created here are chmod'ed, never existing ones.
+func createDir(report *Report, dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		report.Problems = append(report.Problems, Problem{
+			Path:  dir,
+			Issue: fmt.Sprintf("could not be created: %v", err),
+		})
+		return err
+	}
+	report.Created = append(report.Created, dir)
+
+	// MkdirAll is subject to umask - make the mode explicit
+	if err := restrictDir(dir); err != nil {
+		report.Problems = append(report.Problems, Problem{
+			Path:  dir,
+			Issue: fmt.Sprintf("could not restrict permissions: %v", err),
+		})
+		return err
+	}
+	report.Fixed = append(report.Fixed, "restricted permissions on "+dir)
+	return nil
+}
+
+// checkWritable verifies files can actually be created in dir
+func checkWritable(dir string) *Problem {
+	f, err := os.CreateTemp(dir, ".sprite-bootstrap-check-*")
+	if err != nil {
+		return &Problem{
+			Path:  dir,
+			Issue: fmt.Sprintf("is not writable: %v", err),
+		}
+	}
+	name := f.Name()
+	f.Close()
+	os.Remove(name)
+	return nil
+}
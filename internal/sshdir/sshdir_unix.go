@@ -0,0 +1,88 @@
+//go:build !windows
+
+package sshdir
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// checkPath verifies a path inside ~/.ssh is owned by the current user and
+// not writable by group or others. OpenSSH doesn't mind them reading it, so
+// a 0755 ~/.ssh is fine.
+func checkPath(path string, isDir bool) []Problem {
+	info, err := os.Stat(path)
+	if err != nil {
+		return []Problem{{Path: path, Issue: err.Error()}}
+	}
+
+	var problems []Problem
+	if p := checkOwner(path, info, false); p != nil {
+		problems = append(problems, *p)
+	}
+
+	mode := info.Mode().Perm()
+	if isDir && mode&0022 != 0 {
+		problems = append(problems, Problem{
+			Path:  path,
+			Issue: fmt.Sprintf("has mode %04o, OpenSSH rejects a group or world-writable SSH directory", mode),
+			Fix:   "chmod go-w " + path,
+		})
+	}
+	if !isDir && mode&0022 != 0 {
+		problems = append(problems, Problem{
+			Path:  path,
+			Issue: fmt.Sprintf("has mode %04o, OpenSSH ignores group or world-writable config", mode),
+			Fix:   "chmod 600 " + path,
+		})
+	}
+	return problems
+}
+
+// checkParent verifies a parent of ~/.ssh is owned by the user or root and
+// isn't group or world-writable
+func checkParent(path string) []Problem {
+	info, err := os.Stat(path)
+	if err != nil {
+		return []Problem{{Path: path, Issue: err.Error()}}
+	}
+
+	var problems []Problem
+	if p := checkOwner(path, info, true); p != nil {
+		problems = append(problems, *p)
+	}
+
+	// Sticky directories like /tmp are fine
+	mode := info.Mode()
+	if mode.Perm()&0022 != 0 && mode&os.ModeSticky == 0 {
+		problems = append(problems, Problem{
+			Path:  path,
+			Issue: fmt.Sprintf("has mode %04o, sshd's StrictModes rejects group or world-writable parents", mode.Perm()),
+			Fix:   "chmod go-w " + path,
+		})
+	}
+	return problems
+}
+
+// checkOwner verifies the current user (or root, if allowed) owns path
+func checkOwner(path string, info os.FileInfo, allowRoot bool) *Problem {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	uid := uint32(os.Getuid())
+	if stat.Uid == uid || (allowRoot && stat.Uid == 0) {
+		return nil
+	}
+	return &Problem{
+		Path:  path,
+		Issue: fmt.Sprintf("is owned by uid %d, expected %d", stat.Uid, uid),
+		Fix:   fmt.Sprintf("chown %d %s", uid, path),
+	}
+}
+
+// restrictDir limits a directory to the current user
+func restrictDir(path string) error {
+	return os.Chmod(path, 0700)
+}
@@ -0,0 +1,73 @@
+//go:build !windows
+
+package sshdir
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckModes(t *testing.T) {
+	tests := []struct {
+		name     string
+		home     os.FileMode
+		dir      os.FileMode
+		problems int
+		warnings int
+	}{
+		{"private", 0700, 0700, 0, 0},
+		{"readable", 0755, 0755, 0, 0},
+		{"group writable", 0755, 0775, 1, 0},
+		{"world writable", 0755, 0777, 1, 0},
+		{"writable parent", 0775, 0700, 0, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			home := t.TempDir()
+			t.Setenv("HOME", home)
+			dir := filepath.Join(home, ".ssh")
+			if err := os.Mkdir(dir, 0700); err != nil {
+				t.Fatal(err)
+			}
+			// Chmod explicitly, as Mkdir is subject to umask
+			if err := os.Chmod(dir, tt.dir); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chmod(home, tt.home); err != nil {
+				t.Fatal(err)
+			}
+
+			report, err := Check(false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(report.Problems) != tt.problems || len(report.Warnings) != tt.warnings {
+				t.Errorf("problems = %v, warnings = %v; want %d and %d", report.Problems, report.Warnings, tt.problems, tt.warnings)
+			}
+			if err := Ensure(); (err != nil) != (tt.problems > 0) {
+				t.Errorf("Ensure() = %v, want error %v", err, tt.problems > 0)
+			}
+		})
+	}
+}
+
+func TestCheckCreates(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	report, err := Check(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.OK() || len(report.Created) != 1 {
+		t.Fatalf("report = %+v, want the directory created without problems", report)
+	}
+	info, err := os.Stat(filepath.Join(home, ".ssh"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != 0700 {
+		t.Errorf("created mode = %04o, want 0700", mode)
+	}
+}
@@ -0,0 +1,87 @@
+//go:build windows
+
+package sshdir
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// broadPrincipals are groups that OpenSSH for Windows refuses to let write
+// to the user's SSH files
+var broadPrincipals = []string{
+	"Everyone",
+	"BUILTIN\\Users",
+	"NT AUTHORITY\\Authenticated Users",
+}
+
+// writeRights are the icacls permission codes that allow modification
+var writeRights = []string{"(F)", "(M)", "(W)", "(WD)", "(AD)"}
+
+// checkPath checks the ACL of a path inside ~/.ssh. POSIX modes don't apply
+// on Windows, so the equivalent check is that no broad group can write to it.
+func checkPath(path string, isDir bool) []Problem {
+	return checkACL(path)
+}
+
+// checkParent applies the same ACL check to parents of ~/.ssh
+func checkParent(path string) []Problem {
+	return checkACL(path)
+}
+
+// checkACL reports broad groups granted write access by the path's ACL
+func checkACL(path string) []Problem {
+	if _, err := os.Stat(path); err != nil {
+		return []Problem{{Path: path, Issue: err.Error()}}
+	}
+
+	out, err := exec.Command("icacls", path).Output()
+	if err != nil {
+		// icacls unavailable - nothing more we can check
+		return nil
+	}
+
+	var problems []Problem
+	for _, line := range strings.Split(string(out), "\n") {
+		line = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), path))
+		for _, principal := range broadPrincipals {
+			if !strings.HasPrefix(strings.ToLower(line), strings.ToLower(principal)+":") {
+				continue
+			}
+			if !grantsWrite(line) {
+				continue
+			}
+			problems = append(problems, Problem{
+				Path:  path,
+				Issue: fmt.Sprintf("ACL grants write access to %s", principal),
+				Fix:   fmt.Sprintf("icacls \"%s\" /remove \"%s\"", path, principal),
+			})
+		}
+	}
+	return problems
+}
+
+// grantsWrite reports whether an icacls ACE line allows modification
+func grantsWrite(ace string) bool {
+	for _, right := range writeRights {
+		if strings.Contains(ace, right) {
+			return true
+		}
+	}
+	return false
+}
+
+// restrictDir removes inherited ACEs and grants the current user full control
+func restrictDir(path string) error {
+	user := os.Getenv("USERNAME")
+	if user == "" {
+		return nil
+	}
+	out, err := exec.Command("icacls", path, "/inheritance:r", "/grant:r", user+":(OI)(CI)F").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("icacls: %s", strings.TrimSpace(string(out)))
+	}
+	return nil
+}
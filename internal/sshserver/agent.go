@@ -0,0 +1,56 @@
+package sshserver
+
+import (
+	"context"
+	"fmt"
+	"path"
+
+	"github.com/superfly/sprites-go"
+)
+
+// agentChannelType is the channel opened to the client for each connection
+// to the forwarded agent socket
+const agentChannelType = "auth-agent@openssh.com"
+
+// agentSocketDir is where forwarded agent sockets are created on the sprite
+const agentSocketDir = "/tmp/sprite-bootstrap-agent"
+
+// agentSocket returns the path of the connection's forwarded agent socket on
+// the sprite, starting its listener on first use. Each connection to the
+// socket is relayed over an auth-agent@openssh.com channel to the client's
+// agent.
+func (c *sshConn) agentSocket(ctx context.Context, sprite *sprites.Sprite) (string, error) {
+	c.agentMu.Lock()
+	defer c.agentMu.Unlock()
+
+	if c.agentSock != "" {
+		return c.agentSock, nil
+	}
+
+	sock := path.Join(agentSocketDir+"-"+c.state.id, "agent.sock")
+	_, err := startSpriteListener(ctx, sprite, "unix", sock,
+		func(ctx context.Context, localPort int, _ string, _ uint32) {
+			c.bridgeToClient(ctx, sprite, localPort, agentChannelType, nil, sock)
+		})
+	if err != nil {
+		return "", fmt.Errorf("forward agent: %w", err)
+	}
+
+	logger(ctx).InfoContext(ctx, "Forwarding SSH agent", "socket", sock)
+	c.agentSock = sock
+	return sock, nil
+}
+
+// forwardAgent handles auth-agent-req@openssh.com by pointing the session's
+// SSH_AUTH_SOCK at the connection's forwarded agent socket
+func (s *session) forwardAgent() error {
+	if s.running.Load() {
+		return errAlreadyRunning
+	}
+	sock, err := s.conn.agentSocket(s.connCtx, s.sprite.Load())
+	if err != nil {
+		return err
+	}
+	s.setEnv("SSH_AUTH_SOCK", sock)
+	return nil
+}
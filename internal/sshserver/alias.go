@@ -0,0 +1,294 @@
+package sshserver
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/vaurdan/sprite-bootstrap/internal/ratelimit"
+)
+
+// AliasDomain is the domain alias host names live under. Browsers and most
+// resolvers map *.localhost to loopback, so no hosts file entries are needed.
+const AliasDomain = "sprite.localhost"
+
+// AliasURL returns the URL that reaches a sprite through an AliasProxy
+// listening on port
+func AliasURL(spriteName string, port int) string {
+	return fmt.Sprintf("http://%s.%s:%d/", spriteName, AliasDomain, port)
+}
+
+// AliasProxy is a local HTTP reverse proxy that routes requests for
+// <sprite>.sprite.localhost to a port on that sprite, through the sprite's
+// proxy endpoint. WebSocket upgrades are passed through. Only sprites with a
+// route are reachable, so a web page can't use the proxy to reach any
+// sprite the token has access to.
+type AliasProxy struct {
+	tokens *TokenOptions
+
+	// tlsConfig and proxyFn reach the sprites API the way a server with
+	// the same TLSOptions and IgnoreProxyEnv does
+	tlsConfig *tls.Config
+	proxyFn   proxyFunc
+
+	mu            sync.RWMutex
+	routes        map[string]int // Sprite name to port on the sprite
+	forwardRoutes map[string]int // Routes from active forwards, see SetForwardRoutes
+
+	proxy *httputil.ReverseProxy
+
+	// limitUp and limitDown throttle traffic to and from sprites
+	limitUp, limitDown *ratelimit.Limiter
+}
+
+// NewAliasProxy creates an alias proxy. Requests for sprites without a route
+// are refused. The sprites API is reached with tlsOpts, through the proxy
+// the environment names unless ignoreProxyEnv is set.
+func NewAliasProxy(tokens *TokenOptions, tlsOpts TLSOptions, ignoreProxyEnv bool) (*AliasProxy, error) {
+	tlsConfig, err := tlsOpts.config()
+	if err != nil {
+		return nil, err
+	}
+	p := &AliasProxy{
+		tokens:        tokens,
+		tlsConfig:     tlsConfig,
+		routes:        make(map[string]int),
+		forwardRoutes: make(map[string]int),
+		limitUp:       ratelimit.New(0),
+		limitDown:     ratelimit.New(0),
+	}
+	if !ignoreProxyEnv {
+		p.proxyFn = proxyFromEnvironment
+	}
+	p.proxy = &httputil.ReverseProxy{
+		Rewrite: func(r *httputil.ProxyRequest) {
+			// The dial address names the sprite and port; the service
+			// itself sees a plain localhost request, with the alias in
+			// X-Forwarded-Host
+			target, _ := r.In.Context().Value(aliasTargetKey{}).(aliasTarget)
+			r.Out.URL.Scheme = "http"
+			r.Out.URL.Host = net.JoinHostPort(target.sprite, strconv.Itoa(target.port))
+			r.Out.Host = "localhost:" + strconv.Itoa(target.port)
+			r.SetXForwarded()
+		},
+		Transport: &http.Transport{
+			DialContext:         p.dial,
+			MaxIdleConnsPerHost: 4,
+			IdleConnTimeout:     90 * time.Second,
+		},
+		ErrorHandler: p.badGateway,
+	}
+	return p, nil
+}
+
+// SetRoute sends requests for a sprite to port on the sprite
+func (p *AliasProxy) SetRoute(spriteName string, port int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.routes[strings.ToLower(spriteName)] = port
+}
+
+// SetForwardRoutes replaces the routes taken from active forwards, by
+// sprite name. Routes set with SetRoute take precedence.
+func (p *AliasProxy) SetForwardRoutes(routes map[string]int) {
+	lower := make(map[string]int, len(routes))
+	for name, port := range routes {
+		lower[strings.ToLower(name)] = port
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.forwardRoutes = lower
+}
+
+// SetRateLimits caps the bytes per second all requests together send to and
+// receive from sprites, for connections already open too. Zero means no
+// limit.
+func (p *AliasProxy) SetRateLimits(up, down int64) {
+	p.limitUp.SetRate(up)
+	p.limitDown.SetRate(down)
+}
+
+// RateLimits returns the limits of SetRateLimits
+func (p *AliasProxy) RateLimits() (up, down int64) {
+	return p.limitUp.Rate(), p.limitDown.Rate()
+}
+
+// Routes returns the port each routed sprite's requests go to
+func (p *AliasProxy) Routes() map[string]int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	routes := make(map[string]int, len(p.routes)+len(p.forwardRoutes))
+	for name, port := range p.forwardRoutes {
+		routes[name] = port
+	}
+	for name, port := range p.routes {
+		routes[name] = port
+	}
+	return routes
+}
+
+// RoutedSprites returns the names of the routed sprites, sorted
+func (p *AliasProxy) RoutedSprites() []string {
+	routes := p.Routes()
+	names := make([]string, 0, len(routes))
+	for name := range routes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// aliasTarget is where a request is routed
+type aliasTarget struct {
+	sprite string
+	port   int
+}
+
+type aliasTargetKey struct{}
+
+// target maps a Host header to the sprite and port it routes to
+func (p *AliasProxy) target(host string) (aliasTarget, bool) {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	name, ok := strings.CutSuffix(strings.ToLower(host), "."+AliasDomain)
+	if !ok || name == "" || strings.Contains(name, ".") {
+		return aliasTarget{}, false
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	port, routed := p.routes[name]
+	if !routed {
+		port, routed = p.forwardRoutes[name]
+	}
+	return aliasTarget{sprite: name, port: port}, routed && port != 0
+}
+
+func (p *AliasProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	target, ok := p.target(r.Host)
+	if !ok {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "No sprite route for %q.\n\nOpen http://<sprite>.%s/ on this port", r.Host, AliasDomain)
+		if routes := p.RoutedSprites(); len(routes) > 0 {
+			fmt.Fprintf(w, ", e.g. one of:\n\n")
+			for _, name := range routes {
+				fmt.Fprintf(w, "  %s.%s\n", name, AliasDomain)
+			}
+		} else {
+			fmt.Fprintln(w, ".\n\nNo sprite is routed yet: forward a port to one, or restart with --route SPRITE=PORT.")
+		}
+		return
+	}
+
+	ctx := context.WithValue(r.Context(), aliasTargetKey{}, target)
+	ctx = withLogger(ctx, logger(ctx).With("sprite.name", target.sprite))
+	p.proxy.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// dial opens a connection to the port on the sprite named by addr
+func (p *AliasProxy) dial(ctx context.Context, _, addr string) (net.Conn, error) {
+	spriteName, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, err
+	}
+
+	wsConn, _, err := dialProxy(ctx, p.tokens.API, p.tokens.AuthToken, spriteName, "localhost", port, defaultWSBufferSize, false, p.tlsConfig, p.proxyFn)
+	if err != nil {
+		return nil, err
+	}
+	return ratelimit.Conn(&wsNetConn{ws: wsConn, addr: aliasAddr(addr)}, p.limitUp, p.limitDown), nil
+}
+
+// badGateway explains a request that couldn't reach the sprite, which is
+// usually asleep, deleted or not running anything on the port
+func (p *AliasProxy) badGateway(w http.ResponseWriter, r *http.Request, err error) {
+	target, _ := r.Context().Value(aliasTargetKey{}).(aliasTarget)
+	logger(r.Context()).WarnContext(r.Context(), "Alias request failed",
+		"port", target.port,
+		"exception", err)
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusBadGateway)
+	fmt.Fprintf(w, "Could not reach port %d on sprite %s: %v\n\n", target.port, target.sprite, err)
+	fmt.Fprintf(w, "Check that the sprite exists and that a service is listening on port %d there.\n", target.port)
+	fmt.Fprintf(w, "To use another port, restart with --route %s=PORT.\n", target.sprite)
+}
+
+// aliasAddr is the net.Addr of a sprite connection
+type aliasAddr string
+
+func (a aliasAddr) Network() string { return "sprite" }
+func (a aliasAddr) String() string  { return string(a) }
+
+// wsNetConn adapts a proxy WebSocket to net.Conn, so HTTP clients can use it
+type wsNetConn struct {
+	ws     *websocket.Conn
+	addr   net.Addr
+	reader io.Reader // Current message being read
+}
+
+func (c *wsNetConn) Read(b []byte) (int, error) {
+	for {
+		if c.reader == nil {
+			messageType, r, err := c.ws.NextReader()
+			if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				return 0, io.EOF
+			} else if err != nil {
+				return 0, err
+			}
+			if messageType != websocket.BinaryMessage {
+				continue
+			}
+			c.reader = r
+		}
+
+		n, err := c.reader.Read(b)
+		if errors.Is(err, io.EOF) {
+			c.reader = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+func (c *wsNetConn) Write(b []byte) (int, error) {
+	if err := c.ws.WriteMessage(websocket.BinaryMessage, b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *wsNetConn) Close() error                       { return c.ws.Close() }
+func (c *wsNetConn) LocalAddr() net.Addr                { return c.ws.LocalAddr() }
+func (c *wsNetConn) RemoteAddr() net.Addr               { return c.addr }
+func (c *wsNetConn) SetReadDeadline(t time.Time) error  { return c.ws.SetReadDeadline(t) }
+func (c *wsNetConn) SetWriteDeadline(t time.Time) error { return c.ws.SetWriteDeadline(t) }
+
+func (c *wsNetConn) SetDeadline(t time.Time) error {
+	if err := c.ws.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.ws.SetWriteDeadline(t)
+}
@@ -0,0 +1,151 @@
+package sshserver
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAliasProxyTarget(t *testing.T) {
+	p, err := NewAliasProxy(&TokenOptions{}, TLSOptions{}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.SetRoute("Web", 5173)
+	p.SetRoute("both", 3000)
+	p.SetForwardRoutes(map[string]int{"api": 8080, "both": 9000})
+
+	tests := []struct {
+		host   string
+		sprite string
+		port   int
+		ok     bool
+	}{
+		{"web.sprite.localhost:8080", "web", 5173, true},
+		{"WEB.sprite.localhost", "web", 5173, true},
+		{"api.sprite.localhost:8080", "api", 8080, true},
+		{"both.sprite.localhost", "both", 3000, true}, // --route wins over a forward
+		{"other.sprite.localhost:8080", "", 0, false},
+		{"sprite.localhost", "", 0, false},
+		{"a.b.sprite.localhost", "", 0, false},
+		{"web.example.com", "", 0, false},
+	}
+	for _, tt := range tests {
+		target, ok := p.target(tt.host)
+		if ok != tt.ok || (ok && (target.sprite != tt.sprite || target.port != tt.port)) {
+			t.Errorf("target(%q) = %+v, %v; want %s:%d, %v", tt.host, target, ok, tt.sprite, tt.port, tt.ok)
+		}
+	}
+}
+
+func TestAliasProxyRejectsUnroutedHosts(t *testing.T) {
+	p, err := NewAliasProxy(&TokenOptions{}, TLSOptions{}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.SetForwardRoutes(map[string]int{"api": 8080})
+	p.SetForwardRoutes(nil) // The forward ended
+
+	r := httptest.NewRequest(http.MethodGet, "http://api.sprite.localhost:8080/", nil)
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, r)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+// TestAliasProxyTLS routes requests to a sprite through a fake API whose
+// certificate a private CA signed
+func TestAliasProxyTLS(t *testing.T) {
+	dir := t.TempDir()
+	notAfter := time.Now().Add(time.Hour)
+	ca, caKey, caFile, _ := writeTestCert(t, dir, "ca", &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotAfter:              notAfter,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil, nil)
+	_, _, serverFile, serverKeyFile := writeTestCert(t, dir, "server", &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		NotAfter:     notAfter,
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca, caKey)
+	serverCert, err := tls.LoadX509KeyPair(serverFile, serverKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := httptest.NewUnstartedServer(newFakeAPI(t))
+	api.TLS = &tls.Config{Certificates: []tls.Certificate{serverCert}}
+	api.StartTLS()
+	t.Cleanup(api.Close)
+
+	// The service on the sprite
+	service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "hello from the sprite")
+	}))
+	t.Cleanup(service.Close)
+	_, port, err := net.SplitHostPort(service.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	servicePort, _ := strconv.Atoi(port)
+
+	tests := []struct {
+		name     string
+		opts     TLSOptions
+		ignore   bool
+		wantCode int
+	}{
+		{"system roots only", TLSOptions{}, true, http.StatusBadGateway},
+		{"private CA", TLSOptions{CACertFile: caFile}, true, http.StatusOK},
+		{"private CA with proxy environment", TLSOptions{CACertFile: caFile}, false, http.StatusOK},
+		{"insecure", TLSOptions{InsecureSkipVerify: true}, true, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := NewAliasProxy(&TokenOptions{API: api.URL, AuthToken: "test"}, tt.opts, tt.ignore)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := p.proxyFn != nil; got == tt.ignore {
+				t.Errorf("alias proxy has a proxy function = %v with ignoreProxyEnv %v", got, tt.ignore)
+			}
+			p.SetRoute("demo", servicePort)
+
+			r := httptest.NewRequest(http.MethodGet, "http://demo.sprite.localhost:8080/", nil)
+			w := httptest.NewRecorder()
+			p.ServeHTTP(w, r)
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d; body:\n%s", w.Code, tt.wantCode, w.Body)
+			}
+			if tt.wantCode == http.StatusOK && w.Body.String() != "hello from the sprite" {
+				t.Errorf("body = %q, want the service's", w.Body)
+			}
+			if tt.wantCode == http.StatusBadGateway && !strings.Contains(w.Body.String(), "certificate") {
+				t.Errorf("502 page doesn't name the certificate error:\n%s", w.Body)
+			}
+		})
+	}
+}
+
+// TestNewAliasProxyTLSError refuses TLS options that can't be loaded
+func TestNewAliasProxyTLSError(t *testing.T) {
+	_, err := NewAliasProxy(&TokenOptions{}, TLSOptions{CACertFile: filepath.Join(t.TempDir(), "missing.pem")}, false)
+	if err == nil {
+		t.Error("NewAliasProxy accepted a missing CA file")
+	}
+}
@@ -0,0 +1,160 @@
+package sshserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/vaurdan/sprite-bootstrap/internal/logfile"
+)
+
+// auditShell is the command recorded for interactive shells
+const auditShell = "interactive shell"
+
+// Audit event types
+const (
+	auditExec       = "exec"
+	auditShellStart = "shell.start"
+	auditShellEnd   = "shell.end"
+)
+
+// auditEvent is one line of the audit log
+type auditEvent struct {
+	Time    time.Time `json:"time"`
+	Event   string    `json:"event"`
+	Sprite  string    `json:"sprite"`
+	Remote  string    `json:"remote"`
+	ConnID  string    `json:"conn_id"`
+	Session int       `json:"session"`
+	Command string    `json:"command"`
+	// ExitStatus is missing when the command didn't report one, e.g. when
+	// the connection to the sprite failed or the session was ended
+	ExitStatus *int   `json:"exit_status,omitempty"`
+	DurationMS *int64 `json:"duration_ms,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// auditLog appends exec and shell requests to a file as JSON lines. Each
+// event is written straight to the file, so nothing is lost on a crash.
+type auditLog struct {
+	w      *logfile.Writer
+	redact func(string) string
+}
+
+// openAuditLog opens the audit log at path, or returns nil if path is empty
+func openAuditLog(path string, maxSize int64, redact func(string) string) (*auditLog, error) {
+	if path == "" {
+		return nil, nil
+	}
+	w, err := logfile.Open(path, maxSize, logfile.DefaultBackups)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	return &auditLog{w: w, redact: redact}, nil
+}
+
+func (a *auditLog) write(ctx context.Context, ev auditEvent) {
+	if a.redact != nil && ev.Command != auditShell {
+		ev.Command = a.redact(ev.Command)
+	}
+	line, err := json.Marshal(ev)
+	if err != nil {
+		return
+	}
+	if _, err := a.w.Write(append(line, '\n')); err != nil {
+		logger(ctx).ErrorContext(ctx, "Failed to write audit log", "exception", err)
+	}
+}
+
+// auditStart records the start of an interactive shell. Exec requests are
+// only recorded once they end.
+func (s *session) auditStart(ctx context.Context, isShell bool) {
+	if s.conn.srv.audit == nil || !isShell {
+		return
+	}
+	s.conn.srv.audit.write(ctx, s.auditEvent(auditShellStart, ""))
+}
+
+// auditEnd records an exec request or interactive shell that ended after
+// running since started. err is why it failed, if it did.
+func (s *session) auditEnd(ctx context.Context, command string, isShell bool, started time.Time, err error) {
+	if s.conn.srv.audit == nil {
+		return
+	}
+	event := auditExec
+	if isShell {
+		event = auditShellEnd
+	}
+	ev := s.auditEvent(event, command)
+	duration := time.Since(started).Milliseconds()
+	ev.DurationMS = &duration
+	if status, ok := s.exitStatus(); ok {
+		ev.ExitStatus = &status
+	}
+	if err != nil {
+		ev.Error = err.Error()
+	}
+	s.conn.srv.audit.write(ctx, ev)
+}
+
+func (s *session) auditEvent(event, command string) auditEvent {
+	if command == "" {
+		command = auditShell
+	}
+	return auditEvent{
+		Time:    time.Now().UTC(),
+		Event:   event,
+		Sprite:  s.sprite.Load().Name(),
+		Remote:  s.conn.state.remote,
+		ConnID:  s.conn.state.id,
+		Session: s.id,
+		Command: command,
+	}
+}
+
+// RedactPatterns returns an audit redaction hook that masks every match of
+// the regular expressions with "***". For patterns with groups, only the
+// groups are masked, so `token=(\S+)` keeps "token=".
+func RedactPatterns(patterns []string) (func(string) string, error) {
+	if len(patterns) == 0 {
+		return nil, nil
+	}
+	var res []*regexp.Regexp
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redaction pattern %q: %w", p, err)
+		}
+		res = append(res, re)
+	}
+	return func(command string) string {
+		for _, re := range res {
+			command = redactMatches(re, command)
+		}
+		return command
+	}, nil
+}
+
+func redactMatches(re *regexp.Regexp, s string) string {
+	const mask = "***"
+	if re.NumSubexp() == 0 {
+		return re.ReplaceAllLiteralString(s, mask)
+	}
+	var out []byte
+	last := 0
+	for _, m := range re.FindAllStringSubmatchIndex(s, -1) {
+		for i := 2; i < len(m); i += 2 {
+			// Skip groups that didn't take part, or that sit inside one
+			// already masked
+			if m[i] < 0 || m[i] < last {
+				continue
+			}
+			out = append(out, s[last:m[i]]...)
+			out = append(out, mask...)
+			last = m[i+1]
+		}
+	}
+	return string(append(out, s[last:]...))
+}
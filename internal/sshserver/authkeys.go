@@ -0,0 +1,166 @@
+package sshserver
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/vaurdan/sprite-bootstrap/internal/textfile"
+	"golang.org/x/crypto/ssh"
+)
+
+// authorizedKeysPollInterval is how often Watch checks the file for changes
+var authorizedKeysPollInterval = 5 * time.Second
+
+// AuthorizedKeys is a set of public keys allowed to connect, loaded from a
+// file it can be reloaded from. The same type holds trusted user CA keys
+// and revoked keys (see usercert.go).
+type AuthorizedKeys struct {
+	path       string
+	what       string // What the file holds, for logs
+	allowEmpty bool
+
+	mu      sync.RWMutex
+	keys    map[string]struct{}
+	modTime time.Time
+	size    int64
+}
+
+// DefaultAuthorizedKeysPath returns ~/.ssh/authorized_keys.
+func DefaultAuthorizedKeysPath() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(homeDir, ".ssh", "authorized_keys"), nil
+}
+
+// LoadAuthorizedKeys reads an OpenSSH authorized_keys file. Comments and
+// blank lines are skipped and options on each line are ignored.
+func LoadAuthorizedKeys(path string) (*AuthorizedKeys, error) {
+	return loadKeys(&AuthorizedKeys{path: path, what: "authorized keys"})
+}
+
+// LoadTrustedUserCAKeys reads a file of CA public keys in authorized_keys
+// format, like OpenSSH's TrustedUserCAKeys.
+func LoadTrustedUserCAKeys(path string) (*AuthorizedKeys, error) {
+	return loadKeys(&AuthorizedKeys{path: path, what: "trusted user CA keys"})
+}
+
+// LoadRevokedKeys reads a file of revoked public keys in authorized_keys
+// format, like OpenSSH's RevokedKeys. It may be empty. Binary key
+// revocation lists (KRLs) aren't supported.
+func LoadRevokedKeys(path string) (*AuthorizedKeys, error) {
+	return loadKeys(&AuthorizedKeys{path: path, what: "revoked keys", allowEmpty: true})
+}
+
+func loadKeys(ak *AuthorizedKeys) (*AuthorizedKeys, error) {
+	if _, err := ak.Reload(); err != nil {
+		return nil, err
+	}
+	return ak, nil
+}
+
+// krlMagic starts a binary key revocation list
+const krlMagic = "SSHKRL\n\x00"
+
+// readAuthorizedKeys parses the keys in an authorized_keys file
+func readAuthorizedKeys(path string, allowEmpty bool) (map[string]struct{}, error) {
+	data, _, err := textfile.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(data, []byte(krlMagic)) {
+		return nil, fmt.Errorf("%s is a binary KRL, which isn't supported; list the revoked keys one per line instead", path)
+	}
+
+	keys := make(map[string]struct{})
+	for len(bytes.TrimSpace(data)) > 0 {
+		pub, _, _, rest, err := ssh.ParseAuthorizedKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("parse %s: %w", path, err)
+		}
+		keys[string(pub.Marshal())] = struct{}{}
+		data = rest
+	}
+
+	if len(keys) == 0 && !allowEmpty {
+		return nil, fmt.Errorf("%s has no keys", path)
+	}
+	return keys, nil
+}
+
+// Reload re-reads the file if it changed since it was last read, reporting
+// whether it did. On error the keys already loaded stay in effect.
+func (ak *AuthorizedKeys) Reload() (bool, error) {
+	info, err := os.Stat(ak.path)
+	if err != nil {
+		return false, err
+	}
+	ak.mu.RLock()
+	unchanged := ak.keys != nil && info.ModTime().Equal(ak.modTime) && info.Size() == ak.size
+	ak.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	keys, err := readAuthorizedKeys(ak.path, ak.allowEmpty)
+	if err != nil {
+		return false, err
+	}
+	ak.mu.Lock()
+	ak.keys, ak.modTime, ak.size = keys, info.ModTime(), info.Size()
+	ak.mu.Unlock()
+	return true, nil
+}
+
+// Watch reloads the file when it changes, and whenever reload receives,
+// until ctx ends
+func (ak *AuthorizedKeys) Watch(ctx context.Context, reload <-chan os.Signal) {
+	ticker := time.NewTicker(authorizedKeysPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-reload:
+			// Forget the file's state, so it is re-read even if its
+			// timestamp didn't change
+			ak.mu.Lock()
+			ak.modTime = time.Time{}
+			ak.mu.Unlock()
+		}
+
+		changed, err := ak.Reload()
+		if err != nil {
+			slog.Warn("Failed to reload "+ak.what+", keeping the previous ones",
+				"path", ak.path,
+				"exception", err)
+		} else if changed {
+			slog.Info("Reloaded "+ak.what, "path", ak.path, "keys", ak.Len())
+		}
+	}
+}
+
+// Len returns the number of keys in the set.
+func (ak *AuthorizedKeys) Len() int {
+	ak.mu.RLock()
+	defer ak.mu.RUnlock()
+	return len(ak.keys)
+}
+
+// Allows reports whether the key is in the set.
+func (ak *AuthorizedKeys) Allows(pub ssh.PublicKey) bool {
+	ak.mu.RLock()
+	defer ak.mu.RUnlock()
+	_, ok := ak.keys[string(pub.Marshal())]
+	return ok
+}
@@ -0,0 +1,146 @@
+package sshserver
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// defaultMaxAuthTries is used when ServerConfig.MaxAuthTries is zero,
+// matching OpenSSH's MaxAuthTries
+const defaultMaxAuthTries = 6
+
+// authCounter counts authentication attempts per remote IP
+type authCounter struct {
+	mu   sync.Mutex
+	byIP map[string]*AuthSnapshot
+}
+
+// AuthSnapshot counts authentication attempts from one remote IP
+type AuthSnapshot struct {
+	IP          string    `json:"ip"`
+	Failures    int64     `json:"failures"`
+	Successes   int64     `json:"successes"`
+	LastFailure time.Time `json:"last_failure,omitzero"`
+	LastSeen    time.Time `json:"last_seen"`
+}
+
+// record counts one attempt from addr
+func (a *authCounter) record(addr net.Addr, ok bool) {
+	ip := remoteIP(addr)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.byIP == nil {
+		a.byIP = make(map[string]*AuthSnapshot)
+	}
+	c, found := a.byIP[ip]
+	if !found {
+		c = &AuthSnapshot{IP: ip}
+		a.byIP[ip] = c
+	}
+	c.LastSeen = time.Now()
+	if ok {
+		c.Successes++
+	} else {
+		c.Failures++
+		c.LastFailure = time.Now()
+	}
+}
+
+// prune drops counters for IPs not seen since before, then the least
+// recently seen ones beyond max, returning how many were dropped
+func (a *authCounter) prune(before time.Time, max int) int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	dropped := 0
+	for ip, c := range a.byIP {
+		if c.LastSeen.Before(before) {
+			delete(a.byIP, ip)
+			dropped++
+		}
+	}
+	if over := len(a.byIP) - max; over > 0 {
+		oldest := make([]*AuthSnapshot, 0, len(a.byIP))
+		for _, c := range a.byIP {
+			oldest = append(oldest, c)
+		}
+		sort.Slice(oldest, func(i, j int) bool { return oldest[i].LastSeen.Before(oldest[j].LastSeen) })
+		for _, c := range oldest[:over] {
+			delete(a.byIP, c.IP)
+			dropped++
+		}
+	}
+	return dropped
+}
+
+// snapshot returns the counters, most failures first
+func (a *authCounter) snapshot() []AuthSnapshot {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	snaps := make([]AuthSnapshot, 0, len(a.byIP))
+	for _, c := range a.byIP {
+		snaps = append(snaps, *c)
+	}
+	sort.Slice(snaps, func(i, j int) bool {
+		if snaps[i].Failures != snaps[j].Failures {
+			return snaps[i].Failures > snaps[j].Failures
+		}
+		return snaps[i].IP < snaps[j].IP
+	})
+	return snaps
+}
+
+// authLogCallback counts every authentication attempt. The "none" method
+// clients try first to list the methods on offer isn't counted.
+func (srv *Server) authLogCallback(cm ssh.ConnMetadata, method string, err error) {
+	if method == "none" {
+		return
+	}
+	srv.registry.auth.record(cm.RemoteAddr(), err == nil)
+	if err != nil {
+		slog.Debug("Authentication attempt failed",
+			"conn.id", authConnID(cm),
+			"sprite.name", cm.User(),
+			"remote", cm.RemoteAddr().String(),
+			"auth.method", method,
+			"exception", err)
+	}
+}
+
+// authConnID is the conn.id the connection is logged with once it's
+// established, so its authentication can be told apart from others'
+func authConnID(cm ssh.ConnMetadata) string {
+	return bech32Encoding.EncodeToString(cm.SessionID())
+}
+
+// logKeyAuth logs the outcome of checking an offered public key: failures at
+// info, so attempts against an exposed server are visible, and successes at
+// debug
+func logKeyAuth(cm ssh.ConnMetadata, pub ssh.PublicKey, err error) {
+	attrs := []any{
+		"conn.id", authConnID(cm),
+		"sprite.name", cm.User(),
+		"remote", cm.RemoteAddr().String(),
+		"key.type", pub.Type(),
+		"key.fingerprint", ssh.FingerprintSHA256(pub),
+	}
+	if cert, ok := pub.(*ssh.Certificate); ok {
+		attrs = append(attrs, "cert.key_id", cert.KeyId, "cert.serial", cert.Serial)
+	}
+	if errors.Is(err, errAuthThrottled) {
+		// Logged once when the throttle kicks in
+		return
+	} else if err != nil {
+		slog.Info("Rejected public key", append(attrs, "exception", err)...)
+		return
+	}
+	slog.Debug("Accepted public key", attrs...)
+}
@@ -0,0 +1,156 @@
+package sshserver
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/superfly/sprites-go"
+	"golang.org/x/crypto/ssh"
+)
+
+// suggestTimeout bounds listing sprites for close names
+const suggestTimeout = 5 * time.Second
+
+// maxSuggestions is how many close sprite names a banner offers
+const maxSuggestions = 3
+
+// bannerCallback looks up the connection's sprite before authentication
+// and, when that fails, returns a banner saying why, so the client sees
+// more than "Permission denied". The SSH library sends the banner before
+// the first authentication attempt, so the lookup happens here; a
+// successful one is reused by lookupUser through the wake cache.
+//
+// With --authorized-keys or trusted user CAs there is no banner, so
+// clients whose key isn't accepted can't probe sprite names.
+func (srv *Server) bannerCallback(cm ssh.ConnMetadata) string {
+	if srv.restrictsKeys() || !srv.throttle.allow(cm.RemoteAddr()) {
+		return ""
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), lookupTimeout)
+	defer cancel()
+
+	_, err := srv.wakeSprite(ctx, cm.User())
+	if err == nil {
+		return ""
+	}
+	return "sprite-bootstrap: " + srv.describeLookupError(cm.User(), err) + "\n"
+}
+
+// describeLookupError explains a failed sprite lookup in terms a client can
+// act on. API error bodies and credentials are left out; the serve log has
+// the full error.
+func (srv *Server) describeLookupError(user string, err error) string {
+	name, org := parseUser(user)
+
+	var apiErr *sprites.APIError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, errAmbiguousSprite):
+		return err.Error()
+	case errors.Is(err, errOrgNotServed):
+		return fmt.Sprintf("organization %q is not served here; serve is limited to %s", org, srv.orgs.defRoute().org)
+	case errors.Is(err, errOrgCredentials):
+		return fmt.Sprintf("no sprites credentials for organization %q; run \"sprite login\"", org)
+	case tokenRejected(err):
+		return "the sprites API rejected the stored credentials; run \"sprite login\" and reconnect"
+	case errors.As(err, &apiErr):
+		return fmt.Sprintf("the sprites API failed with status %d; try again shortly", apiErr.StatusCode)
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr):
+		return fmt.Sprintf("could not reach the sprites API at %s", apiHost(srv.orgs.defRoute().apiURL))
+	case strings.Contains(err.Error(), "sprite not found"):
+		msg := fmt.Sprintf("no sprite named %q", name)
+		if org != "" {
+			msg += " in organization " + org
+		}
+		if close := srv.similarSprites(name, org); len(close) > 0 {
+			msg += "; did you mean " + strings.Join(close, ", ") + "?"
+		}
+		return msg
+	default:
+		return fmt.Sprintf("could not look up sprite %q; see the serve log for details", name)
+	}
+}
+
+// similarSprites returns sprite names in the organization close to name,
+// closest first. Failures are only logged: suggestions are a courtesy.
+func (srv *Server) similarSprites(name, org string) []string {
+	route := srv.orgs.defRoute()
+	if org != "" {
+		r, err := srv.orgs.route(org)
+		if err != nil {
+			return nil
+		}
+		route = r
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), suggestTimeout)
+	defer cancel()
+
+	all, err := route.client.ListAllSprites(ctx, "")
+	if err != nil {
+		slog.Debug("Failed to list sprites for suggestions",
+			"org", route.org,
+			"exception", err)
+		return nil
+	}
+
+	type candidate struct {
+		name string
+		dist int
+	}
+	var close []candidate
+	for _, s := range all {
+		d := editDistance(name, s.Name())
+		if d <= max(2, len(name)/3) || strings.HasPrefix(s.Name(), name) {
+			close = append(close, candidate{s.Name(), d})
+		}
+	}
+	slices.SortFunc(close, func(a, b candidate) int {
+		return cmp.Or(cmp.Compare(a.dist, b.dist), strings.Compare(a.name, b.name))
+	})
+
+	var names []string
+	for _, c := range close[:min(len(close), maxSuggestions)] {
+		names = append(names, c.name)
+	}
+	return names
+}
+
+// editDistance is the Levenshtein distance between two names
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// apiHost returns the host of an API URL, without any credentials or path
+func apiHost(apiURL string) string {
+	u, err := url.Parse(apiURL)
+	if err != nil || u.Host == "" {
+		return "the configured URL"
+	}
+	return u.Host
+}
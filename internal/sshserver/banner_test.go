@@ -0,0 +1,86 @@
+package sshserver
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// bannerFor logs in as user and returns the banner the server sent
+func bannerFor(t *testing.T, addr, user string) string {
+	t.Helper()
+	var banner string
+	client, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            user,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(newTestSigner(t))},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		BannerCallback:  func(msg string) error { banner = msg; return nil },
+		Timeout:         10 * time.Second,
+	})
+	if err == nil {
+		client.Close()
+		t.Errorf("login as %s succeeded", user)
+	}
+	return banner
+}
+
+func TestAuthFailureBanner(t *testing.T) {
+	const secret = "internal-detail-do-not-show"
+
+	// An API that knows sprites "demo" and "web", failing lookups in the
+	// way each case asks for
+	api := func(status int) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			if r.URL.Path == "/v1/sprites" {
+				json.NewEncoder(w).Encode(map[string]any{"sprites": []map[string]string{{"name": "demo"}, {"name": "web"}}})
+				return
+			}
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(map[string]string{"error": secret})
+		})
+	}
+
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unreachable := "http://" + closed.Addr().String()
+	closed.Close()
+
+	tests := []struct {
+		name string
+		opts *TokenOptions
+		user string
+		want string
+	}{
+		{"unknown sprite", testTokenOptions(t, api(http.StatusNotFound)), "dmeo", `no sprite named "dmeo"; did you mean demo?`},
+		{"no close names", testTokenOptions(t, api(http.StatusNotFound)), "zzzzzzzz", `no sprite named "zzzzzzzz"` + "\n"},
+		{"expired credentials", testTokenOptions(t, api(http.StatusUnauthorized)), "demo", `run "sprite login"`},
+		{"API failure", testTokenOptions(t, api(http.StatusInternalServerError)), "demo", "failed with status 500"},
+		{"API unreachable", &TokenOptions{API: unreachable, AuthToken: "test", Organization: "fake"}, "demo", "could not reach the sprites API at " + closed.Addr().String()},
+	}
+	for _, tt := range tests {
+		_, addr := startTestServer(t, &ServerConfig{TokenOptions: tt.opts})
+		banner := bannerFor(t, addr, tt.user)
+		if !strings.HasPrefix(banner, "sprite-bootstrap: ") || !strings.Contains(banner, tt.want) {
+			t.Errorf("%s: banner = %q, want it to say %q", tt.name, banner, tt.want)
+		}
+		if strings.Contains(banner, secret) || strings.Contains(banner, "Bearer") {
+			t.Errorf("%s: banner leaks the API response: %q", tt.name, banner)
+		}
+	}
+}
+
+func TestNoBannerWithRestrictedKeys(t *testing.T) {
+	keys := &AuthorizedKeys{}
+	_, addr := startTestServer(t, &ServerConfig{AuthorizedKeys: keys})
+	if banner := bannerFor(t, addr, "demo"); banner != "" {
+		t.Errorf("banner = %q, want none when keys are restricted", banner)
+	}
+}
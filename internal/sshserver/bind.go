@@ -0,0 +1,111 @@
+package sshserver
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// BindErrorKind classifies why a listen failed
+type BindErrorKind int
+
+const (
+	BindFailed            BindErrorKind = iota // Anything not classified below
+	BindPermissionDenied                       // EACCES, usually a port below 1024
+	BindAddressInUse                           // EADDRINUSE
+	BindFamilyUnsupported                      // EAFNOSUPPORT, e.g. IPv6 disabled
+)
+
+// PortOwner identifies the process listening on a port
+type PortOwner struct {
+	PID  int
+	Name string
+}
+
+// BindError is returned by Bind with a classified cause and a suggestion
+// for fixing it
+type BindError struct {
+	Addr  string
+	Kind  BindErrorKind
+	Owner *PortOwner // Process holding the port, when known (BindAddressInUse only)
+	Err   error
+}
+
+func (e *BindError) Unwrap() error {
+	return e.Err
+}
+
+func (e *BindError) Error() string {
+	switch e.Kind {
+	case BindPermissionDenied:
+		msg := fmt.Sprintf("permission denied listening on %s: ports below 1024 need elevated privileges\nUse a port of 1024 or above, e.g. -l :2222", e.Addr)
+		if runtime.GOOS == "linux" {
+			msg += ", or allow low ports with: sudo setcap cap_net_bind_service=+ep $(which sprite-bootstrap)"
+		}
+		return msg
+	case BindAddressInUse:
+		if e.Owner == nil {
+			return fmt.Sprintf("%s is already in use by another process\nStop that process or pick a different port", e.Addr)
+		}
+		msg := fmt.Sprintf("%s is already in use by %s (PID %d)", e.Addr, e.Owner.Name, e.Owner.PID)
+		if strings.Contains(e.Owner.Name, "sprite-bootstrap") {
+			msg += fmt.Sprintf("\nThis looks like an older sprite-bootstrap server. Stop it with 'sprite-bootstrap stop', or %s if it isn't tracked anymore", killHint(e.Owner.PID))
+		} else {
+			msg += "\nStop that process or pick a different port"
+		}
+		return msg
+	case BindFamilyUnsupported:
+		return fmt.Sprintf("cannot listen on %s: address family not supported (is IPv6 disabled?)\nTry an IPv4 address such as 127.0.0.1", e.Addr)
+	default:
+		return fmt.Sprintf("failed to listen on %s: %v", e.Addr, e.Err)
+	}
+}
+
+func killHint(pid int) string {
+	if runtime.GOOS == "windows" {
+		return fmt.Sprintf("run 'taskkill /PID %d'", pid)
+	}
+	return fmt.Sprintf("run 'kill %d'", pid)
+}
+
+// classifyBindError wraps a listen error in a BindError
+func classifyBindError(addr string, err error) error {
+	be := &BindError{Addr: addr, Kind: bindErrorKind(err), Err: err}
+	if be.Kind == BindAddressInUse {
+		if _, portStr, splitErr := net.SplitHostPort(addr); splitErr == nil {
+			if port, convErr := strconv.Atoi(portStr); convErr == nil {
+				if owner, ok := FindPortOwner(port); ok {
+					be.Owner = &owner
+				}
+			}
+		}
+	}
+	return be
+}
+
+func bindErrorKind(err error) BindErrorKind {
+	switch {
+	case errors.Is(err, syscall.EACCES):
+		return BindPermissionDenied
+	case errors.Is(err, syscall.EADDRINUSE):
+		return BindAddressInUse
+	case errors.Is(err, syscall.EAFNOSUPPORT):
+		return BindFamilyUnsupported
+	}
+
+	// Windows reports WSA error codes that don't match the syscall constants
+	msg := strings.ToLower(err.Error())
+	switch {
+	case strings.Contains(msg, "only one usage of each socket address"):
+		return BindAddressInUse
+	case strings.Contains(msg, "forbidden by its access permissions"):
+		return BindPermissionDenied
+	case strings.Contains(msg, "address incompatible with the requested protocol"):
+		return BindFamilyUnsupported
+	}
+	return BindFailed
+}
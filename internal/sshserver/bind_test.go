@@ -0,0 +1,175 @@
+package sshserver
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"runtime"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// listenError wraps errno the way net.Listen reports a failed bind
+func listenError(errno error) error {
+	return &net.OpError{Op: "listen", Net: "tcp", Err: os.NewSyscallError("bind", errno)}
+}
+
+func TestBindErrorKind(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want BindErrorKind
+	}{
+		{"EACCES", listenError(syscall.EACCES), BindPermissionDenied},
+		{"EADDRINUSE", listenError(syscall.EADDRINUSE), BindAddressInUse},
+		{"EAFNOSUPPORT", listenError(syscall.EAFNOSUPPORT), BindFamilyUnsupported},
+		{"other errno", listenError(syscall.EINVAL), BindFailed},
+		{"Windows in use", errors.New("listen tcp :2222: bind: Only one usage of each socket address (protocol/network address/port) is normally permitted."), BindAddressInUse},
+		{"Windows permission", errors.New("listen tcp :22: bind: An attempt was made to access a socket in a way forbidden by its access permissions."), BindPermissionDenied},
+		{"Windows family", errors.New("listen tcp [::1]:2222: bind: An address incompatible with the requested protocol was used."), BindFamilyUnsupported},
+		{"unrelated", errors.New("lookup nosuchhost: no such host"), BindFailed},
+	}
+	for _, tt := range tests {
+		if got := bindErrorKind(tt.err); got != tt.want {
+			t.Errorf("bindErrorKind(%s) = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestBindErrorMessages checks each kind of failure explains itself
+// differently, with what to do about it
+func TestBindErrorMessages(t *testing.T) {
+	tests := []struct {
+		name    string
+		err     *BindError
+		want    []string
+		notWant []string
+	}{
+		{
+			name: "permission denied",
+			err:  &BindError{Addr: ":22", Kind: BindPermissionDenied, Err: syscall.EACCES},
+			want: []string{"permission denied listening on :22", "below 1024", "-l :2222"},
+		},
+		{
+			name:    "in use, owner unknown",
+			err:     &BindError{Addr: ":2222", Kind: BindAddressInUse, Err: syscall.EADDRINUSE},
+			want:    []string{":2222 is already in use by another process", "pick a different port"},
+			notWant: []string{"sprite-bootstrap stop", "PID"},
+		},
+		{
+			name:    "in use by another program",
+			err:     &BindError{Addr: ":2222", Kind: BindAddressInUse, Owner: &PortOwner{PID: 812, Name: "sshd"}, Err: syscall.EADDRINUSE},
+			want:    []string{":2222 is already in use by sshd (PID 812)", "pick a different port"},
+			notWant: []string{"sprite-bootstrap stop"},
+		},
+		{
+			name:    "in use by an old server",
+			err:     &BindError{Addr: "127.0.0.1:2222", Kind: BindAddressInUse, Owner: &PortOwner{PID: 4242, Name: "sprite-bootstrap"}, Err: syscall.EADDRINUSE},
+			want:    []string{"in use by sprite-bootstrap (PID 4242)", "older sprite-bootstrap server", "'sprite-bootstrap stop'", "4242"},
+			notWant: []string{"pick a different port"},
+		},
+		{
+			name: "family unsupported",
+			err:  &BindError{Addr: "[::1]:2222", Kind: BindFamilyUnsupported, Err: syscall.EAFNOSUPPORT},
+			want: []string{"cannot listen on [::1]:2222", "address family not supported", "127.0.0.1"},
+		},
+		{
+			name: "other",
+			err:  &BindError{Addr: "10.9.9.9:2222", Kind: BindFailed, Err: syscall.EADDRNOTAVAIL},
+			want: []string{"failed to listen on 10.9.9.9:2222", syscall.EADDRNOTAVAIL.Error()},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := tt.err.Error()
+			for _, s := range tt.want {
+				if !strings.Contains(msg, s) {
+					t.Errorf("message doesn't contain %q:\n%s", s, msg)
+				}
+			}
+			for _, s := range tt.notWant {
+				if strings.Contains(msg, s) {
+					t.Errorf("message contains %q:\n%s", s, msg)
+				}
+			}
+			if !errors.Is(tt.err, tt.err.Err) {
+				t.Error("BindError doesn't unwrap to its cause")
+			}
+		})
+	}
+}
+
+// TestBindInUse binds an address this process already listens on and
+// checks the error names the process
+func TestBindInUse(t *testing.T) {
+	held, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer held.Close()
+	addr := held.Addr().String()
+
+	l, err := Bind(context.Background(), addr)
+	if err == nil {
+		l.Close()
+		t.Fatalf("Bind(%s) succeeded while the address is held", addr)
+	}
+	var be *BindError
+	if !errors.As(err, &be) {
+		t.Fatalf("Bind error = %T %v, want a *BindError", err, err)
+	}
+	if be.Kind != BindAddressInUse {
+		t.Errorf("Kind = %d, want BindAddressInUse: %v", be.Kind, err)
+	}
+	if runtime.GOOS == "linux" {
+		if be.Owner == nil || be.Owner.PID != os.Getpid() {
+			t.Errorf("Owner = %+v, want this process (PID %d)", be.Owner, os.Getpid())
+		}
+	}
+	if !errors.Is(err, syscall.EADDRINUSE) && runtime.GOOS != "windows" {
+		t.Errorf("error doesn't wrap EADDRINUSE: %v", err)
+	}
+}
+
+// TestBindLoopbackOnly checks a port held on loopback only is reported
+// busy for loopback and free for another address, so availability checks
+// must use the address the server will listen on
+func TestBindLoopbackOnly(t *testing.T) {
+	held, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer held.Close()
+	_, port, _ := net.SplitHostPort(held.Addr().String())
+
+	if l, err := Bind(context.Background(), "127.0.0.1:"+port); err == nil {
+		l.Close()
+		t.Error("loopback address reported free while held")
+	}
+	if runtime.GOOS != "linux" {
+		return // Other systems may refuse 127.0.0.2
+	}
+	l, err := Bind(context.Background(), "127.0.0.2:"+port)
+	if err != nil {
+		t.Fatalf("another loopback address on the same port: %v", err)
+	}
+	l.Close()
+}
+
+// TestBindPermissionDenied binds a privileged port as an unprivileged user
+func TestBindPermissionDenied(t *testing.T) {
+	if runtime.GOOS == "windows" || os.Geteuid() == 0 {
+		t.Skip("needs an unprivileged Unix user")
+	}
+	l, err := Bind(context.Background(), "127.0.0.1:1")
+	if err == nil {
+		l.Close()
+		t.Skip("low ports are open to unprivileged users here")
+	}
+	var be *BindError
+	if !errors.As(err, &be) || be.Kind != BindPermissionDenied {
+		t.Errorf("Bind(:1) = %v, want a permission error", err)
+	}
+}
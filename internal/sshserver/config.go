@@ -11,8 +11,11 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 
+	"github.com/vaurdan/sprite-bootstrap/internal/config"
+
 	keyring "github.com/zalando/go-keyring"
 )
 
@@ -26,6 +29,21 @@ var (
 
 var keyringService = "sprites-cli"
 
+// Environment variables that point the CLI at another sprites API, such as
+// the fake one from "sprite-bootstrap dev fake-api", instead of the
+// sprites config. Both must be set.
+const (
+	EnvAPI   = "SPRITES_API"
+	EnvToken = "SPRITES_TOKEN"
+)
+
+// envOverride returns the API URL and token from the environment, if both
+// are set
+func envOverride() (api, token string, ok bool) {
+	api, token = os.Getenv(EnvAPI), os.Getenv(EnvToken)
+	return api, token, api != "" && token != ""
+}
+
 // Config is a simplified sprite configuration.
 type Config struct {
 	Version string `json:"version"`
@@ -203,32 +221,72 @@ type TokenOptions struct {
 	API          string
 	AuthToken    string
 	Organization string
+
+	// Policy is the organization's policy, resolved along with the token;
+	// nil when none applies
+	Policy *config.Policy
 }
 
 // Resolve resolves the relevant API token from the global Sprites config.
 func (o *TokenOptions) Resolve() error {
 	if o.AuthToken != "" {
-		return nil
+		return o.resolvePolicy()
+	}
+	if api, token, ok := envOverride(); ok {
+		o.API, o.AuthToken = api, token
+		return o.resolvePolicy()
 	}
 
+	cfg, err := loadGlobalConfig()
+	if err != nil {
+		return err
+	}
+	return o.ResolveWithConfig(cfg)
+}
+
+// loadGlobalConfig loads ~/.sprites/sprites.json, merged with the current
+// user's config
+func loadGlobalConfig() (*Config, error) {
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
-		return fmt.Errorf("failed to locate sprites configuration: %w", err)
+		return nil, fmt.Errorf("failed to locate sprites configuration: %w", err)
 	}
 	cfg, err := LoadConfig(filepath.Join(homeDir, ".sprites", "sprites.json"))
 	if err != nil {
-		return fmt.Errorf("failed to read sprites config: %w", err)
+		return nil, fmt.Errorf("failed to read sprites config: %w", err)
 	}
 
 	// merge user config, if possible
 	if cfg.CurrentUser != "" {
 		cfg, err = cfg.UserConfig(cfg.CurrentUser)
 		if err != nil {
-			return fmt.Errorf("failed to read user sprites config: %w", err)
+			return nil, fmt.Errorf("failed to read user sprites config: %w", err)
 		}
 	}
+	return cfg, nil
+}
 
-	return o.ResolveWithConfig(cfg)
+// ConfiguredOrgs returns the organizations configured for an API URL in the
+// global Sprites config, sorted by name
+func ConfiguredOrgs(api string) ([]string, error) {
+	if _, _, ok := envOverride(); ok {
+		// The environment names a single API and token
+		return nil, nil
+	}
+	cfg, err := loadGlobalConfig()
+	if err != nil {
+		return nil, err
+	}
+	urlCfg, ok := cfg.URLs[api]
+	if !ok {
+		return nil, errNoURL
+	}
+	orgs := make([]string, 0, len(urlCfg.Orgs))
+	for name := range urlCfg.Orgs {
+		orgs = append(orgs, name)
+	}
+	sort.Strings(orgs)
+	return orgs, nil
 }
 
 // ResolveWithConfig resolves the relevant API token from the provided config.
@@ -254,5 +312,15 @@ func (o *TokenOptions) ResolveWithConfig(cfg *Config) error {
 		o.AuthToken = token
 	}
 
+	return o.resolvePolicy()
+}
+
+// resolvePolicy loads the policy of the resolved organization
+func (o *TokenOptions) resolvePolicy() error {
+	policy, err := config.LoadPolicy(o.Organization)
+	if err != nil {
+		return fmt.Errorf("failed to load organization policy: %w", err)
+	}
+	o.Policy = policy
 	return nil
 }
@@ -0,0 +1,138 @@
+package sshserver
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+// PairingCode is a one-time code for a keyboard-interactive login
+type PairingCode struct {
+	Code    string    `json:"code"`
+	Expires time.Time `json:"expires"`
+}
+
+// RateLimits is a change to the forward rate limits of a process, in bytes
+// per second. Nil leaves a direction as is; zero lifts its limit.
+type RateLimits struct {
+	Up   *int64 `json:"up,omitempty"`
+	Down *int64 `json:"down,omitempty"`
+}
+
+// Apply returns the limits up and down changed as requested
+func (r RateLimits) Apply(up, down int64) (int64, int64) {
+	if r.Up != nil {
+		up = *r.Up
+	}
+	if r.Down != nil {
+		down = *r.Down
+	}
+	return up, down
+}
+
+// RateLimiter has rate limits that can be changed while it runs, like
+// Server and AliasProxy
+type RateLimiter interface {
+	RateLimits() (up, down int64)
+	SetRateLimits(up, down int64)
+}
+
+// Dump is where a debug dump requested over the control socket was written
+type Dump struct {
+	Path string `json:"path"`
+}
+
+// ControlHandler serves the local control API used by the sessions,
+// forwards and pair commands:
+//
+//   - GET /sessions lists the SSH connections and DELETE /sessions/{id}
+//     ends one
+//   - DELETE /forwards/{id} closes one forward channel
+//   - PUT /rate-limits applies RateLimits and returns the new limits
+//   - POST /dumps writes a debug dump to dumpDir and returns its Dump
+//   - POST /pairing-codes issues a PairingCode
+//
+// It does no authentication of its own, so it must only be served on a
+// listener from ListenControl.
+func (srv *Server) ControlHandler(dumpDir string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /sessions", func(w http.ResponseWriter, r *http.Request) {
+		writeHealthJSON(w, http.StatusOK, srv.registry.connections())
+	})
+	mux.HandleFunc("DELETE /sessions/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if !srv.CloseConn(r.PathValue("id")) {
+			http.Error(w, "no such session", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("DELETE /forwards/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if !srv.CloseForward(r.PathValue("id")) {
+			http.Error(w, "no such forward", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("PUT /rate-limits", handleRateLimits(srv))
+	mux.HandleFunc("POST /dumps", func(w http.ResponseWriter, r *http.Request) {
+		path, err := srv.WriteDump(dumpDir)
+		if err != nil {
+			slog.Error("Failed to write debug dump", "exception", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		slog.Info("Wrote debug dump", "path", path)
+		writeHealthJSON(w, http.StatusCreated, Dump{Path: path})
+	})
+	mux.HandleFunc("POST /pairing-codes", func(w http.ResponseWriter, r *http.Request) {
+		code, expires := srv.NewPairingCode()
+		writeHealthJSON(w, http.StatusCreated, PairingCode{Code: code, Expires: expires})
+	})
+	return mux
+}
+
+// RateLimitHandler serves the control API of a forwarding command such as
+// open --alias, which only has PUT /rate-limits, like ControlHandler
+func RateLimitHandler(l RateLimiter) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("PUT /rate-limits", handleRateLimits(l))
+	return mux
+}
+
+// handleRateLimits applies a RateLimits request to l and answers with the
+// resulting limits
+func handleRateLimits(l RateLimiter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var change RateLimits
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&change); err != nil {
+			http.Error(w, "invalid rate limits: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if (change.Up != nil && *change.Up < 0) || (change.Down != nil && *change.Down < 0) {
+			http.Error(w, "rate limits can't be negative", http.StatusBadRequest)
+			return
+		}
+		l.SetRateLimits(change.Apply(l.RateLimits()))
+		up, down := l.RateLimits()
+		writeHealthJSON(w, http.StatusOK, RateLimits{Up: &up, Down: &down})
+	}
+}
+
+// ListenControl listens on a Unix socket at path, replacing one left by an
+// earlier run. The socket is made 0600; its directory should be private to
+// the user too, since the mode is only set once the socket exists.
+func ListenControl(path string) (net.Listener, error) {
+	os.Remove(path)
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
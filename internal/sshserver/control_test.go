@@ -0,0 +1,114 @@
+package sshserver
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestControlHandler(t *testing.T) {
+	srv, _ := startTestServer(t, &ServerConfig{})
+	dumpDir := t.TempDir()
+	h := srv.ControlHandler(dumpDir)
+
+	tests := []struct {
+		method, path, body string
+		status             int
+	}{
+		{http.MethodGet, "/sessions", "", http.StatusOK},
+		{http.MethodDelete, "/sessions/nope", "", http.StatusNotFound},
+		{http.MethodDelete, "/forwards/ssh-999", "", http.StatusNotFound},
+		{http.MethodPut, "/rate-limits", `{"up":5000}`, http.StatusOK},
+		{http.MethodPut, "/rate-limits", `{"down":-1}`, http.StatusBadRequest},
+		{http.MethodPut, "/rate-limits", `not json`, http.StatusBadRequest},
+		{http.MethodPost, "/dumps", "", http.StatusCreated},
+		{http.MethodPost, "/pairing-codes", "", http.StatusCreated},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tt.status {
+			t.Errorf("%s %s = %d, want %d (%s)", tt.method, tt.path, w.Code, tt.status, w.Body)
+		}
+	}
+
+	if up, down := srv.RateLimits(); up != 5000 || down != 0 {
+		t.Errorf("RateLimits() = %d, %d; want 5000, 0", up, down)
+	}
+	entries, err := os.ReadDir(dumpDir)
+	if err != nil || len(entries) != 1 {
+		t.Errorf("dump directory has %v (%v), want one dump", entries, err)
+	}
+}
+
+func TestRateLimitHandler(t *testing.T) {
+	p, err := NewAliasProxy(&TokenOptions{}, TLSOptions{}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.SetRateLimits(1000, 2000)
+	h := RateLimitHandler(p)
+
+	r := httptest.NewRequest(http.MethodPut, "/rate-limits", strings.NewReader(`{"down":0}`))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+
+	var got RateLimits
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Up == nil || *got.Up != 1000 || got.Down == nil || *got.Down != 0 {
+		t.Errorf("reply = %s, want up 1000 and down 0", w.Body)
+	}
+	if up, down := p.RateLimits(); up != 1000 || down != 0 {
+		t.Errorf("RateLimits() = %d, %d; want 1000, 0", up, down)
+	}
+}
+
+func TestControlCloseForward(t *testing.T) {
+	srv, addr := startTestServer(t, &ServerConfig{})
+	client := dialTestServer(t, addr, "demo", newTestSigner(t))
+
+	conn, err := client.Dial("tcp", startEchoServer(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(conn, make([]byte, 4)); err != nil {
+		t.Fatal(err)
+	}
+
+	forwards := srv.Forwards()
+	if len(forwards) != 1 {
+		t.Fatalf("Forwards() = %v, want one", forwards)
+	}
+	h := srv.ControlHandler(t.TempDir())
+	for i, want := range []int{http.StatusNoContent, http.StatusNotFound} {
+		r := httptest.NewRequest(http.MethodDelete, "/forwards/"+forwards[0].ID, nil)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != want {
+			t.Errorf("close #%d = %d, want %d (%s)", i+1, w.Code, want, w.Body)
+		}
+	}
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("read after close = %v, want EOF", err)
+	}
+	if _, _, err := client.SendRequest("keepalive@openssh.com", true, nil); err != nil {
+		t.Errorf("connection closed with the forward: %v", err)
+	}
+}
@@ -0,0 +1,142 @@
+package sshserver
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/superfly/sprites-go"
+)
+
+// credentialReloadInterval limits how often rejected tokens make the server
+// re-read credentials, so a token that stays rejected doesn't have every
+// connection attempt read the config and keyring
+var credentialReloadInterval = 10 * time.Second
+
+// tokenRejected reports whether err is the sprites API refusing the token
+func tokenRejected(err error) bool {
+	var apiErr *sprites.APIError
+	return errors.As(err, &apiErr) &&
+		(apiErr.StatusCode == http.StatusUnauthorized || apiErr.StatusCode == http.StatusForbidden)
+}
+
+// credentials tracks reloads of the organizations' tokens. gen counts
+// reloads that changed a token, so callers can tell whether one happened
+// since they last looked.
+type credentials struct {
+	mu         sync.Mutex
+	lastReload time.Time
+	gen        atomic.Uint64
+}
+
+// ReloadCredentials re-resolves the token of every organization served
+// from the sprites CLI config and keyring, as after "sprite login", and
+// replaces the API clients of those whose token changed. New connections
+// use the new tokens; existing ones switch when the API rejects their old
+// one. It returns how many organizations changed.
+func (srv *Server) ReloadCredentials(ctx context.Context, trigger string) int {
+	srv.creds.mu.Lock()
+	defer srv.creds.mu.Unlock()
+	return srv.reloadCredentials(ctx, trigger)
+}
+
+func (srv *Server) reloadCredentials(ctx context.Context, trigger string) int {
+	srv.creds.lastReload = time.Now()
+	changed, err := srv.orgs.reload()
+	if err != nil {
+		logger(ctx).WarnContext(ctx, "Failed to reload sprites credentials",
+			"trigger", trigger,
+			"exception", err)
+	}
+	if len(changed) == 0 {
+		logger(ctx).InfoContext(ctx, "Sprites credentials unchanged", "trigger", trigger)
+		return 0
+	}
+	srv.creds.gen.Add(1)
+	srv.wakes.forgetDone()
+	logger(ctx).InfoContext(ctx, "Reloaded sprites credentials",
+		"trigger", trigger,
+		"orgs", changed)
+	return len(changed)
+}
+
+// refreshCredentials is called when the API rejected a token the caller
+// got at credentials generation seen. It reports whether the tokens
+// changed since, reloading them unless that was done moments ago.
+func (srv *Server) refreshCredentials(ctx context.Context, seen uint64) bool {
+	srv.creds.mu.Lock()
+	defer srv.creds.mu.Unlock()
+
+	if srv.creds.gen.Load() != seen {
+		return true
+	}
+	if time.Since(srv.creds.lastReload) < credentialReloadInterval {
+		return false
+	}
+	return srv.reloadCredentials(ctx, "token rejected") > 0
+}
+
+// reload re-resolves the token of every organization resolved so far,
+// replacing the routes whose token changed. It returns the organizations
+// that changed, and the first error resolving one.
+func (r *orgRouter) reload() ([]string, error) {
+	r.mu.Lock()
+	routes := make(map[string]*orgRoute, len(r.routes))
+	for org, route := range r.routes {
+		routes[org] = route
+	}
+	r.mu.Unlock()
+
+	var changed []string
+	var firstErr error
+	for org, route := range routes {
+		tokens := &TokenOptions{API: route.apiURL, Organization: org}
+		if err := tokens.Resolve(); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if tokens.AuthToken == route.authToken {
+			continue
+		}
+
+		fresh := newOrgRoute(tokens, r.tlsConfig, r.proxy)
+		r.mu.Lock()
+		r.routes[org] = fresh
+		if r.def == route {
+			r.def = fresh
+		}
+		r.mu.Unlock()
+		if org == "" {
+			org = "default"
+		}
+		changed = append(changed, org)
+	}
+	return changed, firstErr
+}
+
+// refreshSprite switches the session to the sprite's current credentials
+// after the API rejected its token, reporting whether it could
+func (s *session) refreshSprite(ctx context.Context) bool {
+	srv := s.conn.srv
+	if !srv.refreshCredentials(ctx, s.conn.credGen) {
+		return false
+	}
+	route, err := srv.orgs.route(s.conn.org)
+	if err != nil {
+		logger(ctx).WarnContext(ctx, "Failed to switch session to reloaded credentials", "exception", err)
+		return false
+	}
+	sprite, err := route.client.GetSprite(ctx, s.sprite.Load().Name())
+	if err != nil {
+		logger(ctx).WarnContext(ctx, "Failed to switch session to reloaded credentials", "exception", err)
+		return false
+	}
+	s.sprite.Store(sprite)
+	logger(ctx).InfoContext(ctx, "Session switched to reloaded credentials")
+	return true
+}
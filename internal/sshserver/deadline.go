@@ -0,0 +1,40 @@
+package sshserver
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// writeDeadlineConn fails writes the client doesn't take within timeout.
+// Without it, a write to a client that vanished mid-transfer blocks while
+// the kernel retransmits, which takes minutes. Reads get no deadline, so
+// idle sessions are left to the keepalive loop.
+type writeDeadlineConn struct {
+	net.Conn
+	timeout time.Duration
+	log     atomic.Pointer[slog.Logger] // The connection's, once it has one
+}
+
+func (c *writeDeadlineConn) Write(p []byte) (int, error) {
+	// Pipes that can't time out, like a blocking stdout, are written
+	// without a deadline
+	err := c.Conn.SetWriteDeadline(time.Now().Add(c.timeout))
+	if err != nil && !errors.Is(err, os.ErrNoDeadline) {
+		return 0, err
+	}
+	n, err := c.Conn.Write(p)
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		l := c.log.Load()
+		if l == nil {
+			l = slog.Default()
+		}
+		l.Info("SSH client stopped taking data, closing connection",
+			"conn.addr", c.RemoteAddr().String(),
+			"timeout", c.timeout)
+	}
+	return n, err
+}
@@ -0,0 +1,36 @@
+package sshserver
+
+import (
+	"fmt"
+	"strings"
+)
+
+// buildDefaultEnv returns the environment every session starts with: the
+// built-in defaults (unless noBuiltin) with each KEY=VALUE in extra
+// replacing the entry for its key or adding to them
+func buildDefaultEnv(extra []string, noBuiltin bool) ([]string, error) {
+	var env []string
+	if !noBuiltin {
+		env = append(env, defaultSessionEnv...)
+	}
+	for _, kv := range extra {
+		name, _, ok := strings.Cut(kv, "=")
+		if !ok || name == "" || strings.ContainsAny(name, " \t") {
+			return nil, fmt.Errorf("invalid default environment variable %q (expected KEY=VALUE)", kv)
+		}
+		env = setEnvVar(env, kv)
+	}
+	return env, nil
+}
+
+// setEnvVar replaces the entry for kv's key in env, or appends kv
+func setEnvVar(env []string, kv string) []string {
+	name, _, _ := strings.Cut(kv, "=")
+	for i, existing := range env {
+		if strings.HasPrefix(existing, name+"=") {
+			env[i] = kv
+			return env
+		}
+	}
+	return append(env, kv)
+}
@@ -0,0 +1,93 @@
+package sshserver
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestSessionEnv checks the variables commands on the sprite see, through
+// the shell and as file transfer endpoints run without it
+func TestSessionEnv(t *testing.T) {
+	// Only the session may set these; the fake sprite runs commands with
+	// the test's own environment
+	for _, name := range []string{"LANG", "LC_ALL", envBootstrap, envBootstrapTool} {
+		t.Setenv(name, "")
+		os.Unsetenv(name)
+	}
+	// An rsync endpoint that prints its environment instead of syncing
+	bin := t.TempDir()
+	if err := os.WriteFile(filepath.Join(bin, "rsync"), []byte("#!/bin/sh\nenv\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	tests := []struct {
+		name      string
+		noBuiltin bool
+		setEnv    map[string]string // Sent with env requests
+		command   string
+		want      map[string]string // "" means unset
+	}{
+		{
+			name:    "shell command",
+			command: "env",
+			want:    map[string]string{envBootstrap: "1", envBootstrapTool: "", "LANG": "en_US.UTF-8"},
+		},
+		{
+			name:    "tool sent by the client",
+			setEnv:  map[string]string{envBootstrapTool: "vscode"},
+			command: "env",
+			want:    map[string]string{envBootstrap: "1", envBootstrapTool: "vscode"},
+		},
+		{
+			name:      "without built-in defaults",
+			noBuiltin: true,
+			command:   "env",
+			want:      map[string]string{envBootstrap: "1", "LANG": ""},
+		},
+		{
+			name:    "file transfer endpoint",
+			setEnv:  map[string]string{envBootstrapTool: "zed"},
+			command: "rsync --server -vlogDtpre.iLsfxC . /tmp/dest",
+			want:    map[string]string{envBootstrap: "1", envBootstrapTool: "zed", "LANG": ""},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, addr := startTestServer(t, &ServerConfig{NoBuiltinEnv: tt.noBuiltin})
+			client := dialTestServer(t, addr, "demo", newTestSigner(t))
+			session, err := client.NewSession()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer session.Close()
+			for name, value := range tt.setEnv {
+				if err := session.Setenv(name, value); err != nil {
+					t.Fatalf("env %s: %v", name, err)
+				}
+			}
+			out, err := session.CombinedOutput(tt.command)
+			if err != nil {
+				t.Fatalf("%s: %v: %s", tt.command, err, out)
+			}
+
+			env := make(map[string]string)
+			for _, line := range strings.Split(string(out), "\n") {
+				if name, value, ok := strings.Cut(line, "="); ok {
+					env[name] = value
+				}
+			}
+			for name, want := range tt.want {
+				got, ok := env[name]
+				switch {
+				case want == "" && ok:
+					t.Errorf("%s=%s, want it unset", name, got)
+				case want != "" && got != want:
+					t.Errorf("%s=%q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
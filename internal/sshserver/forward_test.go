@@ -0,0 +1,343 @@
+package sshserver
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// startEOFEchoServer listens on a loopback port and answers each
+// connection with everything it sent, only once it has seen EOF
+func startEOFEchoServer(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				data, err := io.ReadAll(c)
+				if err != nil {
+					return
+				}
+				c.Write(data)
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+// TestForwardHalfClose closes the write side of direct-tcpip forwards and
+// reads the response the destination sends after seeing EOF
+func TestForwardHalfClose(t *testing.T) {
+	_, addr := startTestServer(t, &ServerConfig{})
+	client := dialTestServer(t, addr, "demo", newTestSigner(t))
+	dest := startEOFEchoServer(t)
+
+	tests := []struct {
+		name string
+		size int
+	}{
+		{"nothing sent", 0},
+		{"one line", len("hello\n")},
+		{"one buffer", 32 * 1024},
+		{"many buffers", 1 << 20},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload := bytes.Repeat([]byte("0123456789abcdef"), tt.size/16+1)[:tt.size]
+
+			conn, err := client.Dial("tcp", dest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			hc, ok := conn.(interface{ CloseWrite() error })
+			if !ok {
+				t.Fatalf("%T can't half-close", conn)
+			}
+
+			// Written concurrently: the destination doesn't read all of a
+			// large payload until it is sent in full
+			written := make(chan error, 1)
+			go func() {
+				if _, err := conn.Write(payload); err != nil {
+					written <- err
+					return
+				}
+				written <- hc.CloseWrite()
+			}()
+
+			got := make(chan []byte, 1)
+			go func() {
+				data, _ := io.ReadAll(conn)
+				got <- data
+			}()
+			select {
+			case data := <-got:
+				if err := <-written; err != nil {
+					t.Fatalf("write and half-close: %v", err)
+				}
+				if !bytes.Equal(data, payload) {
+					t.Errorf("read %d bytes after EOF, want the %d sent", len(data), len(payload))
+				}
+			case <-time.After(10 * time.Second):
+				t.Fatal("no response and EOF after half-closing the forward")
+			}
+		})
+	}
+}
+
+// startSilentServer listens on a loopback port, reading and discarding
+// what each connection sends and never answering or closing, even after
+// EOF, like a hung remote
+func startSilentServer(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	t.Cleanup(func() {
+		close(done)
+		l.Close()
+	})
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				io.Copy(io.Discard, c)
+				<-done
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+// TestForwardIdleTimeout checks that forwards to a silent destination are
+// closed once idle, and only then
+func TestForwardIdleTimeout(t *testing.T) {
+	tests := []struct {
+		name    string
+		idle    time.Duration
+		traffic time.Duration // How long the client keeps writing
+		closed  bool
+	}{
+		{"disabled", 0, 0, false},
+		{"idle", 200 * time.Millisecond, 0, true},
+		{"kept open by traffic", 200 * time.Millisecond, 600 * time.Millisecond, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, addr := startTestServer(t, &ServerConfig{ForwardIdleTimeout: tt.idle})
+			client := dialTestServer(t, addr, "demo", newTestSigner(t))
+			conn, err := client.Dial("tcp", startSilentServer(t))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			eof := make(chan struct{})
+			go func() {
+				io.Copy(io.Discard, conn)
+				close(eof)
+			}()
+
+			start := time.Now()
+			for time.Since(start) < tt.traffic {
+				if _, err := conn.Write([]byte("ping")); err != nil {
+					t.Fatalf("forward closed after %v while in use: %v", time.Since(start), err)
+				}
+				time.Sleep(tt.idle / 4)
+			}
+			select {
+			case <-eof:
+				if time.Since(start) < tt.traffic {
+					t.Fatalf("forward closed after %v while in use", time.Since(start))
+				}
+			default:
+			}
+
+			wait := time.Second
+			if tt.closed {
+				wait = 10 * time.Second
+			}
+			select {
+			case <-eof:
+				if !tt.closed {
+					t.Fatal("forward closed without an idle timeout")
+				}
+			case <-time.After(wait):
+				if tt.closed {
+					t.Fatalf("forward still open %v after its last traffic", wait)
+				}
+			}
+		})
+	}
+}
+
+// TestForwardGoroutinesOnDisconnect kills a connection with forwards to a
+// hung destination open and checks that every goroutine they started exits
+func TestForwardGoroutinesOnDisconnect(t *testing.T) {
+	tests := []struct {
+		name     string
+		forwards int
+	}{
+		{"one forward", 1},
+		{"many forwards", 8},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, addr := startTestServer(t, &ServerConfig{WarmProxies: -1})
+			dest := startSilentServer(t)
+			before := serverGoroutines()
+
+			raw, err := net.Dial("tcp", addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer raw.Close()
+			sshConn, chans, reqs, err := ssh.NewClientConn(raw, addr, &ssh.ClientConfig{
+				User:            "demo",
+				Auth:            []ssh.AuthMethod{ssh.PublicKeys(newTestSigner(t))},
+				HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+				Timeout:         10 * time.Second,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			client := ssh.NewClient(sshConn, chans, reqs)
+			for i := 0; i < tt.forwards; i++ {
+				conn, err := client.Dial("tcp", dest)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err := conn.Write([]byte("ping")); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := srv.registry.forwards.Load(); got != int64(tt.forwards) {
+				t.Fatalf("%d forwards open, want %d", got, tt.forwards)
+			}
+			if during := serverGoroutines(); during <= before {
+				t.Fatalf("%d server goroutines with forwards open, no more than the %d before", during, before)
+			}
+
+			// Dropped without closing the SSH connection or its channels
+			raw.Close()
+
+			deadline := time.Now().Add(5 * time.Second)
+			for serverGoroutines() > before || srv.registry.forwards.Load() != 0 {
+				if time.Now().After(deadline) {
+					t.Fatalf("%d server goroutines and %d forwards left after the connection died, had %d goroutines",
+						serverGoroutines(), srv.registry.forwards.Load(), before)
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		})
+	}
+}
+
+// TestManyForwardsBoundedMemory opens 200 forwards at once, as a chatty
+// microservices setup would, and checks the memory they hold is bounded by
+// their buffers, the next one is refused, and the memory is returned once
+// they close
+func TestManyForwardsBoundedMemory(t *testing.T) {
+	if testing.Short() {
+		t.Skip("load test")
+	}
+	const forwards = 200
+	srv, addr := startTestServer(t, &ServerConfig{MaxForwards: forwards})
+	client := dialTestServer(t, addr, "demo", newTestSigner(t))
+	dest := startEchoServer(t)
+
+	// heap returns the live heap after collections that also empty the
+	// buffer pool, as a server left alone for a while would
+	heap := func() uint64 {
+		runtime.GC()
+		runtime.GC()
+		var m runtime.MemStats
+		runtime.ReadMemStats(&m)
+		return m.HeapAlloc
+	}
+	before := heap()
+
+	// Every forward moves enough data to fill its buffers
+	payload := bytes.Repeat([]byte("0123456789abcdef"), 64<<10/16)
+	echo := make([]byte, len(payload))
+	conns := make([]net.Conn, 0, forwards)
+	defer func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+	for i := range forwards {
+		conn, err := client.Dial("tcp", dest)
+		if err != nil {
+			t.Fatalf("forward %d: %v", i, err)
+		}
+		conns = append(conns, conn)
+		go conn.Write(payload)
+		if _, err := io.ReadFull(conn, echo); err != nil {
+			t.Fatalf("forward %d: %v", i, err)
+		}
+	}
+
+	_, err := client.Dial("tcp", dest)
+	var openErr *ssh.OpenChannelError
+	if !errors.As(err, &openErr) || openErr.Reason != ssh.ResourceShortage {
+		t.Errorf("forward over the limit: %v, want it refused with ResourceShortage", err)
+	}
+
+	snap := srv.Snapshot()
+	if len(snap.Forwards) != forwards {
+		t.Errorf("snapshot lists %d forwards, want %d", len(snap.Forwards), forwards)
+	}
+	if got := snap.Memory.Attribution["websocket_buffers"]; got < forwards*2*defaultWSBufferSize {
+		t.Errorf("websocket buffers attributed %d bytes, want at least %d", got, forwards*2*defaultWSBufferSize)
+	}
+	// Both ends of each proxy WebSocket live in this process, with the
+	// fake sprite's
+	perForward := (int64(heap()) - int64(before)) / forwards
+	t.Logf("%d KiB of heap per forward", perForward>>10)
+	if perForward > 1<<20 {
+		t.Errorf("%d KiB of heap per forward, want at most 1 MiB", perForward>>10)
+	}
+
+	for _, conn := range conns {
+		conn.Close()
+	}
+	conns = nil
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		snap := srv.Snapshot()
+		if len(snap.Forwards) == 0 && snap.Memory.Attribution["copy_buffers"] == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d forwards and %d bytes of copy buffers left after closing them all",
+				len(snap.Forwards), snap.Memory.Attribution["copy_buffers"])
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if after := heap(); after > before+8<<20 {
+		t.Errorf("heap is %d KiB after closing the forwards, %d KiB before", after>>10, before>>10)
+	}
+}
@@ -0,0 +1,254 @@
+package sshserver
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/superfly/sprites-go"
+)
+
+// apiProbeInterval is how often the health listener checks the sprites API
+// when no lookups have called it
+const apiProbeInterval = time.Minute
+
+// defaultHealthFailures is how many API calls in a row must fail before
+// /healthz reports the server as degraded
+const defaultHealthFailures = 3
+
+// apiHealth tracks the outcome of recent sprites API calls
+type apiHealth struct {
+	mu            sync.Mutex
+	lastCall      time.Time
+	lastSuccess   time.Time
+	failures      int // Consecutive failed calls
+	lastError     string
+	tokenRejected bool
+}
+
+// record notes the outcome of an API call. Errors that come from the API
+// answering (e.g. an unknown sprite) count as successes, and errors raised
+// before any call was made are ignored.
+func (h *apiHealth) record(err error) {
+	rejected := tokenRejected(err)
+
+	switch {
+	case errors.Is(err, errOrgNotServed), errors.Is(err, errOrgCredentials),
+		errors.Is(err, context.Canceled):
+		return
+	case err == nil, errors.Is(err, errAmbiguousSprite),
+		strings.Contains(err.Error(), "sprite not found"):
+		err = nil
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lastCall = time.Now()
+	if err == nil {
+		h.lastSuccess = h.lastCall
+		h.failures = 0
+		h.lastError = ""
+		h.tokenRejected = false
+		return
+	}
+	h.failures++
+	h.lastError = err.Error()
+	h.tokenRejected = rejected
+}
+
+// HealthStatus is the server's view of its connection to the sprites API
+type HealthStatus struct {
+	Healthy             bool       `json:"healthy"`
+	Reason              string     `json:"reason,omitempty"`
+	LastAPISuccess      *time.Time `json:"last_api_success,omitempty"`
+	ConsecutiveFailures int        `json:"consecutive_api_failures"`
+	TokenRejected       bool       `json:"token_rejected"`
+}
+
+// Health reports whether the server can reach the sprites API. It is
+// unhealthy once maxFailures calls in a row fail (0 for the default), or
+// as soon as the API rejects the token.
+func (srv *Server) Health(maxFailures int) HealthStatus {
+	if maxFailures <= 0 {
+		maxFailures = defaultHealthFailures
+	}
+
+	h := &srv.health
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	status := HealthStatus{
+		Healthy:             true,
+		ConsecutiveFailures: h.failures,
+		TokenRejected:       h.tokenRejected,
+	}
+	if !h.lastSuccess.IsZero() {
+		last := h.lastSuccess
+		status.LastAPISuccess = &last
+	}
+	switch {
+	case h.tokenRejected:
+		status.Healthy = false
+		status.Reason = "the sprites API rejected the token; run \"sprite login\""
+	case h.failures >= maxFailures:
+		status.Healthy = false
+		status.Reason = "the last sprites API calls failed: " + h.lastError
+	}
+	return status
+}
+
+// ProbeAPI checks the sprites API with a cheap call when nothing else has
+// called it for a while, until ctx ends. Without it an idle server would
+// report a stale health.
+func (srv *Server) ProbeAPI(ctx context.Context) {
+	ticker := time.NewTicker(apiProbeInterval)
+	defer ticker.Stop()
+
+	for {
+		srv.health.mu.Lock()
+		idle := time.Since(srv.health.lastCall) >= apiProbeInterval
+		srv.health.mu.Unlock()
+
+		if idle {
+			gen := srv.creds.gen.Load()
+			probeCtx, cancel := context.WithTimeout(ctx, lookupTimeout)
+			_, err := srv.orgs.defRoute().client.ListSprites(probeCtx, &sprites.ListOptions{MaxResults: 1})
+			if tokenRejected(err) && srv.refreshCredentials(ctx, gen) {
+				_, err = srv.orgs.defRoute().client.ListSprites(probeCtx, &sprites.ListOptions{MaxResults: 1})
+			}
+			cancel()
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				slog.Debug("Sprites API probe failed", "exception", err)
+			}
+			srv.health.record(err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// HealthOptions configures the health listener's handler
+type HealthOptions struct {
+	Version string
+	Started time.Time
+	Listen  []string
+
+	// MaxFailures is passed to Health
+	MaxFailures int
+
+	// Secret, when set, must be sent as "Authorization: Bearer <secret>"
+	Secret string
+}
+
+// Info is the server description returned by /info
+type Info struct {
+	Version     string       `json:"version"`
+	Started     time.Time    `json:"started"`
+	Uptime      string       `json:"uptime"`
+	Listen      []string     `json:"listen"`
+	Org         string       `json:"org"`
+	TokenExpiry *time.Time   `json:"token_expires"` // Null unless the token is a JWT with an expiry
+	SearchOrgs  []string     `json:"search_orgs,omitempty"`
+	Sprites     int          `json:"sprites"` // Sprites with an SSH connection
+	Connections int          `json:"connections"`
+	Sessions    int64        `json:"sessions"`
+	Health      HealthStatus `json:"health"`
+}
+
+// HealthHandler serves /healthz, which answers 503 while the server is
+// unhealthy, and /info, for reverse proxies and uptime monitors
+func (srv *Server) HealthHandler(opts HealthOptions) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		status := srv.Health(opts.MaxFailures)
+		code := http.StatusOK
+		if !status.Healthy {
+			code = http.StatusServiceUnavailable
+		}
+		writeHealthJSON(w, code, status)
+	})
+	mux.HandleFunc("GET /info", func(w http.ResponseWriter, r *http.Request) {
+		writeHealthJSON(w, http.StatusOK, srv.info(opts))
+	})
+
+	if opts.Secret == "" {
+		return mux
+	}
+	want := []byte("Bearer " + opts.Secret)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// info describes the server for /info
+func (srv *Server) info(opts HealthOptions) Info {
+	def := srv.orgs.defRoute()
+	info := Info{
+		Version:     opts.Version,
+		Started:     opts.Started,
+		Uptime:      time.Since(opts.Started).Round(time.Second).String(),
+		Listen:      opts.Listen,
+		Org:         def.org,
+		TokenExpiry: tokenExpiry(def.authToken),
+		SearchOrgs:  srv.orgs.search,
+		Health:      srv.Health(opts.MaxFailures),
+	}
+
+	seen := make(map[string]bool)
+	for _, c := range srv.registry.connections() {
+		info.Connections++
+		info.Sessions += c.Sessions
+		if !seen[c.Sprite] {
+			seen[c.Sprite] = true
+			info.Sprites++
+		}
+	}
+	return info
+}
+
+// tokenExpiry returns the exp claim of token when it is a JWT, or nil. The
+// signature isn't checked, as the API does that; this only lets a monitor
+// see the expiry coming.
+func tokenExpiry(token string) *time.Time {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return nil
+	}
+	var claims struct {
+		Exp *float64 `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Exp == nil {
+		return nil
+	}
+	exp := time.Unix(int64(*claims.Exp), 0).UTC()
+	return &exp
+}
+
+func writeHealthJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
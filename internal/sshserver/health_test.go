@@ -0,0 +1,136 @@
+package sshserver
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// testJWT returns an unsigned JWT with claims
+func testJWT(claims string) string {
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`)) + "." +
+		enc.EncodeToString([]byte(claims)) + "." + enc.EncodeToString([]byte("signature"))
+}
+
+func TestTokenExpiry(t *testing.T) {
+	exp := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name  string
+		token string
+		want  *time.Time
+	}{
+		{"JWT", testJWT(`{"sub":"me","exp":1893553445}`), &exp},
+		{"JWT without exp", testJWT(`{"sub":"me"}`), nil},
+		{"JWT with a string exp", testJWT(`{"exp":"soon"}`), nil},
+		{"opaque token", "org/1234/abcd/efgh", nil},
+		{"payload not base64", "a.!!!.c", nil},
+		{"payload not JSON", "a." + base64.RawURLEncoding.EncodeToString([]byte("exp")) + ".c", nil},
+		{"empty", "", nil},
+	}
+	for _, tt := range tests {
+		got := tokenExpiry(tt.token)
+		switch {
+		case tt.want == nil && got != nil:
+			t.Errorf("%s: expiry = %v, want none", tt.name, got)
+		case tt.want != nil && (got == nil || !got.Equal(*tt.want)):
+			t.Errorf("%s: expiry = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// getHealth fetches path from h and decodes its JSON body into v
+func getHealth(t *testing.T, h http.Handler, path, secret string, v any) int {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	if secret != "" {
+		req.Header.Set("Authorization", "Bearer "+secret)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized && v != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+			t.Fatalf("%s: %v: %s", path, err, rec.Body)
+		}
+	}
+	return rec.Code
+}
+
+// TestInfoTokenExpiry checks /info reports the expiry of a JWT and null
+// for a token it can't read one from
+func TestInfoTokenExpiry(t *testing.T) {
+	exp := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name  string
+		token string
+		want  string // token_expires as JSON
+	}{
+		{"JWT", testJWT(`{"exp":1893553445}`), `"` + exp.Format(time.RFC3339) + `"`},
+		{"opaque token", "test", "null"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens := testTokenOptions(t, newFakeAPI(t))
+			tokens.AuthToken = tt.token
+			srv := newTestServer(t, &ServerConfig{TokenOptions: tokens})
+			h := srv.HealthHandler(HealthOptions{Version: "1.0", Started: time.Now()})
+
+			var info map[string]json.RawMessage
+			if code := getHealth(t, h, "/info", "", &info); code != http.StatusOK {
+				t.Fatalf("/info answered %d", code)
+			}
+			got, ok := info["token_expires"]
+			if !ok {
+				t.Fatal("/info has no token_expires")
+			}
+			if string(got) != tt.want {
+				t.Errorf("token_expires = %s, want %s", got, tt.want)
+			}
+			if string(info["org"]) != `"fake"` {
+				t.Errorf("org = %s, want \"fake\"", info["org"])
+			}
+		})
+	}
+}
+
+// TestHealthz checks /healthz turns unhealthy after repeated API failures
+// or a rejected token, and that a secret guards both endpoints
+func TestHealthz(t *testing.T) {
+	srv := newTestServer(t, &ServerConfig{})
+	h := srv.HealthHandler(HealthOptions{Started: time.Now(), MaxFailures: 2})
+
+	var status HealthStatus
+	if code := getHealth(t, h, "/healthz", "", &status); code != http.StatusOK || !status.Healthy {
+		t.Fatalf("fresh server: %d %+v, want healthy", code, status)
+	}
+
+	srv.health.record(errors.New("connection refused"))
+	if code := getHealth(t, h, "/healthz", "", &status); code != http.StatusOK {
+		t.Errorf("after one failure: %d, want still healthy", code)
+	}
+	srv.health.record(errors.New("connection refused"))
+	if code := getHealth(t, h, "/healthz", "", &status); code != http.StatusServiceUnavailable || status.ConsecutiveFailures != 2 {
+		t.Errorf("after two failures: %d %+v, want 503", code, status)
+	}
+	srv.health.record(nil)
+	if code := getHealth(t, h, "/healthz", "", &status); code != http.StatusOK || status.LastAPISuccess == nil {
+		t.Errorf("after a success: %d %+v, want healthy", code, status)
+	}
+
+	guarded := srv.HealthHandler(HealthOptions{Started: time.Now(), Secret: "s3cret"})
+	for _, path := range []string{"/healthz", "/info"} {
+		if code := getHealth(t, guarded, path, "", nil); code != http.StatusUnauthorized {
+			t.Errorf("%s without the secret: %d, want 401", path, code)
+		}
+		if code := getHealth(t, guarded, path, "wrong", nil); code != http.StatusUnauthorized {
+			t.Errorf("%s with a wrong secret: %d, want 401", path, code)
+		}
+		if code := getHealth(t, guarded, path, "s3cret", nil); code != http.StatusOK {
+			t.Errorf("%s with the secret: %d, want 200", path, code)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package sshserver
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// OpenSSH host key rotation extension (PROTOCOL, section 2.5). After
+// authentication the server announces all of its host keys; clients with
+// UpdateHostKeys enabled ask it to prove ownership of the ones they don't
+// know yet and then add them to known_hosts.
+const (
+	hostKeysRequest      = "hostkeys-00@openssh.com"
+	hostKeysProveRequest = "hostkeys-prove-00@openssh.com"
+)
+
+// announceHostKeys sends every host key to the client. Clients without
+// support for the extension ignore the request.
+func (c *sshConn) announceHostKeys(ctx context.Context) {
+	var payload []byte
+	for _, key := range c.hostKeys {
+		payload = appendString(payload, key.PublicKey().Marshal())
+	}
+	if _, _, err := c.conn.SendRequest(hostKeysRequest, false, payload); err != nil {
+		logger(ctx).DebugContext(ctx, "Failed to announce host keys", "exception", err)
+	}
+}
+
+// proveHostKeys answers a hostkeys-prove request with a signature by each
+// requested key over the session identifier
+func (c *sshConn) proveHostKeys(payload []byte) ([]byte, error) {
+	byBlob := make(map[string]ssh.Signer, len(c.hostKeys))
+	for _, key := range c.hostKeys {
+		byBlob[string(key.PublicKey().Marshal())] = key
+	}
+
+	var reply []byte
+	for len(payload) > 0 {
+		blob, rest, ok := readString(payload)
+		if !ok {
+			return nil, errors.New("malformed hostkeys-prove request")
+		}
+		payload = rest
+
+		signer, ok := byBlob[string(blob)]
+		if !ok {
+			return nil, errors.New("hostkeys-prove request for a key we don't have")
+		}
+
+		var data []byte
+		data = appendString(data, []byte(hostKeysProveRequest))
+		data = appendString(data, c.conn.SessionID())
+		data = appendString(data, blob)
+
+		sig, err := signHostKeyProof(signer, data)
+		if err != nil {
+			return nil, fmt.Errorf("sign host key proof: %w", err)
+		}
+		reply = appendString(reply, ssh.Marshal(sig))
+	}
+	return reply, nil
+}
+
+// signHostKeyProof signs with the key's default algorithm, using SHA-512
+// for RSA keys as OpenSSH expects
+func signHostKeyProof(signer ssh.Signer, data []byte) (*ssh.Signature, error) {
+	if as, ok := signer.(ssh.AlgorithmSigner); ok && signer.PublicKey().Type() == ssh.KeyAlgoRSA {
+		return as.SignWithAlgorithm(rand.Reader, data, ssh.KeyAlgoRSASHA512)
+	}
+	return signer.Sign(rand.Reader, data)
+}
+
+// appendString appends an SSH wire-format string
+func appendString(buf, s []byte) []byte {
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(s)))
+	return append(buf, s...)
+}
+
+// readString reads an SSH wire-format string
+func readString(buf []byte) (s, rest []byte, ok bool) {
+	if len(buf) < 4 {
+		return nil, nil, false
+	}
+	n := binary.BigEndian.Uint32(buf)
+	buf = buf[4:]
+	if uint32(len(buf)) < n {
+		return nil, nil, false
+	}
+	return buf[:n], buf[n:], true
+}
+
+// sameKey reports whether two signers hold the same public key
+func sameKey(a, b ssh.Signer) bool {
+	return bytes.Equal(a.PublicKey().Marshal(), b.PublicKey().Marshal())
+}
@@ -0,0 +1,183 @@
+package sshserver
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/pem"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// newECDSASigner returns a host key of a different type than
+// newTestSigner's, as a rotation would typically bring
+func newECDSASigner(t *testing.T) ssh.Signer {
+	t.Helper()
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+// TestHostKeysProve asks the server to prove ownership of its host keys
+// the way OpenSSH does and checks the signatures
+func TestHostKeysProve(t *testing.T) {
+	current, next := newTestSigner(t), newECDSASigner(t)
+	_, addr := startTestServer(t, &ServerConfig{HostKey: current, ExtraHostKeys: []ssh.Signer{next}})
+
+	nc, err := net.DialTimeout("tcp", addr, 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, chans, reqs, err := ssh.NewClientConn(nc, addr, &ssh.ClientConfig{
+		User:            "demo",
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(newTestSigner(t))},
+		HostKeyCallback: ssh.FixedHostKey(current.PublicKey()),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go ssh.DiscardRequests(nil)
+	go func() {
+		for ch := range chans {
+			ch.Reject(ssh.Prohibited, "")
+		}
+	}()
+
+	// The announcement lists every host key
+	var announced []string
+	select {
+	case req := <-reqs:
+		if req.Type != hostKeysRequest {
+			t.Fatalf("first global request is %s, want %s", req.Type, hostKeysRequest)
+		}
+		for payload := req.Payload; len(payload) > 0; {
+			blob, rest, ok := readString(payload)
+			if !ok {
+				t.Fatal("malformed announcement")
+			}
+			announced = append(announced, string(blob))
+			payload = rest
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("no host keys announced")
+	}
+	go ssh.DiscardRequests(reqs)
+	want := []string{string(current.PublicKey().Marshal()), string(next.PublicKey().Marshal())}
+	if strings.Join(announced, "\x00") != strings.Join(want, "\x00") {
+		t.Fatalf("announced %d keys, want the current and the extra one", len(announced))
+	}
+
+	// Proofs are signatures over the request name, session and key
+	blob := next.PublicKey().Marshal()
+	ok, reply, err := conn.SendRequest(hostKeysProveRequest, true, appendString(nil, blob))
+	if err != nil || !ok {
+		t.Fatalf("prove request: ok %v, %v", ok, err)
+	}
+	sigBlob, rest, valid := readString(reply)
+	if !valid || len(rest) != 0 {
+		t.Fatalf("reply holds more or less than one signature")
+	}
+	var sig ssh.Signature
+	if err := ssh.Unmarshal(sigBlob, &sig); err != nil {
+		t.Fatal(err)
+	}
+	var data []byte
+	data = appendString(data, []byte(hostKeysProveRequest))
+	data = appendString(data, conn.SessionID())
+	data = appendString(data, blob)
+	if err := next.PublicKey().Verify(data, &sig); err != nil {
+		t.Errorf("proof doesn't verify: %v", err)
+	}
+
+	// Keys the server doesn't hold and garbage are refused
+	stranger := newTestSigner(t).PublicKey().Marshal()
+	if ok, _, err := conn.SendRequest(hostKeysProveRequest, true, appendString(nil, stranger)); err != nil || ok {
+		t.Errorf("prove request for an unknown key: ok %v, %v; want refused", ok, err)
+	}
+	if ok, _, err := conn.SendRequest(hostKeysProveRequest, true, []byte{0, 0, 0, 9, 1}); err != nil || ok {
+		t.Errorf("malformed prove request: ok %v, %v; want refused", ok, err)
+	}
+}
+
+// TestHostKeysOpenSSH connects with the OpenSSH client, which only knows
+// the current host key, and checks UpdateHostKeys adds the next one to
+// known_hosts
+func TestHostKeysOpenSSH(t *testing.T) {
+	sshPath, err := exec.LookPath("ssh")
+	if err != nil {
+		t.Skip("ssh not installed")
+	}
+	current, next := newTestSigner(t), newECDSASigner(t)
+	_, addr := startTestServer(t, &ServerConfig{HostKey: current, ExtraHostKeys: []ssh.Signer{next}})
+	host, port, _ := net.SplitHostPort(addr)
+
+	dir := t.TempDir()
+	_, userKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKey(userKey, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	identity := filepath.Join(dir, "id_ed25519")
+	if err := os.WriteFile(identity, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	knownHosts := filepath.Join(dir, "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(addr)}, current.PublicKey())
+	if err := os.WriteFile(knownHosts, []byte(line+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(sshPath, "-F", "/dev/null",
+		"-o", "UserKnownHostsFile="+knownHosts,
+		"-o", "GlobalKnownHostsFile=/dev/null",
+		"-o", "StrictHostKeyChecking=yes",
+		"-o", "UpdateHostKeys=yes",
+		"-o", "BatchMode=yes",
+		"-o", "IdentitiesOnly=yes",
+		"-i", identity,
+		"-p", port, "demo@"+host,
+		// Long enough for the client to finish the exchange
+		"sleep 1")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("ssh: %v: %s", err, out)
+	}
+
+	data, err := os.ReadFile(knownHosts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for rest := data; len(rest) > 0; {
+		_, _, key, _, more, err := ssh.ParseKnownHosts(rest)
+		if err != nil {
+			break
+		}
+		keys = append(keys, string(key.Marshal()))
+		rest = more
+	}
+	for name, key := range map[string]ssh.Signer{"current": current, "next": next} {
+		if !slices.Contains(keys, string(key.PublicKey().Marshal())) {
+			t.Errorf("known_hosts lacks the %s host key:\n%s", name, data)
+		}
+	}
+}
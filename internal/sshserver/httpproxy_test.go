@@ -0,0 +1,211 @@
+package sshserver
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// connectProxy is an HTTP proxy stub that only tunnels CONNECT requests,
+// recording each one
+type connectProxy struct {
+	addr   string
+	status int // Answered instead of tunnelling, when nonzero
+
+	mu       sync.Mutex
+	requests []*http.Request
+}
+
+// startConnectProxy serves a connectProxy on a loopback port
+func startConnectProxy(t *testing.T, status int) *connectProxy {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	p := &connectProxy{addr: l.Addr().String(), status: status}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go p.serve(c)
+		}
+	}()
+	return p
+}
+
+func (p *connectProxy) serve(c net.Conn) {
+	defer c.Close()
+	req, err := http.ReadRequest(bufio.NewReader(c))
+	if err != nil {
+		return
+	}
+	p.mu.Lock()
+	p.requests = append(p.requests, req)
+	p.mu.Unlock()
+
+	if req.Method != http.MethodConnect {
+		io.WriteString(c, "HTTP/1.1 405 Method Not Allowed\r\n\r\n")
+		return
+	}
+	if p.status != 0 {
+		io.WriteString(c, "HTTP/1.1 "+strconv.Itoa(p.status)+" "+http.StatusText(p.status)+"\r\n\r\n")
+		return
+	}
+	upstream, err := net.Dial("tcp", req.Host)
+	if err != nil {
+		io.WriteString(c, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+		return
+	}
+	defer upstream.Close()
+	io.WriteString(c, "HTTP/1.1 200 Connection established\r\n\r\n")
+	go io.Copy(upstream, c)
+	io.Copy(c, upstream)
+}
+
+// seen returns the requests the proxy received
+func (p *connectProxy) seen() []*http.Request {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]*http.Request(nil), p.requests...)
+}
+
+// TestDialProxyThroughHTTPProxy opens proxy WebSockets through a CONNECT
+// proxy stub, to plain and TLS APIs
+func TestDialProxyThroughHTTPProxy(t *testing.T) {
+	plain := httptest.NewServer(newFakeAPI(t))
+	t.Cleanup(plain.Close)
+	secure := httptest.NewTLSServer(newFakeAPI(t))
+	t.Cleanup(secure.Close)
+	secureTLS := &tls.Config{RootCAs: secure.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs}
+
+	_, echoPort, err := net.SplitHostPort(startEchoServer(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, _ := strconv.Atoi(echoPort)
+
+	tests := []struct {
+		name      string
+		api       string
+		tlsConfig *tls.Config
+		status    int
+		user      *url.Userinfo
+		wantErr   string
+	}{
+		{name: "http API", api: plain.URL},
+		{name: "https API", api: secure.URL, tlsConfig: secureTLS},
+		{name: "proxy credentials", api: plain.URL, user: url.UserPassword("alice", "s3cret")},
+		{name: "proxy refuses", api: plain.URL, status: http.StatusProxyAuthRequired, wantErr: "407"},
+		{name: "proxy forbids", api: secure.URL, tlsConfig: secureTLS, status: http.StatusForbidden, wantErr: "403"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := startConnectProxy(t, tt.status)
+			proxyURL := &url.URL{Scheme: "http", Host: stub.addr, User: tt.user}
+			proxy := func(*http.Request) (*url.URL, error) { return proxyURL, nil }
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			wsConn, _, err := dialProxy(ctx, tt.api, "test", "proxied", "127.0.0.1", port, defaultWSBufferSize, false, tt.tlsConfig, proxy)
+			if tt.wantErr != "" {
+				if err == nil {
+					wsConn.Close()
+					t.Fatalf("dialProxy succeeded, want error containing %q", tt.wantErr)
+				}
+				if !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("dialProxy error = %v, want it to contain %q", err, tt.wantErr)
+				}
+			} else {
+				if err != nil {
+					t.Fatalf("dialProxy: %v", err)
+				}
+				defer wsConn.Close()
+				if err := wsConn.WriteMessage(websocket.BinaryMessage, []byte("ping")); err != nil {
+					t.Fatal(err)
+				}
+				_ = wsConn.SetReadDeadline(time.Now().Add(5 * time.Second))
+				if _, data, err := wsConn.ReadMessage(); err != nil || string(data) != "ping" {
+					t.Fatalf("echo = %q, %v; want %q", data, err, "ping")
+				}
+			}
+
+			seen := stub.seen()
+			if len(seen) != 1 {
+				t.Fatalf("proxy saw %d requests, want 1", len(seen))
+			}
+			apiHost := strings.TrimPrefix(strings.TrimPrefix(tt.api, "http://"), "https://")
+			if seen[0].Method != http.MethodConnect || seen[0].Host != apiHost {
+				t.Errorf("proxy saw %s %s, want CONNECT %s", seen[0].Method, seen[0].Host, apiHost)
+			}
+			wantAuth := ""
+			if tt.user != nil {
+				password, _ := tt.user.Password()
+				wantAuth = "Basic " + base64.StdEncoding.EncodeToString([]byte(tt.user.Username()+":"+password))
+			}
+			if got := seen[0].Header.Get("Proxy-Authorization"); got != wantAuth {
+				t.Errorf("Proxy-Authorization = %q, want %q", got, wantAuth)
+			}
+		})
+	}
+}
+
+// TestProxyFromEnvironmentLoopback connects to loopback APIs directly,
+// whatever the environment names as proxy
+func TestProxyFromEnvironmentLoopback(t *testing.T) {
+	for _, host := range []string{"localhost:8080", "127.0.0.1:8080", "[::1]:8080"} {
+		for _, scheme := range []string{"http", "https"} {
+			u, err := proxyFromEnvironment(&http.Request{URL: &url.URL{Scheme: scheme, Host: host}})
+			if err != nil {
+				t.Fatalf("%s://%s: %v", scheme, host, err)
+			}
+			if u != nil {
+				t.Errorf("%s://%s goes through proxy %s, want a direct connection", scheme, host, u)
+			}
+		}
+	}
+}
+
+// TestIgnoreProxyEnv checks which proxy function the server is built with
+func TestIgnoreProxyEnv(t *testing.T) {
+	tests := []struct {
+		name      string
+		ignore    bool
+		wantProxy bool
+	}{
+		{"environment", false, true},
+		{"ignored", true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, err := NewServer(&ServerConfig{
+				HostKey:        newTestSigner(t),
+				TokenOptions:   testTokenOptions(t, newFakeAPI(t)),
+				IgnoreProxyEnv: tt.ignore,
+				Shell:          "/bin/sh",
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := srv.proxy != nil; got != tt.wantProxy {
+				t.Errorf("server has a proxy function = %v, want %v", got, tt.wantProxy)
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package sshserver
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// idleWarning is how long before an idle session is closed that TTY
+// sessions are warned
+const idleWarning = time.Minute
+
+// idleChannel records the time of the last read or write on a session's
+// channel, so each session's idle timer only sees its own traffic
+type idleChannel struct {
+	ssh.Channel
+	last *atomic.Int64 // UnixNano
+}
+
+func newIdleChannel(ch ssh.Channel) *idleChannel {
+	c := &idleChannel{Channel: ch, last: new(atomic.Int64)}
+	c.touch()
+	return c
+}
+
+func (c *idleChannel) touch() {
+	c.last.Store(time.Now().UnixNano())
+}
+
+func (c *idleChannel) Read(p []byte) (int, error) {
+	n, err := c.Channel.Read(p)
+	if n > 0 {
+		c.touch()
+	}
+	return n, err
+}
+
+func (c *idleChannel) Write(p []byte) (int, error) {
+	n, err := c.Channel.Write(p)
+	if n > 0 {
+		c.touch()
+	}
+	return n, err
+}
+
+func (c *idleChannel) Stderr() io.ReadWriter {
+	return &idleStderr{ReadWriter: c.Channel.Stderr(), c: c}
+}
+
+// idleStderr is the stderr stream of an idleChannel
+type idleStderr struct {
+	io.ReadWriter
+	c *idleChannel
+}
+
+func (s *idleStderr) Write(p []byte) (int, error) {
+	n, err := s.ReadWriter.Write(p)
+	if n > 0 {
+		s.c.touch()
+	}
+	return n, err
+}
+
+// idleSince returns how long the channel has gone without traffic
+func (c *idleChannel) idleSince() time.Duration {
+	return time.Since(time.Unix(0, c.last.Load()))
+}
+
+// watchIdle closes the session once its channel has gone timeout without
+// traffic, cancelling the remote command. TTY sessions are warned first.
+func (s *session) watchIdle(ctx context.Context, ch *idleChannel, timeout time.Duration) {
+	warnAt := timeout - idleWarning
+	if warnAt <= 0 {
+		warnAt = timeout / 2
+	}
+
+	// tty is only read once a command runs, after pty-req was handled
+	tty := func() bool { return s.running.Load() && s.tty }
+
+	warned := false
+	next := warnAt
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(next):
+		}
+
+		idle := ch.idleSince()
+		switch {
+		case idle >= timeout:
+			logger(ctx).InfoContext(ctx, "Closing idle session",
+				"idle", idle.Round(time.Second))
+			if tty() {
+				fmt.Fprintf(ch.Channel, "\r\n\033[33m[sprite] Session closed after %s without activity\033[0m\r\n", timeout)
+			}
+			s.cancel()
+			return
+		case idle >= warnAt:
+			if !warned && tty() {
+				// Written to the underlying channel, so the warning itself
+				// doesn't count as activity
+				fmt.Fprintf(ch.Channel, "\r\n\033[33m[sprite] Closing this session in %s unless there is activity\033[0m\r\n",
+					(timeout - idle).Round(time.Second))
+			}
+			warned = true
+			next = timeout - idle
+		default:
+			warned = false
+			next = warnAt - idle
+		}
+	}
+}
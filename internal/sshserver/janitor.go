@@ -0,0 +1,121 @@
+package sshserver
+
+import (
+	"context"
+	"log/slog"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/superfly/sprites-go"
+)
+
+// defaultJanitorInterval is used when ServerConfig.JanitorInterval is zero
+const defaultJanitorInterval = time.Minute
+
+// Eviction settings for the server's long-lived maps
+var (
+	// authHandoffTTL is how long a sprite looked up during authentication
+	// waits for its connection; handshakes that fail after auth never
+	// pick it up
+	authHandoffTTL = 2 * time.Minute
+
+	// authCounterTTL is how long per-IP authentication counters are kept
+	// after the IP was last seen, and maxAuthCounters caps how many are
+	// kept at all, so scanners can't grow the map without bound
+	authCounterTTL  = 24 * time.Hour
+	maxAuthCounters = 10000
+)
+
+// pendingAuth is a sprite stored by lookupUser for handleConn
+type pendingAuth struct {
+	sprite *sprites.Sprite
+	route  *orgRoute // Organization the sprite was found in
+	user   string    // SSH user, i.e. the sprite name
+	remote string    // Client address
+	woken  <-chan struct{}
+	stored time.Time
+}
+
+// janitor evicts expired entries from the server's maps every interval
+// until ctx ends
+func (srv *Server) janitor(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			srv.prune(time.Now())
+		}
+	}
+}
+
+// prune evicts entries that expired by now
+func (srv *Server) prune(now time.Time) {
+	handoffs := 0
+	srv.sprites.Range(func(k, v any) bool {
+		if now.Sub(v.(pendingAuth).stored) > authHandoffTTL {
+			srv.sprites.Delete(k)
+			handoffs++
+		}
+		return true
+	})
+	counters := srv.registry.auth.prune(now.Add(-authCounterTTL), maxAuthCounters)
+	throttled := srv.throttle.prune(now)
+	codes := srv.pairing.prune(now)
+
+	if handoffs+counters+throttled+codes > 0 {
+		slog.Debug("Pruned server state",
+			"pending_auth", handoffs,
+			"auth_counters", counters,
+			"auth_throttle", throttled,
+			"pairing_codes", codes)
+	}
+
+	// Gauge of what is left, so growth shows up in debug logs
+	var sizes []any
+	m := srv.mapSizes()
+	for _, name := range slices.Sorted(maps.Keys(m)) {
+		sizes = append(sizes, slog.Int(name, m[name]))
+	}
+	slog.Debug("Server map sizes", sizes...)
+}
+
+// mapSizes returns the size of each long-lived map, for Snapshot
+func (srv *Server) mapSizes() map[string]int {
+	pending := 0
+	srv.sprites.Range(func(_, _ any) bool {
+		pending++
+		return true
+	})
+
+	srv.wakes.mu.Lock()
+	wakes := len(srv.wakes.calls)
+	srv.wakes.mu.Unlock()
+
+	srv.registry.auth.mu.Lock()
+	counters := len(srv.registry.auth.byIP)
+	srv.registry.auth.mu.Unlock()
+
+	srv.registry.mu.Lock()
+	conns := len(srv.registry.conns)
+	srv.registry.mu.Unlock()
+
+	srv.registry.openMu.Lock()
+	forwards := len(srv.registry.open)
+	srv.registry.openMu.Unlock()
+
+	return map[string]int{
+		"pending_auth":  pending,
+		"wakes":         wakes,
+		"warm_proxies":  srv.warm.len(),
+		"auth_counters": counters,
+		"auth_throttle": srv.throttle.size(),
+		"pairing_codes": srv.pairing.size(),
+		"connections":   conns,
+		"forwards":      forwards,
+	}
+}
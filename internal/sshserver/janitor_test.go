@@ -0,0 +1,259 @@
+package sshserver
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// pendingAuthCount returns how many sprites wait for their connection
+func (srv *Server) pendingAuthCount() int {
+	return srv.mapSizes()["pending_auth"]
+}
+
+func TestPrunePendingAuth(t *testing.T) {
+	srv := newTestServer(t, &ServerConfig{})
+	now := time.Now()
+
+	tests := []struct {
+		key    string
+		stored time.Time
+		kept   bool
+	}{
+		{"fresh", now.Add(-time.Second), true},
+		{"just in time", now.Add(-authHandoffTTL), true},
+		{"stale", now.Add(-authHandoffTTL - time.Second), false},
+		{"ancient", now.Add(-24 * time.Hour), false},
+	}
+	for _, tt := range tests {
+		srv.sprites.Store(tt.key, pendingAuth{user: tt.key, remote: "192.0.2.1:1", stored: tt.stored})
+	}
+	srv.prune(now)
+	for _, tt := range tests {
+		if _, ok := srv.sprites.Load(tt.key); ok != tt.kept {
+			t.Errorf("%s: kept = %v, want %v", tt.key, ok, tt.kept)
+		}
+	}
+
+	// A failed handshake drops what its address left behind
+	srv.dropPendingAuth(&net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1})
+	if n := srv.pendingAuthCount(); n != 0 {
+		t.Errorf("%d pending after dropPendingAuth, want 0", n)
+	}
+}
+
+// signThenHangUp is a signer that hangs up the connection once the request
+// carrying its signature is sent, before the server can answer it
+type signThenHangUp struct {
+	ssh.Signer
+	signed *atomic.Bool
+}
+
+func (s signThenHangUp) Sign(rand io.Reader, data []byte) (*ssh.Signature, error) {
+	s.signed.Store(true)
+	return s.Signer.Sign(rand, data)
+}
+
+// hangUpConn closes itself after the first write once signed is set
+type hangUpConn struct {
+	net.Conn
+	signed *atomic.Bool
+}
+
+func (c hangUpConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if c.signed.Load() {
+		c.Conn.Close()
+	}
+	return n, err
+}
+
+func TestFailedHandshakesLeaveNoPendingAuth(t *testing.T) {
+	srv, addr := startTestServer(t, &ServerConfig{})
+	signer := newTestSigner(t)
+
+	const attempts = 50
+	for range attempts {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		signed := &atomic.Bool{}
+		_, _, _, err = ssh.NewClientConn(hangUpConn{conn, signed}, addr, &ssh.ClientConfig{
+			User:            "demo",
+			Auth:            []ssh.AuthMethod{ssh.PublicKeys(signThenHangUp{signer, signed})},
+			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+			Timeout:         10 * time.Second,
+		})
+		if err == nil {
+			t.Fatal("handshake succeeded after hanging up")
+		}
+		conn.Close()
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for srv.pendingAuthCount() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d sprites still pending after %d failed handshakes", srv.pendingAuthCount(), attempts)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// setForTest sets *p to v until the test ends
+func setForTest[T any](t *testing.T, p *T, v T) {
+	t.Helper()
+	old := *p
+	*p = v
+	t.Cleanup(func() { *p = old })
+}
+
+// soakDial connects from the i-th loopback address, so connections look
+// like they come from many clients
+func soakDial(addr string, i int) (net.Conn, error) {
+	d := net.Dialer{Timeout: 10 * time.Second}
+	if runtime.GOOS == "linux" {
+		d.LocalAddr = &net.TCPAddr{IP: net.IPv4(127, 1, byte(i>>8), byte(i))}
+	}
+	return d.Dial("tcp", addr)
+}
+
+// TestSoakShortConnections runs thousands of short connections of every
+// kind a long-running server sees and checks its maps settle back to
+// empty, with no leaked goroutines or heap growth
+func TestSoakShortConnections(t *testing.T) {
+	if testing.Short() {
+		t.Skip("soak test")
+	}
+	// Shrink every TTL so the janitor catches up within the test
+	setForTest(t, &authHandoffTTL, 100*time.Millisecond)
+	setForTest(t, &authCounterTTL, 200*time.Millisecond)
+	setForTest(t, &maxAuthCounters, 50)
+	setForTest(t, &authFailureWindow, 100*time.Millisecond)
+	setForTest(t, &authThrottleCooldown, 100*time.Millisecond)
+	setForTest(t, &wakeCacheTTL, 100*time.Millisecond)
+
+	srv, addr := startTestServer(t, &ServerConfig{JanitorInterval: 20 * time.Millisecond})
+	signer := newTestSigner(t)
+	config := func(user string, signer ssh.Signer) *ssh.ClientConfig {
+		return &ssh.ClientConfig{
+			User:            user,
+			Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+			Timeout:         10 * time.Second,
+		}
+	}
+
+	// connect makes the i-th connection, cycling through a command run,
+	// a lookup of a sprite that doesn't exist, a hang up right after
+	// authentication and a port scan
+	connect := func(i int) error {
+		conn, err := soakDial(addr, i)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+
+		switch i % 4 {
+		case 0:
+			c, chans, reqs, err := ssh.NewClientConn(conn, addr, config("demo", signer))
+			if err != nil {
+				return err
+			}
+			client := ssh.NewClient(c, chans, reqs)
+			defer client.Close()
+			session, err := client.NewSession()
+			if err != nil {
+				return err
+			}
+			defer session.Close()
+			return session.Run("true")
+		case 1:
+			if _, _, _, err := ssh.NewClientConn(conn, addr, config("no_such_sprite", signer)); err == nil {
+				return errors.New("logged in to a sprite that doesn't exist")
+			}
+		case 2:
+			signed := &atomic.Bool{}
+			if _, _, _, err := ssh.NewClientConn(hangUpConn{conn, signed}, addr, config("demo", signThenHangUp{signer, signed})); err == nil {
+				return errors.New("handshake succeeded after hanging up")
+			}
+		case 3:
+			// Scanners connect and leave without a word
+		}
+		return nil
+	}
+
+	// run makes n connections, a few at a time, then waits for every map
+	// to empty out
+	run := func(n int) {
+		t.Helper()
+		const workers = 16
+		next := atomic.Int64{}
+		errs := make(chan error, workers)
+		var wg sync.WaitGroup
+		for range workers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := int(next.Add(1)); i <= n; i = int(next.Add(1)) {
+					if err := connect(i); err != nil {
+						errs <- fmt.Errorf("connection %d: %w", i, err)
+						return
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			t.Fatal(err)
+		}
+
+		deadline := time.Now().Add(10 * time.Second)
+		for {
+			sizes := srv.mapSizes()
+			settled := true
+			for name, size := range sizes {
+				// The one sprite may keep a warm proxy
+				if size > 0 && !(name == "warm_proxies" && size <= 1) {
+					settled = false
+				}
+			}
+			if settled {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("maps haven't settled after %d connections: %v", n, sizes)
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+
+	// heap returns the live heap after a collection
+	heap := func() uint64 {
+		runtime.GC()
+		var m runtime.MemStats
+		runtime.ReadMemStats(&m)
+		return m.HeapAlloc
+	}
+
+	// A first round warms up buffer pools and caches
+	run(200)
+	goroutines, before := serverGoroutines(), heap()
+
+	run(2000)
+	if n := serverGoroutines(); n > goroutines {
+		t.Errorf("%d server goroutines after the soak, %d before", n, goroutines)
+	}
+	if after := heap(); after > before+8<<20 {
+		t.Errorf("heap grew from %d to %d bytes", before, after)
+	}
+}
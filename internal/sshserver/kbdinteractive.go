@@ -0,0 +1,151 @@
+package sshserver
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// pairingCodeTTL is how long a pairing code can be used
+const pairingCodeTTL = 10 * time.Minute
+
+// pairingAlphabet leaves out characters that are easily confused
+const pairingAlphabet = "23456789ABCDEFGHJKLMNPQRSTUVWXYZ"
+
+var errBadPairingCode = errors.New("invalid or expired pairing code")
+
+// pairingCodes are one-time codes for keyboard-interactive logins, with
+// when each expires
+type pairingCodes struct {
+	mu    sync.Mutex
+	codes map[string]time.Time
+}
+
+// NewPairingCode returns a one-time code a client without a key can log in
+// with, and when it expires
+func (srv *Server) NewPairingCode() (string, time.Time) {
+	b := make([]byte, 8)
+	rand.Read(b)
+	for i := range b {
+		b[i] = pairingAlphabet[int(b[i])%len(pairingAlphabet)]
+	}
+	code := string(b)
+	expires := time.Now().Add(pairingCodeTTL)
+
+	p := &srv.pairing
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.codes == nil {
+		p.codes = make(map[string]time.Time)
+	}
+	p.codes[code] = expires
+	return code[:4] + "-" + code[4:], expires
+}
+
+// find returns the unexpired code matching answer, or "". Case, spaces and
+// dashes in answer are ignored.
+func (p *pairingCodes) find(answer string, now time.Time) string {
+	answer = strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(answer))
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	found := ""
+	for code, expires := range p.codes {
+		if subtle.ConstantTimeCompare([]byte(code), []byte(answer)) == 1 && now.Before(expires) {
+			found = code
+		}
+	}
+	return found
+}
+
+// redeem uses up code, reporting false if another login got there first
+func (p *pairingCodes) redeem(code string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.codes[code]; !ok {
+		return false
+	}
+	delete(p.codes, code)
+	return true
+}
+
+// prune drops codes that expired by now, returning how many were dropped
+func (p *pairingCodes) prune(now time.Time) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	dropped := 0
+	for code, expires := range p.codes {
+		if !now.Before(expires) {
+			delete(p.codes, code)
+			dropped++
+		}
+	}
+	return dropped
+}
+
+func (p *pairingCodes) size() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.codes)
+}
+
+// keyboardInteractiveCallback lets clients without a usable key log in.
+// Without a pairing code it asks no questions, the same trust as accepting
+// any key; when keys are restricted a code is always required, since the
+// restriction would otherwise be bypassed.
+func (srv *Server) keyboardInteractiveCallback(cm ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (perms *ssh.Permissions, err error) {
+	defer func() { logInteractiveAuth(cm, err) }()
+
+	if !srv.throttle.allow(cm.RemoteAddr()) {
+		return nil, errAuthThrottled
+	}
+
+	code := ""
+	if srv.requirePairingCode || srv.restrictsKeys() {
+		answers, err := client(cm.User(), "Enter the code from 'sprite-bootstrap pair'.", []string{"Pairing code: "}, []bool{false})
+		if err != nil {
+			return nil, err
+		}
+		if len(answers) == 1 {
+			code = srv.pairing.find(answers[0], time.Now())
+		}
+		if code == "" {
+			srv.throttle.fail(cm.RemoteAddr())
+			return nil, errBadPairingCode
+		}
+	}
+
+	// The code is only used up once the sprite was found, so a mistyped
+	// name doesn't waste it
+	if err := srv.lookupUser(cm); err != nil {
+		return nil, err
+	}
+	if code != "" && !srv.pairing.redeem(code) {
+		srv.dropPendingAuth(cm.RemoteAddr())
+		return nil, errBadPairingCode
+	}
+	return &ssh.Permissions{}, nil
+}
+
+// logInteractiveAuth logs the outcome of a keyboard-interactive login like
+// logKeyAuth does for keys
+func logInteractiveAuth(cm ssh.ConnMetadata, err error) {
+	attrs := []any{
+		"conn.id", authConnID(cm),
+		"sprite.name", cm.User(),
+		"remote", cm.RemoteAddr().String(),
+	}
+	if errors.Is(err, errAuthThrottled) {
+		return
+	} else if err != nil {
+		slog.Info("Rejected keyboard-interactive login", append(attrs, "exception", err)...)
+		return
+	}
+	slog.Debug("Accepted keyboard-interactive login", attrs...)
+}
@@ -14,6 +14,8 @@ import (
 	"path/filepath"
 	"strings"
 
+	"github.com/vaurdan/sprite-bootstrap/internal/sshdir"
+
 	"golang.org/x/crypto/ssh"
 )
 
@@ -32,7 +34,8 @@ func DefaultHostKeyPath() (string, error) {
 	return filepath.Join(homeDir, ".ssh", defaultHostKeyName), nil
 }
 
-// LoadHostKey loads the Ed25519 host key at the given path.
+// LoadHostKey loads the host key at the given path. Ed25519, ECDSA and RSA
+// keys are supported.
 func LoadHostKey(path string) (ssh.Signer, error) {
 	rawKey, err := os.ReadFile(path)
 	if err != nil {
@@ -44,16 +47,28 @@ func LoadHostKey(path string) (ssh.Signer, error) {
 		return nil, fmt.Errorf("parse SSH private key: %w", err)
 	}
 
-	signer, err := ssh.NewSignerWithAlgorithms(
-		priv.(ssh.AlgorithmSigner), []string{ssh.KeyAlgoED25519},
-	)
+	algSigner, ok := priv.(ssh.AlgorithmSigner)
+	if !ok {
+		return priv, nil
+	}
+	signer, err := ssh.NewSignerWithAlgorithms(algSigner, hostKeyAlgorithms(priv.PublicKey().Type()))
 	if err != nil {
-		return nil, fmt.Errorf("expected Ed25519 private key: %w", err)
+		return nil, fmt.Errorf("unsupported %s host key: %w", priv.PublicKey().Type(), err)
 	}
 
 	return signer, nil
 }
 
+// hostKeyAlgorithms returns the signature algorithms offered for a host key
+// type. RSA keys only sign with SHA-2, as OpenSSH no longer accepts SHA-1
+// signatures by default.
+func hostKeyAlgorithms(keyType string) []string {
+	if keyType == ssh.KeyAlgoRSA {
+		return []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256}
+	}
+	return []string{keyType}
+}
+
 // GenerateHostKey generates a new Ed25519 host key and writes it to the given path.
 func GenerateHostKey(path string) (ssh.Signer, error) {
 	rawPub, rawPriv, err := ed25519.GenerateKey(nil)
@@ -97,7 +112,8 @@ func GenerateHostKey(path string) (ssh.Signer, error) {
 
 // LoadOrGenerateHostKey loads a host key from the given path, or generates one if it doesn't exist.
 func LoadOrGenerateHostKey(path string) (ssh.Signer, error) {
-	if path == "" {
+	defaultPath := path == ""
+	if defaultPath {
 		var err error
 		path, err = DefaultHostKeyPath()
 		if err != nil {
@@ -111,6 +127,13 @@ func LoadOrGenerateHostKey(path string) (ssh.Signer, error) {
 	}
 
 	if os.IsNotExist(err) {
+		// The default key lives in ~/.ssh - catch permission problems upfront
+		// rather than failing on the write
+		if defaultPath {
+			if err := sshdir.Ensure(); err != nil {
+				return nil, err
+			}
+		}
 		key, err = GenerateHostKey(path)
 		if err != nil {
 			return nil, fmt.Errorf("failed to generate SSH host key: %w", err)
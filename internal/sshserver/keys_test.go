@@ -0,0 +1,152 @@
+package sshserver
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// writeKey writes a PEM private key to a temporary file and returns its path
+func writeKey(t *testing.T, block *pem.Block) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "host_key")
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// openSSHKey returns key in the OpenSSH private key format
+func openSSHKey(t *testing.T, key crypto.PrivateKey) *pem.Block {
+	t.Helper()
+	block, err := ssh.MarshalPrivateKey(key, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return block
+}
+
+func TestLoadHostKey(t *testing.T) {
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	p256, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	p384, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecDER, _ := x509.MarshalECPrivateKey(p256)
+
+	tests := []struct {
+		name     string
+		block    *pem.Block
+		wantType string
+		wantAlgs []string // Algorithms the key must sign with
+	}{
+		{"ed25519", openSSHKey(t, edKey), ssh.KeyAlgoED25519, []string{ssh.KeyAlgoED25519}},
+		{"ecdsa p256", openSSHKey(t, p256), ssh.KeyAlgoECDSA256, []string{ssh.KeyAlgoECDSA256}},
+		{"ecdsa p384", openSSHKey(t, p384), ssh.KeyAlgoECDSA384, []string{ssh.KeyAlgoECDSA384}},
+		{"ecdsa pem", &pem.Block{Type: "EC PRIVATE KEY", Bytes: ecDER}, ssh.KeyAlgoECDSA256, []string{ssh.KeyAlgoECDSA256}},
+		{"rsa openssh", openSSHKey(t, rsaKey), ssh.KeyAlgoRSA, []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256}},
+		{"rsa pkcs1", &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}, ssh.KeyAlgoRSA, []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256}},
+	}
+	for _, tt := range tests {
+		signer, err := LoadHostKey(writeKey(t, tt.block))
+		if err != nil {
+			t.Errorf("%s: LoadHostKey() = %v", tt.name, err)
+			continue
+		}
+		if got := signer.PublicKey().Type(); got != tt.wantType {
+			t.Errorf("%s: key type = %s, want %s", tt.name, got, tt.wantType)
+		}
+		multi, ok := signer.(ssh.MultiAlgorithmSigner)
+		if !ok || !slices.Equal(multi.Algorithms(), tt.wantAlgs) {
+			t.Errorf("%s: signs with %v, want %v", tt.name, multi, tt.wantAlgs)
+			continue
+		}
+		for _, alg := range tt.wantAlgs {
+			sig, err := multi.SignWithAlgorithm(rand.Reader, []byte("data"), alg)
+			if err != nil {
+				t.Errorf("%s: sign with %s: %v", tt.name, alg, err)
+				continue
+			}
+			if err := signer.PublicKey().Verify([]byte("data"), sig); err != nil {
+				t.Errorf("%s: %s signature doesn't verify: %v", tt.name, alg, err)
+			}
+		}
+	}
+
+	if _, err := LoadHostKey(writeKey(t, &pem.Block{Type: "PRIVATE KEY", Bytes: []byte("junk")})); err == nil {
+		t.Error("LoadHostKey accepted a corrupt key")
+	}
+	if _, err := LoadHostKey(filepath.Join(t.TempDir(), "missing")); !os.IsNotExist(err) {
+		t.Errorf("LoadHostKey(missing) = %v, want a not-exist error", err)
+	}
+}
+
+func TestMultipleHostKeys(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	p256, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	rsaSigner, err := LoadHostKey(writeKey(t, openSSHKey(t, rsaKey)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecSigner, err := LoadHostKey(writeKey(t, openSSHKey(t, p256)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, addr := startTestServer(t, &ServerConfig{HostKey: rsaSigner, MoreHostKeys: []ssh.Signer{ecSigner}})
+
+	tests := []struct {
+		clientAlgs []string
+		want       ssh.PublicKey // nil when the handshake must fail
+	}{
+		{[]string{ssh.KeyAlgoRSASHA256}, rsaSigner.PublicKey()},
+		{[]string{ssh.KeyAlgoRSASHA512}, rsaSigner.PublicKey()},
+		{[]string{ssh.KeyAlgoECDSA256}, ecSigner.PublicKey()},
+		{[]string{ssh.KeyAlgoRSA}, nil}, // SHA-1 signatures aren't offered
+		{[]string{ssh.KeyAlgoED25519}, nil},
+	}
+	for _, tt := range tests {
+		var got ssh.PublicKey
+		client, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+			User:              "demo",
+			Auth:              []ssh.AuthMethod{ssh.PublicKeys(newTestSigner(t))},
+			HostKeyAlgorithms: tt.clientAlgs,
+			HostKeyCallback: func(_ string, _ net.Addr, key ssh.PublicKey) error {
+				got = key
+				return nil
+			},
+			Timeout: 10 * time.Second,
+		})
+		if tt.want == nil {
+			if err == nil {
+				client.Close()
+				t.Errorf("%v: handshake succeeded, want it refused", tt.clientAlgs)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: %v", tt.clientAlgs, err)
+			continue
+		}
+		client.Close()
+		if got == nil || string(got.Marshal()) != string(tt.want.Marshal()) {
+			t.Errorf("%v: server presented the wrong host key", tt.clientAlgs)
+		}
+	}
+
+	// Two keys of one type can't both be host keys
+	if _, err := NewServer(&ServerConfig{HostKey: rsaSigner, MoreHostKeys: []ssh.Signer{rsaSigner}, TokenOptions: &TokenOptions{}}); err == nil {
+		t.Error("NewServer accepted two RSA host keys")
+	}
+}
@@ -0,0 +1,88 @@
+package sshserver
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// defaultLifetimeWarnings is when TTY sessions are warned before reaching
+// ServerConfig.MaxSessionLifetime, if LifetimeWarnings is empty
+var defaultLifetimeWarnings = []time.Duration{10 * time.Minute, time.Minute}
+
+// exitSignalRequest is the payload of an "exit-signal" request (RFC 4254
+// section 6.10)
+type exitSignalRequest struct {
+	Signal     string
+	CoreDumped bool
+	Message    string
+	Lang       string
+}
+
+// watchLifetime ends the session at deadline. TTY sessions are warned
+// warnings before it; at the deadline their command gets HUP, as if the
+// terminal hung up, and exec commands get TERM. The client is told with
+// exit-signal. Retries run within the session, so they don't move the
+// deadline.
+func (s *session) watchLifetime(ctx context.Context, deadline time.Time, warnings []time.Duration) {
+	s.conn.state.setDeadline(s.id, deadline)
+	defer s.conn.state.clearDeadline(s.id)
+
+	// tty is only read once a command runs, after pty-req was handled
+	tty := func() bool { return s.running.Load() && s.tty }
+
+	// Warnings furthest from the deadline come first
+	warnings = slices.Clone(warnings)
+	slices.SortFunc(warnings, func(a, b time.Duration) int { return int(b - a) })
+	for _, w := range warnings {
+		at := deadline.Add(-w)
+		if w <= 0 || time.Now().After(at) {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(at)):
+		}
+		if tty() {
+			fmt.Fprintf(s.ch, "\r\n\033[33m[sprite] Session ends in %s\033[0m\r\n", w.Round(time.Second))
+		}
+	}
+
+	select {
+	case <-ctx.Done():
+		return
+	case <-time.After(time.Until(deadline)):
+	}
+
+	signal := "TERM"
+	if tty() {
+		signal = "HUP"
+		fmt.Fprintf(s.ch, "\r\n\033[33m[sprite] Session reached its maximum lifetime\033[0m\r\n")
+	}
+	logger(ctx).InfoContext(ctx, "Ending session at maximum lifetime",
+		"signal", signal)
+
+	s.expired.Store(true)
+	_ = s.signal(ctx, signal)
+	s.ch.SendRequest("exit-signal", false, ssh.Marshal(exitSignalRequest{
+		Signal:  signal,
+		Message: "session reached its maximum lifetime",
+	}))
+	s.cancel()
+}
+
+// watchShutdown tells a TTY session when the server starts shutting down,
+// so the user knows why it is about to end
+func (s *session) watchShutdown(ctx context.Context) {
+	select {
+	case <-ctx.Done():
+	case <-s.conn.srv.draining:
+		if s.running.Load() && s.tty {
+			fmt.Fprintf(s.ch, "\r\n\033[33m[sprite] Server shutting down; this session ends within %s\033[0m\r\n", s.conn.srv.shutdownGrace)
+		}
+	}
+}
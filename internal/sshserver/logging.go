@@ -0,0 +1,24 @@
+package sshserver
+
+import (
+	"context"
+	"log/slog"
+)
+
+type loggerKey struct{}
+
+// withLogger returns ctx carrying l, for logger to find
+func withLogger(ctx context.Context, l *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// logger returns the logger for ctx. While serving a connection it adds
+// the connection's conn.id and sprite.name, and within a session its
+// session.id, so every line can be traced back to them. Elsewhere it is
+// slog's default logger.
+func logger(ctx context.Context) *slog.Logger {
+	if l, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return l
+	}
+	return slog.Default()
+}
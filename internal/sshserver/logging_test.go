@@ -0,0 +1,208 @@
+package sshserver
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// logRecord is a log line with all of its attributes, including those its
+// logger was created with, and the function that logged it
+type logRecord struct {
+	msg   string
+	attrs map[string]string
+	fn    string
+}
+
+// captureHandler records every log line, for all the loggers derived from it
+type captureHandler struct {
+	mu      *sync.Mutex
+	records *[]logRecord
+	attrs   []slog.Attr
+}
+
+func (h *captureHandler) Enabled(context.Context, slog.Level) bool { return true }
+
+func (h *captureHandler) Handle(_ context.Context, r slog.Record) error {
+	rec := logRecord{msg: r.Message, attrs: map[string]string{}}
+	if r.PC != 0 {
+		frame, _ := runtime.CallersFrames([]uintptr{r.PC}).Next()
+		rec.fn = frame.Function
+	}
+	for _, a := range h.attrs {
+		rec.attrs[a.Key] = a.Value.String()
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		rec.attrs[a.Key] = a.Value.String()
+		return true
+	})
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	*h.records = append(*h.records, rec)
+	return nil
+}
+
+func (h *captureHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &captureHandler{mu: h.mu, records: h.records, attrs: append(slices.Clip(h.attrs), attrs...)}
+}
+
+func (h *captureHandler) WithGroup(string) slog.Handler { return h }
+
+// captureLogs makes slog's default logger record to the returned function
+// until the test ends
+func captureLogs(t *testing.T) func() []logRecord {
+	h := &captureHandler{mu: new(sync.Mutex), records: new([]logRecord)}
+	prev := slog.Default()
+	slog.SetDefault(slog.New(h))
+	t.Cleanup(func() { slog.SetDefault(prev) })
+	return func() []logRecord {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		return slices.Clone(*h.records)
+	}
+}
+
+// sharedMessages are logged for work shared by all of a sprite's
+// connections, so they carry no conn.id
+var sharedMessages = []string{"Woke sprite", "Joining wake in progress"}
+
+// TestLogAttributes checks that every line this package logs while serving
+// a connection names it, its sprite and, within a session, the session.
+// Lines the sprites client logs itself can't be attributed.
+func TestLogAttributes(t *testing.T) {
+	tests := []struct {
+		name        string
+		run         func(t *testing.T, client *ssh.Client)
+		wantMessage string // Logged with the connection's attributes
+		session     bool   // Whether wantMessage is logged by a session
+	}{
+		{"exec", func(t *testing.T, client *ssh.Client) {
+			session, err := client.NewSession()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer session.Close()
+			if err := session.Run("echo hi"); err != nil {
+				t.Fatal(err)
+			}
+		}, "Started exec session", true},
+		{"shell", func(t *testing.T, client *ssh.Client) {
+			session, err := client.NewSession()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer session.Close()
+			if err := session.RequestPty("xterm", 24, 80, ssh.TerminalModes{}); err != nil {
+				t.Fatal(err)
+			}
+			session.Stdin = strings.NewReader("exit 0\n")
+			if err := session.Shell(); err != nil {
+				t.Fatal(err)
+			}
+			if err := session.Wait(); err != nil {
+				t.Fatal(err)
+			}
+		}, "Session started", true},
+		{"failing command", func(t *testing.T, client *ssh.Client) {
+			session, err := client.NewSession()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer session.Close()
+			if err := session.Run("exit 3"); err == nil {
+				t.Fatal("command succeeded, want exit status 3")
+			}
+		}, "Started exec session", true},
+		{"forward", func(t *testing.T, client *ssh.Client) {
+			conn, err := client.Dial("tcp", startEchoServer(t))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			if _, err := conn.Write([]byte("x")); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := io.ReadFull(conn, make([]byte, 1)); err != nil {
+				t.Fatal(err)
+			}
+		}, "Proxy connection established", false},
+		{"refused forward", func(t *testing.T, client *ssh.Client) {
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			closed := l.Addr().String()
+			l.Close()
+			if conn, err := client.Dial("tcp", closed); err == nil {
+				conn.Close()
+				t.Fatal("forward to a closed port succeeded")
+			}
+		}, "Failed to open proxy connection", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := captureLogs(t)
+			srv, addr := startTestServer(t, &ServerConfig{WarmProxies: -1})
+			before := serverGoroutines()
+
+			client := dialTestServer(t, addr, "demo", newTestSigner(t))
+			tt.run(t, client)
+			client.Close()
+
+			// Every goroutine serving the connection, and so logging for
+			// it, is done
+			deadline := time.Now().Add(5 * time.Second)
+			for serverGoroutines() > before || srv.Connections() != 0 {
+				if time.Now().After(deadline) {
+					t.Fatal("connection still being served after the client closed it")
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			var connID string
+			seen := false
+			for _, r := range logs() {
+				if !strings.Contains(r.fn, "/internal/sshserver.") || slices.Contains(sharedMessages, r.msg) {
+					continue
+				}
+				id, ok := r.attrs["conn.id"]
+				if !ok {
+					t.Errorf("%q logged without conn.id: %v", r.msg, r.attrs)
+					continue
+				}
+				if connID == "" {
+					connID = id
+				} else if id != connID {
+					t.Errorf("%q logged with conn.id %s, want %s", r.msg, id, connID)
+				}
+				if got := r.attrs["sprite.name"]; got != "demo" {
+					t.Errorf("%q logged with sprite.name %q, want %q", r.msg, got, "demo")
+				}
+				_, inSession := r.attrs["session.id"]
+				if r.msg == tt.wantMessage {
+					seen = true
+					if inSession != tt.session {
+						t.Errorf("%q logged with session.id %v, want %v", r.msg, inSession, tt.session)
+					}
+				}
+				for key := range r.attrs {
+					if strings.HasPrefix(key, "session.") && !inSession {
+						t.Errorf("%q logged with %s but no session.id", r.msg, key)
+					}
+				}
+			}
+			if !seen {
+				t.Errorf("%q wasn't logged", tt.wantMessage)
+			}
+		})
+	}
+}
@@ -0,0 +1,206 @@
+package sshserver
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/vaurdan/sprite-bootstrap/internal/config"
+
+	"github.com/superfly/sprites-go"
+)
+
+// errAmbiguousSprite is returned for a sprite name found in several
+// search organizations
+var errAmbiguousSprite = errors.New("sprite name is ambiguous")
+
+// errOrgNotServed is returned for an organization outside the default and
+// search organizations
+var errOrgNotServed = errors.New("organization is not served here")
+
+// errOrgCredentials is returned when a search organization's credentials
+// can't be resolved
+var errOrgCredentials = errors.New("no credentials for organization")
+
+// maxSearchOrgs bounds how many other organizations a lookup tries
+const maxSearchOrgs = 8
+
+// orgRoute is an organization sprites are reached through: its credentials,
+// policy and API client
+type orgRoute struct {
+	org       string
+	apiURL    string
+	authToken string
+	policy    *config.Policy
+	client    *sprites.Client
+}
+
+func newOrgRoute(tokens *TokenOptions, tlsConfig *tls.Config, proxy proxyFunc) *orgRoute {
+	return &orgRoute{
+		org:       tokens.Organization,
+		apiURL:    tokens.API,
+		authToken: tokens.AuthToken,
+		policy:    tokens.Policy,
+		client:    sprites.New(tokens.AuthToken, clientOptions(tokens.API, tlsConfig, proxy)...),
+	}
+}
+
+// orgRouter finds the organization a sprite belongs to. Sprites are looked
+// up in the default organization first, then in the search organizations,
+// whose credentials are resolved on first use. Where a sprite was found is
+// remembered for the life of the process.
+type orgRouter struct {
+	search    []string
+	tlsConfig *tls.Config
+	proxy     proxyFunc
+
+	mu     sync.Mutex
+	def    *orgRoute
+	routes map[string]*orgRoute // By organization, resolved so far
+	homes  map[string]string    // Sprite name to the organization it was found in
+}
+
+func newOrgRouter(def *orgRoute, search []string, tlsConfig *tls.Config, proxy proxyFunc) *orgRouter {
+	r := &orgRouter{
+		def:       def,
+		tlsConfig: tlsConfig,
+		proxy:     proxy,
+		routes:    map[string]*orgRoute{def.org: def},
+		homes:     make(map[string]string),
+	}
+	for _, org := range search {
+		if org != def.org && len(r.search) < maxSearchOrgs {
+			r.search = append(r.search, org)
+		}
+	}
+	return r
+}
+
+// defRoute returns the default organization's route, which is replaced
+// when its credentials are reloaded
+func (r *orgRouter) defRoute() *orgRoute {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.def
+}
+
+// parseUser splits an SSH username into the sprite name and the
+// organization given as sprite@org or org/sprite, if any
+func parseUser(user string) (name, org string) {
+	if org, name, ok := strings.Cut(user, "/"); ok {
+		return name, org
+	}
+	name, org, _ = strings.Cut(user, "@")
+	return name, org
+}
+
+// SplitSpriteUser returns the sprite name and organization an SSH username
+// selects, the inverse of SpriteUser
+func SplitSpriteUser(user string) (name, org string) {
+	return parseUser(user)
+}
+
+// SpriteUser returns the SSH username that selects a sprite in an
+// organization, or just the sprite name when org is empty
+func SpriteUser(name, org string) string {
+	if org == "" {
+		return name
+	}
+	return name + "@" + org
+}
+
+// route returns the route for an organization, resolving its credentials
+// the first time. Only the default and search organizations are served.
+func (r *orgRouter) route(org string) (*orgRoute, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if route, ok := r.routes[org]; ok {
+		return route, nil
+	}
+	if !slices.Contains(r.search, org) {
+		return nil, fmt.Errorf("%w: %s (serve is limited to %s)", errOrgNotServed, org, r.def.org)
+	}
+	tokens := &TokenOptions{API: r.def.apiURL, Organization: org}
+	if err := tokens.Resolve(); err != nil {
+		return nil, fmt.Errorf("%w %s: %w", errOrgCredentials, org, err)
+	}
+	route := newOrgRoute(tokens, r.tlsConfig, r.proxy)
+	r.routes[org] = route
+	return route, nil
+}
+
+// lookup finds the sprite for an SSH username and the route it belongs to
+func (r *orgRouter) lookup(ctx context.Context, user string) (*sprites.Sprite, *orgRoute, error) {
+	name, org := parseUser(user)
+	if org == "" {
+		r.mu.Lock()
+		org = r.homes[name]
+		r.mu.Unlock()
+	}
+
+	// An explicit or remembered organization is the only one tried
+	if org != "" {
+		route, err := r.route(org)
+		if err != nil {
+			return nil, nil, err
+		}
+		sprite, err := route.client.GetSprite(ctx, name)
+		if err != nil {
+			return nil, nil, err
+		}
+		r.remember(name, org)
+		return sprite, route, nil
+	}
+
+	def := r.defRoute()
+	sprite, err := def.client.GetSprite(ctx, name)
+	if err == nil || len(r.search) == 0 {
+		return sprite, def, err
+	}
+
+	// Not in the default organization: every search organization is tried,
+	// so a name that exists in several is reported instead of guessed
+	var (
+		found     *sprites.Sprite
+		foundIn   *orgRoute
+		foundOrgs []string
+	)
+	for _, org := range r.search {
+		route, rerr := r.route(org)
+		if rerr != nil {
+			logger(ctx).WarnContext(ctx, "Skipping organization in sprite lookup",
+				"org", org,
+				"exception", rerr)
+			continue
+		}
+		s, gerr := route.client.GetSprite(ctx, name)
+		if gerr != nil {
+			continue
+		}
+		found, foundIn = s, route
+		foundOrgs = append(foundOrgs, org)
+	}
+
+	switch len(foundOrgs) {
+	case 0:
+		return nil, nil, err
+	case 1:
+		r.remember(name, foundIn.org)
+		return found, foundIn, nil
+	default:
+		return nil, nil, fmt.Errorf("%w: %s is in %s; connect as %s@<org>",
+			errAmbiguousSprite, name, strings.Join(foundOrgs, ", "), name)
+	}
+}
+
+// remember records the organization a sprite was found in
+func (r *orgRouter) remember(name, org string) {
+	r.mu.Lock()
+	r.homes[name] = org
+	r.mu.Unlock()
+}
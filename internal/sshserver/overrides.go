@@ -0,0 +1,149 @@
+package sshserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/superfly/sprites-go"
+)
+
+// Reserved env request names that control the session instead of being
+// passed to the sprite
+const (
+	envRetries  = "SPRITE_RETRIES"  // Reconnection attempts after the first, capped at the server max
+	envShell    = "SPRITE_SHELL"    // Shell to use, from the server's allow-list
+	envKeepWarm = "SPRITE_KEEPWARM" // Whether the connection keeps the sprite awake
+	envCwd      = "SPRITE_CWD"      // Absolute working directory on the sprite
+	envWrapper  = "SPRITE_WRAPPER"  // Command wrapper for this session, or "none"
+	envRawExec  = "SPRITE_RAW_EXEC" // Whether exec requests skip the shell's -c
+)
+
+// sessionOverrides holds the control parameters a client sent
+type sessionOverrides struct {
+	retries *int
+	shell   string
+	cwd     string
+
+	// wrapper replaces the sprite's command wrapper when wrapperSet; nil
+	// runs the command unwrapped
+	wrapper    []string
+	wrapperSet bool
+
+	// rawExec replaces ServerConfig.RawExec when set
+	rawExec *bool
+}
+
+// applyOverride interprets a reserved env request. It returns false for
+// names that aren't reserved, which are passed through to the sprite.
+func (s *session) applyOverride(ctx context.Context, name, value string) (bool, error) {
+	var err error
+	switch name {
+	case envRetries:
+		n, perr := strconv.Atoi(value)
+		if perr != nil || n < 0 {
+			err = fmt.Errorf("%s must be a non-negative integer", name)
+			break
+		}
+		s.overrides.retries = &n
+	case envShell:
+		if !slices.Contains(s.conn.allowedShells, value) && value != s.conn.shell {
+			err = fmt.Errorf("%s %q is not allowed by the server", name, value)
+			break
+		}
+		s.overrides.shell = value
+	case envKeepWarm:
+		on, perr := strconv.ParseBool(value)
+		if perr != nil {
+			err = fmt.Errorf("%s must be a boolean", name)
+			break
+		}
+		s.conn.keepWarm.Store(on)
+	case envCwd:
+		if !path.IsAbs(value) {
+			err = fmt.Errorf("%s must be an absolute path", name)
+			break
+		}
+		s.overrides.cwd = path.Clean(value)
+	case envWrapper:
+		argv, perr := parseWrapper(value)
+		if perr != nil {
+			err = fmt.Errorf("%s: %w", name, perr)
+			break
+		}
+		s.overrides.wrapper, s.overrides.wrapperSet = argv, true
+	case envRawExec:
+		on, perr := strconv.ParseBool(value)
+		if perr != nil {
+			err = fmt.Errorf("%s must be a boolean", name)
+			break
+		}
+		s.overrides.rawExec = &on
+	default:
+		return false, nil
+	}
+
+	if err != nil {
+		logger(ctx).WarnContext(ctx, "Rejected session override",
+			"name", name,
+			"exception", err)
+		return true, err
+	}
+	logger(ctx).InfoContext(ctx, "Applied session override",
+		"name", name,
+		"value", value)
+	return true, nil
+}
+
+// attempts returns how many times the session may run its command, given
+// the server's limit
+func (o *sessionOverrides) attempts(serverMax int) int {
+	if o.retries == nil {
+		return serverMax
+	}
+	return max(1, min(*o.retries+1, serverMax))
+}
+
+// raw returns whether exec requests run without the shell, given the
+// server's setting
+func (o *sessionOverrides) raw(serverRaw bool) bool {
+	if o.rawExec == nil {
+		return serverRaw
+	}
+	return *o.rawExec
+}
+
+// checkCwd makes sure the SPRITE_CWD the client asked for is a directory
+// on the sprite. If it isn't, the command starts in the sprite's default
+// directory (the user's home) instead, and TTY sessions are told why.
+func (s *session) checkCwd(ctx context.Context) {
+	if s.overrides.cwd == "" {
+		return
+	}
+
+	checkCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	cmd := s.sprite.Load().CommandContext(checkCtx, "test", "-d", s.overrides.cwd)
+	cmd.Stdout = io.Discard
+	cmd.Stderr = io.Discard
+
+	var exit *sprites.ExitError
+	err := cmd.Run()
+	if err == nil || !errors.As(err, &exit) {
+		// Errors other than test's own are left for the command to report
+		return
+	}
+
+	logger(ctx).WarnContext(ctx, "Working directory not found on sprite, using home",
+		"cwd", s.overrides.cwd)
+	if s.tty {
+		fmt.Fprintf(s.ch, "\r\n\033[33m[sprite] %s not found, starting in home directory\033[0m\r\n", s.overrides.cwd)
+	}
+	s.overrides.cwd = ""
+}
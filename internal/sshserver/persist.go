@@ -0,0 +1,142 @@
+package sshserver
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/superfly/sprites-go"
+)
+
+// Holders that keep interactive shells running on the sprite across
+// reconnects
+const (
+	persistTmux   = "tmux"
+	persistScreen = "screen"
+)
+
+// checkPersist validates ServerConfig.Persist
+func checkPersist(holder string) error {
+	switch holder {
+	case "", persistTmux, persistScreen:
+		return nil
+	}
+	return fmt.Errorf("unknown persistent session holder %q (use tmux or screen)", holder)
+}
+
+// hasHolder reports whether the holder is installed on the sprite, probing
+// once per connection. Inconclusive probes are not cached.
+func (c *sshConn) hasHolder(ctx context.Context, sprite *sprites.Sprite) (bool, error) {
+	c.holderMu.Lock()
+	defer c.holderMu.Unlock()
+
+	if c.holderChecked {
+		return c.holderFound, nil
+	}
+
+	probeCtx, cancel := context.WithTimeout(ctx, shellProbeTimeout)
+	defer cancel()
+
+	cmd := sprite.CommandContext(probeCtx, fallbackShell, "-c", `command -v "$1"`, "sh", c.srv.persist)
+	cmd.Stdout = io.Discard
+	cmd.Stderr = io.Discard
+	err := cmd.Run()
+	var exit *sprites.ExitError
+	if err != nil && !errors.As(err, &exit) {
+		return false, err
+	}
+	c.holderChecked, c.holderFound = true, err == nil
+	return c.holderFound, nil
+}
+
+// holderSession names the session's holder on the sprite. The name stays
+// the same for the SSH connection, so the retry loop reattaches to it.
+func (s *session) holderSession() string {
+	id := s.conn.state.id
+	if len(id) > 12 {
+		id = id[:12]
+	}
+	return fmt.Sprintf("sprite-%s-%d", id, s.id)
+}
+
+// usePersist runs an interactive shell under the configured holder, when
+// it's installed on the sprite. Without it the shell runs as usual.
+func (s *session) usePersist(ctx context.Context) {
+	holder := s.conn.srv.persist
+	found, err := s.conn.hasHolder(ctx, s.sprite.Load())
+	if err != nil {
+		logger(ctx).DebugContext(ctx, "Persistent session probe failed", "holder", holder, "exception", err)
+		return
+	}
+	if !found {
+		logger(ctx).WarnContext(ctx, "Persistent session holder not found on sprite",
+			"holder", holder)
+		if s.tty {
+			fmt.Fprintf(s.ch, "\r\n\033[33m[sprite] %s not found, the shell won't survive reconnects\033[0m\r\n", holder)
+		}
+		return
+	}
+
+	s.holder = s.holderSession()
+	logger(ctx).InfoContext(ctx, "Running shell in persistent session",
+		"holder", holder,
+		"holder.session", s.holder)
+}
+
+// holderArgv runs argv in the session's holder, attaching to it if it
+// already exists
+func (s *session) holderArgv(argv []string) []string {
+	var holder []string
+	switch s.conn.srv.persist {
+	case persistTmux:
+		holder = []string{"tmux", "new-session", "-A", "-s", s.holder}
+	case persistScreen:
+		holder = []string{"screen", "-D", "-R", "-S", s.holder}
+	}
+	return append(holder, argv...)
+}
+
+// Scripts run on the sprite once the client of a persistent shell exited
+// normally, with the holder session as $1. A session still running a shell
+// was detached from and is left for a later attach, reporting "running";
+// one whose shell is gone is removed.
+const (
+	tmuxCleanup = `tmux has-session -t "=$1" 2>/dev/null || exit 0
+if tmux list-panes -s -t "=$1" -F '#{pane_dead}' | grep -qx 0; then echo running; exit 0; fi
+tmux kill-session -t "=$1"`
+	screenCleanup = `case $(screen -ls "$1" 2>/dev/null) in
+*".$1"[[:space:]]*Dead*) screen -wipe "$1" >/dev/null ;;
+*".$1"[[:space:]]*) echo running ;;
+esac`
+)
+
+// endPersist removes the holder session after its client exited, unless
+// the client detached and the shell still runs in it. Usually the session
+// ended with the shell already.
+func (s *session) endPersist(ctx context.Context) {
+	checkCtx, cancel := context.WithTimeout(ctx, shellProbeTimeout)
+	defer cancel()
+
+	script := tmuxCleanup
+	if s.conn.srv.persist == persistScreen {
+		script = screenCleanup
+	}
+	var out bytes.Buffer
+	cmd := s.sprite.Load().CommandContext(checkCtx, fallbackShell, "-c", script, "sh", s.holder)
+	cmd.Stdout = &out
+	cmd.Stderr = io.Discard
+	if err := cmd.Run(); err != nil {
+		logger(ctx).DebugContext(ctx, "Persistent session cleanup failed",
+			"holder.session", s.holder,
+			"exception", err)
+		return
+	}
+	if strings.TrimSpace(out.String()) == "running" {
+		logger(ctx).InfoContext(ctx, "Left detached persistent session running",
+			"holder", s.conn.srv.persist,
+			"holder.session", s.holder)
+	}
+}
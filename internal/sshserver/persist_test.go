@@ -0,0 +1,306 @@
+package sshserver
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"golang.org/x/crypto/ssh"
+)
+
+// localTmux points tmux at a server of the test's own, which the fake
+// sprites also use, and returns a function listing its sessions
+func localTmux(t *testing.T) func() []string {
+	t.Helper()
+	if _, err := exec.LookPath("tmux"); err != nil {
+		t.Skip("tmux not installed")
+	}
+	// Kept short: tmux's socket path must fit in a sockaddr
+	dir, err := os.MkdirTemp("", "tmux")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TMUX_TMPDIR", dir)
+	t.Setenv("TMUX", "")
+	t.Cleanup(func() {
+		exec.Command("tmux", "kill-server").Run()
+		os.RemoveAll(dir)
+	})
+	return func() []string {
+		out, _ := exec.Command("tmux", "list-sessions", "-F", "#{session_name}").Output()
+		return strings.Fields(string(out))
+	}
+}
+
+// terminal collects what a session prints
+type terminal struct {
+	mu   sync.Mutex
+	out  strings.Builder
+	done bool
+}
+
+func newTerminal(r io.Reader) *terminal {
+	term := &terminal{}
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			n, err := r.Read(buf)
+			term.mu.Lock()
+			term.out.Write(buf[:n])
+			term.done = err != nil
+			term.mu.Unlock()
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return term
+}
+
+// waitFor waits until the session printed s
+func (term *terminal) waitFor(t *testing.T, s string) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		term.mu.Lock()
+		out, done := term.out.String(), term.done
+		term.mu.Unlock()
+		if strings.Contains(out, s) {
+			return
+		}
+		if done || time.Now().After(deadline) {
+			t.Fatalf("session never printed %q; output:\n%q", s, out)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// startPersistentShell opens an interactive shell with a TTY, which the
+// server runs under tmux, and waits until it takes commands
+func startPersistentShell(t *testing.T, client *ssh.Client) (*ssh.Session, io.Writer, *terminal) {
+	t.Helper()
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { session.Close() })
+	if err := session.RequestPty("xterm", 24, 80, ssh.TerminalModes{}); err != nil {
+		t.Fatal(err)
+	}
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := session.Shell(); err != nil {
+		t.Fatal(err)
+	}
+	term := newTerminal(stdout)
+	// Quoted so the echo of what was typed doesn't match
+	io.WriteString(stdin, "echo sh''ell-ready\r")
+	term.waitFor(t, "shell-ready")
+	return session, stdin, term
+}
+
+// waitExit waits for the session's client to exit with status 0
+func waitExit(t *testing.T, session *ssh.Session) {
+	t.Helper()
+	done := make(chan error, 1)
+	go func() { done <- session.Wait() }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("session ended with %v, want exit status 0", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("session still running")
+	}
+}
+
+// TestPersistEnd checks which ways of leaving a persistent shell keep its
+// tmux session on the sprite
+func TestPersistEnd(t *testing.T) {
+	type step struct {
+		keys string // Typed into the shell
+		wait string // Printed in response
+	}
+	tests := []struct {
+		name         string
+		remainOnExit bool
+		steps        []step
+		drop         bool // The SSH connection dies instead
+		wantKept     bool
+	}{
+		{name: "exit", steps: []step{{"exit\r", ""}}},
+		{name: "detach", steps: []step{{"\x02d", "detached"}}, wantKept: true},
+		{name: "detach from exited shell", remainOnExit: true, steps: []step{{"exit\r", "[dead]"}, {"\x02d", "detached"}}},
+		{name: "client drops", drop: true, wantKept: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sessions := localTmux(t)
+			api := newFakeAPI(t)
+			if tt.remainOnExit {
+				home := filepath.Join(api.Root, "demo")
+				if err := os.MkdirAll(home, 0o755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(filepath.Join(home, ".tmux.conf"), []byte("set -g remain-on-exit on\n"), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			_, addr := startTestServer(t, &ServerConfig{
+				TokenOptions: testTokenOptions(t, api),
+				Persist:      persistTmux,
+			})
+			before := serverGoroutines()
+
+			raw, err := net.Dial("tcp", addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer raw.Close()
+			sshConn, chans, reqs, err := ssh.NewClientConn(raw, addr, &ssh.ClientConfig{
+				User:            "demo",
+				Auth:            []ssh.AuthMethod{ssh.PublicKeys(newTestSigner(t))},
+				HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+				Timeout:         10 * time.Second,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			session, stdin, term := startPersistentShell(t, ssh.NewClient(sshConn, chans, reqs))
+			running := sessions()
+			if len(running) != 1 || !strings.HasPrefix(running[0], "sprite-") {
+				t.Fatalf("tmux sessions = %q, want the shell's", running)
+			}
+
+			for _, s := range tt.steps {
+				io.WriteString(stdin, s.keys)
+				if s.wait != "" {
+					term.waitFor(t, s.wait)
+				}
+			}
+			if !tt.drop {
+				waitExit(t, session)
+			}
+			raw.Close()
+
+			// Once every goroutine of the session is done, so is cleanup
+			deadline := time.Now().Add(10 * time.Second)
+			for serverGoroutines() > before {
+				if time.Now().After(deadline) {
+					t.Fatalf("%d server goroutines left after the session, had %d", serverGoroutines(), before)
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			left := sessions()
+			if kept := slices.Equal(left, running); kept != tt.wantKept {
+				t.Errorf("tmux sessions after the client left = %q, want kept %v", left, tt.wantKept)
+			}
+		})
+	}
+}
+
+// dropExecAPI serves the fake sprites API, handing out the connections of
+// execs of command so a test can drop them
+type dropExecAPI struct {
+	http.Handler
+	command string
+	conns   chan net.Conn
+}
+
+func (a *dropExecAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if websocket.IsWebSocketUpgrade(r) && slices.Contains(r.URL.Query()["cmd"], a.command) {
+		w = &hijackRecorder{ResponseWriter: w, conns: a.conns}
+	}
+	a.Handler.ServeHTTP(w, r)
+}
+
+// hijackRecorder passes on the connection its response hijacks
+type hijackRecorder struct {
+	http.ResponseWriter
+	conns chan net.Conn
+}
+
+func (h *hijackRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	c, rw, err := h.ResponseWriter.(http.Hijacker).Hijack()
+	if err == nil {
+		h.conns <- c
+	}
+	return c, rw, err
+}
+
+// TestPersistReconnect drops the connection to the sprite under a
+// persistent shell, then attaches to the shell again from a new session
+func TestPersistReconnect(t *testing.T) {
+	sessions := localTmux(t)
+	api := &dropExecAPI{Handler: newFakeAPI(t), command: "new-session", conns: make(chan net.Conn, 1)}
+	_, addr := startTestServer(t, &ServerConfig{
+		TokenOptions: testTokenOptions(t, api),
+		Persist:      persistTmux,
+	})
+	client := dialTestServer(t, addr, "demo", newTestSigner(t))
+	session, stdin, term := startPersistentShell(t, client)
+	running := sessions()
+	if len(running) != 1 {
+		t.Fatalf("tmux sessions = %q, want the shell's", running)
+	}
+
+	io.WriteString(stdin, "x=still-here; echo x-''set\r")
+	term.waitFor(t, "x-set")
+	(<-api.conns).Close()
+	// The sprites client reports a dropped TTY exec as status 1
+	done := make(chan error, 1)
+	go func() { done <- session.Wait() }()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("session still running after its sprite connection dropped")
+	}
+	if left := sessions(); !slices.Equal(left, running) {
+		t.Fatalf("tmux sessions after the drop = %q, want %q kept", left, running)
+	}
+
+	again, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer again.Close()
+	if err := again.RequestPty("xterm", 24, 80, ssh.TerminalModes{}); err != nil {
+		t.Fatal(err)
+	}
+	stdin, err = again.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, err := again.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := again.Start("exec tmux attach -t " + running[0]); err != nil {
+		t.Fatal(err)
+	}
+	term = newTerminal(stdout)
+	io.WriteString(stdin, "echo \"$x\"-again\r")
+	term.waitFor(t, "still-here-again")
+
+	io.WriteString(stdin, "exit\r")
+	waitExit(t, again)
+	if left := sessions(); len(left) != 0 {
+		t.Errorf("tmux sessions after exit = %q, want none", left)
+	}
+}
@@ -0,0 +1,68 @@
+package sshserver
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// FindPortOwner finds the process listening on a TCP port by matching the
+// socket inode from /proc/net/tcp{,6} against open file descriptors. Only
+// processes we can inspect (usually our own) are found.
+func FindPortOwner(port int) (PortOwner, bool) {
+	inodes := make(map[string]bool)
+	for _, table := range []string{"/proc/net/tcp", "/proc/net/tcp6"} {
+		listeningInodes(table, port, inodes)
+	}
+	if len(inodes) == 0 {
+		return PortOwner{}, false
+	}
+
+	procs, _ := filepath.Glob("/proc/[0-9]*")
+	for _, proc := range procs {
+		fds, err := os.ReadDir(filepath.Join(proc, "fd"))
+		if err != nil {
+			continue
+		}
+		for _, fd := range fds {
+			link, err := os.Readlink(filepath.Join(proc, "fd", fd.Name()))
+			if err != nil || !strings.HasPrefix(link, "socket:[") {
+				continue
+			}
+			if inodes[strings.TrimSuffix(strings.TrimPrefix(link, "socket:["), "]")] {
+				pid, _ := strconv.Atoi(filepath.Base(proc))
+				comm, _ := os.ReadFile(filepath.Join(proc, "comm"))
+				return PortOwner{PID: pid, Name: strings.TrimSpace(string(comm))}, true
+			}
+		}
+	}
+	return PortOwner{}, false
+}
+
+// listeningInodes adds the inodes of sockets listening on port in a
+// /proc/net/tcp style table
+func listeningInodes(table string, port int, inodes map[string]bool) {
+	f, err := os.Open(table)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	const stateListen = "0A"
+	wantPort := fmt.Sprintf(":%04X", port)
+
+	scanner := bufio.NewScanner(f)
+	scanner.Scan() // Header
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 {
+			continue
+		}
+		if strings.HasSuffix(fields[1], wantPort) && fields[3] == stateListen {
+			inodes[fields[9]] = true
+		}
+	}
+}
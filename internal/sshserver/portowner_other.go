@@ -0,0 +1,34 @@
+//go:build !linux && !windows
+
+package sshserver
+
+import (
+	"context"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FindPortOwner finds the process listening on a TCP port using lsof
+func FindPortOwner(port int) (PortOwner, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	out, err := exec.CommandContext(ctx, "lsof", "-nP", "-iTCP:"+strconv.Itoa(port), "-sTCP:LISTEN", "-Fpc").Output()
+	if err != nil {
+		return PortOwner{}, false
+	}
+
+	// -F output is one field per line: p<pid>, then c<command>
+	var owner PortOwner
+	for _, line := range strings.Split(string(out), "\n") {
+		switch {
+		case strings.HasPrefix(line, "p") && owner.PID == 0:
+			owner.PID, _ = strconv.Atoi(line[1:])
+		case strings.HasPrefix(line, "c") && owner.Name == "":
+			owner.Name = line[1:]
+		}
+	}
+	return owner, owner.PID != 0
+}
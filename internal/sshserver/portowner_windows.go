@@ -0,0 +1,46 @@
+//go:build windows
+
+package sshserver
+
+import (
+	"context"
+	"encoding/csv"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FindPortOwner finds the process listening on a TCP port using netstat
+// and tasklist
+func FindPortOwner(port int) (PortOwner, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	out, err := exec.CommandContext(ctx, "netstat", "-ano", "-p", "TCP").Output()
+	if err != nil {
+		return PortOwner{}, false
+	}
+
+	suffix := ":" + strconv.Itoa(port)
+	pid := 0
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 5 && strings.HasSuffix(fields[1], suffix) && fields[3] == "LISTENING" {
+			pid, _ = strconv.Atoi(fields[4])
+			break
+		}
+	}
+	if pid == 0 {
+		return PortOwner{}, false
+	}
+
+	owner := PortOwner{PID: pid, Name: "unknown"}
+	out, err = exec.CommandContext(ctx, "tasklist", "/FI", "PID eq "+strconv.Itoa(pid), "/FO", "CSV", "/NH").Output()
+	if err == nil {
+		if rec, err := csv.NewReader(strings.NewReader(string(out))).Read(); err == nil && len(rec) > 0 {
+			owner.Name = rec[0]
+		}
+	}
+	return owner, true
+}
@@ -0,0 +1,342 @@
+package sshserver
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/vaurdan/sprite-bootstrap/internal/ratelimit"
+
+	"github.com/gorilla/websocket"
+	"github.com/superfly/sprites-go"
+)
+
+// proxyInitMessage is the initial message sent to establish a proxy
+type proxyInitMessage struct {
+	Host string `json:"host"`
+	Port int    `json:"port"`
+}
+
+// proxyResponseMessage is the response from establishing a proxy
+type proxyResponseMessage struct {
+	Status string `json:"status"`
+	Target string `json:"target"`
+}
+
+// proxyURL builds the WebSocket URL for a sprite's proxy endpoint
+func proxyURL(apiURL, spriteName string) (*url.URL, error) {
+	baseURL := apiURL
+
+	// Convert HTTP(S) to WS(S)
+	if strings.HasPrefix(baseURL, "http") {
+		baseURL = "ws" + baseURL[4:]
+	}
+
+	// Parse base URL
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base URL: %w", err)
+	}
+
+	// Build path
+	u.Path = fmt.Sprintf("/v1/sprites/%s/proxy", spriteName)
+
+	return u, nil
+}
+
+// defaultWSBufferSize is the default read and write buffer size of each
+// proxy WebSocket. Messages larger than the buffer still work; they just
+// take more than one read or write call.
+const defaultWSBufferSize = 64 * 1024
+
+// bufferPool pools the buffers forwards copy through, so data moving in
+// either direction doesn't allocate per read or per WebSocket message
+type bufferPool struct {
+	size int
+	pool sync.Pool
+	// inUse counts buffers taken from the pool, for the debug dump
+	inUse atomic.Int64
+}
+
+func newBufferPool(size int) *bufferPool {
+	p := &bufferPool{size: size}
+	p.pool.New = func() any {
+		buf := make([]byte, size)
+		return &buf
+	}
+	return p
+}
+
+func (p *bufferPool) get() *[]byte {
+	p.inUse.Add(1)
+	return p.pool.Get().(*[]byte)
+}
+
+func (p *bufferPool) put(buf *[]byte) {
+	p.inUse.Add(-1)
+	p.pool.Put(buf)
+}
+
+// limitedWriter writes to w within a rate limit
+type limitedWriter struct {
+	ctx   context.Context
+	w     io.Writer
+	limit *ratelimit.Limiter
+}
+
+func (lw *limitedWriter) Write(p []byte) (int, error) {
+	if err := lw.limit.WaitN(lw.ctx, len(p)); err != nil {
+		return 0, err
+	}
+	return lw.w.Write(p)
+}
+
+// errProxyRefused is returned when the proxy couldn't connect to the
+// destination inside the sprite
+var errProxyRefused = errors.New("proxy connection failed")
+
+// dialProxy opens a proxy WebSocket to host:port as seen from inside the
+// sprite, returning the connection and the target the proxy reports. A nil
+// tlsConfig uses the defaults; a nil proxy connects directly.
+func dialProxy(ctx context.Context, apiURL, authToken, spriteName, host string, port, bufferSize int, compress bool, tlsConfig *tls.Config, proxy proxyFunc) (*websocket.Conn, string, error) {
+	wsConn, err := dialProxyWS(ctx, apiURL, authToken, spriteName, bufferSize, compress, tlsConfig, proxy)
+	if err != nil {
+		return nil, "", err
+	}
+	target, err := initProxy(wsConn, host, port, 0)
+	if err != nil {
+		return nil, "", err
+	}
+	return wsConn, target, nil
+}
+
+// dialProxyWS opens a proxy WebSocket to the sprite without choosing a
+// destination yet. With compress, permessage-deflate is offered; a proxy
+// that doesn't accept it gets an uncompressed connection instead.
+func dialProxyWS(ctx context.Context, apiURL, authToken, spriteName string, bufferSize int, compress bool, tlsConfig *tls.Config, proxy proxyFunc) (*websocket.Conn, error) {
+	wsURL, err := proxyURL(apiURL, spriteName)
+	if err != nil {
+		return nil, err
+	}
+
+	// Set up WebSocket dialer
+	dialer := &websocket.Dialer{
+		ReadBufferSize:    bufferSize,
+		WriteBufferSize:   bufferSize,
+		EnableCompression: compress,
+	}
+	scheme := "http"
+	if wsURL.Scheme == "wss" {
+		scheme = "https"
+		dialer.TLSClientConfig = tlsConfig
+		if dialer.TLSClientConfig == nil {
+			dialer.TLSClientConfig = &tls.Config{
+				InsecureSkipVerify: false,
+			}
+		}
+	}
+	dialer.NetDialContext = func(ctx context.Context, _, addr string) (net.Conn, error) {
+		return dialVia(ctx, proxy, scheme, addr)
+	}
+
+	// Set headers including auth
+	header := http.Header{}
+	header.Set("Authorization", fmt.Sprintf("Bearer %s", authToken))
+	header.Set("User-Agent", "github.com/vaurdan/sprite-bootstrap/1.0")
+
+	wsConn, resp, err := dialer.DialContext(ctx, wsURL.String(), header)
+	if compress && err != nil && strings.Contains(err.Error(), "invalid compression negotiation") {
+		// The proxy answered with deflate parameters the WebSocket library
+		// can't use, such as context takeover
+		logger(ctx).DebugContext(ctx, "Proxy compression negotiation failed, connecting uncompressed", "exception", err)
+		dialer.EnableCompression = false
+		wsConn, resp, err = dialer.DialContext(ctx, wsURL.String(), header)
+	}
+	if err != nil {
+		if errors.Is(err, websocket.ErrBadHandshake) && resp != nil {
+			// The status tells shouldRetry a waking sprite from a bad request
+			return nil, fmt.Errorf("connect to proxy: %w (%s)", err, resp.Status)
+		}
+		return nil, fmt.Errorf("connect to proxy: %w", err)
+	}
+	if dialer.EnableCompression {
+		if strings.Contains(resp.Header.Get("Sec-WebSocket-Extensions"), "permessage-deflate") {
+			// Favour latency: forwarded data is compressed as it streams
+			_ = wsConn.SetCompressionLevel(flate.BestSpeed)
+		} else {
+			logger(ctx).DebugContext(ctx, "Proxy didn't accept compression, connecting uncompressed")
+		}
+	}
+	return wsConn, nil
+}
+
+// initProxy sends a proxy WebSocket the destination host and port and
+// returns the target the proxy reports. A nonzero timeout bounds the
+// exchange. The connection is closed on failure.
+func initProxy(wsConn *websocket.Conn, host string, port int, timeout time.Duration) (string, error) {
+	if timeout > 0 {
+		_ = wsConn.SetWriteDeadline(time.Now().Add(timeout))
+		_ = wsConn.SetReadDeadline(time.Now().Add(timeout))
+		defer func() {
+			_ = wsConn.SetWriteDeadline(time.Time{})
+			_ = wsConn.SetReadDeadline(time.Time{})
+		}()
+	}
+
+	// Send initialization message with destination host and port
+	if err := wsConn.WriteJSON(&proxyInitMessage{Host: host, Port: port}); err != nil {
+		wsConn.Close()
+		return "", fmt.Errorf("send proxy init message: %w", err)
+	}
+
+	var response proxyResponseMessage
+	if err := wsConn.ReadJSON(&response); err != nil {
+		wsConn.Close()
+		return "", fmt.Errorf("read proxy response: %w", err)
+	}
+	if response.Status != "connected" {
+		wsConn.Close()
+		return "", fmt.Errorf("%w: %s", errProxyRefused, response.Status)
+	}
+	return response.Target, nil
+}
+
+// writeFrames sends data as binary messages of at most maxSize bytes each.
+// A maxSize of zero sends a single message.
+func writeFrames(wsConn *websocket.Conn, data []byte, maxSize int) error {
+	if maxSize <= 0 {
+		return wsConn.WriteMessage(websocket.BinaryMessage, data)
+	}
+	for len(data) > 0 {
+		n := min(len(data), maxSize)
+		if err := wsConn.WriteMessage(websocket.BinaryMessage, data[:n]); err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	return nil
+}
+
+// DefaultProbeSizes are the message sizes tried by ProbeFrameSizes
+var DefaultProbeSizes = []int{512, 1024, 1400, 2048, 4096, 8192, 16384, 32768, 65536, 262144, 1048576}
+
+// FrameProbe is the result of echoing one message size through the proxy
+type FrameProbe struct {
+	Size    int
+	OK      bool
+	Elapsed time.Duration
+	Err     error
+}
+
+// echoServerScript listens on a free loopback port, prints it, and echoes
+// back everything the first client sends
+const echoServerScript = `import socket
+s = socket.socket()
+s.bind(("127.0.0.1", 0))
+s.listen(1)
+print(s.getsockname()[1], flush=True)
+c, _ = s.accept()
+while True:
+    d = c.recv(65536)
+    if not d:
+        break
+    c.sendall(d)
+`
+
+// ProbeFrameSizes echoes messages of increasing size through the sprite's
+// proxy endpoint against a loopback echo server started on the sprite. It
+// stops at the first size that doesn't come back intact within timeout,
+// since the connection is unusable after that.
+func ProbeFrameSizes(ctx context.Context, tokenOpts *TokenOptions, sprite *sprites.Sprite, sizes []int, timeout time.Duration) ([]FrameProbe, error) {
+	echoCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	cmd := sprite.CommandContext(echoCtx, "python3", "-c", echoServerScript)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("start echo server on sprite: %w", err)
+	}
+	defer cmd.Wait()
+
+	line, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("start echo server on sprite (is python3 installed?): %w", err)
+	}
+	port, err := strconv.Atoi(strings.TrimSpace(line))
+	if err != nil {
+		return nil, fmt.Errorf("unexpected echo server output %q", line)
+	}
+
+	wsConn, _, err := dialProxy(ctx, tokenOpts.API, tokenOpts.AuthToken, sprite.Name(), "127.0.0.1", port, defaultWSBufferSize, false, nil, proxyFromEnvironment)
+	if err != nil {
+		return nil, err
+	}
+	defer wsConn.Close()
+
+	var results []FrameProbe
+	for _, size := range sizes {
+		result := echoFrame(wsConn, size, timeout)
+		results = append(results, result)
+		if !result.OK {
+			break
+		}
+	}
+	return results, nil
+}
+
+// echoFrame sends one message of the given size and waits for all of it to
+// come back
+func echoFrame(wsConn *websocket.Conn, size int, timeout time.Duration) FrameProbe {
+	result := FrameProbe{Size: size}
+
+	payload := make([]byte, size)
+	rand.Read(payload)
+
+	start := time.Now()
+	deadline := start.Add(timeout)
+	wsConn.SetWriteDeadline(deadline)
+	wsConn.SetReadDeadline(deadline)
+
+	if err := wsConn.WriteMessage(websocket.BinaryMessage, payload); err != nil {
+		result.Err = err
+		return result
+	}
+
+	// The echo may come back split across several messages
+	var got []byte
+	for len(got) < size {
+		messageType, data, err := wsConn.ReadMessage()
+		if err != nil {
+			result.Err = err
+			return result
+		}
+		if messageType == websocket.BinaryMessage {
+			got = append(got, data...)
+		}
+	}
+
+	if !bytes.Equal(got[:size], payload) {
+		result.Err = fmt.Errorf("echoed data differs")
+		return result
+	}
+	result.OK = true
+	result.Elapsed = time.Since(start)
+	return result
+}
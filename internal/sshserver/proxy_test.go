@@ -0,0 +1,232 @@
+package sshserver
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// wsPair returns the two ends of a WebSocket: the one a forward reads with
+// bufferSize buffers, and the sprite's proxy end
+func wsPair(tb testing.TB, bufferSize int) (forward, sprite *websocket.Conn) {
+	tb.Helper()
+	accepted := make(chan *websocket.Conn, 1)
+	upgrader := websocket.Upgrader{ReadBufferSize: bufferSize, WriteBufferSize: bufferSize}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		accepted <- conn
+	}))
+	tb.Cleanup(ts.Close)
+
+	dialer := websocket.Dialer{ReadBufferSize: bufferSize, WriteBufferSize: bufferSize}
+	forward, _, err := dialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	sprite = <-accepted
+	tb.Cleanup(func() {
+		forward.Close()
+		sprite.Close()
+	})
+	return forward, sprite
+}
+
+// memChannel is an SSH channel whose client sends input and keeps what it
+// receives, counting it
+type memChannel struct {
+	input   io.Reader
+	closed  chan struct{}
+	once    sync.Once
+	keep    bool
+	mu      sync.Mutex
+	got     bytes.Buffer
+	written atomic.Int64
+	wrote   chan struct{} // Signalled on each write
+}
+
+func newMemChannel(input string, keep bool) *memChannel {
+	return &memChannel{
+		input:  strings.NewReader(input),
+		closed: make(chan struct{}),
+		keep:   keep,
+		wrote:  make(chan struct{}, 1),
+	}
+}
+
+// Read returns the input, then blocks until the channel is closed
+func (c *memChannel) Read(p []byte) (int, error) {
+	if n, _ := c.input.Read(p); n > 0 {
+		return n, nil
+	}
+	<-c.closed
+	return 0, io.EOF
+}
+
+func (c *memChannel) Write(p []byte) (int, error) {
+	if c.keep {
+		c.mu.Lock()
+		c.got.Write(p)
+		c.mu.Unlock()
+	}
+	c.written.Add(int64(len(p)))
+	select {
+	case c.wrote <- struct{}{}:
+	default:
+	}
+	return len(p), nil
+}
+
+func (c *memChannel) Close() error {
+	c.once.Do(func() { close(c.closed) })
+	return nil
+}
+
+func (c *memChannel) CloseWrite() error                              { return nil }
+func (c *memChannel) SendRequest(string, bool, []byte) (bool, error) { return false, nil }
+func (c *memChannel) Stderr() io.ReadWriter                          { return new(bytes.Buffer) }
+
+// received returns what the client received, if kept
+func (c *memChannel) received() []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return bytes.Clone(c.got.Bytes())
+}
+
+// waitFor waits until the client received n bytes
+func (c *memChannel) waitFor(n int64) {
+	for c.written.Load() < n {
+		<-c.wrote
+	}
+}
+
+// testForwardConn returns a connection of a server whose forwards copy
+// through buffers of bufferSize
+func testForwardConn(bufferSize int) *sshConn {
+	return &sshConn{srv: &Server{copyBuffers: newBufferPool(bufferSize)}}
+}
+
+// TestPipeForward sends data both ways through a forward whose buffers are
+// smaller than the messages
+func TestPipeForward(t *testing.T) {
+	forward, sprite := wsPair(t, defaultWSBufferSize)
+	ch := newMemChannel("request", true)
+	c := testForwardConn(8)
+
+	done := make(chan struct{})
+	var in, out atomic.Int64
+	go func() {
+		defer close(done)
+		c.pipeForward(context.Background(), nil, "demo", "127.0.0.1:80", ch, forward, 0, &in, &out)
+	}()
+
+	// The client's input arrives as binary messages
+	var request []byte
+	for len(request) < len("request") {
+		kind, data, err := sprite.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if kind != websocket.BinaryMessage {
+			t.Fatalf("message type %d, want binary", kind)
+		}
+		request = append(request, data...)
+	}
+	if string(request) != "request" {
+		t.Errorf("sprite got %q, want %q", request, "request")
+	}
+
+	// Binary messages reach the client in order; text messages don't
+	response := []string{"first message", strings.Repeat("long ", 100), "last"}
+	for i, msg := range response {
+		if err := sprite.WriteMessage(websocket.BinaryMessage, []byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			if err := sprite.WriteMessage(websocket.TextMessage, []byte("not data")); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	want := strings.Join(response, "")
+	ch.waitFor(int64(len(want)))
+	msg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+	if err := sprite.WriteMessage(websocket.CloseMessage, msg); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+
+	if got := string(ch.received()); got != want {
+		t.Errorf("client got %q, want %q", got, want)
+	}
+	if in.Load() != int64(len("request")) || out.Load() != int64(len(want)) {
+		t.Errorf("counted %d bytes in and %d out, want %d and %d", in.Load(), out.Load(), len("request"), len(want))
+	}
+	if n := c.srv.copyBuffers.inUse.Load(); n != 0 {
+		t.Errorf("%d copy buffers not returned to the pool", n)
+	}
+}
+
+// BenchmarkForwardDownload streams 32 KiB messages from the sprite to the
+// client. ReadMessage is the loop pipeForward replaced, which allocated a
+// slice per message, for comparison.
+func BenchmarkForwardDownload(b *testing.B) {
+	const size = 32 << 10
+	msg := bytes.Repeat([]byte("x"), size)
+
+	run := func(b *testing.B, forward func(ch *memChannel, wsConn *websocket.Conn)) {
+		wsConn, sprite := wsPair(b, defaultWSBufferSize)
+		ch := newMemChannel("", false)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			forward(ch, wsConn)
+		}()
+
+		b.SetBytes(size)
+		b.ReportAllocs()
+		b.ResetTimer()
+		for range b.N {
+			if err := sprite.WriteMessage(websocket.BinaryMessage, msg); err != nil {
+				b.Fatal(err)
+			}
+		}
+		ch.waitFor(int64(b.N) * size)
+		b.StopTimer()
+
+		sprite.Close()
+		<-done
+	}
+
+	b.Run("pipeForward", func(b *testing.B) {
+		c := testForwardConn(defaultWSBufferSize)
+		run(b, func(ch *memChannel, wsConn *websocket.Conn) {
+			var in, out atomic.Int64
+			c.pipeForward(context.Background(), nil, "demo", "127.0.0.1:80", ch, wsConn, 0, &in, &out)
+		})
+	})
+	b.Run("ReadMessage", func(b *testing.B) {
+		run(b, func(ch *memChannel, wsConn *websocket.Conn) {
+			defer ch.Close()
+			for {
+				kind, data, err := wsConn.ReadMessage()
+				if err != nil {
+					return
+				}
+				if kind == websocket.BinaryMessage {
+					ch.Write(data)
+				}
+			}
+		})
+	})
+}
@@ -0,0 +1,147 @@
+package sshserver
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+)
+
+// errProtocolInterrupted marks a failure after a protocol command (scp and
+// the like) started streaming. Re-running it would replay a stream the
+// client has already partly sent, so it isn't retried.
+var errProtocolInterrupted = errors.New("protocol stream interrupted")
+
+// errNotPlainWords is returned by splitWords for commands that need a shell
+var errNotPlainWords = errors.New("command uses shell syntax")
+
+// splitWords splits a command line the way sh would, as long as it is only
+// words: quoting and backslash escapes are understood, but expansions,
+// globs, redirections and operators are rejected with errNotPlainWords
+func splitWords(command string) ([]string, error) {
+	return tokenize(command, true)
+}
+
+// splitArgv splits a command line into argv for raw exec. Quoting and
+// backslash escapes are understood like splitWords, but every other
+// character, including $, globs and operators, is kept literally.
+func splitArgv(command string) ([]string, error) {
+	return tokenize(command, false)
+}
+
+// tokenize splits command into words. With plain set, shell syntax other
+// than quoting is rejected with errNotPlainWords.
+func tokenize(command string, plain bool) ([]string, error) {
+	var (
+		words   []string
+		word    strings.Builder
+		inWord  bool
+		escaped bool
+		quote   rune
+	)
+	for _, r := range command {
+		switch {
+		case escaped:
+			word.WriteRune(r)
+			escaped = false
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case quote == '"':
+			switch r {
+			case '"':
+				quote = 0
+			case '\\':
+				escaped = true
+			case '$', '`':
+				if plain {
+					return nil, errNotPlainWords
+				}
+				word.WriteRune(r)
+			default:
+				word.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inWord = true
+		case r == '\\':
+			escaped = true
+			inWord = true
+		case r == ' ' || r == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		case plain && strings.ContainsRune("|&;<>()$`*?[#~\n", r):
+			return nil, errNotPlainWords
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 || escaped {
+		return nil, errors.New("unterminated quote or escape")
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// protocolCommand returns the argv of an exec request that runs a remote
+// file transfer endpoint (`scp -t`, `scp -f` or `rsync --server`). Those
+// speak a binary protocol on stdin/stdout, so they are run directly rather
+// than through the user's shell, whose startup files may print to stdout.
+func protocolCommand(command string) ([]string, bool) {
+	argv, err := splitWords(command)
+	if err != nil || len(argv) == 0 {
+		return nil, false
+	}
+
+	switch path.Base(argv[0]) {
+	case "scp":
+		for _, arg := range argv[1:] {
+			if strings.HasPrefix(arg, "-") && strings.ContainsAny(arg, "tf") {
+				return argv, true
+			}
+		}
+	case "rsync":
+		for _, arg := range argv[1:] {
+			if arg == "--server" {
+				return argv, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// withoutDefaultEnv drops the default environment sessions start with,
+// leaving only what the client asked for
+func withoutDefaultEnv(env, defaults []string) []string {
+	var out []string
+	for _, kv := range env {
+		if !slices.Contains(defaults, kv) {
+			out = append(out, kv)
+		}
+	}
+	return out
+}
+
+// rawArgv returns the argv of an exec request in raw mode, which runs the
+// command without the shell's -c: no expansion, globbing, pipes or
+// redirection, so what the client quoted reaches the program as is
+func rawArgv(command string) ([]string, error) {
+	argv, err := splitArgv(command)
+	if err != nil {
+		return nil, fmt.Errorf("raw exec: %w", err)
+	}
+	if len(argv) == 0 {
+		return nil, errors.New("raw exec: empty command")
+	}
+	return argv, nil
+}
@@ -0,0 +1,151 @@
+package sshserver
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os/exec"
+	"slices"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestProtocolCommand(t *testing.T) {
+	tests := []struct {
+		command string
+		want    []string
+	}{
+		{"scp -t /tmp/", []string{"scp", "-t", "/tmp/"}},
+		{"scp -v -f 'my file.txt'", []string{"scp", "-v", "-f", "my file.txt"}},
+		{"/usr/bin/scp -pt dir", []string{"/usr/bin/scp", "-pt", "dir"}},
+		{"rsync --server -vlogDtpre.iLsfxC . /tmp/x", []string{"rsync", "--server", "-vlogDtpre.iLsfxC", ".", "/tmp/x"}},
+		{"scp -r a b", nil},         // Not a transfer endpoint
+		{"rsync -av a b", nil},      // Client side of rsync
+		{"scp -t $HOME", nil},       // Needs the shell
+		{"scp -t a; rm -rf b", nil}, // Needs the shell
+		{"ls -la", nil},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		got, ok := protocolCommand(tt.command)
+		if ok != (tt.want != nil) || !slices.Equal(got, tt.want) {
+			t.Errorf("protocolCommand(%q) = %q, %v; want %q", tt.command, got, ok, tt.want)
+		}
+	}
+}
+
+func TestRawArgv(t *testing.T) {
+	tests := []struct {
+		command string
+		want    []string
+		wantErr bool
+	}{
+		{"echo $HOME", []string{"echo", "$HOME"}, false},
+		{`printf '%s\n' "a b" c\ d`, []string{"printf", `%s\n`, "a b", "c d"}, false},
+		{"ls *.go | wc", []string{"ls", "*.go", "|", "wc"}, false},
+		{`echo "unterminated`, nil, true},
+		{"   ", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := rawArgv(tt.command)
+		if (err != nil) != tt.wantErr || !slices.Equal(got, tt.want) {
+			t.Errorf("rawArgv(%q) = %q, %v; want %q (error %v)", tt.command, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+// scpSession runs an scp endpoint command on the test server, returning
+// the session and its stdin and stdout
+func scpSession(t *testing.T, client *ssh.Client, command string) (*ssh.Session, io.WriteCloser, *bufio.Reader) {
+	t.Helper()
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := session.Start(command); err != nil {
+		t.Fatal(err)
+	}
+	return session, stdin, bufio.NewReader(stdout)
+}
+
+// scpAck reads one scp acknowledgement, failing on anything but success
+func scpAck(t *testing.T, r *bufio.Reader) {
+	t.Helper()
+	b, err := r.ReadByte()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b != 0 {
+		msg, _ := r.ReadString('\n')
+		t.Fatalf("scp refused: %q", msg)
+	}
+}
+
+func TestSCPThroughExec(t *testing.T) {
+	// The fake sprite runs commands on this machine
+	if _, err := exec.LookPath("scp"); err != nil {
+		t.Skip("scp not installed")
+	}
+	_, addr := startTestServer(t, &ServerConfig{})
+	client := dialTestServer(t, addr, "demo", newTestSigner(t))
+	const content = "hello over scp\n"
+
+	// Upload
+	session, stdin, stdout := scpSession(t, client, "scp -t upload.txt")
+	scpAck(t, stdout)
+	io.WriteString(stdin, "C0644 15 upload.txt\n")
+	scpAck(t, stdout)
+	io.WriteString(stdin, content+"\x00")
+	scpAck(t, stdout)
+	stdin.Close()
+	if err := session.Wait(); err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+
+	// Download
+	session, stdin, stdout = scpSession(t, client, "scp -f upload.txt")
+	stdin.Write([]byte{0})
+	header, err := stdout.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(header, "C0644 15 upload.txt") {
+		t.Fatalf("download header = %q", header)
+	}
+	stdin.Write([]byte{0})
+	data := make([]byte, len(content))
+	if _, err := io.ReadFull(stdout, data); err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != content {
+		t.Errorf("downloaded %q, want %q", data, content)
+	}
+	scpAck(t, stdout)
+	stdin.Write([]byte{0})
+	stdin.Close()
+	if err := session.Wait(); err != nil {
+		t.Fatalf("download: %v", err)
+	}
+
+	// A failed transfer reports scp's exit status
+	session, stdin, stdout = scpSession(t, client, "scp -f missing.txt")
+	stdin.Write([]byte{0})
+	if b, err := stdout.ReadByte(); err != nil || b != 1 {
+		t.Errorf("missing file: reply %d, %v; want an error reply", b, err)
+	}
+	stdin.Close()
+	var exit *ssh.ExitError
+	if err := session.Wait(); !errors.As(err, &exit) || exit.ExitStatus() != 1 {
+		t.Errorf("missing file: Wait() = %v, want exit status 1", err)
+	}
+}
@@ -0,0 +1,461 @@
+package sshserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/vaurdan/sprite-bootstrap/internal/ratelimit"
+)
+
+// Registry tracks the server's live connections so their state can be
+// inspected. It holds no credentials.
+type Registry struct {
+	mu    sync.Mutex
+	conns map[string]*connState
+
+	// forwards counts active port forwards across all connections
+	forwards atomic.Int64
+
+	// active counts SSH connections past the handshake, including ones
+	// being refused over the limit
+	active atomic.Int64
+
+	// auth counts authentication attempts per remote IP
+	auth authCounter
+
+	// open holds the forward channels with data flowing, by ID, so they
+	// can be listed and closed one at a time
+	openMu      sync.Mutex
+	open        map[string]*forwardState
+	nextForward int
+}
+
+// forwardState is one forward channel with data flowing through it
+type forwardState struct {
+	id       string
+	conn     *connState
+	kind     string
+	origin   string
+	dest     string
+	started  time.Time
+	bytesIn  *atomic.Int64
+	bytesOut *atomic.Int64
+	close    func()
+}
+
+// connState is the tracked state of one SSH connection
+type connState struct {
+	id      string
+	sprite  string
+	remote  string
+	started time.Time
+	traffic *countingConn
+	close   func()
+
+	sessions       atomic.Int64
+	forwards       atomic.Int64
+	remoteForwards atomic.Int64
+
+	mu          sync.Mutex
+	nextSession int
+	retries     map[int]RetrySnapshot
+	deadlines   map[int]time.Time // Session lifetime deadlines
+}
+
+func newRegistry() *Registry {
+	return &Registry{
+		conns: make(map[string]*connState),
+		open:  make(map[string]*forwardState),
+	}
+}
+
+// addConn starts tracking a connection. close ends the connection.
+func (r *Registry) addConn(id, sprite, remote string, traffic *countingConn, close func()) *connState {
+	st := &connState{
+		id:        id,
+		sprite:    sprite,
+		remote:    remote,
+		started:   time.Now(),
+		traffic:   traffic,
+		close:     close,
+		retries:   make(map[int]RetrySnapshot),
+		deadlines: make(map[int]time.Time),
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.conns[id] = st
+	return st
+}
+
+// removeConn stops tracking a connection
+func (r *Registry) removeConn(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.conns, id)
+}
+
+// acquireForward counts a new port forward on the connection, unless that
+// would exceed perConn forwards on it or total across the server (zero
+// means no limit)
+func (r *Registry) acquireForward(st *connState, perConn, total int) bool {
+	n := st.forwards.Add(1)
+	t := r.forwards.Add(1)
+	if (perConn > 0 && n > int64(perConn)) || (total > 0 && t > int64(total)) {
+		r.releaseForward(st)
+		return false
+	}
+	return true
+}
+
+// releaseForward ends a forward counted by acquireForward
+func (r *Registry) releaseForward(st *connState) {
+	st.forwards.Add(-1)
+	r.forwards.Add(-1)
+}
+
+// addForward starts tracking a forward channel of the connection. close
+// must end just that channel. The returned ID names it to CloseForward.
+func (r *Registry) addForward(st *connState, kind, origin, dest string, bytesIn, bytesOut *atomic.Int64, close func()) string {
+	r.openMu.Lock()
+	defer r.openMu.Unlock()
+	r.nextForward++
+	id := fmt.Sprintf("ssh-%d", r.nextForward)
+	r.open[id] = &forwardState{
+		id:       id,
+		conn:     st,
+		kind:     kind,
+		origin:   origin,
+		dest:     dest,
+		started:  time.Now(),
+		bytesIn:  bytesIn,
+		bytesOut: bytesOut,
+		close:    close,
+	}
+	return id
+}
+
+// removeForward stops tracking a forward channel once it has ended
+func (r *Registry) removeForward(id string) {
+	r.openMu.Lock()
+	defer r.openMu.Unlock()
+	delete(r.open, id)
+}
+
+// newSession returns the next session number on the connection
+func (st *connState) newSession() int {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.nextSession++
+	return st.nextSession
+}
+
+// setRetry records that a session is retrying its sprite command
+func (st *connState) setRetry(session, attempt, maxRetries int) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	r, ok := st.retries[session]
+	if !ok {
+		r = RetrySnapshot{Session: session, Since: time.Now()}
+	}
+	r.Attempt, r.MaxRetries = attempt, maxRetries
+	st.retries[session] = r
+}
+
+// clearRetry records that a session is no longer retrying
+func (st *connState) clearRetry(session int) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	delete(st.retries, session)
+}
+
+// setDeadline records when a session reaches its maximum lifetime
+func (st *connState) setDeadline(session int, deadline time.Time) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.deadlines[session] = deadline
+}
+
+// clearDeadline forgets a session's deadline once it has ended
+func (st *connState) clearDeadline(session int) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	delete(st.deadlines, session)
+}
+
+// Snapshot is a point-in-time view of the server's internal state
+type Snapshot struct {
+	Time        time.Time      `json:"time"`
+	PendingAuth []PendingAuth  `json:"pending_auth"`
+	Auth        []AuthSnapshot `json:"auth"`
+	Connections []ConnSnapshot `json:"connections"`
+	Goroutines  int            `json:"goroutines"`
+	Memory      MemSnapshot    `json:"memory"`
+
+	// Forwards are the forward channels with data flowing
+	Forwards []ForwardSnapshot `json:"forwards"`
+
+	// Maps holds the size of each long-lived map, to spot growth
+	Maps map[string]int `json:"maps"`
+}
+
+// PendingAuth is a sprite looked up during authentication that the
+// connection hasn't picked up yet
+type PendingAuth struct {
+	Sprite string `json:"sprite"`
+	Remote string `json:"remote"`
+}
+
+// ConnSnapshot describes one SSH connection
+type ConnSnapshot struct {
+	ID             string          `json:"id"`
+	Sprite         string          `json:"sprite"`
+	Remote         string          `json:"remote"`
+	Started        time.Time       `json:"started"`
+	Sessions       int64           `json:"sessions"`
+	Forwards       int64           `json:"forwards"`
+	RemoteForwards int64           `json:"remote_forwards"`
+	BytesIn        int64           `json:"bytes_in"`
+	BytesOut       int64           `json:"bytes_out"`
+	Retries        []RetrySnapshot `json:"retries,omitempty"`
+
+	// Lifetimes are the deadlines of sessions with a maximum lifetime
+	Lifetimes []LifetimeSnapshot `json:"lifetimes,omitempty"`
+}
+
+// LifetimeSnapshot describes how long a session has left to live
+type LifetimeSnapshot struct {
+	Session   int       `json:"session"`
+	Deadline  time.Time `json:"deadline"`
+	Remaining string    `json:"remaining"`
+}
+
+// RetrySnapshot describes a session retrying its sprite command
+type RetrySnapshot struct {
+	Session    int       `json:"session"`
+	Attempt    int       `json:"attempt"`
+	MaxRetries int       `json:"max_retries"`
+	Since      time.Time `json:"since"`
+}
+
+// ForwardSnapshot describes a forward channel with data flowing
+type ForwardSnapshot struct {
+	ID       string    `json:"id"`
+	Conn     string    `json:"conn"`
+	Sprite   string    `json:"sprite"`
+	Kind     string    `json:"kind"`
+	Origin   string    `json:"origin"`
+	Dest     string    `json:"dest"`
+	Started  time.Time `json:"started"`
+	BytesIn  int64     `json:"bytes_in"`
+	BytesOut int64     `json:"bytes_out"`
+
+	// LimitUp and LimitDown are the server's rate limits the forward shares,
+	// in bytes per second; zero when unlimited
+	LimitUp   int64 `json:"limit_up,omitempty"`
+	LimitDown int64 `json:"limit_down,omitempty"`
+}
+
+// MemSnapshot holds the interesting parts of runtime.MemStats, plus an
+// estimate of the memory held by forward buffers
+type MemSnapshot struct {
+	HeapAlloc  uint64 `json:"heap_alloc"`
+	HeapInuse  uint64 `json:"heap_inuse"`
+	Sys        uint64 `json:"sys"`
+	NumGC      uint32 `json:"num_gc"`
+	PauseTotal string `json:"pause_total"`
+
+	// Attribution estimates bytes held per category: "websocket_buffers"
+	// for forward proxy connections and "copy_buffers" for pooled copy
+	// buffers in use
+	Attribution map[string]int64 `json:"attribution"`
+}
+
+// connections returns a snapshot of every tracked connection, oldest first
+func (r *Registry) connections() []ConnSnapshot {
+	r.mu.Lock()
+	states := make([]*connState, 0, len(r.conns))
+	for _, st := range r.conns {
+		states = append(states, st)
+	}
+	r.mu.Unlock()
+
+	snaps := make([]ConnSnapshot, 0, len(states))
+	for _, st := range states {
+		cs := ConnSnapshot{
+			ID:             st.id,
+			Sprite:         st.sprite,
+			Remote:         st.remote,
+			Started:        st.started,
+			Sessions:       st.sessions.Load(),
+			Forwards:       st.forwards.Load(),
+			RemoteForwards: st.remoteForwards.Load(),
+			BytesIn:        st.traffic.in.Load(),
+			BytesOut:       st.traffic.out.Load(),
+		}
+		st.mu.Lock()
+		for _, r := range st.retries {
+			cs.Retries = append(cs.Retries, r)
+		}
+		for id, deadline := range st.deadlines {
+			cs.Lifetimes = append(cs.Lifetimes, LifetimeSnapshot{
+				Session:   id,
+				Deadline:  deadline,
+				Remaining: time.Until(deadline).Round(time.Second).String(),
+			})
+		}
+		st.mu.Unlock()
+		sort.Slice(cs.Retries, func(i, j int) bool { return cs.Retries[i].Session < cs.Retries[j].Session })
+		sort.Slice(cs.Lifetimes, func(i, j int) bool { return cs.Lifetimes[i].Session < cs.Lifetimes[j].Session })
+		snaps = append(snaps, cs)
+	}
+
+	sort.Slice(snaps, func(i, j int) bool {
+		if !snaps[i].Started.Equal(snaps[j].Started) {
+			return snaps[i].Started.Before(snaps[j].Started)
+		}
+		return snaps[i].ID < snaps[j].ID
+	})
+	return snaps
+}
+
+// Forwards returns the forward channels with data flowing, oldest first
+func (srv *Server) Forwards() []ForwardSnapshot {
+	srv.registry.openMu.Lock()
+	snaps := make([]ForwardSnapshot, 0, len(srv.registry.open))
+	for _, f := range srv.registry.open {
+		snaps = append(snaps, ForwardSnapshot{
+			ID:        f.id,
+			Conn:      f.conn.id,
+			Sprite:    f.conn.sprite,
+			Kind:      f.kind,
+			Origin:    f.origin,
+			Dest:      f.dest,
+			Started:   f.started,
+			BytesIn:   f.bytesIn.Load(),
+			BytesOut:  f.bytesOut.Load(),
+			LimitUp:   srv.limitUp.Rate(),
+			LimitDown: srv.limitDown.Rate(),
+		})
+	}
+	srv.registry.openMu.Unlock()
+
+	sort.Slice(snaps, func(i, j int) bool {
+		if !snaps[i].Started.Equal(snaps[j].Started) {
+			return snaps[i].Started.Before(snaps[j].Started)
+		}
+		return snaps[i].ID < snaps[j].ID
+	})
+	return snaps
+}
+
+// CloseForward closes one forward channel, leaving the rest of its SSH
+// connection up. It reports whether the forward was found; one already
+// being closed is not, so only the first of several requests succeeds.
+func (srv *Server) CloseForward(id string) bool {
+	srv.registry.openMu.Lock()
+	f, ok := srv.registry.open[id]
+	delete(srv.registry.open, id)
+	srv.registry.openMu.Unlock()
+	if !ok {
+		return false
+	}
+	slog.Info("Closing forward on request",
+		"forward.id", id,
+		"sprite.name", f.conn.sprite,
+		"dest", f.dest)
+	f.close()
+	return true
+}
+
+// CloseConn ends one SSH connection with all its sessions and forwards.
+// It reports whether the connection was found.
+func (srv *Server) CloseConn(id string) bool {
+	srv.registry.mu.Lock()
+	st, ok := srv.registry.conns[id]
+	srv.registry.mu.Unlock()
+	if !ok {
+		return false
+	}
+	slog.Info("Closing connection on request",
+		"conn.id", id,
+		"sprite.name", st.sprite,
+		"conn.addr", st.remote)
+	st.close()
+	return true
+}
+
+// RateLimits returns the bytes per second port forwards may send to and
+// receive from sprites, together; zero when unlimited
+func (srv *Server) RateLimits() (up, down int64) {
+	return srv.limitUp.Rate(), srv.limitDown.Rate()
+}
+
+// SetRateLimits changes the limits of RateLimits, for forwards already
+// running too
+func (srv *Server) SetRateLimits(up, down int64) {
+	srv.limitUp.SetRate(up)
+	srv.limitDown.SetRate(down)
+	slog.Info("Changed forward rate limits", "limit_up", ratelimit.Format(up), "limit_down", ratelimit.Format(down))
+}
+
+// Snapshot returns the current state of the server
+func (srv *Server) Snapshot() Snapshot {
+	snap := Snapshot{
+		Time:        time.Now(),
+		PendingAuth: []PendingAuth{},
+		Auth:        srv.registry.auth.snapshot(),
+		Connections: srv.registry.connections(),
+		Forwards:    srv.Forwards(),
+		Goroutines:  runtime.NumGoroutine(),
+		Maps:        srv.mapSizes(),
+	}
+
+	srv.sprites.Range(func(_, v any) bool {
+		p := v.(pendingAuth)
+		snap.PendingAuth = append(snap.PendingAuth, PendingAuth{Sprite: p.user, Remote: p.remote})
+		return true
+	})
+	sort.Slice(snap.PendingAuth, func(i, j int) bool {
+		return snap.PendingAuth[i].Sprite+snap.PendingAuth[i].Remote < snap.PendingAuth[j].Sprite+snap.PendingAuth[j].Remote
+	})
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	snap.Memory = MemSnapshot{
+		HeapAlloc:  mem.HeapAlloc,
+		HeapInuse:  mem.HeapInuse,
+		Sys:        mem.Sys,
+		NumGC:      mem.NumGC,
+		PauseTotal: time.Duration(mem.PauseTotalNs).String(),
+		Attribution: map[string]int64{
+			"websocket_buffers": (srv.registry.forwards.Load() + int64(srv.warm.len())) * 2 * int64(srv.wsBufferSize),
+			"copy_buffers":      srv.copyBuffers.inUse.Load() * int64(srv.copyBuffers.size),
+		},
+	}
+	return snap
+}
+
+// WriteDump writes a JSON snapshot to a timestamped file in dir and returns
+// its path
+func (srv *Server) WriteDump(dir string) (string, error) {
+	snap := srv.Snapshot()
+	data, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(dir, fmt.Sprintf("serve-dump-%s.json", snap.Time.Format("20060102-150405")))
+	if err := os.WriteFile(path, append(data, '\n'), 0600); err != nil {
+		return "", err
+	}
+	return path, nil
+}
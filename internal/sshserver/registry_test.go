@@ -0,0 +1,127 @@
+package sshserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestRegistrySnapshotConcurrent(t *testing.T) {
+	r := newRegistry()
+	const workers = 20
+
+	// Snapshots are taken while connections come and go
+	stop := make(chan struct{})
+	snapshots := make(chan struct{})
+	go func() {
+		defer close(snapshots)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				r.connections()
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			id := fmt.Sprintf("conn-%02d", i)
+			st := r.addConn(id, "demo", "127.0.0.1:1", &countingConn{}, func() {})
+			st.sessions.Add(1)
+			for attempt := 1; attempt <= 10; attempt++ {
+				st.setRetry(1, attempt, 10)
+			}
+			st.setDeadline(1, time.Now().Add(time.Hour))
+			if i%2 == 0 {
+				st.clearRetry(1)
+				st.clearDeadline(1)
+				r.removeConn(id)
+			}
+		}()
+	}
+	wg.Wait()
+	close(stop)
+	<-snapshots
+
+	conns := r.connections()
+	if len(conns) != workers/2 {
+		t.Fatalf("%d connections, want %d", len(conns), workers/2)
+	}
+	for _, c := range conns {
+		tests := []struct {
+			name string
+			ok   bool
+		}{
+			{"odd connections stay", c.ID[len(c.ID)-1]%2 == 1},
+			{"sprite", c.Sprite == "demo"},
+			{"sessions", c.Sessions == 1},
+			{"last retry", len(c.Retries) == 1 && c.Retries[0].Attempt == 10 && c.Retries[0].MaxRetries == 10},
+			{"lifetime", len(c.Lifetimes) == 1 && c.Lifetimes[0].Session == 1},
+		}
+		for _, tt := range tests {
+			if !tt.ok {
+				t.Errorf("%s: %s: got %+v", c.ID, tt.name, c)
+			}
+		}
+	}
+}
+
+func TestSnapshotConnections(t *testing.T) {
+	srv, addr := startTestServer(t, &ServerConfig{})
+
+	const n = 5
+	var clients []*ssh.Client
+	for range n {
+		clients = append(clients, dialTestServer(t, addr, "demo", newTestSigner(t)))
+	}
+
+	snap := srv.Snapshot()
+	if len(snap.Connections) != n {
+		t.Fatalf("snapshot has %d connections, want %d", len(snap.Connections), n)
+	}
+	for _, c := range snap.Connections {
+		if c.Sprite != "demo" || c.BytesIn == 0 || c.BytesOut == 0 {
+			t.Errorf("connection %+v: want sprite demo with traffic", c)
+		}
+	}
+	if snap.Maps["connections"] != n || len(snap.PendingAuth) != 0 {
+		t.Errorf("maps = %v, pending = %v; want %d connections and nothing pending", snap.Maps, snap.PendingAuth, n)
+	}
+
+	path, err := srv.WriteDump(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dumped Snapshot
+	if err := json.Unmarshal(data, &dumped); err != nil {
+		t.Fatal(err)
+	}
+	if len(dumped.Connections) != n {
+		t.Errorf("dump has %d connections, want %d", len(dumped.Connections), n)
+	}
+
+	for _, client := range clients {
+		client.Close()
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(srv.Snapshot().Connections) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("connections still tracked after closing: %+v", srv.Snapshot().Connections)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,369 @@
+package sshserver
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/superfly/sprites-go"
+	"github.com/vaurdan/sprite-bootstrap/internal/telemetry"
+	"golang.org/x/crypto/ssh"
+)
+
+// defaultMaxRemoteForwards is used when ServerConfig.MaxRemoteForwards is zero
+const defaultMaxRemoteForwards = 10
+
+// remoteListenTimeout bounds starting the listener on the sprite
+var remoteListenTimeout = 30 * time.Second
+
+// remoteListenerScript listens on the address in argv ("unix <path>" for a
+// Unix socket, replacing a stale one and created with mode 0600) and, for
+// each connection it accepts, opens a one-shot loopback port and prints it
+// ("conn <port> <origin host> <origin port>") so the server can reach the
+// connection through the sprite's proxy. It exits, removing its socket, when
+// stdin closes or it is terminated, so it never outlives the SSH connection.
+const remoteListenerScript = `import os, signal, socket, sys, threading
+out = threading.Lock()
+sock = None
+def cleanup(*_):
+    if sock:
+        try:
+            os.unlink(sock)
+        except OSError:
+            pass
+    os._exit(0)
+def say(*words):
+    with out:
+        print(*words, flush=True)
+def pipe(a, b):
+    try:
+        while True:
+            d = a.recv(65536)
+            if not d:
+                break
+            b.sendall(d)
+    except OSError:
+        pass
+    try:
+        b.shutdown(socket.SHUT_WR)
+    except OSError:
+        pass
+def bridge(c, origin):
+    s = socket.socket()
+    s.bind(("127.0.0.1", 0))
+    s.listen(1)
+    s.settimeout(30)
+    say("conn", s.getsockname()[1], origin[0], origin[1])
+    try:
+        p, _ = s.accept()
+    except OSError:
+        c.close()
+        return
+    finally:
+        s.close()
+    t = threading.Thread(target=pipe, args=(p, c), daemon=True)
+    t.start()
+    pipe(c, p)
+    t.join()
+    c.close()
+    p.close()
+def watch_stdin():
+    sys.stdin.read()
+    cleanup()
+signal.signal(signal.SIGTERM, cleanup)
+signal.signal(signal.SIGHUP, cleanup)
+host, port = sys.argv[1], sys.argv[2]
+if host == "unix":
+    os.makedirs(os.path.dirname(port), mode=0o700, exist_ok=True)
+    if os.path.lexists(port):
+        os.unlink(port)
+    l = socket.socket(socket.AF_UNIX)
+    old = os.umask(0o177)
+    l.bind(port)
+    os.umask(old)
+    sock = port
+    say("port", 0)
+else:
+    l = socket.socket(socket.AF_INET6 if ":" in host else socket.AF_INET)
+    l.setsockopt(socket.SOL_SOCKET, socket.SO_REUSEADDR, 1)
+    l.bind((host, int(port)))
+    say("port", l.getsockname()[1])
+l.listen(64)
+threading.Thread(target=watch_stdin, daemon=True).start()
+while True:
+    c, origin = l.accept()
+    if not isinstance(origin, tuple):
+        origin = ("unix", 0)
+    threading.Thread(target=bridge, args=(c, origin), daemon=True).start()
+`
+
+// tcpipForwardRequest is the payload of tcpip-forward and
+// cancel-tcpip-forward requests
+type tcpipForwardRequest struct {
+	BindAddr string
+	BindPort uint32
+}
+
+// forwardedTCPIPChannelData is the payload for forwarded-tcpip channels
+type forwardedTCPIPChannelData struct {
+	DestAddr   string
+	DestPort   uint32
+	OriginAddr string
+	OriginPort uint32
+}
+
+// spriteListener is a listener running remoteListenerScript on the sprite
+type spriteListener struct {
+	port   uint32 // The port actually bound; 0 for Unix sockets
+	stdin  io.Closer
+	cancel context.CancelFunc
+	done   chan struct{} // Closed once the listener has exited
+}
+
+// acceptFunc handles a connection accepted by a spriteListener, which waits
+// on localPort on the sprite's loopback until the server connects to it
+type acceptFunc func(ctx context.Context, localPort int, originAddr string, originPort uint32)
+
+// startSpriteListener listens on host:port on the sprite, or on the socket
+// path in port when host is "unix", calling onConn for each connection. It
+// stops when ctx ends or Close is called.
+func startSpriteListener(ctx context.Context, sprite *sprites.Sprite, host, port string, onConn acceptFunc) (*spriteListener, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	cmd := sprite.CommandContext(ctx, "python3", "-c", remoteListenerScript, host, port)
+	// Held open until the listener is closed; closing it stops the script
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		cancel()
+		return nil, fmt.Errorf("start listener on sprite: %w", err)
+	}
+
+	lines := bufio.NewScanner(stdout)
+	started := make(chan error, 1)
+	var bound int
+	go func() {
+		if !lines.Scan() {
+			started <- fmt.Errorf("listener on sprite exited (is python3 installed?): %s", strings.TrimSpace(stderr.String()))
+			return
+		}
+		if _, err := fmt.Sscanf(lines.Text(), "port %d", &bound); err != nil {
+			started <- fmt.Errorf("unexpected listener output %q", lines.Text())
+			return
+		}
+		started <- nil
+	}()
+
+	select {
+	case err = <-started:
+	case <-time.After(remoteListenTimeout):
+		err = errors.New("timed out starting listener on sprite")
+	}
+	if err != nil {
+		cancel()
+		cmd.Wait()
+		return nil, err
+	}
+
+	l := &spriteListener{port: uint32(bound), stdin: stdin, cancel: cancel, done: make(chan struct{})}
+	go func() {
+		defer close(l.done)
+		defer cmd.Wait()
+		defer stdin.Close()
+		for lines.Scan() {
+			fields := strings.Fields(lines.Text())
+			if len(fields) != 4 || fields[0] != "conn" {
+				continue
+			}
+			localPort, _ := strconv.Atoi(fields[1])
+			originPort, _ := strconv.Atoi(fields[3])
+			go onConn(ctx, localPort, fields[2], uint32(originPort))
+		}
+	}()
+	return l, nil
+}
+
+// Close stops the listener. Closing its stdin lets the script remove its
+// socket before exiting; it is killed if it doesn't exit promptly.
+func (l *spriteListener) Close() {
+	l.stdin.Close()
+	go func() {
+		select {
+		case <-l.done:
+		case <-time.After(5 * time.Second):
+		}
+		l.cancel()
+	}()
+}
+
+// remoteForward is a listener on the sprite for a tcpip-forward request
+type remoteForward struct {
+	bindAddr string // As the client sent it, for forwarded-tcpip channels
+	listener *spriteListener
+}
+
+// remoteBindHost maps the client's bind address to one on the sprite. Like
+// OpenSSH with GatewayPorts off, an empty address or "localhost" means
+// loopback only.
+func remoteBindHost(addr string) string {
+	switch addr {
+	case "", "localhost":
+		return "127.0.0.1"
+	case "*":
+		return "0.0.0.0"
+	}
+	return addr
+}
+
+// handleTCPIPForward starts a listener on the sprite and returns the port it
+// bound, which differs from the requested one when that was 0
+func (c *sshConn) handleTCPIPForward(ctx context.Context, payload []byte, sprite *sprites.Sprite) (uint32, error) {
+	var req tcpipForwardRequest
+	if err := ssh.Unmarshal(payload, &req); err != nil {
+		return 0, err
+	}
+	if err := c.checkPermit(permitPortForwarding); err != nil {
+		return 0, err
+	}
+
+	// A port picked by the sprite can't be checked against the policy
+	// before it is bound, so it needs one that allows any port
+	if err := c.policy.CheckForwardPort(int(req.BindPort)); err != nil {
+		return 0, err
+	}
+
+	c.rfMu.Lock()
+	count := len(c.remoteForwards)
+	c.rfMu.Unlock()
+	if count >= c.srv.maxRemoteForwards {
+		return 0, fmt.Errorf("too many remote forwards (max %d)", c.srv.maxRemoteForwards)
+	}
+
+	fwd := &remoteForward{bindAddr: req.BindAddr}
+	host := remoteBindHost(req.BindAddr)
+	l, err := startSpriteListener(ctx, sprite, host, strconv.Itoa(int(req.BindPort)),
+		func(ctx context.Context, localPort int, originAddr string, originPort uint32) {
+			data := ssh.Marshal(&forwardedTCPIPChannelData{
+				DestAddr:   fwd.bindAddr,
+				DestPort:   fwd.listener.port,
+				OriginAddr: originAddr,
+				OriginPort: originPort,
+			})
+			dest := net.JoinHostPort(fwd.bindAddr, strconv.Itoa(int(fwd.listener.port)))
+			c.bridgeToClient(ctx, sprite, localPort, "forwarded-tcpip", data, dest)
+		})
+	if err != nil {
+		return 0, err
+	}
+	fwd.listener = l
+
+	key := net.JoinHostPort(req.BindAddr, strconv.Itoa(int(l.port)))
+	c.rfMu.Lock()
+	c.remoteForwards[key] = fwd
+	c.rfMu.Unlock()
+	c.state.remoteForwards.Add(1)
+
+	logger(ctx).InfoContext(ctx, "Started remote forward",
+		"bind", net.JoinHostPort(host, strconv.Itoa(int(l.port))))
+
+	go func() {
+		<-l.done
+		c.rfMu.Lock()
+		if c.remoteForwards[key] == fwd {
+			delete(c.remoteForwards, key)
+		}
+		c.rfMu.Unlock()
+		c.state.remoteForwards.Add(-1)
+		logger(ctx).DebugContext(ctx, "Remote forward ended", "bind", key)
+	}()
+
+	return l.port, nil
+}
+
+// cancelTCPIPForward stops the listener started for a tcpip-forward request
+func (c *sshConn) cancelTCPIPForward(payload []byte) error {
+	var req tcpipForwardRequest
+	if err := ssh.Unmarshal(payload, &req); err != nil {
+		return err
+	}
+
+	key := net.JoinHostPort(req.BindAddr, strconv.Itoa(int(req.BindPort)))
+	c.rfMu.Lock()
+	fwd, ok := c.remoteForwards[key]
+	delete(c.remoteForwards, key)
+	c.rfMu.Unlock()
+	if !ok {
+		return fmt.Errorf("no remote forward on %s", key)
+	}
+	fwd.listener.Close()
+	return nil
+}
+
+// bridgeToClient connects a connection accepted on the sprite, waiting on
+// localPort, to a new channel of chanType opened to the client
+func (c *sshConn) bridgeToClient(ctx context.Context, sprite *sprites.Sprite, localPort int, chanType string, data []byte, dest string) {
+	if !c.srv.registry.acquireForward(c.state, c.srv.maxForwardsPerConn, c.srv.maxForwards) {
+		logger(ctx).WarnContext(ctx, "Dropped connection from the sprite over the forward limit",
+			"channel.type", chanType,
+			"dest", dest)
+		return
+	}
+	defer c.srv.registry.releaseForward(c.state)
+
+	// Connect to the one-shot port first, so it isn't left waiting if the
+	// client refuses the channel
+	wsConn, _, err := dialProxy(ctx, c.apiURL, c.authToken, sprite.Name(), "127.0.0.1", localPort, c.srv.wsBufferSize, c.srv.compress, c.srv.tlsConfig, c.srv.proxy)
+	if err != nil {
+		logger(ctx).ErrorContext(ctx, "Failed to open proxy connection", "dest", dest, "exception", err)
+		return
+	}
+	defer wsConn.Close()
+
+	ch, reqs, err := c.conn.OpenChannel(chanType, data)
+	if err != nil {
+		logger(ctx).DebugContext(ctx, "Client refused channel", "channel.type", chanType, "dest", dest, "exception", err)
+		return
+	}
+	defer ch.Close()
+	go ssh.DiscardRequests(reqs)
+
+	ctx, span := telemetry.Start(ctx, "ssh.reverse_channel")
+	span.SetString("sprite.name", sprite.Name())
+	span.SetString("channel.type", chanType)
+	span.SetString("forward.dest", dest)
+	defer span.End()
+	var bytesIn, bytesOut atomic.Int64
+	if span != nil {
+		defer func() {
+			span.SetInt("forward.bytes_in", bytesIn.Load())
+			span.SetInt("forward.bytes_out", bytesOut.Load())
+		}()
+	}
+
+	fwdCtx, closeForward := context.WithCancel(ctx)
+	defer closeForward()
+	id := c.srv.registry.addForward(c.state, chanType, fmt.Sprintf("sprite:%d", localPort), dest, &bytesIn, &bytesOut, func() {
+		closeForward()
+		ch.Close()
+	})
+	defer c.srv.registry.removeForward(id)
+
+	c.pipeForward(fwdCtx, span, sprite.Name(), dest, ch, wsConn, 0, &bytesIn, &bytesOut)
+	logger(ctx).DebugContext(ctx, "Reverse channel completed", "channel.type", chanType, "dest", dest)
+}
@@ -0,0 +1,89 @@
+package sshserver
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// defaultReplayBuffer is how much recent shell output is kept per session
+const defaultReplayBuffer = 64 * 1024
+
+// replayBuffer keeps the most recent output of an interactive shell, so the
+// context the client had can be shown again when the shell is reconnected
+type replayBuffer struct {
+	mu      sync.Mutex
+	buf     []byte
+	pos     int  // Where the next byte goes
+	wrapped bool // Older output was overwritten
+}
+
+func newReplayBuffer(size int) *replayBuffer {
+	return &replayBuffer{buf: make([]byte, size)}
+}
+
+// startReplay keeps the output of an interactive shell for replay, once
+// the shell's persistent holder is known. A shell under tmux or screen
+// gets none: the holder redraws the screen and keeps its own scrollback,
+// so replayed output would only garble the redraw.
+func (s *session) startReplay(isShell bool) {
+	if isShell && s.tty && s.holder == "" && s.conn.srv.replayBuffer > 0 {
+		s.replay = newReplayBuffer(s.conn.srv.replayBuffer)
+	}
+}
+
+func (r *replayBuffer) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n := len(p)
+	if n >= len(r.buf) {
+		p = p[n-len(r.buf):]
+		r.pos, r.wrapped = 0, true
+	}
+	for len(p) > 0 {
+		c := copy(r.buf[r.pos:], p)
+		p = p[c:]
+		r.pos += c
+		if r.pos == len(r.buf) {
+			r.pos, r.wrapped = 0, true
+		}
+	}
+	return n, nil
+}
+
+// snapshot returns the buffered output. Once older output was dropped it
+// starts at a line boundary, so no half escape sequence is replayed.
+func (r *replayBuffer) snapshot() []byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.wrapped {
+		return bytes.Clone(r.buf[:r.pos])
+	}
+	out := append(bytes.Clone(r.buf[r.pos:]), r.buf[:r.pos]...)
+	if i := bytes.IndexByte(out, '\n'); i >= 0 {
+		out = out[i+1:]
+	}
+	return out
+}
+
+// replayWriter writes what a reconnected shell replays, and the reconnect
+// banner, ahead of anything the new shell prints
+type replayWriter struct {
+	w      io.Writer
+	once   sync.Once
+	prefix []byte
+}
+
+func (r *replayWriter) flush() {
+	r.once.Do(func() {
+		r.w.Write(r.prefix)
+		r.prefix = nil
+	})
+}
+
+func (r *replayWriter) Write(p []byte) (int, error) {
+	r.flush()
+	return r.w.Write(p)
+}
@@ -0,0 +1,60 @@
+package sshserver
+
+import (
+	"testing"
+)
+
+func TestStartReplay(t *testing.T) {
+	tests := []struct {
+		name    string
+		isShell bool
+		tty     bool
+		holder  string
+		size    int
+		want    bool
+	}{
+		{"shell", true, true, "", 1024, true},
+		{"persistent shell", true, true, "sprite-abc-1", 1024, false},
+		{"exec", false, true, "", 1024, false},
+		{"no tty", true, false, "", 1024, false},
+		{"disabled", true, true, "", -1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &session{
+				conn:   &sshConn{srv: &Server{replayBuffer: tt.size}},
+				tty:    tt.tty,
+				holder: tt.holder,
+			}
+			s.startReplay(tt.isShell)
+			if got := s.replay != nil; got != tt.want {
+				t.Errorf("replay = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReplayBufferSnapshot(t *testing.T) {
+	tests := []struct {
+		name   string
+		size   int
+		writes []string
+		want   string
+	}{
+		{"fits", 16, []string{"abc\n", "def"}, "abc\ndef"},
+		{"wrapped at a line", 8, []string{"one\ntwo\nthree"}, "three"},
+		{"wrapped in pieces", 8, []string{"one\n", "two\n", "three"}, "three"},
+		{"wrapped without a line", 4, []string{"abcdefgh"}, "efgh"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newReplayBuffer(tt.size)
+			for _, w := range tt.writes {
+				r.Write([]byte(w))
+			}
+			if got := string(r.snapshot()); got != tt.want {
+				t.Errorf("snapshot() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package sshserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// HostKeyRotation records a host key replaced by "host-key rotate". Until
+// the grace period ends the server keeps handshaking with the retired key
+// and announces the new one, so clients can learn it before it takes over.
+type HostKeyRotation struct {
+	Retired string    `json:"retired"` // Path to the previous key
+	Until   time.Time `json:"until"`   // End of the grace period
+}
+
+// Active reports whether the rotation is still in its grace period
+func (r *HostKeyRotation) Active() bool {
+	return time.Now().Before(r.Until)
+}
+
+// rotationFile returns the path recording a rotation of the key at path
+func rotationFile(path string) string {
+	return path + ".rotation"
+}
+
+// LoadHostKeyRotation returns the rotation of the key at path, or nil if
+// there is none
+func LoadHostKeyRotation(path string) (*HostKeyRotation, error) {
+	data, err := os.ReadFile(rotationFile(path))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var rot HostKeyRotation
+	if err := json.Unmarshal(data, &rot); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", rotationFile(path), err)
+	}
+	return &rot, nil
+}
+
+// RotateHostKey moves the key at path aside and generates a new one in its
+// place, recording the rotation for the grace period. It returns the
+// retired and new keys.
+func RotateHostKey(path string, grace time.Duration) (retired, current ssh.Signer, err error) {
+	rot, err := LoadHostKeyRotation(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if rot != nil && rot.Active() {
+		return nil, nil, fmt.Errorf("the previous rotation is in its grace period until %s", rot.Until.Format(time.RFC1123))
+	}
+
+	retired, err = LoadHostKey(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("load current host key: %w", err)
+	}
+
+	retiredPath := path + ".old"
+	if err := os.Rename(path, retiredPath); err != nil {
+		return nil, nil, err
+	}
+	_ = os.Rename(path+".pub", retiredPath+".pub")
+
+	current, err = GenerateHostKey(path)
+	if err != nil {
+		// Put the old key back rather than leave the server without one
+		_ = os.Rename(retiredPath, path)
+		_ = os.Rename(retiredPath+".pub", path+".pub")
+		return nil, nil, fmt.Errorf("generate host key: %w", err)
+	}
+
+	data, err := json.Marshal(HostKeyRotation{Retired: retiredPath, Until: time.Now().Add(grace)})
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := os.WriteFile(rotationFile(path), data, 0600); err != nil {
+		return nil, nil, err
+	}
+	return retired, current, nil
+}
+
+// FinishHostKeyRotation removes the retired key and the rotation record
+func FinishHostKeyRotation(path string, rot *HostKeyRotation) {
+	os.Remove(rot.Retired)
+	os.Remove(rot.Retired + ".pub")
+	os.Remove(rotationFile(path))
+}
@@ -6,23 +6,26 @@
 package sshserver
 
 import (
+	"cmp"
 	"context"
 	"crypto/tls"
 	"encoding/base32"
-	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	mrand "math/rand"
 	"net"
-	"net/http"
-	"net/url"
+	"slices"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/vaurdan/sprite-bootstrap/internal/config"
+	"github.com/vaurdan/sprite-bootstrap/internal/ratelimit"
+	"github.com/vaurdan/sprite-bootstrap/internal/telemetry"
+
 	"github.com/gorilla/websocket"
 	"github.com/superfly/sprites-go"
 	"golang.org/x/crypto/ssh"
@@ -35,6 +38,7 @@ var (
 	errDuplicatePTY   = errors.New("session already has an attached pty")
 	errUnknownReq     = errors.New("unexpected request type")
 	errUnsupportedReq = errors.New("unsupported request type")
+	errKeepalive      = errors.New("keepalive timeout must be shorter than the interval")
 )
 
 // Retry settings for sprite connection recovery
@@ -43,12 +47,16 @@ var (
 	initialRetryDelay  = 1 * time.Second  // Start with 1s delay
 	maxBackoffDuration = 10 * time.Second // Cap backoff at 10 seconds
 	maxShellRetries    = 30               // Allow up to 30 retries for shells (~3-5 minutes)
+	spriteStartTimeout = 3 * time.Minute  // How long commands wait for a starting sprite
+	spriteStartPoll    = 2 * time.Second  // How often they try it meanwhile
+	maxForwardAttempts = 5                // Proxy dials per port forward (~15 seconds), covering a sprite waking up
 )
 
-// SSH keepalive settings - balanced for connection detection vs restore tolerance
+// Default SSH keepalive settings - balanced for connection detection vs
+// restore tolerance (see ServerConfig.KeepaliveInterval)
 var (
-	keepaliveInterval = 30 * time.Second // Send keepalive every 30 seconds
-	keepaliveTimeout  = 20 * time.Second // Wait 20 seconds for response (allows for restore delays)
+	defaultKeepaliveInterval = 30 * time.Second // Send keepalive every 30 seconds
+	defaultKeepaliveTimeout  = 20 * time.Second // Wait 20 seconds for response (allows for restore delays)
 )
 
 // Sprite keepalive settings - keep sprites awake while connections are active
@@ -56,6 +64,19 @@ var (
 	spriteKeepaliveInterval = 30 * time.Second // Send activity to sprite every 30 seconds
 )
 
+// defaultShutdownGrace is how long Shutdown waits for connections to end
+// before closing them, if ServerConfig.ShutdownGrace is zero
+const defaultShutdownGrace = 10 * time.Second
+
+// forwardStallAfter is how long a forward's WebSocket write may block before
+// it is reported as stalled
+var forwardStallAfter = 20 * time.Second
+
+// halfCloseTimeout bounds how long a forward waits for the rest of the
+// response after the client sent EOF. Keepalive pings can't follow the
+// WebSocket close frame that carries the EOF.
+var halfCloseTimeout = 5 * time.Minute
+
 // Bech32 alphabet for session IDs
 var bech32Encoding = base32.NewEncoding("qpzry9x8gf2tvdw0s3jn54khce6mua7l").
 	WithPadding(base32.NoPadding)
@@ -67,26 +88,293 @@ type ServerConfig struct {
 	TokenOptions  *TokenOptions
 	MaxRetries    int
 	SocketTimeout time.Duration
+
+	// Shell is the shell used on the sprite for shell and exec requests.
+	// Empty uses the sprite user's login shell, or /bin/bash if it can't
+	// be found.
+	Shell string
+
+	// InstallTerminfo compiles the client's terminfo entry on the sprite
+	// when it's missing, instead of falling back to xterm-256color.
+	InstallTerminfo bool
+
+	// ExtraHostKeys are announced to clients alongside HostKey through the
+	// hostkeys-00@openssh.com extension, so OpenSSH clients learn a new key
+	// before it replaces the current one. Only HostKey is used for the
+	// handshake.
+	ExtraHostKeys []ssh.Signer
+
+	// MoreHostKeys are offered in the handshake alongside HostKey, each of
+	// a different key type, for clients that want another algorithm
+	MoreHostKeys []ssh.Signer
+
+	// TrustedUserCAKeys, if set, requires clients to present a user
+	// certificate signed by one of these CAs, unless their plain key is in
+	// AuthorizedKeys (see usercert.go). A principal must be the sprite
+	// name or one of AuthorizedPrincipals. Certificates whose key or CA is
+	// in RevokedKeys are rejected.
+	TrustedUserCAKeys    *AuthorizedKeys
+	AuthorizedPrincipals []string
+	RevokedKeys          *AuthorizedKeys
+
+	// AuthorizedKeys restricts which client keys may connect. Nil accepts
+	// any key.
+	AuthorizedKeys *AuthorizedKeys
+
+	// SearchOrgs are other organizations, on the same API, where sprites
+	// not found in TokenOptions' organization are looked for. Their
+	// credentials are resolved on first use.
+	SearchOrgs []string
+
+	// WebSocketBufferSize is the read and write buffer size of each port
+	// forward's proxy connection, and the size of the buffers forwarded
+	// data is copied through. Zero means 64 KiB.
+	WebSocketBufferSize int
+
+	// EnableCompression offers permessage-deflate on port forwards' proxy
+	// connections, falling back to uncompressed ones when the proxy
+	// declines. It helps text-heavy traffic over a slow uplink but adds
+	// latency to interactive traffic.
+	EnableCompression bool
+
+	// WarmProxies is how many proxy connections are kept open ahead of time
+	// for each sprite with recent port forwards, so the next forward skips
+	// the connection setup. Zero means 2; a negative value disables this.
+	// WarmProxyIdle is how long they are kept unused. Zero means 30s.
+	WarmProxies   int
+	WarmProxyIdle time.Duration
+
+	// LimitUp and LimitDown cap the bytes per second all port forwards
+	// together send to and receive from sprites. Zero means no limit. They
+	// can be changed while serving with SetRateLimits.
+	LimitUp   int64
+	LimitDown int64
+
+	// MaxForwardsPerConn and MaxForwards cap concurrent port forwards on one
+	// SSH connection and across the server. Excess forwards are rejected.
+	// Zero means no cap.
+	MaxForwardsPerConn int
+	MaxForwards        int
+
+	// CommandWrappers maps sprite names to a command that shell and exec
+	// requests run through, such as "nix develop --command". The key "*"
+	// applies to sprites without their own entry.
+	CommandWrappers map[string]string
+
+	// MaxRemoteForwards caps the tcpip-forward listeners one connection
+	// may have on its sprite. Zero means 10.
+	MaxRemoteForwards int
+
+	// AllowedSockets holds path.Match patterns for the Unix sockets on the
+	// sprite that clients may forward to. Empty allows any socket.
+	AllowedSockets []string
+
+	// MaxAuthTries is how many authentication attempts a connection may
+	// make before it is closed. Zero means 6.
+	MaxAuthTries int
+
+	// AllowedShells lists the shells clients may pick with SPRITE_SHELL, in
+	// addition to Shell.
+	AllowedShells []string
+
+	// MaxFrameSize caps the payload of each WebSocket message sent for port
+	// forwards. Zero means no cap. Small frames work around path MTU
+	// blackholes on some VPNs.
+	MaxFrameSize int
+
+	// ReplayBuffer is how many bytes of recent output are kept for each
+	// interactive shell and shown again after it is reconnected. Zero means
+	// 64KB; a negative value disables replay.
+	ReplayBuffer int
+
+	// Persist runs interactive shells under "tmux" or "screen" on the
+	// sprite, so a reconnect reattaches to the running shell instead of
+	// starting a new one. Empty runs them directly.
+	Persist string
+
+	// DefaultEnv holds KEY=VALUE variables set for every session before
+	// the ones the client sends, replacing or adding to the built-in
+	// LANG and LC_ALL. NoBuiltinEnv drops the built-in ones, e.g. for
+	// sprites without the en_US.UTF-8 locale.
+	DefaultEnv   []string
+	NoBuiltinEnv bool
+
+	// RawExec runs exec requests as argv, split with sh-style quoting but
+	// without the shell's -c, so nothing in them is expanded. Clients can
+	// choose per session with SPRITE_RAW_EXEC.
+	RawExec bool
+
+	// KeepaliveInterval is how often idle connections and port forwards
+	// are probed, and KeepaliveTimeout how long a probe may go unanswered
+	// before the connection is closed. The timeout must be shorter than
+	// the interval. Writes to the client also fail once blocked for both
+	// together. Zero means 30s and 20s; a negative value in either
+	// disables keepalives.
+	KeepaliveInterval time.Duration
+	KeepaliveTimeout  time.Duration
+
+	// MaxAuthFailures is how many failed sprite lookups a remote IP may
+	// cause per minute before its attempts are refused, without a lookup,
+	// for a minute. Zero means 10; a negative value disables the limit.
+	MaxAuthFailures int
+
+	// MaxConnections caps concurrent SSH connections. Connections over the
+	// limit complete the handshake and then have their channels refused
+	// with "too many connections". Zero means no cap.
+	MaxConnections int
+
+	// JanitorInterval is how often expired entries are evicted from the
+	// server's long-lived maps, such as sprites looked up for connections
+	// that never completed. Zero means one minute.
+	JanitorInterval time.Duration
+
+	// IdleTimeout closes sessions whose channel has had no traffic for
+	// this long, cancelling the remote command. Each session is timed on
+	// its own. Zero disables it.
+	IdleTimeout time.Duration
+
+	// ForwardIdleTimeout closes direct-tcpip forwards that have had no
+	// traffic in either direction for this long. Zero disables it.
+	ForwardIdleTimeout time.Duration
+
+	// MaxSessionLifetime ends sessions this long after they start,
+	// hanging up TTY sessions and terminating exec commands. Retries don't
+	// restart the clock. Zero means unlimited.
+	MaxSessionLifetime time.Duration
+	// LifetimeWarnings are how long before MaxSessionLifetime TTY sessions
+	// are warned. Empty means 10 minutes and 1 minute.
+	LifetimeWarnings []time.Duration
+
+	// ShutdownGrace is how long Shutdown lets connections end on their own
+	// after warning TTY sessions, before it closes them. Zero means 10s.
+	ShutdownGrace time.Duration
+
+	// DisableKeyboardInteractive turns off keyboard-interactive logins for
+	// clients without a usable key. When enabled, they ask no questions
+	// unless RequirePairingCode, AuthorizedKeys or TrustedUserCAKeys is
+	// set; then they ask for
+	// a one-time code from Server.NewPairingCode.
+	DisableKeyboardInteractive bool
+	RequirePairingCode         bool
+
+	// AuditLogPath, if set, is a file every exec and shell request is
+	// appended to as a JSON line (see audit.go). It is rotated once it
+	// would grow past AuditLogMaxSize bytes; zero never rotates.
+	AuditLogPath    string
+	AuditLogMaxSize int64
+	// AuditRedact, if set, rewrites commands before they are logged, to
+	// mask secrets passed on the command line (see RedactPatterns)
+	AuditRedact func(command string) string
+
+	// TLSOptions configures TLS to a self-hosted sprites API with a
+	// private CA or mutual TLS, for REST calls and WebSockets alike
+	TLSOptions
+	// IgnoreProxyEnv connects to the sprites API directly instead of
+	// through the proxy named by HTTPS_PROXY, HTTP_PROXY and NO_PROXY
+	IgnoreProxyEnv bool
 }
 
 // Server is an SSH server that proxies connections to sprites.
 type Server struct {
-	serverConfig  *ssh.ServerConfig
-	client        *sprites.Client
-	maxRetries    int
+	serverConfig *ssh.ServerConfig
+	client       *sprites.Client
+	maxRetries   int
+	shell        string
+
+	installTerminfo bool
+	authorizedKeys  *AuthorizedKeys
+	userCAs         *AuthorizedKeys
+	principals      []string
+	revokedKeys     *AuthorizedKeys
+	maxFrameSize    int
+	replayBuffer    int
+	persist         string
+	allowedShells   []string
+	hostKeys        []ssh.Signer
+	wrappers        map[string][]string
+	allowedSockets  []string
+	defaultEnv      []string
+	rawExec         bool
+
+	// keepaliveInterval is zero when keepalives are disabled
+	keepaliveInterval time.Duration
+	keepaliveTimeout  time.Duration
+
+	idleTimeout        time.Duration
+	forwardIdleTimeout time.Duration
+
+	// maxSessionLifetime ends sessions this long after they start, with
+	// TTY sessions warned lifetimeWarnings before. Zero means unlimited.
+	maxSessionLifetime time.Duration
+	lifetimeWarnings   []time.Duration
+
+	maxConnections int
+
+	wsBufferSize       int
+	copyBuffers        *bufferPool
+	compress           bool
+	maxForwardsPerConn int
+	maxForwards        int
+	maxRemoteForwards  int
+
+	// warm keeps proxy WebSockets open ahead of direct-tcpip forwards
+	warm *warmProxies
+
+	// limitUp and limitDown throttle forward traffic to and from sprites
+	limitUp   *ratelimit.Limiter
+	limitDown *ratelimit.Limiter
+
+	// orgs finds the organization each sprite belongs to, with its
+	// credentials and policy
+	orgs *orgRouter
+
+	// sprites stores authenticated sprites by SSH session ID as
+	// pendingAuth until the connection picks them up. The session ID is
+	// unique per handshake, so concurrent connections from one client
+	// never see each other's entry.
+	sprites sync.Map
 
-	// authToken and apiURL for direct proxy connections
-	authToken string
-	apiURL    string
+	// wakes coalesces sprite lookups and wakes across connections
+	wakes wakeGroup
 
-	// sprites stores authenticated sprites by "user@remoteaddr"
-	sprites sync.Map
+	// throttle limits failed lookups per remote IP
+	throttle authThrottle
+
+	// pairing holds the codes keyboard-interactive logins may use
+	pairing            pairingCodes
+	requirePairingCode bool
+
+	// health tracks recent sprites API calls for Health
+	health apiHealth
+
+	// creds tracks reloads of the organizations' tokens
+	creds credentials
+
+	// tlsConfig is used for connections to the sprites API; nil for the
+	// defaults
+	tlsConfig *tls.Config
+	// proxy picks the HTTP proxy for connections to the sprites API; nil
+	// to connect directly
+	proxy proxyFunc
+
+	// registry tracks live connections for Snapshot
+	registry *Registry
+
+	// audit records exec and shell requests; nil when disabled
+	audit *auditLog
 
 	mu        sync.Mutex
 	closed    atomic.Bool
 	listeners map[net.Listener]struct{}
 	cancel    context.CancelFunc
 	connGroup sync.WaitGroup
+
+	// draining is closed when Shutdown starts, to warn sessions. Canceling
+	// killCtx closes every connection once shutdownGrace is over.
+	draining      chan struct{}
+	shutdownGrace time.Duration
+	killCtx       context.Context
+	kill          context.CancelFunc
 }
 
 // NewServer creates a new SSH server.
@@ -95,102 +383,327 @@ func NewServer(cfg *ServerConfig) (*Server, error) {
 		return nil, errNoHostKey
 	}
 
-	client := sprites.New(cfg.TokenOptions.AuthToken, sprites.WithBaseURL(cfg.TokenOptions.API))
+	maxRemoteForwards := cfg.MaxRemoteForwards
+	if maxRemoteForwards <= 0 {
+		maxRemoteForwards = defaultMaxRemoteForwards
+	}
+
+	wrappers, err := parseWrappers(cfg.CommandWrappers)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkPersist(cfg.Persist); err != nil {
+		return nil, err
+	}
+
+	defaultEnv, err := buildDefaultEnv(cfg.DefaultEnv, cfg.NoBuiltinEnv)
+	if err != nil {
+		return nil, err
+	}
+
+	keepaliveInterval, keepaliveTimeout, err := keepaliveSettings(cfg.KeepaliveInterval, cfg.KeepaliveTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	wsBufferSize := cfg.WebSocketBufferSize
+	if wsBufferSize <= 0 {
+		wsBufferSize = defaultWSBufferSize
+	}
+
+	replayBuffer := cfg.ReplayBuffer
+	if replayBuffer == 0 {
+		replayBuffer = defaultReplayBuffer
+	}
+
+	warmProxies := cfg.WarmProxies
+	if warmProxies == 0 {
+		warmProxies = defaultWarmProxies
+	}
+	warmProxyIdle := cfg.WarmProxyIdle
+	if warmProxyIdle <= 0 {
+		warmProxyIdle = defaultWarmProxyIdle
+	}
+
+	lifetimeWarnings := cfg.LifetimeWarnings
+	if len(lifetimeWarnings) == 0 {
+		lifetimeWarnings = defaultLifetimeWarnings
+	}
+
+	tlsConfig, err := cfg.TLSOptions.config()
+	if err != nil {
+		return nil, err
+	}
+	var proxy proxyFunc
+	if !cfg.IgnoreProxyEnv {
+		proxy = proxyFromEnvironment
+	}
+	useExecDialer(tlsConfig, proxy)
+
+	janitorCtx, cancel := context.WithCancel(context.Background())
+	killCtx, kill := context.WithCancel(context.Background())
 
-	_, cancel := context.WithCancel(context.Background())
+	shutdownGrace := cfg.ShutdownGrace
+	if shutdownGrace <= 0 {
+		shutdownGrace = defaultShutdownGrace
+	}
 
 	s := &Server{
-		client:     client,
-		maxRetries: cfg.MaxRetries,
-		authToken:  cfg.TokenOptions.AuthToken,
-		apiURL:     cfg.TokenOptions.API,
-		listeners:  make(map[net.Listener]struct{}),
-		cancel:     cancel,
+		maxRetries:         cfg.MaxRetries,
+		shell:              cfg.Shell,
+		installTerminfo:    cfg.InstallTerminfo,
+		authorizedKeys:     cfg.AuthorizedKeys,
+		userCAs:            cfg.TrustedUserCAKeys,
+		principals:         cfg.AuthorizedPrincipals,
+		revokedKeys:        cfg.RevokedKeys,
+		maxFrameSize:       cfg.MaxFrameSize,
+		replayBuffer:       replayBuffer,
+		persist:            cfg.Persist,
+		allowedShells:      cfg.AllowedShells,
+		wrappers:           wrappers,
+		allowedSockets:     cfg.AllowedSockets,
+		defaultEnv:         defaultEnv,
+		rawExec:            cfg.RawExec,
+		keepaliveInterval:  keepaliveInterval,
+		keepaliveTimeout:   keepaliveTimeout,
+		idleTimeout:        cfg.IdleTimeout,
+		forwardIdleTimeout: cfg.ForwardIdleTimeout,
+		maxSessionLifetime: cfg.MaxSessionLifetime,
+		lifetimeWarnings:   lifetimeWarnings,
+		maxConnections:     cfg.MaxConnections,
+		wsBufferSize:       wsBufferSize,
+		copyBuffers:        newBufferPool(wsBufferSize),
+		compress:           cfg.EnableCompression,
+		maxForwardsPerConn: cfg.MaxForwardsPerConn,
+		maxForwards:        cfg.MaxForwards,
+		maxRemoteForwards:  maxRemoteForwards,
+		requirePairingCode: cfg.RequirePairingCode,
+		orgs:               newOrgRouter(newOrgRoute(cfg.TokenOptions, tlsConfig, proxy), cfg.SearchOrgs, tlsConfig, proxy),
+		tlsConfig:          tlsConfig,
+		proxy:              proxy,
+		warm:               newWarmProxies(max(warmProxies, 0), warmProxyIdle, wsBufferSize, cfg.EnableCompression, tlsConfig, proxy),
+		limitUp:            ratelimit.New(cfg.LimitUp),
+		limitDown:          ratelimit.New(cfg.LimitDown),
+		listeners:          make(map[net.Listener]struct{}),
+		registry:           newRegistry(),
+		cancel:             cancel,
+		draining:           make(chan struct{}),
+		shutdownGrace:      shutdownGrace,
+		killCtx:            killCtx,
+		kill:               kill,
+	}
+
+	maxAuthTries := cfg.MaxAuthTries
+	if maxAuthTries <= 0 {
+		maxAuthTries = defaultMaxAuthTries
 	}
 
 	serverConfig := &ssh.ServerConfig{
 		PublicKeyCallback: s.publicKeyCallback,
+		AuthLogCallback:   s.authLogCallback,
+		BannerCallback:    s.bannerCallback,
+		MaxAuthTries:      maxAuthTries,
+	}
+	if !cfg.DisableKeyboardInteractive {
+		serverConfig.KeyboardInteractiveCallback = s.keyboardInteractiveCallback
 	}
 	serverConfig.AddHostKey(cfg.HostKey)
+	s.hostKeys = []ssh.Signer{cfg.HostKey}
+	keyTypes := map[string]bool{cfg.HostKey.PublicKey().Type(): true}
+	for _, key := range cfg.MoreHostKeys {
+		keyType := key.PublicKey().Type()
+		if keyTypes[keyType] {
+			return nil, fmt.Errorf("more than one %s host key; use --extra-host-key to announce another key of the same type", keyType)
+		}
+		keyTypes[keyType] = true
+		serverConfig.AddHostKey(key)
+		s.hostKeys = append(s.hostKeys, key)
+	}
+	for _, key := range cfg.ExtraHostKeys {
+		if !slices.ContainsFunc(s.hostKeys, func(k ssh.Signer) bool { return sameKey(k, key) }) {
+			s.hostKeys = append(s.hostKeys, key)
+		}
+	}
 	s.serverConfig = serverConfig
 
+	switch {
+	case cfg.MaxAuthFailures > 0:
+		s.throttle.max = cfg.MaxAuthFailures
+	case cfg.MaxAuthFailures == 0:
+		s.throttle.max = defaultMaxAuthFailures
+	}
+
+	if s.audit, err = openAuditLog(cfg.AuditLogPath, cfg.AuditLogMaxSize, cfg.AuditRedact); err != nil {
+		return nil, err
+	}
+
+	janitorInterval := cfg.JanitorInterval
+	if janitorInterval <= 0 {
+		janitorInterval = defaultJanitorInterval
+	}
+	go s.janitor(janitorCtx, janitorInterval)
+
 	return s, nil
 }
 
-func (srv *Server) publicKeyCallback(cm ssh.ConnMetadata, _ ssh.PublicKey) (*ssh.Permissions, error) {
-	// Look up the sprite by username
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
+func (srv *Server) publicKeyCallback(cm ssh.ConnMetadata, pub ssh.PublicKey) (perms *ssh.Permissions, err error) {
+	defer func() { logKeyAuth(cm, pub, err) }()
 
-	sprite, err := srv.client.GetSprite(ctx, cm.User())
-	if err != nil {
-		return nil, fmt.Errorf("sprite not found: %s", cm.User())
+	if !srv.throttle.allow(cm.RemoteAddr()) {
+		return nil, errAuthThrottled
 	}
 
-	// Wake up the sprite before accepting the connection
-	// This ensures the sprite is fully responsive before VS Code tries to start its server
-	// Without this, reconnections after sleep can fail with "Failed to parse remote port"
-	wakeCtx, wakeCancel := context.WithTimeout(ctx, 20*time.Second)
-	defer wakeCancel()
-
-	cmd := sprite.CommandContext(wakeCtx, "true")
-	cmd.Stdout = io.Discard
-	cmd.Stderr = io.Discard
-	if err := cmd.Run(); err != nil {
-		slog.WarnContext(ctx, "Failed to wake sprite during auth",
-			"sprite", cm.User(),
-			"exception", err)
-		// Continue anyway - the sprite might still work
+	// With trusted user CAs, certificates must be signed by one of them,
+	// and plain keys are only accepted from the authorized keys
+	perms = &ssh.Permissions{}
+	if _, isCert := pub.(*ssh.Certificate); isCert && srv.userCAs != nil {
+		if perms, err = srv.checkUserCert(cm, pub); err != nil {
+			return nil, err
+		}
+	} else if srv.userCAs != nil && (srv.authorizedKeys == nil || !srv.authorizedKeys.Allows(pub)) {
+		return nil, errCertRequired
+	} else if srv.authorizedKeys != nil && !srv.authorizedKeys.Allows(pub) {
+		return nil, fmt.Errorf("unauthorized key for %s", cm.User())
 	}
 
-	// Store sprite for later lookup
-	key := fmt.Sprintf("%s@%s", cm.User(), cm.RemoteAddr().String())
-	srv.sprites.Store(key, sprite)
+	if err := srv.lookupUser(cm); err != nil {
+		return nil, err
+	}
+	return perms, nil
+}
 
-	return &ssh.Permissions{}, nil
+// restrictsKeys reports whether only some keys may log in, in which case
+// clients without one need a pairing code and get no banner
+func (srv *Server) restrictsKeys() bool {
+	return srv.authorizedKeys != nil || srv.userCAs != nil
 }
 
-func (srv *Server) getSprite(user string, remoteAddr net.Addr) *sprites.Sprite {
-	key := fmt.Sprintf("%s@%s", user, remoteAddr.String())
-	if v, ok := srv.sprites.LoadAndDelete(key); ok {
-		return v.(*sprites.Sprite)
+// lookupUser looks up the sprite named by the connection's username and
+// wakes it up before the connection is accepted, sharing the wake with
+// other connections to the sprite
+func (srv *Server) lookupUser(cm ssh.ConnMetadata) error {
+	ctx, cancel := context.WithTimeout(context.Background(), lookupTimeout)
+	defer cancel()
+
+	call, err := srv.wakeSprite(ctx, cm.User())
+	if errors.Is(err, errAmbiguousSprite) {
+		return err
+	} else if err != nil {
+		srv.throttle.fail(cm.RemoteAddr())
+		return fmt.Errorf("sprite not found: %s", cm.User())
 	}
+	srv.throttle.succeed(cm.RemoteAddr())
+
+	// Store sprite for handleConn, which finds it by the same session ID
+	srv.sprites.Store(string(cm.SessionID()), pendingAuth{
+		sprite: call.sprite,
+		route:  call.route,
+		woken:  call.done,
+		user:   cm.User(),
+		remote: cm.RemoteAddr().String(),
+		stored: time.Now(),
+	})
 	return nil
 }
 
-// Bind creates a TCP listener on the given address.
+// getSprite returns the sprite authenticated for a connection, removing it
+// from the pending map
+func (srv *Server) getSprite(conn ssh.ConnMetadata) (pendingAuth, bool) {
+	v, ok := srv.sprites.LoadAndDelete(string(conn.SessionID()))
+	if !ok {
+		return pendingAuth{}, false
+	}
+	return v.(pendingAuth), true
+}
+
+// dropPendingAuth removes sprites stored for a connection whose handshake
+// failed after authentication. The session ID isn't known then, but the
+// client address, port included, identifies the TCP connection.
+func (srv *Server) dropPendingAuth(remote net.Addr) {
+	addr := remote.String()
+	srv.sprites.Range(func(k, v any) bool {
+		if v.(pendingAuth).remote == addr {
+			srv.sprites.Delete(k)
+		}
+		return true
+	})
+}
+
+// Bind creates a TCP listener on the given address. Failures are returned
+// as a *BindError explaining the cause.
 func Bind(ctx context.Context, addr string) (net.Listener, error) {
 	var lc net.ListenConfig
 	l, err := lc.Listen(ctx, "tcp", addr)
 	if err != nil {
-		return nil, err
+		return nil, classifyBindError(addr, err)
 	}
 
 	return l, nil
 }
 
-// Serve starts accepting connections on the listener.
+// Serve accepts connections on the listener until ctx ends, returning its
+// error, or Shutdown closes it, returning errServerClosed. Either way the
+// listener is closed. Temporary accept errors, such as running out of file
+// descriptors, are retried with backoff. Connections outlive ctx and Serve:
+// Shutdown ends them, after giving them ShutdownGrace.
 func (srv *Server) Serve(ctx context.Context, l net.Listener) error {
-	listenCtx, cancel := context.WithCancel(ctx)
-	defer cancel()
-
 	if err := srv.trackListener(l, true); err != nil {
 		return err
 	}
 	defer srv.trackListener(l, false)
 
+	stop := context.AfterFunc(ctx, func() { l.Close() })
+	defer stop()
+	connCtx := context.WithoutCancel(ctx)
+
+	var delay time.Duration
 	for {
-		srv.connGroup.Add(1)
 		tcpConn, err := l.Accept()
 		if err != nil {
-			srv.connGroup.Done()
+			if srv.closed.Load() {
+				return errServerClosed
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			// Temporary is deprecated, but net/http still relies on it to
+			// spot EMFILE and the like
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Temporary() {
+				delay = min(max(2*delay, 5*time.Millisecond), time.Second)
+				logger(ctx).WarnContext(ctx, "Failed to accept connection, retrying", "delay", delay, "exception", err)
+				select {
+				case <-time.After(delay):
+					continue
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
 			return err
 		}
+		delay = 0
 
-		go srv.handleConn(listenCtx, tcpConn, srv.maxRetries)
+		if !srv.trackConn() {
+			tcpConn.Close()
+			return errServerClosed
+		}
+		go srv.handleConn(connCtx, tcpConn, srv.maxRetries)
 	}
 }
 
+// trackConn adds an accepted connection to connGroup, unless Shutdown has
+// started: it waits on connGroup, which nothing may be added to after
+func (srv *Server) trackConn() bool {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	if srv.closed.Load() {
+		return false
+	}
+	srv.connGroup.Add(1)
+	return true
+}
+
 func (srv *Server) trackListener(l net.Listener, add bool) error {
 	srv.mu.Lock()
 	defer srv.mu.Unlock()
@@ -206,18 +719,24 @@ func (srv *Server) trackListener(l net.Listener, add bool) error {
 	return nil
 }
 
-// Shutdown performs a graceful shutdown.
+// Shutdown stops accepting connections, warns TTY sessions and gives
+// connections ShutdownGrace to end on their own before closing the rest.
+// It returns ctx's error if connections are still open when ctx ends, and
+// errServerClosed if the server was already shut down.
 func (srv *Server) Shutdown(ctx context.Context) error {
 	if !srv.closed.CompareAndSwap(false, true) {
 		return errServerClosed
 	}
+	defer srv.kill()
 
 	srv.cancel()
+	srv.warm.close()
+	close(srv.draining)
 
 	srv.mu.Lock()
 	for l := range srv.listeners {
 		if err := l.Close(); err != nil {
-			slog.ErrorContext(ctx, "Failed to close listener",
+			logger(ctx).ErrorContext(ctx, "Failed to close listener",
 				"server.addr", l.Addr().String(),
 				"exception", err)
 		}
@@ -225,17 +744,28 @@ func (srv *Server) Shutdown(ctx context.Context) error {
 	}
 	srv.mu.Unlock()
 
-	shutdown := make(chan struct{})
+	drained := make(chan struct{})
 	go func() {
 		srv.connGroup.Wait()
-		shutdown <- struct{}{}
+		close(drained)
 	}()
 
+	grace := time.NewTimer(srv.shutdownGrace)
+	defer grace.Stop()
 	select {
+	case <-drained:
+		return nil
+	case <-grace.C:
 	case <-ctx.Done():
-		return ctx.Err()
-	case <-shutdown:
+	}
+
+	logger(ctx).InfoContext(ctx, "Closing connections still open at shutdown", "connections", srv.registry.active.Load())
+	srv.kill()
+	select {
+	case <-drained:
 		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
 }
 
@@ -245,9 +775,79 @@ type sshConn struct {
 
 	maxSpriteRetries int
 
-	// For direct-tcpip proxy connections
-	authToken string
-	apiURL    string
+	// hostKeys are announced to the client after login (see hostkeys.go)
+	hostKeys []ssh.Signer
+
+	// shell is the configured shell, resolved against the sprite on the
+	// first session (see resolveShell)
+	shell         string
+	shellMu       sync.Mutex
+	shellRes      *shellResolution
+	allowedShells []string
+
+	// wrapper is the sprite's command wrapper (see wrapper.go)
+	wrapper []string
+
+	// keepWarm controls the sprite keepalive; sessions may turn it off with
+	// SPRITE_KEEPWARM
+	keepWarm atomic.Bool
+
+	// terms caches the TERM to use on the sprite per client TERM
+	// (see resolveTerm)
+	installTerminfo bool
+	termMu          sync.Mutex
+	terms           map[string]string
+
+	// remoteForwards are the connection's tcpip-forward listeners on the
+	// sprite, by bind address and port
+	rfMu           sync.Mutex
+	remoteForwards map[string]*remoteForward
+
+	// agentSock is the forwarded agent socket on the sprite, once a
+	// session asked for agent forwarding (see agent.go)
+	agentMu   sync.Mutex
+	agentSock string
+
+	// sftpServer caches the sftp-server path on the sprite
+	// (see findSFTPServer)
+	sftpMu     sync.Mutex
+	sftpServer string
+
+	// holderFound caches whether the persistent session holder is
+	// installed on the sprite (see persist.go)
+	holderMu      sync.Mutex
+	holderChecked bool
+	holderFound   bool
+
+	// state is the connection's entry in the server registry
+	state *connState
+
+	// forceCommand is run instead of every shell, exec and subsystem
+	// request, from the client certificate's force-command option
+	forceCommand string
+
+	// certExtensions are the extensions of the client's user certificate,
+	// which decide whether it may have a PTY, forward ports or forward its
+	// agent; nil when it didn't log in with one
+	certExtensions map[string]string
+
+	// policy is the policy of the sprite's organization
+	policy *config.Policy
+
+	// org is the sprite's organization, and credGen the credentials
+	// generation its token is from (see credentials.go)
+	org     string
+	credGen uint64
+
+	// woken is closed once the sprite's wake at login finished (see
+	// wake.go)
+	woken <-chan struct{}
+
+	// For direct-tcpip proxy connections, from the sprite's organization
+	authToken    string
+	apiURL       string
+	maxFrameSize int
+	srv          *Server
 }
 
 func (c *sshConn) Close() error {
@@ -261,42 +861,90 @@ func (c *sshConn) Wait() {
 func (srv *Server) handleConn(ctx context.Context, tcpConn net.Conn, maxSpriteRetries int) {
 	defer srv.connGroup.Done()
 
-	newConn, chans, reqs, err := ssh.NewServerConn(tcpConn, srv.serverConfig)
+	// Shutdown closes connections left after its grace period, including
+	// ones still in the handshake
+	stop := context.AfterFunc(srv.killCtx, func() { tcpConn.Close() })
+	defer stop()
+
+	// With keepalives, a write the client doesn't take within a keepalive
+	// round fails, like an unanswered keepalive
+	conn := tcpConn
+	if srv.keepaliveInterval > 0 {
+		conn = &writeDeadlineConn{Conn: tcpConn, timeout: srv.keepaliveInterval + srv.keepaliveTimeout}
+	}
+	counted := &countingConn{Conn: conn}
+	newConn, chans, reqs, err := ssh.NewServerConn(counted, srv.serverConfig)
 	if err != nil {
-		slog.DebugContext(ctx, "SSH handshake failed", "exception", err)
+		logger(ctx).DebugContext(ctx, "SSH handshake failed", "exception", err)
+		srv.dropPendingAuth(tcpConn.RemoteAddr())
+		return
+	}
+
+	if n := srv.registry.active.Add(1); srv.maxConnections > 0 && n > int64(srv.maxConnections) {
+		srv.registry.active.Add(-1)
+		srv.refuseConn(ctx, newConn, chans, reqs)
 		return
 	}
+	defer srv.registry.active.Add(-1)
 
 	c := &sshConn{
 		conn:             newConn,
 		maxSpriteRetries: maxSpriteRetries,
-		authToken:        srv.authToken,
-		apiURL:           srv.apiURL,
+		shell:            srv.shell,
+		installTerminfo:  srv.installTerminfo,
+		maxFrameSize:     srv.maxFrameSize,
+		allowedShells:    srv.allowedShells,
+		hostKeys:         srv.hostKeys,
+		remoteForwards:   make(map[string]*remoteForward),
+		forceCommand:     newConn.Permissions.CriticalOptions[forceCommandOption],
+		certExtensions:   certExtensions(newConn.Permissions),
+		srv:              srv,
 	}
+	c.keepWarm.Store(true)
 	defer c.Wait()
 
 	// Get the sprite that was stored during authentication
-	sprite := srv.getSprite(newConn.User(), newConn.RemoteAddr())
-	if sprite == nil {
-		slog.ErrorContext(ctx, "Sprite not found after auth", "user", newConn.User())
+	auth, ok := srv.getSprite(newConn)
+	if !ok {
+		logger(ctx).ErrorContext(ctx, "Sprite not found after auth", "user", newConn.User())
 		newConn.Close()
 		return
 	}
+	sprite, route := auth.sprite, auth.route
+	c.woken = auth.woken
+	c.authToken, c.apiURL, c.policy = route.authToken, route.apiURL, route.policy
+	c.org, c.credGen = route.org, srv.creds.gen.Load()
+	c.wrapper = wrapperFor(srv.wrappers, sprite.Name())
 
 	connCtx, connCancel := context.WithCancel(ctx)
 	defer connCancel()
 
-	slog.InfoContext(connCtx, "New SSH connection",
+	connID := bech32Encoding.EncodeToString(newConn.SessionID())
+	c.state = srv.registry.addConn(connID, sprite.Name(), newConn.RemoteAddr().String(), counted, connCancel)
+	defer srv.registry.removeConn(connID)
+
+	connCtx, span := telemetry.Start(connCtx, "ssh.connection")
+	span.SetString("sprite.name", sprite.Name())
+	span.SetString("conn.id", connID)
+	defer span.End()
+
+	connCtx = withLogger(connCtx, slog.Default().With("conn.id", connID, "sprite.name", sprite.Name()))
+	if dc, ok := conn.(*writeDeadlineConn); ok {
+		dc.log.Store(logger(connCtx))
+	}
+	logger(connCtx).InfoContext(connCtx, "New SSH connection",
 		"conn.addr", newConn.RemoteAddr().String(),
-		"conn.id", bech32Encoding.EncodeToString(newConn.SessionID()),
-		"sprite.name", sprite.Name())
+		"org", route.org)
+
+	// Let UpdateHostKeys clients learn all of our host keys
+	go c.announceHostKeys(connCtx)
 
 	// Start keepalive goroutine to detect dead connections
 	go c.keepalive(connCtx, connCancel)
 
 	// Start sprite keepalive to prevent the sprite from sleeping
 	// This sends periodic activity to the sprite so it doesn't think it's idle
-	go spriteKeepalive(connCtx, sprite)
+	go spriteKeepalive(connCtx, sprite, &c.keepWarm)
 
 	for {
 		select {
@@ -313,6 +961,8 @@ func (srv *Server) handleConn(ctx context.Context, tcpConn net.Conn, maxSpriteRe
 				go c.handleSession(connCtx, newCh, sprite)
 			case "direct-tcpip":
 				go c.handleDirectTCPIP(connCtx, newCh, sprite)
+			case "direct-streamlocal@openssh.com":
+				go c.handleDirectStreamLocal(connCtx, newCh, sprite)
 			default:
 				newCh.Reject(ssh.UnknownChannelType, "unknown channel type")
 			}
@@ -321,16 +971,102 @@ func (srv *Server) handleConn(ctx context.Context, tcpConn net.Conn, maxSpriteRe
 				return
 			}
 
-			if req.WantReply {
-				req.Reply(false, nil)
+			if req.Type == hostKeysProveRequest {
+				reply, err := c.proveHostKeys(req.Payload)
+				if err != nil {
+					logger(connCtx).WarnContext(connCtx, "Failed to prove host keys", "exception", err)
+				}
+				req.Reply(err == nil, reply)
+				continue
+			}
+
+			switch req.Type {
+			case "tcpip-forward":
+				port, err := c.handleTCPIPForward(connCtx, req.Payload, sprite)
+				if err != nil {
+					logger(connCtx).WarnContext(connCtx, "Failed to start remote forward",
+						"exception", err)
+				}
+				// The bound port is only sent back when the client asked for
+				// port 0, but including it otherwise is harmless
+				req.Reply(err == nil, ssh.Marshal(struct{ Port uint32 }{port}))
+			case "cancel-tcpip-forward":
+				req.Reply(c.cancelTCPIPForward(req.Payload) == nil, nil)
+			case "streamlocal-forward@openssh.com":
+				err := c.handleStreamLocalForward(connCtx, req.Payload, sprite)
+				if err != nil {
+					logger(connCtx).WarnContext(connCtx, "Failed to start remote socket forward",
+						"exception", err)
+				}
+				req.Reply(err == nil, nil)
+			case "cancel-streamlocal-forward@openssh.com":
+				req.Reply(c.cancelStreamLocalForward(req.Payload) == nil, nil)
+			default:
+				if req.WantReply {
+					req.Reply(false, nil)
+				}
 			}
 		}
 	}
 }
 
+// Connections returns the number of SSH connections being served
+func (srv *Server) Connections() int {
+	return int(srv.registry.active.Load())
+}
+
+// MaxConnections returns the connection limit, or 0 for none
+func (srv *Server) MaxConnections() int {
+	return srv.maxConnections
+}
+
+// refuseConn turns away a connection over MaxConnections. The SSH library
+// can't send a disconnect reason, so the client's first channel is
+// rejected with one instead, which OpenSSH and IDEs show to the user.
+func (srv *Server) refuseConn(ctx context.Context, conn *ssh.ServerConn, chans <-chan ssh.NewChannel, reqs <-chan *ssh.Request) {
+	defer conn.Close()
+	srv.getSprite(conn)
+	go ssh.DiscardRequests(reqs)
+
+	logger(ctx).WarnContext(ctx, "Refused connection over the limit",
+		"sprite.name", conn.User(),
+		"remote", conn.RemoteAddr().String(),
+		"max_connections", srv.maxConnections)
+
+	select {
+	case newCh, ok := <-chans:
+		if ok {
+			newCh.Reject(ssh.ResourceShortage, fmt.Sprintf("too many connections to the sprite-bootstrap server (max %d)", srv.maxConnections))
+		}
+	case <-time.After(10 * time.Second):
+	case <-ctx.Done():
+	}
+}
+
+// keepaliveSettings applies the defaults to the configured keepalive
+// settings. The interval returned is zero when keepalives are disabled.
+func keepaliveSettings(interval, timeout time.Duration) (time.Duration, time.Duration, error) {
+	if interval < 0 || timeout < 0 {
+		return 0, 0, nil
+	}
+	if interval == 0 {
+		interval = defaultKeepaliveInterval
+	}
+	if timeout == 0 {
+		timeout = min(defaultKeepaliveTimeout, interval/2)
+	}
+	if timeout >= interval {
+		return 0, 0, fmt.Errorf("%w (timeout %s, interval %s)", errKeepalive, timeout, interval)
+	}
+	return interval, timeout, nil
+}
+
 // keepalive sends periodic keepalive requests to detect dead connections
 func (c *sshConn) keepalive(ctx context.Context, cancel context.CancelFunc) {
-	ticker := time.NewTicker(keepaliveInterval)
+	if c.srv.keepaliveInterval == 0 {
+		return
+	}
+	ticker := time.NewTicker(c.srv.keepaliveInterval)
 	defer ticker.Stop()
 
 	for {
@@ -349,12 +1085,12 @@ func (c *sshConn) keepalive(ctx context.Context, cancel context.CancelFunc) {
 			select {
 			case ok := <-done:
 				if !ok {
-					slog.Debug("SSH keepalive failed, closing connection")
+					logger(ctx).DebugContext(ctx, "SSH keepalive failed, closing connection")
 					cancel()
 					return
 				}
-			case <-time.After(keepaliveTimeout):
-				slog.Debug("SSH keepalive timeout, closing connection")
+			case <-time.After(c.srv.keepaliveTimeout):
+				logger(ctx).DebugContext(ctx, "SSH keepalive timeout, closing connection")
 				cancel()
 				return
 			case <-ctx.Done():
@@ -366,7 +1102,7 @@ func (c *sshConn) keepalive(ctx context.Context, cancel context.CancelFunc) {
 
 // spriteKeepalive sends periodic activity to the sprite to prevent it from sleeping
 // Sprites detect inactivity via stdio - this ensures there's always some output
-func spriteKeepalive(ctx context.Context, sprite *sprites.Sprite) {
+func spriteKeepalive(ctx context.Context, sprite *sprites.Sprite, enabled *atomic.Bool) {
 	ticker := time.NewTicker(spriteKeepaliveInterval)
 	defer ticker.Stop()
 
@@ -375,6 +1111,9 @@ func spriteKeepalive(ctx context.Context, sprite *sprites.Sprite) {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
+			if !enabled.Load() {
+				continue
+			}
 			// Run a command that generates stdio output to keep the sprite awake
 			// 'echo' writes to stdout which the sprite's activity detector should see
 			cmdCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
@@ -384,7 +1123,7 @@ func spriteKeepalive(ctx context.Context, sprite *sprites.Sprite) {
 			if err := cmd.Run(); err != nil {
 				// Don't log errors - the connection might be closing
 				// The SSH keepalive will detect actual connection issues
-				slog.Debug("Sprite keepalive failed", "exception", err)
+				logger(ctx).DebugContext(ctx, "Sprite keepalive failed", "exception", err)
 			}
 			cancel()
 		}
@@ -392,16 +1131,89 @@ func spriteKeepalive(ctx context.Context, sprite *sprites.Sprite) {
 }
 
 type session struct {
-	ch     ssh.Channel
-	sprite *sprites.Sprite
-	cancel context.CancelFunc
+	id      int // Session number within the connection
+	connCtx context.Context
+	ch      ssh.Channel
+	conn    *sshConn
+	cancel  context.CancelFunc
+	shell   string
 
 	env     []string
 	tty     bool
+	term    string
 	running atomic.Bool
 
-	win  windowChangeRequest
-	cond *sync.Cond
+	// sprite is replaced by a handle with new credentials when the API
+	// rejects the old token (see refreshSprite)
+	sprite atomic.Pointer[sprites.Sprite]
+
+	// expired is set when the session reached its maximum lifetime, which
+	// is reported with exit-signal instead of exit-status
+	expired atomic.Bool
+
+	// cmd is the command currently running, for signal requests. status
+	// is the exit status sent to the client, once exited is set.
+	cmdMu  sync.Mutex
+	cmd    *sprites.Cmd
+	status int
+	exited bool
+
+	// argv is set for exec requests run without a shell (see
+	// protocolCommand)
+	argv []string
+
+	// raw is set for exec requests in raw mode (see rawArgv). Unlike argv
+	// it still goes through the wrapper and default environment.
+	raw []string
+
+	// overrides are the SPRITE_* control parameters the client sent
+	overrides sessionOverrides
+
+	// replay keeps recent output of interactive shells, replayed when they
+	// are reconnected (see replay.go)
+	replay *replayBuffer
+
+	// stdin relays the client's input to the command running now (see
+	// stdin.go)
+	stdin *stdinRelay
+
+	// holder is the shell's persistent session on the sprite, if it runs
+	// in one (see persist.go)
+	holder string
+
+	// win is the client's window size, and winGen counts its changes so
+	// the listener applies every one (see setWindow). cond guards both.
+	win    windowChangeRequest
+	winGen uint64
+	cond   *sync.Cond
+
+	// span traces the session when telemetry is enabled; byte counters are
+	// only updated while a span is active
+	span              *telemetry.Span
+	bytesIn, bytesOut atomic.Int64
+}
+
+// Variables set for every session so programs on the sprite can tell they
+// run under sprite-bootstrap, e.g. to skip heavy prompts. They are a stable
+// interface:
+//
+//	SPRITE_BOOTSTRAP=1           Always set, including for scp and rsync
+//	SPRITE_BOOTSTRAP_TOOL=<name> The tool whose setup made the connection
+//	                             (e.g. vscode), when the client sends it;
+//	                             generated SSH config entries do with SetEnv
+//
+// Unlike the SPRITE_* session overrides, SPRITE_BOOTSTRAP_TOOL is passed on
+// to the sprite as sent.
+const (
+	envBootstrap     = "SPRITE_BOOTSTRAP"
+	envBootstrapTool = "SPRITE_BOOTSTRAP_TOOL"
+)
+
+// defaultSessionEnv is the built-in default environment of every session,
+// adjusted by ServerConfig.DefaultEnv and NoBuiltinEnv
+var defaultSessionEnv = []string{
+	"LANG=en_US.UTF-8",
+	"LC_ALL=en_US.UTF-8",
 }
 
 type envRequest struct {
@@ -419,6 +1231,13 @@ type ptyRequest struct {
 	Modes         string
 }
 
+// Window sizes used in place of ones no terminal has
+const (
+	defaultCols   = 80
+	defaultRows   = 24
+	maxWindowSize = 4096
+)
+
 type windowChangeRequest struct {
 	Cols, Rows    uint32
 	Width, Height uint32
@@ -432,18 +1251,6 @@ type directTCPIPChannelData struct {
 	OriginPort uint32
 }
 
-// proxyInitMessage is the initial message sent to establish a proxy
-type proxyInitMessage struct {
-	Host string `json:"host"`
-	Port int    `json:"port"`
-}
-
-// proxyResponseMessage is the response from establishing a proxy
-type proxyResponseMessage struct {
-	Status string `json:"status"`
-	Target string `json:"target"`
-}
-
 // handleDirectTCPIP handles direct-tcpip channel requests for TCP port forwarding
 func (c *sshConn) handleDirectTCPIP(ctx context.Context, newCh ssh.NewChannel, sprite *sprites.Sprite) {
 	c.wg.Add(1)
@@ -456,109 +1263,222 @@ func (c *sshConn) handleDirectTCPIP(ctx context.Context, newCh ssh.NewChannel, s
 		return
 	}
 
-	slog.DebugContext(ctx, "direct-tcpip channel request",
+	logger(ctx).DebugContext(ctx, "direct-tcpip channel request",
 		"dest", fmt.Sprintf("%s:%d", channelData.DestAddr, channelData.DestPort),
 		"origin", fmt.Sprintf("%s:%d", channelData.OriginAddr, channelData.OriginPort))
 
-	ch, reqs, err := newCh.Accept()
-	if err != nil {
-		slog.ErrorContext(ctx, "Failed to accept direct-tcpip channel", "exception", err)
+	if err := c.checkPermit(permitPortForwarding); err != nil {
+		logger(ctx).WarnContext(ctx, "Rejected forward denied by certificate",
+			"dest", fmt.Sprintf("%s:%d", channelData.DestAddr, channelData.DestPort),
+			"exception", err)
+		newCh.Reject(ssh.Prohibited, err.Error())
 		return
 	}
-	defer ch.Close()
-
-	// Discard any channel requests
-	go ssh.DiscardRequests(reqs)
-
-	dest := fmt.Sprintf("%s:%d", channelData.DestAddr, channelData.DestPort)
-	slog.InfoContext(ctx, "Starting direct-tcpip forward via WebSocket proxy", "dest", dest)
 
-	// Build WebSocket URL for the proxy endpoint
-	wsURL, err := c.buildProxyURL(sprite.Name())
-	if err != nil {
-		slog.ErrorContext(ctx, "Failed to build proxy URL", "exception", err)
+	if err := c.policy.CheckForwardPort(int(channelData.DestPort)); err != nil {
+		logger(ctx).WarnContext(ctx, "Rejected forward denied by policy",
+			"dest", fmt.Sprintf("%s:%d", channelData.DestAddr, channelData.DestPort),
+			"exception", err)
+		newCh.Reject(ssh.Prohibited, err.Error())
 		return
 	}
 
-	// Set up WebSocket dialer
-	dialer := &websocket.Dialer{
-		ReadBufferSize:  1024 * 1024,
-		WriteBufferSize: 1024 * 1024,
-	}
-	if wsURL.Scheme == "wss" {
-		dialer.TLSClientConfig = &tls.Config{
-			InsecureSkipVerify: false,
-		}
+	if !c.srv.registry.acquireForward(c.state, c.srv.maxForwardsPerConn, c.srv.maxForwards) {
+		logger(ctx).WarnContext(ctx, "Rejected forward over the limit",
+			"conn.forwards", c.state.forwards.Load(),
+			"server.forwards", c.srv.registry.forwards.Load())
+		newCh.Reject(ssh.ResourceShortage, "too many port forwards")
+		return
 	}
+	defer c.srv.registry.releaseForward(c.state)
 
-	// Set headers including auth
-	header := http.Header{}
-	header.Set("Authorization", fmt.Sprintf("Bearer %s", c.authToken))
-	header.Set("User-Agent", "sprite-bootstrap/1.0")
-
-	// Connect to WebSocket
-	wsConn, _, err := dialer.DialContext(ctx, wsURL.String(), header)
-	if err != nil {
-		slog.ErrorContext(ctx, "Failed to connect to proxy WebSocket", "dest", dest, "exception", err)
-		return
+	dest := fmt.Sprintf("%s:%d", channelData.DestAddr, channelData.DestPort)
+	logger(ctx).InfoContext(ctx, "Starting direct-tcpip forward via WebSocket proxy", "dest", dest)
+
+	ctx, span := telemetry.Start(ctx, "ssh.forward")
+	span.SetString("sprite.name", sprite.Name())
+	span.SetString("forward.dest", dest)
+	defer span.End()
+	var bytesIn, bytesOut atomic.Int64
+	if span != nil {
+		defer func() {
+			span.SetInt("forward.bytes_in", bytesIn.Load())
+			span.SetInt("forward.bytes_out", bytesOut.Load())
+		}()
 	}
-	defer wsConn.Close()
 
-	// Send initialization message with destination host and port
+	// Connect to the proxy endpoint, which dials dest from inside the
+	// sprite, before accepting the channel: while a waking sprite is
+	// retried the client sees a slow connect rather than a reset
 	host := channelData.DestAddr
 	if host == "" {
 		host = "localhost"
 	}
-	initMsg := proxyInitMessage{
-		Host: host,
-		Port: int(channelData.DestPort),
-	}
-
-	if err := wsConn.WriteJSON(&initMsg); err != nil {
-		slog.ErrorContext(ctx, "Failed to send proxy init message", "dest", dest, "exception", err)
+	key := warmKey{apiURL: c.apiURL, authToken: c.authToken, sprite: sprite.Name()}
+	wsConn, target, err := c.dialForward(ctx, span, key, host, int(channelData.DestPort))
+	if err != nil {
+		logger(ctx).ErrorContext(ctx, "Failed to open proxy connection", "dest", dest, "exception", err)
+		newCh.Reject(ssh.ConnectionFailed, proxyRejectMessage(err))
 		return
 	}
+	defer wsConn.Close()
 
-	// Read response
-	var response proxyResponseMessage
-	if err := wsConn.ReadJSON(&response); err != nil {
-		slog.ErrorContext(ctx, "Failed to read proxy response", "dest", dest, "exception", err)
+	ch, reqs, err := newCh.Accept()
+	if err != nil {
+		logger(ctx).ErrorContext(ctx, "Failed to accept direct-tcpip channel", "exception", err)
 		return
 	}
+	defer ch.Close()
 
-	if response.Status != "connected" {
-		slog.ErrorContext(ctx, "Proxy connection failed", "dest", dest, "status", response.Status)
-		return
-	}
+	// Discard any channel requests
+	go ssh.DiscardRequests(reqs)
 
-	slog.InfoContext(ctx, "Proxy connection established", "dest", dest, "target", response.Target)
+	logger(ctx).InfoContext(ctx, "Proxy connection established", "dest", dest, "target", target)
 
-	// Set up WebSocket keepalive via ping/pong
-	wsConn.SetPongHandler(func(string) error {
-		// Extend read deadline on pong
-		wsConn.SetReadDeadline(time.Now().Add(keepaliveInterval + keepaliveTimeout))
-		return nil
+	fwdCtx, closeForward := context.WithCancel(ctx)
+	defer closeForward()
+	origin := fmt.Sprintf("%s:%d", channelData.OriginAddr, channelData.OriginPort)
+	id := c.srv.registry.addForward(c.state, "direct-tcpip", origin, dest, &bytesIn, &bytesOut, func() {
+		closeForward()
+		ch.Close()
 	})
-	// Set initial read deadline
-	wsConn.SetReadDeadline(time.Now().Add(keepaliveInterval + keepaliveTimeout))
+	defer c.srv.registry.removeForward(id)
 
-	// Start bidirectional copy between SSH channel and WebSocket
-	var wg sync.WaitGroup
-	wg.Add(3) // +1 for ping goroutine
+	c.pipeForward(fwdCtx, span, sprite.Name(), dest, ch, wsConn, c.srv.forwardIdleTimeout, &bytesIn, &bytesOut)
+	logger(ctx).DebugContext(ctx, "direct-tcpip forward completed", "dest", dest)
+}
+
+// dialForward opens a proxy connection for a port forward, retrying with
+// backoff while the sprite may be waking up. A destination the proxy
+// reports as refusing connections isn't retried, and ctx ending stops the
+// retries at once.
+func (c *sshConn) dialForward(ctx context.Context, span *telemetry.Span, key warmKey, host string, port int) (*websocket.Conn, string, error) {
+	attempt := 0
+	for {
+		attempt++
+		span.SetInt("forward.retries", int64(attempt-1))
+		wsConn, target, err := c.srv.warm.dial(ctx, key, host, port)
+		if err == nil || errors.Is(err, errProxyRefused) || !shouldRetry(err) || attempt >= maxForwardAttempts {
+			return wsConn, target, err
+		}
+
+		delay := min(initialRetryDelay<<(attempt-1), maxBackoffDuration)
+		logger(ctx).WarnContext(ctx, "Proxy connection failed, retrying",
+			"attempt", attempt+1,
+			"max_retries", maxForwardAttempts,
+			"delay", delay,
+			"exception", err)
+
+		select {
+		case <-time.After(delay + time.Duration(mrand.Int63n(int64(delay/2)))):
+		case <-ctx.Done():
+			return nil, "", ctx.Err()
+		}
+	}
+}
+
+// proxyRejectMessage explains a failed proxy dial to the client rejecting
+// its channel
+func proxyRejectMessage(err error) string {
+	if errors.Is(err, errProxyRefused) {
+		return "connection refused inside the sprite"
+	}
+	return "failed to reach the sprite"
+}
 
-	// Ping goroutine to keep WebSocket alive
+// pipeForward copies between an SSH channel and a proxy WebSocket until
+// both sides are done, keeping the WebSocket alive with pings and reporting
+// writes to the proxy that stall. EOF from the client becomes a WebSocket
+// close frame, after which the response is still read until the proxy
+// closes too; the proxy closing becomes EOF on the channel. ctx ending, or
+// idle passing without traffic either way when it isn't zero, closes both
+// sides so that every goroutine exits.
+func (c *sshConn) pipeForward(ctx context.Context, span *telemetry.Span, spriteName, dest string, ch ssh.Channel, wsConn *websocket.Conn, idle time.Duration, bytesIn, bytesOut *atomic.Int64) {
+	// With an idle timeout, traffic on the channel is timed. Both
+	// directions pass through it, so its clock covers the whole forward.
+	var idleCh *idleChannel
+	var idleTimer *time.Timer
+	var idleC <-chan time.Time
+	if idle > 0 {
+		idleCh = newIdleChannel(ch)
+		ch = idleCh
+		idleTimer = time.NewTimer(idle)
+		defer idleTimer.Stop()
+		idleC = idleTimer.C
+	}
+	// Set up WebSocket keepalive via ping/pong, unless disabled
+	interval, timeout := c.srv.keepaliveInterval, c.srv.keepaliveTimeout
+	if interval != 0 {
+		wsConn.SetPongHandler(func(string) error {
+			// Extend read deadline on pong
+			wsConn.SetReadDeadline(time.Now().Add(interval + timeout))
+			return nil
+		})
+		// Set initial read deadline
+		wsConn.SetReadDeadline(time.Now().Add(interval + timeout))
+	}
+
+	// Start bidirectional copy between SSH channel and WebSocket
+	var wg, pinger sync.WaitGroup
+	wg.Add(2)
+	pinger.Add(1)
+	copied := make(chan struct{})
+
+	// writeStarted is when the in-flight WebSocket write began (UnixNano),
+	// or 0 when no write is blocked
+	var writeStarted atomic.Int64
+	stalled := false
+
+	// closeSent is set once the client's EOF went out as a close frame
+	var closeSent atomic.Bool
+
+	// Ping goroutine to keep WebSocket alive. It also checks for stalled
+	// writes, so it runs on the default interval without keepalives, and
+	// is the watchdog that tears the forward down when ctx ends or it idles.
 	go func() {
-		defer wg.Done()
-		ticker := time.NewTicker(keepaliveInterval)
+		defer pinger.Done()
+		ticker := time.NewTicker(cmp.Or(interval, defaultKeepaliveInterval))
 		defer ticker.Stop()
 
 		for {
 			select {
 			case <-ctx.Done():
+				// Unblock both copy loops, which may be waiting on a
+				// remote that will never answer
+				wsConn.Close()
+				ch.Close()
+				return
+			case <-copied:
+				return
+			case <-idleC:
+				if remaining := idle - idleCh.idleSince(); remaining > 0 {
+					idleTimer.Reset(remaining)
+					continue
+				}
+				span.SetBool("forward.idle_timeout", true)
+				logger(ctx).InfoContext(ctx, "Closing idle forward",
+					"dest", dest,
+					"idle", idle)
+				wsConn.Close()
+				ch.Close()
 				return
 			case <-ticker.C:
-				if err := wsConn.WriteControl(websocket.PingMessage, nil, time.Now().Add(keepaliveTimeout)); err != nil {
-					slog.DebugContext(ctx, "WebSocket ping failed", "exception", err)
+				if started := writeStarted.Load(); started != 0 && !stalled {
+					if blocked := time.Since(time.Unix(0, started)); blocked > forwardStallAfter {
+						stalled = true
+						span.SetBool("forward.stalled", true)
+						logger(ctx).WarnContext(ctx, "Forward stalled writing to proxy, possibly a path MTU problem",
+							"dest", dest,
+							"bytes_in", bytesIn.Load(),
+							"blocked", blocked.Round(time.Second),
+							"hint", "run 'sprite-bootstrap doctor --network' and consider serve --max-frame-size")
+					}
+				}
+				if interval == 0 || closeSent.Load() {
+					continue
+				}
+				if err := wsConn.WriteControl(websocket.PingMessage, nil, time.Now().Add(timeout)); err != nil {
+					logger(ctx).DebugContext(ctx, "WebSocket ping failed", "exception", err)
 					wsConn.Close()
 					return
 				}
@@ -569,22 +1489,45 @@ func (c *sshConn) handleDirectTCPIP(ctx context.Context, newCh ssh.NewChannel, s
 	// Copy from SSH channel to WebSocket
 	go func() {
 		defer wg.Done()
-		defer wsConn.Close()
 
-		buffer := make([]byte, 32*1024)
+		bufp := c.srv.copyBuffers.get()
+		defer c.srv.copyBuffers.put(bufp)
+		buffer := *bufp
 		for {
 			n, err := ch.Read(buffer)
-			if err != nil {
-				if err != io.EOF {
-					slog.DebugContext(ctx, "SSH channel read error", "exception", err)
+			if err == io.EOF {
+				// Half-close: the proxy gets EOF and the response keeps
+				// coming until it closes its side
+				closeSent.Store(true)
+				if interval != 0 {
+					wsConn.SetReadDeadline(time.Now().Add(halfCloseTimeout))
 				}
+				msg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+				if err := wsConn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(cmp.Or(timeout, defaultKeepaliveTimeout))); err != nil {
+					logger(ctx).DebugContext(ctx, "WebSocket close failed", "exception", err)
+					wsConn.Close()
+				}
+				return
+			}
+			if err != nil {
+				logger(ctx).DebugContext(ctx, "SSH channel read error", "exception", err)
+				wsConn.Close()
+				return
+			}
+			if err := c.srv.limitUp.WaitN(ctx, n); err != nil {
+				wsConn.Close()
 				return
 			}
 
-			if err := wsConn.WriteMessage(websocket.BinaryMessage, buffer[:n]); err != nil {
-				slog.DebugContext(ctx, "WebSocket write error", "exception", err)
+			writeStarted.Store(time.Now().UnixNano())
+			err = writeFrames(wsConn, buffer[:n], c.maxFrameSize)
+			writeStarted.Store(0)
+			if err != nil {
+				logger(ctx).DebugContext(ctx, "WebSocket write error", "exception", err)
+				wsConn.Close()
 				return
 			}
+			bytesIn.Add(int64(n))
 		}
 	}()
 
@@ -592,75 +1535,100 @@ func (c *sshConn) handleDirectTCPIP(ctx context.Context, newCh ssh.NewChannel, s
 	go func() {
 		defer wg.Done()
 		defer ch.Close()
+		defer wsConn.Close()
 
+		out := &limitedWriter{ctx: ctx, w: ch, limit: c.srv.limitDown}
 		for {
-			messageType, data, err := wsConn.ReadMessage()
+			messageType, r, err := wsConn.NextReader()
 			if err != nil {
 				if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
-					slog.DebugContext(ctx, "WebSocket read error", "exception", err)
+					logger(ctx).DebugContext(ctx, "WebSocket read error", "exception", err)
+					return
 				}
+				// The close handshake ends the WebSocket both ways, so the
+				// client gets EOF ahead of the channel closing
+				ch.CloseWrite()
 				return
 			}
 
-			// Only forward binary messages
-			if messageType == websocket.BinaryMessage {
-				if _, err := ch.Write(data); err != nil {
-					slog.DebugContext(ctx, "SSH channel write error", "exception", err)
-					return
-				}
+			// Only forward binary messages, copying them through a pooled
+			// buffer rather than reading each into a new slice
+			if messageType != websocket.BinaryMessage {
+				continue
+			}
+			bufp := c.srv.copyBuffers.get()
+			n, err := io.CopyBuffer(out, r, *bufp)
+			c.srv.copyBuffers.put(bufp)
+			bytesOut.Add(n)
+			if err != nil {
+				logger(ctx).DebugContext(ctx, "Forward copy to SSH channel failed", "exception", err)
+				return
 			}
 		}
 	}()
 
 	wg.Wait()
-	slog.DebugContext(ctx, "direct-tcpip forward completed", "dest", dest)
-}
-
-// buildProxyURL builds the WebSocket URL for the proxy endpoint
-func (c *sshConn) buildProxyURL(spriteName string) (*url.URL, error) {
-	baseURL := c.apiURL
-
-	// Convert HTTP(S) to WS(S)
-	if strings.HasPrefix(baseURL, "http") {
-		baseURL = "ws" + baseURL[4:]
-	}
-
-	// Parse base URL
-	u, err := url.Parse(baseURL)
-	if err != nil {
-		return nil, fmt.Errorf("invalid base URL: %w", err)
-	}
-
-	// Build path
-	u.Path = fmt.Sprintf("/v1/sprites/%s/proxy", spriteName)
-
-	return u, nil
+	close(copied)
+	pinger.Wait()
 }
 
 func (c *sshConn) handleSession(ctx context.Context, newCh ssh.NewChannel, sprite *sprites.Sprite) {
 	c.wg.Add(1)
 	defer c.wg.Done()
 
-	ch, reqs, err := newCh.Accept()
+	accepted, reqs, err := newCh.Accept()
 	if err != nil {
-		slog.ErrorContext(ctx, "Failed to accept channel", "exception", err)
+		logger(ctx).ErrorContext(ctx, "Failed to accept channel", "exception", err)
 		return
 	}
-	defer ch.Close()
+	defer accepted.Close()
 
 	sessionCtx, cancel := context.WithCancel(ctx)
 	defer cancel()
+
+	// With an idle timeout, traffic on the channel is timed
+	var ch ssh.Channel = accepted
+	var idleCh *idleChannel
+	if c.srv.idleTimeout > 0 {
+		idleCh = newIdleChannel(accepted)
+		ch = idleCh
+	}
+
+	c.state.sessions.Add(1)
+	defer c.state.sessions.Add(-1)
+
+	sessionCtx, span := telemetry.Start(sessionCtx, "ssh.session")
+	span.SetString("sprite.name", sprite.Name())
+	defer span.End()
+
 	s := session{
-		sprite: sprite,
-		ch:     ch,
-		cancel: cancel,
-		cond:   sync.NewCond(new(sync.Mutex)),
-		// Set default environment variables for all sessions
-		env: []string{
-			"SHELL=/bin/bash",
-			"LANG=en_US.UTF-8",
-			"LC_ALL=en_US.UTF-8",
-		},
+		id:      c.state.newSession(),
+		connCtx: ctx,
+		span:    span,
+		conn:    c,
+		ch:      ch,
+		cancel:  cancel,
+		cond:    sync.NewCond(new(sync.Mutex)),
+		// SHELL is added once the shell has been resolved on the sprite
+		env: append(slices.Clone(c.srv.defaultEnv), envBootstrap+"=1"),
+	}
+	s.sprite.Store(sprite)
+	sessionCtx = withLogger(sessionCtx, logger(ctx).With("session.id", s.id))
+	logger(sessionCtx).DebugContext(sessionCtx, "Session started", "session.default_env", c.srv.defaultEnv)
+
+	if idleCh != nil {
+		go s.watchIdle(sessionCtx, idleCh, c.srv.idleTimeout)
+	}
+	if c.srv.maxSessionLifetime > 0 {
+		go s.watchLifetime(sessionCtx, time.Now().Add(c.srv.maxSessionLifetime), c.srv.lifetimeWarnings)
+	}
+	go s.watchShutdown(sessionCtx)
+
+	if span != nil {
+		defer func() {
+			span.SetInt("session.bytes_in", s.bytesIn.Load())
+			span.SetInt("session.bytes_out", s.bytesOut.Load())
+		}()
 	}
 
 	for {
@@ -672,9 +1640,11 @@ func (c *sshConn) handleSession(ctx context.Context, newCh ssh.NewChannel, sprit
 				return
 			}
 
+			// Requests we don't know or support are refused without logging,
+			// so clients probing with odd requests don't fill the log
 			err := s.handleReq(sessionCtx, req, c.maxSpriteRetries)
-			if err != nil && !errors.Is(err, errUnsupportedReq) {
-				slog.DebugContext(ctx, "Failed to handle session request",
+			if err != nil && !errors.Is(err, errUnsupportedReq) && !errors.Is(err, errUnknownReq) {
+				logger(ctx).DebugContext(ctx, "Failed to handle session request",
 					"session.req.type", req.Type,
 					"exception", err)
 			}
@@ -693,11 +1663,22 @@ func (s *session) handleReq(ctx context.Context, req *ssh.Request, maxSpriteRetr
 			return err
 		} else if s.running.Load() {
 			return errAlreadyRunning
+		} else if s.conn.forceCommand != "" && !forcedEnvAllowed(er.Name) {
+			return fmt.Errorf("%s: %w", er.Name, errForcedEnv)
+		} else if handled, err := s.applyOverride(ctx, er.Name, er.Value); handled {
+			return err
 		} else {
-			s.env = append(s.env, er.Name+"="+er.Value)
+			if er.Name == envBootstrapTool {
+				s.span.SetString("session.tool", er.Value)
+			}
+			// Replaces a default, so the client's value wins
+			s.setEnv(er.Name, er.Value)
 			return nil
 		}
 	case "shell":
+		if s.conn.forceCommand != "" {
+			return s.execForced(ctx, "", maxSpriteRetries)
+		}
 		// Shell request - run login shell
 		return s.exec(ctx, "", true, maxSpriteRetries)
 	case "exec":
@@ -707,7 +1688,10 @@ func (s *session) handleReq(ctx context.Context, req *ssh.Request, maxSpriteRetr
 				return err
 			}
 		}
-		// Exec request - run command via bash -c
+		if s.conn.forceCommand != "" {
+			return s.execForced(ctx, er.Command, maxSpriteRetries)
+		}
+		// Exec request - run command via the shell's -c
 		return s.exec(ctx, er.Command, er.Command == "", maxSpriteRetries)
 	case "pty-req":
 		var pr ptyRequest
@@ -717,11 +1701,14 @@ func (s *session) handleReq(ctx context.Context, req *ssh.Request, maxSpriteRetr
 			return errAlreadyRunning
 		} else if s.tty {
 			return errDuplicatePTY
+		} else if err := s.conn.checkPermit(permitPTY); err != nil {
+			return err
 		}
 
-		// Set terminal-specific environment variables
-		s.env = append(s.env, "TERM="+pr.Term)
-		s.env = append(s.env, "COLORTERM=truecolor")
+		// COLORTERM is only passed on when the client sends it as an env
+		// request - we can't know what its terminal supports
+		s.setEnv("TERM", pr.Term)
+		s.term = pr.Term
 		s.tty = true
 		s.setWindow(windowChangeRequest{pr.Cols, pr.Rows, pr.Width, pr.Height})
 
@@ -734,19 +1721,65 @@ func (s *session) handleReq(ctx context.Context, req *ssh.Request, maxSpriteRetr
 
 		s.setWindow(wr)
 		return nil
-	case "agent-auth-req@openssh.com", "signal", "subsystem", "x11-req":
+	case "subsystem":
+		var sr subsystemRequest
+		if err := ssh.Unmarshal(req.Payload, &sr); err != nil {
+			return err
+		}
+		if s.conn.forceCommand != "" {
+			return s.execForced(ctx, sr.Name, maxSpriteRetries)
+		}
+		return s.subsystem(ctx, sr.Name)
+	case "auth-agent-req@openssh.com":
+		if err := s.conn.checkPermit(permitAgentForwarding); err != nil {
+			return err
+		}
+		return s.forwardAgent()
+	case "signal":
+		var sr signalRequest
+		if err := ssh.Unmarshal(req.Payload, &sr); err != nil {
+			return err
+		}
+		return s.signal(ctx, sr.Signal)
+	case "break":
+		return s.sendBreak(ctx)
+	case "agent-auth-req@openssh.com", "x11-req":
 		return errUnsupportedReq
 	default:
 		return errUnknownReq
 	}
 }
 
+// setWindow stores the client's window size, from a pty-req or a
+// window-change that may come before the command starts, for the running
+// command to pick up
 func (s *session) setWindow(win windowChangeRequest) {
 	s.cond.L.Lock()
 	defer s.cond.L.Unlock()
 
-	s.win = win
-	s.cond.Signal()
+	s.win = saneWindow(win)
+	s.winGen++
+	s.cond.Broadcast()
+}
+
+// window returns the client's window size and its generation
+func (s *session) window() (windowChangeRequest, uint64) {
+	s.cond.L.Lock()
+	defer s.cond.L.Unlock()
+	return s.win, s.winGen
+}
+
+// saneWindow replaces sizes no terminal has, such as the zero some
+// automated clients send, with 80x24. A zero-sized TTY on the sprite is
+// unusable.
+func saneWindow(win windowChangeRequest) windowChangeRequest {
+	if win.Cols == 0 || win.Cols > maxWindowSize {
+		win.Cols = defaultCols
+	}
+	if win.Rows == 0 || win.Rows > maxWindowSize {
+		win.Rows = defaultRows
+	}
+	return win
 }
 
 func (s *session) exec(ctx context.Context, command string, isShell bool, maxRetries int) error {
@@ -759,16 +1792,80 @@ func (s *session) exec(ctx context.Context, command string, isShell bool, maxRet
 	if isShell {
 		maxRetries = max(maxRetries, maxShellRetries)
 	}
+	maxRetries = s.overrides.attempts(maxRetries)
+	if !isShell && !s.tty {
+		s.argv, _ = protocolCommand(command)
+	}
+	if !isShell && s.argv == nil && s.overrides.raw(s.conn.srv.rawExec) {
+		argv, err := rawArgv(command)
+		if err != nil {
+			s.running.Store(false)
+			return err
+		}
+		s.raw = argv
+	}
 
 	go func() {
+		started := time.Now()
+		s.auditStart(ctx, isShell)
+		s.waitAwake(ctx)
+
+		if err := s.resolveShell(ctx); err != nil {
+			logger(ctx).ErrorContext(ctx, "Failed to resolve shell", "exception", err)
+			s.exitWithError(err, exitCodeShellNotFound)
+			s.auditEnd(ctx, command, isShell, started, err)
+			s.cancel()
+			return
+		}
+		s.resolveTerm(ctx)
+		s.checkCwd(ctx)
+		if isShell && s.tty && s.conn.srv.persist != "" {
+			s.usePersist(ctx)
+		}
+		s.startReplay(isShell)
+
+		var err error
 		attempt := 0
+		refreshed := false
+		var startWait time.Time // When the sprite was first found starting
 		for {
 			attempt++
-			err := s.runCommand(ctx, command, isShell, attempt)
+			s.span.SetInt("session.retries", int64(attempt-1))
+			err = s.runCommand(ctx, command, isShell, attempt)
 			if err == nil {
 				break
 			}
 
+			// A token rotated by "sprite login" is picked up and the
+			// command tried once more
+			if !refreshed && tokenRejected(err) {
+				refreshed = true
+				if s.refreshSprite(ctx) {
+					continue
+				}
+			}
+
+			// A sprite that is still starting is waited for patiently,
+			// without using up retries or alarming the user
+			if startFailure(err) && s.spriteStarting(ctx) {
+				if startWait.IsZero() {
+					startWait = time.Now()
+					if s.tty {
+						fmt.Fprintf(s.ch, "\r\n\033[33m[sprite] %s is starting, please wait...\033[0m\r\n", s.sprite.Load().Name())
+					}
+					logger(ctx).InfoContext(ctx, "Sprite is starting, waiting", "error", err)
+				}
+				if time.Since(startWait) < spriteStartTimeout {
+					attempt--
+					select {
+					case <-time.After(spriteStartPoll):
+						continue
+					case <-ctx.Done():
+						err = ctx.Err()
+					}
+				}
+			}
+
 			if shouldRetry(err) && attempt < maxRetries {
 				// Exponential backoff: 500ms → 1s → 2s → 4s → 5s (capped)
 				delay := initialRetryDelay << min(attempt-1, 10)
@@ -788,7 +1885,8 @@ func (s *session) exec(ctx context.Context, command string, isShell bool, maxRet
 					s.ch.Write([]byte(msg))
 				}
 
-				slog.WarnContext(ctx, "Sprite connection lost, retrying",
+				s.conn.state.setRetry(s.id, attempt+1, maxRetries)
+				logger(ctx).WarnContext(ctx, "Sprite connection lost, retrying",
 					"attempt", attempt+1,
 					"max_retries", maxRetries,
 					"delay", delay,
@@ -801,9 +1899,20 @@ func (s *session) exec(ctx context.Context, command string, isShell bool, maxRet
 					err = ctx.Err()
 				}
 			}
-			slog.ErrorContext(ctx, "Failed to exec sprite", "exception", err)
+			logger(ctx).ErrorContext(ctx, "Failed to exec sprite", "exception", err)
 			break
 		}
+		s.conn.state.clearRetry(s.id)
+		// A client that went away leaves the shell running, for a new
+		// connection to attach to
+		if err == nil && s.holder != "" && ctx.Err() == nil {
+			s.endPersist(ctx)
+		}
+		if err != nil && ctx.Err() == nil {
+			s.exitWithFailure(err, attempt)
+		}
+		s.auditEnd(ctx, command, isShell, started, err)
+		// Ending the session closes the channel, after any exit status
 		s.cancel()
 	}()
 
@@ -811,6 +1920,10 @@ func (s *session) exec(ctx context.Context, command string, isShell bool, maxRet
 }
 
 func shouldRetry(err error) bool {
+	if errors.Is(err, errProtocolInterrupted) {
+		return false
+	}
+
 	var ne net.Error
 	if errors.As(err, &ne) && ne.Timeout() {
 		return true
@@ -829,6 +1942,7 @@ func shouldRetry(err error) bool {
 		"temporary failure",
 		"service unavailable",
 		"bad gateway",
+		"gateway timeout",
 	}
 	errLower := strings.ToLower(err.Error())
 	for _, msg := range transientMessages {
@@ -842,64 +1956,130 @@ func shouldRetry(err error) bool {
 
 func (s *session) runCommand(ctx context.Context, command string, isShell bool, attempt int) error {
 	// Run command directly via sprites SDK
-	var cmd *sprites.Cmd
+	var argv []string
 	if isShell && s.tty {
 		// Interactive login shell for "shell" requests with PTY (Zed)
-		cmd = s.sprite.CommandContext(ctx, "/bin/bash", "-li")
+		argv = s.wrap([]string{s.shell, "-li"})
+		if s.holder != "" {
+			argv = s.holderArgv(argv)
+		}
 	} else if isShell {
 		// Non-interactive login shell for "shell" requests without PTY (VS Code)
 		// VS Code pipes commands through stdin
-		cmd = s.sprite.CommandContext(ctx, "/bin/bash", "-l")
+		argv = s.wrap([]string{s.shell, "-l"})
+	} else if s.argv != nil {
+		// File transfer endpoints run directly, so nothing the shell's
+		// startup files (or a wrapper) print can corrupt their stream
+		argv = s.argv
+	} else if s.raw != nil {
+		// Raw exec: the client's words become argv, with no shell to
+		// expand them
+		argv = s.wrap(s.raw)
 	} else {
-		// Execute command via bash -c for "exec" requests
-		cmd = s.sprite.CommandContext(ctx, "/bin/bash", "-c", command)
+		// Execute command via the shell's -c for "exec" requests
+		argv = s.wrap([]string{s.shell, "-c", command})
 	}
+	cmd := s.sprite.Load().CommandContext(ctx, argv[0], argv[1:]...)
 
 	cmd.Env = s.env
+	if s.argv != nil {
+		cmd.Env = withoutDefaultEnv(s.env, s.conn.srv.defaultEnv)
+	}
+	if s.overrides.cwd != "" {
+		cmd.Dir = s.overrides.cwd
+	}
 	// Set TTY if client requested PTY (pty-req)
 	if s.tty {
 		cmd.SetTTY(true)
 		// SetTTYSize takes (rows, cols) not (cols, rows)
-		cmd.SetTTYSize(uint16(s.win.Rows), uint16(s.win.Cols))
+		win, gen := s.window()
+		cmd.SetTTYSize(uint16(win.Rows), uint16(win.Cols))
 
 		winCtx, cancel := context.WithCancel(ctx)
 		defer cancel()
-		go s.listenForWindowChange(winCtx, cmd)
+		go s.listenForWindowChange(winCtx, cmd, gen)
+	}
+	// Set stdin/stdout/stderr after TTY setup. Input goes through the
+	// session's relay, which holds it while the command is reconnected.
+	if s.stdin == nil {
+		var src io.Reader = s.ch
+		if s.span != nil {
+			src = &countingReader{r: s.ch, n: &s.bytesIn}
+		}
+		s.stdin = newStdinRelay(src)
+	}
+	stdin, dropped := s.stdin.attach(attempt > 1 && s.tty)
+	defer s.stdin.detach(stdin)
+	if dropped > 0 {
+		logger(ctx).WarnContext(ctx, "Dropped input typed while reconnecting", "bytes", dropped)
+	}
+
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = stdin, s.ch, s.ch.Stderr()
+	if s.span != nil {
+		cmd.Stdout = &countingWriter{w: s.ch, n: &s.bytesOut}
+		cmd.Stderr = &countingWriter{w: s.ch.Stderr(), n: &s.bytesOut}
+	}
+
+	// Show reconnected message for interactive shells after successful
+	// reconnection, after the output from before it. Both go out ahead of
+	// the new shell's output.
+	reconnected := attempt > 1 && isShell && s.tty
+	var banner *replayWriter
+	if reconnected {
+		prefix := []byte("\033[32m[sprite] Reconnected!\033[0m\r\n")
+		if dropped > 0 {
+			prefix = fmt.Appendf(prefix, "\033[33m[sprite] Some input typed while reconnecting was lost (%d bytes)\033[0m\r\n", dropped)
+		}
+		if s.replay != nil {
+			if out := s.replay.snapshot(); len(out) > 0 {
+				prefix = append(append(out, "\033[0m\r\n"...), prefix...)
+			}
+		}
+		banner = &replayWriter{w: cmd.Stdout, prefix: prefix}
+		cmd.Stdout = banner
+	}
+	if s.replay != nil {
+		cmd.Stdout = io.MultiWriter(cmd.Stdout, s.replay)
 	}
-	// Set stdin/stdout/stderr after TTY setup
-	cmd.Stdin, cmd.Stdout, cmd.Stderr = s.ch, s.ch, s.ch.Stderr()
 
 	if err := cmd.Start(); err != nil {
 		return err
 	}
+	s.setCmd(cmd)
+	defer s.setCmd(nil)
 
-	// Show reconnected message for interactive shells after successful reconnection
-	if attempt > 1 && isShell && s.tty {
-		s.ch.Write([]byte("\033[32m[sprite] Reconnected!\033[0m\r\n"))
+	s.conn.state.clearRetry(s.id)
+	if reconnected {
+		banner.flush()
 	}
 
-	slog.InfoContext(ctx, "Started exec session",
+	logger(ctx).InfoContext(ctx, "Started exec session",
 		"session.exec.tty", s.tty,
 		"session.exec.cmd", command,
 		"attempt", attempt)
 
 	var exit *sprites.ExitError
 	if err := cmd.Wait(); err != nil && !errors.As(err, &exit) {
+		if s.argv != nil {
+			return fmt.Errorf("%w: %w", errProtocolInterrupted, err)
+		}
 		return err
 	}
 
-	var status [4]byte
-	if exit != nil {
-		binary.BigEndian.PutUint32(status[:], uint32(exit.ExitCode()))
-	}
-	if _, err := s.ch.SendRequest("exit-status", false, status[:]); err != nil {
-		return err
+	if s.expired.Load() {
+		return nil
 	}
 
-	return nil
+	var status uint32
+	if exit != nil {
+		status = uint32(exit.ExitCode())
+	}
+	return s.sendExitStatus(status)
 }
 
-func (s *session) listenForWindowChange(ctx context.Context, cmd *sprites.Cmd) error {
+// listenForWindowChange passes window size changes after generation
+// applied on to cmd. A burst of changes is applied as its latest size.
+func (s *session) listenForWindowChange(ctx context.Context, cmd *sprites.Cmd, applied uint64) error {
 	stopf := context.AfterFunc(ctx, func() {
 		s.cond.L.Lock()
 		defer s.cond.L.Unlock()
@@ -907,16 +2087,21 @@ func (s *session) listenForWindowChange(ctx context.Context, cmd *sprites.Cmd) e
 	})
 	defer stopf()
 
-	s.cond.L.Lock()
-	defer s.cond.L.Unlock()
 	for {
-		s.cond.Wait()
+		s.cond.L.Lock()
+		for s.winGen == applied && ctx.Err() == nil {
+			s.cond.Wait()
+		}
+		win, gen := s.win, s.winGen
+		s.cond.L.Unlock()
+
 		if err := ctx.Err(); err != nil {
 			return err
 		}
 		// SetTTYSize takes (rows, cols) not (cols, rows)
-		if err := cmd.SetTTYSize(uint16(s.win.Rows), uint16(s.win.Cols)); err != nil {
+		if err := cmd.SetTTYSize(uint16(win.Rows), uint16(win.Cols)); err != nil {
 			return err
 		}
+		applied = gen
 	}
 }
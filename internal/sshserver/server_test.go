@@ -0,0 +1,128 @@
+package sshserver
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/vaurdan/sprite-bootstrap/internal/fakeapi"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// newTestSigner returns a fresh ed25519 signer
+func newTestSigner(t *testing.T) ssh.Signer {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+// newFakeAPI returns the fake sprites API, with token "test"
+func newFakeAPI(t *testing.T) *fakeapi.Server {
+	return &fakeapi.Server{Root: t.TempDir(), Token: "test"}
+}
+
+// testTokenOptions serves api for the test and returns the options to
+// reach it
+func testTokenOptions(t *testing.T, api http.Handler) *TokenOptions {
+	t.Helper()
+	ts := httptest.NewServer(api)
+	t.Cleanup(ts.Close)
+	return &TokenOptions{API: ts.URL, AuthToken: "test", Organization: "fake"}
+}
+
+// newTestServer creates a server for cfg backed by a fake sprites API,
+// unless cfg.TokenOptions already points at one
+func newTestServer(t *testing.T, cfg *ServerConfig) *Server {
+	t.Helper()
+
+	if cfg.HostKey == nil {
+		cfg.HostKey = newTestSigner(t)
+	}
+	if cfg.TokenOptions == nil {
+		cfg.TokenOptions = testTokenOptions(t, newFakeAPI(t))
+	}
+	cfg.IgnoreProxyEnv = true
+	cfg.ShutdownGrace = time.Second
+	if cfg.Shell == "" {
+		cfg.Shell = "/bin/sh"
+	}
+
+	srv, err := NewServer(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return srv
+}
+
+// startTestServer serves cfg on a loopback port, backed by a fake sprites
+// API, and returns the address to dial. The server is shut down when the
+// test ends.
+func startTestServer(t *testing.T, cfg *ServerConfig) (*Server, string) {
+	t.Helper()
+	srv := newTestServer(t, cfg)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- srv.Serve(context.Background(), l) }()
+	t.Cleanup(func() {
+		srv.Shutdown(context.Background())
+		<-done
+	})
+	return srv, l.Addr().String()
+}
+
+// dialTestServer logs in to the test server as user with signer
+func dialTestServer(t *testing.T, addr, user string, signer ssh.Signer) *ssh.Client {
+	t.Helper()
+	client, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            user,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         10 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+// startEchoServer listens on a loopback port, echoing what each connection
+// sends, and returns its address
+func startEchoServer(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				io.Copy(c, c)
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
@@ -0,0 +1,131 @@
+package sshserver
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/superfly/sprites-go"
+)
+
+// sftpServerPaths are the usual locations of OpenSSH's sftp-server, checked
+// in order
+var sftpServerPaths = []string{
+	"/usr/lib/openssh/sftp-server",     // Debian, Ubuntu
+	"/usr/libexec/openssh/sftp-server", // Fedora, RHEL
+	"/usr/lib/ssh/sftp-server",         // Arch
+	"/usr/libexec/sftp-server",         // Alpine
+}
+
+// sftpProbeScript prints the first executable argument, or exits 1
+const sftpProbeScript = `for p in "$@"; do if [ -x "$p" ]; then echo "$p"; exit 0; fi; done; exit 1`
+
+// sftpNotFoundError is returned when the sprite has no sftp-server binary
+type sftpNotFoundError struct {
+	Sprite string
+}
+
+func (e *sftpNotFoundError) Error() string {
+	return fmt.Sprintf("sftp-server not found on sprite %s (looked in %s); install openssh-sftp-server",
+		e.Sprite, strings.Join(sftpServerPaths, ", "))
+}
+
+type subsystemRequest struct {
+	Name string
+}
+
+// findSFTPServer locates sftp-server on the sprite once per connection.
+// Inconclusive probes aren't cached.
+func (c *sshConn) findSFTPServer(ctx context.Context, sprite *sprites.Sprite) (string, error) {
+	c.sftpMu.Lock()
+	defer c.sftpMu.Unlock()
+
+	if c.sftpServer != "" {
+		return c.sftpServer, nil
+	}
+
+	probeCtx, cancel := context.WithTimeout(ctx, shellProbeTimeout)
+	defer cancel()
+
+	args := append([]string{"-c", sftpProbeScript, "sh"}, sftpServerPaths...)
+	out, err := sprite.CommandContext(probeCtx, fallbackShell, args...).Output()
+	var exit *sprites.ExitError
+	if errors.As(err, &exit) {
+		return "", &sftpNotFoundError{Sprite: sprite.Name()}
+	} else if err != nil {
+		return "", err
+	}
+
+	c.sftpServer = strings.TrimSpace(string(out))
+	return c.sftpServer, nil
+}
+
+// subsystem starts the named subsystem. Only sftp is supported; it runs the
+// sprite's sftp-server with its stdin and stdout wired to the channel.
+func (s *session) subsystem(ctx context.Context, name string) error {
+	if name != "sftp" {
+		return errUnsupportedReq
+	}
+	if !s.running.CompareAndSwap(false, true) {
+		return errAlreadyRunning
+	}
+
+	go func() {
+		defer s.cancel()
+
+		path, err := s.conn.findSFTPServer(ctx, s.sprite.Load())
+		if err != nil {
+			logger(ctx).ErrorContext(ctx, "Failed to start sftp subsystem",
+				"exception", err)
+			s.exitWithError(err, exitCodeShellNotFound)
+			return
+		}
+
+		// No retries: the client's SFTP state doesn't survive a new
+		// sftp-server process
+		if err := s.runSubsystem(ctx, path); err != nil {
+			logger(ctx).ErrorContext(ctx, "sftp subsystem failed", "exception", err)
+		}
+	}()
+
+	return nil
+}
+
+// runSubsystem runs a subsystem binary on the sprite and reports its exit
+// status
+func (s *session) runSubsystem(ctx context.Context, path string) error {
+	cmd := s.sprite.Load().CommandContext(ctx, path)
+	cmd.Env = s.env
+	if s.overrides.cwd != "" {
+		cmd.Dir = s.overrides.cwd
+	}
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = s.ch, s.ch, s.ch.Stderr()
+	if s.span != nil {
+		cmd.Stdin = &countingReader{r: s.ch, n: &s.bytesIn}
+		cmd.Stdout = &countingWriter{w: s.ch, n: &s.bytesOut}
+	}
+
+	start := time.Now()
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	s.setCmd(cmd)
+	defer s.setCmd(nil)
+	logger(ctx).InfoContext(ctx, "Started subsystem", "session.subsystem", path)
+
+	var exit *sprites.ExitError
+	if err := cmd.Wait(); err != nil && !errors.As(err, &exit) {
+		return err
+	}
+	logger(ctx).DebugContext(ctx, "Subsystem ended", "session.subsystem", path, "duration", time.Since(start))
+
+	var status [4]byte
+	if exit != nil {
+		binary.BigEndian.PutUint32(status[:], uint32(exit.ExitCode()))
+	}
+	_, err := s.ch.SendRequest("exit-status", false, status[:])
+	return err
+}
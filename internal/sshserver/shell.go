@@ -0,0 +1,246 @@
+package sshserver
+
+import (
+	"cmp"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/superfly/sprites-go"
+)
+
+// Shell settings for commands run on the sprite
+const (
+	defaultShell  = "/bin/bash" // Used when the login shell can't be found
+	fallbackShell = "/bin/sh"   // Tried when the configured shell is missing
+)
+
+// loginShellScript prints the sprite user's login shell from the passwd
+// database, or from /etc/passwd when getent is missing or finds nothing. A
+// pipeline's status is cut's, so the fallback goes on empty output.
+const loginShellScript = `u=$(id -un); s=$(getent passwd "$u" 2>/dev/null | cut -d: -f7); [ -n "$s" ] || s=$(grep "^$u:" /etc/passwd | cut -d: -f7); echo "$s"`
+
+// exitCodeShellNotFound is reported to the client when no usable shell exists
+// on the sprite, mirroring the shell convention for "command not found"
+const exitCodeShellNotFound = 127
+
+// exitCodeSpriteFailed is reported when the command couldn't be run or
+// its connection to the sprite broke for good. ssh uses it for its own
+// errors, so scripts see the same code as for a failed connection.
+const exitCodeSpriteFailed = 255
+
+// shellProbeTimeout bounds the `test -x` probe run on the first session
+var shellProbeTimeout = 15 * time.Second
+
+// shellNotFoundError is returned when neither the configured shell nor the
+// fallback shell is executable on the sprite.
+type shellNotFoundError struct {
+	Shell  string
+	Sprite string
+	Probe  string
+}
+
+func (e *shellNotFoundError) Error() string {
+	return fmt.Sprintf("shell %s not found on sprite %s; set --shell or install %s (%s)",
+		e.Shell, e.Sprite, e.Shell, e.Probe)
+}
+
+// shellResolution is the cached result of probing the sprite for a shell.
+type shellResolution struct {
+	shell     string
+	wanted    string // The shell that was missing when fellBack
+	fellBack  bool
+	probeInfo string
+	err       error
+}
+
+// probeShell checks whether the given shell is executable on the sprite.
+// It returns found=false only when the probe ran and the shell is missing;
+// errors running the probe itself (network, sprite waking) are returned as err.
+func probeShell(ctx context.Context, sprite *sprites.Sprite, shell string) (found bool, info string, err error) {
+	probeCtx, cancel := context.WithTimeout(ctx, shellProbeTimeout)
+	defer cancel()
+
+	cmd := sprite.CommandContext(probeCtx, "test", "-x", shell)
+	cmd.Stdout = io.Discard
+	cmd.Stderr = io.Discard
+
+	var exit *sprites.ExitError
+	if err := cmd.Run(); err != nil {
+		if errors.As(err, &exit) {
+			return false, fmt.Sprintf("test -x %s exited %d", shell, exit.ExitCode()), nil
+		}
+		return false, "", err
+	}
+	return true, fmt.Sprintf("test -x %s succeeded", shell), nil
+}
+
+// loginShell looks up the sprite user's login shell. It returns "" when the
+// user has none on record; errors running the lookup are returned as err.
+func loginShell(ctx context.Context, sprite *sprites.Sprite) (string, error) {
+	probeCtx, cancel := context.WithTimeout(ctx, shellProbeTimeout)
+	defer cancel()
+
+	out, err := sprite.CommandContext(probeCtx, "sh", "-c", loginShellScript).Output()
+	var exit *sprites.ExitError
+	if errors.As(err, &exit) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	shell := strings.TrimSpace(string(out))
+	if !path.IsAbs(shell) {
+		return "", nil
+	}
+	return shell, nil
+}
+
+// resolveShell finds the shell for the connection once: the configured
+// shell, or the sprite user's login shell (/bin/bash if there is none),
+// falling back to /bin/sh when it's missing. Inconclusive probes (e.g. the
+// sprite is still waking) are not cached so a later session can retry.
+func (c *sshConn) resolveShell(ctx context.Context, sprite *sprites.Sprite) *shellResolution {
+	c.shellMu.Lock()
+	defer c.shellMu.Unlock()
+
+	if c.shellRes != nil {
+		return c.shellRes
+	}
+
+	shell := c.shell
+	if shell == "" {
+		login, err := loginShell(ctx, sprite)
+		if err != nil {
+			logger(ctx).DebugContext(ctx, "Login shell lookup failed", "exception", err)
+			return &shellResolution{shell: defaultShell}
+		}
+		shell = cmp.Or(login, defaultShell)
+	}
+
+	found, info, err := probeShell(ctx, sprite, shell)
+	if err != nil {
+		// Couldn't tell - use the shell anyway and let the retry loop deal
+		// with connectivity
+		logger(ctx).DebugContext(ctx, "Shell probe failed", "shell", shell, "exception", err)
+		return &shellResolution{shell: shell}
+	}
+	if found {
+		c.shellRes = &shellResolution{shell: shell, probeInfo: info}
+		return c.shellRes
+	}
+
+	res := &shellResolution{wanted: shell, probeInfo: info}
+	if shell != fallbackShell {
+		fbFound, fbInfo, fbErr := probeShell(ctx, sprite, fallbackShell)
+		if fbErr == nil && fbFound {
+			res.shell = fallbackShell
+			res.fellBack = true
+			c.shellRes = res
+			return res
+		}
+		if fbErr == nil {
+			res.probeInfo = info + ", " + fbInfo
+		} else {
+			// Fallback probe inconclusive - don't cache the failure
+			return &shellResolution{shell: shell}
+		}
+	}
+
+	res.err = &shellNotFoundError{Shell: shell, Sprite: sprite.Name(), Probe: res.probeInfo}
+	c.shellRes = res
+	return res
+}
+
+// resolveShell picks the shell for this session, notifying the user when the
+// configured shell had to be replaced by the fallback.
+func (s *session) resolveShell(ctx context.Context) error {
+	if s.overrides.shell != "" && s.overrides.shell != s.conn.shell {
+		return s.useShellOverride(ctx)
+	}
+
+	res := s.conn.resolveShell(ctx, s.sprite.Load())
+	if res.err != nil {
+		return res.err
+	}
+
+	s.shell = res.shell
+	s.env = append([]string{"SHELL=" + s.shell}, s.env...)
+
+	if res.fellBack {
+		logger(ctx).WarnContext(ctx, "Configured shell not found on sprite, falling back",
+			"shell", res.wanted,
+			"fallback", res.shell,
+			"probe", res.probeInfo)
+		if s.tty {
+			s.ch.Write([]byte(fmt.Sprintf("\r\n\033[33m[sprite] %s not found, using %s\033[0m\r\n",
+				res.wanted, res.shell)))
+		}
+	}
+	return nil
+}
+
+// useShellOverride switches the session to the SPRITE_SHELL the client asked
+// for, as long as it exists on the sprite
+func (s *session) useShellOverride(ctx context.Context) error {
+	shell := s.overrides.shell
+	found, info, err := probeShell(ctx, s.sprite.Load(), shell)
+	if err == nil && !found {
+		return &shellNotFoundError{Shell: shell, Sprite: s.sprite.Load().Name(), Probe: info}
+	}
+
+	s.shell = shell
+	s.env = append([]string{"SHELL=" + s.shell}, s.env...)
+	return nil
+}
+
+// exitWithError writes a diagnostic to the client's stderr and reports the
+// given exit status so ssh exits with a meaningful code.
+func (s *session) exitWithError(err error, code uint32) {
+	// API errors can end with the newline of their response body
+	msg := fmt.Sprintf("sprite-bootstrap: %s\n", strings.TrimSpace(err.Error()))
+	if s.tty {
+		msg = "\r\n" + msg[:len(msg)-1] + "\r\n"
+	}
+	s.ch.Stderr().Write([]byte(msg))
+	s.sendExitStatus(code)
+}
+
+// exitWithFailure tells the client the command failed on our side after
+// attempts tries, unless an exit status was already sent
+func (s *session) exitWithFailure(err error, attempts int) {
+	if _, sent := s.exitStatus(); sent {
+		return
+	}
+	name := s.sprite.Load().Name()
+	if attempts > 1 {
+		err = fmt.Errorf("lost connection to sprite %s after %d attempts: %w", name, attempts, err)
+	} else {
+		err = fmt.Errorf("failed to run command on sprite %s: %w", name, err)
+	}
+	s.exitWithError(err, exitCodeSpriteFailed)
+}
+
+// sendExitStatus reports the command's exit status to the client and
+// keeps it for the audit log
+func (s *session) sendExitStatus(code uint32) error {
+	s.cmdMu.Lock()
+	s.status, s.exited = int(int32(code)), true
+	s.cmdMu.Unlock()
+
+	var status [4]byte
+	binary.BigEndian.PutUint32(status[:], code)
+	_, err := s.ch.SendRequest("exit-status", false, status[:])
+	return err
+}
+
+// exitStatus returns the exit status sent to the client, if any
+func (s *session) exitStatus() (int, bool) {
+	s.cmdMu.Lock()
+	defer s.cmdMu.Unlock()
+	return s.status, s.exited
+}
@@ -0,0 +1,239 @@
+package sshserver
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestLoginShellScript(t *testing.T) {
+	// The fallback reads the real /etc/passwd, as the user id reports
+	passwd, err := os.ReadFile("/etc/passwd")
+	if err != nil {
+		t.Skip("no /etc/passwd")
+	}
+	var user, fromFile string
+	for _, line := range strings.Split(string(passwd), "\n") {
+		fields := strings.Split(line, ":")
+		if len(fields) == 7 && fields[0] != "" && fields[6] != "" {
+			user, fromFile = fields[0], fields[6]
+			break
+		}
+	}
+	if user == "" {
+		t.Skip("no usable /etc/passwd entry")
+	}
+
+	tests := []struct {
+		name   string
+		getent string // Body of the stub getent script
+		want   string
+	}{
+		{"getent finds the user", `echo "` + user + `:x:1000:1000::/home/u:/usr/bin/fish"`, "/usr/bin/fish"},
+		{"getent finds nothing", `exit 2`, fromFile},
+		{"getent missing", `echo "getent: not found" >&2; exit 127`, fromFile},
+		{"getent prints an empty shell", `echo "` + user + `:x:1000:1000::/home/u:"`, fromFile},
+	}
+	for _, tt := range tests {
+		bin := t.TempDir()
+		stubs := map[string]string{
+			"getent": tt.getent,
+			"id":     `echo "` + user + `"`,
+		}
+		for name, body := range stubs {
+			if err := os.WriteFile(filepath.Join(bin, name), []byte("#!/bin/sh\n"+body+"\n"), 0755); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		cmd := exec.Command("/bin/sh", "-c", loginShellScript)
+		cmd.Env = append(os.Environ(), "PATH="+bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+		out, err := cmd.Output()
+		if err != nil {
+			t.Errorf("%s: script failed: %v", tt.name, err)
+			continue
+		}
+		if got := strings.TrimSpace(string(out)); got != tt.want {
+			t.Errorf("%s: login shell = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// failingExecAPI is the fake sprites API with execs of command answered
+// with status instead, unless it is zero. Other execs, such as the wake and
+// shell probes, run as usual.
+type failingExecAPI struct {
+	http.Handler
+	command string
+	status  int
+	execs   atomic.Int32
+}
+
+func (a *failingExecAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.HasSuffix(r.URL.Path, "/exec") && slices.Contains(r.URL.Query()["cmd"], a.command) {
+		a.execs.Add(1)
+		if a.status != 0 {
+			http.Error(w, http.StatusText(a.status), a.status)
+			return
+		}
+	}
+	a.Handler.ServeHTTP(w, r)
+}
+
+// TestExitStatusOnFailure runs commands that fail on the server's side,
+// permanently or after the retries run out, and checks what ssh reports
+func TestExitStatusOnFailure(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int // Exec failure; zero runs the command
+		tty       bool
+		wantCode  int
+		wantExecs int32
+		wantErr   string // In the client's stderr
+	}{
+		{"command fails", 0, false, 3, 1, ""},
+		{"permanent failure", http.StatusBadRequest, false, exitCodeSpriteFailed, 1, "sprite-bootstrap: failed to run command on sprite demo"},
+		{"permanent failure with a tty", http.StatusBadRequest, true, exitCodeSpriteFailed, 1, "sprite-bootstrap: failed to run command on sprite demo"},
+		{"retries exhausted", http.StatusBadGateway, false, exitCodeSpriteFailed, 2, "sprite-bootstrap: lost connection to sprite demo after 2 attempts"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &failingExecAPI{Handler: newFakeAPI(t), command: "exit 3", status: tt.status}
+			_, addr := startTestServer(t, &ServerConfig{
+				TokenOptions: testTokenOptions(t, api),
+				MaxRetries:   2,
+			})
+			client := dialTestServer(t, addr, "demo", newTestSigner(t))
+			session, err := client.NewSession()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer session.Close()
+			if tt.tty {
+				if err := session.RequestPty("xterm", 24, 80, ssh.TerminalModes{}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			var stderr bytes.Buffer
+			session.Stderr = &stderr
+
+			err = session.Run("exit 3")
+			var exitErr *ssh.ExitError
+			if !errors.As(err, &exitErr) {
+				t.Fatalf("Run() = %v, want an exit status", err)
+			}
+			if got := exitErr.ExitStatus(); got != tt.wantCode {
+				t.Errorf("exit status = %d, want %d", got, tt.wantCode)
+			}
+			if got := api.execs.Load(); got != tt.wantExecs {
+				t.Errorf("%d exec attempts, want %d", got, tt.wantExecs)
+			}
+			if tt.wantErr == "" {
+				if stderr.Len() != 0 {
+					t.Errorf("stderr = %q, want nothing", stderr.String())
+				}
+			} else if !strings.Contains(stderr.String(), tt.wantErr) {
+				t.Errorf("stderr = %q, want it to contain %q", stderr.String(), tt.wantErr)
+			}
+		})
+	}
+}
+
+// missingPathsAPI is the fake sprites API on a sprite without the given
+// paths: exec arguments naming one are pointed somewhere that doesn't exist
+type missingPathsAPI struct {
+	http.Handler
+	missing []string
+}
+
+func (a *missingPathsAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	for i, arg := range q["cmd"] {
+		if slices.Contains(a.missing, arg) {
+			q["cmd"][i] = "/nonexistent" + arg
+		}
+	}
+	r.URL.RawQuery = q.Encode()
+	a.Handler.ServeHTTP(w, r)
+}
+
+// TestShellFallback runs commands on sprites missing the configured shell,
+// and /bin/sh as well
+func TestShellFallback(t *testing.T) {
+	if _, err := os.Stat("/bin/bash"); err != nil {
+		t.Skip("no /bin/bash")
+	}
+	tests := []struct {
+		name       string
+		missing    []string
+		wantShell  string // $SHELL of the command
+		wantNotice string // Printed on a TTY
+		wantErr    string // In stderr, with exit status 127
+	}{
+		{name: "shell found", wantShell: "/bin/bash"},
+		{name: "falls back to sh", missing: []string{"/bin/bash"}, wantShell: "/bin/sh",
+			wantNotice: "[sprite] /bin/bash not found, using /bin/sh"},
+		{name: "sh missing too", missing: []string{"/bin/bash", "/bin/sh"},
+			wantErr: "sprite-bootstrap: shell /bin/bash not found on sprite demo; set --shell or install /bin/bash " +
+				"(test -x /bin/bash exited 1, test -x /bin/sh exited 1)\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &missingPathsAPI{Handler: newFakeAPI(t), missing: tt.missing}
+			_, addr := startTestServer(t, &ServerConfig{
+				TokenOptions: testTokenOptions(t, api),
+				Shell:        "/bin/bash",
+			})
+			client := dialTestServer(t, addr, "demo", newTestSigner(t))
+
+			for _, tty := range []bool{false, true} {
+				session, err := client.NewSession()
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer session.Close()
+				if tty {
+					if err := session.RequestPty("xterm", 24, 80, ssh.TerminalModes{}); err != nil {
+						t.Fatal(err)
+					}
+				}
+				var stdout, stderr bytes.Buffer
+				session.Stdout, session.Stderr = &stdout, &stderr
+				err = session.Run(`echo "shell=$SHELL"`)
+
+				if tt.wantErr != "" {
+					var exitErr *ssh.ExitError
+					if !errors.As(err, &exitErr) || exitErr.ExitStatus() != exitCodeShellNotFound {
+						t.Fatalf("tty %v: Run() = %v, want exit status %d", tty, err, exitCodeShellNotFound)
+					}
+					got := strings.ReplaceAll(stderr.String(), "\r\n", "\n")
+					if strings.TrimSpace(got) != strings.TrimSpace(tt.wantErr) {
+						t.Errorf("tty %v: stderr = %q, want %q", tty, got, tt.wantErr)
+					}
+					continue
+				}
+				if err != nil {
+					t.Fatalf("tty %v: Run() = %v; stderr %q", tty, err, stderr.String())
+				}
+				if want := "shell=" + tt.wantShell; !strings.Contains(stdout.String(), want) {
+					t.Errorf("tty %v: output %q, want %q", tty, stdout.String(), want)
+				}
+				notice := tty && tt.wantNotice != ""
+				if got := strings.Contains(stdout.String(), "not found, using"); got != notice {
+					t.Errorf("tty %v: output %q, want notice %v", tty, stdout.String(), notice)
+				} else if notice && !strings.Contains(stdout.String(), tt.wantNotice) {
+					t.Errorf("tty %v: output %q, want notice %q", tty, stdout.String(), tt.wantNotice)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package sshserver
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serverGoroutines counts the goroutines running server code, leaving out
+// ones started by tests and the fake API
+func serverGoroutines() int {
+	buf := make([]byte, 1<<20)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+	count := 0
+	for _, g := range strings.Split(string(buf), "\n\n") {
+		if strings.Contains(g, "internal/sshserver.") && !strings.Contains(g, "_test.go") {
+			count++
+		}
+	}
+	return count
+}
+
+func TestServeShutdown(t *testing.T) {
+	before := serverGoroutines()
+
+	srv := newTestServer(t, &ServerConfig{})
+	srv.shutdownGrace = 200 * time.Millisecond
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(ctx, l) }()
+
+	client := dialTestServer(t, l.Addr().String(), "demo", newTestSigner(t))
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := session.Start("echo ready; sleep 60"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(stdout, make([]byte, len("ready\n"))); err != nil {
+		t.Fatal(err)
+	}
+	fwd, err := client.Dial("tcp", startEchoServer(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fwd.Close()
+
+	// Ending ctx stops accepting but leaves connections to Shutdown
+	cancel()
+	select {
+	case err := <-served:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Serve() = %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve didn't return when ctx ended")
+	}
+	if _, err := net.DialTimeout("tcp", l.Addr().String(), time.Second); err == nil {
+		t.Error("listener still accepting after ctx ended")
+	}
+	if _, _, err := client.SendRequest("keepalive@openssh.com", true, nil); err != nil {
+		t.Errorf("connection closed with ctx: %v", err)
+	}
+
+	// The session outlives the grace period and is closed
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer shutdownCancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		t.Errorf("Shutdown() = %v, want nil", err)
+	}
+	if err := srv.Shutdown(shutdownCtx); !errors.Is(err, errServerClosed) {
+		t.Errorf("second Shutdown() = %v, want %v", err, errServerClosed)
+	}
+	if err := srv.Serve(context.Background(), l); !errors.Is(err, errServerClosed) {
+		t.Errorf("Serve() after Shutdown = %v, want %v", err, errServerClosed)
+	}
+	if err := session.Wait(); err == nil {
+		t.Error("session survived Shutdown")
+	}
+	client.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for serverGoroutines() > before {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d server goroutines left after Shutdown, had %d", serverGoroutines(), before)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,67 @@
+package sshserver
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/superfly/sprites-go"
+)
+
+// signalRequest is the payload of a "signal" request
+type signalRequest struct {
+	Signal string
+}
+
+// forwardedSignals are the SSH signal names (RFC 4254 section 6.10) the
+// sprites API can deliver; the names are passed on unchanged
+var forwardedSignals = map[string]bool{
+	"HUP":  true,
+	"INT":  true,
+	"KILL": true,
+	"QUIT": true,
+	"TERM": true,
+	"USR1": true,
+	"USR2": true,
+}
+
+// setCmd records the command the session is running, or clears it when cmd
+// is nil
+func (s *session) setCmd(cmd *sprites.Cmd) {
+	s.cmdMu.Lock()
+	s.cmd = cmd
+	s.cmdMu.Unlock()
+}
+
+// sendBreak handles a break request (RFC 4335). On a PTY a break interrupts
+// the running command, like a serial console's break; without one there's
+// no line to break, so the request is refused.
+func (s *session) sendBreak(ctx context.Context) error {
+	if !s.tty {
+		return errUnsupportedReq
+	}
+	return s.signal(ctx, "INT")
+}
+
+// signal delivers a client's signal request to the running command. Signals
+// that arrive before the command has started or after it has exited are
+// dropped, as OpenSSH does; the exit status is reported as usual.
+func (s *session) signal(ctx context.Context, name string) error {
+	if !forwardedSignals[name] {
+		return fmt.Errorf("unsupported signal %q", name)
+	}
+
+	s.cmdMu.Lock()
+	cmd := s.cmd
+	s.cmdMu.Unlock()
+	if cmd == nil {
+		logger(ctx).DebugContext(ctx, "Dropped signal with no command running", "signal", name)
+		return nil
+	}
+
+	if err := cmd.Signal(name); err != nil {
+		logger(ctx).DebugContext(ctx, "Failed to signal command", "signal", name, "exception", err)
+		return nil
+	}
+	logger(ctx).DebugContext(ctx, "Forwarded signal", "signal", name)
+	return nil
+}
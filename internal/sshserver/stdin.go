@@ -0,0 +1,124 @@
+package sshserver
+
+import (
+	"io"
+	"sync"
+)
+
+// stdinBufferSize bounds the client input held while no command reads it,
+// e.g. typed while the sprite connection is re-established
+const stdinBufferSize = 32 * 1024
+
+// stdinRelay reads the client's input for a session and hands it to one
+// command at a time. Without it, the command that lost its connection
+// would read (and drop) what the user typed before its replacement starts.
+type stdinRelay struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	buf     []byte
+	gen     int  // Generation of the attached reader
+	reading bool // A reader is attached
+	dropped int  // Bytes dropped while detached, since the last attach
+	err     error
+}
+
+func newStdinRelay(src io.Reader) *stdinRelay {
+	r := &stdinRelay{}
+	r.cond = sync.NewCond(&r.mu)
+	go r.pump(src)
+	return r
+}
+
+func (r *stdinRelay) pump(src io.Reader) {
+	p := make([]byte, 32*1024)
+	for {
+		n, err := src.Read(p)
+
+		r.mu.Lock()
+		// An attached command gets everything, the client waiting while it
+		// catches up; without one the oldest input goes
+		for r.reading && len(r.buf) >= stdinBufferSize {
+			r.cond.Wait()
+		}
+		r.buf = append(r.buf, p[:n]...)
+		if over := len(r.buf) - stdinBufferSize; over > 0 && !r.reading {
+			r.buf = r.buf[over:]
+			r.dropped += over
+		}
+		if err != nil {
+			r.err = err
+		}
+		r.cond.Broadcast()
+		r.mu.Unlock()
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+// attach returns the reader for the next command. With discardEnter, input
+// that is only Enter presses, as users hit to check whether a reconnecting
+// shell is alive, is dropped instead of being run. It also returns how many
+// bytes were dropped since the last command.
+func (r *stdinRelay) attach(discardEnter bool) (io.Reader, int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if discardEnter && onlyEnter(r.buf) {
+		r.buf = r.buf[:0]
+	}
+	dropped := r.dropped
+	r.dropped = 0
+	r.gen++
+	r.reading = true
+	r.cond.Broadcast()
+	return &relayReader{r: r, gen: r.gen}, dropped
+}
+
+// detach stops handing input to the reader, which then reads EOF
+func (r *stdinRelay) detach(reader io.Reader) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if rr, ok := reader.(*relayReader); ok && rr.gen == r.gen {
+		r.gen++
+		r.reading = false
+		r.cond.Broadcast()
+	}
+}
+
+func onlyEnter(b []byte) bool {
+	for _, c := range b {
+		if c != '\r' && c != '\n' {
+			return false
+		}
+	}
+	return len(b) > 0
+}
+
+// relayReader is one command's view of the relay
+type relayReader struct {
+	r   *stdinRelay
+	gen int
+}
+
+func (rr *relayReader) Read(p []byte) (int, error) {
+	r := rr.r
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for r.gen == rr.gen && len(r.buf) == 0 && r.err == nil {
+		r.cond.Wait()
+	}
+	if r.gen != rr.gen {
+		return 0, io.EOF
+	}
+	if len(r.buf) > 0 {
+		n := copy(p, r.buf)
+		r.buf = r.buf[n:]
+		r.cond.Broadcast()
+		return n, nil
+	}
+	return 0, r.err
+}
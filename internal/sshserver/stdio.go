@@ -0,0 +1,60 @@
+package sshserver
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"time"
+)
+
+// ServeConn serves a single SSH connection on conn, e.g. a ProxyCommand's
+// stdin and stdout from StdioConn, and returns once it ended. It counts
+// towards Shutdown like the connections Serve accepts.
+func (srv *Server) ServeConn(ctx context.Context, conn net.Conn) error {
+	if !srv.trackConn() {
+		conn.Close()
+		return errServerClosed
+	}
+	srv.handleConn(ctx, conn, srv.maxRetries)
+	return nil
+}
+
+// stdioAddr is the address of both ends of a StdioConn
+type stdioAddr struct{}
+
+func (stdioAddr) Network() string { return "stdio" }
+func (stdioAddr) String() string  { return "stdio" }
+
+// stdioConn is a connection over a pair of files, read from one and
+// written to the other
+type stdioConn struct {
+	in, out *os.File
+}
+
+// StdioConn returns a connection that reads from in and writes to out, as
+// ssh runs a ProxyCommand with os.Stdin and os.Stdout
+func StdioConn(in, out *os.File) net.Conn {
+	return &stdioConn{in: in, out: out}
+}
+
+func (c *stdioConn) Read(p []byte) (int, error)  { return c.in.Read(p) }
+func (c *stdioConn) Write(p []byte) (int, error) { return c.out.Write(p) }
+func (c *stdioConn) LocalAddr() net.Addr         { return stdioAddr{} }
+func (c *stdioConn) RemoteAddr() net.Addr        { return stdioAddr{} }
+
+// Close closes both files. A blocked Read on a file that can't be polled
+// only returns once ssh closes its end, which it does when it exits.
+func (c *stdioConn) Close() error {
+	return errors.Join(c.in.Close(), c.out.Close())
+}
+
+func (c *stdioConn) SetReadDeadline(t time.Time) error  { return c.in.SetReadDeadline(t) }
+func (c *stdioConn) SetWriteDeadline(t time.Time) error { return c.out.SetWriteDeadline(t) }
+
+func (c *stdioConn) SetDeadline(t time.Time) error {
+	if err := c.in.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.out.SetWriteDeadline(t)
+}
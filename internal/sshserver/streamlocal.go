@@ -0,0 +1,306 @@
+package sshserver
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/superfly/sprites-go"
+	"github.com/vaurdan/sprite-bootstrap/internal/telemetry"
+	"golang.org/x/crypto/ssh"
+)
+
+// maxSocketPath is the longest Unix socket path Linux accepts (sun_path
+// less the terminating NUL)
+const maxSocketPath = 107
+
+// unixDialScript connects to the Unix socket in argv, then opens a one-shot
+// loopback port and prints it ("port <n>", or "error <message>") so the
+// server can reach the socket through the sprite's proxy, which only
+// speaks TCP
+const unixDialScript = `import socket, sys, threading
+def pipe(a, b):
+    try:
+        while True:
+            d = a.recv(65536)
+            if not d:
+                break
+            b.sendall(d)
+    except OSError:
+        pass
+    try:
+        b.shutdown(socket.SHUT_WR)
+    except OSError:
+        pass
+u = socket.socket(socket.AF_UNIX)
+try:
+    u.connect(sys.argv[1])
+except OSError as e:
+    print("error", e.strerror or e, flush=True)
+    sys.exit(1)
+s = socket.socket()
+s.bind(("127.0.0.1", 0))
+s.listen(1)
+s.settimeout(30)
+print("port", s.getsockname()[1], flush=True)
+p, _ = s.accept()
+s.close()
+t = threading.Thread(target=pipe, args=(p, u), daemon=True)
+t.start()
+pipe(u, p)
+t.join()
+`
+
+// streamLocalChannelData is the payload for direct-streamlocal@openssh.com
+// channel requests
+type streamLocalChannelData struct {
+	SocketPath string
+	Reserved0  string
+	Reserved1  uint32
+}
+
+// streamLocalForwardRequest is the payload of streamlocal-forward and
+// cancel-streamlocal-forward requests
+type streamLocalForwardRequest struct {
+	SocketPath string
+}
+
+// forwardedStreamLocalChannelData is the payload for
+// forwarded-streamlocal@openssh.com channels
+type forwardedStreamLocalChannelData struct {
+	SocketPath string
+	Reserved   string
+}
+
+// checkSocketPath validates a Unix socket path on the sprite against the
+// server's allow-list, which holds path.Match patterns. An empty allow-list
+// permits any path.
+func checkSocketPath(p string, allowed []string) error {
+	switch {
+	case !path.IsAbs(p):
+		return errors.New("socket path must be absolute")
+	case path.Clean(p) != p:
+		return errors.New("socket path must be clean")
+	case strings.ContainsRune(p, 0):
+		return errors.New("socket path contains NUL")
+	case len(p) > maxSocketPath:
+		return fmt.Errorf("socket path is longer than %d bytes", maxSocketPath)
+	}
+	if len(allowed) == 0 {
+		return nil
+	}
+	for _, pattern := range allowed {
+		if ok, _ := path.Match(pattern, p); ok {
+			return nil
+		}
+	}
+	return errors.New("socket path is not allowed by the server")
+}
+
+// handleDirectStreamLocal handles direct-streamlocal@openssh.com channel
+// requests, forwarding to a Unix socket on the sprite
+func (c *sshConn) handleDirectStreamLocal(ctx context.Context, newCh ssh.NewChannel, sprite *sprites.Sprite) {
+	c.wg.Add(1)
+	defer c.wg.Done()
+
+	var channelData streamLocalChannelData
+	if err := ssh.Unmarshal(newCh.ExtraData(), &channelData); err != nil {
+		newCh.Reject(ssh.ConnectionFailed, "failed to parse channel data")
+		return
+	}
+	dest := channelData.SocketPath
+
+	if err := c.checkPermit(permitPortForwarding); err != nil {
+		newCh.Reject(ssh.Prohibited, err.Error())
+		return
+	}
+	if err := checkSocketPath(dest, c.srv.allowedSockets); err != nil {
+		logger(ctx).WarnContext(ctx, "Rejected socket forward",
+			"dest", dest,
+			"exception", err)
+		newCh.Reject(ssh.Prohibited, err.Error())
+		return
+	}
+
+	if !c.srv.registry.acquireForward(c.state, c.srv.maxForwardsPerConn, c.srv.maxForwards) {
+		newCh.Reject(ssh.ResourceShortage, "too many port forwards")
+		return
+	}
+	defer c.srv.registry.releaseForward(c.state)
+
+	// The helper exits when the forward ends
+	fwdCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	port, err := dialUnixOnSprite(fwdCtx, sprite, dest)
+	if err != nil {
+		logger(ctx).DebugContext(ctx, "Failed to connect to socket on sprite", "dest", dest, "exception", err)
+		newCh.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+
+	wsConn, _, err := dialProxy(fwdCtx, c.apiURL, c.authToken, sprite.Name(), "127.0.0.1", port, c.srv.wsBufferSize, c.srv.compress, c.srv.tlsConfig, c.srv.proxy)
+	if err != nil {
+		logger(ctx).ErrorContext(ctx, "Failed to open proxy connection", "dest", dest, "exception", err)
+		newCh.Reject(ssh.ConnectionFailed, "failed to reach the sprite")
+		return
+	}
+	defer wsConn.Close()
+
+	ch, reqs, err := newCh.Accept()
+	if err != nil {
+		logger(ctx).ErrorContext(ctx, "Failed to accept direct-streamlocal channel", "exception", err)
+		return
+	}
+	defer ch.Close()
+	go ssh.DiscardRequests(reqs)
+
+	logger(ctx).InfoContext(ctx, "Starting socket forward", "dest", dest)
+
+	fwdCtx, span := telemetry.Start(fwdCtx, "ssh.forward")
+	span.SetString("sprite.name", sprite.Name())
+	span.SetString("forward.dest", dest)
+	defer span.End()
+	var bytesIn, bytesOut atomic.Int64
+	if span != nil {
+		defer func() {
+			span.SetInt("forward.bytes_in", bytesIn.Load())
+			span.SetInt("forward.bytes_out", bytesOut.Load())
+		}()
+	}
+
+	id := c.srv.registry.addForward(c.state, "direct-streamlocal", "", dest, &bytesIn, &bytesOut, func() {
+		cancel()
+		ch.Close()
+	})
+	defer c.srv.registry.removeForward(id)
+
+	c.pipeForward(fwdCtx, span, sprite.Name(), dest, ch, wsConn, 0, &bytesIn, &bytesOut)
+	logger(ctx).DebugContext(ctx, "direct-streamlocal forward completed", "dest", dest)
+}
+
+// dialUnixOnSprite connects to a Unix socket on the sprite and returns the
+// loopback port that leads to it. The helper lives until ctx ends.
+func dialUnixOnSprite(ctx context.Context, sprite *sprites.Sprite, socketPath string) (int, error) {
+	cmd := sprite.CommandContext(ctx, "python3", "-c", unixDialScript, socketPath)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return 0, err
+	}
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		return 0, fmt.Errorf("start socket helper on sprite: %w", err)
+	}
+	result := make(chan error, 1)
+	var port int
+	go func() {
+		defer cmd.Wait()
+		line, err := bufio.NewReader(stdout).ReadString('\n')
+		if err != nil {
+			result <- fmt.Errorf("socket helper on sprite exited (is python3 installed?): %s", strings.TrimSpace(stderr.String()))
+			return
+		}
+		if msg, ok := strings.CutPrefix(strings.TrimSpace(line), "error "); ok {
+			result <- fmt.Errorf("%s: %s", socketPath, msg)
+			return
+		}
+		if _, err := fmt.Sscanf(line, "port %d", &port); err != nil {
+			result <- fmt.Errorf("unexpected socket helper output %q", line)
+			return
+		}
+		result <- nil
+	}()
+
+	select {
+	case err = <-result:
+		return port, err
+	case <-time.After(remoteListenTimeout):
+		return 0, errors.New("timed out connecting to socket on sprite")
+	}
+}
+
+// streamLocalKey is the remoteForwards key of a Unix socket forward
+func streamLocalKey(socketPath string) string {
+	return "unix:" + socketPath
+}
+
+// handleStreamLocalForward creates a Unix socket on the sprite whose
+// connections are relayed back to the client as forwarded-streamlocal
+// channels
+func (c *sshConn) handleStreamLocalForward(ctx context.Context, payload []byte, sprite *sprites.Sprite) error {
+	var req streamLocalForwardRequest
+	if err := ssh.Unmarshal(payload, &req); err != nil {
+		return err
+	}
+	if err := c.checkPermit(permitPortForwarding); err != nil {
+		return err
+	}
+	if err := checkSocketPath(req.SocketPath, c.srv.allowedSockets); err != nil {
+		return err
+	}
+
+	key := streamLocalKey(req.SocketPath)
+	c.rfMu.Lock()
+	_, exists := c.remoteForwards[key]
+	count := len(c.remoteForwards)
+	c.rfMu.Unlock()
+	if exists {
+		return fmt.Errorf("%s is already forwarded", req.SocketPath)
+	}
+	if count >= c.srv.maxRemoteForwards {
+		return fmt.Errorf("too many remote forwards (max %d)", c.srv.maxRemoteForwards)
+	}
+
+	data := ssh.Marshal(&forwardedStreamLocalChannelData{SocketPath: req.SocketPath})
+	l, err := startSpriteListener(ctx, sprite, "unix", req.SocketPath,
+		func(ctx context.Context, localPort int, _ string, _ uint32) {
+			c.bridgeToClient(ctx, sprite, localPort, "forwarded-streamlocal@openssh.com", data, req.SocketPath)
+		})
+	if err != nil {
+		return err
+	}
+
+	fwd := &remoteForward{bindAddr: req.SocketPath, listener: l}
+	c.rfMu.Lock()
+	c.remoteForwards[key] = fwd
+	c.rfMu.Unlock()
+	c.state.remoteForwards.Add(1)
+
+	logger(ctx).InfoContext(ctx, "Started remote socket forward", "socket", req.SocketPath)
+
+	go func() {
+		<-l.done
+		c.rfMu.Lock()
+		if c.remoteForwards[key] == fwd {
+			delete(c.remoteForwards, key)
+		}
+		c.rfMu.Unlock()
+		c.state.remoteForwards.Add(-1)
+	}()
+	return nil
+}
+
+// cancelStreamLocalForward removes a socket created by streamlocal-forward
+func (c *sshConn) cancelStreamLocalForward(payload []byte) error {
+	var req streamLocalForwardRequest
+	if err := ssh.Unmarshal(payload, &req); err != nil {
+		return err
+	}
+
+	key := streamLocalKey(req.SocketPath)
+	c.rfMu.Lock()
+	fwd, ok := c.remoteForwards[key]
+	delete(c.remoteForwards, key)
+	c.rfMu.Unlock()
+	if !ok {
+		return fmt.Errorf("no remote forward on %s", req.SocketPath)
+	}
+	fwd.listener.Close()
+	return nil
+}
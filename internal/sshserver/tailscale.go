@@ -0,0 +1,84 @@
+package sshserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/netip"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// tailnetPrefix is the CGNAT range Tailscale assigns node addresses from
+var tailnetPrefix = netip.MustParsePrefix("100.64.0.0/10")
+
+var errNoTailnet = errors.New("no Tailscale address found (is tailscale up?)")
+
+// TailscaleAddr returns this machine's tailnet IPv4 address by looking for
+// an interface address in 100.64.0.0/10.
+func TailscaleAddr() (netip.Addr, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return netip.Addr{}, err
+	}
+
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, a := range addrs {
+			ipNet, ok := a.(*net.IPNet)
+			if !ok {
+				continue
+			}
+			addr, ok := netip.AddrFromSlice(ipNet.IP)
+			if ok && tailnetPrefix.Contains(addr.Unmap()) {
+				return addr.Unmap(), nil
+			}
+		}
+	}
+
+	return netip.Addr{}, errNoTailnet
+}
+
+// TailscaleHost returns the name clients should use to reach this machine
+// over the tailnet: its MagicDNS name when the tailscale CLI reports one,
+// otherwise its tailnet address.
+func TailscaleHost(ctx context.Context) (string, error) {
+	addr, err := TailscaleAddr()
+	if err != nil {
+		return "", err
+	}
+
+	if name := tailscaleDNSName(ctx); name != "" {
+		return name, nil
+	}
+	return addr.String(), nil
+}
+
+// tailscaleDNSName asks the tailscale CLI for this node's MagicDNS name
+func tailscaleDNSName(ctx context.Context) string {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	out, err := exec.CommandContext(ctx, "tailscale", "status", "--self", "--peers=false", "--json").Output()
+	if err != nil {
+		return ""
+	}
+
+	var status struct {
+		Self struct {
+			DNSName string
+		}
+	}
+	if err := json.Unmarshal(out, &status); err != nil {
+		return ""
+	}
+	return strings.TrimSuffix(status.Self.DNSName, ".")
+}
@@ -0,0 +1,72 @@
+package sshserver
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/vaurdan/sprite-bootstrap/internal/telemetry"
+)
+
+// spanRecorder keeps exported spans in memory
+type spanRecorder struct {
+	mu    sync.Mutex
+	spans []telemetry.SpanData
+}
+
+func (r *spanRecorder) ExportSpans(ctx context.Context, spans []telemetry.SpanData) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = append(r.spans, spans...)
+	return nil
+}
+
+func TestConnectionSpans(t *testing.T) {
+	rec := &spanRecorder{}
+	telemetry.InitWithExporter(rec)
+	defer telemetry.Shutdown(context.Background())
+
+	srv, addr := startTestServer(t, &ServerConfig{})
+	client := dialTestServer(t, addr, "demo", newTestSigner(t))
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out, err := session.Output("echo hi"); err != nil || string(out) != "hi\n" {
+		t.Fatalf("exec = %q, %v", out, err)
+	}
+	client.Close()
+	if err := srv.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := telemetry.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	byName := make(map[string]telemetry.SpanData)
+	for _, s := range rec.spans {
+		byName[s.Name] = s
+	}
+	connSpan, sessionSpan := byName["ssh.connection"], byName["ssh.session"]
+	tests := []struct {
+		span telemetry.SpanData
+		key  string
+	}{
+		{connSpan, "sprite.name"},
+		{connSpan, "conn.id"},
+		{sessionSpan, "sprite.name"},
+		{sessionSpan, "session.bytes_out"},
+	}
+	for _, tt := range tests {
+		found := false
+		for _, a := range tt.span.Attributes {
+			found = found || a.Key == tt.key
+		}
+		if !found {
+			t.Errorf("span %q lacks %s: %+v", tt.span.Name, tt.key, rec.spans)
+		}
+	}
+	if sessionSpan.TraceID != connSpan.TraceID || sessionSpan.ParentID != connSpan.SpanID {
+		t.Error("session span isn't a child of the connection span")
+	}
+}
@@ -0,0 +1,129 @@
+package sshserver
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/superfly/sprites-go"
+)
+
+// fallbackTerm is used when the sprite has no terminfo entry for the
+// client's TERM
+const fallbackTerm = "xterm-256color"
+
+// terminfoTimeout bounds the infocmp probe and the optional tic install
+var terminfoTimeout = 15 * time.Second
+
+// probeTerminfo checks whether the sprite has a terminfo entry for term.
+// It returns found=false only when infocmp ran and reported the entry missing.
+func probeTerminfo(ctx context.Context, sprite *sprites.Sprite, term string) (found bool, err error) {
+	probeCtx, cancel := context.WithTimeout(ctx, terminfoTimeout)
+	defer cancel()
+
+	cmd := sprite.CommandContext(probeCtx, "infocmp", term)
+	cmd.Stdout = io.Discard
+	cmd.Stderr = io.Discard
+
+	var exit *sprites.ExitError
+	if err := cmd.Run(); err != nil {
+		// infocmp exits 1 for an unknown terminal; anything else (e.g. 127
+		// when ncurses isn't installed) tells us nothing
+		if errors.As(err, &exit) && exit.ExitCode() == 1 {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// installTerminfo compiles the local terminfo entry for term on the sprite
+func installTerminfo(ctx context.Context, sprite *sprites.Sprite, term string) error {
+	installCtx, cancel := context.WithTimeout(ctx, terminfoTimeout)
+	defer cancel()
+
+	source, err := exec.CommandContext(installCtx, "infocmp", "-x", term).Output()
+	if err != nil {
+		return fmt.Errorf("local infocmp %s: %w", term, err)
+	}
+
+	var stderr bytes.Buffer
+	cmd := sprite.CommandContext(installCtx, "tic", "-x", "-")
+	cmd.Stdin = bytes.NewReader(source)
+	cmd.Stdout = io.Discard
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("tic on sprite: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// resolveTerm returns the TERM to use on the sprite for the client's term,
+// probing once per connection. Inconclusive probes aren't cached.
+func (c *sshConn) resolveTerm(ctx context.Context, sprite *sprites.Sprite, term string) string {
+	c.termMu.Lock()
+	defer c.termMu.Unlock()
+
+	if resolved, ok := c.terms[term]; ok {
+		return resolved
+	}
+
+	found, err := probeTerminfo(ctx, sprite, term)
+	if err != nil {
+		logger(ctx).DebugContext(ctx, "Terminfo probe failed", "term", term, "exception", err)
+		return term
+	}
+
+	resolved := term
+	if !found {
+		resolved = fallbackTerm
+		if c.installTerminfo {
+			if err := installTerminfo(ctx, sprite, term); err != nil {
+				logger(ctx).WarnContext(ctx, "Failed to install terminfo on sprite",
+					"term", term, "exception", err)
+			} else {
+				logger(ctx).InfoContext(ctx, "Installed terminfo on sprite",
+					"term", term)
+				resolved = term
+			}
+		}
+		if resolved != term {
+			logger(ctx).DebugContext(ctx, "Sprite lacks terminfo entry, using fallback",
+				"term", term, "fallback", resolved)
+		}
+	}
+
+	if c.terms == nil {
+		c.terms = make(map[string]string)
+	}
+	c.terms[term] = resolved
+	return resolved
+}
+
+// resolveTerm swaps the session's TERM for a fallback when the sprite can't
+// handle the one the client asked for.
+func (s *session) resolveTerm(ctx context.Context) {
+	if !s.tty || s.term == "" || s.term == fallbackTerm {
+		return
+	}
+	if resolved := s.conn.resolveTerm(ctx, s.sprite.Load(), s.term); resolved != s.term {
+		s.setEnv("TERM", resolved)
+	}
+}
+
+// setEnv replaces (or adds) a variable in the session environment
+func (s *session) setEnv(name, value string) {
+	prefix := name + "="
+	for i, kv := range s.env {
+		if strings.HasPrefix(kv, prefix) {
+			s.env[i] = prefix + value
+			return
+		}
+	}
+	s.env = append(s.env, prefix+value)
+}
@@ -0,0 +1,129 @@
+package sshserver
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+)
+
+// defaultMaxAuthFailures is used when ServerConfig.MaxAuthFailures is zero
+const defaultMaxAuthFailures = 10
+
+// Failed lookups are counted over authFailureWindow; an address over the
+// limit is refused without a lookup until authThrottleCooldown has passed
+// since its last failure
+var (
+	authFailureWindow    = time.Minute
+	authThrottleCooldown = time.Minute
+)
+
+var errAuthThrottled = errors.New("too many failed lookups from this address")
+
+// authThrottle limits failed sprite lookups per remote IP, so a scanner
+// trying usernames can't turn into a flood of API calls
+type authThrottle struct {
+	max int // Failures allowed per window; 0 disables the throttle
+
+	mu   sync.Mutex
+	byIP map[string]*authFailures
+}
+
+// authFailures are the recent failed lookups from one IP
+type authFailures struct {
+	count     int
+	since     time.Time // Start of the current window
+	last      time.Time // Most recent failure
+	throttled bool      // Over the limit and not yet cooled down
+}
+
+// remoteIP returns the IP of addr without the port
+func remoteIP(addr net.Addr) string {
+	ip := addr.String()
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	return ip
+}
+
+// allow reports whether an attempt from addr may look up a sprite
+func (t *authThrottle) allow(addr net.Addr) bool {
+	if t.max <= 0 {
+		return true
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	f, ok := t.byIP[remoteIP(addr)]
+	if !ok || !f.throttled {
+		return true
+	}
+	if time.Since(f.last) >= authThrottleCooldown {
+		delete(t.byIP, remoteIP(addr))
+		return true
+	}
+	return false
+}
+
+// fail records a failed lookup from addr
+func (t *authThrottle) fail(addr net.Addr) {
+	if t.max <= 0 {
+		return
+	}
+	ip := remoteIP(addr)
+	now := time.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.byIP == nil {
+		t.byIP = make(map[string]*authFailures)
+	}
+	f, ok := t.byIP[ip]
+	if !ok || now.Sub(f.since) > authFailureWindow && !f.throttled {
+		f = &authFailures{since: now}
+		t.byIP[ip] = f
+	}
+	f.count++
+	f.last = now
+	if f.count > t.max && !f.throttled {
+		f.throttled = true
+		slog.Warn("Throttling authentication from address with too many failed lookups",
+			"remote", ip,
+			"failures", f.count,
+			"cooldown", authThrottleCooldown)
+	}
+}
+
+// succeed clears the failures of addr
+func (t *authThrottle) succeed(addr net.Addr) {
+	if t.max <= 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.byIP, remoteIP(addr))
+}
+
+// prune drops addresses whose window and cooldown are over, returning how
+// many were dropped
+func (t *authThrottle) prune(now time.Time) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	dropped := 0
+	for ip, f := range t.byIP {
+		if now.Sub(f.last) >= max(authFailureWindow, authThrottleCooldown) {
+			delete(t.byIP, ip)
+			dropped++
+		}
+	}
+	return dropped
+}
+
+// size returns how many addresses are tracked
+func (t *authThrottle) size() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.byIP)
+}
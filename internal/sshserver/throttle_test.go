@@ -0,0 +1,130 @@
+package sshserver
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestAuthThrottle(t *testing.T) {
+	defer func(d time.Duration) { authThrottleCooldown = d }(authThrottleCooldown)
+	authThrottleCooldown = 50 * time.Millisecond
+
+	a := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1000}
+	aOtherPort := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 2000}
+	b := &net.TCPAddr{IP: net.ParseIP("192.0.2.2"), Port: 1000}
+
+	// Steps are "fail", "succeed", "wait" (the cooldown), or "allow ADDR
+	// WANT" checks
+	tests := []struct {
+		name  string
+		max   int
+		steps []string
+	}{
+		{"under the limit", 3, []string{"fail", "fail", "fail", "allow a true"}},
+		{"over the limit", 3, []string{"fail", "fail", "fail", "fail", "allow a false", "allow b true"}},
+		{"per IP, not port", 3, []string{"fail", "fail", "fail", "fail", "allow aport false"}},
+		{"success resets", 3, []string{"fail", "fail", "fail", "succeed", "fail", "allow a true"}},
+		{"cooldown", 3, []string{"fail", "fail", "fail", "fail", "wait", "allow a true", "fail", "allow a true"}},
+		{"disabled", 0, []string{"fail", "fail", "fail", "fail", "allow a true"}},
+	}
+	addrs := map[string]net.Addr{"a": a, "aport": aOtherPort, "b": b}
+	for _, tt := range tests {
+		th := &authThrottle{max: tt.max}
+		for i, step := range tt.steps {
+			switch f := strings.Fields(step); f[0] {
+			case "fail":
+				th.fail(a)
+			case "succeed":
+				th.succeed(a)
+			case "wait":
+				time.Sleep(authThrottleCooldown)
+			case "allow":
+				if got := th.allow(addrs[f[1]]); fmt.Sprint(got) != f[2] {
+					t.Errorf("%s: step %d: allow(%s) = %v, want %s", tt.name, i, f[1], got, f[2])
+				}
+			}
+		}
+	}
+}
+
+func TestAuthThrottleConcurrent(t *testing.T) {
+	th := &authThrottle{max: 10}
+	var wg sync.WaitGroup
+	for i := range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			addr := &net.TCPAddr{IP: net.IPv4(192, 0, 2, byte(i%5)), Port: 1000 + i}
+			for range 20 {
+				th.allow(addr)
+				th.fail(addr)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := th.size(); n != 5 {
+		t.Errorf("tracking %d addresses, want 5", n)
+	}
+	for i := range 5 {
+		if th.allow(&net.TCPAddr{IP: net.IPv4(192, 0, 2, byte(i))}) {
+			t.Errorf("192.0.2.%d not throttled after 200 failures", i)
+		}
+	}
+	if n := th.prune(time.Now().Add(time.Hour)); n != 5 || th.size() != 0 {
+		t.Errorf("prune dropped %d, %d left; want all 5 dropped", n, th.size())
+	}
+}
+
+// lookupAPI records the sprite names looked up through it
+type lookupAPI struct {
+	next http.Handler
+
+	mu    sync.Mutex
+	names map[string]bool
+}
+
+func (a *lookupAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if name, ok := strings.CutPrefix(r.URL.Path, "/v1/sprites/"); ok && r.Method == http.MethodGet {
+		a.mu.Lock()
+		a.names[name] = true
+		a.mu.Unlock()
+	}
+	a.next.ServeHTTP(w, r)
+}
+
+func TestAuthThrottleBurst(t *testing.T) {
+	api := &lookupAPI{next: newFakeAPI(t), names: make(map[string]bool)}
+	_, addr := startTestServer(t, &ServerConfig{
+		TokenOptions:    testTokenOptions(t, api),
+		MaxAuthFailures: 3,
+	})
+	signer := newTestSigner(t)
+
+	// A scanner trying names the API doesn't know
+	for i := range 10 {
+		client, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+			User:            fmt.Sprintf("No_Such_Sprite_%d", i),
+			Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+			Timeout:         10 * time.Second,
+		})
+		if err == nil {
+			client.Close()
+			t.Fatalf("login as an unknown sprite succeeded")
+		}
+	}
+	// The failure that goes over the limit is the last one looked up
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	if len(api.names) != 4 {
+		t.Errorf("looked up %d of 10 bad names, want 4: %v", len(api.names), api.names)
+	}
+}
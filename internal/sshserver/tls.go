@@ -0,0 +1,146 @@
+package sshserver
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/superfly/sprites-go"
+)
+
+// Environment variables for TLS to a self-hosted sprites API, used by
+// serve when the matching flags aren't given
+const (
+	EnvCACert             = "SPRITES_CA_CERT"
+	EnvClientCert         = "SPRITES_CLIENT_CERT"
+	EnvClientKey          = "SPRITES_CLIENT_KEY"
+	EnvInsecureSkipVerify = "SPRITES_INSECURE_SKIP_VERIFY"
+)
+
+// apiTimeout matches the sprites client's default request timeout
+const apiTimeout = 30 * time.Second
+
+// TLSOptions configures TLS to the sprites API: the REST calls, exec
+// sessions and proxy WebSockets alike
+type TLSOptions struct {
+	// CACertFile is a PEM bundle trusted in addition to the system roots
+	CACertFile string
+
+	// ClientCertFile and ClientKeyFile are a PEM client certificate and
+	// its key, for APIs that require mutual TLS. Both or neither.
+	ClientCertFile string
+	ClientKeyFile  string
+
+	// InsecureSkipVerify accepts any server certificate. For testing only.
+	InsecureSkipVerify bool
+}
+
+// TLSOptionsFromEnv reads TLSOptions from the SPRITES_* variables
+func TLSOptionsFromEnv() (TLSOptions, error) {
+	opts := TLSOptions{
+		CACertFile:     os.Getenv(EnvCACert),
+		ClientCertFile: os.Getenv(EnvClientCert),
+		ClientKeyFile:  os.Getenv(EnvClientKey),
+	}
+	if v := os.Getenv(EnvInsecureSkipVerify); v != "" {
+		insecure, err := strconv.ParseBool(v)
+		if err != nil {
+			return opts, fmt.Errorf("invalid %s %q: %w", EnvInsecureSkipVerify, v, err)
+		}
+		opts.InsecureSkipVerify = insecure
+	}
+	return opts, nil
+}
+
+// config builds the tls.Config, or nil when no option is set so the
+// defaults apply
+func (o TLSOptions) config() (*tls.Config, error) {
+	if o == (TLSOptions{}) {
+		return nil, nil
+	}
+	cfg := &tls.Config{InsecureSkipVerify: o.InsecureSkipVerify}
+
+	if o.CACertFile != "" {
+		pem, err := os.ReadFile(o.CACertFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA certificate: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", o.CACertFile)
+		}
+		cfg.RootCAs = pool
+	}
+
+	if (o.ClientCertFile == "") != (o.ClientKeyFile == "") {
+		return nil, errors.New("a client certificate needs both the certificate and the key file")
+	}
+	if o.ClientCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(o.ClientCertFile, o.ClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// clientOptions returns the sprites client options that apply tlsConfig and
+// proxy to its REST calls
+func clientOptions(apiURL string, tlsConfig *tls.Config, proxy proxyFunc) []sprites.Option {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	transport.Proxy = proxy
+	return []sprites.Option{
+		sprites.WithBaseURL(apiURL),
+		sprites.WithHTTPClient(&http.Client{Timeout: apiTimeout, Transport: transport}),
+	}
+}
+
+// useExecDialer applies tlsConfig and proxy to the WebSockets the sprites
+// client opens for exec sessions. Those always use websocket.DefaultDialer
+// and replace its TLSClientConfig, so the handshake is done in the TLS dial
+// hook instead. The dialer's own Proxy is cleared: it would send the
+// connection to the proxy through the TLS hook too.
+func useExecDialer(tlsConfig *tls.Config, proxy proxyFunc) {
+	dialer := *websocket.DefaultDialer
+	dialer.Proxy = nil
+	dialer.NetDialContext = func(ctx context.Context, _, addr string) (net.Conn, error) {
+		return dialVia(ctx, proxy, "http", addr)
+	}
+	dialer.NetDialTLSContext = func(ctx context.Context, _, addr string) (net.Conn, error) {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		cfg := &tls.Config{}
+		if tlsConfig != nil {
+			cfg = tlsConfig.Clone()
+		}
+		if cfg.ServerName == "" {
+			cfg.ServerName = host
+		}
+		conn, err := dialVia(ctx, proxy, "https", addr)
+		if err != nil {
+			return nil, err
+		}
+		tlsConn := tls.Client(conn, cfg)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return tlsConn, nil
+	}
+	websocket.DefaultDialer = &dialer
+}
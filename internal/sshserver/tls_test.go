@@ -0,0 +1,211 @@
+package sshserver
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// writeTestCert signs a certificate from tmpl with parentKey, or self-signs
+// it when parent is nil, and writes it and its key as PEM files in dir
+func writeTestCert(t *testing.T, dir, name string, tmpl, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey, string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parent == nil {
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile := filepath.Join(dir, name+".pem")
+	keyFile := filepath.Join(dir, name+"-key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return cert, key, certFile, keyFile
+}
+
+// TestProxyTLS dials the proxy WebSocket of a fake API whose certificate a
+// private CA signed, with and without a client certificate required
+func TestProxyTLS(t *testing.T) {
+	dir := t.TempDir()
+	notAfter := time.Now().Add(time.Hour)
+
+	// A private CA signs the server's certificate and the client's
+	ca, caKey, caFile, _ := writeTestCert(t, dir, "ca", &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotAfter:              notAfter,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil, nil)
+	_, serverKey, serverFile, _ := writeTestCert(t, dir, "server", &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		NotAfter:     notAfter,
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca, caKey)
+	_, _, clientFile, clientKeyFile := writeTestCert(t, dir, "client", &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: "client"},
+		NotAfter:     notAfter,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca, caKey)
+	// A client certificate from a CA the server doesn't trust
+	_, _, strangerFile, strangerKeyFile := writeTestCert(t, dir, "stranger", &x509.Certificate{
+		SerialNumber: big.NewInt(4),
+		Subject:      pkix.Name{CommonName: "stranger"},
+		NotAfter:     notAfter,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, nil, nil)
+
+	serverPEM, err := os.ReadFile(serverFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverBlock, _ := pem.Decode(serverPEM)
+	serverCert := tls.Certificate{Certificate: [][]byte{serverBlock.Bytes}, PrivateKey: serverKey}
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca)
+
+	startAPI := func(mutual bool) string {
+		ts := httptest.NewUnstartedServer(newFakeAPI(t))
+		ts.TLS = &tls.Config{Certificates: []tls.Certificate{serverCert}}
+		if mutual {
+			ts.TLS.ClientAuth = tls.RequireAndVerifyClientCert
+			ts.TLS.ClientCAs = clientCAs
+		}
+		ts.StartTLS()
+		t.Cleanup(ts.Close)
+		return ts.URL
+	}
+	plainAPI, mutualAPI := startAPI(false), startAPI(true)
+
+	_, echoPort, err := net.SplitHostPort(startEchoServer(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, _ := strconv.Atoi(echoPort)
+
+	tests := []struct {
+		name   string
+		api    string
+		opts   TLSOptions
+		wantOK bool
+	}{
+		{"system roots only", plainAPI, TLSOptions{}, false},
+		{"private CA", plainAPI, TLSOptions{CACertFile: caFile}, true},
+		{"insecure", plainAPI, TLSOptions{InsecureSkipVerify: true}, true},
+		{"server's own cert as CA", plainAPI, TLSOptions{CACertFile: serverFile}, true},
+		{"mutual without client cert", mutualAPI, TLSOptions{CACertFile: caFile}, false},
+		{"mutual with client cert", mutualAPI, TLSOptions{CACertFile: caFile, ClientCertFile: clientFile, ClientKeyFile: clientKeyFile}, true},
+		{"mutual with untrusted client cert", mutualAPI, TLSOptions{CACertFile: caFile, ClientCertFile: strangerFile, ClientKeyFile: strangerKeyFile}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tlsConfig, err := tt.opts.config()
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			wsConn, _, err := dialProxy(ctx, tt.api, "test", "tls-test", "127.0.0.1", port, defaultWSBufferSize, false, tlsConfig, nil)
+			if !tt.wantOK {
+				if err == nil {
+					wsConn.Close()
+					t.Fatal("dialProxy succeeded, want a TLS failure")
+				}
+				if !strings.Contains(err.Error(), "certificate") {
+					t.Fatalf("dialProxy error = %v, want a certificate error", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("dialProxy: %v", err)
+			}
+			defer wsConn.Close()
+
+			if err := wsConn.WriteMessage(websocket.BinaryMessage, []byte("ping")); err != nil {
+				t.Fatal(err)
+			}
+			_ = wsConn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			_, data, err := wsConn.ReadMessage()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != "ping" {
+				t.Errorf("echo = %q, want %q", data, "ping")
+			}
+		})
+	}
+}
+
+// TestTLSOptionsConfig rejects options that can't make a tls.Config
+func TestTLSOptionsConfig(t *testing.T) {
+	dir := t.TempDir()
+	notPEM := filepath.Join(dir, "not.pem")
+	if err := os.WriteFile(notPEM, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		opts    TLSOptions
+		wantNil bool
+		wantErr bool
+	}{
+		{"unset", TLSOptions{}, true, false},
+		{"insecure", TLSOptions{InsecureSkipVerify: true}, false, false},
+		{"missing CA file", TLSOptions{CACertFile: filepath.Join(dir, "missing.pem")}, false, true},
+		{"CA file without certificates", TLSOptions{CACertFile: notPEM}, false, true},
+		{"client cert without key", TLSOptions{ClientCertFile: notPEM}, false, true},
+		{"client key without cert", TLSOptions{ClientKeyFile: notPEM}, false, true},
+		{"unreadable client cert", TLSOptions{ClientCertFile: notPEM, ClientKeyFile: notPEM}, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := tt.opts.config()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("config() error = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && (cfg == nil) != tt.wantNil {
+				t.Errorf("config() = %v, want nil %v", cfg, tt.wantNil)
+			}
+		})
+	}
+}
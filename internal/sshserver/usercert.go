@@ -0,0 +1,133 @@
+package sshserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// forceCommandOption is the certificate critical option that replaces
+// whatever the client asks to run, as in OpenSSH. source-address, the
+// other one OpenSSH knows, is enforced by the SSH library.
+const forceCommandOption = "force-command"
+
+// Certificate extensions that allow what a certificate without them may
+// not do, as in OpenSSH
+const (
+	permitPTY             = "permit-pty"
+	permitPortForwarding  = "permit-port-forwarding"
+	permitAgentForwarding = "permit-agent-forwarding"
+)
+
+// certAuthExtension marks the Permissions of a certificate login, whose
+// extensions then limit the connection. It isn't a name OpenSSH uses, so no
+// certificate carries it.
+const certAuthExtension = "cert-auth@sprite-bootstrap"
+
+var (
+	errCertRequired = errors.New("a certificate signed by a trusted user CA is required")
+	errForcedEnv    = errors.New("environment variable not allowed with a forced command")
+)
+
+// checkUserCert validates a user certificate against the trusted CAs, the
+// way OpenSSH does with TrustedUserCAKeys: one of its principals must be
+// the sprite name (or the full username, or one of AuthorizedPrincipals),
+// it must be within its validity period, carry only critical options we
+// enforce, and neither it nor its CA may be revoked. Plain keys are
+// rejected.
+func (srv *Server) checkUserCert(cm ssh.ConnMetadata, pub ssh.PublicKey) (*ssh.Permissions, error) {
+	cert, ok := pub.(*ssh.Certificate)
+	if !ok {
+		return nil, errCertRequired
+	}
+	if cert.CertType != ssh.UserCert {
+		return nil, fmt.Errorf("certificate has type %d, not a user certificate", cert.CertType)
+	}
+	if !srv.userCAs.Allows(cert.SignatureKey) {
+		return nil, fmt.Errorf("certificate signed by an untrusted CA (%s)", ssh.FingerprintSHA256(cert.SignatureKey))
+	}
+
+	// Unlike ssh.CertChecker, OpenSSH doesn't take a certificate without
+	// principals as valid for everyone
+	name, _ := parseUser(cm.User())
+	i := slices.IndexFunc(cert.ValidPrincipals, func(p string) bool {
+		return p == name || p == cm.User() || slices.Contains(srv.principals, p)
+	})
+	if i < 0 {
+		return nil, fmt.Errorf("no certificate principal allowed for %s (has %q)", cm.User(), cert.ValidPrincipals)
+	}
+
+	checker := ssh.CertChecker{
+		SupportedCriticalOptions: []string{forceCommandOption},
+		IsRevoked: func(cert *ssh.Certificate) bool {
+			return srv.revokedKeys != nil &&
+				(srv.revokedKeys.Allows(cert.Key) || srv.revokedKeys.Allows(cert.SignatureKey))
+		},
+	}
+	if err := checker.CheckCert(cert.ValidPrincipals[i], cert); err != nil {
+		return nil, err
+	}
+
+	// The library checks source-address against the client address once
+	// the callback returns, from the returned critical options
+	extensions := maps.Clone(cert.Extensions)
+	if extensions == nil {
+		extensions = make(map[string]string)
+	}
+	extensions[certAuthExtension] = ""
+	return &ssh.Permissions{
+		CriticalOptions: cert.CriticalOptions,
+		Extensions:      extensions,
+	}, nil
+}
+
+// certExtensions returns the extensions of the certificate a connection
+// logged in with, or nil if it didn't use one
+func certExtensions(perms *ssh.Permissions) map[string]string {
+	if perms == nil {
+		return nil
+	}
+	if _, ok := perms.Extensions[certAuthExtension]; !ok {
+		return nil
+	}
+	return perms.Extensions
+}
+
+// checkPermit returns an error unless the connection's certificate has
+// the given permit-* extension. Connections that didn't log in with a
+// certificate aren't limited.
+func (c *sshConn) checkPermit(extension string) error {
+	if c.certExtensions == nil {
+		return nil
+	}
+	if _, ok := c.certExtensions[extension]; !ok {
+		return fmt.Errorf("certificate does not have %s", extension)
+	}
+	return nil
+}
+
+// forcedEnvAllowed reports whether an env request may be passed on to a
+// forced command. Anything else could change what runs: SPRITE_WRAPPER and
+// the other overrides directly, and variables like BASH_ENV or LD_PRELOAD
+// through the shell.
+func forcedEnvAllowed(name string) bool {
+	return name == "TERM" || name == "LANG" || strings.HasPrefix(name, "LC_")
+}
+
+// execForced runs the certificate's forced command in place of what the
+// client asked for, which is passed on in SSH_ORIGINAL_COMMAND as OpenSSH
+// does
+func (s *session) execForced(ctx context.Context, original string, maxRetries int) error {
+	if s.running.Load() {
+		return errAlreadyRunning
+	}
+	if original != "" {
+		s.setEnv("SSH_ORIGINAL_COMMAND", original)
+	}
+	return s.exec(ctx, s.conn.forceCommand, false, maxRetries)
+}
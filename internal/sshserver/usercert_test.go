@@ -0,0 +1,130 @@
+package sshserver
+
+import (
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// newTestCert signs a user certificate for the sprite with ca
+func newTestCert(t *testing.T, ca ssh.Signer, sprite string, options, extensions map[string]string) ssh.Signer {
+	t.Helper()
+	key := newTestSigner(t)
+	cert := &ssh.Certificate{
+		Key:             key.PublicKey(),
+		CertType:        ssh.UserCert,
+		ValidPrincipals: []string{sprite},
+		ValidBefore:     ssh.CertTimeInfinity,
+		Permissions:     ssh.Permissions{CriticalOptions: options, Extensions: extensions},
+	}
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewCertSigner(cert, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+// startCertServer starts a test server that trusts ca for user certificates
+func startCertServer(t *testing.T, ca ssh.Signer) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "user_ca.pub")
+	if err := os.WriteFile(path, ssh.MarshalAuthorizedKey(ca.PublicKey()), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cas, err := LoadTrustedUserCAKeys(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, addr := startTestServer(t, &ServerConfig{TrustedUserCAKeys: cas})
+	return addr
+}
+
+func TestForceCommandIgnoresClientEnv(t *testing.T) {
+	ca := newTestSigner(t)
+	addr := startCertServer(t, ca)
+	cert := newTestCert(t, ca, "demo", map[string]string{forceCommandOption: "echo forced"}, nil)
+
+	tests := []struct {
+		name, value string
+		allowed     bool
+	}{
+		{envWrapper, "sh -c 'echo wrapped' --", false},
+		{envRawExec, "true", false},
+		{envShell, "/bin/bash", false},
+		{"BASH_ENV", "/tmp/evil", false},
+		{"LD_PRELOAD", "/tmp/evil.so", false},
+		{"TERM", "xterm", true},
+		{"LANG", "C.UTF-8", true},
+		{"LC_ALL", "C", true},
+	}
+
+	client := dialTestServer(t, addr, "demo", cert)
+	sess, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		err := sess.Setenv(tt.name, tt.value)
+		if got := err == nil; got != tt.allowed {
+			t.Errorf("Setenv(%s) allowed = %v, want %v", tt.name, got, tt.allowed)
+		}
+	}
+
+	out, err := sess.CombinedOutput("echo client")
+	if err != nil {
+		t.Fatalf("run: %v (%q)", err, out)
+	}
+	if got := strings.TrimSpace(string(out)); got != "forced" {
+		t.Errorf("output = %q, want only the forced command's", got)
+	}
+}
+
+func TestCertExtensions(t *testing.T) {
+	ca := newTestSigner(t)
+	addr := startCertServer(t, ca)
+
+	all := map[string]string{permitPTY: "", permitPortForwarding: "", permitAgentForwarding: ""}
+	tests := []struct {
+		name       string
+		extensions map[string]string
+		permitted  bool
+	}{
+		{"none", nil, false},
+		{"all", all, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := dialTestServer(t, addr, "demo", newTestCert(t, ca, "demo", nil, tt.extensions))
+
+			sess, err := client.NewSession()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer sess.Close()
+			if err := sess.RequestPty("xterm", 24, 80, nil); (err == nil) != tt.permitted {
+				t.Errorf("pty-req error = %v, want permitted %v", err, tt.permitted)
+			}
+			if err := agent.RequestAgentForwarding(sess); (err == nil) != tt.permitted {
+				t.Errorf("agent forwarding error = %v, want permitted %v", err, tt.permitted)
+			}
+
+			// The port is closed, so a permitted forward fails to connect
+			// rather than being prohibited
+			_, err = client.Dial("tcp", "127.0.0.1:1")
+			if prohibited := err != nil && strings.Contains(err.Error(), permitPortForwarding); prohibited == tt.permitted {
+				t.Errorf("direct-tcpip error = %v, want permitted %v", err, tt.permitted)
+			}
+			if _, err := client.Listen("tcp", "127.0.0.1:0"); !tt.permitted && err == nil {
+				t.Error("tcpip-forward allowed without permit-port-forwarding")
+			}
+		})
+	}
+}
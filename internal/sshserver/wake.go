@@ -0,0 +1,237 @@
+package sshserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/superfly/sprites-go"
+)
+
+// Wake timeouts, shared by every connection waiting on the same wake.
+// Sprites that are asleep get longer, since they boot before answering.
+var (
+	lookupTimeout   = 30 * time.Second
+	wakeTimeout     = 20 * time.Second
+	coldWakeTimeout = 2 * time.Minute
+)
+
+// authWakeWait is how long authentication waits for a sleeping sprite to
+// wake before letting the client in; the first session waits for the rest
+// of the wake, telling TTY clients what is going on
+var authWakeWait = 5 * time.Second
+
+// spriteRunning is the status of a sprite that is awake
+const spriteRunning = "running"
+
+// wakeCacheTTL is how long a successful wake is reused by new connections
+var wakeCacheTTL = 10 * time.Second
+
+// wakeGroup coalesces the lookup and wake of a sprite, so connections that
+// arrive together (e.g. VS Code reconnecting after sleep) share one wake
+// instead of each calling the API
+type wakeGroup struct {
+	mu    sync.Mutex
+	calls map[string]*wakeCall
+}
+
+// wakeCall is one lookup and wake, in flight or recently completed
+type wakeCall struct {
+	looked chan struct{} // Closed once the sprite was looked up
+	done   chan struct{} // Closed when the wake completes too
+	sprite *sprites.Sprite
+	route  *orgRoute
+	asleep bool // The sprite wasn't running when looked up
+	err    error
+}
+
+// asleep reports whether a sprite has to boot before it can run commands.
+// Sprites whose status is unknown are taken to be awake.
+func asleep(sprite *sprites.Sprite) bool {
+	return sprite.Status != "" && sprite.Status != spriteRunning
+}
+
+// wakeSprite looks up a sprite by SSH username and wakes it up, sharing the
+// work with concurrent and recent calls for the same sprite. The shared wake
+// runs under its own timeouts; ctx only bounds how long this caller waits.
+// A sleeping sprite is only waited for up to authWakeWait, after which the
+// call is returned with its wake still in progress.
+func (srv *Server) wakeSprite(ctx context.Context, name string) (*wakeCall, error) {
+	g := &srv.wakes
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*wakeCall)
+	}
+	call, ok := g.calls[name]
+	if !ok {
+		call = &wakeCall{looked: make(chan struct{}), done: make(chan struct{})}
+		g.calls[name] = call
+		go srv.runWake(name, call)
+	} else {
+		logger(ctx).DebugContext(ctx, "Joining wake in progress", "sprite.name", name)
+	}
+	g.mu.Unlock()
+
+	select {
+	case <-call.looked:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if call.err != nil {
+		return nil, call.err
+	}
+
+	var wait <-chan time.Time
+	if call.asleep {
+		t := time.NewTimer(authWakeWait)
+		defer t.Stop()
+		wait = t.C
+	}
+	select {
+	case <-call.done:
+	case <-wait:
+		logger(ctx).DebugContext(ctx, "Sprite still waking, accepting connection", "sprite.name", name)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return call, nil
+}
+
+// runWake performs a shared wake and keeps a successful result for
+// wakeCacheTTL
+func (srv *Server) runWake(name string, call *wakeCall) {
+	start := time.Now()
+	call.sprite, call.route, call.err = srv.lookupSprite(name)
+	if call.err == nil {
+		call.asleep = asleep(call.sprite)
+	}
+	close(call.looked)
+	if call.err == nil {
+		srv.wake(call.sprite, call.asleep)
+	}
+	close(call.done)
+
+	forget := func() {
+		srv.wakes.mu.Lock()
+		if srv.wakes.calls[name] == call {
+			delete(srv.wakes.calls, name)
+		}
+		srv.wakes.mu.Unlock()
+	}
+	if call.err != nil {
+		forget()
+		return
+	}
+	slog.Debug("Woke sprite", "sprite.name", name, "org", call.route.org, "duration", time.Since(start))
+	time.AfterFunc(wakeCacheTTL, forget)
+}
+
+// lookupSprite gets the sprite from whichever organization has it
+func (srv *Server) lookupSprite(name string) (*sprites.Sprite, *orgRoute, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), lookupTimeout)
+	defer cancel()
+
+	// A token rejected since "sprite login" rotated it is reloaded, and
+	// the lookup tried once more
+	gen := srv.creds.gen.Load()
+	sprite, route, err := srv.orgs.lookup(ctx, name)
+	if tokenRejected(err) && srv.refreshCredentials(ctx, gen) {
+		sprite, route, err = srv.orgs.lookup(ctx, name)
+	}
+	srv.health.record(err)
+	if err != nil {
+		return nil, nil, err
+	}
+	return sprite, route, nil
+}
+
+// wake runs a no-op command on the sprite, which the API holds until the
+// sprite is up. This makes sure the sprite is fully responsive before VS
+// Code tries to start its server; without it, reconnections after sleep can
+// fail with "Failed to parse remote port".
+func (srv *Server) wake(sprite *sprites.Sprite, asleep bool) {
+	timeout := wakeTimeout
+	if asleep {
+		timeout = coldWakeTimeout
+		slog.Info("Waking sleeping sprite",
+			"sprite.name", sprite.Name(),
+			"sprite.status", sprite.Status)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := sprite.CommandContext(ctx, "true")
+	cmd.Stdout = io.Discard
+	cmd.Stderr = io.Discard
+	if err := cmd.Run(); err != nil {
+		slog.Warn("Failed to wake sprite",
+			"sprite.name", sprite.Name(),
+			"exception", err)
+		// Continue anyway - the sprite might still work
+	}
+}
+
+// waitAwake holds a session's first command until the sprite it connected
+// to has woken, telling TTY clients why it takes a moment
+func (s *session) waitAwake(ctx context.Context) {
+	select {
+	case <-s.conn.woken:
+		return
+	default:
+	}
+	if s.tty {
+		fmt.Fprintf(s.ch, "\033[33m[sprite] Waking %s...\033[0m\r\n", s.sprite.Load().Name())
+	}
+	select {
+	case <-s.conn.woken:
+	case <-ctx.Done():
+	}
+}
+
+// startFailure reports whether err could come from a sprite that isn't up
+// yet: a transient error, or the API answering that it's unavailable
+func startFailure(err error) bool {
+	var apiErr *sprites.APIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode >= http.StatusInternalServerError {
+		return true
+	}
+	return shouldRetry(err)
+}
+
+// spriteStarting reports whether a failed command is down to the sprite
+// still starting rather than the network: its wake hasn't finished, or the
+// API doesn't report it running yet
+func (s *session) spriteStarting(ctx context.Context) bool {
+	select {
+	case <-s.conn.woken:
+	default:
+		return true
+	}
+	route, err := s.conn.srv.orgs.route(s.conn.org)
+	if err != nil {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(ctx, lookupTimeout)
+	defer cancel()
+	sprite, err := route.client.GetSprite(ctx, s.sprite.Load().Name())
+	return err == nil && asleep(sprite)
+}
+
+// forgetDone drops completed wakes, whose sprites may carry credentials
+// that were since replaced
+func (g *wakeGroup) forgetDone() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for name, call := range g.calls {
+		select {
+		case <-call.done:
+			delete(g.calls, name)
+		default:
+		}
+	}
+}
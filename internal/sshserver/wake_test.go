@@ -0,0 +1,86 @@
+package sshserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// sleepingAPI reports every sprite as cold and counts lookups and wakes.
+// Wakes take wakeDelay, like a sprite booting.
+type sleepingAPI struct {
+	next      http.Handler
+	wakeDelay time.Duration
+	lookups   atomic.Int64
+	wakes     atomic.Int64
+}
+
+func (a *sleepingAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/v1/sprites/demo":
+		a.lookups.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"id": "fake-demo", "name": "demo", "organization": "fake", "status": "cold",
+		})
+		return
+	case r.URL.Path == "/v1/sprites/demo/exec" && r.URL.Query().Get("cmd") == "true":
+		a.wakes.Add(1)
+		time.Sleep(a.wakeDelay)
+	}
+	a.next.ServeHTTP(w, r)
+}
+
+func TestWakeCoalesced(t *testing.T) {
+	tests := []struct {
+		name        string
+		connections int
+		wantLookups int64
+		wantWakes   int64
+	}{
+		{"one connection", 1, 1, 1},
+		{"reconnect burst", 10, 1, 1},
+	}
+	for _, tt := range tests {
+		api := &sleepingAPI{next: newFakeAPI(t), wakeDelay: 300 * time.Millisecond}
+		_, addr := startTestServer(t, &ServerConfig{TokenOptions: testTokenOptions(t, api)})
+		signer := newTestSigner(t)
+
+		var wg sync.WaitGroup
+		errs := make(chan error, tt.connections)
+		for range tt.connections {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				client, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+					User:            "demo",
+					Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+					HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+					Timeout:         10 * time.Second,
+				})
+				if err != nil {
+					errs <- err
+					return
+				}
+				client.Close()
+			}()
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			t.Errorf("%s: connection failed: %v", tt.name, err)
+		}
+
+		if n := api.lookups.Load(); n != tt.wantLookups {
+			t.Errorf("%s: %d sprite lookups, want %d", tt.name, n, tt.wantLookups)
+		}
+		if n := api.wakes.Load(); n != tt.wantWakes {
+			t.Errorf("%s: %d wakes, want %d", tt.name, n, tt.wantWakes)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package sshserver
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// defaultWarmProxies is how many warm proxy WebSockets are kept per
+	// sprite
+	defaultWarmProxies = 2
+
+	// defaultWarmProxyIdle is how long a warm proxy WebSocket is kept
+	// unused before it is closed
+	defaultWarmProxyIdle = 30 * time.Second
+
+	// warmInitTimeout bounds the init exchange on a warm WebSocket, which
+	// the API may have dropped while it sat idle
+	warmInitTimeout = 5 * time.Second
+)
+
+// warmKey is the proxy endpoint and credentials a WebSocket was opened with
+type warmKey struct {
+	apiURL    string
+	authToken string
+	sprite    string
+}
+
+// warmConn is an idle proxy WebSocket waiting for a forward
+type warmConn struct {
+	ws    *websocket.Conn
+	timer *time.Timer
+}
+
+// warmProxies keeps proxy WebSockets to sprites with recent forwards open
+// ahead of time, with TCP, TLS and the upgrade done. The proxy binds a
+// WebSocket to one destination with its init message, so connections
+// aren't reused after a forward ends: each one taken is replaced in the
+// background, and unused ones are closed after maxIdle.
+type warmProxies struct {
+	size       int // Per sprite; zero disables warming
+	maxIdle    time.Duration
+	bufferSize int
+	compress   bool
+	tlsConfig  *tls.Config
+	proxy      proxyFunc
+
+	ctx    context.Context // Canceled by close, aborting dials in flight
+	cancel context.CancelFunc
+
+	mu      sync.Mutex
+	idle    map[warmKey][]*warmConn
+	dialing map[warmKey]int
+	closed  bool
+}
+
+func newWarmProxies(size int, maxIdle time.Duration, bufferSize int, compress bool, tlsConfig *tls.Config, proxy proxyFunc) *warmProxies {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &warmProxies{
+		size:       size,
+		maxIdle:    maxIdle,
+		bufferSize: bufferSize,
+		compress:   compress,
+		tlsConfig:  tlsConfig,
+		proxy:      proxy,
+		ctx:        ctx,
+		cancel:     cancel,
+		idle:       make(map[warmKey][]*warmConn),
+		dialing:    make(map[warmKey]int),
+	}
+}
+
+// dial opens a proxy connection to host:port inside the sprite, on a warm
+// WebSocket when one is ready. A warm WebSocket that fails before the proxy
+// answers is replaced by a fresh dial. Either way the sprite's warm
+// WebSockets are topped up for the next forward.
+func (w *warmProxies) dial(ctx context.Context, key warmKey, host string, port int) (*websocket.Conn, string, error) {
+	defer w.fill(key)
+
+	if ws := w.take(key); ws != nil {
+		target, err := initProxy(ws, host, port, warmInitTimeout)
+		if err == nil || errors.Is(err, errProxyRefused) {
+			return ws, target, err
+		}
+		logger(ctx).DebugContext(ctx, "Warm proxy connection failed, dialing a new one", "exception", err)
+	}
+	return dialProxy(ctx, key.apiURL, key.authToken, key.sprite, host, port, w.bufferSize, w.compress, w.tlsConfig, w.proxy)
+}
+
+// take removes the most recently opened idle WebSocket for key, or returns
+// nil
+func (w *warmProxies) take(key warmKey) *websocket.Conn {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	conns := w.idle[key]
+	for len(conns) > 0 {
+		wc := conns[len(conns)-1]
+		conns = conns[:len(conns)-1]
+		if wc.timer.Stop() {
+			w.setIdle(key, conns)
+			return wc.ws
+		}
+		// Its eviction is already under way
+	}
+	w.setIdle(key, conns)
+	return nil
+}
+
+// fill dials WebSockets for key in the background until size are idle or
+// on their way
+func (w *warmProxies) fill(key warmKey) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return
+	}
+	for n := len(w.idle[key]) + w.dialing[key]; n < w.size; n++ {
+		w.dialing[key]++
+		go w.warm(key)
+	}
+}
+
+// warm opens one WebSocket for key and adds it to the idle ones
+func (w *warmProxies) warm(key warmKey) {
+	ctx, cancel := context.WithTimeout(w.ctx, apiTimeout)
+	defer cancel()
+	ws, err := dialProxyWS(ctx, key.apiURL, key.authToken, key.sprite, w.bufferSize, w.compress, w.tlsConfig, w.proxy)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.dialing[key]--; w.dialing[key] == 0 {
+		delete(w.dialing, key)
+	}
+	if err != nil {
+		slog.Debug("Failed to open warm proxy connection", "sprite.name", key.sprite, "exception", err)
+		return
+	}
+	if w.closed {
+		ws.Close()
+		return
+	}
+
+	wc := &warmConn{ws: ws}
+	wc.timer = time.AfterFunc(w.maxIdle, func() { w.evict(key, wc) })
+	w.idle[key] = append(w.idle[key], wc)
+}
+
+// evict closes an idle WebSocket that went unused for maxIdle
+func (w *warmProxies) evict(key warmKey, wc *warmConn) {
+	w.mu.Lock()
+	conns := w.idle[key]
+	for i, c := range conns {
+		if c == wc {
+			w.setIdle(key, append(conns[:i:i], conns[i+1:]...))
+			break
+		}
+	}
+	w.mu.Unlock()
+	wc.ws.Close()
+}
+
+// setIdle stores the idle WebSockets for key, dropping the entry when there
+// are none. Called with mu held.
+func (w *warmProxies) setIdle(key warmKey, conns []*warmConn) {
+	if len(conns) == 0 {
+		delete(w.idle, key)
+		return
+	}
+	w.idle[key] = conns
+}
+
+// len returns the number of idle WebSockets
+func (w *warmProxies) len() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	n := 0
+	for _, conns := range w.idle {
+		n += len(conns)
+	}
+	return n
+}
+
+// close closes the idle WebSockets and stops warming new ones
+func (w *warmProxies) close() {
+	w.mu.Lock()
+	w.closed = true
+	idle := w.idle
+	w.idle = make(map[warmKey][]*warmConn)
+	w.mu.Unlock()
+
+	w.cancel()
+	for _, conns := range idle {
+		for _, wc := range conns {
+			wc.timer.Stop()
+			wc.ws.Close()
+		}
+	}
+}
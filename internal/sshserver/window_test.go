@@ -0,0 +1,134 @@
+package sshserver
+
+import (
+	"bufio"
+	"fmt"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestSaneWindow(t *testing.T) {
+	tests := []struct {
+		name       string
+		cols, rows uint32
+		wantCols   uint32
+		wantRows   uint32
+	}{
+		{"usual", 120, 40, 120, 40},
+		{"zero", 0, 0, defaultCols, defaultRows},
+		{"zero cols", 0, 40, defaultCols, 40},
+		{"zero rows", 120, 0, 120, defaultRows},
+		{"largest", maxWindowSize, maxWindowSize, maxWindowSize, maxWindowSize},
+		{"too large", maxWindowSize + 1, 1 << 31, defaultCols, defaultRows},
+		{"overflows uint16", 1 << 16, 1<<16 + 30, defaultCols, defaultRows},
+		{"one cell", 1, 1, 1, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := saneWindow(windowChangeRequest{Cols: tt.cols, Rows: tt.rows, Width: 640, Height: 480})
+			if got.Cols != tt.wantCols || got.Rows != tt.wantRows {
+				t.Errorf("saneWindow(%dx%d) = %dx%d, want %dx%d", tt.cols, tt.rows, got.Cols, got.Rows, tt.wantCols, tt.wantRows)
+			}
+			if got.Width != 640 || got.Height != 480 {
+				t.Errorf("saneWindow changed the pixel size to %dx%d", got.Width, got.Height)
+			}
+		})
+	}
+}
+
+// TestWindowSize checks the size the command's TTY gets from pty-req and
+// window-change requests, including ones sent before it starts and bursts
+// while it runs
+func TestWindowSize(t *testing.T) {
+	if _, err := exec.LookPath("stty"); err != nil {
+		t.Skip("stty not installed")
+	}
+	_, addr := startTestServer(t, &ServerConfig{})
+	client := dialTestServer(t, addr, "demo", newTestSigner(t))
+
+	tests := []struct {
+		name   string
+		pty    termSize   // Sent with pty-req
+		before []termSize // Window changes sent before the command starts
+		after  []termSize // Window changes sent once it runs
+		want   termSize
+	}{
+		{name: "from pty-req", pty: termSize{100, 40}, want: termSize{100, 40}},
+		{name: "zero", pty: termSize{0, 0}, want: termSize{80, 24}},
+		{name: "absurd", pty: termSize{1 << 20, 1 << 20}, want: termSize{80, 24}},
+		{name: "change before exec", pty: termSize{0, 0}, before: []termSize{{120, 50}}, want: termSize{120, 50}},
+		{name: "zero change before exec", pty: termSize{100, 40}, before: []termSize{{0, 0}}, want: termSize{80, 24}},
+		{name: "change while running", pty: termSize{100, 40}, after: []termSize{{132, 43}}, want: termSize{132, 43}},
+		{name: "burst while running", pty: termSize{100, 40}, after: burst(100, 132, 43), want: termSize{132, 43}},
+		{name: "burst ending in zero", pty: termSize{100, 40}, after: append(burst(50, 132, 43), termSize{0, 0}), want: termSize{80, 24}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			session, err := client.NewSession()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer session.Close()
+			if err := session.RequestPty("xterm", tt.pty.rows, tt.pty.cols, ssh.TerminalModes{}); err != nil {
+				t.Fatal(err)
+			}
+			for _, s := range tt.before {
+				if err := session.WindowChange(s.rows, s.cols); err != nil {
+					t.Fatal(err)
+				}
+			}
+			stdout, err := session.StdoutPipe()
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Reports the size until it is the last one sent
+			if err := session.Start("while :; do stty size; sleep 0.05; done"); err != nil {
+				t.Fatal(err)
+			}
+			lines := bufio.NewScanner(stdout)
+			if !lines.Scan() {
+				t.Fatalf("no output: %v", lines.Err())
+			}
+			for _, s := range tt.after {
+				if err := session.WindowChange(s.rows, s.cols); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			want := fmt.Sprintf("%d %d", tt.want.rows, tt.want.cols)
+			got := make(chan string, 1)
+			go func() {
+				line := strings.TrimSpace(lines.Text())
+				for line != want && lines.Scan() {
+					line = strings.TrimSpace(lines.Text())
+				}
+				got <- line
+			}()
+			select {
+			case line := <-got:
+				if line != want {
+					t.Errorf("stty size = %q, want %q", line, want)
+				}
+			case <-time.After(5 * time.Second):
+				t.Errorf("stty size never reported %q", want)
+			}
+		})
+	}
+}
+
+// termSize is a terminal's size in characters
+type termSize struct{ cols, rows int }
+
+// burst returns n window sizes, growing to cols x rows
+func burst(n, cols, rows int) []termSize {
+	sizes := make([]termSize, n)
+	for i := range sizes {
+		sizes[i].cols = cols - n + i + 1
+		sizes[i].rows = rows - (n-i-1)%10
+	}
+	return sizes
+}
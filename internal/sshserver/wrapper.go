@@ -0,0 +1,81 @@
+package sshserver
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// wrapperAll is the CommandWrappers key that applies to every sprite
+const wrapperAll = "*"
+
+// parseWrappers splits each wrapper command into argv
+func parseWrappers(wrappers map[string]string) (map[string][]string, error) {
+	parsed := make(map[string][]string, len(wrappers))
+	for sprite, wrapper := range wrappers {
+		argv, err := parseWrapper(wrapper)
+		if err != nil {
+			return nil, fmt.Errorf("command wrapper for %s: %w", sprite, err)
+		}
+		parsed[sprite] = argv
+	}
+	return parsed, nil
+}
+
+// parseWrapper splits a wrapper command into argv. "none" (or nothing)
+// means no wrapper.
+func parseWrapper(wrapper string) ([]string, error) {
+	wrapper = strings.TrimSpace(wrapper)
+	if wrapper == "" || wrapper == "none" {
+		return nil, nil
+	}
+	return splitWords(wrapper)
+}
+
+// wrapperFor returns the configured wrapper for a sprite
+func wrapperFor(wrappers map[string][]string, sprite string) []string {
+	if argv, ok := wrappers[sprite]; ok {
+		return argv
+	}
+	return wrappers[wrapperAll]
+}
+
+// wrapper returns the wrapper for this session: the SPRITE_WRAPPER override
+// if the client sent one, otherwise the sprite's configured wrapper
+func (s *session) wrapper() []string {
+	if s.overrides.wrapperSet {
+		return s.overrides.wrapper
+	}
+	return s.conn.wrapper
+}
+
+// wrap prefixes argv with the session's wrapper. The original command stays
+// separate arguments, so it needs no extra quoting, and follows "--" where
+// the wrapper takes options after its own arguments, so a command that
+// starts with a dash isn't read as one of them.
+func (s *session) wrap(argv []string) []string {
+	wrapper := s.wrapper()
+	if len(wrapper) == 0 {
+		return argv
+	}
+	wrapped := append([]string{}, wrapper...)
+	if needsSeparator(wrapper) {
+		wrapped = append(wrapped, "--")
+	}
+	return append(wrapped, argv...)
+}
+
+// needsSeparator reports whether "--" goes between wrapper and the command.
+// It doesn't when the wrapper ends with one already, or when it takes the
+// command as plain arguments that "--" would become part of: nix's
+// --command (or -c) and direnv exec DIR.
+func needsSeparator(wrapper []string) bool {
+	last := wrapper[len(wrapper)-1]
+	switch {
+	case last == "--", last == "--command", last == "-c":
+		return false
+	case len(wrapper) == 3 && path.Base(wrapper[0]) == "direnv" && wrapper[1] == "exec":
+		return false
+	}
+	return true
+}
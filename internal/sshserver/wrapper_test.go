@@ -0,0 +1,89 @@
+package sshserver
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestWrap(t *testing.T) {
+	command := []string{"/bin/bash", "-c", "make test"}
+	tests := []struct {
+		name    string
+		wrapper string
+		argv    []string
+		want    []string
+	}{
+		{"none", "none", command, command},
+		{"nix develop", "nix develop --command", command, []string{"nix", "develop", "--command", "/bin/bash", "-c", "make test"}},
+		{"nix develop -c", "nix develop ./ci -c", command, []string{"nix", "develop", "./ci", "-c", "/bin/bash", "-c", "make test"}},
+		{"devbox", "devbox run", command, []string{"devbox", "run", "--", "/bin/bash", "-c", "make test"}},
+		{"devbox with separator", "devbox run --", command, []string{"devbox", "run", "--", "/bin/bash", "-c", "make test"}},
+		{"direnv", "direnv exec .", command, []string{"direnv", "exec", ".", "/bin/bash", "-c", "make test"}},
+		{"direnv by path", "/usr/bin/direnv exec /srv/app", command, []string{"/usr/bin/direnv", "exec", "/srv/app", "/bin/bash", "-c", "make test"}},
+		{"direnv with more", "direnv exec . env", command, []string{"direnv", "exec", ".", "env", "--", "/bin/bash", "-c", "make test"}},
+		{"command with a dash", "env FOO=1", []string{"-weird", "arg"}, []string{"env", "FOO=1", "--", "-weird", "arg"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wrapper, err := parseWrapper(tt.wrapper)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s := &session{conn: &sshConn{wrapper: wrapper}}
+			if got := s.wrap(tt.argv); !slices.Equal(got, tt.want) {
+				t.Errorf("wrap(%q) = %q, want %q", tt.argv, got, tt.want)
+			}
+		})
+	}
+}
+
+// wrapperStubs are stand-ins for environment tools, parsing their
+// arguments like the real ones: nix's --command and direnv exec DIR take
+// the rest as the command, devbox run wants "--" before it
+var wrapperStubs = map[string]string{
+	"nix": `[ "$1 $2" = "develop --command" ] || exit 64
+shift 2
+exec "$@"`,
+	"devbox": `[ "$1" = run ] || exit 64
+shift
+case $1 in -*) [ "$1" = -- ] || { echo "devbox: unknown flag $1" >&2; exit 64; } ;; esac
+shift
+exec "$@"`,
+	"direnv": `[ "$1" = exec ] && [ -d "$2" ] || exit 64
+shift 2
+exec "$@"`,
+}
+
+// TestWrapperTools runs commands through stubs of the tools the README
+// lists wrappers for
+func TestWrapperTools(t *testing.T) {
+	bin := t.TempDir()
+	for name, script := range wrapperStubs {
+		if err := os.WriteFile(filepath.Join(bin, name), []byte("#!/bin/sh\n"+script+"\n"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	for _, wrapper := range []string{"nix develop --command", "devbox run", "direnv exec ."} {
+		t.Run(wrapper, func(t *testing.T) {
+			_, addr := startTestServer(t, &ServerConfig{CommandWrappers: map[string]string{"demo": wrapper}})
+			client := dialTestServer(t, addr, "demo", newTestSigner(t))
+			session, err := client.NewSession()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer session.Close()
+			out, err := session.CombinedOutput("echo wrapped")
+			if err != nil {
+				t.Fatalf("exec: %v: %s", err, out)
+			}
+			if got := strings.TrimSpace(string(out)); got != "wrapped" {
+				t.Errorf("output = %q, want %q", got, "wrapped")
+			}
+		})
+	}
+}
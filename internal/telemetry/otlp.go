@@ -0,0 +1,178 @@
+package telemetry
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OTLP status codes
+const (
+	otlpStatusUnset = 0
+	otlpStatusError = 2
+)
+
+// otlpSpanKindInternal is used for every span we emit
+const otlpSpanKindInternal = 1
+
+// OTLPExporter exports spans to an OTLP/HTTP collector using JSON encoding.
+type OTLPExporter struct {
+	url         string
+	serviceName string
+	client      *http.Client
+}
+
+// NewOTLPExporter creates an exporter for the given collector endpoint. The
+// endpoint may be a base URL (http://localhost:4318) or the full traces URL.
+func NewOTLPExporter(endpoint, serviceName string) *OTLPExporter {
+	url := strings.TrimSuffix(endpoint, "/")
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		url = "http://" + url
+	}
+	if !strings.HasSuffix(url, "/v1/traces") {
+		url += "/v1/traces"
+	}
+
+	return &OTLPExporter{
+		url:         url,
+		serviceName: serviceName,
+		client:      &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// ExportSpans sends the spans to the collector.
+func (e *OTLPExporter) ExportSpans(ctx context.Context, spans []SpanData) error {
+	body, err := json.Marshal(e.buildRequest(spans))
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("export spans: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("export spans: collector returned %s", resp.Status)
+	}
+	return nil
+}
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    *string `json:"intValue,omitempty"`
+	BoolValue   *bool   `json:"boolValue,omitempty"`
+}
+
+func (e *OTLPExporter) buildRequest(spans []SpanData) otlpRequest {
+	out := make([]otlpSpan, 0, len(spans))
+	for _, sd := range spans {
+		span := otlpSpan{
+			TraceID:           hex.EncodeToString(sd.TraceID[:]),
+			SpanID:            hex.EncodeToString(sd.SpanID[:]),
+			Name:              sd.Name,
+			Kind:              otlpSpanKindInternal,
+			StartTimeUnixNano: strconv.FormatInt(sd.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(sd.End.UnixNano(), 10),
+			Status:            otlpStatus{Code: otlpStatusUnset},
+		}
+		if sd.ParentID != [8]byte{} {
+			span.ParentSpanID = hex.EncodeToString(sd.ParentID[:])
+		}
+		if sd.Err != "" {
+			span.Status = otlpStatus{Code: otlpStatusError, Message: sd.Err}
+		}
+		for _, attr := range sd.Attributes {
+			span.Attributes = append(span.Attributes, toOTLPKeyValue(attr))
+		}
+		out = append(out, span)
+	}
+
+	service := e.serviceName
+	return otlpRequest{
+		ResourceSpans: []otlpResourceSpans{{
+			Resource: otlpResource{
+				Attributes: []otlpKeyValue{{Key: "service.name", Value: otlpValue{StringValue: &service}}},
+			},
+			ScopeSpans: []otlpScopeSpans{{
+				Scope: otlpScope{Name: "sprite-bootstrap"},
+				Spans: out,
+			}},
+		}},
+	}
+}
+
+func toOTLPKeyValue(attr Attribute) otlpKeyValue {
+	kv := otlpKeyValue{Key: attr.Key}
+	switch attr.Kind {
+	case KindInt:
+		v := strconv.FormatInt(attr.Int, 10)
+		kv.Value.IntValue = &v
+	case KindBool:
+		v := attr.Bool
+		kv.Value.BoolValue = &v
+	default:
+		v := attr.Str
+		kv.Value.StringValue = &v
+	}
+	return kv
+}
@@ -0,0 +1,287 @@
+// Package telemetry provides opt-in tracing for sprite-bootstrap.
+//
+// Spans are exported to an OTLP/HTTP collector using the OTLP JSON encoding.
+// When no endpoint is configured everything here is inert: Start returns a
+// nil *Span, all Span methods are no-ops on nil, and no goroutines are started.
+package telemetry
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// EndpointEnv is the environment variable used when --otel-endpoint isn't set
+const EndpointEnv = "OTEL_EXPORTER_OTLP_ENDPOINT"
+
+// flushInterval is how often buffered spans are sent to the exporter
+var flushInterval = 5 * time.Second
+
+// maxBufferedSpans caps memory used when the collector is unreachable
+var maxBufferedSpans = 2048
+
+// Exporter sends finished spans somewhere.
+type Exporter interface {
+	ExportSpans(ctx context.Context, spans []SpanData) error
+}
+
+// SpanData is a finished span, ready for export.
+type SpanData struct {
+	TraceID    [16]byte
+	SpanID     [8]byte
+	ParentID   [8]byte
+	Name       string
+	Start      time.Time
+	End        time.Time
+	Attributes []Attribute
+	Err        string
+}
+
+// Attribute is a single span attribute. Exactly one of the value fields is
+// meaningful, selected by Kind.
+type Attribute struct {
+	Key  string
+	Kind AttributeKind
+	Str  string
+	Int  int64
+	Bool bool
+}
+
+// AttributeKind identifies the type of an Attribute value.
+type AttributeKind int
+
+const (
+	KindString AttributeKind = iota
+	KindInt
+	KindBool
+)
+
+// provider batches spans and hands them to the exporter.
+type provider struct {
+	exporter Exporter
+
+	mu      sync.Mutex
+	pending []SpanData
+	dropped int
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+var global atomic.Pointer[provider]
+
+// configuredEndpoint remembers the endpoint passed to Init so child processes
+// (the background serve) can be started with the same setting
+var configuredEndpoint atomic.Value
+
+// Endpoint returns the configured endpoint, preferring the flag value over
+// the environment.
+func Endpoint(flagValue string) string {
+	if flagValue != "" {
+		return flagValue
+	}
+	return os.Getenv(EndpointEnv)
+}
+
+// Init enables tracing with an OTLP/HTTP exporter for the given endpoint.
+// An empty endpoint leaves tracing disabled.
+func Init(endpoint, serviceName string) {
+	if endpoint == "" {
+		return
+	}
+	configuredEndpoint.Store(endpoint)
+	InitWithExporter(NewOTLPExporter(endpoint, serviceName))
+}
+
+// InitWithExporter enables tracing with a custom exporter.
+func InitWithExporter(exporter Exporter) {
+	p := &provider{
+		exporter: exporter,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if old := global.Swap(p); old != nil {
+		old.shutdown(context.Background())
+	}
+	go p.run()
+}
+
+// ConfiguredEndpoint returns the endpoint tracing was initialized with, or ""
+func ConfiguredEndpoint() string {
+	endpoint, _ := configuredEndpoint.Load().(string)
+	return endpoint
+}
+
+// Enabled reports whether tracing is active.
+func Enabled() bool {
+	return global.Load() != nil
+}
+
+// Shutdown flushes any buffered spans and disables tracing.
+func Shutdown(ctx context.Context) error {
+	p := global.Swap(nil)
+	if p == nil {
+		return nil
+	}
+	return p.shutdown(ctx)
+}
+
+func (p *provider) run() {
+	defer close(p.done)
+
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), flushInterval)
+			p.flush(ctx)
+			cancel()
+		}
+	}
+}
+
+func (p *provider) shutdown(ctx context.Context) error {
+	close(p.stop)
+	<-p.done
+	return p.flush(ctx)
+}
+
+func (p *provider) record(sd SpanData) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.pending) >= maxBufferedSpans {
+		p.dropped++
+		return
+	}
+	p.pending = append(p.pending, sd)
+}
+
+func (p *provider) flush(ctx context.Context) error {
+	p.mu.Lock()
+	spans := p.pending
+	p.pending = nil
+	p.mu.Unlock()
+
+	if len(spans) == 0 {
+		return nil
+	}
+	return p.exporter.ExportSpans(ctx, spans)
+}
+
+// Span is an in-progress span. A nil *Span is valid and does nothing.
+type Span struct {
+	p    *provider
+	data SpanData
+	once sync.Once
+	mu   sync.Mutex
+}
+
+type spanKey struct{}
+
+// Start begins a new span as a child of any span in ctx. When tracing is
+// disabled it returns ctx unchanged and a nil span without allocating.
+func Start(ctx context.Context, name string) (context.Context, *Span) {
+	p := global.Load()
+	if p == nil {
+		return ctx, nil
+	}
+
+	s := &Span{p: p}
+	s.data.Name = name
+	s.data.Start = time.Now()
+	if parent, ok := ctx.Value(spanKey{}).(*Span); ok && parent != nil {
+		s.data.TraceID = parent.data.TraceID
+		s.data.ParentID = parent.data.SpanID
+	} else {
+		rand.Read(s.data.TraceID[:])
+	}
+	rand.Read(s.data.SpanID[:])
+
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// FromContext returns the current span in ctx, or nil.
+func FromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+// SetString sets a string attribute on the span.
+func (s *Span) SetString(key, value string) {
+	if s == nil {
+		return
+	}
+	s.setAttr(Attribute{Key: key, Kind: KindString, Str: value})
+}
+
+// SetInt sets an integer attribute on the span.
+func (s *Span) SetInt(key string, value int64) {
+	if s == nil {
+		return
+	}
+	s.setAttr(Attribute{Key: key, Kind: KindInt, Int: value})
+}
+
+// SetBool sets a boolean attribute on the span.
+func (s *Span) SetBool(key string, value bool) {
+	if s == nil {
+		return
+	}
+	s.setAttr(Attribute{Key: key, Kind: KindBool, Bool: value})
+}
+
+func (s *Span) setAttr(attr Attribute) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.data.Attributes {
+		if s.data.Attributes[i].Key == attr.Key {
+			s.data.Attributes[i] = attr
+			return
+		}
+	}
+	s.data.Attributes = append(s.data.Attributes, attr)
+}
+
+// RecordError marks the span as failed. A nil error is ignored.
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	s.data.Err = err.Error()
+	s.mu.Unlock()
+}
+
+// End finishes the span and queues it for export. Calling End more than once
+// has no effect.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.once.Do(func() {
+		s.mu.Lock()
+		s.data.End = time.Now()
+		data := s.data
+		data.Attributes = append([]Attribute(nil), s.data.Attributes...)
+		s.mu.Unlock()
+		s.p.record(data)
+	})
+}
+
+// TraceID returns the hex-encoded trace ID, or "" for a nil span.
+func (s *Span) TraceID() string {
+	if s == nil {
+		return ""
+	}
+	return hex.EncodeToString(s.data.TraceID[:])
+}
@@ -0,0 +1,115 @@
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+	"testing"
+)
+
+// memExporter keeps exported spans in memory
+type memExporter struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+func (e *memExporter) ExportSpans(ctx context.Context, spans []SpanData) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+func TestDisabledIsInert(t *testing.T) {
+	if Enabled() {
+		t.Fatal("tracing enabled before Init")
+	}
+	goroutines := runtime.NumGoroutine()
+	Init("", "test")
+
+	ctx := context.Background()
+	err := errors.New("ignored")
+	allocs := testing.AllocsPerRun(100, func() {
+		got, span := Start(ctx, "noop")
+		span.SetString("k", "v")
+		span.SetInt("n", 1)
+		span.SetBool("b", true)
+		span.RecordError(err)
+		span.End()
+		if got != ctx || span != nil {
+			t.Fatal("Start changed ctx or returned a span while disabled")
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("disabled span allocates %v times", allocs)
+	}
+	if Enabled() || runtime.NumGoroutine() > goroutines {
+		t.Error("Init with no endpoint enabled tracing")
+	}
+}
+
+func TestSpansExported(t *testing.T) {
+	exp := &memExporter{}
+	InitWithExporter(exp)
+
+	ctx, conn := Start(context.Background(), "ssh.connection")
+	conn.SetString("sprite.name", "demo")
+	_, session := Start(ctx, "ssh.session")
+	session.SetInt("session.retries", 1)
+	session.SetInt("session.retries", 2) // Replaces the first value
+	session.RecordError(errors.New("sprite went away"))
+	session.End()
+	session.End() // Recorded once
+	conn.End()
+
+	if err := Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if Enabled() {
+		t.Error("tracing still enabled after Shutdown")
+	}
+
+	if len(exp.spans) != 2 {
+		t.Fatalf("exported %d spans, want 2", len(exp.spans))
+	}
+	gotSession, gotConn := exp.spans[0], exp.spans[1]
+
+	tests := []struct {
+		name string
+		ok   bool
+	}{
+		{"session name", gotSession.Name == "ssh.session"},
+		{"connection name", gotConn.Name == "ssh.connection"},
+		{"same trace", gotSession.TraceID == gotConn.TraceID},
+		{"session parent", gotSession.ParentID == gotConn.SpanID},
+		{"connection is a root", gotConn.ParentID == [8]byte{}},
+		{"sprite attribute", len(gotConn.Attributes) == 1 && gotConn.Attributes[0] == Attribute{Key: "sprite.name", Kind: KindString, Str: "demo"}},
+		{"retries attribute", len(gotSession.Attributes) == 1 && gotSession.Attributes[0].Int == 2},
+		{"error", gotSession.Err == "sprite went away" && gotConn.Err == ""},
+		{"timing", !gotSession.End.Before(gotSession.Start)},
+	}
+	for _, tt := range tests {
+		if !tt.ok {
+			t.Errorf("%s: got %+v", tt.name, exp.spans)
+		}
+	}
+}
+
+func TestBufferCap(t *testing.T) {
+	defer func(n int) { maxBufferedSpans = n }(maxBufferedSpans)
+	maxBufferedSpans = 3
+
+	exp := &memExporter{}
+	InitWithExporter(exp)
+	for range 5 {
+		_, span := Start(context.Background(), "span")
+		span.End()
+	}
+	if err := Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(exp.spans) != 3 {
+		t.Errorf("exported %d spans, want the first 3", len(exp.spans))
+	}
+}
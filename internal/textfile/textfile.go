@@ -0,0 +1,58 @@
+// Package textfile reads text files users may have edited on Windows, where
+// editors like Notepad add a byte order mark and CRLF line endings.
+//
+// Files are normalized to LF without a BOM for parsing, and the Style they
+// had is kept so files that are modified and written back keep it.
+package textfile
+
+import (
+	"bytes"
+	"os"
+)
+
+// bom is the UTF-8 byte order mark
+var bom = []byte{0xEF, 0xBB, 0xBF}
+
+// Style is how a text file was encoded
+type Style struct {
+	BOM  bool // Started with a UTF-8 byte order mark
+	CRLF bool // Most lines ended in CRLF
+}
+
+// Normalize strips a UTF-8 BOM and converts all CRLF line endings to LF,
+// returning the text and the style it had
+func Normalize(data []byte) ([]byte, Style) {
+	var style Style
+	if bytes.HasPrefix(data, bom) {
+		style.BOM = true
+		data = data[len(bom):]
+	}
+
+	crlf := bytes.Count(data, []byte("\r\n"))
+	if crlf == 0 {
+		return data, style
+	}
+	style.CRLF = crlf > bytes.Count(data, []byte("\n"))-crlf
+	return bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n")), style
+}
+
+// Encode converts LF text back to the style
+func (s Style) Encode(text []byte) []byte {
+	if s.CRLF {
+		text = bytes.ReplaceAll(text, []byte("\n"), []byte("\r\n"))
+	}
+	if s.BOM {
+		text = append(append([]byte{}, bom...), text...)
+	}
+	return text
+}
+
+// ReadFile reads a file and normalizes it
+func ReadFile(path string) ([]byte, Style, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, Style{}, err
+	}
+	text, style := Normalize(data)
+	return text, style, nil
+}
@@ -0,0 +1,88 @@
+package textfile
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name  string
+		in    string
+		want  string
+		style Style
+	}{
+		{"empty", "", "", Style{}},
+		{"LF", "a\nb\n", "a\nb\n", Style{}},
+		{"CRLF", "a\r\nb\r\n", "a\nb\n", Style{CRLF: true}},
+		{"BOM", "\ufeffa\n", "a\n", Style{BOM: true}},
+		{"BOM only", "\ufeff", "", Style{BOM: true}},
+		{"BOM and CRLF", "\ufeffa\r\nb\r\n", "a\nb\n", Style{BOM: true, CRLF: true}},
+		{"mostly CRLF", "a\r\nb\r\nc\n", "a\nb\nc\n", Style{CRLF: true}},
+		{"mostly LF", "a\nb\nc\r\n", "a\nb\nc\n", Style{}},
+		{"as many of each", "a\r\nb\n", "a\nb\n", Style{}},
+		{"no final newline", "a\r\nb", "a\nb", Style{CRLF: true}},
+		{"lone CR kept", "a\rb\r\n", "a\rb\n", Style{CRLF: true}},
+		{"BOM in the middle kept", "a\n\ufeffb\n", "a\n\ufeffb\n", Style{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, style := Normalize([]byte(tt.in))
+			if string(got) != tt.want || style != tt.style {
+				t.Errorf("Normalize(%q) = %q, %+v; want %q, %+v", tt.in, got, style, tt.want, tt.style)
+			}
+		})
+	}
+}
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		style Style
+		want  string
+	}{
+		{Style{}, "a\nb\n"},
+		{Style{CRLF: true}, "a\r\nb\r\n"},
+		{Style{BOM: true}, "\ufeffa\nb\n"},
+		{Style{BOM: true, CRLF: true}, "\ufeffa\r\nb\r\n"},
+	}
+	for _, tt := range tests {
+		text := []byte("a\nb\n")
+		if got := tt.style.Encode(text); string(got) != tt.want {
+			t.Errorf("%+v.Encode = %q, want %q", tt.style, got, tt.want)
+		}
+		if string(text) != "a\nb\n" {
+			t.Errorf("%+v.Encode modified its input to %q", tt.style, text)
+		}
+	}
+}
+
+// TestRoundTrip checks that normalizing and encoding back gives the file
+// it started from, for files in one consistent style
+func TestRoundTrip(t *testing.T) {
+	for _, in := range []string{"", "a\nb\n", "a\r\nb\r\n", "\ufeffa\nb", "\ufeffa\r\nb\r\n"} {
+		text, style := Normalize([]byte(in))
+		if got := style.Encode(text); string(got) != in {
+			t.Errorf("round trip of %q gave %q", in, got)
+		}
+	}
+}
+
+func TestReadFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config")
+	if err := os.WriteFile(path, []byte("\ufeffHost a\r\n  Port 22\r\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	text, style, err := ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(text) != "Host a\n  Port 22\n" || style != (Style{BOM: true, CRLF: true}) {
+		t.Errorf("ReadFile = %q, %+v", text, style)
+	}
+
+	if _, _, err := ReadFile(filepath.Join(dir, "missing")); !os.IsNotExist(err) {
+		t.Errorf("ReadFile of a missing file: %v, want not exist", err)
+	}
+}
@@ -0,0 +1,102 @@
+package tools
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// SplitEditorArgs splits --editor-args the way sh splits words: single
+// quotes are literal, double quotes allow \" \\ \$ and \` escapes, a
+// backslash outside quotes escapes the next character, and a backslash
+// before a newline joins the lines. Nothing is expanded.
+func SplitEditorArgs(s string) ([]string, error) {
+	var (
+		args    []string
+		word    strings.Builder
+		inWord  bool
+		quote   rune // ' or " while inside quotes
+		escaped bool
+	)
+	for _, r := range s {
+		switch {
+		case escaped && r == '\n':
+			escaped = false
+		case escaped:
+			// Inside double quotes only these are escapes
+			if quote == '"' && !strings.ContainsRune("\"\\$`", r) {
+				word.WriteRune('\\')
+			}
+			word.WriteRune(r)
+			escaped, inWord = false, true
+		case r == '\\' && quote != '\'':
+			escaped = true
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+			word.WriteRune(r)
+		case r == '\'' || r == '"':
+			quote, inWord = r, true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inWord {
+				args = append(args, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+
+	switch {
+	case escaped:
+		return nil, errors.New("editor args end with a backslash")
+	case quote != 0:
+		return nil, fmt.Errorf("editor args have an unterminated %c quote", quote)
+	}
+	if inWord {
+		args = append(args, word.String())
+	}
+	return args, nil
+}
+
+// checkEditorArgs rejects editor args the tool can't pass on, or that
+// conflict with the arguments it controls
+func checkEditorArgs(tool Tool, args []string) error {
+	if len(args) == 0 {
+		return nil
+	}
+	launcher, ok := tool.(EditorLauncher)
+	if !ok {
+		return fmt.Errorf("%s doesn't launch an editor, so it takes no editor args", tool.Name())
+	}
+	return launcher.CheckEditorArgs(args)
+}
+
+// rejectFlags returns an error for the first arg that is one of flags,
+// alone or as --flag=value
+func rejectFlags(args []string, flags ...string) error {
+	for _, arg := range args {
+		name, _, _ := strings.Cut(arg, "=")
+		for _, flag := range flags {
+			if name == flag {
+				return fmt.Errorf("editor arg %s conflicts with the remote workspace sprite-bootstrap opens", arg)
+			}
+		}
+	}
+	return nil
+}
+
+// displayArgs joins args for a command line shown to the user, quoting
+// those the shell would split or expand
+func displayArgs(args []string) string {
+	words := make([]string, len(args))
+	for i, arg := range args {
+		if arg == "" || strings.ContainsAny(arg, " \t\n'\"\\$`*?[]{}()<>|&;#~!") {
+			arg = posixQuote(arg)
+		}
+		words[i] = arg
+	}
+	return strings.Join(words, " ")
+}
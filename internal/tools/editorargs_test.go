@@ -0,0 +1,182 @@
+package tools
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSplitEditorArgs(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    []string
+		wantErr string
+	}{
+		{"", nil, ""},
+		{"   ", nil, ""},
+		{"--disable-extensions", []string{"--disable-extensions"}, ""},
+		{"  --new-window \t --verbose\n", []string{"--new-window", "--verbose"}, ""},
+		{"--profile 'Work Profile'", []string{"--profile", "Work Profile"}, ""},
+		{`--profile "Work Profile"`, []string{"--profile", "Work Profile"}, ""},
+		{`--profile Work\ Profile`, []string{"--profile", "Work Profile"}, ""},
+		{`--user-data-dir=/tmp/my\ dir`, []string{"--user-data-dir=/tmp/my dir"}, ""},
+		{`--name="it's mine"`, []string{"--name=it's mine"}, ""},
+		{`--name='say "hi"'`, []string{`--name=say "hi"`}, ""},
+		{`--name="say \"hi\""`, []string{`--name=say "hi"`}, ""},
+		{`'it'\''s'`, []string{"it's"}, ""},
+		{`"a\\b" "a\b" 'a\b'`, []string{`a\b`, `a\b`, `a\b`}, ""},
+		{`'' ""`, []string{"", ""}, ""},
+		{`a""b`, []string{"ab"}, ""},
+		{"'$HOME' \"$HOME\" $HOME", []string{"$HOME", "$HOME", "$HOME"}, ""},
+		{"--x *.go ~", []string{"--x", "*.go", "~"}, ""},
+		{`--profile 'Work`, nil, "unterminated ' quote"},
+		{`--name="x`, nil, `unterminated " quote`},
+		{"--one \\\n--two", []string{"--one", "--two"}, ""},
+		{`--x \`, nil, "end with a backslash"},
+	}
+	for _, tt := range tests {
+		got, err := SplitEditorArgs(tt.in)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("SplitEditorArgs(%q) error = %v, want %q", tt.in, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("SplitEditorArgs(%q): %v", tt.in, err)
+			continue
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("SplitEditorArgs(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+// TestSplitEditorArgsLikeSh checks SplitEditorArgs splits as sh does
+func TestSplitEditorArgsLikeSh(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not installed")
+	}
+	for _, in := range []string{
+		`--profile 'Work Profile' --name="it's \"mine\"" plain\ word`,
+		`'a'"b"c 'x\y' "x\y" "x\\y" "\$" 'don'\''t'`,
+		"\"\\`date\\`\" '`date`'",
+		"--one \\\n --two \"three\\\nfour\"",
+		`--goto=src/main.go:10:5 --new-window`,
+	} {
+		got, err := SplitEditorArgs(in)
+		if err != nil {
+			t.Fatalf("SplitEditorArgs(%q): %v", in, err)
+		}
+		out, err := exec.Command("sh", "-c", `printf '%s\0' `+in).Output()
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := strings.Split(strings.TrimSuffix(string(out), "\x00"), "\x00")
+		if !slices.Equal(got, want) {
+			t.Errorf("SplitEditorArgs(%q) = %q, sh splits it as %q", in, got, want)
+		}
+	}
+}
+
+// TestDisplayArgsRoundTrip checks the command line shown to the user
+// splits back into the arguments the editor got
+func TestDisplayArgsRoundTrip(t *testing.T) {
+	args := append([]string{"ssh://sprite@127.0.0.1:2222/home/sprite/app"}, awkwardArgs...)
+	shown := displayArgs(args)
+	if !strings.HasPrefix(shown, "ssh://sprite@127.0.0.1:2222/home/sprite/app plain ") {
+		t.Errorf("displayArgs quoted plain arguments: %s", shown)
+	}
+	got, err := SplitEditorArgs(shown)
+	if err != nil {
+		t.Fatalf("SplitEditorArgs(%s): %v", shown, err)
+	}
+	if !slices.Equal(got, args) {
+		t.Errorf("%s splits into %q, want %q", shown, got, args)
+	}
+}
+
+// noEditorTool is a tool that launches no editor
+type noEditorTool struct{ Tool }
+
+func (noEditorTool) Name() string { return "cli" }
+
+func TestCheckEditorArgs(t *testing.T) {
+	tests := []struct {
+		name    string
+		tool    Tool
+		args    []string
+		wantErr bool
+	}{
+		{"vscode, none", &VSCode{}, nil, false},
+		{"vscode, harmless", &VSCode{}, []string{"--disable-extensions", "--profile", "Work"}, false},
+		{"vscode, --remote", &VSCode{}, []string{"--remote", "ssh-remote+other"}, true},
+		{"vscode, --folder-uri=", &VSCode{}, []string{"--folder-uri=vscode-remote://x"}, true},
+		{"vscode, -g", &VSCode{}, []string{"-g", "main.go"}, true},
+		{"vscode, flag as a value", &VSCode{}, []string{"--profile=--remote"}, false},
+		{"zed, harmless", &Zed{}, []string{"--foreground", "--new"}, false},
+		{"zed, another ssh URL", &Zed{}, []string{"ssh://other@host/path"}, true},
+		{"no editor, none", noEditorTool{}, nil, false},
+		{"no editor, some", noEditorTool{}, []string{"--verbose"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkEditorArgs(tt.tool, tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkEditorArgs(%q) = %v, want error %v", tt.args, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestVSCodeArgsOrder checks editor args come after the ones that open
+// the workspace
+func TestVSCodeArgsOrder(t *testing.T) {
+	opts := NewSetupOptions("demo", "org", 2222, "/home/sprite/app")
+	opts.OpenFile = "/home/sprite/app/main.go"
+	opts.EditorArgs = []string{"--profile", "Work Profile"}
+	want := []string{"--remote", "ssh-remote+sprite-demo", "/home/sprite/app/", "--goto", "/home/sprite/app/main.go", "--profile", "Work Profile"}
+	if got := vscodeArgs(opts); !slices.Equal(got, want) {
+		t.Errorf("vscodeArgs = %q, want %q", got, want)
+	}
+}
+
+// TestZedAliasLaunchArgs launches Zed through a bash alias, as when it is
+// only installed as one, and checks the editor args arrive intact
+func TestZedAliasLaunchArgs(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Zed is launched through aliases on Unix only")
+	}
+	bash, err := exec.LookPath("bash")
+	if err != nil {
+		t.Skip("bash not installed")
+	}
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("SHELL", bash)
+	out := filepath.Join(home, "args")
+	rc := `alias zed='printf "%s\0" >` + posixQuote(out) + `'` + "\n"
+	if err := os.WriteFile(filepath.Join(home, ".bashrc"), []byte(rc), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	editorArgs, err := SplitEditorArgs(`--foreground --name="it's \"mine\"" 'two  spaces' '$(id)'`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	args := append([]string{"ssh://demo@127.0.0.1:2222/home/sprite/my app"}, editorArgs...)
+	if err := buildZedCommand("zed", true, args).Run(); err != nil {
+		t.Fatalf("launch: %v", err)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Split(strings.TrimSuffix(string(data), "\x00"), "\x00"); !slices.Equal(got, args) {
+		t.Errorf("zed got %q, want %q", got, args)
+	}
+}
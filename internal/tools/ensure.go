@@ -0,0 +1,192 @@
+package tools
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vaurdan/sprite-bootstrap/internal/sshconfig"
+)
+
+// Outcomes of Ensure
+const (
+	EnsureConverged = "converged" // Everything was already in place
+	EnsureChanged   = "changed"   // Missing steps were run and succeeded
+	EnsureFailed    = "failed"    // A check or step failed
+)
+
+// EnsureAction is a step Ensure ran to converge, or the check that failed
+type EnsureAction struct {
+	Step   string `json:"step"`
+	Detail string `json:"detail,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// EnsureResult is what Ensure found and did
+type EnsureResult struct {
+	Sprite   string         `json:"sprite"`
+	Tool     string         `json:"tool"`
+	Status   string         `json:"status"`
+	Actions  []EnsureAction `json:"actions"`
+	Duration int64          `json:"duration_ms"`
+}
+
+// fail records a failed step and marks the result failed
+func (r *EnsureResult) fail(step string, err error) {
+	r.Actions = append(r.Actions, EnsureAction{Step: step, Error: err.Error()})
+	r.Status = EnsureFailed
+}
+
+// changed records a step that was run to converge
+func (r *EnsureResult) changed(step, detail string) {
+	r.Actions = append(r.Actions, EnsureAction{Step: step, Detail: detail})
+	if r.Status == EnsureConverged {
+		r.Status = EnsureChanged
+	}
+}
+
+// Ensure checks that a tool's setup of a sprite is in place (serve up, SSH
+// config entry and host key pin current, remote artifacts present) and
+// runs only the steps that are missing. It never prompts, and the checks
+// are bounded by ctx. Unlike Bootstrap it doesn't launch the editor or
+// install profile extras.
+func Ensure(ctx context.Context, tool Tool, opts SetupOptions) *EnsureResult {
+	start := time.Now()
+	result := &EnsureResult{Sprite: opts.SpriteName, Tool: tool.Name(), Status: EnsureConverged, Actions: []EnsureAction{}}
+	defer func() { result.Duration = time.Since(start).Milliseconds() }()
+
+	if err := checkStdio(tool, opts); err != nil {
+		result.fail("ssh.stdio", err)
+		return result
+	}
+
+	sprite, policy, err := wakeSprite(ctx, opts)
+	if err != nil {
+		result.fail("sprite.wake", err)
+		return result
+	}
+	opts.Sprite, opts.Policy = sprite, policy
+
+	switch {
+	case opts.Stdio:
+		// ssh runs stdio for each connection; there's no server to start
+	case !IsServeRunning():
+		if err := startServe(opts, ""); err != nil {
+			result.fail("serve.start", err)
+			return result
+		}
+		result.changed("serve.start", fmt.Sprintf("listening on port %d", opts.LocalPort))
+	default:
+		if err := checkServeListening(ctx, opts); err != nil {
+			result.fail("serve.check", err)
+			return result
+		}
+	}
+
+	if opts.Policy.PinsHostKeys() {
+		before, _ := os.ReadFile(knownHostsFile())
+		if err := pinHostKey(); err != nil {
+			result.fail("ssh.pin_host_key", err)
+			return result
+		}
+		if after, _ := os.ReadFile(knownHostsFile()); !bytes.Equal(before, after) {
+			result.changed("ssh.pin_host_key", knownHostsFile())
+		}
+	}
+
+	if c, ok := tool.(SSHConfigurer); ok {
+		txn := sshconfig.Begin()
+		txn.Add(c.SSHConfigEntry(opts))
+		summary, err := txn.Commit()
+		if err != nil {
+			result.fail("ssh_config.update", err)
+			return result
+		}
+		if summary.Changed() {
+			result.changed("ssh_config.update", strings.TrimSpace(summary.String()))
+		}
+	}
+
+	if _, ok := tool.(Verifier); ok {
+		ensureArtifacts(ctx, tool, opts, result)
+	}
+	return result
+}
+
+// checkServeListening checks that the running server accepts connections
+// on the port the tool connects to
+func checkServeListening(ctx context.Context, opts SetupOptions) error {
+	dialCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	var d net.Dialer
+	conn, err := d.DialContext(dialCtx, "tcp", net.JoinHostPort(opts.ServeHost(), strconv.Itoa(opts.LocalPort)))
+	if err != nil {
+		return fmt.Errorf("the SSH server is running but not reachable on port %d: %w", opts.LocalPort, err)
+	}
+	return conn.Close()
+}
+
+// ensureArtifacts re-runs the setup steps whose artifacts are missing or
+// wrong, each step once, and checks them again
+func ensureArtifacts(ctx context.Context, tool Tool, opts SetupOptions, result *EnsureResult) {
+	statuses, err := VerifyTool(ctx, tool, opts)
+	if err != nil {
+		result.fail("artifacts.check", err)
+		return
+	}
+
+	ran := make(map[string]bool)
+	fixed := false
+	for _, st := range statuses {
+		if st.OK() {
+			continue
+		}
+		a := st.Artifact
+		step := cmp.Or(a.Step, a.Path)
+		if ran[step] {
+			continue
+		}
+		ran[step] = true
+
+		if a.Fix == nil {
+			err := fmt.Errorf("%s: %s (%s)", a.Name, st.Problem, a.Path)
+			if a.Hint != "" {
+				err = fmt.Errorf("%w; fix manually: %s", err, a.Hint)
+			}
+			result.fail(step, err)
+			continue
+		}
+		if err := traceStep(ctx, "ensure."+step, func(ctx context.Context) error {
+			return a.Fix(ctx, opts)
+		}); err != nil {
+			result.fail(step, fmt.Errorf("%s: %w", a.Name, err))
+			continue
+		}
+		result.changed(step, fmt.Sprintf("%s was %s", a.Name, st.Problem))
+		fixed = true
+	}
+	if !fixed || result.Status == EnsureFailed {
+		return
+	}
+
+	// A fix that runs without error but leaves the artifact wrong would
+	// otherwise be reported as converged
+	statuses, err = VerifyTool(ctx, tool, opts)
+	if err != nil {
+		result.fail("artifacts.check", err)
+		return
+	}
+	for _, st := range statuses {
+		if !st.OK() {
+			result.fail(cmp.Or(st.Artifact.Step, st.Artifact.Path), errors.New(st.Artifact.Name+": still "+st.Problem+" after the fix"))
+		}
+	}
+}
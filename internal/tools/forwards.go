@@ -0,0 +1,169 @@
+package tools
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vaurdan/sprite-bootstrap/internal/config"
+	"github.com/vaurdan/sprite-bootstrap/internal/sshserver"
+)
+
+// Sources of a forward
+const (
+	ForwardSourceSSH = "ssh" // A channel of an SSH connection to serve
+	ForwardSourceCLI = "cli" // A forwarding command such as "open --alias"
+)
+
+// ForwardInfo describes an active forward, from serve or a CLI command
+type ForwardInfo struct {
+	ID       string    `json:"id"`
+	Source   string    `json:"source"`
+	Label    string    `json:"label,omitempty"`
+	Sprite   string    `json:"sprite"`
+	Kind     string    `json:"kind"`
+	Local    string    `json:"local"`
+	Remote   string    `json:"remote"`
+	Started  time.Time `json:"started"`
+	BytesIn  int64     `json:"bytes_in"`
+	BytesOut int64     `json:"bytes_out"`
+	PID      int       `json:"pid"`
+
+	// LimitUp and LimitDown are the bytes per second the forward may send
+	// and receive, shared with the other forwards of its process; zero when
+	// unlimited
+	LimitUp   int64 `json:"limit_up,omitempty"`
+	LimitDown int64 `json:"limit_down,omitempty"`
+}
+
+// cliForwardFile returns the path to a forwarding command's metadata
+func cliForwardFile(pid int) string {
+	return filepath.Join(config.RuntimeDir(), fmt.Sprintf("forward-%d.json", pid))
+}
+
+// CLIForwardID returns the ID of the forward run by a process
+func CLIForwardID(pid int) string {
+	return "open-" + strconv.Itoa(pid)
+}
+
+// SaveCLIForward writes a forwarding command's metadata, replacing the file
+// atomically so readers never see a partial write
+func SaveCLIForward(info *ForwardInfo) error {
+	if err := config.EnsureRuntimeDir(); err != nil {
+		return err
+	}
+	data, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	path := cliForwardFile(info.PID)
+	if err := os.WriteFile(path+".tmp", data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// RemoveCLIForward deletes a forwarding command's metadata
+func RemoveCLIForward(pid int) {
+	os.Remove(cliForwardFile(pid))
+}
+
+// ListForwards returns the forwards of the running server and of running
+// forwarding commands, oldest first. Metadata left by commands that have
+// exited is removed.
+func ListForwards() []ForwardInfo {
+	var forwards []ForwardInfo
+
+	pid := GetServePid()
+	stats, err := LoadServeStats()
+	if err == nil && pid != 0 && stats.PID == pid && time.Since(stats.UpdatedAt) < 3*ServeStatsInterval {
+		forwards = append(forwards, stats.Forwards...)
+	}
+
+	paths, _ := filepath.Glob(filepath.Join(config.RuntimeDir(), "forward-*.json"))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var info ForwardInfo
+		if err := json.Unmarshal(data, &info); err != nil || !isProcessRunning(info.PID) {
+			os.Remove(path)
+			continue
+		}
+		forwards = append(forwards, info)
+	}
+
+	sort.SliceStable(forwards, func(i, j int) bool {
+		return forwards[i].Started.Before(forwards[j].Started)
+	})
+	return forwards
+}
+
+// FindForward returns the forward with an ID or label. A label shared by
+// several forwards is an error.
+func FindForward(forwards []ForwardInfo, ref string) (ForwardInfo, error) {
+	var matches []ForwardInfo
+	for _, f := range forwards {
+		if f.ID == ref {
+			return f, nil
+		}
+		if f.Label != "" && f.Label == ref {
+			matches = append(matches, f)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return ForwardInfo{}, fmt.Errorf("no such forward %q (see 'sprite-bootstrap forwards list')", ref)
+	case 1:
+		return matches[0], nil
+	default:
+		ids := make([]string, len(matches))
+		for i, m := range matches {
+			ids[i] = m.ID
+		}
+		return ForwardInfo{}, fmt.Errorf("label %q is on several forwards (%s); close one by ID", ref, strings.Join(ids, ", "))
+	}
+}
+
+// CloseForward closes one forward. A CLI forward's command is stopped; a
+// forward through serve is closed by serve, over its control socket, which
+// drops just that channel and leaves its SSH connection up.
+func CloseForward(f ForwardInfo) error {
+	if f.Source == ForwardSourceCLI {
+		if err := signalTerminate(f.PID); err != nil {
+			if errors.Is(err, os.ErrProcessDone) {
+				return fmt.Errorf("no such forward %q: its command has exited", f.ID)
+			}
+			return fmt.Errorf("stop forwarding command (PID %d): %w", f.PID, err)
+		}
+		return nil
+	}
+	_, err := controlRequest(http.MethodDelete, "/forwards/"+url.PathEscape(f.ID), nil)
+	var cerr *ControlError
+	if errors.As(err, &cerr) && cerr.Status == http.StatusNotFound {
+		return fmt.Errorf("no such forward %q: it was already closed", f.ID)
+	}
+	return err
+}
+
+// RateLimits is a request to change the rate limits of serve or a
+// forwarding command, in bytes per second. Nil leaves a direction as is;
+// zero lifts its limit.
+type RateLimits = sshserver.RateLimits
+
+// SetRateLimits asks the process running a forward to change its rate
+// limits, which apply to all of that process's forwards, over the
+// process's control socket
+func SetRateLimits(f ForwardInfo, limits RateLimits) error {
+	_, err := controlRequestTo(f.PID, http.MethodPut, "/rate-limits", limits)
+	return err
+}
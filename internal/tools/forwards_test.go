@@ -0,0 +1,92 @@
+package tools
+
+import (
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/vaurdan/sprite-bootstrap/internal/config"
+	"github.com/vaurdan/sprite-bootstrap/internal/sshserver"
+)
+
+// TestMain keeps the state and runtime directories out of the real home
+// directory; the layout is detected once per process, so this has to
+// happen before any test runs.
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "tools")
+	if err != nil {
+		panic(err)
+	}
+	os.Setenv("HOME", dir)
+	os.Setenv("XDG_STATE_HOME", dir)
+	os.Unsetenv("XDG_RUNTIME_DIR")
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// serveControl serves h on this process's control socket and records this
+// process as the running server
+func serveControl(t *testing.T, h http.Handler) {
+	t.Helper()
+	if err := config.EnsureRuntimeDir(); err != nil {
+		t.Fatal(err)
+	}
+	l, err := sshserver.ListenControl(ControlSocket(os.Getpid()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hs := &http.Server{Handler: h}
+	go hs.Serve(l)
+	t.Cleanup(func() { hs.Close() })
+
+	if err := os.WriteFile(ServePidFile(), []byte(strconv.Itoa(os.Getpid())), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Remove(ServePidFile()) })
+}
+
+func TestCloseForward(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("DELETE /forwards/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if r.PathValue("id") != "ssh-1" {
+			http.Error(w, "no such forward", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	serveControl(t, mux)
+
+	exited := exec.Command("true")
+	if err := exited.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		f       ForwardInfo
+		wantErr string
+	}{
+		{ForwardInfo{ID: "ssh-1", Source: ForwardSourceSSH}, ""},
+		{ForwardInfo{ID: "ssh-2", Source: ForwardSourceSSH}, "no such forward"},
+		{ForwardInfo{ID: "cli-1", Source: ForwardSourceCLI, PID: exited.Process.Pid}, "no such forward"},
+	}
+	for _, tt := range tests {
+		err := CloseForward(tt.f)
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("CloseForward(%s) = %v, want nil", tt.f.ID, err)
+		case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+			t.Errorf("CloseForward(%s) = %v, want %q", tt.f.ID, err, tt.wantErr)
+		}
+	}
+}
+
+func TestFindForwardUnknown(t *testing.T) {
+	forwards := []ForwardInfo{{ID: "ssh-1", Label: "db"}}
+	if _, err := FindForward(forwards, "ssh-9"); err == nil || !strings.Contains(err.Error(), "no such forward") {
+		t.Errorf("FindForward(ssh-9) = %v, want no such forward", err)
+	}
+}
@@ -0,0 +1,134 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/superfly/sprites-go"
+	"github.com/vaurdan/sprite-bootstrap/internal/textfile"
+)
+
+// GitSigning describes how the local git signs commits
+type GitSigning struct {
+	Format    string // gpg.format: "ssh", "openpgp" or "x509"
+	Key       string // user.signingkey as configured
+	PublicKey string // SSH public key, for ssh signing
+	Email     string
+	Name      string
+}
+
+// DetectGitSigning reads the local git config and returns how commits are
+// signed, or nil when commit signing isn't enabled
+func DetectGitSigning() *GitSigning {
+	if gitConfig("commit.gpgsign") != "true" {
+		return nil
+	}
+
+	s := &GitSigning{
+		Format: gitConfig("gpg.format"),
+		Key:    gitConfig("user.signingkey"),
+		Email:  gitConfig("user.email"),
+		Name:   gitConfig("user.name"),
+	}
+	if s.Format == "" {
+		s.Format = "openpgp"
+	}
+	if s.Format == "ssh" {
+		s.PublicKey = sshSigningPublicKey(s.Key)
+	}
+	return s
+}
+
+// gitConfig returns a local git config value, or "" if it isn't set
+func gitConfig(key string) string {
+	out, err := exec.Command("git", "config", "--get", key).Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// sshSigningPublicKey resolves an ssh-format user.signingkey to the public
+// key itself. The key may be given literally ("key::ssh-ed25519 ..." or just
+// "ssh-ed25519 ...") or as a path to the public or private key file.
+func sshSigningPublicKey(key string) string {
+	key = strings.TrimPrefix(key, "key::")
+	if strings.HasPrefix(key, "ssh-") || strings.HasPrefix(key, "ecdsa-") || strings.HasPrefix(key, "sk-") {
+		return key
+	}
+	if key == "" {
+		return ""
+	}
+
+	path := ExpandHome(key)
+	if !strings.HasSuffix(path, ".pub") {
+		if _, err := os.Stat(path + ".pub"); err == nil {
+			path += ".pub"
+		}
+	}
+	data, _, err := textfile.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) < 2 || strings.Contains(fields[0], "PRIVATE") {
+		return ""
+	}
+	return fields[0] + " " + fields[1]
+}
+
+// UsesAgent reports whether signing on the sprite goes through the
+// forwarded SSH agent
+func (s *GitSigning) UsesAgent() bool {
+	return s != nil && s.Format == "ssh" && s.PublicKey != ""
+}
+
+// Summary describes the signing path configured on the sprite, for the
+// instructions
+func (s *GitSigning) Summary() string {
+	switch {
+	case s == nil:
+		return ""
+	case s.UsesAgent():
+		return fmt.Sprintf("Commits are signed with your SSH key (%s) through the forwarded agent; keep the key loaded in ssh-agent", abbreviateKey(s.PublicKey))
+	case s.Format == "ssh":
+		return "Commit signing uses an SSH key, but its public key couldn't be read from user.signingkey; set git signing up on the sprite by hand"
+	default:
+		return fmt.Sprintf("Commit signing uses %s, which isn't set up on the sprite. Import a signing subkey there (gpg --export-secret-subkeys locally, gpg --import on the sprite) or switch to SSH signing (gpg.format=ssh)", s.Format)
+	}
+}
+
+// abbreviateKey shortens a public key for display
+func abbreviateKey(key string) string {
+	fields := strings.Fields(key)
+	if len(fields) < 2 || len(fields[1]) < 16 {
+		return key
+	}
+	return fields[0] + " ..." + fields[1][len(fields[1])-12:]
+}
+
+// configureGitSigning sets up ssh-format commit signing on the sprite,
+// reporting whether anything changed
+func configureGitSigning(ctx context.Context, sprite *sprites.Sprite, s *GitSigning) (bool, error) {
+	gitCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	return runRemoteWrite(gitCtx, sprite, gitSigningScript, nil, s.PublicKey, s.Email, s.Name)
+}
+
+// signingNote returns the instructions line describing commit signing, or
+// "" when commits aren't signed
+func signingNote(opts SetupOptions, agentHint string) string {
+	summary := opts.GitSigning.Summary()
+	if summary == "" {
+		return ""
+	}
+	if opts.GitSigning.UsesAgent() && agentHint != "" {
+		summary += ". " + agentHint
+	}
+	return fmt.Sprintf("\n%sGit signing:%s %s\n", ColorCyan, ColorReset, summary)
+}
@@ -0,0 +1,58 @@
+package tools
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/vaurdan/sprite-bootstrap/internal/config"
+	"github.com/vaurdan/sprite-bootstrap/internal/sshconfig"
+	"github.com/vaurdan/sprite-bootstrap/internal/sshserver"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// knownHostsFile returns the known_hosts file pinned SSH config entries
+// check the server's host key against
+func knownHostsFile() string {
+	return filepath.Join(config.StateDir(), "known_hosts")
+}
+
+// pinnedKnownHosts returns the known_hosts file for SSH config entries when
+// the policy requires host key pinning, and "" otherwise
+func pinnedKnownHosts(opts SetupOptions) string {
+	if !opts.Policy.PinsHostKeys() {
+		return ""
+	}
+	return knownHostsFile()
+}
+
+// pinHostKey writes the serve host key to the pinned known_hosts file. It
+// pins the default host key, which is the one the background server uses
+// unless a serve config names another, and during a rotation's grace
+// period the retired key the server still handshakes with.
+func pinHostKey() error {
+	path, err := sshserver.DefaultHostKeyPath()
+	if err != nil {
+		return err
+	}
+	key, err := sshserver.LoadHostKey(path)
+	if err != nil {
+		return fmt.Errorf("failed to load host key: %w", err)
+	}
+
+	line := []byte(sshconfig.PinnedHostAlias + " " + string(ssh.MarshalAuthorizedKey(key.PublicKey())))
+	if rot, err := sshserver.LoadHostKeyRotation(path); err == nil && rot != nil && rot.Active() {
+		if retired, err := sshserver.LoadHostKey(rot.Retired); err == nil {
+			line = append(line, sshconfig.PinnedHostAlias+" "+string(ssh.MarshalAuthorizedKey(retired.PublicKey()))...)
+		}
+	}
+	if existing, err := os.ReadFile(knownHostsFile()); err == nil && bytes.Equal(existing, line) {
+		return nil
+	}
+	if err := config.EnsureStateDir(); err != nil {
+		return err
+	}
+	return os.WriteFile(knownHostsFile(), line, 0600)
+}
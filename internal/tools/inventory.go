@@ -0,0 +1,97 @@
+package tools
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/vaurdan/sprite-bootstrap/internal/config"
+	"github.com/vaurdan/sprite-bootstrap/internal/sshconfig"
+	"github.com/vaurdan/sprite-bootstrap/internal/ui"
+
+	"github.com/charmbracelet/huh"
+)
+
+// renamedFrom returns the inventory entry recorded for the sprite's ID
+// under another name in the same organization, meaning the sprite was
+// renamed since that setup, or nil
+func renamedFrom(inv *config.Inventory, opts SetupOptions) *config.InventoryEntry {
+	if opts.Sprite == nil || opts.Sprite.ID == "" {
+		return nil
+	}
+	for _, e := range inv.FindID(opts.Sprite.ID) {
+		if e.Name != opts.SpriteName && e.Org == opts.OrgName {
+			return e
+		}
+	}
+	return nil
+}
+
+// offerRenameMigration checks whether the sprite was set up under an old
+// name and, if the user agrees, removes the old name's SSH config entry in
+// txn. It returns the entry to migrate, or nil.
+func offerRenameMigration(inv *config.Inventory, opts SetupOptions, txn *sshconfig.Transaction) *config.InventoryEntry {
+	old := renamedFrom(inv, opts)
+	if old == nil {
+		return nil
+	}
+
+	fmt.Printf("%s⚠%s %s was set up before as %s (same sprite ID %s)\n", ColorYellow, ColorReset, opts.SpriteName, old.Name, opts.Sprite.ID)
+	if !ui.IsInteractive() || !confirmRenameMigration(old.Name, opts.SpriteName) {
+		fmt.Printf("%s⚠%s Kept the local state for %s; remove it with: sprite-bootstrap stop -s %s --local-only\n", ColorYellow, ColorReset, old.Name, old.Name)
+		return nil
+	}
+	txn.Remove(old.Name)
+	return old
+}
+
+// confirmRenameMigration asks whether to move local state to a sprite's
+// new name
+func confirmRenameMigration(oldName, newName string) bool {
+	var migrate bool
+	form := huh.NewForm(
+		huh.NewGroup(
+			huh.NewConfirm().
+				Title(fmt.Sprintf("Move the local setup of %s to %s?", oldName, newName)).
+				Description(fmt.Sprintf("Replaces the %s SSH config entry with %s", sshconfig.HostName(oldName), sshconfig.HostName(newName))).
+				Value(&migrate),
+		),
+	)
+	if err := form.Run(); err != nil {
+		return false
+	}
+	return migrate
+}
+
+// recordSetup notes a finished setup in the inventory, moving the entry of
+// a migrated old name. Failures only warn: the setup itself worked.
+func recordSetup(inv *config.Inventory, tool Tool, opts SetupOptions, migrated *config.InventoryEntry) {
+	if migrated != nil {
+		inv.Rename(migrated, opts.SpriteName)
+	}
+	var id string
+	var created time.Time
+	if opts.Sprite != nil {
+		id, created = opts.Sprite.ID, opts.Sprite.CreatedAt
+	}
+	inv.Record(opts.SpriteName, opts.OrgName, id, tool.Name(), time.Now()).Created = created
+	if err := config.SaveInventory(inv); err != nil {
+		fmt.Printf("%s⚠%s Failed to update the sprite inventory: %v\n", ColorYellow, ColorReset, err)
+		return
+	}
+	if migrated != nil {
+		fmt.Printf("%s✓%s Moved local state from %s to %s\n", ColorGreen, ColorReset, migrated.Name, opts.SpriteName)
+	}
+}
+
+// forgetSetup removes a cleaned up sprite, or one tool's setup of it, from
+// the inventory
+func forgetSetup(spriteName, orgName, tool string) {
+	inv, err := config.LoadInventory()
+	if err != nil || inv.Find(spriteName, orgName) == nil {
+		return
+	}
+	inv.Remove(spriteName, orgName, tool)
+	if err := config.SaveInventory(inv); err != nil {
+		fmt.Printf("%s⚠%s Failed to update the sprite inventory: %v\n", ColorYellow, ColorReset, err)
+	}
+}
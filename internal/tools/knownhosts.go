@@ -0,0 +1,105 @@
+package tools
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// rotatedKnownHostsFiles are the known_hosts files that may hold entries
+// for the serve host key: the user's, which ssh fills in on the first
+// connection, and the pinned one this tool writes
+func rotatedKnownHostsFiles() []string {
+	files := []string{knownHostsFile()}
+	if home, err := os.UserHomeDir(); err == nil {
+		files = append(files, filepath.Join(home, ".ssh", "known_hosts"))
+	}
+	return files
+}
+
+// TrustRotatedHostKey adds an entry for the new serve host key next to
+// every known_hosts entry for the retired one, for the same hosts, so
+// clients accept either during a rotation. It returns how many entries
+// were added.
+func TrustRotatedHostKey(retired, current ssh.PublicKey) (int, error) {
+	return editKnownHosts(retired, current)
+}
+
+// ForgetRetiredHostKey removes the known_hosts entries for a retired serve
+// host key once its rotation is over. It returns how many were removed.
+func ForgetRetiredHostKey(retired ssh.PublicKey) (int, error) {
+	return editKnownHosts(retired, nil)
+}
+
+// editKnownHosts visits each known_hosts entry for the retired key. With a
+// current key, an entry for it and the same hosts is added after it unless
+// one exists; without, the entry is removed.
+func editKnownHosts(retired, current ssh.PublicKey) (int, error) {
+	total := 0
+	for _, path := range rotatedKnownHostsFiles() {
+		data, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return total, err
+		}
+
+		lines := strings.SplitAfter(string(data), "\n")
+		trusted := make(map[string]bool)
+		for _, line := range lines {
+			if hosts, k, ok := parseKnownHostsLine(line); ok && current != nil && sameKey(k, current) {
+				trusted[hosts] = true
+			}
+		}
+
+		var out strings.Builder
+		changed := 0
+		for _, line := range lines {
+			hosts, k, ok := parseKnownHostsLine(line)
+			if !ok || !sameKey(k, retired) || trusted[hosts] {
+				out.WriteString(line)
+				continue
+			}
+			changed++
+			if current == nil {
+				continue
+			}
+			out.WriteString(strings.TrimSuffix(line, "\n") + "\n")
+			out.WriteString(hosts + " " + string(ssh.MarshalAuthorizedKey(current)))
+		}
+		if changed == 0 {
+			continue
+		}
+
+		tmp := path + ".tmp"
+		if err := os.WriteFile(tmp, []byte(out.String()), 0600); err != nil {
+			return total, err
+		}
+		if err := os.Rename(tmp, path); err != nil {
+			return total, err
+		}
+		total += changed
+	}
+	return total, nil
+}
+
+func sameKey(a, b ssh.PublicKey) bool {
+	return bytes.Equal(a.Marshal(), b.Marshal())
+}
+
+// parseKnownHostsLine returns the host list and key of a plain known_hosts
+// entry. Comments and marked lines (@cert-authority, @revoked) are skipped.
+func parseKnownHostsLine(line string) (string, ssh.PublicKey, bool) {
+	fields := strings.Fields(line)
+	if len(fields) < 3 || strings.HasPrefix(fields[0], "#") || strings.HasPrefix(fields[0], "@") {
+		return "", nil, false
+	}
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(fields[1] + " " + fields[2]))
+	if err != nil {
+		return "", nil, false
+	}
+	return fields[0], key, true
+}
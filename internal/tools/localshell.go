@@ -0,0 +1,173 @@
+package tools
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// shellFamily groups local shells by the syntax they take on the command line
+type shellFamily int
+
+const (
+	shellUnknown shellFamily = iota
+	shellPOSIX               // sh, bash, zsh and friends
+	shellFish
+	shellPwsh // PowerShell, Core or Windows
+	shellCmd
+)
+
+func (f shellFamily) String() string {
+	switch f {
+	case shellPOSIX:
+		return "posix"
+	case shellFish:
+		return "fish"
+	case shellPwsh:
+		return "pwsh"
+	case shellCmd:
+		return "cmd"
+	}
+	return "unknown"
+}
+
+// detectShellFamily tells the family of a shell from its path
+func detectShellFamily(shell string) shellFamily {
+	name := strings.ToLower(filepath.Base(shell))
+	name = strings.TrimSuffix(name, ".exe")
+	switch name {
+	case "sh", "bash", "zsh", "dash", "ksh", "mksh", "ash", "yash", "busybox":
+		return shellPOSIX
+	case "fish":
+		return shellFish
+	case "pwsh", "powershell":
+		return shellPwsh
+	case "cmd":
+		return shellCmd
+	}
+	return shellUnknown
+}
+
+// localShell is the user's shell on this machine
+type localShell struct {
+	path   string
+	family shellFamily
+}
+
+// userShell returns the user's login shell: $SHELL, falling back to
+// /bin/sh (%ComSpec% on Windows)
+func userShell() localShell {
+	path := os.Getenv("SHELL")
+	if path == "" {
+		path = "/bin/sh"
+		if runtime.GOOS == "windows" {
+			path = os.Getenv("ComSpec")
+		}
+	}
+	return localShell{path: path, family: detectShellFamily(path)}
+}
+
+var warnUnknownShell sync.Once
+
+// known reports whether commands can be built for the shell, warning once
+// when they can't
+func (s localShell) known() bool {
+	if s.family != shellUnknown {
+		return true
+	}
+	warnUnknownShell.Do(func() {
+		fmt.Printf("%s⚠%s Don't know how to run commands with %s; shell aliases won't be used\n",
+			ColorYellow, ColorReset, s.path)
+	})
+	return false
+}
+
+// hasCommandArgs returns the arguments that make the shell exit 0 when name
+// is a command, alias or function in an interactive session
+func (s localShell) hasCommandArgs(name string) []string {
+	switch s.family {
+	case shellPOSIX:
+		return []string{"-i", "-c", "command -v " + posixQuote(name)}
+	case shellFish:
+		// Fish aliases are functions, which type -q sees
+		return []string{"-i", "-c", "type -q " + fishQuote(name)}
+	case shellPwsh:
+		return []string{"-NoLogo", "-Command",
+			fmt.Sprintf("if (Get-Command %s -ErrorAction SilentlyContinue) { exit 0 } else { exit 1 }", pwshQuote(name))}
+	case shellCmd:
+		// cmd has no aliases that outlive a session, so only PATH counts
+		return []string{"/d", "/c", "where " + name}
+	}
+	return nil
+}
+
+// runArgs returns the arguments that make the shell run name with args in
+// an interactive session, so aliases and functions are available
+func (s localShell) runArgs(name string, args ...string) []string {
+	switch s.family {
+	case shellPOSIX:
+		return []string{"-i", "-c", joinQuoted(name, args, posixQuote)}
+	case shellFish:
+		return []string{"-i", "-c", joinQuoted(name, args, fishQuote)}
+	case shellPwsh:
+		return []string{"-NoLogo", "-Command", "& " + joinQuoted(name, args, pwshQuote)}
+	case shellCmd:
+		return []string{"/d", "/c", joinQuoted(name, args, cmdQuote)}
+	}
+	return nil
+}
+
+// hasCommand checks if a command exists in the shell, including aliases
+// and functions. Unknown shells report false.
+func (s localShell) hasCommand(name string) bool {
+	if !s.known() {
+		return false
+	}
+	return exec.Command(s.path, s.hasCommandArgs(name)...).Run() == nil
+}
+
+// command builds the exec.Cmd that runs name through the shell. Unknown
+// shells run name directly.
+func (s localShell) command(name string, args ...string) *exec.Cmd {
+	if !s.known() {
+		return exec.Command(name, args...)
+	}
+	return exec.Command(s.path, s.runArgs(name, args...)...)
+}
+
+// joinQuoted joins name, left as is so aliases match, with quoted args
+func joinQuoted(name string, args []string, quote func(string) string) string {
+	words := []string{name}
+	for _, arg := range args {
+		words = append(words, quote(arg))
+	}
+	return strings.Join(words, " ")
+}
+
+// posixQuote quotes s for sh: single quotes, with embedded ones closed,
+// escaped and reopened
+func posixQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// fishQuote quotes s for fish, where single quotes take \' and \\ escapes
+func fishQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	return "'" + strings.ReplaceAll(s, "'", `\'`) + "'"
+}
+
+// pwshQuote quotes s for PowerShell, where a single quote is escaped by
+// doubling it
+func pwshQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// cmdQuote quotes s for cmd. Double quotes can't be escaped there, so they
+// are dropped.
+func cmdQuote(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, "") + `"`
+}
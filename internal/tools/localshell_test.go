@@ -0,0 +1,221 @@
+package tools
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestDetectShellFamily(t *testing.T) {
+	tests := []struct {
+		shell string
+		want  shellFamily
+	}{
+		{"/bin/sh", shellPOSIX},
+		{"/usr/bin/bash", shellPOSIX},
+		{"/bin/zsh", shellPOSIX},
+		{"/opt/homebrew/bin/fish", shellFish},
+		{"/usr/local/bin/pwsh", shellPwsh},
+		{`C:\Program Files\PowerShell\7\pwsh.exe`, shellPwsh},
+		{`C:\Windows\System32\WindowsPowerShell\v1.0\PowerShell.exe`, shellPwsh},
+		{`C:\Windows\system32\cmd.exe`, shellCmd},
+		{"/usr/bin/xonsh", shellUnknown},
+		{"/usr/bin/nu", shellUnknown},
+		{"", shellUnknown},
+	}
+	for _, tt := range tests {
+		if runtime.GOOS != "windows" && strings.Contains(tt.shell, `\`) {
+			// filepath.Base only splits on backslashes on Windows
+			continue
+		}
+		if got := detectShellFamily(tt.shell); got != tt.want {
+			t.Errorf("detectShellFamily(%q) = %v, want %v", tt.shell, got, tt.want)
+		}
+	}
+}
+
+// awkwardArgs are arguments that break naive quoting
+var awkwardArgs = []string{
+	"plain",
+	"two words",
+	"",
+	"it's",
+	`say "hi"`,
+	`back\slash`,
+	`trailing\`,
+	"$HOME and `date` and $(id)",
+	"semi; colon && pipe | star *",
+	"ssh://sprite-a/home/sprite/my app",
+}
+
+func TestQuote(t *testing.T) {
+	tests := []struct {
+		name  string
+		quote func(string) string
+		in    string
+		want  string
+	}{
+		{"posix", posixQuote, "it's", `'it'\''s'`},
+		{"posix", posixQuote, "", `''`},
+		{"fish", fishQuote, "it's", `'it\'s'`},
+		{"fish", fishQuote, `a\b`, `'a\\b'`},
+		{"pwsh", pwshQuote, "it's", `'it''s'`},
+		{"pwsh", pwshQuote, "$env:HOME", `'$env:HOME'`},
+		{"cmd", cmdQuote, "two words", `"two words"`},
+		{"cmd", cmdQuote, `say "hi"`, `"say hi"`},
+	}
+	for _, tt := range tests {
+		if got := tt.quote(tt.in); got != tt.want {
+			t.Errorf("%s quote(%q) = %s, want %s", tt.name, tt.in, got, tt.want)
+		}
+	}
+}
+
+// TestPOSIXQuoteRoundTrip has sh parse quoted arguments back
+func TestPOSIXQuoteRoundTrip(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not installed")
+	}
+	script := joinQuoted(`printf '%s\0'`, awkwardArgs, posixQuote)
+	out, err := exec.Command("sh", "-c", script).Output()
+	if err != nil {
+		t.Fatalf("sh -c %s: %v", script, err)
+	}
+	if got := strings.Split(strings.TrimSuffix(string(out), "\x00"), "\x00"); !slices.Equal(got, awkwardArgs) {
+		t.Errorf("sh parsed %q, want %q", got, awkwardArgs)
+	}
+}
+
+// fakeShell writes a script named name that records its arguments, one
+// per line, in the returned file, and exits 0 only when an argument
+// mentions "present"
+func fakeShell(t *testing.T, name string) (string, string) {
+	t.Helper()
+	dir := t.TempDir()
+	log := filepath.Join(dir, "args")
+	path := filepath.Join(dir, name)
+	script := `#!/bin/sh
+for a in "$@"; do printf '%s\n' "$a"; done > "` + log + `"
+case "$*" in *present*) exit 0 ;; esac
+exit 1
+`
+	if err := os.WriteFile(path, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return path, log
+}
+
+// readArgs returns the arguments a fake shell recorded
+func readArgs(t *testing.T, log string) []string {
+	t.Helper()
+	data, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatalf("shell wasn't run: %v", err)
+	}
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+}
+
+// TestLocalShellCommands runs alias probes and launches through fake
+// shells of each family and checks the command lines they get
+func TestLocalShellCommands(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake shells are sh scripts")
+	}
+	tests := []struct {
+		shell     string
+		wantProbe []string
+		wantRun   []string
+	}{
+		{"bash", []string{"-i", "-c", "command -v 'zed-present'"},
+			[]string{"-i", "-c", `zed-present '--new' 'it'\''s here'`}},
+		{"zsh", []string{"-i", "-c", "command -v 'zed-present'"},
+			[]string{"-i", "-c", `zed-present '--new' 'it'\''s here'`}},
+		{"fish", []string{"-i", "-c", "type -q 'zed-present'"},
+			[]string{"-i", "-c", `zed-present '--new' 'it\'s here'`}},
+		{"pwsh", []string{"-NoLogo", "-Command", "if (Get-Command 'zed-present' -ErrorAction SilentlyContinue) { exit 0 } else { exit 1 }"},
+			[]string{"-NoLogo", "-Command", `& zed-present '--new' 'it''s here'`}},
+		{"cmd", []string{"/d", "/c", "where zed-present"},
+			[]string{"/d", "/c", `zed-present "--new" "it's here"`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.shell, func(t *testing.T) {
+			path, log := fakeShell(t, tt.shell)
+			t.Setenv("SHELL", path)
+			sh := userShell()
+
+			if !sh.hasCommand("zed-present") {
+				t.Error("hasCommand reported a command the shell has as missing")
+			}
+			if got := readArgs(t, log); !slices.Equal(got, tt.wantProbe) {
+				t.Errorf("probe ran %s %q, want %q", tt.shell, got, tt.wantProbe)
+			}
+			if sh.hasCommand("zed-missing") {
+				t.Error("hasCommand reported a missing command")
+			}
+
+			if err := sh.command("zed-present", "--new", "it's here").Run(); err != nil {
+				t.Fatalf("launch: %v", err)
+			}
+			if got := readArgs(t, log); !slices.Equal(got, tt.wantRun) {
+				t.Errorf("launch ran %s %q, want %q", tt.shell, got, tt.wantRun)
+			}
+		})
+	}
+}
+
+// TestUnknownShell checks that a shell commands can't be built for runs
+// the binary directly instead
+func TestUnknownShell(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake shells are sh scripts")
+	}
+	path, log := fakeShell(t, "xonsh")
+	t.Setenv("SHELL", path)
+	sh := userShell()
+
+	if sh.hasCommand("zed-present") {
+		t.Error("hasCommand found a command through an unknown shell")
+	}
+	cmd := sh.command("zed", "--new", "two words")
+	if want := []string{"zed", "--new", "two words"}; !slices.Equal(cmd.Args, want) {
+		t.Errorf("command args = %q, want %q", cmd.Args, want)
+	}
+	if _, err := os.Stat(log); err == nil {
+		t.Error("the unknown shell was run")
+	}
+}
+
+// TestBashAliasLaunch launches through an alias defined in a real bash's
+// .bashrc, with arguments that need quoting
+func TestBashAliasLaunch(t *testing.T) {
+	bash, err := exec.LookPath("bash")
+	if err != nil {
+		t.Skip("bash not installed")
+	}
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("SHELL", bash)
+	rc := `alias myzed='printf "%s\0"'` + "\n"
+	if err := os.WriteFile(filepath.Join(home, ".bashrc"), []byte(rc), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	sh := userShell()
+
+	if !sh.hasCommand("myzed") {
+		t.Fatal("bash doesn't report the alias from .bashrc")
+	}
+	if sh.hasCommand("myzed-missing") {
+		t.Error("bash reports a command that doesn't exist")
+	}
+	out, err := sh.command("myzed", awkwardArgs...).Output()
+	if err != nil {
+		t.Fatalf("launch: %v", err)
+	}
+	if got := strings.Split(strings.TrimSuffix(string(out), "\x00"), "\x00"); !slices.Equal(got, awkwardArgs) {
+		t.Errorf("alias got %q, want %q", got, awkwardArgs)
+	}
+}
@@ -0,0 +1,82 @@
+package tools
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Pin fixes a downloaded artifact to a version and its SHA-256 digest
+type Pin struct {
+	Version string
+	SHA256  string
+}
+
+var sha256Pattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+var (
+	pinsMu sync.RWMutex
+
+	// pins maps an artifact ID (an extension publisher.name) to the version
+	// and digest we have verified. None are built in, since extensions
+	// release too often for a pin to stay current; they come from --pin.
+	pins = map[string]Pin{}
+
+	// allowUnpinned lets unpinned artifacts be installed at their latest
+	// version, unverified. Without it they are refused.
+	allowUnpinned bool
+)
+
+// SetAllowUnpinned sets whether artifacts without a pin may be installed
+// unverified
+func SetAllowUnpinned(allow bool) {
+	pinsMu.Lock()
+	defer pinsMu.Unlock()
+	allowUnpinned = allow
+}
+
+// requirePin returns the pin for an artifact ID. Without one it fails,
+// unless unpinned installs are allowed, in which case the zero Pin (latest
+// version, no digest) is returned with a warning.
+func requirePin(id string) (Pin, error) {
+	pinsMu.RLock()
+	defer pinsMu.RUnlock()
+	if pin, ok := pins[id]; ok {
+		return pin, nil
+	}
+	if !allowUnpinned {
+		return Pin{}, fmt.Errorf("%s is not pinned; pass --pin %s@VERSION=SHA256, or --allow-unpinned to install the latest version unverified", id, id)
+	}
+	fmt.Printf("%s⚠%s %s is not pinned, installing the latest version unverified\n", ColorYellow, ColorReset, id)
+	return Pin{}, nil
+}
+
+// LookupPin returns the pin for an artifact ID
+func LookupPin(id string) (Pin, bool) {
+	pinsMu.RLock()
+	defer pinsMu.RUnlock()
+	pin, ok := pins[id]
+	return pin, ok
+}
+
+// SetPin adds or replaces a pin from a spec of the form id@version=sha256
+func SetPin(spec string) error {
+	id, rest, ok := strings.Cut(spec, "@")
+	if !ok || id == "" {
+		return fmt.Errorf("invalid pin %q (expected id@version=sha256)", spec)
+	}
+	version, digest, ok := strings.Cut(rest, "=")
+	if !ok || version == "" {
+		return fmt.Errorf("invalid pin %q (expected id@version=sha256)", spec)
+	}
+	digest = strings.ToLower(digest)
+	if !sha256Pattern.MatchString(digest) {
+		return fmt.Errorf("invalid pin %q: checksum must be 64 hex characters", spec)
+	}
+
+	pinsMu.Lock()
+	defer pinsMu.Unlock()
+	pins[id] = Pin{Version: version, SHA256: digest}
+	return nil
+}
@@ -0,0 +1,62 @@
+package tools
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSetPin(t *testing.T) {
+	digest := strings.Repeat("ab", 32)
+	tests := []struct {
+		spec    string
+		want    Pin
+		wantErr bool
+	}{
+		{"anthropic.claude-code@2.0.1=" + digest, Pin{"2.0.1", digest}, false},
+		{"anthropic.claude-code@2.0.1=" + strings.ToUpper(digest), Pin{"2.0.1", digest}, false},
+		{"anthropic.claude-code=" + digest, Pin{}, true},
+		{"@2.0.1=" + digest, Pin{}, true},
+		{"anthropic.claude-code@=" + digest, Pin{}, true},
+		{"anthropic.claude-code@2.0.1=abc", Pin{}, true},
+	}
+	for _, tt := range tests {
+		err := SetPin(tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("SetPin(%q) error = %v, want error %v", tt.spec, err, tt.wantErr)
+			continue
+		}
+		if err == nil {
+			if got, _ := LookupPin("anthropic.claude-code"); got != tt.want {
+				t.Errorf("SetPin(%q) pinned %+v, want %+v", tt.spec, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestRequirePin(t *testing.T) {
+	digest := strings.Repeat("cd", 32)
+	if err := SetPin("pub.pinned@1.0.0=" + digest); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { SetAllowUnpinned(false) })
+
+	tests := []struct {
+		id            string
+		allowUnpinned bool
+		want          Pin
+		wantErr       bool
+	}{
+		{"pub.pinned", false, Pin{"1.0.0", digest}, false},
+		{"pub.pinned", true, Pin{"1.0.0", digest}, false},
+		{"pub.unpinned", false, Pin{}, true},
+		{"pub.unpinned", true, Pin{}, false},
+	}
+	for _, tt := range tests {
+		SetAllowUnpinned(tt.allowUnpinned)
+		got, err := requirePin(tt.id)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("requirePin(%q) with allowUnpinned %v = %+v, %v; want %+v, error %v",
+				tt.id, tt.allowUnpinned, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/vaurdan/sprite-bootstrap/internal/config"
+	"github.com/vaurdan/sprite-bootstrap/internal/textfile"
+
+	"github.com/superfly/sprites-go"
+)
+
+// extensionIDPattern matches marketplace extension IDs (publisher.name)
+var extensionIDPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9-]*\.[A-Za-z0-9][A-Za-z0-9-]*$`)
+
+// envKeyPattern matches valid environment variable names
+var envKeyPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// remoteEnvFile is where the profile env file is stored on the sprite
+const remoteEnvFile = ".sprite-bootstrap/env"
+
+// ValidateProfile checks that everything a profile references exists
+func ValidateProfile(p *config.Profile) error {
+	var errs []error
+
+	tool, ok := Get(p.Tool)
+	if !ok {
+		names := Names()
+		sort.Strings(names)
+		errs = append(errs, fmt.Errorf("unknown tool %q (available: %s)", p.Tool, strings.Join(names, ", ")))
+	}
+
+	if len(p.Extensions) > 0 && ok {
+		if _, supported := tool.(ExtensionInstaller); !supported {
+			errs = append(errs, fmt.Errorf("tool %q does not support remote extensions", p.Tool))
+		}
+	}
+	for _, id := range p.Extensions {
+		if !extensionIDPattern.MatchString(id) {
+			errs = append(errs, fmt.Errorf("invalid extension ID %q (expected publisher.name)", id))
+		}
+	}
+
+	if p.EnvFile != "" {
+		if _, err := readEnvFile(ExpandHome(p.EnvFile)); err != nil {
+			errs = append(errs, fmt.Errorf("env file: %w", err))
+		}
+	}
+
+	if p.Dotfiles != "" {
+		if url, _ := splitDotfiles(p.Dotfiles); url == "" {
+			errs = append(errs, fmt.Errorf("dotfiles: missing repository URL"))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// ExpandRemotePath fills in a profile remote path template
+func ExpandRemotePath(template, spriteName, orgName, profileName string) string {
+	return strings.NewReplacer(
+		"{sprite}", spriteName,
+		"{org}", orgName,
+		"{profile}", profileName,
+	).Replace(template)
+}
+
+// ExpandHome expands a leading ~ to the user's home directory
+func ExpandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(homeDir, path[1:])
+}
+
+// splitDotfiles splits "url#branch" into its parts
+func splitDotfiles(spec string) (url, branch string) {
+	url, branch, _ = strings.Cut(spec, "#")
+	return strings.TrimSpace(url), strings.TrimSpace(branch)
+}
+
+// readEnvFile reads and validates a KEY=VALUE env file. The result has LF
+// line endings and no BOM, since bash on the sprite would keep a trailing \r
+// in each value.
+func readEnvFile(path string) ([]byte, error) {
+	data, _, err := textfile.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, _, ok := strings.Cut(strings.TrimPrefix(line, "export "), "=")
+		if !ok || !envKeyPattern.MatchString(key) {
+			return nil, fmt.Errorf("%s:%d: expected KEY=VALUE", path, i+1)
+		}
+	}
+	return data, nil
+}
+
+// setupExtras applies the optional env file, dotfiles, git signing and
+// extensions before the tool-specific setup runs
+func setupExtras(ctx context.Context, tool Tool, opts SetupOptions) error {
+	if opts.EnvFile != "" {
+		fmt.Printf("%s⏳%s Copying env file...\n", ColorYellow, ColorReset)
+		var changed bool
+		if err := traceStep(ctx, "profile.env_file", func(ctx context.Context) (err error) {
+			changed, err = installEnvFile(ctx, opts.Sprite, ExpandHome(opts.EnvFile))
+			return err
+		}); err != nil {
+			return fmt.Errorf("failed to copy env file: %w", err)
+		}
+		fmt.Printf("%s✓%s Env file %s in ~/%s\n", ColorGreen, ColorReset, changedWord(changed, "installed"), remoteEnvFile)
+	}
+
+	if opts.Dotfiles != "" {
+		fmt.Printf("%s⏳%s Installing dotfiles...\n", ColorYellow, ColorReset)
+		if err := traceStep(ctx, "profile.dotfiles", func(ctx context.Context) error {
+			return installDotfiles(ctx, opts.Sprite, opts.Dotfiles)
+		}); err != nil {
+			return fmt.Errorf("failed to install dotfiles: %w", err)
+		}
+		fmt.Printf("%s✓%s Dotfiles installed\n", ColorGreen, ColorReset)
+	}
+
+	if opts.GitSigning.UsesAgent() {
+		fmt.Printf("%s⏳%s Configuring git commit signing...\n", ColorYellow, ColorReset)
+		var changed bool
+		if err := traceStep(ctx, "git.signing", func(ctx context.Context) (err error) {
+			changed, err = configureGitSigning(ctx, opts.Sprite, opts.GitSigning)
+			return err
+		}); err != nil {
+			// Non-fatal, commits can still be made unsigned
+			fmt.Printf("%s⚠%s Failed to configure git signing: %v\n", ColorYellow, ColorReset, err)
+		} else {
+			fmt.Printf("%s✓%s Git signing %s\n", ColorGreen, ColorReset, changedWord(changed, "configured"))
+		}
+	}
+
+	if len(opts.Extensions) > 0 {
+		installer, ok := tool.(ExtensionInstaller)
+		if !ok {
+			return fmt.Errorf("%s does not support remote extensions", tool.Name())
+		}
+		if err := traceStep(ctx, "profile.extensions", func(ctx context.Context) error {
+			return installer.InstallExtensions(ctx, opts.Sprite, opts.Extensions)
+		}); err != nil {
+			// Non-fatal, individual failures were already reported
+			fmt.Printf("%s⚠%s %v\n", ColorYellow, ColorReset, err)
+		}
+	}
+
+	return nil
+}
+
+// installEnvFile copies a local env file to the sprite and sources it from
+// ~/.profile so login shells pick it up, reporting whether either changed
+func installEnvFile(ctx context.Context, sprite *sprites.Sprite, path string) (bool, error) {
+	data, err := readEnvFile(path)
+	if err != nil {
+		return false, err
+	}
+
+	envCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	return runRemoteWrite(envCtx, sprite, envFileScript, bytes.NewReader(data), remoteEnvFile)
+}
+
+// installDotfiles clones (or updates) a dotfiles repository into ~/.dotfiles
+// and runs its install script if it has one
+func installDotfiles(ctx context.Context, sprite *sprites.Sprite, spec string) error {
+	url, branch := splitDotfiles(spec)
+
+	dotCtx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+
+	cmd := lockedCommand(dotCtx, sprite, dotfilesScript, url, branch)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return spriteLockError(sprite, cmd.Run())
+}
+
+// runPostHooks runs each hook on the sprite in the remote path, stopping at
+// the first failure
+func runPostHooks(ctx context.Context, opts SetupOptions) error {
+	for _, hook := range opts.PostHooks {
+		fmt.Printf("%s⏳%s Running hook: %s\n", ColorYellow, ColorReset, hook)
+		err := traceStep(ctx, "profile.post_hook", func(ctx context.Context) error {
+			cmd := opts.Sprite.CommandContext(ctx, "/bin/bash", "-lc", hook)
+			cmd.Dir = opts.RemotePath
+			cmd.Stdout = os.Stdout
+			cmd.Stderr = os.Stderr
+			return cmd.Run()
+		})
+		if err != nil {
+			return fmt.Errorf("hook %q failed: %w", hook, err)
+		}
+		fmt.Printf("%s✓%s Hook finished\n", ColorGreen, ColorReset)
+	}
+	return nil
+}
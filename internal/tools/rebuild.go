@@ -0,0 +1,136 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/vaurdan/sprite-bootstrap/internal/config"
+	"github.com/vaurdan/sprite-bootstrap/internal/ui"
+
+	"github.com/charmbracelet/huh"
+	"github.com/superfly/sprites-go"
+)
+
+// checkRebuilt looks for editor server caches left from before the sprite
+// was rebuilt, which half-work against the new image, and offers to clear
+// them before the editor connects. The new identity is recorded either way,
+// so the question isn't asked again.
+func checkRebuilt(ctx context.Context, inv *config.Inventory, opts SetupOptions) {
+	if opts.Sprite == nil {
+		return
+	}
+	e := inv.Find(opts.SpriteName, opts.OrgName)
+	if e == nil || !e.Rebuilt(opts.Sprite.ID, opts.Sprite.CreatedAt) {
+		return
+	}
+	defer func() {
+		e.ID, e.Created = opts.Sprite.ID, opts.Sprite.CreatedAt
+		if err := config.SaveInventory(inv); err != nil {
+			fmt.Printf("%s⚠%s Failed to update the sprite inventory: %v\n", ColorYellow, ColorReset, err)
+		}
+	}()
+
+	caches, err := findServerCaches(ctx, opts.Sprite)
+	if err != nil {
+		fmt.Printf("%s⚠%s Failed to check for stale editor caches: %v\n", ColorYellow, ColorReset, err)
+		return
+	}
+	if len(caches) == 0 {
+		return
+	}
+
+	var paths []string
+	for _, name := range slices.Sorted(maps.Keys(caches)) {
+		for _, p := range caches[name] {
+			paths = append(paths, "~/"+p)
+		}
+	}
+	fmt.Printf("%s⚠%s %s was rebuilt since its last setup (created %s), and editor server caches from before remain: %s\n",
+		ColorYellow, ColorReset, opts.SpriteName, opts.Sprite.CreatedAt.Local().Format(time.DateTime), strings.Join(paths, ", "))
+	fmt.Printf("    They can crash the editor's extension host on the new image\n")
+	if !ui.IsInteractive() || !confirmClearCaches(opts.SpriteName, paths) {
+		fmt.Printf("    To clear them, run on the sprite: rm -rf %s\n", strings.Join(paths, " "))
+		return
+	}
+
+	fmt.Printf("%s⏳%s Clearing stale editor caches...\n", ColorYellow, ColorReset)
+	if err := traceStep(ctx, "sprite.clear_caches", func(ctx context.Context) error {
+		return clearServerCaches(ctx, opts.Sprite, caches)
+	}); err != nil {
+		fmt.Printf("%s⚠%s Failed to clear stale editor caches: %v\n", ColorYellow, ColorReset, err)
+		return
+	}
+	fmt.Printf("%s✓%s Cleared stale editor caches\n", ColorGreen, ColorReset)
+}
+
+// findServerCaches returns the server cache paths present on the sprite,
+// by tool
+func findServerCaches(ctx context.Context, sprite *sprites.Sprite) (map[string][]string, error) {
+	checkCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	var all []string
+	owner := make(map[string]string)
+	for name, tool := range registry {
+		if c, ok := tool.(ServerCacher); ok {
+			for _, p := range c.ServerCaches() {
+				all = append(all, p)
+				owner[p] = name
+			}
+		}
+	}
+	if len(all) == 0 {
+		return nil, nil
+	}
+
+	out, err := sprite.CommandContext(checkCtx, "/bin/sh", append([]string{"-c",
+		`cd "$HOME" && for p; do [ -e "$p" ] && printf '%s\n' "$p"; done; true`, "sh"}, all...)...).Output()
+	if err != nil {
+		return nil, err
+	}
+	caches := make(map[string][]string)
+	for _, p := range strings.Fields(string(out)) {
+		if name, ok := owner[p]; ok {
+			caches[name] = append(caches[name], p)
+		}
+	}
+	return caches, nil
+}
+
+// clearServerCaches stops each tool's server with its cleanup, then removes
+// its caches
+func clearServerCaches(ctx context.Context, sprite *sprites.Sprite, caches map[string][]string) error {
+	var paths []string
+	for _, name := range slices.Sorted(maps.Keys(caches)) {
+		if c, ok := registry[name].(Cleaner); ok {
+			_ = c.Cleanup(ctx, sprite) // Best effort; a running server only delays the removal
+		}
+		paths = append(paths, caches[name]...)
+	}
+
+	clearCtx, cancel := context.WithTimeout(ctx, 60*time.Second)
+	defer cancel()
+	cmd := lockedCommand(clearCtx, sprite, `cd "$HOME" && rm -rf -- "$@"`, paths...)
+	return spriteLockError(sprite, cmd.Run())
+}
+
+// confirmClearCaches asks whether to clear stale editor caches
+func confirmClearCaches(spriteName string, paths []string) bool {
+	remove := true
+	form := huh.NewForm(
+		huh.NewGroup(
+			huh.NewConfirm().
+				Title(fmt.Sprintf("Clear the stale editor caches on %s?", spriteName)).
+				Description("Removes " + strings.Join(paths, ", ") + "; the editors download their servers again on connect").
+				Value(&remove),
+		),
+	)
+	if err := form.Run(); err != nil {
+		return false
+	}
+	return remove
+}
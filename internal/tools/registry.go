@@ -1,24 +1,31 @@
 package tools
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
-	"sprite-bootstrap/internal/config"
-	"sprite-bootstrap/internal/sshserver"
+	"github.com/vaurdan/sprite-bootstrap/internal/config"
+	"github.com/vaurdan/sprite-bootstrap/internal/sshconfig"
+	"github.com/vaurdan/sprite-bootstrap/internal/sshserver"
+	"github.com/vaurdan/sprite-bootstrap/internal/telemetry"
 
 	"github.com/superfly/sprites-go"
 )
 
-// ANSI color codes for terminal output
-const (
+// ANSI color codes for terminal output, empty once DisableColors is called
+var (
 	ColorReset  = "\033[0m"
 	ColorGreen  = "\033[32m"
 	ColorYellow = "\033[33m"
@@ -26,6 +33,11 @@ const (
 	ColorBold   = "\033[1m"
 )
 
+// DisableColors turns off color in all further output
+func DisableColors() {
+	ColorReset, ColorGreen, ColorYellow, ColorCyan, ColorBold = "", "", "", "", ""
+}
+
 // registry holds all registered tools
 var registry = make(map[string]Tool)
 
@@ -54,8 +66,65 @@ func Names() []string {
 	return names
 }
 
+// CleanupOptions selects what CleanupSpriteWith removes
+type CleanupOptions struct {
+	// Tool limits cleanup to one tool's artifacts; empty cleans every tool
+	Tool string
+
+	// LocalOnly removes local artifacts (the SSH config entry) without
+	// connecting to the sprite
+	LocalOnly bool
+}
+
 // CleanupSprite runs cleanup for all registered tools that implement Cleaner
 func CleanupSprite(ctx context.Context, spriteName, orgName string) error {
+	return CleanupSpriteWith(ctx, spriteName, orgName, CleanupOptions{})
+}
+
+// CleanupSpriteWith removes the artifacts opts selects, printing what was
+// removed and what was kept
+func CleanupSpriteWith(ctx context.Context, spriteName, orgName string, opts CleanupOptions) error {
+	selected := make(map[string]Tool)
+	if opts.Tool != "" {
+		tool, ok := Get(opts.Tool)
+		if !ok {
+			return fmt.Errorf("unknown tool: %s", opts.Tool)
+		}
+		selected[tool.Name()] = tool
+	} else {
+		for name, tool := range registry {
+			selected[name] = tool
+		}
+	}
+
+	// Remove the SSH config entry with a single rewrite if a selected tool
+	// writes one
+	txn := sshconfig.Begin()
+	for _, tool := range selected {
+		if _, ok := tool.(SSHConfigurer); ok {
+			txn.Remove(spriteName)
+		}
+	}
+	if txn.Empty() {
+		fmt.Printf("  Kept SSH config entry for %s (not written by %s)\n", spriteName, opts.Tool)
+	} else if err := commitSSHConfig(txn); err != nil {
+		fmt.Printf("%s⚠%s Failed to remove SSH config entry: %v\n", ColorYellow, ColorReset, err)
+	}
+	forgetSetup(spriteName, orgName, opts.Tool)
+
+	names := make([]string, 0, len(registry))
+	for name, tool := range registry {
+		if _, ok := tool.(Cleaner); ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	if opts.LocalOnly {
+		fmt.Printf("  Kept %s state on %s (--local-only)\n", strings.Join(names, ", "), spriteName)
+		return nil
+	}
+
 	// Resolve credentials
 	tokenOpts := &sshserver.TokenOptions{
 		Organization: orgName,
@@ -71,44 +140,93 @@ func CleanupSprite(ctx context.Context, spriteName, orgName string) error {
 		return fmt.Errorf("sprite not found: %s", spriteName)
 	}
 
-	// Run cleanup for all tools that implement Cleaner
-	for _, tool := range registry {
-		if cleaner, ok := tool.(Cleaner); ok {
-			fmt.Printf("%s⏳%s Cleaning up %s on %s%s%s...\n",
-				ColorYellow, ColorReset, tool.Name(), ColorCyan, spriteName, ColorReset)
-			if err := cleaner.Cleanup(ctx, sprite); err != nil {
-				fmt.Printf("%s⚠%s Failed to cleanup %s: %v\n",
-					ColorYellow, ColorReset, tool.Name(), err)
-			} else {
-				fmt.Printf("%s✓%s Cleaned up %s\n", ColorGreen, ColorReset, tool.Name())
-			}
+	// Run cleanup for the selected tools that implement Cleaner
+	for _, name := range names {
+		tool := registry[name]
+		if _, ok := selected[name]; !ok {
+			fmt.Printf("  Kept %s state on %s (not selected)\n", name, spriteName)
+			continue
+		}
+		fmt.Printf("%s⏳%s Cleaning up %s on %s%s%s...\n",
+			ColorYellow, ColorReset, name, ColorCyan, spriteName, ColorReset)
+		if err := tool.(Cleaner).Cleanup(ctx, sprite); err != nil {
+			fmt.Printf("%s⚠%s Failed to cleanup %s: %v\n",
+				ColorYellow, ColorReset, name, err)
+		} else {
+			fmt.Printf("%s✓%s Cleaned up %s\n", ColorGreen, ColorReset, name)
 		}
 	}
 
 	return nil
 }
 
-// Bootstrap performs the common bootstrap sequence for any tool
+// Bootstrap performs the common bootstrap sequence for any tool, ending
+// with the tool's instructions and, unless opts.Quiet, the summary
 func Bootstrap(ctx context.Context, tool Tool, opts SetupOptions) error {
+	summary, err := BootstrapSummary(ctx, tool, opts)
+	if err != nil {
+		return err
+	}
+	if !opts.Quiet {
+		fmt.Print(summary)
+	}
+	return nil
+}
+
+// BootstrapSummary is Bootstrap without printing the summary, which it
+// returns instead
+func BootstrapSummary(ctx context.Context, tool Tool, opts SetupOptions) (*Summary, error) {
+	ctx, span := telemetry.Start(ctx, "bootstrap")
+	span.SetString("tool", tool.Name())
+	span.SetString("sprite.name", opts.SpriteName)
+	defer span.End()
+
+	summary, err := bootstrap(ctx, tool, opts)
+	span.RecordError(err)
+	return summary, err
+}
+
+func bootstrap(ctx context.Context, tool Tool, opts SetupOptions) (*Summary, error) {
+	if err := checkEditorArgs(tool, opts.EditorArgs); err != nil {
+		return nil, err
+	}
+	if err := checkStdio(tool, opts); err != nil {
+		return nil, err
+	}
+
 	// Validate prerequisites
 	if err := tool.Validate(ctx); err != nil {
-		return fmt.Errorf("validation failed: %w", err)
+		return nil, fmt.Errorf("validation failed: %w", err)
 	}
 
 	// Wake up the sprite first (it might be in warm/sleep state)
 	fmt.Printf("%s⏳%s Waking sprite %s%s%s...\n", ColorYellow, ColorReset, ColorCyan, opts.SpriteName, ColorReset)
-	sprite, err := wakeSprite(ctx, opts)
+	sprite, policy, err := wakeSprite(ctx, opts)
 	if err != nil {
-		return fmt.Errorf("failed to wake sprite: %w", err)
+		return nil, fmt.Errorf("failed to wake sprite: %w", err)
 	}
-	opts.Sprite = sprite
+	opts.Sprite, opts.Policy = sprite, policy
 	fmt.Printf("%s✓%s Sprite ready\n", ColorGreen, ColorReset)
 
-	// Ensure serve is running
-	if !IsServeRunning() {
+	// A --path naming a file opens its directory with the file focused
+	if err := traceStep(ctx, "sprite.check_path", func(ctx context.Context) error {
+		return resolveOpenFile(ctx, &opts)
+	}); err != nil {
+		fmt.Printf("%s⚠%s Failed to check %s, opening it as a directory: %v\n", ColorYellow, ColorReset, opts.RemotePath, err)
+	} else if opts.OpenFile != "" {
+		fmt.Printf("%s✓%s Opening %s in %s\n", ColorGreen, ColorReset, path.Base(opts.OpenFile), opts.RemotePath)
+	}
+
+	// Ensure serve is running, unless ssh starts stdio for each connection
+	if opts.Stdio {
+		fmt.Printf("%s✓%s Connecting through sprite-bootstrap stdio, no SSH server needed\n", ColorGreen, ColorReset)
+	} else if !IsServeRunning() {
 		fmt.Printf("%s⏳%s Starting SSH server...\n", ColorYellow, ColorReset)
-		if err := StartServe(opts.LocalPort, opts.OrgName); err != nil {
-			return fmt.Errorf("failed to start SSH server: %w", err)
+		err := traceStep(ctx, "serve.start", func(ctx context.Context) error {
+			return startServe(opts, "")
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to start SSH server: %w", err)
 		}
 		fmt.Printf("%s✓%s SSH server listening on port %d\n", ColorGreen, ColorReset, opts.LocalPort)
 	} else {
@@ -117,56 +235,181 @@ func Bootstrap(ctx context.Context, tool Tool, opts SetupOptions) error {
 
 	// Test SSH connection (also accepts host key fingerprint)
 	fmt.Printf("%s⏳%s Testing SSH connection...\n", ColorYellow, ColorReset)
-	if err := testSSHConnection(ctx, opts); err != nil {
-		return fmt.Errorf("SSH connection test failed: %w", err)
+	if err := traceStep(ctx, "ssh.test", func(ctx context.Context) error {
+		return testSSHConnection(ctx, opts)
+	}); err != nil {
+		return nil, fmt.Errorf("SSH connection test failed: %w%s", err, serveLogExcerpt())
 	}
 	fmt.Printf("%s✓%s SSH connection verified\n", ColorGreen, ColorReset)
 
+	if opts.Policy.PinsHostKeys() {
+		if err := traceStep(ctx, "ssh.pin_host_key", func(context.Context) error {
+			return pinHostKey()
+		}); err != nil {
+			return nil, fmt.Errorf("failed to pin SSH host key required by policy %q: %w", opts.Policy.Name, err)
+		}
+		fmt.Printf("%s✓%s SSH host key pinned (policy %s)\n", ColorGreen, ColorReset, opts.Policy.Name)
+	}
+
+	opts.GitSigning = DetectGitSigning()
+
+	// A sprite renamed since its last setup can take over its old local
+	// state instead of getting a second copy
+	inv, err := config.LoadInventory()
+	if err != nil {
+		fmt.Printf("%s⚠%s Failed to read the sprite inventory: %v\n", ColorYellow, ColorReset, err)
+	}
+
+	// Editor server caches from before a rebuild break in odd ways once the
+	// editor connects, so they are dealt with first
+	checkRebuilt(ctx, inv, opts)
+
+	// SSH config entries, written in one go before the tool launches the IDE
+	txn := sshconfig.Begin()
+	migrated := offerRenameMigration(inv, opts, txn)
+	var entry *sshconfig.Entry
+	if c, ok := tool.(SSHConfigurer); ok {
+		e := c.SSHConfigEntry(opts)
+		entry = &e
+		txn.Add(e)
+	}
+	if err := traceStep(ctx, "ssh_config.commit", func(context.Context) error {
+		return commitSSHConfig(txn)
+	}); err != nil {
+		fmt.Printf("%s⚠%s Failed to update SSH config: %v\n", ColorYellow, ColorReset, err)
+	} else if entry != nil {
+		// Global options can still break the entry in ways that are hard
+		// to diagnose once the IDE fails to connect
+		checkSSHConflicts(*entry)
+	}
+
+	// Profile extras (env file, dotfiles, extensions)
+	if err := setupExtras(ctx, tool, opts); err != nil {
+		return nil, err
+	}
+
 	// Tool-specific setup
-	if err := tool.Setup(ctx, opts); err != nil {
-		return fmt.Errorf("failed tool setup: %w", err)
+	if err := traceStep(ctx, "tool.setup", func(ctx context.Context) error {
+		return tool.Setup(ctx, opts)
+	}); err != nil {
+		return nil, fmt.Errorf("failed tool setup: %w", err)
+	}
+
+	if err := runPostHooks(ctx, opts); err != nil {
+		return nil, err
 	}
 
+	recordSetup(inv, tool, opts, migrated)
+
 	// Print instructions
 	fmt.Println(tool.Instructions(opts))
 
+	return NewSummary(tool, opts), nil
+}
+
+// commitSSHConfig commits a transaction and reports what it changed
+func commitSSHConfig(txn *sshconfig.Transaction) error {
+	if txn.Empty() {
+		return nil
+	}
+	for _, w := range txn.Warnings() {
+		fmt.Printf("%s⚠%s %s\n", ColorYellow, ColorReset, w)
+	}
+	summary, err := txn.Commit()
+	if err != nil {
+		return err
+	}
+	if summary.Changed() {
+		fmt.Printf("%s✓%s Updated SSH config\n", ColorGreen, ColorReset)
+		for _, line := range strings.Split(strings.TrimSuffix(summary.String(), "\n"), "\n") {
+			fmt.Printf("    %s\n", line)
+		}
+	}
 	return nil
 }
 
 // wakeSprite sends a simple command to wake up a sprite from warm/sleep state
-// Returns the sprite instance for use in subsequent operations
-func wakeSprite(ctx context.Context, opts SetupOptions) (*sprites.Sprite, error) {
+// Returns the sprite instance for use in subsequent operations, and the
+// organization policy resolved with the credentials
+func wakeSprite(ctx context.Context, opts SetupOptions) (*sprites.Sprite, *config.Policy, error) {
 	// Resolve token from sprites config
 	tokenOpts := &sshserver.TokenOptions{
 		Organization: opts.OrgName,
 	}
-	if err := tokenOpts.Resolve(); err != nil {
-		return nil, fmt.Errorf("failed to resolve sprites credentials: %w\nRun 'sprite login' first", err)
+	if err := traceStep(ctx, "credentials.resolve", func(context.Context) error {
+		return tokenOpts.Resolve()
+	}); err != nil {
+		return nil, nil, fmt.Errorf("failed to resolve sprites credentials: %w\nRun 'sprite login' first", err)
 	}
 
 	// Create sprites client
 	client := sprites.New(tokenOpts.AuthToken, sprites.WithBaseURL(tokenOpts.API))
 
 	// Get the sprite
-	sprite, err := client.GetSprite(ctx, opts.SpriteName)
-	if err != nil {
-		return nil, fmt.Errorf("sprite not found: %s", opts.SpriteName)
+	var sprite *sprites.Sprite
+	if err := traceStep(ctx, "sprites.get_sprite", func(ctx context.Context) error {
+		var err error
+		sprite, err = client.GetSprite(ctx, opts.SpriteName)
+		return err
+	}); err != nil {
+		return nil, nil, fmt.Errorf("sprite not found: %s", opts.SpriteName)
 	}
 
 	// Run a simple command to wake it up
 	// Using 'true' as it's the simplest command that does nothing but succeed
-	wakeCtx, cancel := context.WithTimeout(ctx, 60*time.Second)
+	err := traceStep(ctx, "sprite.wake", func(ctx context.Context) error {
+		wakeCtx, cancel := context.WithTimeout(ctx, 60*time.Second)
+		defer cancel()
+
+		cmd := sprite.CommandContext(wakeCtx, "true")
+		cmd.Stdout = io.Discard
+		cmd.Stderr = io.Discard
+		return cmd.Run()
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to wake sprite: %w", err)
+	}
+
+	return sprite, tokenOpts.Policy, nil
+}
+
+// resolveOpenFile checks whether RemotePath is a regular file on the sprite.
+// If so, it becomes OpenFile and RemotePath becomes its directory.
+func resolveOpenFile(ctx context.Context, opts *SetupOptions) error {
+	if opts.Sprite == nil || opts.OpenFile != "" {
+		return nil
+	}
+
+	checkCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
-	cmd := sprite.CommandContext(wakeCtx, "true")
+	cmd := opts.Sprite.CommandContext(checkCtx, "test", "-f", opts.RemotePath)
 	cmd.Stdout = io.Discard
 	cmd.Stderr = io.Discard
 
+	var exit *sprites.ExitError
 	if err := cmd.Run(); err != nil {
-		return nil, fmt.Errorf("failed to wake sprite: %w", err)
+		// test exits 1 for anything but a regular file, including a
+		// directory or a path that doesn't exist yet
+		if errors.As(err, &exit) && exit.ExitCode() == 1 {
+			return nil
+		}
+		return err
 	}
 
-	return sprite, nil
+	opts.OpenFile = opts.RemotePath
+	opts.RemotePath = path.Dir(opts.RemotePath)
+	return nil
+}
+
+// traceStep runs fn inside a child span named after the bootstrap step
+func traceStep(ctx context.Context, name string, fn func(ctx context.Context) error) error {
+	ctx, span := telemetry.Start(ctx, name)
+	defer span.End()
+
+	err := fn(ctx)
+	span.RecordError(err)
+	return err
 }
 
 // NewSetupOptions creates SetupOptions from common parameters
@@ -181,46 +424,129 @@ func NewSetupOptions(spriteName, orgName string, localPort int, remotePath strin
 
 // ServePidFile returns the path to the serve PID file
 func ServePidFile() string {
-	return filepath.Join(config.StateDir(), "serve.pid")
+	return filepath.Join(config.RuntimeDir(), "serve.pid")
 }
 
-// isPortAvailable checks if a port is available for binding
-func isPortAvailable(port int) bool {
-	ln, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+// checkAddrAvailable binds addr, the exact address serve will listen on, and
+// returns the classified error if that fails
+func checkAddrAvailable(addr string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ln, err := sshserver.Bind(ctx, addr)
 	if err != nil {
-		return false
+		return err
 	}
 	ln.Close()
-	return true
+	return nil
+}
+
+// serveListenAddr returns the address serve will listen on for the setup
+func serveListenAddr(opts SetupOptions) (string, error) {
+	port := strconv.Itoa(opts.LocalPort)
+	if !opts.Tailscale {
+		return ":" + port, nil
+	}
+	ip, err := sshserver.TailscaleAddr()
+	if err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(ip.String(), port), nil
 }
 
-// StartServe starts the serve command in the background
+// ServeBinary is the binary run as "<binary> serve" to start the background
+// server. Empty means the running executable.
+var ServeBinary string
+
+// StartServe starts the serve command in the background. A non-empty
+// orgName limits it to that organization's sprites.
 func StartServe(port int, orgName string) error {
-	// Check if port is available
-	if !isPortAvailable(port) {
-		return fmt.Errorf("port %d is already in use by another service\nTry a different port with -p flag, e.g.: sprite-bootstrap zed -s mysprite -p 2223", port)
+	return startServe(SetupOptions{LocalPort: port, OrgName: orgName}, orgName)
+}
+
+// selfExecutable returns the path serve and stdio run from: ourselves,
+// unless an embedding program pointed us elsewhere
+func selfExecutable() (string, error) {
+	if ServeBinary != "" {
+		return ServeBinary, nil
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return "", fmt.Errorf("failed to get executable path: %w", err)
+	}
+	return executable, nil
+}
+
+// checkStdio rejects Stdio for tools that connect to serve's port rather
+// than through their SSH config entry
+func checkStdio(tool Tool, opts SetupOptions) error {
+	if _, ok := tool.(SSHConfigurer); opts.Stdio && !ok {
+		return fmt.Errorf("--stdio needs an SSH config entry, and %s connects to the SSH server's port instead", tool.Name())
+	}
+	return nil
+}
+
+// startServe starts serve in the background for the given setup. Unless
+// onlyOrg is set, the server serves every organization, and connections
+// pick theirs with the sprite@org username.
+func startServe(opts SetupOptions, onlyOrg string) error {
+	port := opts.LocalPort
+
+	// Check the address serve will use is free, so failures are explained
+	// here rather than buried in the serve log
+	addr, err := serveListenAddr(opts)
+	if err != nil {
+		return err
+	}
+	if err := checkAddrAvailable(addr); err != nil {
+		var be *sshserver.BindError
+		if errors.As(err, &be) && be.Kind == sshserver.BindAddressInUse && be.Owner == nil {
+			return fmt.Errorf("%w\nTry a different port with -p flag, e.g.: sprite-bootstrap zed -s mysprite -p %d", err, port+1)
+		}
+		return err
 	}
 
-	if err := config.EnsureStateDir(); err != nil {
+	if err := config.EnsureRuntimeDir(); err != nil {
 		return err
 	}
 
-	// Get the path to ourselves
-	executable, err := os.Executable()
+	executable, err := selfExecutable()
 	if err != nil {
-		return fmt.Errorf("failed to get executable path: %w", err)
+		return err
 	}
 
 	args := []string{"serve", "-l", fmt.Sprintf(":%d", port)}
-	if orgName != "" {
-		args = append(args, "-o", orgName)
+	if onlyOrg != "" {
+		args = append(args, "-o", onlyOrg)
+	}
+	if opts.Tailscale {
+		args = append(args, "--listen-tailscale")
+	}
+	if endpoint := telemetry.ConfiguredEndpoint(); endpoint != "" {
+		args = append(args, "--otel-endpoint", endpoint)
+	}
+	if path := config.DefaultServeConfigFile(); path != "" {
+		args = append(args, "--config", path)
 	}
+	// --log-max-size is left to its default or the config file
+	args = append(args, "--log-file", ServeRotatingLog(port))
 	cmd := exec.Command(executable, args...)
 	// Inherit stdin so sprites-go SDK can detect TTY for proper PTY handling
 	// Without this, Zed's terminal has input echo issues
 	cmd.Stdin = os.Stdin
+	// Pass the environment explicitly so serve reaches the API the same way
+	// we do, even where the launch context would drop it
+	cmd.Env = serveEnv()
 	setSysProcAttr(cmd)
 
+	logFile, err := os.OpenFile(ServeLogFile(), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open serve log: %w", err)
+	}
+	defer logFile.Close()
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+
 	if err := cmd.Start(); err != nil {
 		return fmt.Errorf("failed to start serve: %w", err)
 	}
@@ -232,17 +558,31 @@ func StartServe(port int, orgName string) error {
 		return fmt.Errorf("failed to save PID: %w", err)
 	}
 
+	meta := &ServeMetadata{
+		PID:         cmd.Process.Pid,
+		Port:        port,
+		StartedAt:   time.Now(),
+		Executable:  executable,
+		Args:        args,
+		LogFile:     ServeLogFile(),
+		RotatingLog: ServeRotatingLog(port),
+		Env:         EnvSnapshot(cmd.Env),
+	}
+	if err := saveServeMetadata(meta); err != nil {
+		fmt.Printf("%s⚠%s Failed to save serve metadata: %v\n", ColorYellow, ColorReset, err)
+	}
+
 	cmd.Process.Release()
 
 	// Wait for server to be ready (port to be bound)
 	for i := 0; i < 20; i++ {
 		time.Sleep(100 * time.Millisecond)
-		if !isPortAvailable(port) {
+		if checkAddrAvailable(addr) != nil {
 			return nil // Server is now listening
 		}
 	}
 
-	return fmt.Errorf("server started but failed to bind to port %d", port)
+	return fmt.Errorf("server started but failed to bind to port %d%s", port, serveLogExcerpt())
 }
 
 // StopServe stops the running serve process
@@ -318,18 +658,25 @@ func testSSHConnection(ctx context.Context, opts SetupOptions) error {
 		"-o", "StrictHostKeyChecking=accept-new",
 		"-o", "ConnectTimeout=30",
 		"-p", strconv.Itoa(opts.LocalPort),
-		fmt.Sprintf("%s@localhost", opts.SpriteName),
+		fmt.Sprintf("%s@%s", opts.SSHUser(), opts.ServeHost()),
 		"true",
 	}
+	if opts.Stdio {
+		sshArgs = append([]string{"-o", "ProxyCommand=" + opts.ProxyCommand()}, sshArgs...)
+	}
 
 	// Retry a few times in case the server is still spinning up
 	var lastErr error
 	for i := 0; i < 5; i++ {
+		var stderr bytes.Buffer
 		cmd := exec.CommandContext(ctx, "ssh", sshArgs...)
 		cmd.Stdout = nil
-		cmd.Stderr = nil
+		cmd.Stderr = &stderr
 		if err := cmd.Run(); err != nil {
 			lastErr = err
+			if msg := strings.TrimSpace(stderr.String()); msg != "" {
+				lastErr = fmt.Errorf("%w: %s", err, msg)
+			}
 			time.Sleep(500 * time.Millisecond)
 			continue
 		}
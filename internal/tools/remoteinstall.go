@@ -0,0 +1,75 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/superfly/sprites-go"
+)
+
+// remoteInstallAttempts is how many times an install on the sprite is tried
+const remoteInstallAttempts = 3
+
+// remoteInstallTimeout bounds each install attempt
+var remoteInstallTimeout = 120 * time.Second
+
+// remoteKillGrace is how long a timed out attempt has to exit once killed
+const remoteKillGrace = 10 * time.Second
+
+// errChecksumMismatch is the exit status of verify_sha256 in
+// remote_install.sh when a download doesn't match its pin
+const errChecksumMismatch = 3
+
+// runRemoteInstall runs a script that downloads and installs something on
+// the sprite using the helpers in remote_install.sh, which it can call
+// without sourcing. Failed attempts are retried: downloads resume where
+// they stopped and half-extracted files are cleaned up, so a retry picks up
+// from an interrupted one. A checksum mismatch or a sprite lock held by
+// another run is not retried.
+func runRemoteInstall(ctx context.Context, sprite *sprites.Sprite, script string, args ...string) error {
+	var err error
+	for attempt := 1; attempt <= remoteInstallAttempts; attempt++ {
+		if attempt > 1 {
+			fmt.Printf("%s⚠%s Install interrupted (%v), retrying (%d/%d)...\n", ColorYellow, ColorReset, err, attempt, remoteInstallAttempts)
+		}
+
+		err = runInstallAttempt(ctx, sprite, script, args...)
+		if isSpriteLocked(err) {
+			return spriteLockError(sprite, err)
+		}
+		var exit *sprites.ExitError
+		if err == nil || ctx.Err() != nil || (errors.As(err, &exit) && exit.ExitCode() == errChecksumMismatch) {
+			return err
+		}
+	}
+	return err
+}
+
+// runInstallAttempt runs script under the sprite lock, killing it on the
+// sprite when it takes longer than remoteInstallTimeout. An attempt that
+// was only abandoned would keep running there, holding the lock and
+// writing to the download the next attempt resumes.
+func runInstallAttempt(ctx context.Context, sprite *sprites.Sprite, script string, args ...string) error {
+	installCtx, cancel := context.WithTimeout(ctx, remoteInstallTimeout)
+	defer cancel()
+	// The command outlives the attempt long enough to be killed
+	cmdCtx, cancelCmd := context.WithTimeout(ctx, remoteInstallTimeout+remoteKillGrace)
+	defer cancelCmd()
+
+	lockedArgs := append([]string{"-c", lockedScript(installCtx, remoteInstallScript+"\n"+script), "bash"}, args...)
+	cmd := sprite.CommandContext(cmdCtx, "/bin/bash", lockedArgs...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	stop := context.AfterFunc(installCtx, func() { _ = cmd.Signal("KILL") })
+	err := cmd.Wait()
+	if !stop() && ctx.Err() == nil {
+		return fmt.Errorf("timed out after %s", remoteInstallTimeout)
+	}
+	return err
+}
@@ -0,0 +1,153 @@
+package tools
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/superfly/sprites-go"
+	"github.com/vaurdan/sprite-bootstrap/internal/fakeapi"
+)
+
+// testVSIX returns a VSIX holding package.json and payload, stored
+// uncompressed so a transfer can be cut at a known point
+func testVSIX(t *testing.T, payload []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, data := range map[string][]byte{
+		"extension/package.json": []byte(`{"name": "ext", "version": "1.2.3"}`),
+		"extension/payload.bin":  payload,
+	} {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// redirectCurl puts a curl on PATH that sends every https URL to server
+// instead
+func redirectCurl(t *testing.T, server string) {
+	t.Helper()
+	curl, err := exec.LookPath("curl")
+	if err != nil {
+		t.Skip("curl not installed")
+	}
+	bin := t.TempDir()
+	stub := `#!/bin/sh
+for arg do
+    shift
+    case $arg in
+    https://*) arg=` + posixQuote(server) + `/${arg#https://} ;;
+    esac
+    set -- "$@" "$arg"
+done
+exec ` + posixQuote(curl) + ` "$@"
+`
+	if err := os.WriteFile(filepath.Join(bin, "curl"), []byte(stub), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+// TestRemoteInstallResume cuts the first VSIX download off halfway and
+// checks the retry resumes it and installs the extension
+func TestRemoteInstallResume(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("install scripts run on Linux sprites")
+	}
+	for _, tool := range []string{"bash", "unzip", "sha256sum"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skip(tool + " not installed")
+		}
+	}
+
+	payload := make([]byte, 256<<10)
+	rand.Read(payload)
+	vsix := testVSIX(t, payload)
+	sum := sha256.Sum256(vsix)
+
+	var (
+		mu     sync.Mutex
+		ranges []string // Range header of each download
+	)
+	downloads := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/Microsoft.VisualStudio.Services.VSIXPackage") {
+			http.NotFound(w, r)
+			return
+		}
+		mu.Lock()
+		ranges = append(ranges, r.Header.Get("Range"))
+		first := len(ranges) == 1
+		mu.Unlock()
+
+		if first {
+			// Send half and stall, like a sprite losing its network
+			w.Header().Set("Content-Length", strconv.Itoa(len(vsix)))
+			w.Write(vsix[:len(vsix)/2])
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+			return
+		}
+		http.ServeContent(w, r, "ext.vsix", time.Time{}, bytes.NewReader(vsix))
+	}))
+	defer downloads.Close()
+	redirectCurl(t, downloads.URL)
+
+	root := t.TempDir()
+	api := httptest.NewServer(&fakeapi.Server{Root: root, Token: "test"})
+	defer api.Close()
+	sprite := sprites.New("test", sprites.WithBaseURL(api.URL)).Sprite("demo")
+
+	old := remoteInstallTimeout
+	remoteInstallTimeout = 3 * time.Second
+	defer func() { remoteInstallTimeout = old }()
+
+	err := runRemoteInstall(context.Background(), sprite, installExtensionScript, "pub", "ext", "1.2.3", hex.EncodeToString(sum[:]))
+	if err != nil {
+		t.Fatalf("install: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(ranges) != 2 || ranges[0] != "" || !strings.HasPrefix(ranges[1], "bytes=") || ranges[1] == "bytes=0-" {
+		t.Errorf("downloads asked for ranges %q, want the whole file and then the rest", ranges)
+	}
+
+	home := filepath.Join(root, "demo")
+	got, err := os.ReadFile(filepath.Join(home, ".vscode-server/extensions/pub.ext-1.2.3/payload.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Error("installed payload differs from the one served")
+	}
+	// Nothing is left of the interrupted attempt
+	for _, pattern := range []string{".cache/sprite-bootstrap/downloads/*", ".vscode-server/extensions/.*"} {
+		if left, _ := filepath.Glob(filepath.Join(home, pattern)); len(left) > 0 {
+			t.Errorf("left behind: %q", left)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/vaurdan/sprite-bootstrap/internal/jsonc"
+	"github.com/vaurdan/sprite-bootstrap/internal/ui"
+
+	"github.com/charmbracelet/huh"
+	"github.com/superfly/sprites-go"
+)
+
+// errSkippedBrokenSettings is returned when a settings file on the sprite
+// doesn't parse and is left alone
+var errSkippedBrokenSettings = errors.New("settings file is not valid JSON, left untouched")
+
+// readRemoteHomeFile reads a file on the sprite, given relative to the home
+// directory. A missing file reads as empty.
+func readRemoteHomeFile(ctx context.Context, sprite *sprites.Sprite, rel string) ([]byte, error) {
+	cmd := sprite.CommandContext(ctx, "/bin/sh", "-c", `f="$HOME/$1"; [ ! -e "$f" ] || cat "$f"`, "sh", rel)
+	return cmd.Output()
+}
+
+// loadRemoteSettings reads a JSONC settings file on the sprite, relative to
+// the home directory, into settings and returns it as plain JSON ("{}" when
+// missing or empty). A file that doesn't parse is reported with the error's
+// location. Interactively, the user may have it backed up and start afresh;
+// otherwise errSkippedBrokenSettings is returned.
+func loadRemoteSettings(ctx context.Context, sprite *sprites.Sprite, rel string, settings *map[string]any) ([]byte, error) {
+	data, err := readRemoteHomeFile(ctx, sprite, rel)
+	if err != nil {
+		return nil, fmt.Errorf("read ~/%s: %w", rel, err)
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return []byte("{}"), nil
+	}
+
+	parseErr := jsonc.Unmarshal(data, settings)
+	if parseErr == nil {
+		return jsonc.Standardize(data), nil
+	}
+
+	fmt.Printf("%s⚠%s ~/%s on the sprite is not valid JSON: %v\n", ColorYellow, ColorReset, rel, parseErr)
+	var syntaxErr *jsonc.SyntaxError
+	if errors.As(parseErr, &syntaxErr) && syntaxErr.Snippet != "" {
+		fmt.Println(indent(syntaxErr.Snippet, "    "))
+	}
+
+	backup := fmt.Sprintf("%s.broken-%s", rel, time.Now().Format("20060102-150405"))
+	if !ui.IsInteractive() || !confirmReplaceBroken(rel, backup) {
+		fmt.Printf("%s⚠%s Skipped: fix ~/%s on the sprite and run setup again\n", ColorYellow, ColorReset, rel)
+		return nil, errSkippedBrokenSettings
+	}
+
+	cmd := lockedCommand(ctx, sprite, `mv "$HOME/$1" "$HOME/$2"`, rel, backup)
+	if out, err := cmd.CombinedOutput(); isSpriteLocked(err) {
+		return nil, spriteLockError(sprite, err)
+	} else if err != nil {
+		return nil, fmt.Errorf("back up ~/%s: %w: %s", rel, err, strings.TrimSpace(string(out)))
+	}
+	fmt.Printf("%s✓%s Backed up to ~/%s\n", ColorGreen, ColorReset, backup)
+	*settings = nil
+	return []byte("{}"), nil
+}
+
+// confirmReplaceBroken asks whether to back up a broken settings file and
+// write a fresh one
+func confirmReplaceBroken(rel, backup string) bool {
+	var replace bool
+	form := huh.NewForm(
+		huh.NewGroup(
+			huh.NewConfirm().
+				Title(fmt.Sprintf("Back up ~/%s and write a fresh file?", rel)).
+				Description("The broken file is kept as ~/" + backup).
+				Value(&replace),
+		),
+	)
+	if err := form.Run(); err != nil {
+		return false
+	}
+	return replace
+}
+
+// indent prefixes every line of s
+func indent(s, prefix string) string {
+	return prefix + strings.ReplaceAll(s, "\n", "\n"+prefix)
+}
@@ -0,0 +1,44 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/superfly/sprites-go"
+)
+
+// runRemoteWrite runs a script that writes files on the sprite using the
+// helpers in write_if_changed.sh, which it can call without sourcing. It
+// reports whether the script changed anything; scripts end with
+// report_changes so re-running setup leaves unchanged files untouched. The
+// script holds the sprite lock.
+func runRemoteWrite(ctx context.Context, sprite *sprites.Sprite, script string, stdin io.Reader, args ...string) (bool, error) {
+	cmd := lockedCommand(ctx, sprite, writeIfChangedScript+"\n"+script, args...)
+	cmd.Stdin = stdin
+	out, err := cmd.CombinedOutput()
+	if isSpriteLocked(err) {
+		return false, spriteLockError(sprite, err)
+	}
+	if err != nil {
+		return false, fmt.Errorf("%w: %s", err, strings.TrimSpace(string(out)))
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	switch lines[len(lines)-1] {
+	case "written":
+		return true, nil
+	case "unchanged":
+		return false, nil
+	}
+	return false, fmt.Errorf("unexpected output %q", strings.TrimSpace(string(out)))
+}
+
+// changedWord describes the outcome of a remote write in progress output
+func changedWord(changed bool, done string) string {
+	if changed {
+		return done
+	}
+	return "unchanged"
+}
@@ -0,0 +1,130 @@
+package tools
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// runWriteIfChanged writes content to path with the write_if_changed helper
+// and returns the script's report
+func runWriteIfChanged(t *testing.T, path, mode, content string) string {
+	t.Helper()
+	script := writeIfChangedScript + "\nset -e\nwrite_if_changed \"$1\" $2\nreport_changes\n"
+	cmd := exec.Command("bash", "-c", script, "bash", path, mode)
+	cmd.Stdin = strings.NewReader(content)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("write_if_changed: %v: %s", err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func TestWriteIfChanged(t *testing.T) {
+	if _, err := exec.LookPath("bash"); err != nil {
+		t.Skip("bash not installed")
+	}
+
+	tests := []struct {
+		name     string
+		existing string      // Content already there; "" for none
+		oldMode  os.FileMode // Mode of the existing file
+		symlink  bool        // Path is a symlink to the file
+		mode     string
+		content  string
+		want     string
+		wantMode os.FileMode
+	}{
+		{"new file", "", 0, false, "", "a\n", "written", 0644},
+		{"new file with mode", "", 0, false, "600", "a\n", "written", 0600},
+		{"same content", "a\n", 0640, false, "", "a\n", "unchanged", 0640},
+		{"new content keeps mode", "a\n", 0640, false, "", "b\n", "written", 0640},
+		{"same content, loose mode", "a\n", 0644, false, "600", "a\n", "written", 0600},
+		{"new content, loose mode", "a\n", 0644, false, "600", "b\n", "written", 0600},
+		{"same content, right mode", "a\n", 0600, false, "600", "a\n", "unchanged", 0600},
+		{"symlink", "a\n", 0600, true, "600", "b\n", "written", 0600},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		file := filepath.Join(dir, "file")
+		path := file
+		if tt.existing != "" {
+			if err := os.WriteFile(file, []byte(tt.existing), tt.oldMode); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chmod(file, tt.oldMode); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if tt.symlink {
+			path = filepath.Join(dir, "link")
+			if err := os.Symlink(file, path); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if got := runWriteIfChanged(t, path, tt.mode, tt.content); got != tt.want {
+			t.Errorf("%s: reported %q, want %q", tt.name, got, tt.want)
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != tt.content {
+			t.Errorf("%s: content = %q, want %q", tt.name, data, tt.content)
+		}
+		info, err := os.Stat(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != tt.wantMode {
+			t.Errorf("%s: mode = %o, want %o", tt.name, info.Mode().Perm(), tt.wantMode)
+		}
+		if tt.symlink {
+			if info, err := os.Lstat(path); err != nil || info.Mode()&os.ModeSymlink == 0 {
+				t.Errorf("%s: symlink replaced (%v)", tt.name, err)
+			}
+		}
+		wantEntries := 1
+		if tt.symlink {
+			wantEntries = 2
+		}
+		if entries, _ := os.ReadDir(dir); len(entries) != wantEntries {
+			t.Errorf("%s: left %d entries in the directory, want %d", tt.name, len(entries), wantEntries)
+		}
+	}
+}
+
+// TestWriteIfChangedSecondRun checks that running the same write again
+// leaves the file alone
+func TestWriteIfChangedSecondRun(t *testing.T) {
+	if _, err := exec.LookPath("bash"); err != nil {
+		t.Skip("bash not installed")
+	}
+	path := filepath.Join(t.TempDir(), "settings.json")
+	if got := runWriteIfChanged(t, path, "600", "{}\n"); got != "written" {
+		t.Fatalf("first run reported %q, want written", got)
+	}
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(path, old, old); err != nil {
+		t.Fatal(err)
+	}
+	before, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := runWriteIfChanged(t, path, "600", "{}\n"); got != "unchanged" {
+		t.Errorf("second run reported %q, want unchanged", got)
+	}
+	after, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !after.ModTime().Equal(before.ModTime()) || !os.SameFile(before, after) {
+		t.Errorf("second run rewrote the file")
+	}
+}
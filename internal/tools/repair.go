@@ -0,0 +1,285 @@
+package tools
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/superfly/sprites-go"
+)
+
+// ArtifactStatus is the result of checking one artifact
+type ArtifactStatus struct {
+	Tool     string
+	Artifact Artifact
+	Present  bool
+	Problem  string // Empty when the artifact is as expected
+}
+
+// OK reports whether the artifact is as expected
+func (s ArtifactStatus) OK() bool {
+	return s.Problem == ""
+}
+
+// VerifyTool checks every artifact the tool declares
+func VerifyTool(ctx context.Context, tool Tool, opts SetupOptions) ([]ArtifactStatus, error) {
+	verifier, ok := tool.(Verifier)
+	if !ok {
+		return nil, fmt.Errorf("%s does not describe its setup state", tool.Name())
+	}
+
+	var statuses []ArtifactStatus
+	for _, artifact := range verifier.Artifacts(opts) {
+		status := ArtifactStatus{Tool: tool.Name(), Artifact: artifact}
+		var err error
+		if artifact.Local {
+			err = checkLocalArtifact(&status)
+		} else {
+			err = checkRemoteArtifact(ctx, opts.Sprite, &status)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to check %s: %w", artifact.Path, err)
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// checkLocalArtifact checks an artifact on the local machine
+func checkLocalArtifact(status *ArtifactStatus) error {
+	a := status.Artifact
+	info, err := os.Stat(ExpandHome(a.Path))
+	if os.IsNotExist(err) {
+		if !a.Optional {
+			status.Problem = "missing"
+		}
+		return nil
+	} else if err != nil {
+		return err
+	}
+	status.Present = true
+
+	if a.Dir != info.IsDir() {
+		status.Problem = wrongKind(a.Dir)
+		return nil
+	}
+	if a.Dir || (a.Contains == "" && a.SHA256 == "") {
+		return nil
+	}
+
+	data, err := os.ReadFile(ExpandHome(a.Path))
+	if err != nil {
+		return err
+	}
+	if a.Contains != "" && !strings.Contains(string(data), a.Contains) {
+		status.Problem = "content is missing expected settings"
+	} else if a.SHA256 != "" {
+		sum := sha256.Sum256(data)
+		if hex.EncodeToString(sum[:]) != a.SHA256 {
+			status.Problem = "content differs from what setup writes"
+		}
+	}
+	return nil
+}
+
+// checkRemoteArtifact checks an artifact on the sprite
+func checkRemoteArtifact(ctx context.Context, sprite *sprites.Sprite, status *ArtifactStatus) error {
+	a := status.Artifact
+
+	checkCtx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+
+	wantHash := ""
+	if a.SHA256 != "" {
+		wantHash = "1"
+	}
+	cmd := sprite.CommandContext(checkCtx, "/bin/bash", "-c", checkArtifactScript, "bash", a.Path, a.Contains, wantHash)
+	out, err := cmd.Output()
+	if err != nil {
+		return err
+	}
+
+	fields := strings.Fields(string(out))
+	if len(fields) == 1 && fields[0] == "missing" {
+		if !a.Optional {
+			status.Problem = "missing"
+		}
+		return nil
+	}
+	if len(fields) != 6 || fields[0] != "present" {
+		return fmt.Errorf("unexpected check output %q", strings.TrimSpace(string(out)))
+	}
+	status.Present = true
+
+	owner, user, kind, hash, contains := fields[1], fields[2], fields[3], fields[4], fields[5]
+	switch {
+	case owner != user:
+		status.Problem = fmt.Sprintf("owned by %s, expected %s", owner, user)
+	case a.Dir && kind != "dir", !a.Dir && kind == "dir":
+		status.Problem = wrongKind(a.Dir)
+	case contains == "no":
+		status.Problem = "content is missing expected settings"
+	case a.SHA256 != "" && hash != a.SHA256:
+		status.Problem = "content differs from what setup writes"
+	}
+	return nil
+}
+
+func wrongKind(wantDir bool) string {
+	if wantDir {
+		return "expected a directory"
+	}
+	return "expected a file"
+}
+
+// fixOwnership returns a Fix that hands path back to the sprite user. This
+// needs passwordless sudo, since the path is owned by someone else.
+func fixOwnership(path string) func(ctx context.Context, opts SetupOptions) error {
+	return func(ctx context.Context, opts SetupOptions) error {
+		fixCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		defer cancel()
+
+		script := `p="$1"; case "$p" in "~/"*) p="$HOME/${p#\~/}" ;; esac; sudo -n chown -R "$(id -un):$(id -gn)" "$p"`
+		cmd := lockedCommand(fixCtx, opts.Sprite, script, path)
+		if out, err := cmd.CombinedOutput(); isSpriteLocked(err) {
+			return spriteLockError(opts.Sprite, err)
+		} else if err != nil {
+			return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(out)))
+		}
+		return nil
+	}
+}
+
+// Repair checks the setup state of a sprite and, with fix, re-runs the setup
+// steps whose artifacts are missing or wrong. With no tool name, every tool
+// that has at least one artifact present on the sprite is checked.
+func Repair(ctx context.Context, opts SetupOptions, toolName string, fix bool) error {
+	var candidates []Tool
+	if toolName != "" {
+		tool, ok := Get(toolName)
+		if !ok {
+			return fmt.Errorf("unknown tool: %s", toolName)
+		}
+		if _, ok := tool.(Verifier); !ok {
+			return fmt.Errorf("%s does not support repair", toolName)
+		}
+		candidates = append(candidates, tool)
+	} else {
+		names := Names()
+		sort.Strings(names)
+		for _, name := range names {
+			if tool := registry[name]; tool != nil {
+				if _, ok := tool.(Verifier); ok {
+					candidates = append(candidates, tool)
+				}
+			}
+		}
+	}
+
+	fmt.Printf("%s⏳%s Waking sprite %s%s%s...\n", ColorYellow, ColorReset, ColorCyan, opts.SpriteName, ColorReset)
+	sprite, policy, err := wakeSprite(ctx, opts)
+	if err != nil {
+		return fmt.Errorf("failed to wake sprite: %w", err)
+	}
+	opts.Sprite, opts.Policy = sprite, policy
+
+	problems := 0
+	checked := 0
+	for _, tool := range candidates {
+		statuses, err := VerifyTool(ctx, tool, opts)
+		if err != nil {
+			return err
+		}
+		if toolName == "" && !anyPresent(statuses) {
+			continue // Tool was never set up on this sprite
+		}
+		checked++
+
+		fmt.Printf("\n%s%s%s\n", ColorBold, tool.Name(), ColorReset)
+		var broken []ArtifactStatus
+		for _, st := range statuses {
+			if st.OK() {
+				if st.Present {
+					fmt.Printf("  %s✓%s %s\n", ColorGreen, ColorReset, st.Artifact.Name)
+				}
+				continue
+			}
+			fmt.Printf("  %s✗%s %s: %s (%s)\n", ColorYellow, ColorReset, st.Artifact.Name, st.Problem, st.Artifact.Path)
+			broken = append(broken, st)
+		}
+		problems += len(broken)
+
+		if fix {
+			problems -= applyFixes(ctx, opts, broken)
+		}
+	}
+
+	fmt.Println()
+	switch {
+	case checked == 0:
+		fmt.Printf("%s⚠%s No tool setup found on %s\n", ColorYellow, ColorReset, opts.SpriteName)
+	case problems == 0:
+		fmt.Printf("%s✓%s No problems found\n", ColorGreen, ColorReset)
+	case !fix:
+		fmt.Printf("%s⚠%s %d problem(s) found. Run again with --fix to repair.\n", ColorYellow, ColorReset, problems)
+	}
+	if problems > 0 {
+		return fmt.Errorf("%d problem(s) remain", problems)
+	}
+	return nil
+}
+
+// applyFixes runs the fix for each broken step once, returning how many
+// artifacts were repaired
+func applyFixes(ctx context.Context, opts SetupOptions, broken []ArtifactStatus) int {
+	fixed := 0
+	done := make(map[string]error)
+	for _, st := range broken {
+		a := st.Artifact
+		if a.Fix == nil {
+			if a.Hint != "" {
+				fmt.Printf("    Fix manually: %s\n", a.Hint)
+			}
+			continue
+		}
+
+		step := a.Step
+		if step == "" {
+			step = a.Path
+		}
+		err, ran := done[step]
+		if !ran {
+			fmt.Printf("%s⏳%s Repairing %s...\n", ColorYellow, ColorReset, a.Name)
+			err = traceStep(ctx, "repair."+step, func(ctx context.Context) error {
+				return a.Fix(ctx, opts)
+			})
+			done[step] = err
+		}
+		if err != nil {
+			fmt.Printf("%s⚠%s Failed to repair %s: %v\n", ColorYellow, ColorReset, a.Name, err)
+			if a.Hint != "" {
+				fmt.Printf("    Fix manually: %s\n", a.Hint)
+			}
+			continue
+		}
+		if !ran {
+			fmt.Printf("%s✓%s Repaired %s\n", ColorGreen, ColorReset, a.Name)
+		}
+		fixed++
+	}
+	return fixed
+}
+
+func anyPresent(statuses []ArtifactStatus) bool {
+	for _, st := range statuses {
+		if st.Present && !st.Artifact.Local {
+			return true
+		}
+	}
+	return false
+}
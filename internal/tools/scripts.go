@@ -0,0 +1,40 @@
+package tools
+
+import _ "embed"
+
+// Remote scripts run on the sprite with bash -c. They live in scripts/ so
+// they can be shellchecked.
+var (
+	//go:embed scripts/check_artifact.sh
+	checkArtifactScript string
+
+	//go:embed scripts/claude_settings.sh
+	claudeSettingsScript string
+
+	//go:embed scripts/cleanup_vscode_state.sh
+	cleanupVSCodeStateScript string
+
+	//go:embed scripts/dotfiles.sh
+	dotfilesScript string
+
+	//go:embed scripts/env_file.sh
+	envFileScript string
+
+	//go:embed scripts/fix_claude_paths.sh
+	fixClaudePathsScript string
+
+	//go:embed scripts/git_signing.sh
+	gitSigningScript string
+
+	//go:embed scripts/install_extension.sh
+	installExtensionScript string
+
+	//go:embed scripts/remote_install.sh
+	remoteInstallScript string
+
+	//go:embed scripts/sprite_lock.sh
+	spriteLockScript string
+
+	//go:embed scripts/write_if_changed.sh
+	writeIfChangedScript string
+)
@@ -0,0 +1,17 @@
+#!/bin/bash
+p="$1"
+case "$p" in "~/"*) p="$HOME/${p#\~/}" ;; esac
+if [ ! -e "$p" ] && [ ! -L "$p" ]; then
+    echo missing
+    exit 0
+fi
+owner=$(stat -c %U "$p")
+kind=file
+if [ -L "$p" ]; then kind=link; elif [ -d "$p" ]; then kind=dir; fi
+hash=-
+if [ "$kind" = file ] && [ -n "$3" ]; then hash=$(sha256sum "$p" | cut -d' ' -f1); fi
+contains=-
+if [ "$kind" = file ] && [ -n "$2" ]; then
+    if grep -qF -- "$2" "$p"; then contains=yes; else contains=no; fi
+fi
+echo "present $owner $(id -un) $kind $hash $contains"
@@ -0,0 +1,13 @@
+#!/bin/bash
+# Merge the Claude Code permission settings into the VS Code server's
+# Machine settings. Needs write_if_changed.sh. The current settings come on
+# stdin as plain JSON, already checked by setup, which strips comments.
+set -e
+SETTINGS_FILE="$HOME/.vscode-server/data/Machine/settings.json"
+MERGE='. + {"claudeCode.allowDangerouslySkipPermissions": true, "claudeCode.initialPermissionMode": "bypassPermissions"}'
+
+# jq is always available on sprites. The merge is done before anything is
+# written, so nothing is written if it fails.
+NEW=$(jq "$MERGE")
+write_if_changed "$SETTINGS_FILE" <<< "$NEW"
+report_changes
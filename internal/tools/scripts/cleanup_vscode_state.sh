@@ -0,0 +1,25 @@
+#!/bin/bash
+set -e
+WORKSPACE_DIR="$HOME/.vscode-server/data/User/workspaceStorage"
+
+if [ ! -d "$WORKSPACE_DIR" ]; then
+    exit 0
+fi
+
+# Remove all workspace lock files - VS Code will recreate them
+find "$WORKSPACE_DIR" -name "*.lock" -type f -delete 2>/dev/null || true
+
+# Find and remove duplicate workspace folders (those with -N suffix)
+# This keeps the original folder which has the extension state
+for dir in "$WORKSPACE_DIR"/*-[0-9]; do
+    if [ -d "$dir" ]; then
+        rm -rf "$dir"
+    fi
+done
+for dir in "$WORKSPACE_DIR"/*-[0-9][0-9]; do
+    if [ -d "$dir" ]; then
+        rm -rf "$dir"
+    fi
+done
+
+echo "cleanup complete"
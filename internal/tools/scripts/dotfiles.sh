@@ -0,0 +1,21 @@
+#!/bin/bash
+set -e
+DIR="$HOME/.dotfiles"
+if [ -d "$DIR/.git" ]; then
+    git -C "$DIR" fetch --quiet origin
+    if [ -n "$2" ]; then
+        git -C "$DIR" checkout --quiet "$2"
+    fi
+    git -C "$DIR" pull --quiet --ff-only || true
+elif [ -n "$2" ]; then
+    git clone --quiet --branch "$2" "$1" "$DIR"
+else
+    git clone --quiet "$1" "$DIR"
+fi
+
+for script in install.sh bootstrap.sh setup.sh; do
+    if [ -x "$DIR/$script" ]; then
+        cd "$DIR" && "./$script"
+        break
+    fi
+done
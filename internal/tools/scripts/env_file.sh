@@ -0,0 +1,11 @@
+#!/bin/bash
+# Install the env file read from stdin at $HOME/$1 and source it from
+# ~/.profile so login shells pick it up. Needs write_if_changed.sh.
+set -e
+ENV_FILE="$HOME/$1"
+write_if_changed "$ENV_FILE" 600
+
+# shellcheck disable=SC2016 # expanded when ~/.profile is sourced
+LINE='[ -f "$HOME/'"$1"'" ] && { set -a; . "$HOME/'"$1"'"; set +a; }'
+append_line_once "$HOME/.profile" "$LINE"
+report_changes
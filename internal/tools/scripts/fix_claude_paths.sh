@@ -0,0 +1,27 @@
+#!/bin/bash
+set -e
+PROJECTS_DIR="$HOME/.claude/projects"
+
+if [ ! -d "$PROJECTS_DIR" ]; then
+    exit 0
+fi
+
+# Find all directories that don't end with a dash
+for dir in "$PROJECTS_DIR"/*; do
+    if [ -d "$dir" ] && [ ! -L "$dir" ]; then
+        # Check if it doesn't already end with a dash
+        case "$dir" in
+            *-)
+                # Already ends with dash, skip
+                ;;
+            *)
+                # Create symlink with trailing dash if it doesn't exist
+                if [ ! -e "${dir}-" ]; then
+                    ln -s "$dir" "${dir}-"
+                fi
+                ;;
+        esac
+    fi
+done
+
+echo "done"
@@ -0,0 +1,28 @@
+#!/bin/bash
+# Configure ssh-format commit signing with public key $1, whose private half
+# is reached through the forwarded SSH agent. $2 and $3 are the local
+# user.email and user.name, used when the sprite has no identity yet. Needs
+# write_if_changed.sh.
+set -e
+PUBKEY="$1"
+EMAIL="$2"
+NAME="$3"
+
+mkdir -p "$HOME/.ssh"
+chmod 700 "$HOME/.ssh"
+SIGNERS="$HOME/.ssh/allowed_signers"
+if [ ! -f "$SIGNERS" ] || ! grep -qF "$PUBKEY" "$SIGNERS"; then
+    append_line_once "$SIGNERS" "${EMAIL:-*} $PUBKEY"
+fi
+
+if [ -n "$EMAIL" ] && ! git config --global user.email >/dev/null; then
+    git_config_once user.email "$EMAIL"
+fi
+if [ -n "$NAME" ] && ! git config --global user.name >/dev/null; then
+    git_config_once user.name "$NAME"
+fi
+git_config_once gpg.format ssh
+git_config_once user.signingkey "key::$PUBKEY"
+git_config_once gpg.ssh.allowedSignersFile "$SIGNERS"
+git_config_once commit.gpgsign true
+report_changes
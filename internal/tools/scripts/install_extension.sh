@@ -0,0 +1,73 @@
+#!/bin/bash
+# Usage: install_extension.sh <publisher> <extension> [version] [sha256]
+# With a version, that exact version is installed. With a sha256, the
+# downloaded VSIX must match it before anything is extracted. Needs
+# remote_install.sh.
+set -e
+PUBLISHER="$1"
+EXTENSION="$2"
+VERSION="${3:-}"
+EXPECTED_SHA256="${4:-}"
+EXT_DIR="$HOME/.vscode-server/extensions"
+
+# Create extensions directory if needed
+mkdir -p "$EXT_DIR"
+clean_stale_partials
+
+# Get latest version from marketplace API unless pinned
+if [ -z "$VERSION" ]; then
+    VERSION=$(curl -sf "https://marketplace.visualstudio.com/items?itemName=${PUBLISHER}.${EXTENSION}" | grep -oP '"version"\s*:\s*"\K[^"]+' | head -1)
+    if [ -z "$VERSION" ]; then
+        # Fallback: try to get from Open VSX
+        VERSION=$(curl -sf "https://open-vsx.org/api/${PUBLISHER}/${EXTENSION}" | grep -oP '"version"\s*:\s*"\K[^"]+' | head -1)
+    fi
+fi
+if [ -z "$VERSION" ]; then
+    echo "Could not determine extension version"
+    exit 1
+fi
+
+# Validate version format (semver-like: digits and dots only)
+if ! echo "$VERSION" | grep -qE '^[0-9]+\.[0-9]+\.[0-9]+(-[a-zA-Z0-9.]+)?$'; then
+    echo "Invalid version format: $VERSION"
+    exit 1
+fi
+
+echo "Installing ${PUBLISHER}.${EXTENSION} version ${VERSION}..."
+
+# Check if already installed; a directory without package.json was left by
+# an interrupted install and is removed
+DEST="$EXT_DIR/${PUBLISHER}.${EXTENSION}-${VERSION}"
+if installed_or_clean "$DEST" package.json; then
+    echo "Already installed"
+    exit 0
+fi
+
+# Download VSIX from marketplace, resuming an interrupted download. Each
+# source gets its own file name so partial downloads are never mixed.
+NAME="${PUBLISHER}.${EXTENSION}-${VERSION}"
+VSIX_URL="https://${PUBLISHER}.gallery.vsassets.io/_apis/public/gallery/publisher/${PUBLISHER}/extension/${EXTENSION}/${VERSION}/assetbyname/Microsoft.VisualStudio.Services.VSIXPackage"
+
+echo "Downloading from marketplace..."
+VSIX="$DOWNLOAD_DIR/$NAME.marketplace.vsix"
+if ! resumable_download "$VSIX_URL" "$NAME.marketplace.vsix"; then
+    # Fallback to Open VSX
+    echo "Trying Open VSX..."
+    VSIX="$DOWNLOAD_DIR/$NAME.openvsx.vsix"
+    VSIX_URL="https://open-vsx.org/api/${PUBLISHER}/${EXTENSION}/${VERSION}/file/${PUBLISHER}.${EXTENSION}-${VERSION}.vsix"
+    resumable_download "$VSIX_URL" "$NAME.openvsx.vsix"
+fi
+
+# Verify the download before extracting anything from it
+ACTUAL_SHA256=$(verify_sha256 "$VSIX" "$EXPECTED_SHA256")
+if [ -n "$EXPECTED_SHA256" ]; then
+    echo "Checksum verified"
+else
+    echo "Unpinned download, sha256 ${ACTUAL_SHA256}"
+fi
+
+# Extract the extension from the VSIX (it's a zip file) into place
+extract_zip "$VSIX" extension "$DEST"
+rm -f "$VSIX"
+
+echo "Installed successfully"
@@ -0,0 +1,70 @@
+#!/bin/bash
+# Helpers prepended to scripts that download and install things on the
+# sprite. Downloads resume after an interruption and nothing is moved into
+# place until it is complete, so a failed attempt never breaks the next one.
+
+DOWNLOAD_DIR="$HOME/.cache/sprite-bootstrap/downloads"
+
+# clean_stale_partials removes partial downloads older than a day
+clean_stale_partials() {
+    mkdir -p "$DOWNLOAD_DIR"
+    find "$DOWNLOAD_DIR" -name '*.partial' -mtime +0 -delete 2>/dev/null || true
+}
+
+# resumable_download URL NAME: download URL to $DOWNLOAD_DIR/NAME, resuming
+# an earlier partial download of the same name. A complete download left by
+# an attempt that failed later is reused.
+resumable_download() {
+    local partial="$DOWNLOAD_DIR/$2.partial"
+    [ -f "$DOWNLOAD_DIR/$2" ] && return 0
+    mkdir -p "$DOWNLOAD_DIR"
+    if ! curl -sfL --retry 3 --retry-delay 2 -C - -o "$partial" "$1"; then
+        # The server may not support resuming, or the file changed since
+        # the partial download; start over once
+        rm -f "$partial"
+        if ! curl -sfL --retry 3 --retry-delay 2 -o "$partial" "$1"; then
+            rm -f "$partial"
+            return 1
+        fi
+    fi
+    mv "$partial" "$DOWNLOAD_DIR/$2"
+}
+
+# verify_sha256 FILE EXPECTED: check FILE against EXPECTED (if set), removing
+# it on a mismatch so the next attempt downloads it again. Prints the actual
+# digest.
+verify_sha256() {
+    local actual
+    actual=$(sha256sum "$1" | cut -d' ' -f1)
+    if [ -n "$2" ] && [ "$actual" != "$2" ]; then
+        rm -f "$1"
+        echo "Checksum mismatch for $(basename "$1"): expected $2, got $actual" >&2
+        return 3
+    fi
+    echo "$actual"
+}
+
+# installed_or_clean DIR MARKER: succeed if DIR holds a complete install
+# (MARKER exists in it); a DIR without MARKER is a leftover from an
+# interrupted install and is removed
+installed_or_clean() {
+    [ -e "$1/$2" ] && return 0
+    rm -rf "$1"
+    return 1
+}
+
+# extract_zip ZIP SUBDIR DEST: extract SUBDIR of ZIP as DEST. It is unpacked
+# into a hidden staging directory next to DEST and renamed into place, so
+# DEST never exists half-extracted.
+extract_zip() {
+    local staging
+    staging="$(dirname "$3")/.$(basename "$3").extracting"
+    rm -rf "$staging"
+    mkdir -p "$staging"
+    if ! unzip -q "$1" "$2/*" -d "$staging"; then
+        rm -rf "$staging"
+        return 1
+    fi
+    mv "$staging/$2" "$3"
+    rm -rf "$staging"
+}
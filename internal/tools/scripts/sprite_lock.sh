@@ -0,0 +1,63 @@
+#!/bin/bash
+# Prepended to scripts that modify the sprite, so concurrent sprite-bootstrap
+# runs (a setup racing a cleanup from another terminal, say) take turns.
+# The lock is a directory, since mkdir is atomic; it records the bash process
+# holding it, which sprite exec sessions can all see, and who started it.
+
+SPRITE_LOCK="$HOME/.sprite-bootstrap.lock"
+
+# sprite_lock_id DIR: print the pid and start time of the lock in DIR. One
+# without a pid file yet goes by the directory's age.
+sprite_lock_id() {
+    local pid= started=
+    read -r pid started 2>/dev/null < "$1/pid"
+    [ -n "$started" ] || started=$(stat -c %Y "$1" 2>/dev/null)
+    printf '%s %s\n' "$pid" "$started"
+}
+
+# sprite_lock_remove ID: remove the lock if it is still the one with ID.
+# Removals take turns under a second lock, so a run that found a lock stale
+# can't remove the one another run took after breaking it first.
+sprite_lock_remove() {
+    local now
+    until mkdir "$SPRITE_LOCK.break" 2>/dev/null; do
+        # One left by a run killed while removing is cleared after 5s
+        now=$(date +%s)
+        if [ $((now - $(stat -c %Y "$SPRITE_LOCK.break" 2>/dev/null || echo "$now"))) -gt 5 ]; then
+            rmdir "$SPRITE_LOCK.break" 2>/dev/null
+        fi
+        sleep 0.1
+    done
+    [ "$(sprite_lock_id "$SPRITE_LOCK")" != "$1" ] || rm -rf "$SPRITE_LOCK"
+    rmdir "$SPRITE_LOCK.break"
+}
+
+# sprite_lock OWNER WAIT STALE: take the lock, waiting up to WAIT seconds.
+# A lock whose process is gone, or older than STALE seconds, is broken. On
+# timeout the holder is printed to stderr and the script exits 75.
+sprite_lock() {
+    local polls=0 id pid started now
+    while ! mkdir "$SPRITE_LOCK" 2>/dev/null; do
+        id=$(sprite_lock_id "$SPRITE_LOCK")
+        pid=${id% *} started=${id#* }
+        now=$(date +%s)
+        if { [ -n "$pid" ] && ! kill -0 "$pid" 2>/dev/null; } ||
+            [ $((now - ${started:-$now})) -gt "$3" ]; then
+            sprite_lock_remove "$id"
+            continue
+        fi
+        # Polled five times a second
+        if [ "$polls" -ge $(($2 * 5)) ]; then
+            echo "sprite locked by $(cat "$SPRITE_LOCK/owner" 2>/dev/null)" >&2
+            exit 75
+        fi
+        sleep 0.2
+        polls=$((polls + 1))
+    done
+    started=$(date +%s)
+    printf '%s\n' "$1" > "$SPRITE_LOCK/owner"
+    printf '%s %s\n' "$$" "$started" > "$SPRITE_LOCK/pid"
+    SPRITE_LOCK_ID="$$ $started"
+    # A lock broken as stale and taken by another run stays theirs
+    trap 'sprite_lock_remove "$SPRITE_LOCK_ID"' EXIT
+}
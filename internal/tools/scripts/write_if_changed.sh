@@ -0,0 +1,58 @@
+#!/bin/bash
+# Helpers prepended to scripts that write files on the sprite, so re-running
+# setup leaves files that already have the right content untouched.
+
+CHANGED=
+
+# write_if_changed PATH [MODE]: replace PATH with stdin unless it already has
+# that content. With MODE the file always ends up with it, even when the
+# content is unchanged; without, new files get 644 and existing ones keep
+# theirs. A symlink at PATH is kept and the file it points to is written.
+write_if_changed() {
+    local target tmp
+    target=$1
+    if [ -L "$target" ]; then
+        target=$(readlink -f "$target")
+    fi
+    mkdir -p "$(dirname "$target")"
+    tmp=$(mktemp "$target.XXXXXX")
+    cat > "$tmp"
+    if [ -f "$target" ] && [ "$(sha256sum < "$tmp")" = "$(sha256sum < "$target")" ]; then
+        rm -f "$tmp"
+        if [ -n "$2" ] && [ "$(stat -c %a "$target")" != "${2#0}" ]; then
+            chmod "$2" "$target"
+            CHANGED=1
+        fi
+        return 0
+    fi
+    if [ -n "$2" ]; then
+        chmod "$2" "$tmp"
+    elif [ -f "$target" ]; then
+        chmod --reference="$target" "$tmp"
+    else
+        chmod 644 "$tmp"
+    fi
+    mv "$tmp" "$target"
+    CHANGED=1
+}
+
+# append_line_once PATH LINE: append LINE to PATH unless it's already there
+append_line_once() {
+    touch "$1"
+    grep -qxF -- "$2" "$1" && return 0
+    printf '%s\n' "$2" >> "$1"
+    CHANGED=1
+}
+
+# git_config_once KEY VALUE: set a global git config value unless it's set
+git_config_once() {
+    [ "$(git config --global --get "$1")" = "$2" ] && return 0
+    git config --global "$1" "$2"
+    CHANGED=1
+}
+
+# report_changes prints whether anything was written, as the script's last
+# line of output
+report_changes() {
+    if [ -n "$CHANGED" ]; then echo written; else echo unchanged; fi
+}
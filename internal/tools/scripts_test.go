@@ -0,0 +1,117 @@
+package tools
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// runSh runs script under sh with HOME set to home and returns its output
+func runSh(t *testing.T, home, script string) (string, error) {
+	t.Helper()
+	cmd := exec.Command("sh", "-c", script)
+	cmd.Env = append(os.Environ(), "HOME="+home)
+	out, err := cmd.CombinedOutput()
+	return strings.TrimSpace(string(out)), err
+}
+
+// TestScriptsShellcheck runs shellcheck over the remote scripts, when it is
+// installed
+func TestScriptsShellcheck(t *testing.T) {
+	shellcheck, err := exec.LookPath("shellcheck")
+	if err != nil {
+		t.Skip("shellcheck not installed")
+	}
+	scripts, err := filepath.Glob(filepath.Join("scripts", "*.sh"))
+	if err != nil || len(scripts) == 0 {
+		t.Fatalf("no scripts found: %v", err)
+	}
+	args := append([]string{"--shell=bash", "--severity=error"}, scripts...)
+	if out, err := exec.Command(shellcheck, args...).CombinedOutput(); err != nil {
+		t.Errorf("shellcheck: %v\n%s", err, out)
+	}
+}
+
+// TestScriptsUnderSh runs the helpers the remote scripts are built on under
+// sh rather than bash, which keeps them to POSIX shell plus local
+func TestScriptsUnderSh(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not installed")
+	}
+
+	t.Run("write_if_changed", func(t *testing.T) {
+		home := t.TempDir()
+		path := filepath.Join(home, "config", "settings.json")
+		script := writeIfChangedScript + `
+set -e
+write_if_changed "$HOME/config/settings.json" 600 <<'EOF'
+{}
+EOF
+report_changes
+`
+		for i, want := range []string{"written", "unchanged"} {
+			out, err := runSh(t, home, script)
+			if err != nil {
+				t.Fatalf("run %d: %v: %s", i+1, err, out)
+			}
+			if out != want {
+				t.Errorf("run %d reported %q, want %q", i+1, out, want)
+			}
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != "{}\n" {
+			t.Errorf("content = %q, want %q", data, "{}\n")
+		}
+		if err := os.Chmod(path, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if out, err := runSh(t, home, script); err != nil || out != "written" {
+			t.Errorf("run with a loose mode: %q, %v; want written", out, err)
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != 0o600 {
+			t.Errorf("mode = %o, want 600", info.Mode().Perm())
+		}
+		if entries, _ := os.ReadDir(filepath.Dir(path)); len(entries) != 1 {
+			t.Errorf("left %d entries next to the file, want none", len(entries)-1)
+		}
+	})
+
+	t.Run("sprite_lock", func(t *testing.T) {
+		home := t.TempDir()
+		script := spriteLockScript + `
+sprite_lock me 1 900
+cat "$SPRITE_LOCK/owner"
+`
+		out, err := runSh(t, home, script)
+		if err != nil {
+			t.Fatalf("run: %v: %s", err, out)
+		}
+		if out != "me" {
+			t.Errorf("lock owner while running = %q, want me", out)
+		}
+		if entries, _ := os.ReadDir(home); len(entries) != 0 {
+			t.Errorf("lock left behind after the run")
+		}
+
+		// A lock held by a running process makes the next run give up
+		writeLock(t, home, os.Getpid(), time.Now())
+		out, err = runSh(t, home, script)
+		exit, ok := err.(*exec.ExitError)
+		if !ok || exit.ExitCode() != spriteLockedExit {
+			t.Fatalf("run over a held lock ended with %v, want exit status %d", err, spriteLockedExit)
+		}
+		if !strings.Contains(out, "sprite locked by host other, pid 1") {
+			t.Errorf("output %q doesn't name the holder", out)
+		}
+	})
+}
@@ -0,0 +1,239 @@
+package tools
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/vaurdan/sprite-bootstrap/internal/config"
+)
+
+// serveLogExcerptLines is how much of the serve log is attached to errors
+const serveLogExcerptLines = 20
+
+// serveEnvPrefixes select the variables that affect how serve reaches the
+// sprites API, recorded in the metadata so doctor can compare them
+var serveEnvPrefixes = []string{"SPRITE_", "SPRITES_", "OTEL_"}
+
+// serveEnvNames are individual variables recorded in the metadata
+var serveEnvNames = []string{
+	"HTTP_PROXY", "HTTPS_PROXY", "NO_PROXY", "ALL_PROXY",
+	"http_proxy", "https_proxy", "no_proxy", "all_proxy",
+	"SSL_CERT_FILE", "SSL_CERT_DIR",
+	"HOME", "USERPROFILE", "LOCALAPPDATA", "XDG_STATE_HOME",
+}
+
+// ServeMetadata describes the running background server
+type ServeMetadata struct {
+	PID         int               `json:"pid"`
+	Port        int               `json:"port"`
+	StartedAt   time.Time         `json:"started_at"`
+	Executable  string            `json:"executable"`
+	Args        []string          `json:"args"`
+	LogFile     string            `json:"log_file"`               // This run's output
+	RotatingLog string            `json:"rotating_log,omitempty"` // Kept across restarts, rotated by size
+	Env         map[string]string `json:"env"`
+}
+
+// ServeStats is the live state a running server publishes for status
+type ServeStats struct {
+	PID            int       `json:"pid"`
+	Connections    int       `json:"connections"`
+	MaxConnections int       `json:"max_connections"` // 0 for no limit
+	UpdatedAt      time.Time `json:"updated_at"`
+
+	// Forwards are the server's forward channels with data flowing
+	Forwards []ForwardInfo `json:"forwards,omitempty"`
+}
+
+// ServeStatsInterval is how often a running server updates its stats
+const ServeStatsInterval = 5 * time.Second
+
+// serveStatsFile returns the path to the running server's stats
+func serveStatsFile() string {
+	return filepath.Join(config.RuntimeDir(), "serve-stats.json")
+}
+
+// LoadServeStats reads the stats of the running server
+func LoadServeStats() (*ServeStats, error) {
+	data, err := os.ReadFile(serveStatsFile())
+	if err != nil {
+		return nil, err
+	}
+	stats := &ServeStats{}
+	if err := json.Unmarshal(data, stats); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// SaveServeStats writes the running server's stats, replacing the file
+// atomically so readers never see a partial write
+func SaveServeStats(stats *ServeStats) error {
+	data, err := json.Marshal(stats)
+	if err != nil {
+		return err
+	}
+	tmp := serveStatsFile() + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, serveStatsFile())
+}
+
+// ServeLogFile returns the path to the background server's log
+func ServeLogFile() string {
+	return filepath.Join(config.RuntimeDir(), "serve.log")
+}
+
+// ServeRotatingLog returns the path of the size-rotated log the background
+// server on port writes with --log-file
+func ServeRotatingLog(port int) string {
+	return filepath.Join(config.StateDir(), "logs", fmt.Sprintf("serve-%d.log", port))
+}
+
+// StdioLog returns the path of the size-rotated log stdio writes by default
+func StdioLog() string {
+	return filepath.Join(config.StateDir(), "logs", "stdio.log")
+}
+
+// serveMetaFile returns the path to the background server's metadata
+func serveMetaFile() string {
+	return filepath.Join(config.RuntimeDir(), "serve-meta.json")
+}
+
+// LoadServeMetadata reads the metadata written when serve was last started
+func LoadServeMetadata() (*ServeMetadata, error) {
+	data, err := os.ReadFile(serveMetaFile())
+	if err != nil {
+		return nil, err
+	}
+	meta := &ServeMetadata{}
+	if err := json.Unmarshal(data, meta); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+// saveServeMetadata writes the metadata for a freshly started server
+func saveServeMetadata(meta *ServeMetadata) error {
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(serveMetaFile(), data, 0600)
+}
+
+// serveEnv returns the environment passed to the background server: a copy
+// of ours with malformed and duplicate entries removed (last one wins), so
+// the child sees the same proxy and SPRITE_* settings even when the launch
+// context would otherwise drop them
+func serveEnv() []string {
+	seen := make(map[string]int)
+	var env []string
+	for _, kv := range os.Environ() {
+		name, _, ok := strings.Cut(kv, "=")
+		if !ok || name == "" {
+			continue
+		}
+		if i, dup := seen[name]; dup {
+			env[i] = kv
+			continue
+		}
+		seen[name] = len(env)
+		env = append(env, kv)
+	}
+	return env
+}
+
+// EnvSnapshot returns the variables that affect how serve reaches the
+// sprites API, with credentials in proxy URLs redacted
+func EnvSnapshot(env []string) map[string]string {
+	snapshot := make(map[string]string)
+	for _, kv := range env {
+		name, value, ok := strings.Cut(kv, "=")
+		if !ok || !isServeEnvName(name) {
+			continue
+		}
+		snapshot[name] = redactEnvValue(name, value)
+	}
+	return snapshot
+}
+
+// DiffEnvSnapshots lists the variables that differ between two snapshots
+func DiffEnvSnapshots(want, got map[string]string) []string {
+	names := make(map[string]bool)
+	for name := range want {
+		names[name] = true
+	}
+	for name := range got {
+		names[name] = true
+	}
+
+	var diffs []string
+	for name := range names {
+		w, wok := want[name]
+		g, gok := got[name]
+		switch {
+		case wok && !gok:
+			diffs = append(diffs, name+" is unset for serve (here: "+w+")")
+		case !wok && gok:
+			diffs = append(diffs, name+" is set for serve ("+g+") but not here")
+		case w != g:
+			diffs = append(diffs, name+" differs (serve: "+g+", here: "+w+")")
+		}
+	}
+	sort.Strings(diffs)
+	return diffs
+}
+
+func isServeEnvName(name string) bool {
+	for _, n := range serveEnvNames {
+		if name == n {
+			return true
+		}
+	}
+	for _, prefix := range serveEnvPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactEnvValue hides secrets: tokens entirely, proxy passwords in URLs
+func redactEnvValue(name, value string) string {
+	upper := strings.ToUpper(name)
+	if strings.Contains(upper, "TOKEN") || strings.Contains(upper, "SECRET") || strings.Contains(upper, "HEADERS") {
+		return "<redacted>"
+	}
+	if u, err := url.Parse(value); err == nil && u.User != nil {
+		if _, hasPassword := u.User.Password(); hasPassword {
+			u.User = url.UserPassword(u.User.Username(), "redacted")
+			return u.String()
+		}
+	}
+	return value
+}
+
+// serveLogExcerpt returns the last lines of the serve log, formatted for
+// appending to an error, or "" if there's nothing to show
+func serveLogExcerpt() string {
+	data, err := os.ReadFile(ServeLogFile())
+	if err != nil {
+		return ""
+	}
+	lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	if len(lines) == 1 && lines[0] == "" {
+		return ""
+	}
+	if len(lines) > serveLogExcerptLines {
+		lines = lines[len(lines)-serveLogExcerptLines:]
+	}
+	return "\n\nServe log (" + ServeLogFile() + "):\n  " + strings.Join(lines, "\n  ")
+}
@@ -0,0 +1,159 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/vaurdan/sprite-bootstrap/internal/config"
+	"github.com/vaurdan/sprite-bootstrap/internal/sshserver"
+)
+
+// ControlSocket returns the path of the control socket of the serve
+// process with pid
+func ControlSocket(pid int) string {
+	return filepath.Join(config.RuntimeDir(), fmt.Sprintf("control-%d.sock", pid))
+}
+
+// controlRequest sends a request to the running server's control socket
+// and returns the response body, failing on any status other than 2xx.
+// body, if not nil, is sent as JSON.
+func controlRequest(method, path string, body any) ([]byte, error) {
+	pid := GetServePid()
+	if pid == 0 {
+		return nil, errors.New("the SSH server is not running")
+	}
+	return controlRequestTo(pid, method, path, body)
+}
+
+// controlRequestTo sends a request to the control socket of the process
+// with pid, serve or a forwarding command, like controlRequest
+func controlRequestTo(pid int, method, path string, body any) ([]byte, error) {
+	socket := ControlSocket(pid)
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		},
+	}
+
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reqBody = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, "http://control"+path, reqBody)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("reach the control socket of process %d (restart it if it predates this version): %w", pid, err)
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, &ControlError{Status: resp.StatusCode, Message: strings.TrimSpace(string(respBody))}
+	}
+	return respBody, nil
+}
+
+// ControlError is a control socket request the process refused
+type ControlError struct {
+	Status  int    // HTTP status code
+	Message string // Explanation from the process
+}
+
+func (e *ControlError) Error() string {
+	return fmt.Sprintf("%s (%s)", e.Message, http.StatusText(e.Status))
+}
+
+// ListSessions returns the running server's SSH connections, oldest first
+func ListSessions() ([]sshserver.ConnSnapshot, error) {
+	body, err := controlRequest(http.MethodGet, "/sessions", nil)
+	if err != nil {
+		return nil, err
+	}
+	var sessions []sshserver.ConnSnapshot
+	if err := json.Unmarshal(body, &sessions); err != nil {
+		return nil, fmt.Errorf("parse sessions: %w", err)
+	}
+	return sessions, nil
+}
+
+// FindSession returns the connection with an ID or a unique prefix of one
+func FindSession(sessions []sshserver.ConnSnapshot, ref string) (sshserver.ConnSnapshot, error) {
+	var matches []sshserver.ConnSnapshot
+	for _, s := range sessions {
+		if s.ID == ref {
+			return s, nil
+		}
+		if ref != "" && strings.HasPrefix(s.ID, ref) {
+			matches = append(matches, s)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return sshserver.ConnSnapshot{}, fmt.Errorf("no session %q (see 'sprite-bootstrap sessions')", ref)
+	case 1:
+		return matches[0], nil
+	default:
+		return sshserver.ConnSnapshot{}, fmt.Errorf("%q matches %d sessions; give more of the ID", ref, len(matches))
+	}
+}
+
+// KillSession ends one of the running server's SSH connections, with all
+// of its sessions and forwards
+func KillSession(id string) error {
+	_, err := controlRequest(http.MethodDelete, "/sessions/"+url.PathEscape(id), nil)
+	return err
+}
+
+// WriteDump asks the running server to write a debug dump of its state to
+// the runtime directory, and returns the dump's path
+func WriteDump() (string, error) {
+	body, err := controlRequest(http.MethodPost, "/dumps", nil)
+	if err != nil {
+		return "", err
+	}
+	var dump sshserver.Dump
+	if err := json.Unmarshal(body, &dump); err != nil {
+		return "", fmt.Errorf("parse dump: %w", err)
+	}
+	return dump.Path, nil
+}
+
+// NewPairingCode asks the running server for a one-time code that lets a
+// client without an SSH key log in
+func NewPairingCode() (sshserver.PairingCode, error) {
+	var code sshserver.PairingCode
+	body, err := controlRequest(http.MethodPost, "/pairing-codes", nil)
+	if err != nil {
+		return code, err
+	}
+	if err := json.Unmarshal(body, &code); err != nil {
+		return code, fmt.Errorf("parse pairing code: %w", err)
+	}
+	return code, nil
+}
@@ -0,0 +1,70 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/superfly/sprites-go"
+)
+
+// spriteLockedExit is the exit status of a script that gave up waiting for
+// the sprite lock
+const spriteLockedExit = 75
+
+// spriteLockWait bounds how long a script waits for another run to release
+// the sprite lock. It is shortened to fit the script's own timeout.
+var spriteLockWait = 90 * time.Second
+
+// spriteLockStale is how old a lock may get before it is broken even though
+// its holder looks alive, which covers a reused PID
+const spriteLockStale = 15 * time.Minute
+
+// lockedScript prefixes a script that modifies the sprite so it runs while
+// holding the sprite lock, waiting at most until shortly before ctx's
+// deadline. Scripts that only read skip the lock.
+func lockedScript(ctx context.Context, script string) string {
+	wait := spriteLockWait
+	if deadline, ok := ctx.Deadline(); ok {
+		wait = min(wait, time.Until(deadline)-2*time.Second)
+	}
+	wait = max(wait, time.Second)
+
+	host, _ := os.Hostname()
+	owner := fmt.Sprintf("host %s, pid %d", host, os.Getpid())
+	return fmt.Sprintf("%s\nsprite_lock %s %d %d\n%s", spriteLockScript, posixQuote(owner),
+		int(wait.Seconds()), int(spriteLockStale.Seconds()), script)
+}
+
+// lockedCommand returns a bash command running script, which modifies the
+// sprite, while holding the sprite lock
+func lockedCommand(ctx context.Context, sprite *sprites.Sprite, script string, args ...string) *sprites.Cmd {
+	return sprite.CommandContext(ctx, "/bin/bash", append([]string{"-c", lockedScript(ctx, script), "bash"}, args...)...)
+}
+
+// isSpriteLocked reports whether err is the exit of a script that couldn't
+// take the sprite lock
+func isSpriteLocked(err error) bool {
+	var exit *sprites.ExitError
+	return errors.As(err, &exit) && exit.ExitCode() == spriteLockedExit
+}
+
+// spriteLockError explains a script that couldn't take the sprite lock,
+// naming the run holding it. Other errors are returned as is.
+func spriteLockError(sprite *sprites.Sprite, err error) error {
+	if !isSpriteLocked(err) {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	holder := "unknown"
+	out, readErr := sprite.CommandContext(ctx, "/bin/sh", "-c", `cat "$HOME/.sprite-bootstrap.lock/owner" 2>/dev/null`).Output()
+	if owner := strings.TrimSpace(string(out)); readErr == nil && owner != "" {
+		holder = owner
+	}
+	return fmt.Errorf("another sprite-bootstrap run is modifying this sprite (%s); try again once it finishes", holder)
+}
@@ -0,0 +1,194 @@
+package tools
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// lockCommand runs body under the sprite lock in home, waiting wait seconds
+// and breaking locks older than stale seconds
+func lockCommand(home, owner string, wait, stale int, body string) *exec.Cmd {
+	script := fmt.Sprintf("%s\nsprite_lock %s %d %d\n%s", spriteLockScript, posixQuote(owner), wait, stale, body)
+	cmd := exec.Command("bash", "-c", script)
+	cmd.Env = append(os.Environ(), "HOME="+home)
+	return cmd
+}
+
+// exitedPID returns the PID of a process that has exited
+func exitedPID(t *testing.T) int {
+	t.Helper()
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	return cmd.Process.Pid
+}
+
+// writeLock leaves a lock in home as a run with pid, started at started,
+// would
+func writeLock(t *testing.T, home string, pid int, started time.Time) string {
+	t.Helper()
+	lock := filepath.Join(home, ".sprite-bootstrap.lock")
+	if err := os.Mkdir(lock, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(lock, "owner"), []byte("host other, pid 1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(lock, "pid"), fmt.Appendf(nil, "%d %d\n", pid, started.Unix()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return lock
+}
+
+// criticalSection fails when another run is inside it at the same time
+const criticalSection = `
+[ ! -e "$HOME/inside" ] || { echo overlap >&2; exit 1; }
+touch "$HOME/inside"
+sleep 0.05
+rm "$HOME/inside"
+`
+
+// TestSpriteLockContention starts runs at once, over a free lock and over
+// a stale one they all try to break, and checks they take turns
+func TestSpriteLockContention(t *testing.T) {
+	if _, err := exec.LookPath("bash"); err != nil {
+		t.Skip("bash not installed")
+	}
+	tests := []struct {
+		name  string
+		stale bool // A dead run left the lock behind
+	}{
+		{"free", false},
+		{"stale", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for round := 0; round < 3; round++ {
+				home := t.TempDir()
+				if tt.stale {
+					writeLock(t, home, exitedPID(t), time.Now())
+				}
+
+				const runs = 6
+				var wg sync.WaitGroup
+				errs := make(chan error, runs)
+				for i := 0; i < runs; i++ {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						cmd := lockCommand(home, fmt.Sprintf("run %d", i), 30, 900, criticalSection)
+						if out, err := cmd.CombinedOutput(); err != nil {
+							errs <- fmt.Errorf("run %d: %v: %s", i, err, out)
+						}
+					}()
+				}
+				wg.Wait()
+				close(errs)
+				for err := range errs {
+					t.Error(err)
+				}
+
+				entries, err := os.ReadDir(home)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(entries) != 0 {
+					var names []string
+					for _, e := range entries {
+						names = append(names, e.Name())
+					}
+					t.Fatalf("round %d left %v behind", round, names)
+				}
+			}
+		})
+	}
+}
+
+// TestSpriteLockHeld checks which locks a run waits for and which it
+// breaks
+func TestSpriteLockHeld(t *testing.T) {
+	if _, err := exec.LookPath("bash"); err != nil {
+		t.Skip("bash not installed")
+	}
+	tests := []struct {
+		name       string
+		pid        int
+		age        time.Duration
+		wantLocked bool
+	}{
+		{"holder running", os.Getpid(), 0, true},
+		{"holder gone", exitedPID(t), 0, false},
+		{"holder running too long", os.Getpid(), time.Hour, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			home := t.TempDir()
+			lock := writeLock(t, home, tt.pid, time.Now().Add(-tt.age))
+
+			out, err := lockCommand(home, "me", 1, 900, "cat \"$HOME/.sprite-bootstrap.lock/owner\"").CombinedOutput()
+			if tt.wantLocked {
+				exit, ok := err.(*exec.ExitError)
+				if !ok || exit.ExitCode() != spriteLockedExit {
+					t.Fatalf("run ended with %v, want exit status %d", err, spriteLockedExit)
+				}
+				if want := "sprite locked by host other, pid 1"; !strings.Contains(string(out), want) {
+					t.Errorf("output %q doesn't name the holder", out)
+				}
+				if _, err := os.Stat(filepath.Join(lock, "pid")); err != nil {
+					t.Errorf("held lock was touched: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("run failed: %v: %s", err, out)
+			}
+			if strings.TrimSpace(string(out)) != "me" {
+				t.Errorf("lock owner while running = %q, want me", out)
+			}
+			if _, err := os.Stat(lock); !os.IsNotExist(err) {
+				t.Errorf("lock left behind after the run: %v", err)
+			}
+		})
+	}
+}
+
+// TestSpriteLockKeepsOthersLock checks that a run whose lock was broken and
+// taken by another doesn't remove that one when it exits, and that a lock
+// which changed since it was found stale isn't broken
+func TestSpriteLockKeepsOthersLock(t *testing.T) {
+	if _, err := exec.LookPath("bash"); err != nil {
+		t.Skip("bash not installed")
+	}
+	home := t.TempDir()
+	lock := filepath.Join(home, ".sprite-bootstrap.lock")
+
+	// The run's lock is replaced while it holds it
+	replace := `rm -rf "$SPRITE_LOCK" && mkdir "$SPRITE_LOCK" && echo "1 2" > "$SPRITE_LOCK/pid"`
+	if out, err := lockCommand(home, "me", 1, 900, replace).CombinedOutput(); err != nil {
+		t.Fatalf("run failed: %v: %s", err, out)
+	}
+	if data, err := os.ReadFile(filepath.Join(lock, "pid")); err != nil || string(data) != "1 2\n" {
+		t.Fatalf("other run's lock = %q, %v; want it kept", data, err)
+	}
+
+	// Breaking the lock seen before, "3 4", finds "1 2" instead
+	script := spriteLockScript + "\nsprite_lock_remove '3 4'\n"
+	cmd := exec.Command("bash", "-c", script)
+	cmd.Env = append(os.Environ(), "HOME="+home)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("sprite_lock_remove: %v: %s", err, out)
+	}
+	if data, err := os.ReadFile(filepath.Join(lock, "pid")); err != nil || string(data) != "1 2\n" {
+		t.Errorf("lock after removing another = %q, %v; want it kept", data, err)
+	}
+	if entries, _ := os.ReadDir(home); len(entries) != 1 {
+		t.Errorf("left %d entries in home, want just the lock", len(entries))
+	}
+}
@@ -0,0 +1,86 @@
+package tools
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/vaurdan/sprite-bootstrap/internal/sshconfig"
+	"github.com/vaurdan/sprite-bootstrap/internal/ui"
+
+	"github.com/charmbracelet/huh"
+)
+
+// checkSSHConflicts looks for options elsewhere in the SSH config that break
+// the sprite's entry, explains them and, where a directive in the entry
+// overrides them, offers to add it
+func checkSSHConflicts(entry sshconfig.Entry) {
+	conflicts, err := sshconfig.CheckConflicts(entry)
+	if err != nil {
+		slog.Debug("Failed to check SSH config conflicts", "exception", err)
+		return
+	}
+	if len(conflicts) == 0 {
+		return
+	}
+
+	host := sshconfig.HostName(entry.Sprite)
+	var fixes []string
+	for _, c := range conflicts {
+		fmt.Printf("%s⚠%s SSH option %s %s applies to %s: %s\n", ColorYellow, ColorReset, c.Option, c.Value, host, c.Problem)
+		for _, f := range c.Fix {
+			if !slices.Contains(fixes, f) {
+				fixes = append(fixes, f)
+			}
+		}
+	}
+	if len(fixes) < len(conflicts) {
+		fmt.Printf("    Move the block that sets it below the sprite-bootstrap entries, or exclude them with \"Host * !sprite-*\"\n")
+	}
+	if len(fixes) == 0 {
+		return
+	}
+	if !ui.IsInteractive() || !confirmSSHOverrides(host, fixes) {
+		fmt.Printf("    To fix, add to the %s entry: %s\n", host, strings.Join(fixes, "; "))
+		return
+	}
+
+	entry.Overrides = append(entry.Overrides, fixes...)
+	txn := sshconfig.Begin()
+	txn.Add(entry)
+	if err := commitSSHConfig(txn); err != nil {
+		fmt.Printf("%s⚠%s Failed to update SSH config: %v\n", ColorYellow, ColorReset, err)
+		return
+	}
+
+	// ssh keeps the first value it finds, so an override loses to a block
+	// earlier in the file
+	remaining, err := sshconfig.CheckConflicts(entry)
+	if err != nil {
+		return
+	}
+	for _, c := range remaining {
+		if len(c.Fix) > 0 {
+			fmt.Printf("%s⚠%s %s is still in effect: a block earlier in the file sets it first. Move that block below the sprite-bootstrap entries.\n", ColorYellow, ColorReset, c.Option)
+		}
+	}
+}
+
+// confirmSSHOverrides asks whether to add overriding directives to a
+// sprite's SSH config entry
+func confirmSSHOverrides(host string, fixes []string) bool {
+	var add bool
+	form := huh.NewForm(
+		huh.NewGroup(
+			huh.NewConfirm().
+				Title(fmt.Sprintf("Override these options for %s?", host)).
+				Description("Adds to its managed block: " + strings.Join(fixes, "; ")).
+				Value(&add),
+		),
+	)
+	if err := form.Run(); err != nil {
+		return false
+	}
+	return add
+}
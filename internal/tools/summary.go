@@ -0,0 +1,144 @@
+package tools
+
+import (
+	"cmp"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/vaurdan/sprite-bootstrap/internal/sshconfig"
+	"github.com/vaurdan/sprite-bootstrap/internal/sshserver"
+)
+
+// defaultLocalPort is the --port default, left out of summary commands
+const defaultLocalPort = 2222
+
+// Summary describes what a bootstrap set up, how to reconnect and how to
+// clean up, the same way whichever tool ran. It is printed after the
+// tool's instructions and included in --json output.
+type Summary struct {
+	Tool      string `json:"tool"`
+	Sprite    string `json:"sprite"`
+	Org       string `json:"org,omitempty"`
+	Workspace string `json:"workspace"`
+	Host      string `json:"host"`
+	Port      int    `json:"port"`
+	SSHUser   string `json:"ssh_user"`
+	SSHAlias  string `json:"ssh_alias,omitempty"`
+
+	// Commands to run later, ready to paste into a shell
+	Reconnect string `json:"reconnect"`
+	SSH       string `json:"ssh"`
+	Stop      string `json:"stop"`
+	Cleanup   string `json:"cleanup"`
+
+	// Files are the local state the bootstrap created or relies on
+	Files []SummaryFile `json:"files"`
+}
+
+// SummaryFile is a local file listed in a Summary
+type SummaryFile struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+}
+
+// NewSummary describes the setup opts leads to with tool. The reconnect
+// command repeats opts.Command, or the tool's own command when it is empty.
+func NewSummary(tool Tool, opts SetupOptions) *Summary {
+	s := &Summary{
+		Tool:      tool.Name(),
+		Sprite:    opts.SpriteName,
+		Org:       opts.OrgName,
+		Workspace: opts.RemotePath,
+		Host:      opts.ServeHost(),
+		Port:      opts.LocalPort,
+		SSHUser:   opts.SSHUser(),
+	}
+
+	command := opts.Command
+	if len(command) == 0 {
+		command = append([]string{tool.Name()}, spriteArgs(opts)...)
+		if opts.RemotePath != "/home/sprite" || opts.OpenFile != "" {
+			command = append(command, "--path", cmp.Or(opts.OpenFile, opts.RemotePath))
+		}
+		if opts.Stdio {
+			command = append(command, "--stdio")
+		} else if opts.Tailscale {
+			command = append(command, "--tailscale")
+		} else if opts.Host != "" {
+			command = append(command, "--host", opts.Host)
+		}
+	}
+	s.Reconnect = displayArgs(append([]string{"sprite-bootstrap"}, command...))
+
+	if _, ok := tool.(SSHConfigurer); ok {
+		s.SSHAlias = sshconfig.HostName(opts.SpriteName)
+		s.SSH = "ssh " + s.SSHAlias
+	} else {
+		s.SSH = displayArgs([]string{"ssh", "-p", strconv.Itoa(opts.LocalPort), s.SSHUser + "@" + s.Host})
+	}
+
+	s.Stop = "sprite-bootstrap stop"
+	s.Cleanup = displayArgs(append(append([]string{"sprite-bootstrap", "stop"}, spriteArgs(opts)...), "--tool", tool.Name()))
+
+	if s.SSHAlias != "" {
+		if path, err := sshconfig.Path(); err == nil {
+			s.Files = append(s.Files, SummaryFile{Name: "SSH config", Path: path})
+		}
+	}
+	if path := pinnedKnownHosts(opts); path != "" {
+		s.Files = append(s.Files, SummaryFile{Name: "Pinned host key", Path: path})
+	}
+	if path, err := sshserver.DefaultHostKeyPath(); err == nil {
+		s.Files = append(s.Files, SummaryFile{Name: "Server host key", Path: path})
+	}
+	s.Files = append(s.Files,
+		SummaryFile{Name: "Server PID", Path: ServePidFile()},
+		SummaryFile{Name: "Server log", Path: ServeLogFile()},
+	)
+	return s
+}
+
+// spriteArgs returns the flags selecting the sprite, organization and port
+func spriteArgs(opts SetupOptions) []string {
+	args := []string{"-s", opts.SpriteName}
+	if opts.OrgName != "" {
+		args = append(args, "-o", opts.OrgName)
+	}
+	if opts.LocalPort != defaultLocalPort {
+		args = append(args, "-p", strconv.Itoa(opts.LocalPort))
+	}
+	return args
+}
+
+// String renders the summary as plain text that survives being pasted into
+// docs: no emoji, and colors only where the output colors at all
+func (s *Summary) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%sSummary%s\n", ColorBold, ColorReset)
+
+	row := func(label, value string) {
+		fmt.Fprintf(&b, "  %-11s %s\n", label+":", value)
+	}
+	sprite := s.Sprite
+	if s.Org != "" {
+		sprite += " (org " + s.Org + ")"
+	}
+	row("Sprite", sprite)
+	row("Tool", s.Tool)
+	row("Workspace", s.Workspace)
+	row("Server", fmt.Sprintf("%s:%d as %s", s.Host, s.Port, s.SSHUser))
+	if s.SSHAlias != "" {
+		row("SSH alias", s.SSHAlias)
+	}
+	row("Reconnect", s.Reconnect)
+	row("Shell", s.SSH)
+	row("Stop", s.Stop)
+	row("Clean up", s.Cleanup)
+
+	b.WriteString("  Files:\n")
+	for _, f := range s.Files {
+		fmt.Fprintf(&b, "    %-17s %s\n", f.Name, f.Path)
+	}
+	return b.String()
+}
@@ -2,6 +2,12 @@ package tools
 
 import (
 	"context"
+	"fmt"
+	"strings"
+
+	"github.com/vaurdan/sprite-bootstrap/internal/config"
+	"github.com/vaurdan/sprite-bootstrap/internal/sshconfig"
+	"github.com/vaurdan/sprite-bootstrap/internal/sshserver"
 
 	"github.com/superfly/sprites-go"
 )
@@ -30,11 +36,138 @@ type Cleaner interface {
 	Cleanup(ctx context.Context, sprite *sprites.Sprite) error
 }
 
+// ServerCacher is an optional interface for tools whose server on the
+// sprite keeps caches that go stale when the sprite is rebuilt from a newer
+// image
+type ServerCacher interface {
+	// ServerCaches returns the cache paths, relative to the sprite user's
+	// home. Cleanup stops the server before they are removed.
+	ServerCaches() []string
+}
+
+// ExtensionInstaller is an optional interface for tools that can install
+// editor extensions on the sprite
+type ExtensionInstaller interface {
+	// InstallExtensions installs the given extensions (publisher.name) on the sprite
+	InstallExtensions(ctx context.Context, sprite *sprites.Sprite, ids []string) error
+}
+
+// EditorLauncher is an optional interface for tools that launch a local
+// editor and pass it extra arguments from --editor-args
+type EditorLauncher interface {
+	// CheckEditorArgs returns an error for args that conflict with the
+	// ones the tool passes itself
+	CheckEditorArgs(args []string) error
+}
+
+// SSHConfigurer is an optional interface for tools that connect through a
+// host alias in ~/.ssh/config
+type SSHConfigurer interface {
+	// SSHConfigEntry returns the SSH config entry the tool needs
+	SSHConfigEntry(opts SetupOptions) sshconfig.Entry
+}
+
+// Verifier is an optional interface for tools that can describe the state
+// their setup leaves behind, so it can be checked and repaired
+type Verifier interface {
+	// Artifacts returns the state Setup is expected to produce
+	Artifacts(opts SetupOptions) []Artifact
+}
+
+// Artifact is a file or directory a tool's setup is expected to leave behind
+type Artifact struct {
+	Name     string // Short description shown in reports
+	Path     string // Path on the sprite; a leading ~/ is the sprite user's home
+	Local    bool   // Path is on the local machine instead of the sprite
+	Dir      bool   // Expect a directory rather than a regular file
+	Optional bool   // Missing is fine; ownership and content are only checked when present
+	Contains string // Text the file must contain
+	SHA256   string // Expected hex SHA-256 of the file contents
+
+	// Step names the setup step that produces the artifact. Artifacts sharing
+	// a step are fixed together by running Fix once.
+	Step string
+	Fix  func(ctx context.Context, opts SetupOptions) error
+	Hint string // Manual fix shown when Fix is nil or fails
+}
+
 // SetupOptions contains configuration for setting up a tool
 type SetupOptions struct {
 	SpriteName string
 	OrgName    string
 	LocalPort  int
-	RemotePath string // Path on the sprite (e.g., /home/sprite or /home/sprite/myproject)
+	RemotePath string          // Path on the sprite (e.g., /home/sprite or /home/sprite/myproject)
 	Sprite     *sprites.Sprite // The sprite instance for running remote commands
+
+	// OpenFile is the file to focus when RemotePath named a regular file.
+	// Bootstrap then sets RemotePath to its directory, which tools that
+	// can't target a file open instead.
+	OpenFile string
+
+	// EditorArgs are extra arguments appended to the editor launch
+	EditorArgs []string
+
+	// Policy is the organization's policy, resolved with the credentials
+	Policy *config.Policy
+
+	// Host is the serve host the IDE connects to. Empty means localhost.
+	Host string
+	// Tailscale starts serve bound to the tailnet address
+	Tailscale bool
+	// Stdio has the SSH config entry reach the sprite through a
+	// "sprite-bootstrap stdio" ProxyCommand, without a background server
+	Stdio bool
+
+	// Optional extras, usually filled in from a profile
+	Extensions []string // Remote extensions to install (publisher.name)
+	EnvFile    string   // Local env file copied to the sprite
+	Dotfiles   string   // Dotfiles git URL, optionally suffixed with #branch
+	PostHooks  []string // Commands run on the sprite after setup
+
+	// GitSigning is how local commits are signed, detected during
+	// bootstrap. Nil when commits aren't signed.
+	GitSigning *GitSigning
+
+	// Command is the sprite-bootstrap command line, without the binary,
+	// the summary suggests for reconnecting. Empty means the tool's own.
+	Command []string
+	// Quiet leaves the summary out of Bootstrap's output
+	Quiet bool
+}
+
+// WorkspaceTarget describes what the IDE opens: the workspace root, and the
+// focused file if there is one
+func (o SetupOptions) WorkspaceTarget() string {
+	if o.OpenFile == "" {
+		return o.RemotePath
+	}
+	return fmt.Sprintf("%s (file %s)", o.RemotePath, o.OpenFile)
+}
+
+// SSHUser returns the SSH username that reaches the sprite through serve,
+// naming the organization when one was given
+func (o SetupOptions) SSHUser() string {
+	return sshserver.SpriteUser(o.SpriteName, o.OrgName)
+}
+
+// ServeHost returns the host the IDE should connect to for the SSH server
+func (o SetupOptions) ServeHost() string {
+	if o.Host == "" {
+		return "localhost"
+	}
+	return o.Host
+}
+
+// ProxyCommand returns the ssh ProxyCommand that serves the connection with
+// "sprite-bootstrap stdio", or "" without Stdio
+func (o SetupOptions) ProxyCommand() string {
+	if !o.Stdio {
+		return ""
+	}
+	executable, err := selfExecutable()
+	if err != nil {
+		executable = "sprite-bootstrap"
+	}
+	// ssh expands % tokens in the command, %r being the username
+	return fmt.Sprintf(`"%s" stdio %%r`, strings.ReplaceAll(executable, "%", "%%"))
 }
@@ -2,15 +2,16 @@ package tools
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"fmt"
 	"os"
 	"os/exec"
-	"path/filepath"
 	"strings"
 	"time"
 
-	"sprite-bootstrap/internal/config"
+	"github.com/vaurdan/sprite-bootstrap/internal/config"
+	"github.com/vaurdan/sprite-bootstrap/internal/sshconfig"
 
 	"github.com/charmbracelet/huh"
 	"github.com/superfly/sprites-go"
@@ -33,12 +34,6 @@ func (v *VSCode) Description() string {
 
 const remoteSSHExtensionID = "ms-vscode-remote.remote-ssh"
 
-// Markers for our managed SSH config entries
-const (
-	sshConfigStartMarker = "# >>> sprite-bootstrap %s >>>"
-	sshConfigEndMarker   = "# <<< sprite-bootstrap %s <<<"
-)
-
 // findVSCodeBinary finds the VS Code binary
 func findVSCodeBinary() string {
 	if codePath := os.Getenv("VSCODE_PATH"); codePath != "" {
@@ -74,171 +69,46 @@ func installExtension(binary, extensionID string) error {
 	return cmd.Run()
 }
 
-// sshConfigHostName returns the SSH config host name for a sprite
-func sshConfigHostName(spriteName string) string {
-	return fmt.Sprintf("sprite-%s", spriteName)
-}
-
-// sshConfigPath returns the path to the user's SSH config
-func sshConfigPath() (string, error) {
-	homeDir, err := os.UserHomeDir()
-	if err != nil {
-		return "", err
-	}
-	return filepath.Join(homeDir, ".ssh", "config"), nil
-}
-
-// sshConfigLockPath returns the path to the SSH config lock file
-func sshConfigLockPath() (string, error) {
-	configPath, err := sshConfigPath()
-	if err != nil {
-		return "", err
+// SSHConfigEntry implements the SSHConfigurer interface for VS Code, which
+// connects through the sprite-<name> host alias
+func (v *VSCode) SSHConfigEntry(opts SetupOptions) sshconfig.Entry {
+	return sshconfig.Entry{
+		Sprite:       opts.SpriteName,
+		Host:         opts.ServeHost(),
+		Port:         opts.LocalPort,
+		User:         opts.SSHUser(),
+		ProxyCommand: opts.ProxyCommand(),
+		Cwd:          opts.RemotePath,
+		ForwardAgent: opts.GitSigning.UsesAgent(),
+		Tool:         v.Name(),
+
+		KnownHostsFile: pinnedKnownHosts(opts),
 	}
-	return configPath + ".sprite-bootstrap.lock", nil
 }
 
-// withSSHConfigLock executes a function while holding a lock on the SSH config
-func withSSHConfigLock(fn func() error) error {
-	lockPath, err := sshConfigLockPath()
-	if err != nil {
-		return err
-	}
-
-	// Create lock file
-	lockFile, err := os.OpenFile(lockPath, os.O_CREATE|os.O_RDWR, 0600)
-	if err != nil {
-		return fmt.Errorf("failed to create lock file: %w", err)
-	}
-	defer lockFile.Close()
-	defer os.Remove(lockPath)
-
-	// Try to acquire exclusive lock with timeout
-	// Use a simple retry loop since flock isn't portable
-	for i := 0; i < 50; i++ { // 5 second timeout
-		// Try to write our PID - if file is empty or has our PID, we have the lock
-		lockFile.Seek(0, 0)
-		content, _ := os.ReadFile(lockPath)
-		if len(content) == 0 {
-			lockFile.WriteString(fmt.Sprintf("%d", os.Getpid()))
-			break
-		}
-		time.Sleep(100 * time.Millisecond)
-	}
-
-	return fn()
-}
-
-// addSSHConfigEntry adds a sprite SSH config entry if not already present
-func addSSHConfigEntry(opts SetupOptions) error {
-	return withSSHConfigLock(func() error {
-		configPath, err := sshConfigPath()
-		if err != nil {
-			return err
-		}
-
-		// Ensure .ssh directory exists
-		if err := os.MkdirAll(filepath.Dir(configPath), 0700); err != nil {
-			return err
-		}
-
-		hostName := sshConfigHostName(opts.SpriteName)
-		startMarker := fmt.Sprintf(sshConfigStartMarker, opts.SpriteName)
-		endMarker := fmt.Sprintf(sshConfigEndMarker, opts.SpriteName)
-
-		// Read existing config (ignore error - file may not exist)
-		existingConfig, _ := os.ReadFile(configPath)
-
-		// Check if entry already exists - if so, remove it first
-		configStr := string(existingConfig)
-		if strings.Contains(configStr, startMarker) {
-			configStr = removeSSHConfigEntryFromString(configStr, opts.SpriteName)
-		}
-
-		// Build new entry
-		entry := fmt.Sprintf(`%s
-Host %s
-    HostName localhost
-    Port %d
-    User %s
-    StrictHostKeyChecking no
-    UserKnownHostsFile /dev/null
-%s
-`, startMarker, hostName, opts.LocalPort, opts.SpriteName, endMarker)
-
-		// Append to config
-		if len(configStr) > 0 && !strings.HasSuffix(configStr, "\n") {
-			configStr += "\n"
-		}
-		configStr += entry
-
-		return os.WriteFile(configPath, []byte(configStr), 0600)
-	})
-}
-
-// removeSSHConfigEntryFromString removes a sprite entry from the config string
-func removeSSHConfigEntryFromString(config, spriteName string) string {
-	startMarker := fmt.Sprintf(sshConfigStartMarker, spriteName)
-	endMarker := fmt.Sprintf(sshConfigEndMarker, spriteName)
-
-	lines := strings.Split(config, "\n")
-	var result []string
-	inBlock := false
-
-	for _, line := range lines {
-		if strings.TrimSpace(line) == startMarker {
-			inBlock = true
-			continue
-		}
-		if strings.TrimSpace(line) == endMarker {
-			inBlock = false
-			continue
-		}
-		if !inBlock {
-			result = append(result, line)
-		}
-	}
-
-	// Clean up extra blank lines at the end
-	for len(result) > 0 && strings.TrimSpace(result[len(result)-1]) == "" {
-		result = result[:len(result)-1]
+// vscodeArgs returns the arguments that open the remote workspace, with
+// the focused file if there is one
+func vscodeArgs(opts SetupOptions) []string {
+	remotePath := opts.RemotePath
+	if !strings.HasSuffix(remotePath, "/") {
+		remotePath += "/"
 	}
 
-	if len(result) > 0 {
-		return strings.Join(result, "\n") + "\n"
+	args := []string{"--remote", "ssh-remote+" + sshconfig.HostName(opts.SpriteName), remotePath}
+	if opts.OpenFile != "" {
+		args = append(args, "--goto", opts.OpenFile)
 	}
-	return ""
+	return append(args, opts.EditorArgs...)
 }
 
-// removeSSHConfigEntry removes a sprite SSH config entry
-func removeSSHConfigEntry(spriteName string) error {
-	configPath, err := sshConfigPath()
-	if err != nil {
-		return err
-	}
-
-	existingConfig, err := os.ReadFile(configPath)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return nil
-		}
-		return err
-	}
-
-	newConfig := removeSSHConfigEntryFromString(string(existingConfig), spriteName)
-	return os.WriteFile(configPath, []byte(newConfig), 0600)
+// CheckEditorArgs implements the EditorLauncher interface for VS Code
+func (v *VSCode) CheckEditorArgs(args []string) error {
+	return rejectFlags(args, "--remote", "--folder-uri", "--file-uri", "--goto", "-g")
 }
 
 // launchVSCode launches VS Code with SSH remote connection
 func launchVSCode(binary string, opts SetupOptions) error {
-	hostName := sshConfigHostName(opts.SpriteName)
-	remoteArg := fmt.Sprintf("ssh-remote+%s", hostName)
-
-	remotePath := opts.RemotePath
-	if !strings.HasSuffix(remotePath, "/") {
-		remotePath += "/"
-	}
-
-	cmd := exec.Command(binary, "--remote", remoteArg, remotePath)
+	cmd := exec.Command(binary, vscodeArgs(opts)...)
 	if err := cmd.Start(); err != nil {
 		return err
 	}
@@ -255,21 +125,20 @@ func (v *VSCode) Setup(ctx context.Context, opts SetupOptions) error {
 	// Install Remote-SSH extension if needed
 	if !hasExtension(binary, remoteSSHExtensionID) {
 		fmt.Printf("%s⏳%s Installing Remote-SSH extension...\n", ColorYellow, ColorReset)
-		if err := installExtension(binary, remoteSSHExtensionID); err != nil {
+		if err := traceStep(ctx, "vscode.install_remote_ssh", func(context.Context) error {
+			return installExtension(binary, remoteSSHExtensionID)
+		}); err != nil {
 			fmt.Printf("%s⚠%s Failed to install extension: %v\n", ColorYellow, ColorReset, err)
 		} else {
 			fmt.Printf("%s✓%s Remote-SSH extension installed\n", ColorGreen, ColorReset)
 		}
 	}
 
-	// Add SSH config entry
-	if err := addSSHConfigEntry(opts); err != nil {
-		fmt.Printf("%s⚠%s Failed to add SSH config: %v\n", ColorYellow, ColorReset, err)
-	}
-
 	// Clean up stale VS Code workspace state to prevent duplicate workspaces
 	if opts.Sprite != nil {
-		if err := cleanupStaleVSCodeState(ctx, opts.Sprite); err != nil {
+		if err := traceStep(ctx, "vscode.cleanup_state", func(ctx context.Context) error {
+			return cleanupStaleVSCodeState(ctx, opts.Sprite)
+		}); err != nil {
 			// Non-fatal, just log
 			fmt.Printf("%s⚠%s Failed to clean up stale VS Code state: %v\n", ColorYellow, ColorReset, err)
 		}
@@ -277,7 +146,9 @@ func (v *VSCode) Setup(ctx context.Context, opts SetupOptions) error {
 
 	// Fix Claude Code project path bug (VS Code extension adds trailing dash to path)
 	if opts.Sprite != nil {
-		if err := fixClaudeCodeProjectPaths(ctx, opts.Sprite); err != nil {
+		if err := traceStep(ctx, "vscode.fix_claude_paths", func(ctx context.Context) error {
+			return fixClaudeCodeProjectPaths(ctx, opts.Sprite)
+		}); err != nil {
 			// Non-fatal, just log
 			fmt.Printf("%s⚠%s Failed to fix Claude Code project paths: %v\n", ColorYellow, ColorReset, err)
 		}
@@ -288,7 +159,9 @@ func (v *VSCode) Setup(ctx context.Context, opts SetupOptions) error {
 		// Not installed - ask user if they want to install it
 		if promptInstallClaudeCode() {
 			fmt.Printf("%s⏳%s Installing Claude Code extension on remote...\n", ColorYellow, ColorReset)
-			if err := installClaudeCodeOnRemote(ctx, opts.Sprite); err != nil {
+			if err := traceStep(ctx, "vscode.install_claude_code", func(ctx context.Context) error {
+				return installClaudeCodeOnRemote(ctx, opts.Sprite)
+			}); err != nil {
 				fmt.Printf("%s⚠%s Failed to install: %v\n", ColorYellow, ColorReset, err)
 				fmt.Printf("   You can install it manually in VS Code Extensions\n")
 			} else {
@@ -297,10 +170,19 @@ func (v *VSCode) Setup(ctx context.Context, opts SetupOptions) error {
 		}
 	}
 
-	// Configure Claude Code settings for skip permissions mode
-	if opts.Sprite != nil {
-		if err := configureClaudeCodeSettings(ctx, opts.Sprite); err != nil {
+	// Configure Claude Code settings for skip permissions mode, unless the
+	// organization's policy denies it
+	if err := opts.Policy.CheckClaudeBypassPermissions(); err != nil && opts.Sprite != nil {
+		fmt.Printf("%s⚠%s Skipped Claude Code skip permissions mode: %v\n", ColorYellow, ColorReset, err)
+	} else if opts.Sprite != nil {
+		var changed bool
+		if err := traceStep(ctx, "vscode.claude_settings", func(ctx context.Context) (err error) {
+			changed, err = configureClaudeCodeSettings(ctx, opts.Sprite)
+			return err
+		}); err != nil {
 			fmt.Printf("%s⚠%s Failed to configure Claude Code settings: %v\n", ColorYellow, ColorReset, err)
+		} else {
+			fmt.Printf("%s✓%s Claude Code settings %s\n", ColorGreen, ColorReset, changedWord(changed, "configured"))
 		}
 	}
 
@@ -327,40 +209,12 @@ func fixClaudeCodeProjectPaths(ctx context.Context, sprite *sprites.Sprite) erro
 
 	// For each project directory without a trailing dash,
 	// create a symlink with the trailing dash pointing to it
-	script := `
-set -e
-PROJECTS_DIR="$HOME/.claude/projects"
-
-if [ ! -d "$PROJECTS_DIR" ]; then
-    exit 0
-fi
-
-# Find all directories that don't end with a dash
-for dir in "$PROJECTS_DIR"/*; do
-    if [ -d "$dir" ] && [ ! -L "$dir" ]; then
-        # Check if it doesn't already end with a dash
-        case "$dir" in
-            *-)
-                # Already ends with dash, skip
-                ;;
-            *)
-                # Create symlink with trailing dash if it doesn't exist
-                if [ ! -e "${dir}-" ]; then
-                    ln -s "$dir" "${dir}-"
-                fi
-                ;;
-        esac
-    fi
-done
-
-echo "done"
-`
-
-	cmd := sprite.CommandContext(fixCtx, "/bin/bash", "-c", script)
+
+	cmd := lockedCommand(fixCtx, sprite, fixClaudePathsScript)
 	cmd.Stdout = nil
 	cmd.Stderr = nil
 
-	return cmd.Run()
+	return spriteLockError(sprite, cmd.Run())
 }
 
 // cleanupStaleVSCodeState removes stale VS Code workspace locks and duplicate workspace folders
@@ -378,38 +232,12 @@ func cleanupStaleVSCodeState(ctx context.Context, sprite *sprites.Sprite) error
 	// 1. Remove all lock files (they'll be recreated by VS Code)
 	// 2. Remove duplicate workspace folders (ones with -1, -2, etc. suffixes)
 	//    keeping only the original to preserve extension state
-	script := `
-set -e
-WORKSPACE_DIR="$HOME/.vscode-server/data/User/workspaceStorage"
-
-if [ ! -d "$WORKSPACE_DIR" ]; then
-    exit 0
-fi
-
-# Remove all workspace lock files - VS Code will recreate them
-find "$WORKSPACE_DIR" -name "*.lock" -type f -delete 2>/dev/null || true
-
-# Find and remove duplicate workspace folders (those with -N suffix)
-# This keeps the original folder which has the extension state
-for dir in "$WORKSPACE_DIR"/*-[0-9]; do
-    if [ -d "$dir" ]; then
-        rm -rf "$dir"
-    fi
-done
-for dir in "$WORKSPACE_DIR"/*-[0-9][0-9]; do
-    if [ -d "$dir" ]; then
-        rm -rf "$dir"
-    fi
-done
-
-echo "cleanup complete"
-`
-
-	cmd := sprite.CommandContext(cleanupCtx, "/bin/bash", "-c", script)
+
+	cmd := lockedCommand(cleanupCtx, sprite, cleanupVSCodeStateScript)
 	cmd.Stdout = nil
 	cmd.Stderr = nil
 
-	return cmd.Run()
+	return spriteLockError(sprite, cmd.Run())
 }
 
 // isClaudeCodeInstalledOnRemote checks if Claude Code extension is installed on the sprite
@@ -433,123 +261,80 @@ func isClaudeCodeInstalledOnRemote(ctx context.Context, sprite *sprites.Sprite)
 	return strings.TrimSpace(string(output)) != ""
 }
 
-// configureClaudeCodeSettings ensures VS Code remote settings have Claude Code skip permissions enabled
-func configureClaudeCodeSettings(ctx context.Context, sprite *sprites.Sprite) error {
+// configureClaudeCodeSettings ensures VS Code remote settings have Claude Code skip permissions enabled,
+// reporting whether the settings file changed
+func configureClaudeCodeSettings(ctx context.Context, sprite *sprites.Sprite) (bool, error) {
 	if sprite == nil {
-		return fmt.Errorf("sprite is nil")
+		return false, fmt.Errorf("sprite is nil")
 	}
 
 	configCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	// Add Claude Code settings to VS Code server Machine settings
-	// This enables skip permissions mode by default for Claude Code
-	script := `
-set -e
-SETTINGS_DIR="$HOME/.vscode-server/data/Machine"
-SETTINGS_FILE="$SETTINGS_DIR/settings.json"
-
-# Create settings directory if needed
-mkdir -p "$SETTINGS_DIR"
-
-# If settings file doesn't exist, create it with our settings
-if [ ! -f "$SETTINGS_FILE" ]; then
-    cat > "$SETTINGS_FILE" << 'SETTINGS'
-{
-    "claudeCode.allowDangerouslySkipPermissions": true,
-    "claudeCode.initialPermissionMode": "bypassPermissions"
+	// This enables skip permissions mode by default for Claude Code.
+	// The file may have comments, so it's parsed here and handed to jq as
+	// plain JSON.
+	var settings map[string]any
+	current, err := loadRemoteSettings(configCtx, sprite, machineSettingsFile, &settings)
+	if err != nil {
+		return false, err
+	}
+	if settings["claudeCode.allowDangerouslySkipPermissions"] == true &&
+		settings["claudeCode.initialPermissionMode"] == "bypassPermissions" {
+		return false, nil
+	}
+
+	return runRemoteWrite(configCtx, sprite, claudeSettingsScript, bytes.NewReader(current))
 }
-SETTINGS
-    exit 0
-fi
 
-# Settings file exists - update/add our settings using jq (always available on sprites)
-TMP_FILE=$(mktemp)
-jq '. + {"claudeCode.allowDangerouslySkipPermissions": true, "claudeCode.initialPermissionMode": "bypassPermissions"}' "$SETTINGS_FILE" > "$TMP_FILE" && mv "$TMP_FILE" "$SETTINGS_FILE"
-`
+// machineSettingsFile is the VS Code server's Machine settings, relative to
+// the home directory on the sprite
+const machineSettingsFile = ".vscode-server/data/Machine/settings.json"
 
-	cmd := sprite.CommandContext(configCtx, "/bin/bash", "-c", script)
-	cmd.Stdout = nil
-	cmd.Stderr = nil
+// installClaudeCodeOnRemote downloads and installs the Claude Code extension on the sprite
+func installClaudeCodeOnRemote(ctx context.Context, sprite *sprites.Sprite) error {
+	return installRemoteExtension(ctx, sprite, "anthropic.claude-code")
+}
 
-	return cmd.Run()
+// InstallExtensions installs VS Code extensions into the sprite's VS Code server
+func (v *VSCode) InstallExtensions(ctx context.Context, sprite *sprites.Sprite, ids []string) error {
+	var failed []string
+	for _, id := range ids {
+		fmt.Printf("%s⏳%s Installing %s on remote...\n", ColorYellow, ColorReset, id)
+		if err := installRemoteExtension(ctx, sprite, id); err != nil {
+			fmt.Printf("%s⚠%s Failed to install %s: %v\n", ColorYellow, ColorReset, id, err)
+			failed = append(failed, id)
+			continue
+		}
+		fmt.Printf("%s✓%s %s installed\n", ColorGreen, ColorReset, id)
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to install %s", strings.Join(failed, ", "))
+	}
+	return nil
 }
 
-// installClaudeCodeOnRemote downloads and installs the Claude Code extension on the sprite
-func installClaudeCodeOnRemote(ctx context.Context, sprite *sprites.Sprite) error {
+// installRemoteExtension downloads and installs a VS Code extension (publisher.name) on the sprite
+func installRemoteExtension(ctx context.Context, sprite *sprites.Sprite, id string) error {
 	if sprite == nil {
 		return fmt.Errorf("sprite is nil")
 	}
-
-	installCtx, cancel := context.WithTimeout(ctx, 120*time.Second)
-	defer cancel()
+	publisher, extension, ok := strings.Cut(id, ".")
+	if !ok || publisher == "" || extension == "" {
+		return fmt.Errorf("invalid extension ID %q (expected publisher.name)", id)
+	}
 
 	// Download VSIX from VS Code marketplace and extract to extensions directory
 	// The VSIX is a zip file that needs to be extracted to ~/.vscode-server/extensions/
-	script := `
-set -e
-PUBLISHER="anthropic"
-EXTENSION="claude-code"
-EXT_DIR="$HOME/.vscode-server/extensions"
-
-# Create extensions directory if needed
-mkdir -p "$EXT_DIR"
-
-# Get latest version from marketplace API
-VERSION=$(curl -sf "https://marketplace.visualstudio.com/items?itemName=${PUBLISHER}.${EXTENSION}" | grep -oP '"version"\s*:\s*"\K[^"]+' | head -1)
-if [ -z "$VERSION" ]; then
-    # Fallback: try to get from Open VSX
-    VERSION=$(curl -sf "https://open-vsx.org/api/${PUBLISHER}/${EXTENSION}" | grep -oP '"version"\s*:\s*"\K[^"]+' | head -1)
-fi
-if [ -z "$VERSION" ]; then
-    echo "Could not determine extension version"
-    exit 1
-fi
-
-# Validate version format (semver-like: digits and dots only)
-if ! echo "$VERSION" | grep -qE '^[0-9]+\.[0-9]+\.[0-9]+(-[a-zA-Z0-9.]+)?$'; then
-    echo "Invalid version format: $VERSION"
-    exit 1
-fi
-
-echo "Installing ${PUBLISHER}.${EXTENSION} version ${VERSION}..."
-
-# Check if already installed
-if [ -d "$EXT_DIR/${PUBLISHER}.${EXTENSION}-${VERSION}" ]; then
-    echo "Already installed"
-    exit 0
-fi
-
-# Create temp directory with cleanup trap
-TMP_DIR=$(mktemp -d)
-trap "rm -rf '$TMP_DIR'" EXIT
-
-# Download VSIX from marketplace
-VSIX_URL="https://${PUBLISHER}.gallery.vsassets.io/_apis/public/gallery/publisher/${PUBLISHER}/extension/${EXTENSION}/${VERSION}/assetbyname/Microsoft.VisualStudio.Services.VSIXPackage"
-cd "$TMP_DIR"
-
-echo "Downloading from marketplace..."
-if ! curl -sfL "$VSIX_URL" -o extension.vsix; then
-    # Fallback to Open VSX
-    echo "Trying Open VSX..."
-    VSIX_URL="https://open-vsx.org/api/${PUBLISHER}/${EXTENSION}/${VERSION}/file/${PUBLISHER}.${EXTENSION}-${VERSION}.vsix"
-    curl -sfL "$VSIX_URL" -o extension.vsix
-fi
-
-# Extract VSIX (it's a zip file)
-unzip -q extension.vsix -d extracted
-
-# Move extension to VS Code extensions directory
-mv extracted/extension "$EXT_DIR/${PUBLISHER}.${EXTENSION}-${VERSION}"
-
-echo "Installed successfully"
-`
-
-	cmd := sprite.CommandContext(installCtx, "/bin/bash", "-c", script)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	// Pinned extensions are checked against their digest before extraction,
+	// and unpinned ones are refused unless --allow-unpinned
+	pin, err := requirePin(id)
+	if err != nil {
+		return err
+	}
 
-	return cmd.Run()
+	return runRemoteInstall(ctx, sprite, installExtensionScript, publisher, extension, pin.Version, pin.SHA256)
 }
 
 // promptInstallClaudeCode asks the user if they want to install Claude Code extension
@@ -594,7 +379,7 @@ func promptInstallClaudeCode() bool {
 }
 
 func (v *VSCode) Instructions(opts SetupOptions) string {
-	hostName := sshConfigHostName(opts.SpriteName)
+	hostName := sshconfig.HostName(opts.SpriteName)
 
 	binary := findVSCodeBinary()
 	if binary != "" {
@@ -605,10 +390,11 @@ func (v *VSCode) Instructions(opts SetupOptions) string {
 %sOpening:%s %s:%s
 
 If VS Code doesn't connect, try manually:
-  %scode --remote ssh-remote+%s %s%s
-`, ColorBold, ColorGreen, ColorReset,
-			ColorCyan, ColorReset, hostName, opts.RemotePath,
-			ColorYellow, hostName, opts.RemotePath, ColorReset)
+  %scode %s%s
+%s`, ColorBold, ColorGreen, ColorReset,
+			ColorCyan, ColorReset, hostName, opts.WorkspaceTarget(),
+			ColorYellow, displayArgs(vscodeArgs(opts)), ColorReset,
+			signingNote(opts, ""))
 	}
 
 	// VS Code not found - show manual instructions
@@ -619,7 +405,7 @@ If VS Code doesn't connect, try manually:
    %scode --install-extension %s%s
 
 2. Connect via command line:
-   %scode --remote ssh-remote+%s %s%s
+   %scode %s%s
 
    Or in VS Code:
    - Press Cmd+Shift+P (or Ctrl+Shift+P)
@@ -628,24 +414,71 @@ If VS Code doesn't connect, try manually:
 
 3. Install Claude Code on the remote (optional):
    Search for "Claude Code" in VS Code Extensions
-`, ColorBold, ColorGreen, ColorReset,
+%s`, ColorBold, ColorGreen, ColorReset,
 		ColorYellow, remoteSSHExtensionID, ColorReset,
-		ColorYellow, hostName, opts.RemotePath, ColorReset,
-		ColorYellow, hostName, ColorReset)
+		ColorYellow, displayArgs(vscodeArgs(opts)), ColorReset,
+		ColorYellow, hostName, ColorReset,
+		signingNote(opts, ""))
 }
 
 func (v *VSCode) Validate(ctx context.Context) error {
 	return nil
 }
 
+// Artifacts implements the Verifier interface for VS Code
+func (v *VSCode) Artifacts(opts SetupOptions) []Artifact {
+	configPath, _ := sshconfig.Path()
+	artifacts := []Artifact{
+		{
+			Name:     "SSH config entry",
+			Path:     configPath,
+			Local:    true,
+			Contains: sshconfig.StartMarker(opts.SpriteName),
+			Step:     "vscode.ssh_config",
+			Fix: func(ctx context.Context, opts SetupOptions) error {
+				txn := sshconfig.Begin()
+				txn.Add(v.SSHConfigEntry(opts))
+				_, err := txn.Commit()
+				return err
+			},
+		},
+		{
+			Name:     "VS Code server directory",
+			Path:     "~/.vscode-server",
+			Dir:      true,
+			Optional: true,
+			Step:     "vscode.server_owner",
+			Fix:      fixOwnership("~/.vscode-server"),
+			Hint:     "on the sprite, run: sudo chown -R $(id -un) ~/.vscode-server",
+		},
+	}
+	// Settings the policy denies aren't expected, so repair doesn't
+	// restore them
+	if opts.Policy.CheckClaudeBypassPermissions() != nil {
+		return artifacts
+	}
+	return append(artifacts, Artifact{
+		Name:     "Claude Code settings",
+		Path:     "~/.vscode-server/data/Machine/settings.json",
+		Contains: `"claudeCode.allowDangerouslySkipPermissions": true`,
+		Step:     "vscode.claude_settings",
+		Fix: func(ctx context.Context, opts SetupOptions) error {
+			_, err := configureClaudeCodeSettings(ctx, opts.Sprite)
+			return err
+		},
+	})
+}
+
 // Cleanup implements the Cleaner interface for VSCode
-func (v *VSCode) Cleanup(ctx context.Context, sprite *sprites.Sprite) error {
-	spriteName := sprite.Name()
+// ServerCaches returns the VS Code server binaries and remote extensions,
+// whose native modules are built against the image's libraries. User data
+// and settings are kept.
+func (v *VSCode) ServerCaches() []string {
+	return []string{".vscode-server/bin", ".vscode-server/cli", ".vscode-server/extensions"}
+}
 
-	// Remove SSH config entry
-	if err := removeSSHConfigEntry(spriteName); err != nil {
-		return fmt.Errorf("failed to remove SSH config entry: %w", err)
-	}
+func (v *VSCode) Cleanup(ctx context.Context, sprite *sprites.Sprite) error {
+	// The SSH config entry is removed by CleanupSprite
 
 	// Kill VS Code server processes on the sprite
 	cleanupCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
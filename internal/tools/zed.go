@@ -1,13 +1,16 @@
 package tools
 
 import (
+	"cmp"
 	"context"
 	"fmt"
+	"net/url"
 	"os"
 	"os/exec"
+	"strings"
 	"time"
 
-	"sprite-bootstrap/internal/sshserver"
+	"github.com/vaurdan/sprite-bootstrap/internal/sshserver"
 
 	"github.com/superfly/sprites-go"
 )
@@ -61,9 +64,9 @@ func findZedBinary() (string, bool) {
 	return "", false
 }
 
-// launchZed launches Zed with the given URL
-func launchZed(zedCmd string, useShell bool, url string) error {
-	cmd := buildZedCommand(zedCmd, useShell, url)
+// launchZed launches Zed with the given URL and extra arguments
+func launchZed(zedCmd string, useShell bool, args []string) error {
+	cmd := buildZedCommand(zedCmd, useShell, args)
 	if err := cmd.Start(); err != nil {
 		return err
 	}
@@ -74,7 +77,10 @@ func launchZed(zedCmd string, useShell bool, url string) error {
 func (z *Zed) Setup(ctx context.Context, opts SetupOptions) error {
 	// Clean up stale Zed state before connecting
 	// This prevents "starting proxy" hangs caused by stale Unix sockets
-	cleanupStaleZedState(ctx, opts)
+	traceStep(ctx, "zed.cleanup_state", func(ctx context.Context) error {
+		cleanupStaleZedState(ctx, opts)
+		return nil
+	})
 	return nil
 }
 
@@ -101,27 +107,37 @@ func cleanupStaleZedState(ctx context.Context, opts SetupOptions) {
 
 	// Remove stale server state (Unix sockets and PID files)
 	// Zed will recreate these on connect
-	cmd := sprite.CommandContext(cleanupCtx, "rm", "-rf",
-		"/home/sprite/.local/share/zed/server_state")
+	cmd := lockedCommand(cleanupCtx, sprite, `rm -rf "$1"`, "/home/sprite/.local/share/zed/server_state")
 	_ = cmd.Run() // Ignore errors
 
 	fmt.Printf("%s✓%s Cleaned stale Zed state\n", ColorGreen, ColorReset)
 }
 
+// zedAgentHint explains how to forward the agent from Zed, which connects
+// without our SSH config alias
+const zedAgentHint = `Zed needs "args": ["-A"] on this host in ssh_connections to forward the agent`
+
 func (z *Zed) Instructions(opts SetupOptions) string {
-	sshURL := fmt.Sprintf("ssh://%s@localhost:%d%s", opts.SpriteName, opts.LocalPort, opts.RemotePath)
+	// Zed opens a file named in the URL with its directory as the project
+	target := cmp.Or(opts.OpenFile, opts.RemotePath)
+	sshURL := fmt.Sprintf("ssh://%s@%s:%d%s", url.User(opts.SSHUser()), opts.ServeHost(), opts.LocalPort, target)
+	args := append([]string{sshURL}, opts.EditorArgs...)
+	var project string
+	if opts.OpenFile != "" {
+		project = fmt.Sprintf("%sProject:%s %s\n", ColorCyan, ColorReset, opts.RemotePath)
+	}
 
 	// Try to launch Zed
 	if zedCmd, useShell := findZedBinary(); zedCmd != "" {
-		if err := launchZed(zedCmd, useShell, sshURL); err == nil {
+		if err := launchZed(zedCmd, useShell, args); err == nil {
 			return fmt.Sprintf(`
 %s%s✓ Zed Remote Development Ready!%s
 
 %sOpening:%s %s
-
+%s
 If Zed doesn't open, connect manually:
   %szed %s%s
-`, ColorBold, ColorGreen, ColorReset, ColorCyan, ColorReset, sshURL, ColorYellow, sshURL, ColorReset)
+%s`, ColorBold, ColorGreen, ColorReset, ColorCyan, ColorReset, sshURL, project, ColorYellow, displayArgs(args), ColorReset, signingNote(opts, zedAgentHint))
 		}
 	}
 
@@ -129,17 +145,56 @@ If Zed doesn't open, connect manually:
 %s%s✓ Zed Remote Development Ready!%s
 
 %sConnect to:%s %s
-
+%s
 Or run:
   %szed %s%s
-`, ColorBold, ColorGreen, ColorReset, ColorCyan, ColorReset, sshURL, ColorYellow, sshURL, ColorReset)
+%s`, ColorBold, ColorGreen, ColorReset, ColorCyan, ColorReset, sshURL, project, ColorYellow, displayArgs(args), ColorReset, signingNote(opts, zedAgentHint))
+}
+
+// CheckEditorArgs implements the EditorLauncher interface for Zed
+func (z *Zed) CheckEditorArgs(args []string) error {
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "ssh://") {
+			return fmt.Errorf("editor arg %s conflicts with the remote workspace sprite-bootstrap opens", arg)
+		}
+	}
+	return nil
 }
 
 func (z *Zed) Validate(ctx context.Context) error {
 	return nil
 }
 
+// Artifacts implements the Verifier interface for Zed
+func (z *Zed) Artifacts(opts SetupOptions) []Artifact {
+	return []Artifact{
+		{
+			Name:     "Zed server binaries",
+			Path:     "~/.zed_server",
+			Dir:      true,
+			Optional: true,
+			Step:     "zed.server_owner",
+			Fix:      fixOwnership("~/.zed_server"),
+			Hint:     "on the sprite, run: sudo chown -R $(id -un) ~/.zed_server",
+		},
+		{
+			Name:     "Zed data directory",
+			Path:     "~/.local/share/zed",
+			Dir:      true,
+			Optional: true,
+			Step:     "zed.data_owner",
+			Fix:      fixOwnership("~/.local/share/zed"),
+			Hint:     "on the sprite, run: sudo chown -R $(id -un) ~/.local/share/zed",
+		},
+	}
+}
+
 // Cleanup implements the Cleaner interface for Zed
+// ServerCaches returns the Zed remote server binaries and state
+func (z *Zed) ServerCaches() []string {
+	return []string{".zed_server", ".local/share/zed/server_state"}
+}
+
 func (z *Zed) Cleanup(ctx context.Context, sprite *sprites.Sprite) error {
 	cleanupCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
@@ -148,14 +203,11 @@ func (z *Zed) Cleanup(ctx context.Context, sprite *sprites.Sprite) error {
 	cmd := sprite.CommandContext(cleanupCtx, "pkill", "-f", "zed-remote-server")
 	_ = cmd.Run() // Ignore errors - might not find any processes
 
-	// Clean up Zed server state directory (Unix sockets and PID files)
-	cmd = sprite.CommandContext(cleanupCtx, "rm", "-rf",
-		"/home/sprite/.local/share/zed/server_state")
-	_ = cmd.Run()
-
-	// Clean up old Zed server binaries in ~/.zed_server/
-	cmd = sprite.CommandContext(cleanupCtx, "bash", "-c",
-		`find ~/.zed_server -name 'zed-remote-server-*' -mtime +1 -delete 2>/dev/null || true`)
+	// Clean up Zed server state directory (Unix sockets and PID files) and
+	// old Zed server binaries in ~/.zed_server/
+	cmd = lockedCommand(cleanupCtx, sprite,
+		`rm -rf /home/sprite/.local/share/zed/server_state
+find ~/.zed_server -name 'zed-remote-server-*' -mtime +1 -delete 2>/dev/null || true`)
 	_ = cmd.Run()
 
 	return nil
@@ -3,8 +3,6 @@
 package tools
 
 import (
-	"fmt"
-	"os"
 	"os/exec"
 )
 
@@ -24,27 +22,17 @@ func findZedFallback() (string, bool) {
 	return "", false
 }
 
-// shellHasCommand checks if a command exists in the shell (including aliases)
+// shellHasCommand checks if a command exists in the user's shell
+// (including aliases and functions)
 func shellHasCommand(name string) bool {
-	shell := os.Getenv("SHELL")
-	if shell == "" {
-		shell = "/bin/sh"
-	}
-
-	// Use 'command -v' which works for aliases, functions, and binaries
-	cmd := exec.Command(shell, "-i", "-c", fmt.Sprintf("command -v %s", name))
-	return cmd.Run() == nil
+	return userShell().hasCommand(name)
 }
 
 // buildZedCommand builds the exec.Cmd to launch Zed
-func buildZedCommand(zedCmd string, useShell bool, url string) *exec.Cmd {
+func buildZedCommand(zedCmd string, useShell bool, args []string) *exec.Cmd {
 	if useShell {
-		shell := os.Getenv("SHELL")
-		if shell == "" {
-			shell = "/bin/sh"
-		}
-		// Use interactive shell to load aliases
-		return exec.Command(shell, "-i", "-c", fmt.Sprintf("%s %q", zedCmd, url))
+		// Use an interactive shell to load aliases; each arg is quoted for it
+		return userShell().command(zedCmd, args...)
 	}
-	return exec.Command(zedCmd, url)
+	return exec.Command(zedCmd, args...)
 }
@@ -93,7 +93,7 @@ func findZedFallback() (string, bool) {
 }
 
 // buildZedCommand builds the exec.Cmd to launch Zed on Windows
-func buildZedCommand(zedCmd string, useShell bool, url string) *exec.Cmd {
+func buildZedCommand(zedCmd string, useShell bool, args []string) *exec.Cmd {
 	// On Windows, we don't use shell aliases
-	return exec.Command(zedCmd, url)
+	return exec.Command(zedCmd, args...)
 }
@@ -0,0 +1,74 @@
+// Package ui contains helpers for interacting with the user's terminal and desktop.
+package ui
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"time"
+)
+
+// notifyTimeout bounds how long a desktop notification command may take
+var notifyTimeout = 5 * time.Second
+
+// IsInteractive reports whether stdout is a terminal
+func IsInteractive() bool {
+	info, err := os.Stdout.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// Notify rings the terminal bell and shows a desktop notification where the
+// platform supports it. Missing notification tools are silently ignored.
+func Notify(title, message string) {
+	fmt.Fprint(os.Stderr, "\a")
+
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+
+	cmd := notifyCommand(ctx, title, message)
+	if cmd == nil {
+		return
+	}
+	// Scripts read the text from the environment so it never needs quoting
+	cmd.Env = append(os.Environ(), "SB_NOTIFY_TITLE="+title, "SB_NOTIFY_MESSAGE="+message)
+	_ = cmd.Run()
+}
+
+// notifyCommand returns the platform's notification command, or nil
+func notifyCommand(ctx context.Context, title, message string) *exec.Cmd {
+	switch runtime.GOOS {
+	case "darwin":
+		if _, err := exec.LookPath("osascript"); err != nil {
+			return nil
+		}
+		return exec.CommandContext(ctx, "osascript", "-e",
+			`display notification (system attribute "SB_NOTIFY_MESSAGE") with title (system attribute "SB_NOTIFY_TITLE")`)
+	case "windows":
+		if _, err := exec.LookPath("powershell"); err != nil {
+			return nil
+		}
+		return exec.CommandContext(ctx, "powershell", "-NoProfile", "-NonInteractive", "-Command", windowsToastScript)
+	default:
+		if _, err := exec.LookPath("notify-send"); err != nil {
+			return nil
+		}
+		return exec.CommandContext(ctx, "notify-send", "--app-name=sprite-bootstrap", title, message)
+	}
+}
+
+// windowsToastScript shows a toast using the WinRT notification API, which
+// is available on Windows 10 and later without extra modules
+const windowsToastScript = `
+[Windows.UI.Notifications.ToastNotificationManager, Windows.UI.Notifications, ContentType = WindowsRuntime] > $null
+$xml = [Windows.UI.Notifications.ToastNotificationManager]::GetTemplateContent([Windows.UI.Notifications.ToastTemplateType]::ToastText02)
+$text = $xml.GetElementsByTagName('text')
+$text.Item(0).AppendChild($xml.CreateTextNode($env:SB_NOTIFY_TITLE)) > $null
+$text.Item(1).AppendChild($xml.CreateTextNode($env:SB_NOTIFY_MESSAGE)) > $null
+$toast = [Windows.UI.Notifications.ToastNotification]::new($xml)
+[Windows.UI.Notifications.ToastNotificationManager]::CreateToastNotifier('sprite-bootstrap').Show($toast)
+`
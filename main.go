@@ -1,9 +1,10 @@
 package main
 
 import (
+	"errors"
 	"os"
 
-	"sprite-bootstrap/cmd"
+	"github.com/vaurdan/sprite-bootstrap/cmd"
 )
 
 // version is set by the linker at build time
@@ -12,6 +13,10 @@ var version = "dev"
 func main() {
 	cmd.SetVersion(version)
 	if err := cmd.Execute(); err != nil {
+		var exit *cmd.ExitError
+		if errors.As(err, &exit) {
+			os.Exit(exit.Code)
+		}
 		os.Exit(1)
 	}
 }
@@ -0,0 +1,38 @@
+// Package config exposes sprite-bootstrap's state directory, user
+// preferences and setup profiles.
+//
+// See the pkg package documentation for the compatibility policy.
+package config
+
+import (
+	"github.com/vaurdan/sprite-bootstrap/internal/config"
+)
+
+// Preferences are the user's saved preferences.
+type Preferences = config.Preferences
+
+// Profile is a named setup bundle applied with --profile.
+type Profile = config.Profile
+
+// StateDir returns the directory where sprite-bootstrap keeps its state.
+func StateDir() string {
+	return config.StateDir()
+}
+
+// RuntimeDir returns the directory where sprite-bootstrap keeps
+// machine-specific runtime state. It is StateDir unless that is on a network
+// filesystem.
+func RuntimeDir() string {
+	return config.RuntimeDir()
+}
+
+// LoadPreferences loads the user's preferences, returning empty preferences
+// if none have been saved.
+func LoadPreferences() (*Preferences, error) {
+	return config.LoadPreferences()
+}
+
+// SavePreferences saves the user's preferences.
+func SavePreferences(prefs *Preferences) error {
+	return config.SavePreferences(prefs)
+}
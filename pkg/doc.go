@@ -0,0 +1,19 @@
+// Package pkg is the public Go API of sprite-bootstrap.
+//
+// Everything under internal/ is private to the CLI. The packages under pkg/
+// re-export the parts that other programs can build on:
+//
+//   - pkg/tools: the Tool interface, the tool registry and Bootstrap
+//   - pkg/sshserver: the local SSH server that proxies to sprites
+//   - pkg/config: the state directory, preferences and profiles
+//
+// # Compatibility
+//
+// Within a major version, exported identifiers in pkg/ are not removed or
+// changed incompatibly. New methods are only added to interfaces through new
+// optional interfaces (like tools.Cleaner), never to Tool itself. Struct
+// types may gain fields, so use keyed literals. Behaviour that only affects
+// terminal output (wording, colours) is not covered.
+//
+// Releases are tagged vMAJOR.MINOR.PATCH.
+package pkg
@@ -0,0 +1,79 @@
+package sshserver_test
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/vaurdan/sprite-bootstrap/pkg/sshserver"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func ExampleNewServer() {
+	// A real server keeps its key, see LoadOrGenerateHostKey
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		log.Fatal(err)
+	}
+	hostKey, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	srv, err := sshserver.NewServer(&sshserver.ServerConfig{
+		HostKey: hostKey,
+		TokenOptions: &sshserver.TokenOptions{
+			API:          "https://api.sprites.dev",
+			AuthToken:    os.Getenv("SPRITES_TOKEN"),
+			Organization: "my-org",
+		},
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx := context.Background()
+	l, err := sshserver.Bind(ctx, "127.0.0.1:0")
+	if err != nil {
+		log.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- srv.Serve(ctx, l) }()
+	fmt.Println("connections:", len(srv.Snapshot().Connections))
+
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Fatal(err)
+	}
+	<-done
+	// Output: connections: 0
+}
+
+func ExampleLoadAuthorizedKeys() {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		log.Fatal(err)
+	}
+	client, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	path := filepath.Join(os.TempDir(), "example_authorized_keys")
+	defer os.Remove(path)
+	if err := os.WriteFile(path, ssh.MarshalAuthorizedKey(client.PublicKey()), 0o600); err != nil {
+		log.Fatal(err)
+	}
+
+	// Set as ServerConfig.AuthorizedKeys, only these keys may connect
+	keys, err := sshserver.LoadAuthorizedKeys(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(keys.Len(), keys.Allows(client.PublicKey()))
+	// Output: 1 true
+}
@@ -0,0 +1,66 @@
+// Package sshserver exposes the local SSH server that proxies sessions,
+// exec requests and port forwards to sprites.
+//
+// See the pkg package documentation for the compatibility policy.
+package sshserver
+
+import (
+	"context"
+	"net"
+
+	"github.com/vaurdan/sprite-bootstrap/internal/sshserver"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Server is an SSH server that proxies connections to sprites.
+type Server = sshserver.Server
+
+// ServerConfig configures a Server.
+type ServerConfig = sshserver.ServerConfig
+
+// TokenOptions selects the sprites API credentials. Call Resolve to fill in
+// the token and API URL from the sprites CLI configuration.
+type TokenOptions = sshserver.TokenOptions
+
+// TLSOptions configures TLS to a self-hosted sprites API.
+type TLSOptions = sshserver.TLSOptions
+
+// NewServer creates a server from cfg. cfg.HostKey must be set.
+func NewServer(cfg *ServerConfig) (*Server, error) {
+	return sshserver.NewServer(cfg)
+}
+
+// Bind creates a TCP listener on addr.
+func Bind(ctx context.Context, addr string) (net.Listener, error) {
+	return sshserver.Bind(ctx, addr)
+}
+
+// LoadOrGenerateHostKey loads the host key at path, generating one if it
+// doesn't exist. An empty path uses the default key in ~/.ssh.
+func LoadOrGenerateHostKey(path string) (ssh.Signer, error) {
+	return sshserver.LoadOrGenerateHostKey(path)
+}
+
+// LoadHostKey loads an Ed25519, ECDSA or RSA host key, for
+// ServerConfig.MoreHostKeys and ExtraHostKeys.
+func LoadHostKey(path string) (ssh.Signer, error) {
+	return sshserver.LoadHostKey(path)
+}
+
+// DefaultHostKeyPath returns the default host key location.
+func DefaultHostKeyPath() (string, error) {
+	return sshserver.DefaultHostKeyPath()
+}
+
+// AuthorizedKeys is a set of client keys allowed to connect.
+type AuthorizedKeys = sshserver.AuthorizedKeys
+
+// LoadAuthorizedKeys reads an OpenSSH authorized_keys file.
+func LoadAuthorizedKeys(path string) (*AuthorizedKeys, error) {
+	return sshserver.LoadAuthorizedKeys(path)
+}
+
+// Snapshot is a point-in-time view of a server's connections, pending
+// authentications, goroutines and memory, as returned by Server.Snapshot.
+type Snapshot = sshserver.Snapshot
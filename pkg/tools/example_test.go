@@ -0,0 +1,54 @@
+package tools_test
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/vaurdan/sprite-bootstrap/pkg/tools"
+)
+
+// notesTool is a Tool that needs nothing installed locally
+type notesTool struct{}
+
+func (notesTool) Name() string                       { return "notes" }
+func (notesTool) Description() string                { return "Keep notes on the sprite" }
+func (notesTool) Validate(ctx context.Context) error { return nil }
+
+func (notesTool) Setup(ctx context.Context, opts tools.SetupOptions) error {
+	// opts.Sprite runs commands on the sprite once Bootstrap has woken it
+	return nil
+}
+
+func (notesTool) Instructions(opts tools.SetupOptions) string {
+	return fmt.Sprintf("ssh -p %d %s@%s", opts.LocalPort, opts.SSHUser(), opts.ServeHost())
+}
+
+func ExampleRegister() {
+	tools.Register(notesTool{})
+
+	tool, ok := tools.Get("notes")
+	fmt.Println(ok, tool.Name()+":", tool.Description())
+	// Output: true notes: Keep notes on the sprite
+}
+
+func ExampleNewSetupOptions() {
+	opts := tools.NewSetupOptions("mysprite", "my-org", 2222, "/home/sprite/app")
+	fmt.Println(opts.SpriteName, opts.LocalPort, opts.RemotePath)
+	fmt.Println(notesTool{}.Instructions(opts))
+	// Output:
+	// mysprite 2222 /home/sprite/app
+	// ssh -p 2222 mysprite@my-org@localhost
+}
+
+func ExampleBootstrap() {
+	// The SSH server runs as "<binary> serve"
+	tools.SetServeBinary("/usr/local/bin/sprite-bootstrap")
+	tools.Register(notesTool{})
+
+	tool, _ := tools.Get("notes")
+	opts := tools.NewSetupOptions("mysprite", "", 2222, "/home/sprite")
+	if err := tools.Bootstrap(context.Background(), tool, opts); err != nil {
+		log.Fatal(err)
+	}
+}
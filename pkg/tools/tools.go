@@ -0,0 +1,117 @@
+// Package tools exposes sprite-bootstrap's IDE tool registry and bootstrap
+// orchestration for use by other programs.
+//
+// Register a Tool, then run Bootstrap to wake the sprite, start the local SSH
+// server, verify the connection and run the tool's setup:
+//
+//	tools.Register(&MyTool{})
+//	tool, _ := tools.Get("mytool")
+//	opts := tools.NewSetupOptions("mysprite", "", 2222, "/home/sprite")
+//	err := tools.Bootstrap(ctx, tool, opts)
+//
+// See the pkg package documentation for the compatibility policy.
+package tools
+
+import (
+	"context"
+
+	"github.com/vaurdan/sprite-bootstrap/internal/tools"
+)
+
+// Tool is an IDE integration. Name must be unique among registered tools.
+type Tool = tools.Tool
+
+// Cleaner is implemented by tools that remove state from the sprite on stop.
+type Cleaner = tools.Cleaner
+
+// ExtensionInstaller is implemented by tools that can install editor
+// extensions on the sprite.
+type ExtensionInstaller = tools.ExtensionInstaller
+
+// Verifier is implemented by tools that describe the state their setup
+// leaves behind, enabling the repair command.
+type Verifier = tools.Verifier
+
+// Artifact is a file or directory a tool's setup is expected to leave behind.
+type Artifact = tools.Artifact
+
+// SetupOptions configures a single bootstrap run.
+type SetupOptions = tools.SetupOptions
+
+// Summary describes what a bootstrap set up, how to reconnect and how to
+// clean up.
+type Summary = tools.Summary
+
+// Register adds a tool to the registry, replacing any tool with the same name.
+func Register(tool Tool) {
+	tools.Register(tool)
+}
+
+// Get returns the registered tool with the given name.
+func Get(name string) (Tool, bool) {
+	return tools.Get(name)
+}
+
+// All returns every registered tool, keyed by name. The map must not be modified.
+func All() map[string]Tool {
+	return tools.All()
+}
+
+// Names returns the names of all registered tools in no particular order.
+func Names() []string {
+	return tools.Names()
+}
+
+// NewSetupOptions creates SetupOptions for a sprite. remotePath must be absolute.
+func NewSetupOptions(spriteName, orgName string, localPort int, remotePath string) SetupOptions {
+	return tools.NewSetupOptions(spriteName, orgName, localPort, remotePath)
+}
+
+// Bootstrap wakes the sprite, makes sure the local SSH server is running,
+// tests the connection, runs the tool's setup and prints its instructions,
+// followed by the summary unless opts.Quiet is set.
+func Bootstrap(ctx context.Context, tool Tool, opts SetupOptions) error {
+	return tools.Bootstrap(ctx, tool, opts)
+}
+
+// BootstrapSummary is Bootstrap returning the summary instead of printing it.
+func BootstrapSummary(ctx context.Context, tool Tool, opts SetupOptions) (*Summary, error) {
+	return tools.BootstrapSummary(ctx, tool, opts)
+}
+
+// CleanupSprite runs Cleanup on the sprite for every registered Cleaner.
+func CleanupSprite(ctx context.Context, spriteName, orgName string) error {
+	return tools.CleanupSprite(ctx, spriteName, orgName)
+}
+
+// CleanupOptions selects what CleanupSpriteWith removes.
+type CleanupOptions = tools.CleanupOptions
+
+// CleanupSpriteWith is CleanupSprite limited to one tool or to local
+// artifacts, as opts selects.
+func CleanupSpriteWith(ctx context.Context, spriteName, orgName string, opts CleanupOptions) error {
+	return tools.CleanupSpriteWith(ctx, spriteName, orgName, opts)
+}
+
+// IsServeRunning reports whether the background SSH server is running.
+func IsServeRunning() bool {
+	return tools.IsServeRunning()
+}
+
+// SetServeBinary sets the sprite-bootstrap binary used to start the
+// background SSH server. Programs embedding this package don't have a serve
+// command of their own, so they must call this before Bootstrap or StartServe.
+func SetServeBinary(path string) {
+	tools.ServeBinary = path
+}
+
+// StartServe starts the background SSH server on the given port. A
+// non-empty orgName limits it to that organization's sprites.
+func StartServe(port int, orgName string) error {
+	return tools.StartServe(port, orgName)
+}
+
+// StopServe stops the background SSH server if it's running.
+func StopServe() error {
+	return tools.StopServe()
+}